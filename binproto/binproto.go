@@ -0,0 +1,115 @@
+// Package binproto implements the length-prefixed binary protocol served by
+// lru-cache's -binary-listen listener.
+//
+// Every request and response is a 12-byte header followed by the key and the
+// value:
+//
+//	byte  0     opcode (requests) or status (responses)
+//	byte  1     reserved, must be zero
+//	bytes 2-3   key length, big endian
+//	bytes 4-7   value length, big endian
+//	bytes 8-11  TTL in seconds, big endian; zero never expires
+//
+// Responses are sent in request order, so clients may pipeline. A response to
+// Get carries the value and its remaining TTL; error responses carry a
+// human-readable message as the value.
+package binproto
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+)
+
+// HeaderSize is the length of the fixed frame header
+const HeaderSize = 12
+
+// MaxKeySize is the longest key a frame can carry
+const MaxKeySize = 1<<16 - 1
+
+// Request opcodes
+const (
+    OpGet    byte = 0x01
+    OpSet    byte = 0x02
+    OpDelete byte = 0x03
+    OpTouch  byte = 0x04
+    OpPing   byte = 0x05
+    OpAuth   byte = 0x06 // Value is an API key or JWT
+    OpQuit   byte = 0x07
+)
+
+// Response statuses
+const (
+    StatusOK           byte = 0x00
+    StatusNotFound     byte = 0x01
+    StatusBadRequest   byte = 0x02
+    StatusTooLarge     byte = 0x03
+    StatusUnauthorized byte = 0x04
+    StatusForbidden    byte = 0x05
+    StatusError        byte = 0x06
+    StatusNotStored    byte = 0x07 // The cache declined the write, as its doorkeeper does the first of a new key
+)
+
+// ErrFrameTooLarge is returned by ReadFrame when a value exceeds the caller's limit
+var ErrFrameTooLarge = errors.New("binproto: frame too large")
+
+// Frame is a decoded request or response
+type Frame struct {
+    Op    byte // Opcode for requests, status for responses
+    TTL   uint32
+    Key   []byte
+    Value []byte
+}
+
+// ReadFrame reads one frame, rejecting values longer than maxValue bytes
+func ReadFrame(r io.Reader, maxValue int) (Frame, error) {
+    var h [HeaderSize]byte
+    if _, err := io.ReadFull(r, h[:]); err != nil {
+        return Frame{}, err
+    }
+    if h[1] != 0 {
+        return Frame{}, fmt.Errorf("binproto: reserved header byte is %#x", h[1])
+    }
+    keyLen := int(binary.BigEndian.Uint16(h[2:4]))
+    valueLen := binary.BigEndian.Uint32(h[4:8])
+    if uint64(valueLen) > uint64(maxValue) {
+        return Frame{}, ErrFrameTooLarge
+    }
+    body := make([]byte, keyLen+int(valueLen))
+    if _, err := io.ReadFull(r, body); err != nil {
+        if errors.Is(err, io.EOF) {
+            err = io.ErrUnexpectedEOF
+        }
+        return Frame{}, err
+    }
+    return Frame{
+        Op:    h[0],
+        TTL:   binary.BigEndian.Uint32(h[8:12]),
+        Key:   body[:keyLen:keyLen],
+        Value: body[keyLen:],
+    }, nil
+}
+
+// WriteFrame writes f; it fails without writing anything if the key is too long
+func WriteFrame(w io.Writer, f Frame) error {
+    if len(f.Key) > MaxKeySize {
+        return fmt.Errorf("binproto: key of %d bytes exceeds %d", len(f.Key), MaxKeySize)
+    }
+    if uint64(len(f.Value)) > 1<<32-1 {
+        return ErrFrameTooLarge
+    }
+    var h [HeaderSize]byte
+    h[0] = f.Op
+    binary.BigEndian.PutUint16(h[2:4], uint16(len(f.Key)))
+    binary.BigEndian.PutUint32(h[4:8], uint32(len(f.Value)))
+    binary.BigEndian.PutUint32(h[8:12], f.TTL)
+    if _, err := w.Write(h[:]); err != nil {
+        return err
+    }
+    if _, err := w.Write(f.Key); err != nil {
+        return err
+    }
+    _, err := w.Write(f.Value)
+    return err
+}
@@ -0,0 +1,83 @@
+package binproto
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "strings"
+    "testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+    tests := []struct {
+        name  string
+        frame Frame
+    }{
+        {"get", Frame{Op: OpGet, Key: []byte("k"), Value: []byte{}}},
+        {"set with ttl", Frame{Op: OpSet, TTL: 60, Key: []byte("k"), Value: []byte("value")}},
+        {"set never expiring", Frame{Op: OpSet, TTL: 0, Key: []byte("k"), Value: []byte("value")}},
+        {"empty key", Frame{Op: StatusOK, Key: []byte{}, Value: []byte("v")}},
+        {"longest key", Frame{Op: OpDelete, Key: bytes.Repeat([]byte("k"), MaxKeySize), Value: []byte{}}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var b bytes.Buffer
+            if err := WriteFrame(&b, tt.frame); err != nil {
+                t.Fatal(err)
+            }
+            if b.Len() != HeaderSize+len(tt.frame.Key)+len(tt.frame.Value) {
+                t.Fatalf("frame of %d bytes, want %d", b.Len(), HeaderSize+len(tt.frame.Key)+len(tt.frame.Value))
+            }
+            got, err := ReadFrame(&b, 16)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got.Op != tt.frame.Op || got.TTL != tt.frame.TTL || !bytes.Equal(got.Key, tt.frame.Key) || !bytes.Equal(got.Value, tt.frame.Value) {
+                t.Fatalf("read %+v, want %+v", got, tt.frame)
+            }
+        })
+    }
+}
+
+func TestReadFrameHeader(t *testing.T) {
+    // A set of key "k" and value "v" with a TTL of 258 seconds
+    frame := []byte{OpSet, 0, 0, 1, 0, 0, 0, 1, 0, 0, 1, 2, 'k', 'v'}
+    f, err := ReadFrame(bytes.NewReader(frame), 16)
+    if err != nil || f.TTL != 258 || string(f.Key) != "k" || string(f.Value) != "v" {
+        t.Fatalf("ReadFrame = %+v, %v", f, err)
+    }
+}
+
+func TestReadFrameErrors(t *testing.T) {
+    header := func(reserved byte, keyLen, valueLen byte) []byte {
+        return []byte{OpSet, reserved, 0, keyLen, 0, 0, 0, valueLen, 0, 0, 0, 0}
+    }
+    tests := []struct {
+        name    string
+        input   []byte
+        wantErr error // nil for any error
+    }{
+        {"empty", nil, io.EOF},
+        {"short header", header(0, 1, 1)[:5], io.ErrUnexpectedEOF},
+        {"reserved byte", append(header(1, 1, 1), 'k', 'v'), nil},
+        {"value too large", append(header(0, 1, 17), 'k'), ErrFrameTooLarge},
+        {"short body", append(header(0, 1, 2), 'k', 'v'), io.ErrUnexpectedEOF},
+        {"missing body", header(0, 1, 1), io.ErrUnexpectedEOF},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := ReadFrame(bytes.NewReader(tt.input), 16)
+            if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+                t.Fatalf("ReadFrame = %v, want %v", err, tt.wantErr)
+            }
+        })
+    }
+}
+
+func TestWriteFrameKeyTooLong(t *testing.T) {
+    var b bytes.Buffer
+    err := WriteFrame(&b, Frame{Op: OpSet, Key: []byte(strings.Repeat("k", MaxKeySize+1))})
+    if err == nil || b.Len() != 0 {
+        t.Fatalf("WriteFrame = %v after writing %d bytes, want an error and nothing written", err, b.Len())
+    }
+}
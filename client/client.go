@@ -0,0 +1,138 @@
+// Package client is a reference Go client for lru-cache, over its binary
+// protocol (Client and Cluster) or its HTTP API (HTTPClient).
+package client
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "net"
+    "strings"
+    "sync"
+    "time"
+
+    "lru-cache/binproto"
+)
+
+var (
+    // ErrNotFound is returned for keys that are missing or expired
+    ErrNotFound = errors.New("client: key not found")
+    // ErrNotStored is returned by writes the server declined to cache, as
+    // its doorkeeper does the first write of a new key
+    ErrNotStored = errors.New("client: value not stored")
+)
+
+// maxResponseValue bounds the values the client will accept from a server
+const maxResponseValue = 64 << 20
+
+// ServerError is a non-OK status returned by the server
+type ServerError struct {
+    Status  byte
+    Message string
+}
+
+func (e *ServerError) Error() string {
+    return fmt.Sprintf("client: server returned status %#x: %s", e.Status, e.Message)
+}
+
+// Client is a single connection to the binary listener; it is safe for
+// concurrent use, with requests serialized over the connection
+type Client struct {
+    mutex sync.Mutex
+    conn  net.Conn
+    r     *bufio.Reader
+    w     *bufio.Writer
+}
+
+// Dial connects to addr, which may be host:port or unix:///path
+func Dial(addr string) (*Client, error) {
+    network := "tcp"
+    if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+        network, addr = "unix", path
+    }
+    conn, err := net.DialTimeout(network, addr, 5*time.Second)
+    if err != nil {
+        return nil, err
+    }
+    return &Client{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+// Close sends Quit and closes the connection
+func (c *Client) Close() error {
+    c.do(binproto.Frame{Op: binproto.OpQuit})
+    return c.conn.Close()
+}
+
+// Auth authenticates the connection with an API key or JWT
+func (c *Client) Auth(token string) error {
+    _, err := c.do(binproto.Frame{Op: binproto.OpAuth, Value: []byte(token)})
+    return err
+}
+
+// Ping checks that the server is responding
+func (c *Client) Ping() error {
+    _, err := c.do(binproto.Frame{Op: binproto.OpPing})
+    return err
+}
+
+// Get returns the value of key and its remaining TTL, zero meaning no expiry
+func (c *Client) Get(key string) ([]byte, time.Duration, error) {
+    resp, err := c.do(binproto.Frame{Op: binproto.OpGet, Key: []byte(key)})
+    if err != nil {
+        return nil, 0, err
+    }
+    return resp.Value, time.Duration(resp.TTL) * time.Second, nil
+}
+
+// Set stores value under key; a zero ttl never expires
+func (c *Client) Set(key string, value []byte, ttl time.Duration) error {
+    _, err := c.do(binproto.Frame{Op: binproto.OpSet, Key: []byte(key), Value: value, TTL: seconds(ttl)})
+    return err
+}
+
+// Delete removes key, returning ErrNotFound if it did not exist
+func (c *Client) Delete(key string) error {
+    _, err := c.do(binproto.Frame{Op: binproto.OpDelete, Key: []byte(key)})
+    return err
+}
+
+// Touch updates the TTL of an existing key
+func (c *Client) Touch(key string, ttl time.Duration) error {
+    _, err := c.do(binproto.Frame{Op: binproto.OpTouch, Key: []byte(key), TTL: seconds(ttl)})
+    return err
+}
+
+// seconds rounds a TTL up to whole seconds so short TTLs do not become "never expires"
+func seconds(ttl time.Duration) uint32 {
+    if ttl <= 0 {
+        return 0
+    }
+    return uint32((ttl + time.Second - 1) / time.Second)
+}
+
+// do sends one request and reads its response
+func (c *Client) do(req binproto.Frame) (binproto.Frame, error) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    if err := binproto.WriteFrame(c.w, req); err != nil {
+        return binproto.Frame{}, err
+    }
+    if err := c.w.Flush(); err != nil {
+        return binproto.Frame{}, err
+    }
+    resp, err := binproto.ReadFrame(c.r, maxResponseValue)
+    if err != nil {
+        return binproto.Frame{}, err
+    }
+    switch resp.Op {
+    case binproto.StatusOK:
+        return resp, nil
+    case binproto.StatusNotFound:
+        return resp, ErrNotFound
+    case binproto.StatusNotStored:
+        return resp, ErrNotStored
+    default:
+        return resp, &ServerError{Status: resp.Op, Message: string(resp.Value)}
+    }
+}
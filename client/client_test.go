@@ -0,0 +1,102 @@
+package client
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "net"
+    "testing"
+    "time"
+
+    "lru-cache/binproto"
+)
+
+// pipeClient returns a Client connected over an in-memory connection to a
+// server that answers every request with reply and sends the requests it
+// read on the returned channel
+func pipeClient(t *testing.T, reply binproto.Frame) (*Client, <-chan binproto.Frame) {
+    t.Helper()
+    conn, server := net.Pipe()
+    requests := make(chan binproto.Frame, 1)
+    go func() {
+        defer server.Close()
+        r := bufio.NewReader(server)
+        for {
+            req, err := binproto.ReadFrame(r, 1024)
+            if err != nil {
+                return
+            }
+            requests <- req
+            // One write per frame, since the pipe blocks on the empty write
+            // of an empty value
+            var b bytes.Buffer
+            binproto.WriteFrame(&b, reply)
+            if _, err := server.Write(b.Bytes()); err != nil {
+                return
+            }
+        }
+    }()
+    t.Cleanup(func() { conn.Close() })
+    return &Client{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, requests
+}
+
+func TestClientRequests(t *testing.T) {
+    tests := []struct {
+        name string
+        call func(c *Client) error
+        want binproto.Frame
+    }{
+        {"set never expiring", func(c *Client) error { return c.Set("k", []byte("v"), 0) }, binproto.Frame{Op: binproto.OpSet, Key: []byte("k"), Value: []byte("v")}},
+        {"set rounds up", func(c *Client) error { return c.Set("k", []byte("v"), 1500*time.Millisecond) }, binproto.Frame{Op: binproto.OpSet, TTL: 2, Key: []byte("k"), Value: []byte("v")}},
+        {"touch", func(c *Client) error { return c.Touch("k", time.Minute) }, binproto.Frame{Op: binproto.OpTouch, TTL: 60, Key: []byte("k")}},
+        {"delete", func(c *Client) error { return c.Delete("k") }, binproto.Frame{Op: binproto.OpDelete, Key: []byte("k")}},
+        {"auth", func(c *Client) error { return c.Auth("token") }, binproto.Frame{Op: binproto.OpAuth, Value: []byte("token")}},
+        {"ping", func(c *Client) error { return c.Ping() }, binproto.Frame{Op: binproto.OpPing}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c, requests := pipeClient(t, binproto.Frame{Op: binproto.StatusOK})
+            if err := tt.call(c); err != nil {
+                t.Fatal(err)
+            }
+            got := <-requests
+            if got.Op != tt.want.Op || got.TTL != tt.want.TTL || !bytes.Equal(got.Key, tt.want.Key) || !bytes.Equal(got.Value, tt.want.Value) {
+                t.Fatalf("sent %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestClientResponses(t *testing.T) {
+    tests := []struct {
+        name       string
+        reply      binproto.Frame
+        wantValue  string
+        wantTTL    time.Duration
+        wantErr    error
+        wantStatus byte // Of the *ServerError wanted instead of wantErr
+    }{
+        {"hit", binproto.Frame{Op: binproto.StatusOK, TTL: 30, Value: []byte("v")}, "v", 30 * time.Second, nil, 0},
+        {"hit never expiring", binproto.Frame{Op: binproto.StatusOK, Value: []byte("v")}, "v", 0, nil, 0},
+        {"miss", binproto.Frame{Op: binproto.StatusNotFound}, "", 0, ErrNotFound, 0},
+        {"not stored", binproto.Frame{Op: binproto.StatusNotStored}, "", 0, ErrNotStored, 0},
+        {"error", binproto.Frame{Op: binproto.StatusForbidden, Value: []byte("read-only node")}, "", 0, nil, binproto.StatusForbidden},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c, _ := pipeClient(t, tt.reply)
+            value, ttl, err := c.Get("k")
+            var serverErr *ServerError
+            switch {
+            case tt.wantStatus != 0:
+                if !errors.As(err, &serverErr) || serverErr.Status != tt.wantStatus || serverErr.Message != string(tt.reply.Value) {
+                    t.Fatalf("Get = %v, want a server error with status %#x", err, tt.wantStatus)
+                }
+            case !errors.Is(err, tt.wantErr):
+                t.Fatalf("Get = %v, want %v", err, tt.wantErr)
+            case string(value) != tt.wantValue || ttl != tt.wantTTL:
+                t.Fatalf("Get = %q, %v; want %q, %v", value, ttl, tt.wantValue, tt.wantTTL)
+            }
+        })
+    }
+}
@@ -0,0 +1,213 @@
+package client
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+)
+
+// ErrNoNodes is returned when a Cluster has no servers to send a key to
+var ErrNoNodes = errors.New("client: cluster has no nodes")
+
+// Cluster spreads keys over several servers with a consistent hash ring, so
+// the cache scales horizontally without a proxy; it is safe for concurrent use
+type Cluster struct {
+    token   string
+    mutex   sync.RWMutex
+    ring    *Ring
+    clients map[string]*Client
+}
+
+// DialCluster connects to every addr, authenticating each connection with
+// token unless it is empty
+func DialCluster(addrs []string, token string) (*Cluster, error) {
+    c := &Cluster{token: token, ring: NewRing(DefaultReplicas), clients: make(map[string]*Client)}
+    for _, addr := range addrs {
+        if err := c.AddNode(addr); err != nil {
+            c.Close()
+            return nil, err
+        }
+    }
+    return c, nil
+}
+
+// DiscoverCluster connects to the nodes r finds; call Watch to follow
+// later changes
+func DiscoverCluster(ctx context.Context, r Resolver, token string) (*Cluster, error) {
+    c := &Cluster{token: token, ring: NewRing(DefaultReplicas), clients: make(map[string]*Client)}
+    if err := c.Refresh(ctx, r); err != nil && len(c.Nodes()) == 0 {
+        return nil, err
+    }
+    return c, nil
+}
+
+// Refresh adds the nodes r finds and removes those it no longer does. An
+// empty or failed lookup changes nothing, so a registry outage does not
+// empty the ring; nodes that cannot be reached are skipped and reported.
+func (c *Cluster) Refresh(ctx context.Context, r Resolver) error {
+    addrs, err := r.Resolve(ctx)
+    if err != nil {
+        return fmt.Errorf("client: discovery: %w", err)
+    }
+    if len(addrs) == 0 {
+        return errors.New("client: discovery found no nodes")
+    }
+    found := make(map[string]bool, len(addrs))
+    var errs []error
+    for _, addr := range addrs {
+        if err := c.AddNode(addr); err != nil {
+            errs = append(errs, err)
+            continue
+        }
+        found[addr] = true
+    }
+    for _, addr := range c.Nodes() {
+        if !found[addr] && len(found) > 0 {
+            c.RemoveNode(addr)
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// Watch calls Refresh every interval until ctx is done, passing its errors
+// to onError unless it is nil
+func (c *Cluster) Watch(ctx context.Context, r Resolver, interval time.Duration, onError func(error)) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+        if err := c.Refresh(ctx, r); err != nil && onError != nil {
+            onError(err)
+        }
+    }
+}
+
+// AddNode connects to addr and moves the keys it now owns to it; those keys
+// are not copied, so they read as missing until set again
+func (c *Cluster) AddNode(addr string) error {
+    c.mutex.RLock()
+    _, exists := c.clients[addr]
+    c.mutex.RUnlock()
+    if exists {
+        return nil
+    }
+
+    client, err := Dial(addr)
+    if err != nil {
+        return fmt.Errorf("client: dial %s: %w", addr, err)
+    }
+    if c.token != "" {
+        if err := client.Auth(c.token); err != nil {
+            client.Close()
+            return fmt.Errorf("client: auth %s: %w", addr, err)
+        }
+    }
+
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    if _, exists := c.clients[addr]; exists {
+        client.Close() // Another caller added it meanwhile
+        return nil
+    }
+    c.clients[addr] = client
+    c.ring.Add(addr)
+    return nil
+}
+
+// RemoveNode stops sending keys to addr and closes its connection
+func (c *Cluster) RemoveNode(addr string) error {
+    c.mutex.Lock()
+    client, ok := c.clients[addr]
+    if ok {
+        delete(c.clients, addr)
+        c.ring.Remove(addr)
+    }
+    c.mutex.Unlock()
+    if !ok {
+        return nil
+    }
+    return client.Close()
+}
+
+// Nodes returns the addresses of the servers in the cluster
+func (c *Cluster) Nodes() []string {
+    c.mutex.RLock()
+    defer c.mutex.RUnlock()
+    return c.ring.Nodes()
+}
+
+// NodeFor returns the address of the server that owns key, or "" if there are none
+func (c *Cluster) NodeFor(key string) string {
+    c.mutex.RLock()
+    defer c.mutex.RUnlock()
+    return c.ring.Node(key)
+}
+
+// client returns the connection to the server that owns key
+func (c *Cluster) client(key string) (*Client, error) {
+    c.mutex.RLock()
+    defer c.mutex.RUnlock()
+    client, ok := c.clients[c.ring.Node(key)]
+    if !ok {
+        return nil, ErrNoNodes
+    }
+    return client, nil
+}
+
+// Get returns the value of key and its remaining TTL from the server owning it
+func (c *Cluster) Get(key string) ([]byte, time.Duration, error) {
+    client, err := c.client(key)
+    if err != nil {
+        return nil, 0, err
+    }
+    return client.Get(key)
+}
+
+// Set stores value under key on the server owning it
+func (c *Cluster) Set(key string, value []byte, ttl time.Duration) error {
+    client, err := c.client(key)
+    if err != nil {
+        return err
+    }
+    return client.Set(key, value, ttl)
+}
+
+// Delete removes key from the server owning it
+func (c *Cluster) Delete(key string) error {
+    client, err := c.client(key)
+    if err != nil {
+        return err
+    }
+    return client.Delete(key)
+}
+
+// Touch updates the TTL of key on the server owning it
+func (c *Cluster) Touch(key string, ttl time.Duration) error {
+    client, err := c.client(key)
+    if err != nil {
+        return err
+    }
+    return client.Touch(key, ttl)
+}
+
+// Close closes every connection
+func (c *Cluster) Close() error {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    var errs []error
+    for addr, client := range c.clients {
+        if err := client.Close(); err != nil {
+            errs = append(errs, err)
+        }
+        delete(c.clients, addr)
+        c.ring.Remove(addr)
+    }
+    return errors.Join(errs...)
+}
@@ -0,0 +1,240 @@
+package client
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "net/url"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Resolver finds the addresses of cache nodes in a service registry
+type Resolver interface {
+    Resolve(ctx context.Context) ([]string, error)
+}
+
+// ParseResolver returns the Resolver for target, one of
+//
+//	dns://name:port              every A and AAAA address of name, with port
+//	dns+srv://_service._tcp.name the targets and ports of name's SRV records
+//	consul://[token@]host:8500/service[?tag=t&dc=d]
+//	                             healthy instances of service in Consul's catalog
+//	etcd://host:2379/prefix      the values of keys under prefix in etcd
+//
+// Consul and etcd are reached over HTTP, or HTTPS with consul+https:// and
+// etcd+https://; the etcd resolver uses its v3 JSON gateway.
+func ParseResolver(target string) (Resolver, error) {
+    u, err := url.Parse(target)
+    if err != nil {
+        return nil, fmt.Errorf("client: discovery target: %w", err)
+    }
+    switch u.Scheme {
+    case "dns":
+        if _, _, err := net.SplitHostPort(u.Host); err != nil {
+            return nil, fmt.Errorf("client: dns:// target needs a port: %w", err)
+        }
+        return &DNSResolver{Addr: u.Host}, nil
+    case "dns+srv":
+        if u.Host == "" {
+            return nil, errors.New("client: dns+srv:// target needs a name")
+        }
+        return &SRVResolver{Name: u.Host}, nil
+    case "consul", "consul+https":
+        service := strings.Trim(u.Path, "/")
+        if u.Host == "" || service == "" {
+            return nil, errors.New("client: consul:// target needs a host and a service")
+        }
+        r := &ConsulResolver{URL: registryURL(u), Service: service, Tag: u.Query().Get("tag"), Datacenter: u.Query().Get("dc")}
+        if u.User != nil {
+            r.Token = u.User.Username()
+        }
+        return r, nil
+    case "etcd", "etcd+https":
+        if u.Host == "" || u.Path == "" {
+            return nil, errors.New("client: etcd:// target needs a host and a key prefix")
+        }
+        return &EtcdResolver{URL: registryURL(u), Prefix: u.Path}, nil
+    }
+    return nil, fmt.Errorf("client: unknown discovery scheme %q", u.Scheme)
+}
+
+// registryURL is the HTTP base URL of the registry a target names
+func registryURL(u *url.URL) string {
+    scheme := "http"
+    if strings.HasSuffix(u.Scheme, "+https") {
+        scheme = "https"
+    }
+    return scheme + "://" + u.Host
+}
+
+// registryClient bounds registry requests that the caller's context does not
+var registryClient = &http.Client{Timeout: 10 * time.Second}
+
+// sorted removes duplicates from addrs and sorts them
+func sorted(addrs []string) []string {
+    sort.Strings(addrs)
+    out := addrs[:0]
+    for i, addr := range addrs {
+        if i == 0 || addr != addrs[i-1] {
+            out = append(out, addr)
+        }
+    }
+    return out
+}
+
+// DNSResolver resolves a host name and pairs every address with a fixed port
+type DNSResolver struct {
+    Addr string // host:port
+}
+
+// Resolve looks up the host's addresses
+func (r *DNSResolver) Resolve(ctx context.Context) ([]string, error) {
+    host, port, err := net.SplitHostPort(r.Addr)
+    if err != nil {
+        return nil, err
+    }
+    ips, err := net.DefaultResolver.LookupHost(ctx, host)
+    if err != nil {
+        return nil, err
+    }
+    addrs := make([]string, 0, len(ips))
+    for _, ip := range ips {
+        addrs = append(addrs, net.JoinHostPort(ip, port))
+    }
+    return sorted(addrs), nil
+}
+
+// SRVResolver resolves SRV records, such as those of a headless Kubernetes service
+type SRVResolver struct {
+    Name string // For example _gossip._tcp.lru-cache.default.svc.cluster.local
+}
+
+// Resolve looks up the records' targets and ports
+func (r *SRVResolver) Resolve(ctx context.Context) ([]string, error) {
+    _, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", r.Name)
+    if err != nil {
+        return nil, err
+    }
+    addrs := make([]string, 0, len(records))
+    for _, srv := range records {
+        addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), fmt.Sprint(srv.Port)))
+    }
+    return sorted(addrs), nil
+}
+
+// ConsulResolver lists the instances of a service that pass their health checks
+type ConsulResolver struct {
+    URL        string // Base URL of the Consul agent, e.g. http://127.0.0.1:8500
+    Service    string
+    Tag        string // Only instances with this tag; empty matches all
+    Datacenter string // Empty is the agent's datacenter
+    Token      string // ACL token; empty sends none
+}
+
+// consulEntry is the part of an entry of /v1/health/service the resolver needs
+type consulEntry struct {
+    Node struct {
+        Address string
+    }
+    Service struct {
+        Address string // Empty when the service uses its node's address
+        Port    int
+    }
+}
+
+// Resolve queries the agent's health endpoint
+func (r *ConsulResolver) Resolve(ctx context.Context) ([]string, error) {
+    query := url.Values{"passing": {"1"}}
+    if r.Tag != "" {
+        query.Set("tag", r.Tag)
+    }
+    if r.Datacenter != "" {
+        query.Set("dc", r.Datacenter)
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL+"/v1/health/service/"+url.PathEscape(r.Service)+"?"+query.Encode(), nil)
+    if err != nil {
+        return nil, err
+    }
+    if r.Token != "" {
+        req.Header.Set("X-Consul-Token", r.Token)
+    }
+    var entries []consulEntry
+    if err := registryJSON(req, &entries); err != nil {
+        return nil, err
+    }
+    addrs := make([]string, 0, len(entries))
+    for _, e := range entries {
+        host := e.Service.Address
+        if host == "" {
+            host = e.Node.Address
+        }
+        addrs = append(addrs, net.JoinHostPort(host, fmt.Sprint(e.Service.Port)))
+    }
+    return sorted(addrs), nil
+}
+
+// EtcdResolver lists the addresses stored as values under a key prefix, with
+// one key per node, for example /services/lru-cache/node1 = 10.0.0.1:7946
+type EtcdResolver struct {
+    URL    string // Base URL of an etcd member, e.g. http://127.0.0.1:2379
+    Prefix string
+}
+
+// etcdRange is the body of a range request and response of etcd's JSON
+// gateway, which carries bytes in base64 as encoding/json does
+type etcdRange struct {
+    Key      []byte `json:"key,omitempty"`
+    RangeEnd []byte `json:"range_end,omitempty"`
+    KVs      []struct {
+        Value []byte `json:"value"`
+    } `json:"kvs,omitempty"`
+}
+
+// Resolve reads the range of keys starting with the prefix
+func (r *EtcdResolver) Resolve(ctx context.Context) ([]string, error) {
+    // The range ends at the prefix with its last byte incremented, as etcdctl --prefix does
+    end := []byte(r.Prefix)
+    for i := len(end) - 1; i >= 0; i-- {
+        if end[i] < 0xff {
+            end[i]++
+            end = end[:i+1]
+            break
+        }
+    }
+    body, _ := json.Marshal(etcdRange{Key: []byte(r.Prefix), RangeEnd: end})
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL+"/v3/kv/range", bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    var resp etcdRange
+    if err := registryJSON(req, &resp); err != nil {
+        return nil, err
+    }
+    addrs := make([]string, 0, len(resp.KVs))
+    for _, kv := range resp.KVs {
+        if addr := strings.TrimSpace(string(kv.Value)); addr != "" {
+            addrs = append(addrs, addr)
+        }
+    }
+    return sorted(addrs), nil
+}
+
+// registryJSON sends req and decodes the JSON response into v
+func registryJSON(req *http.Request, v interface{}) error {
+    resp, err := registryClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("client: %s answered %s", req.URL.Host, resp.Status)
+    }
+    return json.NewDecoder(resp.Body).Decode(v)
+}
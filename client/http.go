@@ -0,0 +1,382 @@
+package client
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "math/rand/v2"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// HTTP client tuning
+const (
+    httpTimeout    = 5 * time.Second        // Default limit on one attempt
+    httpIdleConns  = 64                     // Idle connections kept per server
+    httpIdleTime   = 90 * time.Second       // How long an idle connection is kept
+    httpBackoff    = 100 * time.Millisecond // Default wait before the first retry
+    httpMaxBackoff = 2 * time.Second        // Longest wait between retries
+    httpBatchSize  = 1000                   // Calls per JSON-RPC batch, the server's limit
+)
+
+// HTTPError is an error response from the HTTP API
+type HTTPError struct {
+    Status  int
+    Code    string // Such as KEY_NOT_FOUND, RATE_LIMITED or UNAVAILABLE
+    Message string
+}
+
+func (e *HTTPError) Error() string {
+    return fmt.Sprintf("client: server returned %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// HTTPClient talks to the HTTP API of one server, or of a proxy in front of
+// several; it pools connections and is safe for concurrent use
+type HTTPClient struct {
+    base    string
+    token   string
+    client  *http.Client
+    retries int
+    backoff time.Duration
+}
+
+// HTTPOption configures an HTTPClient
+type HTTPOption func(*HTTPClient)
+
+// WithToken authenticates every request with an API key or JWT
+func WithToken(token string) HTTPOption {
+    return func(c *HTTPClient) { c.token = token }
+}
+
+// WithTimeout limits each attempt of a request; the default is 5s
+func WithTimeout(d time.Duration) HTTPOption {
+    return func(c *HTTPClient) { c.client.Timeout = d }
+}
+
+// WithRetries retries requests that failed to reach the server or were
+// answered 429, 502, 503 or 504 up to n times, waiting backoff before the
+// first retry and doubling it, with jitter, up to 2s
+func WithRetries(n int, backoff time.Duration) HTTPOption {
+    return func(c *HTTPClient) { c.retries, c.backoff = n, backoff }
+}
+
+// WithHTTPClient sends requests through hc instead of the client's own pool
+func WithHTTPClient(hc *http.Client) HTTPOption {
+    return func(c *HTTPClient) { c.client = hc }
+}
+
+// NewHTTPClient creates a client for the server at baseURL, such as
+// http://localhost:8080
+func NewHTTPClient(baseURL string, opts ...HTTPOption) (*HTTPClient, error) {
+    u, err := url.Parse(baseURL)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return nil, fmt.Errorf("client: invalid base URL %q", baseURL)
+    }
+    c := &HTTPClient{
+        base: strings.TrimSuffix(baseURL, "/"),
+        client: &http.Client{
+            Timeout: httpTimeout,
+            Transport: &http.Transport{
+                Proxy:               http.ProxyFromEnvironment,
+                MaxIdleConns:        httpIdleConns,
+                MaxIdleConnsPerHost: httpIdleConns,
+                IdleConnTimeout:     httpIdleTime,
+            },
+        },
+        backoff: httpBackoff,
+    }
+    for _, opt := range opts {
+        opt(c)
+    }
+    return c, nil
+}
+
+// Close closes the idle connections in the pool
+func (c *HTTPClient) Close() {
+    c.client.CloseIdleConnections()
+}
+
+// Get returns the value of key, or ErrNotFound if it is missing or expired
+func (c *HTTPClient) Get(ctx context.Context, key string) ([]byte, error) {
+    return c.do(ctx, http.MethodGet, "/v1/cache/"+url.PathEscape(key), "", nil)
+}
+
+// Set stores value under key, sent as a raw body so binary values arrive
+// unchanged; a zero ttl never expires
+func (c *HTTPClient) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+    path := "/v1/cache/" + url.PathEscape(key) + "?expiration=" + strconv.FormatUint(uint64(seconds(ttl)), 10)
+    _, err := c.do(ctx, http.MethodPut, path, "application/octet-stream", value)
+    return err
+}
+
+// SetFrom stores the value read from r until EOF under key, streaming it to
+// the server without buffering it; a zero ttl never expires. As r cannot be
+// read twice, the request is never retried.
+func (c *HTTPClient) SetFrom(ctx context.Context, key string, r io.Reader, ttl time.Duration) error {
+    path := "/v1/cache/" + url.PathEscape(key) + "?expiration=" + strconv.FormatUint(uint64(seconds(ttl)), 10)
+    resp, _, err := c.open(ctx, http.MethodPut, path, "application/octet-stream", io.NopCloser(r), nil)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    return nil
+}
+
+// GetTo writes the value of key to w as it arrives, without buffering it,
+// and returns the number of bytes written; it fails with ErrNotFound if the
+// key is missing or expired
+func (c *HTTPClient) GetTo(ctx context.Context, key string, w io.Writer) (int64, error) {
+    return c.copy(ctx, key, w, nil)
+}
+
+// GetRange returns length bytes of the value of key from offset, or fewer
+// if the value ends first; a negative length reads to the end
+func (c *HTTPClient) GetRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+    spec := fmt.Sprintf("bytes=%d-", offset)
+    if length >= 0 {
+        if length == 0 {
+            return []byte{}, nil
+        }
+        spec += strconv.FormatInt(offset+length-1, 10)
+    }
+    var buf bytes.Buffer
+    _, err := c.copy(ctx, key, &buf, http.Header{"Range": {spec}})
+    return buf.Bytes(), err
+}
+
+// copy streams the value of key, or the range header asks for, to w
+func (c *HTTPClient) copy(ctx context.Context, key string, w io.Writer, header http.Header) (int64, error) {
+    resp, _, err := c.open(ctx, http.MethodGet, "/v1/cache/"+url.PathEscape(key), "", nil, header)
+    if err != nil {
+        var httpErr *HTTPError
+        if errors.As(err, &httpErr) && httpErr.Status == http.StatusRequestedRangeNotSatisfiable {
+            return 0, nil // The range starts past the end of the value
+        }
+        return 0, err
+    }
+    defer resp.Body.Close()
+    return io.Copy(w, resp.Body)
+}
+
+// Delete removes key, returning ErrNotFound if it did not exist
+func (c *HTTPClient) Delete(ctx context.Context, key string) error {
+    _, err := c.do(ctx, http.MethodDelete, "/v1/cache/"+url.PathEscape(key), "", nil)
+    return err
+}
+
+// GetMulti returns the values of the keys that were found in one request;
+// missing and expired keys are left out
+func (c *HTTPClient) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+    values := make(map[string][]byte, len(keys))
+    if len(keys) == 0 {
+        return values, nil
+    }
+    query := url.Values{"key": keys, "keys": {""}} // An empty keys asks for the JSON form even for one key
+    body, err := c.do(ctx, http.MethodGet, "/cache?"+query.Encode(), "", nil)
+    if err != nil {
+        return nil, err
+    }
+    var resp struct {
+        Values map[string]string `json:"values"`
+    }
+    if err := json.Unmarshal(body, &resp); err != nil {
+        return nil, fmt.Errorf("client: decoding response: %w", err)
+    }
+    for key, value := range resp.Values {
+        values[key] = []byte(value)
+    }
+    return values, nil
+}
+
+// SetMulti stores every entry with the same ttl, sending JSON-RPC batches of
+// up to 1000 calls; entries of a failed batch may or may not have been stored
+func (c *HTTPClient) SetMulti(ctx context.Context, entries map[string][]byte, ttl time.Duration) error {
+    calls := make([]rpcCall, 0, len(entries))
+    for key, value := range entries {
+        calls = append(calls, rpcCall{Method: "cache.set", Params: map[string]interface{}{"key": key, "value": string(value), "ttl": seconds(ttl)}})
+    }
+    _, err := c.batch(ctx, calls)
+    return err
+}
+
+// DeleteMulti removes every key in JSON-RPC batches, returning how many existed
+func (c *HTTPClient) DeleteMulti(ctx context.Context, keys []string) (int, error) {
+    calls := make([]rpcCall, 0, len(keys))
+    for _, key := range keys {
+        calls = append(calls, rpcCall{Method: "cache.delete", Params: map[string]interface{}{"key": key}})
+    }
+    results, err := c.batch(ctx, calls)
+    deleted := 0
+    for _, result := range results {
+        if string(result) == "true" {
+            deleted++
+        }
+    }
+    return deleted, err
+}
+
+// rpcCall is one call in a JSON-RPC batch
+type rpcCall struct {
+    JSONRPC string                 `json:"jsonrpc"`
+    Method  string                 `json:"method"`
+    Params  map[string]interface{} `json:"params"`
+    ID      int                    `json:"id"`
+}
+
+// rpcResult is one response in a JSON-RPC batch
+type rpcResult struct {
+    Result json.RawMessage `json:"result"`
+    Error  *struct {
+        Code    int    `json:"code"`
+        Message string `json:"message"`
+    } `json:"error"`
+    ID int `json:"id"`
+}
+
+// batch sends calls in batches of httpBatchSize, returning the results in
+// call order and every error reported for a call
+func (c *HTTPClient) batch(ctx context.Context, calls []rpcCall) ([]json.RawMessage, error) {
+    results := make([]json.RawMessage, len(calls))
+    var errs []error
+    for start := 0; start < len(calls); start += httpBatchSize {
+        chunk := calls[start:min(start+httpBatchSize, len(calls))]
+        for i := range chunk {
+            chunk[i].JSONRPC, chunk[i].ID = "2.0", start+i
+        }
+        body, err := json.Marshal(chunk)
+        if err != nil {
+            return results, err
+        }
+        resp, err := c.do(ctx, http.MethodPost, "/rpc", "application/json", body)
+        if err != nil {
+            return results, err
+        }
+        var replies []rpcResult
+        if err := json.Unmarshal(resp, &replies); err != nil {
+            return results, fmt.Errorf("client: decoding response: %w", err)
+        }
+        for _, reply := range replies {
+            if reply.ID < 0 || reply.ID >= len(calls) {
+                continue
+            }
+            if reply.Error != nil {
+                errs = append(errs, fmt.Errorf("client: %s %v: %s", calls[reply.ID].Method, calls[reply.ID].Params["key"], reply.Error.Message))
+                continue
+            }
+            results[reply.ID] = reply.Result
+        }
+    }
+    return results, errors.Join(errs...)
+}
+
+// do sends a request, retrying as configured, and returns the response body
+// of a 2xx answer; a 404 with KEY_NOT_FOUND or EXPIRED becomes ErrNotFound
+// and a 409 with NOT_ADMITTED ErrNotStored
+func (c *HTTPClient) do(ctx context.Context, method, path, contentType string, body []byte) ([]byte, error) {
+    backoff := c.backoff
+    for attempt := 0; ; attempt++ {
+        resp, retryAfter, err := c.send(ctx, method, path, contentType, body)
+        if err == nil || attempt >= c.retries || !retryable(ctx, err) {
+            return resp, err
+        }
+        wait := backoff/2 + rand.N(backoff/2+1)
+        if retryAfter > wait {
+            wait = retryAfter
+        }
+        select {
+        case <-time.After(wait):
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        }
+        backoff = min(2*backoff, httpMaxBackoff)
+    }
+}
+
+// send makes one attempt, returning the server's Retry-After, if any, with
+// the error
+func (c *HTTPClient) send(ctx context.Context, method, path, contentType string, body []byte) ([]byte, time.Duration, error) {
+    var reader io.Reader
+    if body != nil {
+        reader = bytes.NewReader(body)
+    }
+    resp, retryAfter, err := c.open(ctx, method, path, contentType, reader, nil)
+    if err != nil {
+        return nil, retryAfter, err
+    }
+    defer resp.Body.Close()
+    data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseValue+1))
+    if err != nil {
+        return nil, 0, err
+    }
+    if len(data) > maxResponseValue {
+        return nil, 0, fmt.Errorf("client: response exceeds %d bytes", maxResponseValue)
+    }
+    return data, 0, nil
+}
+
+// open sends a request and returns the response of a 2xx answer, whose body
+// the caller must close, or the error the server answered with and its
+// Retry-After, if any
+func (c *HTTPClient) open(ctx context.Context, method, path, contentType string, body io.Reader, header http.Header) (*http.Response, time.Duration, error) {
+    req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+    if err != nil {
+        return nil, 0, err
+    }
+    for name, values := range header {
+        req.Header[name] = values
+    }
+    if contentType != "" {
+        req.Header.Set("Content-Type", contentType)
+    }
+    if c.token != "" {
+        req.Header.Set("Authorization", "Bearer "+c.token)
+    }
+    resp, err := c.client.Do(req)
+    if err != nil {
+        return nil, 0, err
+    }
+    if resp.StatusCode/100 == 2 {
+        return resp, 0, nil
+    }
+    defer resp.Body.Close()
+
+    var envelope struct {
+        Error struct {
+            Code    string `json:"code"`
+            Message string `json:"message"`
+        } `json:"error"`
+    }
+    json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&envelope)
+    if resp.StatusCode == http.StatusNotFound && (envelope.Error.Code == "KEY_NOT_FOUND" || envelope.Error.Code == "EXPIRED") {
+        return nil, 0, ErrNotFound
+    }
+    if resp.StatusCode == http.StatusConflict && envelope.Error.Code == "NOT_ADMITTED" {
+        return nil, 0, ErrNotStored
+    }
+    retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+    return nil, time.Duration(retryAfter) * time.Second, &HTTPError{Status: resp.StatusCode, Code: envelope.Error.Code, Message: envelope.Error.Message}
+}
+
+// retryable reports whether a failed attempt may succeed if sent again
+func retryable(ctx context.Context, err error) bool {
+    if ctx.Err() != nil {
+        return false
+    }
+    var httpErr *HTTPError
+    if errors.As(err, &httpErr) {
+        switch httpErr.Status {
+        case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+            return true
+        }
+        return false
+    }
+    // Sending a write the doorkeeper refused again would have it admitted,
+    // which the doorkeeper is there to prevent
+    return !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrNotStored)
+}
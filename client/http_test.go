@@ -0,0 +1,56 @@
+package client
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestHTTPClientErrors(t *testing.T) {
+    tests := []struct {
+        name         string
+        status       int
+        body         string
+        wantErr      error
+        wantStatus   int // Of the *HTTPError wanted instead of wantErr
+        wantAttempts int32
+    }{
+        {"not admitted", http.StatusConflict, `{"error":{"code":"NOT_ADMITTED","message":"new key"}}`, ErrNotStored, 0, 1},
+        {"wrong type", http.StatusConflict, `{"error":{"code":"WRONG_TYPE","message":"list"}}`, nil, http.StatusConflict, 1},
+        {"unavailable", http.StatusServiceUnavailable, `{"error":{"code":"UNAVAILABLE","message":"closing"}}`, nil, http.StatusServiceUnavailable, 3},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var attempts atomic.Int32
+            server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                attempts.Add(1)
+                w.Header().Set("Content-Type", "application/json")
+                w.WriteHeader(tt.status)
+                w.Write([]byte(tt.body))
+            }))
+            defer server.Close()
+            c, err := NewHTTPClient(server.URL, WithRetries(2, time.Millisecond))
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            err = c.Set(context.Background(), "k", []byte("v"), 0)
+            var httpErr *HTTPError
+            switch {
+            case tt.wantStatus != 0:
+                if !errors.As(err, &httpErr) || httpErr.Status != tt.wantStatus {
+                    t.Fatalf("Set = %v, want an HTTP error with status %d", err, tt.wantStatus)
+                }
+            case !errors.Is(err, tt.wantErr):
+                t.Fatalf("Set = %v, want %v", err, tt.wantErr)
+            }
+            if n := attempts.Load(); n != tt.wantAttempts {
+                t.Fatalf("%d attempts, want %d", n, tt.wantAttempts)
+            }
+        })
+    }
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+    "hash/fnv"
+    "sort"
+    "strconv"
+)
+
+// DefaultReplicas is the number of virtual nodes per server, which keeps
+// each server's share of keys within about 15% of even
+const DefaultReplicas = 160
+
+// Ring is a consistent hash ring with virtual nodes; adding or removing a
+// node only moves the keys that node gains or loses. It is not safe for
+// concurrent use; Cluster guards its ring with a lock.
+type Ring struct {
+    replicas int
+    hashes   []uint64 // Sorted virtual node positions
+    owners   map[uint64]string
+    nodes    map[string]bool
+}
+
+// NewRing creates a ring with replicas virtual nodes per node, or
+// DefaultReplicas if replicas is not positive
+func NewRing(replicas int, nodes ...string) *Ring {
+    if replicas <= 0 {
+        replicas = DefaultReplicas
+    }
+    r := &Ring{replicas: replicas, owners: make(map[uint64]string), nodes: make(map[string]bool)}
+    for _, node := range nodes {
+        r.Add(node)
+    }
+    return r
+}
+
+// hash is 64-bit FNV-1a followed by a finalizer that spreads similar inputs,
+// such as "node#1" and "node#2", across the whole ring
+func hash(s string) uint64 {
+    h := fnv.New64a()
+    h.Write([]byte(s))
+    x := h.Sum64()
+    x ^= x >> 33
+    x *= 0xff51afd7ed558ccd
+    x ^= x >> 33
+    x *= 0xc4ceb9fe1a85ec53
+    x ^= x >> 33
+    return x
+}
+
+// Add places node on the ring; adding a node twice has no effect
+func (r *Ring) Add(node string) {
+    if r.nodes[node] {
+        return
+    }
+    r.nodes[node] = true
+    for i := 0; i < r.replicas; i++ {
+        h := hash(node + "#" + strconv.Itoa(i))
+        if _, taken := r.owners[h]; taken {
+            continue // A 64-bit collision; the earlier node keeps the point
+        }
+        r.owners[h] = node
+        r.hashes = append(r.hashes, h)
+    }
+    sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// Remove takes node off the ring
+func (r *Ring) Remove(node string) {
+    if !r.nodes[node] {
+        return
+    }
+    delete(r.nodes, node)
+    kept := r.hashes[:0]
+    for _, h := range r.hashes {
+        if r.owners[h] == node {
+            delete(r.owners, h)
+        } else {
+            kept = append(kept, h)
+        }
+    }
+    r.hashes = kept
+}
+
+// Node returns the node owning key: the first virtual node clockwise from
+// the key's hash. It returns "" when the ring is empty.
+func (r *Ring) Node(key string) string {
+    if len(r.hashes) == 0 {
+        return ""
+    }
+    h := hash(key)
+    i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+    if i == len(r.hashes) {
+        i = 0
+    }
+    return r.owners[r.hashes[i]]
+}
+
+// Nodes returns the nodes on the ring in sorted order
+func (r *Ring) Nodes() []string {
+    nodes := make([]string, 0, len(r.nodes))
+    for node := range r.nodes {
+        nodes = append(nodes, node)
+    }
+    sort.Strings(nodes)
+    return nodes
+}
+
+// Successors returns up to n distinct nodes in the order the ring meets
+// them clockwise from the key's hash, the owner first, for failing over to
+func (r *Ring) Successors(key string, n int) []string {
+    if len(r.hashes) == 0 || n <= 0 {
+        return nil
+    }
+    h := hash(key)
+    start := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+    nodes := make([]string, 0, min(n, len(r.nodes)))
+    seen := make(map[string]bool, cap(nodes))
+    for i := 0; i < len(r.hashes) && len(nodes) < n; i++ {
+        node := r.owners[r.hashes[(start+i)%len(r.hashes)]]
+        if !seen[node] {
+            seen[node] = true
+            nodes = append(nodes, node)
+        }
+    }
+    return nodes
+}
+
+// Arc is a span of the hash space one node owns: the hashes after Start up
+// to and including End, wrapping past the top when End is below Start
+type Arc struct {
+    Start uint64 `json:"start"`
+    End   uint64 `json:"end"`
+}
+
+// Arcs returns the spans each node owns, in ring order
+func (r *Ring) Arcs() map[string][]Arc {
+    arcs := make(map[string][]Arc, len(r.nodes))
+    for i, h := range r.hashes {
+        prev := r.hashes[(i+len(r.hashes)-1)%len(r.hashes)]
+        arcs[r.owners[h]] = append(arcs[r.owners[h]], Arc{Start: prev, End: h})
+    }
+    return arcs
+}
+
+// Share returns the fraction of the hash space, and so of keys, each node owns
+func (r *Ring) Share() map[string]float64 {
+    shares := make(map[string]float64, len(r.nodes))
+    if len(r.hashes) == 1 {
+        shares[r.owners[r.hashes[0]]] = 1
+        return shares
+    }
+    for node, arcs := range r.Arcs() {
+        for _, a := range arcs {
+            shares[node] += float64(a.End-a.Start) / (1 << 64) // Unsigned subtraction wraps as the arc does
+        }
+    }
+    return shares
+}
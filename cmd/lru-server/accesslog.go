@@ -0,0 +1,93 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "time"
+)
+
+// statusRecorder captures the status code and body size written by a handler
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+    bytes  int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+    if sr.status == 0 {
+        sr.status = status
+    }
+    sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+    if sr.status == 0 {
+        sr.status = http.StatusOK
+    }
+    n, err := sr.ResponseWriter.Write(p)
+    sr.bytes += n
+    return n, err
+}
+
+func (sr *statusRecorder) Flush() {
+    if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+    if h, ok := sr.ResponseWriter.(http.Hijacker); ok {
+        sr.status = http.StatusSwitchingProtocols
+        return h.Hijack()
+    }
+    return nil, nil, errors.New("response writer does not support hijacking")
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+    return sr.ResponseWriter
+}
+
+// accessLogEntry is one line of the access log
+type accessLogEntry struct {
+    Time      string  `json:"time"`
+    Method    string  `json:"method"`
+    Path      string  `json:"path"`
+    Key       string  `json:"key,omitempty"`
+    Status    int     `json:"status"`
+    LatencyMS float64 `json:"latency_ms"`
+    Bytes     int     `json:"bytes"`
+    ClientIP  string  `json:"client_ip"`
+}
+
+// accessLogMiddleware writes a JSON line per request to out
+func accessLogMiddleware(out io.Writer, next http.Handler) http.Handler {
+    logger := log.New(out, "", 0)
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        rec := &statusRecorder{ResponseWriter: w}
+        next.ServeHTTP(rec, r)
+        if rec.status == 0 {
+            rec.status = http.StatusOK
+        }
+
+        line, err := json.Marshal(accessLogEntry{
+            Time:      start.UTC().Format(time.RFC3339Nano),
+            Method:    r.Method,
+            Path:      r.URL.Path,
+            Key:       r.URL.Query().Get("key"),
+            Status:    rec.status,
+            LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+            Bytes:     rec.bytes,
+            ClientIP:  clientIP(r),
+        })
+        if err == nil {
+            logger.Println(string(line))
+        }
+    })
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "net/http/pprof"
+    "strconv"
+
+    "lru-cache/lrucache"
+)
+
+// CapacityRequest is the body of PUT /admin/capacity
+type CapacityRequest struct {
+    Capacity int `json:"capacity"`
+}
+
+// CapacityResponse reports the new capacity and how many entries were evicted
+type CapacityResponse struct {
+    Capacity int `json:"capacity"`
+    Evicted  int `json:"evicted"`
+}
+
+// LogLevelRequest is the body of PUT /admin/loglevel and its response
+type LogLevelRequest struct {
+    Level string `json:"level"`
+}
+
+// CountResponse reports how many entries an admin operation affected
+type CountResponse struct {
+    Count int `json:"count"`
+}
+
+// DebugLRUResponse is returned by GET /debug/lru
+type DebugLRUResponse struct {
+    Entries  int                   `json:"entries"`
+    Capacity int                   `json:"capacity"`
+    Order    []lrucache.DebugEntry `json:"order"`
+}
+
+// adminRoutes lists the privileged endpoints served on the admin listener
+func adminRoutes(h *handlers, metrics *HTTPMetrics) []route {
+    routes := []route{
+        {
+            Method:  http.MethodGet,
+            Path:    "/metrics",
+            Summary: "Cache and HTTP metrics in the Prometheus text format",
+            Handler: metricsHandler(h.namespaces, metrics),
+            Response: []response{
+                {Status: http.StatusOK, Description: "Prometheus exposition", ContentType: "text/plain"},
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/admin/flush",
+            Summary: "Remove every entry",
+            Handler: h.flushHandler,
+            Response: []response{
+                {Status: http.StatusOK, Description: "Number of entries removed", ContentType: "application/json", Body: CountResponse{}},
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/admin/export",
+            Summary: "Export all live entries, most recently used first",
+            Handler: h.exportHandler,
+            Response: []response{
+                {Status: http.StatusOK, Description: "All live entries", ContentType: "application/json", Body: []lrucache.Entry{}},
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/admin/import",
+            Summary: "Import entries produced by /admin/export",
+            Handler: h.importHandler,
+            Body:    []lrucache.Entry{},
+            Response: []response{
+                {Status: http.StatusOK, Description: "Number of entries imported", ContentType: "application/json", Body: CountResponse{}},
+                errorResponse(http.StatusBadRequest, "Malformed request body (BAD_REQUEST)"),
+            },
+        },
+        {
+            Method:  http.MethodPut,
+            Path:    "/admin/capacity",
+            Summary: "Change the maximum number of entries",
+            Handler: h.capacityHandler,
+            Body:    CapacityRequest{},
+            Response: []response{
+                {Status: http.StatusOK, Description: "New capacity and evicted entries", ContentType: "application/json", Body: CapacityResponse{}},
+                errorResponse(http.StatusBadRequest, "Malformed request body or non-positive capacity (BAD_REQUEST)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/admin/loglevel",
+            Summary: "Show the minimum log level",
+            Handler: getLogLevelHandler,
+            Response: []response{
+                {Status: http.StatusOK, Description: "Current log level", ContentType: "application/json", Body: LogLevelRequest{}},
+            },
+        },
+        {
+            Method:  http.MethodPut,
+            Path:    "/admin/loglevel",
+            Summary: "Change the minimum log level to debug, info, warn or error without restarting",
+            Handler: setLogLevelHandler,
+            Body:    LogLevelRequest{},
+            Response: []response{
+                {Status: http.StatusOK, Description: "New log level", ContentType: "application/json", Body: LogLevelRequest{}},
+                errorResponse(http.StatusBadRequest, "Malformed request body or unknown level (BAD_REQUEST)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/admin/raft",
+            Summary: "Show this node's Raft state, the leader and the members",
+            Handler: raftStatusHandler,
+            Response: []response{
+                {Status: http.StatusOK, Description: "Raft status", ContentType: "application/json", Body: RaftStatus{Peers: []RaftPeer{}}},
+                errorResponse(http.StatusNotFound, "Raft is disabled (NOT_FOUND)"),
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/admin/raft/peers",
+            Summary: "Add a voting member; send it to the leader",
+            Handler: raftAddPeerHandler,
+            Body:    RaftPeerRequest{},
+            Response: []response{
+                {Status: http.StatusOK, Description: "Raft status including the new member", ContentType: "application/json", Body: RaftStatus{Peers: []RaftPeer{}}},
+                errorResponse(http.StatusBadRequest, "Missing id or address (BAD_REQUEST)"),
+                errorResponse(http.StatusForbidden, "This node is not the leader (READ_ONLY)"),
+                errorResponse(http.StatusNotFound, "Raft is disabled (NOT_FOUND)"),
+                errorResponse(http.StatusServiceUnavailable, "The change could not be committed (UNAVAILABLE)"),
+            },
+        },
+        {
+            Method:  http.MethodDelete,
+            Path:    "/admin/raft/peers/{id}",
+            Summary: "Remove a member; send it to the leader",
+            Handler: raftRemovePeerHandler,
+            Params: []param{
+                {Name: "id", In: "path", Description: "Member to remove", Required: true},
+            },
+            Response: []response{
+                {Status: http.StatusOK, Description: "Raft status without the member", ContentType: "application/json", Body: RaftStatus{Peers: []RaftPeer{}}},
+                errorResponse(http.StatusForbidden, "This node is not the leader (READ_ONLY)"),
+                errorResponse(http.StatusNotFound, "Raft is disabled (NOT_FOUND)"),
+                errorResponse(http.StatusServiceUnavailable, "The change could not be committed (UNAVAILABLE)"),
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/admin/wan",
+            Summary: "Apply changes shipped from a cluster in another datacenter, keeping whichever write of a key is later",
+            Handler: h.wanHandler,
+            Body:    WANBatch{Changes: []WANChange{}},
+            Response: []response{
+                {Status: http.StatusOK, Description: "Changes applied and lost to later local writes", ContentType: "application/json", Body: WANApplyResponse{}},
+                errorResponse(http.StatusBadRequest, "Malformed body (BAD_REQUEST)"),
+                errorResponse(http.StatusForbidden, "This node is a Raft follower (READ_ONLY)"),
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/admin/drain",
+            Summary: "Take this node off the ring and hand its entries to the members now owning them, before removing it",
+            Handler: drainHandler,
+            Response: []response{
+                {Status: http.StatusOK, Description: "Progress of the drain, which continues in the background", ContentType: "application/json", Body: DrainStatus{}},
+                errorResponse(http.StatusNotFound, "Rebalancing is disabled (NOT_FOUND)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/admin/drain",
+            Summary: "Show the progress of a drain",
+            Handler: drainStatusHandler,
+            Response: []response{
+                {Status: http.StatusOK, Description: "Progress of the drain", ContentType: "application/json", Body: DrainStatus{}},
+                errorResponse(http.StatusNotFound, "Rebalancing is disabled (NOT_FOUND)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/debug/lru",
+            Summary: "List entries from most to least recently used with their TTLs and sizes",
+            Handler: h.debugLRUHandler,
+            Params: []param{
+                {Name: "limit", In: "query", Description: "Most entries to list (default 1000, 0 lists all)"},
+                {Name: "truncate", In: "query", Description: "Cut values to this many bytes (0 keeps them whole)"},
+                {Name: "match", In: "query", Description: "List only keys matching this pattern, where * matches any run of characters and ? one"},
+            },
+            Response: []response{
+                {Status: http.StatusOK, Description: "Entries in LRU order, including expired ones not yet removed", ContentType: "application/json", Body: DebugLRUResponse{Order: []lrucache.DebugEntry{}}},
+                errorResponse(http.StatusBadRequest, "Negative or non-numeric limit or truncate (BAD_REQUEST)"),
+            },
+        },
+        {
+            Method:  http.MethodDelete,
+            Path:    "/admin/keys/{key}",
+            Summary: "Delete one key, as the dashboard does",
+            Handler: h.adminDeleteHandler,
+            Response: []response{
+                {Status: http.StatusNoContent, Description: "Deleted"},
+                errorResponse(http.StatusNotFound, "Key not found (KEY_NOT_FOUND)"),
+            },
+        },
+    }
+    routes = append(routes, reloadRoute(h))
+    routes = append(routes, runtimeConfigRoutes(h)...)
+    return append(routes, namespaceAdminRoutes(h)...)
+}
+
+// newAdminRouter serves the admin endpoints, the pprof profiles and expvar
+func newAdminRouter(h *handlers, metrics *HTTPMetrics) *http.ServeMux {
+    mux := newRouter(adminRoutes(h, metrics))
+    mux.HandleFunc("/debug/pprof/", pprof.Index)
+    mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+    mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+    mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+    mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+    mux.HandleFunc("GET /debug/vars", expvarHandler)
+    return mux
+}
+
+// writeJSON writes v as a JSON response with status 200
+func writeJSON(w http.ResponseWriter, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(v)
+}
+
+// flushHandler handles POST /admin/flush
+func (h *handlers) flushHandler(w http.ResponseWriter, r *http.Request) {
+    if raftFollower(w) {
+        return
+    }
+    n := h.cache.Flush()
+    audit(httpCaller("admin", r), "flush", "")
+    writeJSON(w, CountResponse{Count: n})
+}
+
+// exportHandler handles GET /admin/export
+func (h *handlers) exportHandler(w http.ResponseWriter, r *http.Request) {
+    replicated, ok := supported[lrucache.Replicated](w, h.cache)
+    if !ok {
+        return
+    }
+    writeJSON(w, replicated.Export())
+}
+
+// importHandler handles POST /admin/import
+func (h *handlers) importHandler(w http.ResponseWriter, r *http.Request) {
+    replicated, ok := supported[lrucache.Replicated](w, h.cache)
+    if !ok {
+        return
+    }
+    var entries []lrucache.Entry
+    if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+        writeError(w, http.StatusBadRequest, codeBadRequest, "Malformed JSON body")
+        return
+    }
+    if raftFollower(w) {
+        return
+    }
+    n := replicated.Import(entries)
+    audit(httpCaller("admin", r), "import", "")
+    writeJSON(w, CountResponse{Count: n})
+}
+
+// debugLRUHandler handles GET /debug/lru
+func (h *handlers) debugLRUHandler(w http.ResponseWriter, r *http.Request) {
+    admin, ok := supported[lrucache.Administered](w, h.cache)
+    if !ok {
+        return
+    }
+    limit, truncate := 1000, 0
+    for name, v := range map[string]*int{"limit": &limit, "truncate": &truncate} {
+        if s := r.URL.Query().Get(name); s != "" {
+            n, err := strconv.Atoi(s)
+            if err != nil || n < 0 {
+                writeError(w, http.StatusBadRequest, codeBadRequest, name+" must be a non-negative integer")
+                return
+            }
+            *v = n
+        }
+    }
+    stats := admin.Stats()
+    order := admin.DebugEntriesMatching(r.URL.Query().Get("match"), limit, truncate)
+    writeJSON(w, DebugLRUResponse{Entries: stats.Entries, Capacity: stats.Capacity, Order: order})
+}
+
+// adminDeleteHandler handles DELETE /admin/keys/{key}
+func (h *handlers) adminDeleteHandler(w http.ResponseWriter, r *http.Request) {
+    if raftFollower(w) {
+        return
+    }
+    if !h.cache.Delete(r.PathValue("key")) {
+        writeError(w, http.StatusNotFound, codeKeyNotFound, "Key not found")
+        return
+    }
+    audit(httpCaller("admin", r), "delete", r.PathValue("key"))
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// getLogLevelHandler handles GET /admin/loglevel
+func getLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, LogLevelRequest{Level: logLevel.Level().String()})
+}
+
+// setLogLevelHandler handles PUT /admin/loglevel
+func setLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+    var req LogLevelRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, http.StatusBadRequest, codeBadRequest, "Malformed JSON body")
+        return
+    }
+    level, err := parseLogLevel(req.Level)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+        return
+    }
+    previous := logLevel.Level()
+    logLevel.Set(level)
+    // Log at a level the new setting still shows, so the change is always recorded
+    slog.Log(r.Context(), max(level, slog.LevelInfo), "log level changed", "from", previous.String(), "to", level.String())
+    writeJSON(w, LogLevelRequest{Level: level.String()})
+}
+
+// capacityHandler handles PUT /admin/capacity
+func (h *handlers) capacityHandler(w http.ResponseWriter, r *http.Request) {
+    admin, ok := supported[lrucache.Administered](w, h.cache)
+    if !ok {
+        return
+    }
+    var req CapacityRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Capacity <= 0 {
+        writeError(w, http.StatusBadRequest, codeBadRequest, "Capacity must be a positive integer")
+        return
+    }
+    if raftFollower(w) {
+        return
+    }
+    evicted, err := admin.Resize(req.Capacity)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+        return
+    }
+    writeJSON(w, CapacityResponse{Capacity: req.Capacity, Evicted: evicted})
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "net"
+    "net/http"
+    "os"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Limits of the audit webhook
+const (
+    auditQueueSize   = 8192
+    auditBatchSize   = 256
+    auditFlushPeriod = time.Second
+)
+
+// Caller identifies who made a change
+type Caller struct {
+    Protocol string
+    Subject  string // Authenticated principal; empty when anonymous
+    Client   string // Client IP or remote address
+}
+
+// newCaller describes a client of protocol at addr, authenticated as p if non-nil
+func newCaller(protocol string, p *Principal, addr string) Caller {
+    c := Caller{Protocol: protocol, Client: addr}
+    if p != nil {
+        c.Subject = p.Subject
+    }
+    return c
+}
+
+// httpCaller describes the client of an HTTP request
+func httpCaller(protocol string, r *http.Request) Caller {
+    return newCaller(protocol, principalFrom(r.Context()), clientIP(r))
+}
+
+// connClient is the host of a connection's remote address, or "unix" for unix sockets
+func connClient(conn net.Conn) string {
+    addr := conn.RemoteAddr()
+    if addr == nil || addr.Network() == "unix" {
+        return "unix"
+    }
+    if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+        return host
+    }
+    return addr.String()
+}
+
+// AuditEntry is one line of the audit log
+type AuditEntry struct {
+    Time     string `json:"time"`
+    Op       string `json:"op"`
+    Key      string `json:"key,omitempty"`
+    Subject  string `json:"subject,omitempty"`
+    Client   string `json:"client"`
+    Protocol string `json:"protocol"`
+}
+
+// auditor is nil unless -audit-file or -audit-webhook is set
+var auditor *Auditor
+
+// audit records a set, delete, flush or import made by c
+func audit(c Caller, op, key string) {
+    if auditor != nil {
+        auditor.Record(AuditEntry{
+            Time:     time.Now().UTC().Format(time.RFC3339Nano),
+            Op:       op,
+            Key:      key,
+            Subject:  c.Subject,
+            Client:   c.Client,
+            Protocol: c.Protocol,
+        })
+    }
+}
+
+// Auditor appends entries to a size-rotated file and posts them in batches to a webhook
+type Auditor struct {
+    path     string
+    maxSize  int64
+    backups  int
+    mutex    sync.Mutex // Guards file and size
+    file     *os.File
+    size     int64
+    webhook  string
+    client   *http.Client
+    queue    chan AuditEntry
+    dropped  atomic.Uint64
+    done     chan struct{}
+    stopped  chan struct{}
+    stopOnce sync.Once
+}
+
+// NewAuditor opens path for appending, unless empty, keeping backups rotated
+// files of at most maxSize bytes; entries are also posted to webhook, unless empty
+func NewAuditor(path string, maxSize int64, backups int, webhook string) (*Auditor, error) {
+    a := &Auditor{
+        path:    path,
+        maxSize: maxSize,
+        backups: backups,
+        webhook: webhook,
+        client:  &http.Client{Timeout: 10 * time.Second},
+        queue:   make(chan AuditEntry, auditQueueSize),
+        done:    make(chan struct{}),
+        stopped: make(chan struct{}),
+    }
+    if path != "" {
+        if err := a.open(); err != nil {
+            return nil, err
+        }
+    }
+    return a, nil
+}
+
+// target describes where entries go, for log messages
+func (a *Auditor) target() string {
+    switch {
+    case a.path == "":
+        return redactURL(a.webhook)
+    case a.webhook == "":
+        return a.path
+    }
+    return a.path + "," + redactURL(a.webhook)
+}
+
+func (a *Auditor) open() error {
+    f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+    if err != nil {
+        return err
+    }
+    info, err := f.Stat()
+    if err != nil {
+        f.Close()
+        return err
+    }
+    a.file, a.size = f, info.Size()
+    return nil
+}
+
+// Record writes e to the file and queues it for the webhook; the file is
+// written synchronously so that a change is never made without its entry
+func (a *Auditor) Record(e AuditEntry) {
+    line, _ := json.Marshal(e)
+    line = append(line, '\n')
+    if a.path != "" {
+        a.write(line)
+    }
+    if a.webhook != "" {
+        select {
+        case a.queue <- e:
+        default:
+            a.dropped.Add(1)
+        }
+    }
+}
+
+func (a *Auditor) write(line []byte) {
+    a.mutex.Lock()
+    defer a.mutex.Unlock()
+
+    if a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+        if err := a.rotate(); err != nil {
+            slog.Error("audit: rotating log failed", "path", a.path, "err", err)
+        }
+    }
+    if a.file == nil {
+        return
+    }
+    n, err := a.file.Write(line)
+    a.size += int64(n)
+    if err != nil {
+        slog.Error("audit: write failed", "path", a.path, "err", err)
+    }
+}
+
+// rotate shifts path.1 through path.<backups-1> up by one, moves the current
+// file to path.1 and starts a new one; the caller holds the mutex
+func (a *Auditor) rotate() error {
+    a.file.Close()
+    a.file = nil
+    if a.backups == 0 {
+        os.Remove(a.path)
+    } else {
+        for i := a.backups - 1; i > 0; i-- {
+            os.Rename(fmt.Sprintf("%s.%d", a.path, i), fmt.Sprintf("%s.%d", a.path, i+1))
+        }
+        if err := os.Rename(a.path, a.path+".1"); err != nil {
+            return err
+        }
+    }
+    return a.open()
+}
+
+// ListenAndServe posts queued entries to the webhook until shut down
+func (a *Auditor) ListenAndServe() error {
+    defer close(a.stopped)
+    ticker := time.NewTicker(auditFlushPeriod)
+    defer ticker.Stop()
+    batch := make([]AuditEntry, 0, auditBatchSize)
+    for {
+        select {
+        case e := <-a.queue:
+            if batch = append(batch, e); len(batch) >= auditBatchSize {
+                a.post(batch)
+                batch = batch[:0]
+            }
+        case <-ticker.C:
+            if n := a.dropped.Swap(0); n > 0 {
+                slog.Warn("audit: webhook queue full, entries dropped", "dropped", n)
+            }
+            if len(batch) > 0 {
+                a.post(batch)
+                batch = batch[:0]
+            }
+        case <-a.done:
+            for len(a.queue) > 0 {
+                batch = append(batch, <-a.queue)
+            }
+            if len(batch) > 0 {
+                a.post(batch)
+            }
+            return errServerClosed
+        }
+    }
+}
+
+// post sends a batch to the webhook as a JSON array
+func (a *Auditor) post(batch []AuditEntry) {
+    body, _ := json.Marshal(batch)
+    resp, err := a.client.Post(a.webhook, "application/json", bytes.NewReader(body))
+    if err != nil {
+        slog.Warn("audit: webhook failed", "entries", len(batch), "err", err)
+        return
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        slog.Warn("audit: webhook rejected entries", "entries", len(batch), "status", resp.Status)
+    }
+}
+
+// Shutdown posts the remaining entries
+func (a *Auditor) Shutdown(ctx context.Context) error {
+    a.stopOnce.Do(func() { close(a.done) })
+    select {
+    case <-a.stopped:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Close stops posting without waiting
+func (a *Auditor) Close() error {
+    a.stopOnce.Do(func() { close(a.done) })
+    return nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "errors"
+    "net/http"
+    "strings"
+    "sync"
+)
+
+// Role is a set of permissions granted to an authenticated caller
+type Role int
+
+const (
+    // RoleRead allows fetching cache entries
+    RoleRead Role = 1 << iota
+    // RoleWrite allows setting and deleting cache entries
+    RoleWrite
+    // RoleAdmin allows privileged operations such as flushing the cache
+    RoleAdmin
+)
+
+// parseRole maps a role name to a Role
+func parseRole(name string) (Role, bool) {
+    switch strings.ToLower(name) {
+    case "read", "reader":
+        return RoleRead, true
+    case "write", "writer":
+        return RoleWrite, true
+    case "admin":
+        return RoleAdmin, true
+    }
+    return 0, false
+}
+
+// Allows reports whether the granted roles satisfy the required one; higher roles imply lower ones
+func (r Role) Allows(required Role) bool {
+    granted := r
+    if granted&RoleAdmin != 0 {
+        granted |= RoleWrite
+    }
+    if granted&RoleWrite != 0 {
+        granted |= RoleRead
+    }
+    return granted&required == required
+}
+
+// Principal identifies the caller of a request
+type Principal struct {
+    Subject string
+    Roles   Role
+    Method  string
+    Tenant  *Tenant // Set for API keys confined to a prefix
+}
+
+type principalKey struct{}
+
+// principalFrom returns the authenticated caller stored in the request context, if any
+func principalFrom(ctx context.Context) *Principal {
+    p, _ := ctx.Value(principalKey{}).(*Principal)
+    return p
+}
+
+var (
+    errNoCredentials = errors.New("no credentials")
+    errForbidden     = errors.New("insufficient role")
+    errReadOnly      = errors.New("read-only node")
+    errNoLeader      = errors.New("no raft leader")
+)
+
+// Authenticator checks API keys and JWTs presented in request headers
+type Authenticator struct {
+    mutex     sync.RWMutex // Guards keys and tenants, which a reload replaces
+    keys      [][sha256.Size]byte
+    tenants   map[[sha256.Size]byte]*Tenant
+    jwt       *JWTVerifier
+    authReads bool
+    gate      func(write bool) error
+}
+
+// NewAuthenticator creates an Authenticator accepting keys and the keys of
+// tenants; with no keys or verifier every request is allowed
+func NewAuthenticator(keys []string, tenants map[string]*Tenant, jwt *JWTVerifier, authReads bool) *Authenticator {
+    a := &Authenticator{jwt: jwt, authReads: authReads}
+    a.SetKeys(keys, tenants)
+    return a
+}
+
+// SetKeys replaces the accepted API keys and tenants; sessions already
+// authenticated keep their principal
+func (a *Authenticator) SetKeys(keys []string, tenants map[string]*Tenant) {
+    var sums [][sha256.Size]byte
+    for _, key := range keys {
+        if key = strings.TrimSpace(key); key != "" {
+            sums = append(sums, sha256.Sum256([]byte(key)))
+        }
+    }
+    byKey := make(map[[sha256.Size]byte]*Tenant, len(tenants))
+    for key, t := range tenants {
+        sum := sha256.Sum256([]byte(key))
+        sums = append(sums, sum)
+        byKey[sum] = t
+    }
+    a.mutex.Lock()
+    a.keys, a.tenants = sums, byKey
+    a.mutex.Unlock()
+}
+
+// Enabled reports whether any API keys or a JWT verifier are configured
+func (a *Authenticator) Enabled() bool {
+    a.mutex.RLock()
+    defer a.mutex.RUnlock()
+    return len(a.keys) > 0 || a.jwt != nil
+}
+
+// Valid reports whether key matches one of the configured keys in constant time
+func (a *Authenticator) Valid(key string) bool {
+    // Hashing first keeps the comparison independent of the key length
+    sum := sha256.Sum256([]byte(key))
+    match := 0
+    a.mutex.RLock()
+    defer a.mutex.RUnlock()
+    for i := range a.keys {
+        match |= subtle.ConstantTimeCompare(sum[:], a.keys[i][:])
+    }
+    return key != "" && match == 1
+}
+
+// Authenticate resolves the request credentials to a Principal
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+    if key := r.Header.Get("X-API-Key"); key != "" {
+        return a.apiKeyPrincipal(key)
+    }
+    return a.AuthenticateToken(bearerToken(r))
+}
+
+// AuthenticateToken resolves an API key or JWT, as sent by non-HTTP protocols
+func (a *Authenticator) AuthenticateToken(token string) (*Principal, error) {
+    if token == "" {
+        return nil, errNoCredentials
+    }
+    if a.Valid(token) {
+        return a.apiKeyPrincipal(token)
+    }
+    if a.jwt != nil && strings.Count(token, ".") == 2 {
+        return a.jwt.Verify(token)
+    }
+    return nil, errNoCredentials
+}
+
+// apiKeyPrincipal identifies API key callers by a short fingerprint, never the key itself
+func (a *Authenticator) apiKeyPrincipal(key string) (*Principal, error) {
+    if !a.Valid(key) {
+        return nil, errNoCredentials
+    }
+    p := &Principal{Subject: apiKeySubject(key), Roles: RoleRead | RoleWrite | RoleAdmin, Method: "api-key"}
+    a.mutex.RLock()
+    t := a.tenants[sha256.Sum256([]byte(key))]
+    a.mutex.RUnlock()
+    if t != nil {
+        p.Roles, p.Tenant = t.roles, t
+    }
+    return p, nil
+}
+
+// apiKeySubject is the fingerprint API keys are logged and audited by
+func apiKeySubject(key string) string {
+    sum := sha256.Sum256([]byte(key))
+    return "key:" + hex.EncodeToString(sum[:4])
+}
+
+// SetGate makes every operation first pass gate, which is told whether the
+// operation needs RoleWrite or RoleAdmin and returns errReadOnly or errNoLeader
+// to refuse it, as replicas and Raft followers do; call it before serving requests
+func (a *Authenticator) SetGate(gate func(write bool) error) {
+    a.gate = gate
+}
+
+// Middleware rejects requests without valid credentials (401) or the required
+// role (403), writes to a read-only node (403) and, in Raft mode, requests
+// made while there is no leader (503)
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+    next = a.guard(a.requiredRole, next)
+    if a.gate == nil {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodOptions || r.URL.Path == "/graphql" || r.URL.Path == "/rpc" {
+            next.ServeHTTP(w, r) // Operations are checked one by one by the handler
+            return
+        }
+        switch a.gate(a.requiredRole(r)&(RoleWrite|RoleAdmin) != 0) {
+        case nil:
+            next.ServeHTTP(w, r)
+        case errReadOnly:
+            writeError(w, http.StatusForbidden, codeReadOnly, readOnlyMessage)
+        default:
+            writeError(w, http.StatusServiceUnavailable, codeUnavailable, noLeaderMessage)
+        }
+    })
+}
+
+// RequireRole is like Middleware but demands the same role for every request
+func (a *Authenticator) RequireRole(role Role, next http.Handler) http.Handler {
+    return a.guard(func(*http.Request) Role { return role }, next)
+}
+
+// guard authenticates requests and checks the role returned by roleFor
+func (a *Authenticator) guard(roleFor func(*http.Request) Role, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        // Checked per request since a reload may add or remove every key
+        if !a.Enabled() {
+            next.ServeHTTP(w, r)
+            return
+        }
+        required := roleFor(r)
+        p, err := a.Authenticate(r)
+        if err != nil {
+            if required != 0 {
+                w.Header().Set("WWW-Authenticate", `Bearer realm="lru-cache"`)
+                writeError(w, http.StatusUnauthorized, codeUnauthorized, "Missing or invalid credentials")
+                return
+            }
+            next.ServeHTTP(w, r)
+            return
+        }
+        if !p.Roles.Allows(required) {
+            writeError(w, http.StatusForbidden, codeForbidden, "Insufficient role for this operation")
+            return
+        }
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+    })
+}
+
+// Authorize checks that p may perform an operation needing the required role;
+// with authentication disabled everything is allowed. It refuses tenants,
+// whose prefix only the HTTP cache endpoints enforce.
+func (a *Authenticator) Authorize(p *Principal, required Role) error {
+    if a.gate != nil {
+        if err := a.gate(required&(RoleWrite|RoleAdmin) != 0); err != nil {
+            return err
+        }
+    }
+    if !a.Enabled() || required == 0 {
+        return nil
+    }
+    if p == nil {
+        return errNoCredentials
+    }
+    if !p.Roles.Allows(required) || p.Tenant != nil {
+        return errForbidden
+    }
+    return nil
+}
+
+// ReadRole returns the role reads require: RoleRead with -auth-reads, otherwise none
+func (a *Authenticator) ReadRole() Role {
+    if a.authReads {
+        return RoleRead
+    }
+    return 0
+}
+
+// requiredRole returns the role needed for the request, or zero when it may be anonymous
+func (a *Authenticator) requiredRole(r *http.Request) Role {
+    if r.URL.Path == "/graphql" || r.URL.Path == "/rpc" {
+        return a.ReadRole() // Writes are authorized per field or method by the handler
+    }
+    switch r.Method {
+    case http.MethodOptions:
+        return 0 // CORS preflight requests never carry credentials
+    case http.MethodGet, http.MethodHead:
+        if a.authReads {
+            return RoleRead
+        }
+        return 0
+    default:
+        return RoleWrite
+    }
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) string {
+    auth := r.Header.Get("Authorization")
+    if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+        return strings.TrimSpace(auth[7:])
+    }
+    return ""
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "io"
+    "net"
+    "time"
+
+    "lru-cache/binproto"
+    "lru-cache/lrucache"
+)
+
+// newBinaryServer serves the length-prefixed protocol in package binproto
+func newBinaryServer(cfg *Config, cache lrucache.Cache, addr string, auth *Authenticator) *tcpServer {
+    return &tcpServer{
+        Addr:     addr,
+        MaxConns: cfg.MaxConns,
+        handle: func(conn net.Conn, closing func() bool) {
+            s := &binarySession{
+                cache:         cache,
+                maxValueBytes: cfg.MaxValueBytes,
+                r:             bufio.NewReader(conn),
+                w:             bufio.NewWriter(conn),
+                auth:          auth,
+                client:        connClient(conn),
+            }
+            if err := s.serve(closing); err != nil {
+                logConnError("binary", conn, err, closing)
+            }
+        },
+    }
+}
+
+// binarySession is the state of one binary protocol connection
+type binarySession struct {
+    cache         lrucache.Cache
+    maxValueBytes int
+    r             *bufio.Reader
+    w             *bufio.Writer
+    auth          *Authenticator
+    principal     *Principal
+    client        string
+    quit          bool
+}
+
+// caller identifies the client for the audit log
+func (s *binarySession) caller() Caller {
+    return newCaller("binary", s.principal, s.client)
+}
+
+// serve answers frames until the client disconnects or quits, flushing
+// only once pipelined requests have been drained
+func (s *binarySession) serve(closing func() bool) error {
+    for !s.quit && !closing() {
+        req, err := binproto.ReadFrame(s.r, s.maxValueBytes)
+        if err != nil {
+            if errors.Is(err, io.EOF) {
+                return nil
+            }
+            if errors.Is(err, binproto.ErrFrameTooLarge) {
+                s.reply(binproto.StatusTooLarge, "value exceeds the maximum size")
+                s.w.Flush()
+            }
+            return err
+        }
+        s.dispatch(req)
+        if err := flushDrained(s.r, s.w, s.quit || closing()); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (s *binarySession) reply(status byte, msg string) {
+    binproto.WriteFrame(s.w, binproto.Frame{Op: status, Value: []byte(msg)})
+}
+
+// replyCacheError replies to a write the cache refused
+func (s *binarySession) replyCacheError(err error) {
+    switch {
+    case errors.Is(err, lrucache.ErrTooLarge):
+        s.reply(binproto.StatusTooLarge, "value exceeds the maximum size")
+    case errors.Is(err, lrucache.ErrNotAdmitted):
+        s.reply(binproto.StatusNotStored, "the key is new and was not cached; the doorkeeper caches it on its next write")
+    default:
+        s.reply(binproto.StatusError, err.Error())
+    }
+}
+
+// authorize replies with an auth error and returns false when the session lacks the role
+func (s *binarySession) authorize(required Role) bool {
+    switch s.auth.Authorize(s.principal, required) {
+    case nil:
+        return true
+    case errNoCredentials:
+        s.reply(binproto.StatusUnauthorized, "authentication required")
+    case errReadOnly:
+        s.reply(binproto.StatusForbidden, "read-only node")
+    case errNoLeader:
+        s.reply(binproto.StatusError, "no raft leader")
+    default:
+        s.reply(binproto.StatusForbidden, "insufficient role for this operation")
+    }
+    return false
+}
+
+// binaryExpiration converts a frame's TTL to a cache expiration; zero never
+// expires, as binproto documents, rather than taking the cache's default TTL
+func binaryExpiration(ttl uint32) time.Duration {
+    if ttl == 0 {
+        return -1
+    }
+    return time.Duration(ttl) * time.Second
+}
+
+// dispatch executes one request frame and writes its response
+func (s *binarySession) dispatch(req binproto.Frame) {
+    key := string(req.Key)
+    expiration := binaryExpiration(req.TTL)
+    switch req.Op {
+    case binproto.OpPing:
+        s.reply(binproto.StatusOK, "")
+    case binproto.OpQuit:
+        s.reply(binproto.StatusOK, "")
+        s.quit = true
+    case binproto.OpAuth:
+        p, err := s.auth.AuthenticateToken(string(req.Value))
+        if err != nil {
+            s.reply(binproto.StatusUnauthorized, "invalid credentials")
+            return
+        }
+        s.principal = p
+        s.reply(binproto.StatusOK, "")
+    case binproto.OpGet:
+        if !s.authorize(s.auth.ReadRole()) {
+            return
+        }
+        item, err := s.cache.GetItem(key)
+        if err != nil {
+            s.reply(binproto.StatusNotFound, "")
+            return
+        }
+        var ttl uint32
+        if !item.Expiration.IsZero() {
+            ttl = uint32((time.Until(item.Expiration) + time.Second - 1) / time.Second)
+        }
+        binproto.WriteFrame(s.w, binproto.Frame{Op: binproto.StatusOK, TTL: ttl, Value: []byte(item.Value)})
+    case binproto.OpSet:
+        if !s.authorize(RoleWrite) {
+            return
+        }
+        if err := s.cache.Set(key, string(req.Value), expiration); err != nil {
+            s.replyCacheError(err)
+            return
+        }
+        audit(s.caller(), "set", key)
+        s.reply(binproto.StatusOK, "")
+    case binproto.OpDelete:
+        if !s.authorize(RoleWrite) {
+            return
+        }
+        if !s.cache.Delete(key) {
+            s.reply(binproto.StatusNotFound, "")
+            return
+        }
+        audit(s.caller(), "delete", key)
+        s.reply(binproto.StatusOK, "")
+    case binproto.OpTouch:
+        if !s.authorize(RoleWrite) {
+            return
+        }
+        if !s.cache.Touch(key, expiration) {
+            s.reply(binproto.StatusNotFound, "")
+            return
+        }
+        s.reply(binproto.StatusOK, "")
+    default:
+        s.reply(binproto.StatusBadRequest, "unknown opcode")
+    }
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "net"
+    "testing"
+    "time"
+
+    "lru-cache/binproto"
+    "lru-cache/lrucache"
+)
+
+// binaryPipe serves one binary protocol session backed by cache over an
+// in-memory connection and returns the client's end with a reader of its
+// responses
+func binaryPipe(t *testing.T, cache lrucache.Cache) (net.Conn, *bufio.Reader) {
+    t.Helper()
+    srv := newBinaryServer(&Config{MaxValueBytes: 64}, cache, "", NewAuthenticator(nil, nil, nil, false))
+    client, server := net.Pipe()
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        srv.handle(server, func() bool { return false })
+        server.Close()
+    }()
+    t.Cleanup(func() {
+        client.Close()
+        <-done
+    })
+    return client, bufio.NewReader(client)
+}
+
+// binaryFrameCall sends one frame and reads the response; the frame goes in
+// one write, since the pipe blocks on the empty write of an empty value
+func binaryFrameCall(t *testing.T, conn net.Conn, r *bufio.Reader, req binproto.Frame) binproto.Frame {
+    t.Helper()
+    var b bytes.Buffer
+    binproto.WriteFrame(&b, req)
+    if _, err := conn.Write(b.Bytes()); err != nil {
+        t.Fatalf("write: %v", err)
+    }
+    resp, err := binproto.ReadFrame(r, 1024)
+    if err != nil {
+        t.Fatalf("read: %v", err)
+    }
+    return resp
+}
+
+func TestBinaryExpiration(t *testing.T) {
+    cache := lrucache.New(lrucache.WithCapacity(10), lrucache.WithDefaultTTL(time.Minute))
+    conn, r := binaryPipe(t, cache)
+
+    tests := []struct {
+        name       string
+        ttl        uint32
+        wantNever  bool
+    }{
+        {"zero never expires", 0, true},
+        {"ttl", 60, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            set := binaryFrameCall(t, conn, r, binproto.Frame{Op: binproto.OpSet, TTL: tt.ttl, Key: []byte("k"), Value: []byte("v")})
+            if set.Op != binproto.StatusOK {
+                t.Fatalf("set = %#x %q", set.Op, set.Value)
+            }
+            get := binaryFrameCall(t, conn, r, binproto.Frame{Op: binproto.OpGet, Key: []byte("k")})
+            if get.Op != binproto.StatusOK || (get.TTL == 0) != tt.wantNever || get.TTL > 60 {
+                t.Fatalf("get = %#x with TTL %d after a set with TTL %d", get.Op, get.TTL, tt.ttl)
+            }
+        })
+    }
+
+    touch := binaryFrameCall(t, conn, r, binproto.Frame{Op: binproto.OpTouch, Key: []byte("k")})
+    if item, _ := cache.GetItem("k"); touch.Op != binproto.StatusOK || !item.Expiration.IsZero() {
+        t.Fatalf("touch with TTL 0 left expiration %v, want none", item.Expiration)
+    }
+}
+
+func TestBinaryNotAdmitted(t *testing.T) {
+    conn, r := binaryPipe(t, lrucache.New(lrucache.WithCapacity(10), lrucache.WithDoorkeeper(time.Minute)))
+    for _, want := range []byte{binproto.StatusNotStored, binproto.StatusOK} {
+        if resp := binaryFrameCall(t, conn, r, binproto.Frame{Op: binproto.OpSet, Key: []byte("k"), Value: []byte("v")}); resp.Op != want {
+            t.Fatalf("set = %#x %q, want %#x", resp.Op, resp.Value, want)
+        }
+    }
+}
+
+func TestBinaryRefusedSet(t *testing.T) {
+    conn, r := binaryPipe(t, lrucache.New(lrucache.WithCapacity(10), lrucache.WithMaxValueBytes(4)))
+    resp := binaryFrameCall(t, conn, r, binproto.Frame{Op: binproto.OpSet, Key: []byte("k"), Value: []byte("value")})
+    if resp.Op != binproto.StatusTooLarge {
+        t.Fatalf("set of a value over the cache's limit = %#x, want too large", resp.Op)
+    }
+    if resp := binaryFrameCall(t, conn, r, binproto.Frame{Op: 0x7f}); resp.Op != binproto.StatusBadRequest {
+        t.Fatalf("unknown opcode = %#x, want bad request", resp.Op)
+    }
+}
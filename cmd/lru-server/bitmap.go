@@ -0,0 +1,216 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strconv"
+
+    "lru-cache/lrucache"
+)
+
+var offsetParam = param{Name: "offset", In: "path", Description: "Bit offset, counting from the most significant bit of the first byte", Required: true}
+
+// bitmapRoutes lists the endpoints setting, reading and counting the bits of
+// a value
+func bitmapRoutes(h *handlers) []route {
+    return []route{
+        {
+            Method:  http.MethodGet,
+            Path:    "/v1/cache/{key}/bits",
+            Summary: "Count the bits set in a value; a missing key has none",
+            Handler: h.bitCountHandler,
+            Params:  []param{keyParam, consistencyParam},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The number of bits set", ContentType: "application/json", Body: BitCountResponse{}},
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/v1/cache/{key}/bits/{offset}",
+            Summary: "Get one bit of a value; bits past its end are zero",
+            Handler: h.getBitHandler,
+            Params:  []param{keyParam, offsetParam, consistencyParam},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The bit", ContentType: "application/json", Body: BitRequest{}},
+                errorResponse(http.StatusBadRequest, "Invalid offset (BAD_REQUEST)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+        {
+            Method:  http.MethodPut,
+            Path:    "/v1/cache/{key}/bits/{offset}",
+            Summary: "Set one bit of a value, growing it with zero bytes as needed; the value keeps its expiration",
+            Handler: h.setBitHandler,
+            Params:  []param{keyParam, offsetParam},
+            Body:    BitRequest{},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The bit's previous value", ContentType: "application/json", Body: BitRequest{}},
+                errorResponse(http.StatusBadRequest, "Malformed request body, invalid bit or invalid offset (BAD_REQUEST)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+                errorResponse(http.StatusRequestEntityTooLarge, "Value too large (PAYLOAD_TOO_LARGE)"),
+            },
+        },
+    }
+}
+
+// BitRequest is the body of PUT /v1/cache/{key}/bits/{offset}, and of the
+// responses carrying one bit
+type BitRequest struct {
+    Bit int `json:"bit"`
+}
+
+// BitCountResponse is the body of GET /v1/cache/{key}/bits
+type BitCountResponse struct {
+    Count int `json:"count"`
+}
+
+// maxBitOffset is the highest bit offset of a value of up to maxValueBytes
+// bytes, and lrucache.MaxBitOffset if that is lower or maxValueBytes is not
+// set
+func maxBitOffset(maxValueBytes int) int64 {
+    if maxValueBytes <= 0 {
+        return lrucache.MaxBitOffset
+    }
+    return min(int64(maxValueBytes)*8-1, lrucache.MaxBitOffset)
+}
+
+// bitOffset parses the offset path parameter, writing an error response if
+// it is malformed or would make the value longer than -max-value-bytes
+func (h *handlers) bitOffset(w http.ResponseWriter, r *http.Request) (int64, bool) {
+    limit := maxBitOffset(h.maxValueBytes)
+    offset, err := strconv.ParseInt(r.PathValue("offset"), 10, 64)
+    if err != nil || offset < 0 || offset > limit {
+        writeError(w, http.StatusBadRequest, codeBadRequest, "offset must be an integer from 0 to "+strconv.FormatInt(limit, 10))
+        return 0, false
+    }
+    return offset, true
+}
+
+// bitCountHandler handles GET /v1/cache/{key}/bits
+func (h *handlers) bitCountHandler(w http.ResponseWriter, r *http.Request) {
+    bitmaps, ok := supported[lrucache.Bitmaps](w, h.cache)
+    if !ok {
+        return
+    }
+    span := startSpan(r.Context(), "cache.bitcount")
+    n, err := bitmaps.BitCount(r.PathValue("key"))
+    span.End()
+    if err != nil {
+        writeCacheError(w, err)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(BitCountResponse{Count: n})
+}
+
+// getBitHandler handles GET /v1/cache/{key}/bits/{offset}
+func (h *handlers) getBitHandler(w http.ResponseWriter, r *http.Request) {
+    bitmaps, ok := supported[lrucache.Bitmaps](w, h.cache)
+    if !ok {
+        return
+    }
+    offset, ok := h.bitOffset(w, r)
+    if !ok {
+        return
+    }
+    span := startSpan(r.Context(), "cache.getbit")
+    bit, err := bitmaps.GetBit(r.PathValue("key"), offset)
+    span.End()
+    if err != nil {
+        writeCacheError(w, err)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(BitRequest{Bit: bit})
+}
+
+// setBitHandler handles PUT /v1/cache/{key}/bits/{offset}
+func (h *handlers) setBitHandler(w http.ResponseWriter, r *http.Request) {
+    bitmaps, ok := supported[lrucache.Bitmaps](w, h.cache)
+    if !ok {
+        return
+    }
+    offset, ok := h.bitOffset(w, r)
+    if !ok {
+        return
+    }
+    var req BitRequest
+    if !h.decodeJSON(w, r, &req) {
+        return
+    }
+
+    span := startSpan(r.Context(), "cache.setbit")
+    old, err := bitmaps.SetBit(r.PathValue("key"), offset, req.Bit)
+    span.End()
+    if err != nil {
+        writeCacheError(w, err)
+        return
+    }
+    audit(httpCaller("http", r), "setbit", r.PathValue("key"))
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(BitRequest{Bit: old})
+}
+
+// bitmap implements SETBIT key offset value, GETBIT key offset and BITCOUNT
+// key
+func (s *respSession) bitmap(cmd string, args []string) {
+    switch {
+    case cmd == "SETBIT" && len(args) != 3,
+        cmd == "GETBIT" && len(args) != 2,
+        cmd == "BITCOUNT" && len(args) != 1:
+        s.writeWrongArgs(cmd)
+        return
+    }
+    key := args[0]
+    bitmaps, ok := respSupported[lrucache.Bitmaps](s)
+    if !ok {
+        return
+    }
+    var offset int64
+    if cmd != "BITCOUNT" {
+        var err error
+        if offset, err = strconv.ParseInt(args[1], 10, 64); err != nil || offset < 0 || offset > maxBitOffset(s.maxValueBytes) {
+            s.writeError("ERR bit offset is not an integer or out of range")
+            return
+        }
+    }
+    switch cmd {
+    case "SETBIT":
+        if !s.allowed(RoleWrite) {
+            return
+        }
+        bit, err := strconv.Atoi(args[2])
+        if err != nil || (bit != 0 && bit != 1) {
+            s.writeError("ERR bit is not an integer or out of range")
+            return
+        }
+        old, err := bitmaps.SetBit(key, offset, bit)
+        if err != nil {
+            s.writeCacheError(err)
+            return
+        }
+        audit(s.caller(), "setbit", key)
+        s.writeInt(int64(old))
+    case "GETBIT":
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        bit, err := bitmaps.GetBit(key, offset)
+        if err != nil {
+            s.writeCacheError(err)
+            return
+        }
+        s.writeInt(int64(bit))
+    case "BITCOUNT":
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        n, err := bitmaps.BitCount(key)
+        if err != nil {
+            s.writeCacheError(err)
+            return
+        }
+        s.writeInt(int64(n))
+    }
+}
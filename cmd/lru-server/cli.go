@@ -0,0 +1,321 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "math/rand/v2"
+    "net/http"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "sync"
+    "time"
+
+    "lru-cache/client"
+)
+
+// command is a subcommand of the binary, named by its first argument
+type command struct {
+    name    string
+    summary string
+    run     func(args []string) error
+}
+
+// commands are run as lru-server <name> [flags]; with a flag or nothing as
+// the first argument the binary serves, as before there were commands
+var commands = []command{
+    {"serve", "run the cache server; the default when the first argument is a flag", func(args []string) error { serve(args); return nil }},
+    {"snapshot", "write every entry of a running server to a file, through its admin listener", snapshotCommand},
+    {"restore", "load a file written by snapshot into a running server", restoreCommand},
+    {"bench", "measure the throughput and latency of a running server with a mix of reads and writes", benchCommand},
+    {"client", "get, set, delete and list keys, show stats or flush a running server; also run as lru-cli", clientCommand},
+}
+
+// Environment variables locating the server for the commands talking to one;
+// the server itself ignores them
+const (
+    envURL      = envPrefix + "URL"
+    envAdminURL = envPrefix + "ADMIN_URL"
+    envAPIKey   = envPrefix + "API_KEY"
+)
+
+// runCommand runs the command name with args and returns the exit status
+func runCommand(name string, args []string) int {
+    if name == "help" {
+        commandUsage(os.Stdout)
+        return 0
+    }
+    i := slices.IndexFunc(commands, func(c command) bool { return c.name == name })
+    if i < 0 {
+        fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+        commandUsage(os.Stderr)
+        return 2
+    }
+    if err := commands[i].run(args); err != nil {
+        fmt.Fprintf(os.Stderr, "lru-server %s: %v\n", name, err)
+        return 1
+    }
+    return 0
+}
+
+// commandUsage lists the commands
+func commandUsage(w io.Writer) {
+    fmt.Fprintln(w, "Usage: lru-server [command] [flags]")
+    fmt.Fprintln(w)
+    fmt.Fprintln(w, "Commands:")
+    for _, c := range commands {
+        fmt.Fprintf(w, "  %-9s %s\n", c.name, c.summary)
+    }
+    fmt.Fprintln(w)
+    fmt.Fprintln(w, "Run lru-server <command> -h for the flags of a command.")
+}
+
+// newCommandFlags creates the flag set of the command name, whose usage
+// shows the positional arguments in synopsis
+func newCommandFlags(name, synopsis string) *flag.FlagSet {
+    fs := flag.NewFlagSet("lru-server "+name, flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintf(fs.Output(), "Usage: lru-server %s [flags] %s\n", name, synopsis)
+        fs.PrintDefaults()
+    }
+    return fs
+}
+
+// apiFlags adds -url and -api-key, locating the HTTP API of a server
+func apiFlags(fs *flag.FlagSet) (url, apiKey *string) {
+    url = fs.String("url", envOr(envURL, "http://127.0.0.1:8080"), "base URL of the server's HTTP API; $"+envURL+" overrides the default")
+    apiKey = fs.String("api-key", os.Getenv(envAPIKey), "API key or JWT sent with every request (defaults to $"+envAPIKey+")")
+    return url, apiKey
+}
+
+// adminFlags adds -admin-url and -api-key, locating the admin listener of a
+// server
+func adminFlags(fs *flag.FlagSet) (url, apiKey *string) {
+    url = adminURLFlag(fs)
+    apiKey = fs.String("api-key", os.Getenv(envAPIKey), "API key or JWT with the admin role (defaults to $"+envAPIKey+")")
+    return url, apiKey
+}
+
+// adminURLFlag adds -admin-url alone, for commands that also take apiFlags
+func adminURLFlag(fs *flag.FlagSet) *string {
+    return fs.String("admin-url", envOr(envAdminURL, "http://127.0.0.1:8081"), "base URL of the server's admin listener; $"+envAdminURL+" overrides the default")
+}
+
+// adminRequest sends a request to the admin listener at base and returns
+// the response of a 2xx answer, or the error the server answered with
+func adminRequest(base, apiKey, method, path string, body []byte) (*http.Response, error) {
+    req, err := http.NewRequest(method, strings.TrimSuffix(base, "/")+path, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    if apiKey != "" {
+        req.Header.Set("Authorization", "Bearer "+apiKey)
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode/100 == 2 {
+        return resp, nil
+    }
+    defer resp.Body.Close()
+    var envelope ErrorResponse
+    if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&envelope) != nil || envelope.Error.Message == "" {
+        return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+    }
+    return nil, fmt.Errorf("%s %s: %s: %s", method, path, envelope.Error.Code, envelope.Error.Message)
+}
+
+// snapshotCommand implements lru-server snapshot
+func snapshotCommand(args []string) error {
+    fs := newCommandFlags("snapshot", "")
+    admin, apiKey := adminFlags(fs)
+    out := fs.String("o", "snapshot.json", "file to write, replaced once the whole snapshot arrived; - writes to stdout")
+    fs.Parse(args)
+    if fs.NArg() > 0 {
+        fs.Usage()
+        os.Exit(2)
+    }
+
+    resp, err := adminRequest(*admin, *apiKey, http.MethodGet, "/admin/export", nil)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return err
+    }
+    var entries []json.RawMessage
+    if err := json.Unmarshal(data, &entries); err != nil {
+        return fmt.Errorf("malformed export: %w", err)
+    }
+    if *out == "-" {
+        _, err = os.Stdout.Write(data)
+        return err
+    }
+    // Write a copy and rename it over the file so that a failure leaves the old one
+    tmp, err := os.CreateTemp(filepath.Dir(*out), filepath.Base(*out)+".*.tmp")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    if err := os.Rename(tmp.Name(), *out); err != nil {
+        return err
+    }
+    fmt.Fprintf(os.Stderr, "wrote %d entries to %s\n", len(entries), *out)
+    return nil
+}
+
+// restoreCommand implements lru-server restore
+func restoreCommand(args []string) error {
+    fs := newCommandFlags("restore", "FILE")
+    admin, apiKey := adminFlags(fs)
+    fs.Parse(args)
+    if fs.NArg() != 1 {
+        fs.Usage()
+        os.Exit(2)
+    }
+
+    var data []byte
+    var err error
+    if path := fs.Arg(0); path == "-" {
+        data, err = io.ReadAll(os.Stdin)
+    } else {
+        data, err = os.ReadFile(path)
+    }
+    if err != nil {
+        return err
+    }
+    var entries []json.RawMessage
+    if err := json.Unmarshal(data, &entries); err != nil {
+        return fmt.Errorf("%s: not a snapshot: %w", fs.Arg(0), err)
+    }
+    resp, err := adminRequest(*admin, *apiKey, http.MethodPost, "/admin/import", data)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    var count CountResponse
+    if err := json.NewDecoder(resp.Body).Decode(&count); err != nil {
+        return err
+    }
+    fmt.Fprintf(os.Stderr, "imported %d of %d entries; the others had expired\n", count.Count, len(entries))
+    return nil
+}
+
+// benchResult is what one bench worker measured
+type benchResult struct {
+    latencies            []time.Duration
+    hits, misses, writes int
+    errors               int
+    lastErr              error
+}
+
+// benchCommand implements lru-server bench
+func benchCommand(args []string) error {
+    fs := newCommandFlags("bench", "")
+    url, apiKey := apiFlags(fs)
+    duration := fs.Duration("duration", 10*time.Second, "how long to send requests")
+    concurrency := fs.Int("concurrency", 16, "requests in flight at once")
+    keys := fs.Int("keys", 10000, "distinct keys read and written, chosen uniformly")
+    valueSize := fs.Int("value-size", 100, "bytes of each value written")
+    reads := fs.Float64("reads", 0.9, "fraction of requests that are reads; the others are writes")
+    ttl := fs.Duration("ttl", 0, "expiration of the values written (0 never expires)")
+    fs.Parse(args)
+    if fs.NArg() > 0 || *duration <= 0 || *concurrency <= 0 || *keys <= 0 || *valueSize < 0 || *reads < 0 || *reads > 1 {
+        fs.Usage()
+        os.Exit(2)
+    }
+
+    c, err := client.NewHTTPClient(*url, client.WithToken(*apiKey))
+    if err != nil {
+        return err
+    }
+    defer c.Close()
+    value := bytes.Repeat([]byte("x"), *valueSize)
+    ctx, cancel := context.WithTimeout(context.Background(), *duration)
+    defer cancel()
+
+    results := make([]benchResult, *concurrency)
+    started := time.Now()
+    var wg sync.WaitGroup
+    for i := range results {
+        wg.Add(1)
+        go func(res *benchResult) {
+            defer wg.Done()
+            rng := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+            for ctx.Err() == nil {
+                key := fmt.Sprintf("bench:%d", rng.IntN(*keys))
+                start := time.Now()
+                var err error
+                read := rng.Float64() < *reads
+                if read {
+                    _, err = c.Get(ctx, key)
+                } else {
+                    err = c.Set(ctx, key, value, *ttl)
+                }
+                if ctx.Err() != nil {
+                    return // Cut short by the end of the run
+                }
+                res.latencies = append(res.latencies, time.Since(start))
+                switch {
+                case errors.Is(err, client.ErrNotFound):
+                    res.misses++
+                case err != nil:
+                    res.errors++
+                    res.lastErr = err
+                case read:
+                    res.hits++
+                default:
+                    res.writes++
+                }
+            }
+        }(&results[i])
+    }
+    wg.Wait()
+    elapsed := time.Since(started)
+
+    var total benchResult
+    for _, res := range results {
+        total.latencies = append(total.latencies, res.latencies...)
+        total.hits += res.hits
+        total.misses += res.misses
+        total.writes += res.writes
+        total.errors += res.errors
+        if res.lastErr != nil {
+            total.lastErr = res.lastErr
+        }
+    }
+    n := len(total.latencies)
+    if n == 0 {
+        return errors.New("no request completed")
+    }
+    slices.Sort(total.latencies)
+    percentile := func(p float64) time.Duration { return total.latencies[min(n-1, int(p*float64(n)))] }
+    fmt.Printf("%d requests in %s: %.0f requests/s\n", n, elapsed.Round(time.Millisecond), float64(n)/elapsed.Seconds())
+    fmt.Printf("reads:   %d hits, %d misses\n", total.hits, total.misses)
+    fmt.Printf("writes:  %d\n", total.writes)
+    fmt.Printf("errors:  %d\n", total.errors)
+    fmt.Printf("latency: p50 %s, p90 %s, p99 %s, max %s\n", percentile(0.5), percentile(0.9), percentile(0.99), total.latencies[n-1])
+    if total.lastErr != nil {
+        fmt.Printf("last error: %v\n", total.lastErr)
+    }
+    return nil
+}
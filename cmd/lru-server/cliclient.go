@@ -0,0 +1,291 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "text/tabwriter"
+    "time"
+
+    "lru-cache/client"
+    "lru-cache/lrucache"
+)
+
+// cliName is the name the binary runs the client command under, as when
+// installed as a link to lru-server
+const cliName = "lru-cli"
+
+// clientVerb is one verb of the client command, given as its first argument
+type clientVerb struct {
+    name     string
+    synopsis string // Of the arguments
+    summary  string
+    minArgs  int
+    maxArgs  int // Negative for no limit
+    run      func(s *clientSession, args []string) error
+}
+
+// clientVerbs are the verbs of lru-server client
+var clientVerbs = []clientVerb{
+    {"get", "KEY", "print the value of KEY", 1, 1, (*clientSession).get},
+    {"set", "KEY VALUE", "set KEY to VALUE, expiring after -ttl", 2, 2, (*clientSession).set},
+    {"del", "KEY...", "delete the keys and print how many existed", 1, -1, (*clientSession).del},
+    {"keys", "[PATTERN]", "list the keys matching PATTERN, where * matches any run of characters, most recently used first", 0, 1, (*clientSession).keys},
+    {"stats", "", "show the hit ratio, size and eviction counters", 0, 0, (*clientSession).stats},
+    {"flush", "", "delete every key", 0, 0, (*clientSession).flush},
+}
+
+// clientAliases are older names of verbs
+var clientAliases = map[string]string{"delete": "del"}
+
+// clientSession holds what the verbs need to reach the server and print
+type clientSession struct {
+    api     *client.HTTPClient
+    admin   string // Base URL of the admin listener, for keys, stats and flush
+    apiKey  string
+    format  string // table or json
+    pretty  bool   // Indent JSON values read by get, as the REPL does
+    ttl     time.Duration
+    limit   int
+    timeout time.Duration
+    out     io.Writer
+}
+
+// lookupVerb returns the verb named name or one of its aliases
+func lookupVerb(name string) (clientVerb, bool) {
+    if alias, ok := clientAliases[name]; ok {
+        name = alias
+    }
+    i := slices.IndexFunc(clientVerbs, func(v clientVerb) bool { return v.name == name })
+    if i < 0 {
+        return clientVerb{}, false
+    }
+    return clientVerbs[i], true
+}
+
+// clientCommand implements lru-server client
+func clientCommand(args []string) error {
+    fs := newCommandFlags("client", "VERB [ARGS] | repl")
+    url, apiKey := apiFlags(fs)
+    admin := adminURLFlag(fs)
+    format := fs.String("format", "table", "output as a table or json")
+    ttl := fs.Duration("ttl", 0, "expiration of a set (0 never expires)")
+    limit := fs.Int("limit", 1000, "most keys listed by keys (0 lists all)")
+    timeout := fs.Duration("timeout", 5*time.Second, "limit on each request")
+    usage := fs.Usage
+    fs.Usage = func() {
+        usage()
+        clientVerbUsage(fs.Output())
+    }
+    fs.Parse(args)
+    verb, ok := lookupVerb(fs.Arg(0))
+    rest := fs.Args()[min(1, fs.NArg()):]
+    repl := fs.Arg(0) == "repl" && len(rest) == 0
+    if !repl && !ok || len(rest) < verb.minArgs || (verb.maxArgs >= 0 && len(rest) > verb.maxArgs) {
+        fs.Usage()
+        os.Exit(2)
+    }
+    if *format != "table" && *format != "json" {
+        return errors.New("-format must be table or json")
+    }
+
+    s, err := newClientSession(*url, *admin, *apiKey, *timeout)
+    if err != nil {
+        return err
+    }
+    defer s.api.Close()
+    s.format, s.ttl, s.limit = *format, *ttl, *limit
+    if repl {
+        return s.repl(*url)
+    }
+    return verb.run(s, rest)
+}
+
+// clientVerbUsage lists the verbs
+func clientVerbUsage(w io.Writer) {
+    fmt.Fprintln(w, "\nVerbs:")
+    for _, v := range clientVerbs {
+        fmt.Fprintf(w, "  %-18s %s\n", strings.TrimSpace(v.name+" "+v.synopsis), v.summary)
+    }
+    fmt.Fprintf(w, "  %-18s %s\n", "repl", "read verbs from an interactive prompt with history and tab completion")
+}
+
+// newClientSession connects to the HTTP API at apiURL and the admin listener at adminURL
+func newClientSession(apiURL, adminURL, apiKey string, timeout time.Duration) (*clientSession, error) {
+    c, err := client.NewHTTPClient(apiURL, client.WithToken(apiKey), client.WithTimeout(timeout))
+    if err != nil {
+        return nil, err
+    }
+    return &clientSession{api: c, admin: adminURL, apiKey: apiKey, format: "table", timeout: timeout, limit: 1000, out: os.Stdout}, nil
+}
+
+// print writes v as indented JSON, or calls table with a writer aligning
+// tab-separated columns
+func (s *clientSession) print(v interface{}, table func(w io.Writer)) error {
+    if s.format == "json" {
+        enc := json.NewEncoder(s.out)
+        enc.SetIndent("", "  ")
+        return enc.Encode(v)
+    }
+    tw := tabwriter.NewWriter(s.out, 0, 4, 2, ' ', 0)
+    table(tw)
+    return tw.Flush()
+}
+
+// adminJSON sends a request to the admin listener and decodes its answer into v
+func (s *clientSession) adminJSON(method, path string, v interface{}) error {
+    resp, err := adminRequest(s.admin, s.apiKey, method, path, nil)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (s *clientSession) context() (context.Context, context.CancelFunc) {
+    return context.WithTimeout(context.Background(), s.timeout)
+}
+
+func (s *clientSession) get(args []string) error {
+    ctx, cancel := s.context()
+    defer cancel()
+    value, err := s.api.Get(ctx, args[0])
+    if errors.Is(err, client.ErrNotFound) {
+        return fmt.Errorf("key %q not found", args[0])
+    }
+    if err != nil {
+        return err
+    }
+    if s.format == "json" {
+        return s.print(map[string]string{"key": args[0], "value": string(value)}, nil)
+    }
+    if s.pretty {
+        return s.printValue(value)
+    }
+    // The value as stored, so that it can be piped into a file
+    _, err = s.out.Write(value)
+    return err
+}
+
+func (s *clientSession) set(args []string) error {
+    ctx, cancel := s.context()
+    defer cancel()
+    if err := s.api.Set(ctx, args[0], []byte(args[1]), s.ttl); err != nil {
+        return err
+    }
+    if s.format == "json" {
+        return s.print(map[string]interface{}{"key": args[0], "stored": true}, nil)
+    }
+    return nil
+}
+
+func (s *clientSession) del(args []string) error {
+    ctx, cancel := s.context()
+    defer cancel()
+    deleted, err := s.api.DeleteMulti(ctx, args)
+    if err != nil {
+        return err
+    }
+    return s.print(map[string]int{"deleted": deleted}, func(w io.Writer) {
+        fmt.Fprintf(w, "%d deleted\n", deleted)
+    })
+}
+
+// KeyInfo describes one key listed by lru-server client keys
+type KeyInfo struct {
+    Key   string `json:"key"`
+    Kind  string `json:"kind"` // string, hash, list, set, zset or hll
+    TTL   int    `json:"ttl"`  // Seconds left; -1 never expires
+    Bytes int64  `json:"bytes"`
+}
+
+func (s *clientSession) keys(args []string) error {
+    query := url.Values{"limit": {fmt.Sprint(s.limit)}, "truncate": {"1"}}
+    if len(args) > 0 {
+        query.Set("match", args[0])
+    }
+    var resp DebugLRUResponse
+    if err := s.adminJSON(http.MethodGet, "/debug/lru?"+query.Encode(), &resp); err != nil {
+        return err
+    }
+    keys := make([]KeyInfo, 0, len(resp.Order))
+    for _, e := range resp.Order {
+        kind := string(e.Kind)
+        if e.Kind == lrucache.KindString {
+            kind = "string"
+        }
+        keys = append(keys, KeyInfo{Key: e.Key, Kind: kind, TTL: e.TTL, Bytes: e.Bytes})
+    }
+    return s.print(keys, func(w io.Writer) {
+        fmt.Fprintln(w, "KEY\tTYPE\tTTL\tSIZE")
+        for _, k := range keys {
+            ttl := "-"
+            if k.TTL >= 0 {
+                ttl = (time.Duration(k.TTL) * time.Second).String()
+            }
+            fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", k.Key, k.Kind, ttl, k.Bytes)
+        }
+    })
+}
+
+// ClientStats is printed by lru-server client stats
+type ClientStats struct {
+    lrucache.CacheStats
+    HitRatio float64 `json:"HitRatio"`
+    Uptime   int64   `json:"UptimeSeconds"`
+}
+
+func (s *clientSession) stats(args []string) error {
+    var vars struct {
+        Cache  lrucache.CacheStats `json:"cache"`
+        Uptime int64               `json:"uptime_seconds"`
+    }
+    if err := s.adminJSON(http.MethodGet, "/debug/vars", &vars); err != nil {
+        return err
+    }
+    stats := ClientStats{CacheStats: vars.Cache, Uptime: vars.Uptime}
+    if lookups := stats.Hits + stats.Misses; lookups > 0 {
+        stats.HitRatio = float64(stats.Hits) / float64(lookups)
+    }
+    return s.print(stats, func(w io.Writer) {
+        rows := []struct {
+            name  string
+            value interface{}
+        }{
+            {"hit ratio", fmt.Sprintf("%.1f%%", 100*stats.HitRatio)},
+            {"hits", stats.Hits},
+            {"misses", stats.Misses},
+            {"entries", fmt.Sprintf("%d of %d", stats.Entries, stats.Capacity)},
+            {"memory", fmt.Sprintf("%d bytes", stats.Bytes)},
+            {"evictions", stats.Evictions},
+            {"expirations", stats.Expirations},
+            {"uptime", (time.Duration(stats.Uptime) * time.Second).String()},
+        }
+        for _, r := range rows {
+            fmt.Fprintf(w, "%s\t%v\n", r.name, r.value)
+        }
+    })
+}
+
+func (s *clientSession) flush(args []string) error {
+    var count CountResponse
+    if err := s.adminJSON(http.MethodPost, "/admin/flush", &count); err != nil {
+        return err
+    }
+    return s.print(count, func(w io.Writer) {
+        fmt.Fprintf(w, "%d deleted\n", count.Count)
+    })
+}
+
+// runAsCLI reports whether the binary was started under cliName
+func runAsCLI(arg0 string) bool {
+    return strings.TrimSuffix(filepath.Base(arg0), ".exe") == cliName
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "time"
+    "unicode"
+    "unicode/utf8"
+)
+
+// Bounds of the REPL history, kept in historyFile in the home directory
+const (
+    historyFile = ".lru_cli_history"
+    historyMax  = 1000
+)
+
+// replCommands are the words the REPL understands besides the client verbs
+var replCommands = []string{"help", "format", "ttl", "exit"}
+
+// errQuit ends the REPL
+var errQuit = errors.New("quit")
+
+// repl reads commands from stdin until exit or end of input, editing lines
+// with history and tab completion when stdin is a terminal
+func (s *clientSession) repl(apiURL string) error {
+    s.pretty = true
+    var lines lineReader
+    if isTerminal(int(os.Stdin.Fd())) {
+        editor := newLineEditor(os.Stdin, os.Stdout, "lru "+strings.TrimPrefix(strings.TrimPrefix(apiURL, "http://"), "https://")+"> ")
+        editor.complete = completeREPL
+        editor.loadHistory()
+        defer editor.saveHistory()
+        lines = editor
+        fmt.Fprintln(s.out, "Connected to", apiURL+"; type help for the commands, tab to complete them.")
+    } else {
+        lines = &plainReader{bufio.NewScanner(os.Stdin)}
+    }
+    for {
+        line, err := lines.ReadLine()
+        if err == io.EOF {
+            return nil
+        } else if err != nil {
+            return err
+        }
+        args, err := splitWords(line)
+        if err != nil {
+            fmt.Fprintln(s.out, "(error)", err)
+            continue
+        }
+        if len(args) == 0 {
+            continue
+        }
+        if err := s.replRun(args); err == errQuit {
+            return nil
+        } else if err != nil {
+            fmt.Fprintln(s.out, "(error)", err)
+        }
+    }
+}
+
+// replRun runs one command of the REPL
+func (s *clientSession) replRun(args []string) error {
+    name, rest := strings.ToLower(args[0]), args[1:]
+    switch name {
+    case "exit", "quit":
+        return errQuit
+    case "help", "?":
+        fmt.Fprintln(s.out, "Commands:")
+        for _, v := range clientVerbs {
+            fmt.Fprintf(s.out, "  %-18s %s\n", strings.TrimSpace(v.name+" "+v.synopsis), v.summary)
+        }
+        fmt.Fprintf(s.out, "  %-18s %s\n", "format table|json", "print results as tables or as JSON")
+        fmt.Fprintf(s.out, "  %-18s %s\n", "ttl DURATION", "expiration of later sets, such as 10m (0 never expires)")
+        fmt.Fprintf(s.out, "  %-18s %s\n", "exit", "leave; so does Ctrl-D")
+        return nil
+    case "format":
+        if len(rest) != 1 || (rest[0] != "table" && rest[0] != "json") {
+            return fmt.Errorf("format is %s; give table or json to change it", s.format)
+        }
+        s.format = rest[0]
+        return nil
+    case "ttl":
+        if len(rest) != 1 {
+            return fmt.Errorf("ttl is %s; give a duration such as 10m to change it", s.ttl)
+        }
+        ttl, err := time.ParseDuration(rest[0])
+        if err != nil || ttl < 0 {
+            return fmt.Errorf("invalid duration %q", rest[0])
+        }
+        s.ttl = ttl
+        return nil
+    }
+    verb, ok := lookupVerb(name)
+    if !ok {
+        return fmt.Errorf("unknown command %q; type help for the commands", args[0])
+    }
+    if len(rest) < verb.minArgs || (verb.maxArgs >= 0 && len(rest) > verb.maxArgs) {
+        return fmt.Errorf("usage: %s", strings.TrimSpace(verb.name+" "+verb.synopsis))
+    }
+    return verb.run(s, rest)
+}
+
+// printValue writes a value read by get: JSON indented when pretty, and
+// always ending in a newline
+func (s *clientSession) printValue(value []byte) error {
+    var indented bytes.Buffer
+    if json.Valid(value) && (bytes.HasPrefix(value, []byte("{")) || bytes.HasPrefix(value, []byte("["))) && json.Indent(&indented, value, "", "  ") == nil {
+        value = indented.Bytes()
+    }
+    if _, err := s.out.Write(value); err != nil {
+        return err
+    }
+    if !bytes.HasSuffix(value, []byte("\n")) {
+        fmt.Fprintln(s.out)
+    }
+    return nil
+}
+
+// completeREPL completes the word being typed at the start of line
+func completeREPL(line string) []string {
+    words := strings.Fields(line)
+    typing := len(words) == 0 || !strings.HasSuffix(line, " ")
+    var candidates []string
+    switch {
+    case len(words) == 0 || (len(words) == 1 && typing):
+        for _, v := range clientVerbs {
+            candidates = append(candidates, v.name)
+        }
+        candidates = append(candidates, replCommands...)
+    case words[0] == "format" && (len(words) == 1 || (len(words) == 2 && typing)):
+        candidates = []string{"table", "json"}
+    default:
+        return nil
+    }
+    prefix := ""
+    if typing && len(words) > 0 {
+        prefix = words[len(words)-1]
+    }
+    var matches []string
+    for _, c := range candidates {
+        if strings.HasPrefix(c, strings.ToLower(prefix)) {
+            matches = append(matches, c)
+        }
+    }
+    return matches
+}
+
+// splitWords splits line into words at spaces, as a shell does: quotes keep
+// spaces within a word and a backslash makes the next character literal
+func splitWords(line string) ([]string, error) {
+    var words []string
+    var word strings.Builder
+    inWord, quote := false, rune(0)
+    runes := []rune(line)
+    for i := 0; i < len(runes); i++ {
+        r := runes[i]
+        switch {
+        case r == '\\' && quote != '\'':
+            if i+1 == len(runes) {
+                return nil, errors.New("trailing backslash")
+            }
+            i++
+            word.WriteRune(runes[i])
+            inWord = true
+        case quote != 0 && r == quote:
+            quote = 0
+        case quote != 0:
+            word.WriteRune(r)
+        case r == '"' || r == '\'':
+            quote, inWord = r, true
+        case unicode.IsSpace(r):
+            if inWord {
+                words = append(words, word.String())
+                word.Reset()
+                inWord = false
+            }
+        default:
+            word.WriteRune(r)
+            inWord = true
+        }
+    }
+    if quote != 0 {
+        return nil, fmt.Errorf("unterminated %c quote", quote)
+    }
+    if inWord {
+        words = append(words, word.String())
+    }
+    return words, nil
+}
+
+// lineReader reads the REPL's input a line at a time
+type lineReader interface {
+    ReadLine() (string, error)
+}
+
+// plainReader reads lines from a pipe or file, without prompts
+type plainReader struct {
+    scanner *bufio.Scanner
+}
+
+func (p *plainReader) ReadLine() (string, error) {
+    if p.scanner.Scan() {
+        return p.scanner.Text(), nil
+    }
+    if err := p.scanner.Err(); err != nil {
+        return "", err
+    }
+    return "", io.EOF
+}
+
+// lineEditor reads lines from a terminal in raw mode, with cursor movement,
+// history on the up and down arrows and completion on tab
+type lineEditor struct {
+    in       *os.File
+    out      io.Writer
+    prompt   string
+    complete func(line string) []string
+    history  []string
+    keys     *bufio.Reader
+
+    buf []rune // Line being edited
+    pos int    // Cursor position in buf
+}
+
+func newLineEditor(in *os.File, out io.Writer, prompt string) *lineEditor {
+    return &lineEditor{in: in, out: out, prompt: prompt, keys: bufio.NewReader(in)}
+}
+
+// historyPath returns the file history is kept in, or "" without a home directory
+func historyPath() string {
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return ""
+    }
+    return filepath.Join(home, historyFile)
+}
+
+// loadHistory reads the history of earlier sessions, if any
+func (e *lineEditor) loadHistory() {
+    data, err := os.ReadFile(historyPath())
+    if err != nil {
+        return
+    }
+    for _, line := range strings.Split(string(data), "\n") {
+        if line != "" {
+            e.history = append(e.history, line)
+        }
+    }
+    e.history = e.history[max(0, len(e.history)-historyMax):]
+}
+
+// saveHistory writes the history for later sessions; it may hold values, so
+// only the user can read it
+func (e *lineEditor) saveHistory() {
+    if path := historyPath(); path != "" && len(e.history) > 0 {
+        os.WriteFile(path, []byte(strings.Join(e.history, "\n")+"\n"), 0o600)
+    }
+}
+
+// ReadLine reads a line, returning io.EOF on Ctrl-D at an empty line
+func (e *lineEditor) ReadLine() (string, error) {
+    restore, err := makeRaw(int(e.in.Fd()))
+    if err != nil {
+        return "", err
+    }
+    defer restore()
+    e.buf, e.pos = e.buf[:0], 0
+    browsing := len(e.history) // Index of the history line shown; len(history) is the new line
+    draft := ""                // The new line, kept while browsing history
+    e.redraw()
+    for {
+        r, _, err := e.keys.ReadRune()
+        if err != nil {
+            return "", err
+        }
+        switch r {
+        case '\r', '\n':
+            fmt.Fprint(e.out, "\r\n")
+            line := string(e.buf)
+            if strings.TrimSpace(line) != "" && (len(e.history) == 0 || e.history[len(e.history)-1] != line) {
+                e.history = append(e.history, line)
+                e.history = e.history[max(0, len(e.history)-historyMax):]
+            }
+            return line, nil
+        case 4: // Ctrl-D
+            if len(e.buf) == 0 {
+                fmt.Fprint(e.out, "\r\n")
+                return "", io.EOF
+            }
+            e.deleteAt(e.pos)
+        case 3: // Ctrl-C abandons the line
+            fmt.Fprint(e.out, "^C\r\n")
+            e.buf, e.pos = e.buf[:0], 0
+            browsing = len(e.history)
+        case 127, 8: // Backspace
+            if e.pos > 0 {
+                e.pos--
+                e.deleteAt(e.pos)
+            }
+        case '\t':
+            e.completeWord()
+        case 1: // Ctrl-A
+            e.pos = 0
+        case 5: // Ctrl-E
+            e.pos = len(e.buf)
+        case 21: // Ctrl-U deletes to the start of the line
+            e.buf, e.pos = slices.Delete(e.buf, 0, e.pos), 0
+        case 23: // Ctrl-W deletes the word before the cursor
+            start := e.pos
+            for start > 0 && e.buf[start-1] == ' ' {
+                start--
+            }
+            for start > 0 && e.buf[start-1] != ' ' {
+                start--
+            }
+            e.buf, e.pos = slices.Delete(e.buf, start, e.pos), start
+        case 12: // Ctrl-L clears the screen
+            fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+        case 27:
+            switch e.escape() {
+            case 'A':
+                if browsing > 0 {
+                    if browsing == len(e.history) {
+                        draft = string(e.buf)
+                    }
+                    browsing--
+                    e.setLine(e.history[browsing])
+                }
+            case 'B':
+                if browsing < len(e.history) {
+                    browsing++
+                    if browsing == len(e.history) {
+                        e.setLine(draft)
+                    } else {
+                        e.setLine(e.history[browsing])
+                    }
+                }
+            case 'C':
+                e.pos = min(e.pos+1, len(e.buf))
+            case 'D':
+                e.pos = max(e.pos-1, 0)
+            case 'H':
+                e.pos = 0
+            case 'F':
+                e.pos = len(e.buf)
+            case '~':
+                e.deleteAt(e.pos)
+            }
+        default:
+            if unicode.IsPrint(r) {
+                e.buf = slices.Insert(e.buf, e.pos, r)
+                e.pos++
+            }
+        }
+        e.redraw()
+    }
+}
+
+// escape reads the rest of an escape sequence and returns its final
+// character: A to D for the arrows, H and F for Home and End and ~ for Delete
+func (e *lineEditor) escape() rune {
+    first, _, err := e.keys.ReadRune()
+    if err != nil || (first != '[' && first != 'O') {
+        return 0
+    }
+    var params []rune
+    for {
+        r, _, err := e.keys.ReadRune()
+        if err != nil {
+            return 0
+        }
+        if r >= '0' && r <= '9' || r == ';' {
+            params = append(params, r)
+            continue
+        }
+        switch p := string(params); {
+        case r == '~' && (p == "1" || p == "7"):
+            return 'H'
+        case r == '~' && (p == "4" || p == "8"):
+            return 'F'
+        case r == '~' && p != "3":
+            return 0
+        }
+        return r
+    }
+}
+
+// deleteAt removes the character at i, if any
+func (e *lineEditor) deleteAt(i int) {
+    if i < len(e.buf) {
+        e.buf = slices.Delete(e.buf, i, i+1)
+    }
+}
+
+// setLine replaces the line being edited, with the cursor at its end
+func (e *lineEditor) setLine(line string) {
+    e.buf = append(e.buf[:0], []rune(line)...)
+    e.pos = len(e.buf)
+}
+
+// completeWord completes the word before the cursor as far as every
+// candidate agrees, listing the candidates when that adds nothing
+func (e *lineEditor) completeWord() {
+    if e.complete == nil || e.pos != len(e.buf) {
+        return
+    }
+    line := string(e.buf)
+    matches := e.complete(line)
+    if len(matches) == 0 {
+        return
+    }
+    start := strings.LastIndexByte(line, ' ') + 1
+    typed := line[start:]
+    common := matches[0]
+    for _, m := range matches[1:] {
+        for !strings.HasPrefix(m, common) {
+            _, size := utf8.DecodeLastRuneInString(common)
+            common = common[:len(common)-size]
+        }
+    }
+    if len(matches) == 1 {
+        common += " "
+    }
+    if len(common) > len(typed) {
+        e.setLine(line[:start] + common)
+        return
+    }
+    fmt.Fprint(e.out, "\r\n"+strings.Join(matches, "  ")+"\r\n")
+}
+
+// redraw writes the prompt and line and puts the cursor in place
+func (e *lineEditor) redraw() {
+    fmt.Fprint(e.out, "\r"+e.prompt+string(e.buf)+"\x1b[K")
+    if back := len(e.buf) - e.pos; back > 0 {
+        fmt.Fprintf(e.out, "\x1b[%dD", back)
+    }
+}
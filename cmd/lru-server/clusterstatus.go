@@ -0,0 +1,125 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sync"
+    "time"
+
+    "lru-cache/client"
+    "lru-cache/lrucache"
+)
+
+// clusterStatusTimeout bounds the whole fan-out of GET /cluster/status
+const clusterStatusTimeout = 3 * time.Second
+
+// NodeStatus is returned by GET /cluster/node: what a member reports about itself
+type NodeStatus struct {
+    Name        string            `json:"name"`
+    Entries     int               `json:"entries"`
+    Capacity    int               `json:"capacity"`
+    Bytes       int64             `json:"bytes"`
+    Replication ReplicationStatus `json:"replication"`
+}
+
+// MemberStatus is one member in GET /cluster/status
+type MemberStatus struct {
+    Member
+    Reachable bool         `json:"reachable"` // Answered GET /cluster/node
+    Error     string       `json:"error,omitempty"`
+    Share     float64      `json:"share"`            // Fraction of the keys it owns on the ring
+    Ranges    []client.Arc `json:"ranges,omitempty"` // With ?ranges=true
+    Node      *NodeStatus  `json:"node,omitempty"`
+}
+
+// ClusterStatus is returned by GET /cluster/status
+type ClusterStatus struct {
+    Self    string         `json:"self"`
+    Health  int            `json:"health"` // This node's; zero when it answers probes on time
+    Members []MemberStatus `json:"members"`
+}
+
+// nodeStatus reports this node
+func (h *handlers) nodeStatus() NodeStatus {
+    stats := lrucache.StatsOf(h.cache)
+    return NodeStatus{Name: membership.Name(), Entries: stats.Entries, Capacity: stats.Capacity, Bytes: stats.Bytes, Replication: replicationStatus()}
+}
+
+// nodeHandler handles GET /cluster/node
+func (h *handlers) nodeHandler(w http.ResponseWriter, r *http.Request) {
+    if membership == nil {
+        writeError(w, http.StatusNotFound, codeNotFound, "Cluster membership is disabled; set -gossip-listen")
+        return
+    }
+    writeJSON(w, h.nodeStatus())
+}
+
+// clusterStatusHandler handles GET /cluster/status, asking every member for
+// its GET /cluster/node with the caller's credentials
+func (h *handlers) clusterStatusHandler(w http.ResponseWriter, r *http.Request) {
+    if membership == nil {
+        writeError(w, http.StatusNotFound, codeNotFound, "Cluster membership is disabled; set -gossip-listen")
+        return
+    }
+    arcs, shares := membership.Ownership()
+    withRanges := r.URL.Query().Get("ranges") == "true"
+    ctx, cancel := context.WithTimeout(r.Context(), clusterStatusTimeout)
+    defer cancel()
+
+    members := membership.Members()
+    status := ClusterStatus{Self: membership.Name(), Health: membership.list.GetHealthScore(), Members: make([]MemberStatus, len(members))}
+    var wg sync.WaitGroup
+    for i, member := range members {
+        ms := &status.Members[i]
+        ms.Member, ms.Share = member, shares[member.Name]
+        if withRanges {
+            ms.Ranges = arcs[member.Name]
+        }
+        if member.Self {
+            node := h.nodeStatus()
+            ms.Reachable, ms.Node = true, &node
+            continue
+        }
+        if member.API == "" {
+            ms.Error = "no reachable HTTP API"
+            continue
+        }
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            node, err := fetchNodeStatus(ctx, r, member.API)
+            if err != nil {
+                ms.Error = err.Error()
+                return
+            }
+            ms.Reachable, ms.Node = true, &node
+        }()
+    }
+    wg.Wait()
+    writeJSON(w, status)
+}
+
+// fetchNodeStatus asks the member at api for its status
+func fetchNodeStatus(ctx context.Context, r *http.Request, api string) (NodeStatus, error) {
+    var node NodeStatus
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, api+"/cluster/node", nil)
+    if err != nil {
+        return node, err
+    }
+    for _, h := range []string{"Authorization", "X-API-Key"} {
+        if v := r.Header.Get(h); v != "" {
+            req.Header.Set(h, v)
+        }
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return node, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return node, fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+    }
+    return node, json.NewDecoder(resp.Body).Decode(&node)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+    "compress/flate"
+    "compress/gzip"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// compressWriter buffers the response until it knows whether the body is
+// large enough to be worth compressing
+type compressWriter struct {
+    http.ResponseWriter
+    encoding  string
+    level     int
+    threshold int
+    buf       []byte
+    status    int
+    started   bool
+    enc       io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+    if cw.started || cw.status != 0 {
+        return
+    }
+    cw.status = status
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+    if cw.status == 0 {
+        cw.status = http.StatusOK
+    }
+    if cw.started {
+        if cw.enc != nil {
+            return cw.enc.Write(p)
+        }
+        return cw.ResponseWriter.Write(p)
+    }
+    cw.buf = append(cw.buf, p...)
+    if len(cw.buf) >= cw.threshold {
+        if err := cw.start(true); err != nil {
+            return 0, err
+        }
+    }
+    return len(p), nil
+}
+
+// start sends the headers and any buffered body, compressed or not
+func (cw *compressWriter) start(compress bool) error {
+    cw.started = true
+    h := cw.Header()
+    if cw.status == 0 {
+        cw.status = http.StatusOK
+    }
+    if h.Get("Content-Encoding") != "" || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+        compress = false
+    }
+    if compress {
+        h.Set("Content-Encoding", cw.encoding)
+        h.Del("Content-Length")
+        if cw.encoding == "gzip" {
+            cw.enc, _ = gzip.NewWriterLevel(cw.ResponseWriter, cw.level)
+        } else {
+            cw.enc, _ = flate.NewWriter(cw.ResponseWriter, cw.level)
+        }
+    }
+    cw.ResponseWriter.WriteHeader(cw.status)
+    if len(cw.buf) == 0 {
+        return nil
+    }
+    var err error
+    if cw.enc != nil {
+        _, err = cw.enc.Write(cw.buf)
+    } else {
+        _, err = cw.ResponseWriter.Write(cw.buf)
+    }
+    cw.buf = nil
+    return err
+}
+
+// Flush sends what has been written so far; streams that flush before reaching
+// the threshold are passed through uncompressed
+func (cw *compressWriter) Flush() {
+    if !cw.started {
+        cw.start(false)
+    }
+    if f, ok := cw.enc.(interface{ Flush() error }); ok {
+        f.Flush()
+    }
+    if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+    return cw.ResponseWriter
+}
+
+// close finishes the response, sending small bodies uncompressed
+func (cw *compressWriter) close() error {
+    if !cw.started {
+        if cw.status == 0 {
+            return nil // nothing was written; let net/http send its default response
+        }
+        return cw.start(false)
+    }
+    if cw.enc != nil {
+        return cw.enc.Close()
+    }
+    return nil
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header, honouring q=0
+func negotiateEncoding(header string) string {
+    best, bestQ := "", 0.0
+    for _, part := range strings.Split(header, ",") {
+        name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+        q := 1.0
+        if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+            if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+                q = parsed
+            }
+        }
+        name = strings.ToLower(strings.TrimSpace(name))
+        if name == "*" {
+            name = "gzip"
+        }
+        if name != "gzip" && name != "deflate" {
+            continue
+        }
+        if q > bestQ || (q == bestQ && name == "gzip") {
+            best, bestQ = name, q
+        }
+    }
+    if bestQ <= 0 {
+        return ""
+    }
+    return best
+}
+
+// compressMiddleware compresses responses of at least minSize bytes for clients that accept it
+func compressMiddleware(level, minSize int, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Add("Vary", "Accept-Encoding")
+        encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+        if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        cw := &compressWriter{ResponseWriter: w, encoding: encoding, level: level, threshold: minSize}
+        defer cw.close()
+        next.ServeHTTP(cw, r)
+    })
+}
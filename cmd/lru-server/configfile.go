@@ -0,0 +1,486 @@
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "slices"
+    "strconv"
+    "strings"
+
+    "github.com/pelletier/go-toml/v2"
+    "github.com/pelletier/go-toml/v2/unstable"
+    "gopkg.in/yaml.v3"
+)
+
+// repeatableFlags may be given more than once; a list in a config file sets
+// them once per element rather than as one comma-separated value
+var repeatableFlags = []string{"namespace", "webhook"}
+
+// repeatableFlag is the flag.Value of a repeatable flag: set adds a value and
+// reset drops those added so far, so that the values of a later source
+// replace those of an earlier one rather than adding to them
+type repeatableFlag struct {
+    set   func(string) error
+    reset func()
+}
+
+func (f *repeatableFlag) String() string     { return "" }
+func (f *repeatableFlag) Set(s string) error { return f.set(s) }
+
+// configNode is a value of a config file, as a scalar, a list or a table,
+// with the line it starts on for error messages
+type configNode struct {
+    line   int
+    value  string                 // Of a scalar
+    list   []*configNode          // Of a list
+    keys   []string               // Of a table, in file order
+    fields map[string]*configNode // Of a table
+    isList bool
+}
+
+func (n *configNode) isTable() bool { return n.fields != nil }
+
+// add sets key of the table n, refusing to define it twice
+func (n *configNode) add(key string, v *configNode) error {
+    if _, ok := n.fields[key]; ok {
+        return fmt.Errorf("line %d: %s is set twice", v.line, key)
+    }
+    n.keys = append(n.keys, key)
+    n.fields[key] = v
+    return nil
+}
+
+func newConfigTable(line int) *configNode {
+    return &configNode{line: line, fields: make(map[string]*configNode)}
+}
+
+// configSetting is a flag a config file sets
+type configSetting struct {
+    name  string
+    value string
+    line  int
+}
+
+// applyConfigFile sets the flags a -config file names, except the explicit
+// ones given on the command line, which take precedence; the list a file
+// gives a repeatable flag replaces the one from the environment. Settings are named as the flags,
+// with _ for - if preferred, and tables join their keys onto the name, so
+// that in YAML
+//
+//	listen: ":8080"
+//	capacity: 100000
+//	raft:
+//	  listen: ":7390"
+//	  dir: /var/lib/lru-cache
+//	api_keys: [key-one, key-two]
+//	namespace:
+//	  sessions: {capacity: 10000, default_ttl: 30m}
+//	  fragments: {policy: fifo}
+//
+// sets -listen, -capacity, -raft-listen, -raft-dir, -api-keys to
+// key-one,key-two and -namespace once per namespace. The same file in TOML
+// has [raft] and [namespace.sessions] tables. JSON is read as YAML.
+func applyConfigFile(fs *flag.FlagSet, path string, explicit map[string]bool) error {
+    root, err := readConfigFile(path)
+    if err != nil {
+        return err
+    }
+    var settings []configSetting
+    if err := root.settings("", &settings); err != nil {
+        return fmt.Errorf("%s: %w", path, err)
+    }
+    replaced := make(map[string]bool)
+    for _, s := range settings {
+        if fs.Lookup(s.name) == nil || s.name == "config" {
+            msg := fmt.Sprintf("%s:%d: unknown setting %s", path, s.line, s.name)
+            if closest := closestFlag(fs, s.name); closest != "" {
+                msg += "; did you mean " + closest + "?"
+            }
+            return fmt.Errorf("%s", msg)
+        }
+        if explicit[s.name] {
+            continue
+        }
+        if f, ok := fs.Lookup(s.name).Value.(*repeatableFlag); ok && !replaced[s.name] {
+            f.reset()
+            replaced[s.name] = true
+        }
+        if err := fs.Set(s.name, s.value); err != nil {
+            return fmt.Errorf("%s:%d: %s: invalid value %q: %v", path, s.line, s.name, s.value, err)
+        }
+    }
+    return nil
+}
+
+// readConfigFile parses the file at path as TOML or YAML by its extension
+func readConfigFile(path string) (*configNode, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var root *configNode
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".toml":
+        root, err = parseTOML(data)
+    case ".yaml", ".yml", ".json":
+        root, err = parseYAML(data)
+    default:
+        return nil, fmt.Errorf("%s: want a .yaml, .yml, .json or .toml file", path)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    return root, nil
+}
+
+// settings appends the flags the table n sets, their names starting with
+// prefix
+func (n *configNode) settings(prefix string, out *[]configSetting) error {
+    for _, key := range n.keys {
+        v := n.fields[key]
+        name := strings.ReplaceAll(key, "_", "-")
+        if prefix != "" {
+            name = prefix + "-" + name
+        }
+        switch {
+        case slices.Contains(repeatableFlags, name):
+            if err := v.repeated(name, out); err != nil {
+                return err
+            }
+        case v.isTable():
+            if err := v.settings(name, out); err != nil {
+                return err
+            }
+        case v.isList:
+            items := make([]string, len(v.list))
+            for i, item := range v.list {
+                if item.isList || item.isTable() {
+                    return fmt.Errorf("line %d: %s: want a list of values", item.line, name)
+                }
+                items[i] = item.value
+            }
+            *out = append(*out, configSetting{name, strings.Join(items, ","), v.line})
+        default:
+            *out = append(*out, configSetting{name, v.value, v.line})
+        }
+    }
+    return nil
+}
+
+// repeated appends a setting of the repeatable flag name per element of n:
+// each value of a list or, for a table such as namespace's, name:key=value,...
+// per entry
+func (n *configNode) repeated(name string, out *[]configSetting) error {
+    switch {
+    case n.isList:
+        for _, item := range n.list {
+            if item.isList || item.isTable() {
+                return fmt.Errorf("line %d: %s: want a list of values", item.line, name)
+            }
+            *out = append(*out, configSetting{name, item.value, item.line})
+        }
+    case n.isTable():
+        for _, entry := range n.keys {
+            v := n.fields[entry]
+            spec := entry
+            if v.isList {
+                return fmt.Errorf("line %d: %s %s: want a table of settings", v.line, name, entry)
+            }
+            if v.isTable() {
+                pairs := make([]string, len(v.keys))
+                for i, key := range v.keys {
+                    if f := v.fields[key]; f.isList || f.isTable() {
+                        return fmt.Errorf("line %d: %s %s: %s: want a value", f.line, name, entry, key)
+                    }
+                    pairs[i] = key + "=" + v.fields[key].value
+                }
+                spec += ":" + strings.Join(pairs, ",")
+            }
+            *out = append(*out, configSetting{name, spec, v.line})
+        }
+    default:
+        *out = append(*out, configSetting{name, n.value, n.line})
+    }
+    return nil
+}
+
+// explicitFlags returns the names of the flags of fs given on the command line
+func explicitFlags(fs *flag.FlagSet) map[string]bool {
+    explicit := make(map[string]bool)
+    fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+    return explicit
+}
+
+// envPrefix starts the environment variables setting flags, named as the
+// flag in upper case with _ for -
+const envPrefix = "LRU_CACHE_"
+
+// applyEnv sets every flag not in explicit from its environment variable,
+// such as -raft-listen from LRU_CACHE_RAFT_LISTEN; a repeatable flag's
+// variable separates its values with ";". It warns of variables with the
+// prefix that name no flag, which are likely typos, but for those of the
+// commands talking to a server.
+func applyEnv(fs *flag.FlagSet, explicit map[string]bool) error {
+    for _, kv := range os.Environ() {
+        key, value, _ := strings.Cut(kv, "=")
+        if !strings.HasPrefix(key, envPrefix) || key == envURL || key == envAdminURL || key == envAPIKey {
+            continue
+        }
+        name := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(key, envPrefix), "_", "-"))
+        if fs.Lookup(name) == nil {
+            msg := "ignoring " + key + ": no such setting"
+            if closest := closestFlag(fs, name); closest != "" {
+                msg += "; did you mean " + envPrefix + strings.ToUpper(strings.ReplaceAll(closest, "-", "_")) + "?"
+            }
+            fmt.Fprintln(os.Stderr, msg)
+            continue
+        }
+        if explicit[name] {
+            continue
+        }
+        values := []string{value}
+        if slices.Contains(repeatableFlags, name) {
+            values = strings.Split(value, ";")
+        }
+        for _, v := range values {
+            if err := fs.Set(name, strings.TrimSpace(v)); err != nil {
+                return fmt.Errorf("%s: invalid value %q: %v", key, v, err)
+            }
+        }
+    }
+    return nil
+}
+
+// closestFlag names the flag of fs closest to the unknown name, or "" if
+// none is close
+func closestFlag(fs *flag.FlagSet, name string) string {
+    best, bestDist := "", max(2, len(name)/4)+1
+    fs.VisitAll(func(f *flag.Flag) {
+        if d := editDistance(name, f.Name); d < bestDist {
+            best, bestDist = f.Name, d
+        }
+    })
+    return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+    prev := make([]int, len(b)+1)
+    cur := make([]int, len(b)+1)
+    for j := range prev {
+        prev[j] = j
+    }
+    for i := 1; i <= len(a); i++ {
+        cur[0] = i
+        for j := 1; j <= len(b); j++ {
+            cost := 1
+            if a[i-1] == b[j-1] {
+                cost = 0
+            }
+            cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+        }
+        prev, cur = cur, prev
+    }
+    return prev[len(b)]
+}
+
+// parseYAML reads a YAML or JSON document whose top level is a mapping
+func parseYAML(data []byte) (*configNode, error) {
+    var doc yaml.Node
+    if err := yaml.Unmarshal(data, &doc); err != nil {
+        return nil, err
+    }
+    if len(doc.Content) == 0 {
+        return newConfigTable(1), nil
+    }
+    root, err := fromYAML(doc.Content[0])
+    if err != nil {
+        return nil, err
+    }
+    if !root.isTable() {
+        return nil, fmt.Errorf("line %d: want a mapping of settings", root.line)
+    }
+    return root, nil
+}
+
+func fromYAML(y *yaml.Node) (*configNode, error) {
+    switch y.Kind {
+    case yaml.AliasNode:
+        return fromYAML(y.Alias)
+    case yaml.MappingNode:
+        n := newConfigTable(y.Line)
+        for i := 0; i+1 < len(y.Content); i += 2 {
+            v, err := fromYAML(y.Content[i+1])
+            if err != nil {
+                return nil, err
+            }
+            if err := n.add(y.Content[i].Value, v); err != nil {
+                return nil, err
+            }
+        }
+        return n, nil
+    case yaml.SequenceNode:
+        n := &configNode{line: y.Line, isList: true}
+        for _, item := range y.Content {
+            v, err := fromYAML(item)
+            if err != nil {
+                return nil, err
+            }
+            n.list = append(n.list, v)
+        }
+        return n, nil
+    case yaml.ScalarNode:
+        if y.Tag == "!!null" {
+            return &configNode{line: y.Line}, nil
+        }
+        return &configNode{line: y.Line, value: y.Value}, nil
+    }
+    return nil, fmt.Errorf("line %d: unsupported YAML", y.Line)
+}
+
+// parseTOML reads a TOML document. Decoding it checks the whole of TOML, such
+// as a table defined twice, and gives the typed values; walking the parsed
+// expressions gives the order and lines of the keys.
+func parseTOML(data []byte) (*configNode, error) {
+    var doc map[string]interface{}
+    if err := toml.Unmarshal(data, &doc); err != nil {
+        var decodeErr *toml.DecodeError
+        if errors.As(err, &decodeErr) {
+            row, _ := decodeErr.Position()
+            return nil, fmt.Errorf("line %d: %s", row, strings.TrimPrefix(decodeErr.Error(), "toml: "))
+        }
+        return nil, err
+    }
+    r := &tomlReader{}
+    r.p.Reset(data)
+    root := newConfigTable(1)
+    table, values := root, doc
+    for r.p.NextExpression() {
+        e := r.p.Expression()
+        switch e.Kind {
+        case unstable.ArrayTable:
+            _, line := r.key(e.Key())
+            return nil, fmt.Errorf("line %d: arrays of tables are not supported", line)
+        case unstable.Table:
+            path, line := r.key(e.Key())
+            var err error
+            if table, err = root.table(path, line); err != nil {
+                return nil, err
+            }
+            values = doc
+            for _, key := range path {
+                values, _ = values[key].(map[string]interface{})
+            }
+        case unstable.KeyValue:
+            if err := r.keyValue(table, values, e); err != nil {
+                return nil, err
+            }
+        }
+    }
+    return root, r.p.Error()
+}
+
+// tomlReader turns the expressions of a TOML document into configNodes
+type tomlReader struct {
+    p unstable.Parser
+}
+
+// line is the line n starts on, or line if the parser kept no position for it
+func (r *tomlReader) line(n *unstable.Node, line int) int {
+    if n.Raw.Length == 0 {
+        return line
+    }
+    return r.p.Shape(n.Raw).Start.Line
+}
+
+// key returns the parts of a possibly dotted key and the line it is on
+func (r *tomlReader) key(it unstable.Iterator) ([]string, int) {
+    var path []string
+    line := 0
+    for it.Next() {
+        if line == 0 {
+            line = r.line(it.Node(), 0)
+        }
+        path = append(path, string(it.Node().Data))
+    }
+    return path, line
+}
+
+// keyValue adds the key = value e to table, whose decoded values are values
+func (r *tomlReader) keyValue(table *configNode, values map[string]interface{}, e *unstable.Node) error {
+    path, line := r.key(e.Key())
+    for _, key := range path[:len(path)-1] {
+        values, _ = values[key].(map[string]interface{})
+    }
+    table, err := table.table(path[:len(path)-1], line)
+    if err != nil {
+        return err
+    }
+    v, err := r.value(e.Value(), values[path[len(path)-1]], line)
+    if err != nil {
+        return err
+    }
+    if err := table.add(path[len(path)-1], v); err != nil {
+        return fmt.Errorf("line %d: %s is set twice", line, strings.Join(path, "."))
+    }
+    return nil
+}
+
+// value converts n, which decoded to v, starting on line unless it says
+// otherwise
+func (r *tomlReader) value(n *unstable.Node, v interface{}, line int) (*configNode, error) {
+    line = r.line(n, line)
+    switch n.Kind {
+    case unstable.Array:
+        items, _ := v.([]interface{})
+        list := &configNode{line: line, isList: true}
+        it := n.Children()
+        for i := 0; it.Next() && i < len(items); i++ {
+            item, err := r.value(it.Node(), items[i], line)
+            if err != nil {
+                return nil, err
+            }
+            list.list = append(list.list, item)
+        }
+        return list, nil
+    case unstable.InlineTable:
+        fields, _ := v.(map[string]interface{})
+        table := newConfigTable(line)
+        it := n.Children()
+        for it.Next() {
+            if err := r.keyValue(table, fields, it.Node()); err != nil {
+                return nil, err
+            }
+        }
+        return table, nil
+    }
+    switch v := v.(type) {
+    case string:
+        return &configNode{line: line, value: v}, nil
+    case int64:
+        return &configNode{line: line, value: strconv.FormatInt(v, 10)}, nil
+    case float64:
+        return &configNode{line: line, value: strconv.FormatFloat(v, 'g', -1, 64)}, nil
+    }
+    // Booleans, and dates, which no flag takes but are valid TOML
+    return &configNode{line: line, value: fmt.Sprint(v)}, nil
+}
+
+// table returns the table at path below n, creating it
+func (n *configNode) table(path []string, line int) (*configNode, error) {
+    for _, key := range path {
+        next, ok := n.fields[key]
+        if !ok {
+            next = newConfigTable(line)
+            n.add(key, next)
+        }
+        if !next.isTable() {
+            return nil, fmt.Errorf("line %d: %s is not a table", line, key)
+        }
+        n = next
+    }
+    return n, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// CORSPolicy describes which cross-origin requests browsers may make
+type CORSPolicy struct {
+    Origins []string
+    Methods []string
+    Headers []string
+    MaxAge  time.Duration
+}
+
+// allowOrigin returns the value for Access-Control-Allow-Origin, or "" if the origin is not allowed
+func (p *CORSPolicy) allowOrigin(origin string) string {
+    for _, allowed := range p.Origins {
+        if allowed == "*" {
+            return "*"
+        }
+        if strings.EqualFold(allowed, origin) {
+            return origin
+        }
+    }
+    return ""
+}
+
+// Middleware sets CORS headers for allowed origins and answers preflight requests
+func (p *CORSPolicy) Middleware(next http.Handler) http.Handler {
+    if len(p.Origins) == 0 {
+        return next
+    }
+    methods := strings.Join(p.Methods, ", ")
+    headers := strings.Join(p.Headers, ", ")
+    maxAge := strconv.Itoa(int(p.MaxAge.Seconds()))
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        origin := r.Header.Get("Origin")
+        if origin == "" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        w.Header().Add("Vary", "Origin")
+        allowed := p.allowOrigin(origin)
+        preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+        if allowed != "" {
+            w.Header().Set("Access-Control-Allow-Origin", allowed)
+        }
+        if !preflight {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if allowed == "" {
+            w.WriteHeader(http.StatusForbidden)
+            return
+        }
+        w.Header().Set("Access-Control-Allow-Methods", methods)
+        w.Header().Set("Access-Control-Allow-Headers", headers)
+        if p.MaxAge > 0 {
+            w.Header().Set("Access-Control-Max-Age", maxAge)
+        }
+        w.WriteHeader(http.StatusNoContent)
+    })
+}
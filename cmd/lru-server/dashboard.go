@@ -0,0 +1,43 @@
+package main
+
+import (
+    "embed"
+    "io/fs"
+    "net/http"
+    "strings"
+)
+
+// dashboardFiles is the single-page admin UI served at /ui on the admin listener
+//
+//go:embed ui
+var dashboardFiles embed.FS
+
+// withDashboard serves the dashboard in front of next. The page itself needs
+// no credentials: it only holds code, and with API keys set asks for one to
+// send with the admin requests it makes.
+func withDashboard(next http.Handler) http.Handler {
+    files, err := fs.Sub(dashboardFiles, "ui")
+    if err != nil {
+        panic(err)
+    }
+    static := http.StripPrefix("/ui/", http.FileServerFS(files))
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/ui" && !strings.HasPrefix(r.URL.Path, "/ui/") {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if r.Method != http.MethodGet && r.Method != http.MethodHead {
+            writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "The dashboard is read with GET")
+            return
+        }
+        if r.URL.Path == "/ui" {
+            http.Redirect(w, r, "/ui/", http.StatusMovedPermanently)
+            return
+        }
+        // Scripts and styles come only from the page's own files, so that a
+        // key or value shown in it can never run as code
+        w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+        w.Header().Set("X-Content-Type-Options", "nosniff")
+        static.ServeHTTP(w, r)
+    })
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+    "bytes"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "os/exec"
+    "strings"
+)
+
+// errDecrypt is returned for data on disk that does not decrypt with the
+// configured key
+var errDecrypt = errors.New("data does not decrypt with -encryption-key; it was written with another key or without one")
+
+// loadEncryptionKey returns the AES key given in base64 as key or printed by
+// command, which is run with sh and suits a KMS CLI; nil if both are empty
+func loadEncryptionKey(key, command string) ([]byte, error) {
+    if command != "" {
+        if key != "" {
+            return nil, errors.New("-encryption-key and -encryption-key-command cannot be used together")
+        }
+        var stderr bytes.Buffer
+        cmd := exec.Command("sh", "-c", command)
+        cmd.Stderr = &stderr
+        out, err := cmd.Output()
+        if err != nil {
+            return nil, fmt.Errorf("-encryption-key-command: %w: %s", err, strings.TrimSpace(stderr.String()))
+        }
+        key = strings.TrimSpace(string(out))
+    }
+    if key == "" {
+        return nil, nil
+    }
+    decoded, err := base64.StdEncoding.DecodeString(key)
+    if err != nil || (len(decoded) != 16 && len(decoded) != 24 && len(decoded) != 32) {
+        return nil, errors.New("the encryption key must be 16, 24 or 32 bytes encoded in base64")
+    }
+    return decoded, nil
+}
+
+// newAEAD returns AES-GCM with key, which loadEncryptionKey checked, or nil
+// for no key
+func newAEAD(key []byte) cipher.AEAD {
+    if key == nil {
+        return nil
+    }
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        panic(err)
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        panic(err)
+    }
+    return aead
+}
+
+// seal encrypts data with aead, prefixing a random nonce; a nil aead leaves
+// data as it is
+func seal(aead cipher.AEAD, data []byte) []byte {
+    if aead == nil {
+        return data
+    }
+    out := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+    rand.Read(out)
+    return aead.Seal(out, out, data, nil)
+}
+
+// unseal reverses seal
+func unseal(aead cipher.AEAD, data []byte) ([]byte, error) {
+    if aead == nil {
+        return data, nil
+    }
+    n := aead.NonceSize()
+    if len(data) < n {
+        return nil, errDecrypt
+    }
+    out, err := aead.Open(nil, data[:n], data[n:], nil)
+    if err != nil {
+        return nil, errDecrypt
+    }
+    return out, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "sort"
+    "strings"
+
+    "lru-cache/lrucache"
+)
+
+// Error codes returned in the "code" field of error responses
+const (
+    codeBadRequest       = "BAD_REQUEST"
+    codeKeyNotFound      = "KEY_NOT_FOUND"
+    codeExpired          = "EXPIRED"
+    codePayloadTooLarge  = "PAYLOAD_TOO_LARGE"
+    codeUnauthorized     = "UNAUTHORIZED"
+    codeForbidden        = "FORBIDDEN"
+    codeRateLimited      = "RATE_LIMITED"
+    codeNotFound         = "NOT_FOUND"
+    codeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+    codeOverloaded       = "OVERLOADED"
+    codeReadOnly         = "READ_ONLY"
+    codeUnavailable      = "UNAVAILABLE"
+    codeWrongType        = "WRONG_TYPE"
+    codeCorrupt          = "CORRUPT_VALUE"
+    codeQuotaExceeded    = "QUOTA_EXCEEDED"
+    codeConflict         = "CONFLICT"
+    codeLeaseHeld        = "LEASE_HELD"
+    codeLeaseInvalid     = "LEASE_INVALID"
+    codeNotImplemented   = "NOT_IMPLEMENTED"
+    codeNotAdmitted      = "NOT_ADMITTED"
+)
+
+// Messages for errReadOnly, errNoLeader and lrucache.ErrNotAdmitted
+const (
+    readOnlyMessage    = "This node is read-only; send writes to the primary or Raft leader"
+    noLeaderMessage    = "The Raft cluster has no leader; try again shortly"
+    notAdmittedMessage = "The key is new and was not cached; the doorkeeper caches it on its next write"
+)
+
+// APIError is the machine-readable part of an error response
+type APIError struct {
+    Code    string `json:"code"`
+    Message string `json:"message"`
+}
+
+// ErrorResponse is the JSON envelope of every error response
+type ErrorResponse struct {
+    Error APIError `json:"error"`
+}
+
+// writeError writes a JSON error envelope with the given status
+func writeError(w http.ResponseWriter, status int, code, message string) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(ErrorResponse{Error: APIError{Code: code, Message: message}})
+}
+
+// writeCacheError answers a request the cache refused: the key holds another
+// kind of value, a score would not be finite, a bit was invalid, the value
+// was too large or found corrupt, the quota was used up, the doorkeeper did
+// not admit a new key, the cache was closed, the request's context ended or
+// the change was not committed
+func writeCacheError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, lrucache.ErrNotAdmitted):
+        writeError(w, http.StatusConflict, codeNotAdmitted, notAdmittedMessage)
+    case errors.Is(err, lrucache.ErrWrongType):
+        writeError(w, http.StatusConflict, codeWrongType, "Key holds another kind of value")
+    case errors.Is(err, lrucache.ErrInvalidScore):
+        writeError(w, http.StatusBadRequest, codeBadRequest, "Score is not a finite number")
+    case errors.Is(err, lrucache.ErrInvalidBit):
+        writeError(w, http.StatusBadRequest, codeBadRequest, "bit must be 0 or 1")
+    case errors.Is(err, lrucache.ErrTooLarge):
+        writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "Value exceeds the maximum size")
+    case errors.Is(err, lrucache.ErrLeaseInvalid):
+        writeError(w, http.StatusConflict, codeLeaseInvalid, "The lease expired or was revoked by another write; read the key again")
+    case errors.Is(err, lrucache.ErrQuotaExceeded):
+        writeError(w, http.StatusTooManyRequests, codeQuotaExceeded, "The write would exceed the namespace's quota")
+    case errors.Is(err, lrucache.ErrCorrupt):
+        writeError(w, http.StatusInternalServerError, codeCorrupt, "The stored value failed its checksum and was dropped")
+    case errors.Is(err, lrucache.ErrClosed):
+        writeError(w, http.StatusServiceUnavailable, codeUnavailable, "The cache is shutting down")
+    case errors.Is(err, context.DeadlineExceeded):
+        writeError(w, http.StatusGatewayTimeout, codeUnavailable, "Timed out waiting for the cache")
+    case errors.Is(err, context.Canceled):
+        writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Request cancelled")
+    default:
+        writeError(w, http.StatusServiceUnavailable, codeUnavailable, "The change was not committed: "+err.Error())
+    }
+}
+
+// apiCacheError describes a write the cache refused, for the front-ends that
+// answer with an APIError rather than an HTTP status
+func apiCacheError(err error) *APIError {
+    switch {
+    case errors.Is(err, lrucache.ErrNotAdmitted):
+        return &APIError{Code: codeNotAdmitted, Message: notAdmittedMessage}
+    case errors.Is(err, lrucache.ErrTooLarge):
+        return &APIError{Code: codePayloadTooLarge, Message: "Value exceeds the maximum size"}
+    case errors.Is(err, lrucache.ErrQuotaExceeded):
+        return &APIError{Code: codeQuotaExceeded, Message: "The write would exceed the namespace's quota"}
+    case errors.Is(err, lrucache.ErrClosed):
+        return &APIError{Code: codeUnavailable, Message: "The cache is shutting down"}
+    default:
+        return &APIError{Code: codeUnavailable, Message: "The change was not committed: " + err.Error()}
+    }
+}
+
+// writeLookupError answers a read that found no value: the key is missing
+// or expired, or the cache refused the read
+func writeLookupError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, lrucache.ErrExpired):
+        writeError(w, http.StatusNotFound, codeExpired, "Key has expired")
+    case errors.Is(err, lrucache.ErrNotFound):
+        writeError(w, http.StatusNotFound, codeKeyNotFound, "Key not found")
+    default:
+        writeCacheError(w, err)
+    }
+}
+
+// errorResponse documents an error response of a route
+func errorResponse(status int, description string) response {
+    return response{Status: status, Description: description, ContentType: "application/json", Body: ErrorResponse{}}
+}
+
+// notFoundHandler answers requests that match no route, distinguishing
+// unknown paths (404) from known paths with the wrong method (405)
+func notFoundHandler(mux *http.ServeMux) http.HandlerFunc {
+    methods := []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+    return func(w http.ResponseWriter, r *http.Request) {
+        var allowed []string
+        for _, method := range methods {
+            probe := r.Clone(r.Context())
+            probe.Method = method
+            if _, pattern := mux.Handler(probe); pattern != "" && pattern != "/" {
+                allowed = append(allowed, method)
+            }
+        }
+        if len(allowed) == 0 {
+            writeError(w, http.StatusNotFound, codeNotFound, "No such endpoint")
+            return
+        }
+        sort.Strings(allowed)
+        w.Header().Set("Allow", strings.Join(allowed, ", "))
+        writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+    }
+}
+
+// supported returns cache as the optional interface T, answering 501
+// NOT_IMPLEMENTED when the cache does not provide it
+func supported[T any](w http.ResponseWriter, cache lrucache.Cache) (T, bool) {
+    c, ok := cache.(T)
+    if !ok {
+        writeError(w, http.StatusNotImplemented, codeNotImplemented, "The cache does not support this operation")
+    }
+    return c, ok
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "lru-cache/lrucache"
+    "lru-cache/lrucache/cachetest"
+)
+
+// coreCache hides every method of a cache beyond lrucache.Cache
+type coreCache struct {
+    lrucache.Cache
+}
+
+func TestUnsupportedOperation(t *testing.T) {
+    tests := []struct {
+        name       string
+        cache      lrucache.Cache
+        wantStatus int
+    }{
+        {"full cache", cachetest.New(10), http.StatusNotFound},
+        {"core only", coreCache{cachetest.New(10)}, http.StatusNotImplemented},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            h := &handlers{cache: tt.cache, maxValueBytes: 64}
+            r := httptest.NewRequest(http.MethodGet, "/v1/cache/h/fields", nil)
+            r.SetPathValue("key", "h")
+            w := httptest.NewRecorder()
+            h.getHashHandler(w, r)
+            if w.Code != tt.wantStatus {
+                t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.wantStatus)
+            }
+            if tt.wantStatus == http.StatusNotImplemented && !strings.Contains(w.Body.String(), codeNotImplemented) {
+                t.Fatalf("body = %s, want %s", w.Body, codeNotImplemented)
+            }
+        })
+    }
+}
+
+func TestNotAdmitted(t *testing.T) {
+    h := &handlers{cache: lrucache.New(lrucache.WithCapacity(10), lrucache.WithDoorkeeper(time.Minute)), maxValueBytes: 64}
+    put := func() *httptest.ResponseRecorder {
+        r := httptest.NewRequest(http.MethodPut, "/v1/cache/k", strings.NewReader("v"))
+        r.SetPathValue("key", "k")
+        w := httptest.NewRecorder()
+        h.putRawValue(w, r)
+        return w
+    }
+    if w := put(); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), codeNotAdmitted) {
+        t.Fatalf("first PUT of a new key = %d %s, want 409 %s", w.Code, w.Body, codeNotAdmitted)
+    }
+    if w := put(); w.Code != http.StatusNoContent {
+        t.Fatalf("second PUT = %d %s, want 204", w.Code, w.Body)
+    }
+
+    if e := apiCacheError(lrucache.ErrNotAdmitted); e.Code != codeNotAdmitted {
+        t.Errorf("apiCacheError = %+v, want %s", e, codeNotAdmitted)
+    }
+    if code := status.Code(grpcCacheError(lrucache.ErrNotAdmitted)); code != codes.FailedPrecondition {
+        t.Errorf("grpcCacheError = %v, want FailedPrecondition", code)
+    }
+}
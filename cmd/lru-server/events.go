@@ -0,0 +1,38 @@
+package main
+
+import (
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// EventMessage is the JSON form of an Event pushed to WebSocket and SSE clients
+type EventMessage struct {
+    Event     string    `json:"event"`
+    Namespace string    `json:"namespace,omitempty"` // Left out for the default one
+    Key       string    `json:"key,omitempty"`
+    Value     string    `json:"value,omitempty"`
+    Time      time.Time `json:"time"`
+}
+
+func newEventMessage(e lrucache.Event) EventMessage {
+    return EventMessage{Event: e.Type.String(), Namespace: e.Namespace, Key: e.Key, Value: e.Value, Time: e.Time}
+}
+
+// eventFilter builds the filter of a subscription to keys with prefix that
+// match pattern, if not empty, and to the named event types, if any
+func eventFilter(prefix, pattern string, types []string) (lrucache.EventFilter, error) {
+    f := lrucache.EventFilter{Prefix: prefix, Pattern: pattern}
+    for _, name := range types {
+        t, err := lrucache.ParseEventType(name)
+        if err != nil {
+            return f, err
+        }
+        f.Types = append(f.Types, t)
+    }
+    return f, nil
+}
+
+// events fans out every change to the default cache and, tagged with their
+// name, to the namespaces
+var events = lrucache.NewEventBus()
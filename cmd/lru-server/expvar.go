@@ -0,0 +1,184 @@
+package main
+
+import (
+    "expvar"
+    "fmt"
+    "net/http"
+    "net/url"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// publishExpvars exposes cache counters and the effective configuration at
+// /debug/vars on the admin listener
+func publishExpvars(h *handlers) {
+    started := time.Now()
+    expvar.Publish("cache", expvar.Func(func() interface{} { return lrucache.StatsOf(h.cache) }))
+    expvar.Publish("replication", expvar.Func(func() interface{} { return replicationStatus() }))
+    expvar.Publish("raft", expvar.Func(func() interface{} {
+        if consensus == nil {
+            return nil
+        }
+        return consensus.status()
+    }))
+    expvar.Publish("ha", expvar.Func(func() interface{} {
+        if h.ha == nil {
+            return nil
+        }
+        return h.ha.Status()
+    }))
+    expvar.Publish("cluster", expvar.Func(func() interface{} {
+        if membership == nil {
+            return nil
+        }
+        return membership.Members()
+    }))
+    expvar.Publish("invalidation", expvar.Func(func() interface{} {
+        if invalidator == nil {
+            return nil
+        }
+        return invalidator.Status()
+    }))
+    expvar.Publish("rebalance", expvar.Func(func() interface{} {
+        if rebalancer == nil {
+            return nil
+        }
+        return rebalancer.Status()
+    }))
+    expvar.Publish("proxy", expvar.Func(func() interface{} {
+        if proxy == nil {
+            return nil
+        }
+        return proxy.Backends()
+    }))
+    expvar.Publish("proxy_hints", expvar.Func(func() interface{} {
+        if proxy == nil || proxy.hints == nil {
+            return nil
+        }
+        return proxy.hints.Status()
+    }))
+    expvar.Publish("wan", expvar.Func(func() interface{} { return wan.Status() }))
+    expvar.Publish("fill", expvar.Func(func() interface{} {
+        if filler == nil {
+            return nil
+        }
+        return filler.Status()
+    }))
+    expvar.Publish("mqtt", expvar.Func(func() interface{} {
+        if h.mqtt == nil {
+            return nil
+        }
+        return h.mqtt.Status()
+    }))
+    expvar.Publish("memory", expvar.Func(func() interface{} { return memoryStats(h.namespaces) }))
+    expvar.Publish("uptime_seconds", expvar.Func(func() interface{} { return int64(time.Since(started).Seconds()) }))
+    expvar.Publish("config", expvar.Func(func() interface{} { return h.reloader.Config().public() }))
+}
+
+// expvarHandler is expvar.Handler without the cmdline variable, which would
+// reveal API keys and secrets passed as flags
+func expvarHandler(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    fmt.Fprint(w, "{\n")
+    first := true
+    expvar.Do(func(kv expvar.KeyValue) {
+        if kv.Key == "cmdline" {
+            return
+        }
+        if !first {
+            fmt.Fprint(w, ",\n")
+        }
+        first = false
+        fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+    })
+    fmt.Fprint(w, "\n}\n")
+}
+
+// public returns the configuration with secrets and credentials left out
+func (c *Config) public() map[string]interface{} {
+    return map[string]interface{}{
+        "listen":              c.Listen,
+        "admin_listen":        c.AdminListen,
+        "resp_listen":         c.RESPListen,
+        "memcache_listen":     c.MemcacheListen,
+        "grpc_listen":         c.GRPCListen,
+        "binary_listen":       c.BinaryListen,
+        "replication_listen":  c.ReplicationListen,
+        "replicate_from":      c.ReplicateFrom,
+        "replica_primary_url": redactURL(c.ReplicaPrimaryURL),
+        "ha_lock":             redactURL(c.HALock),
+        "ha_advertise":        c.HAAdvertise,
+        "raft_listen":         c.RaftListen,
+        "raft_id":             c.RaftID,
+        "raft_peers":          c.RaftPeers,
+        "raft_stale_reads":    c.RaftStaleReads,
+        "gossip_listen":       c.GossipListen,
+        "gossip_name":         c.GossipName,
+        "gossip_join":         c.GossipJoin,
+        "gossip_discover":     redactURL(c.GossipDiscover),
+        "gossip_encrypted":    c.GossipKey != "",
+        "encrypted":           c.EncryptionKey != nil,
+        "gossip_invalidate":   c.GossipInvalidate,
+        "gossip_rebalance":    c.GossipRebalance,
+        "proxy":               c.ProxyBackends,
+        "proxy_discover":      redactURL(c.ProxyDiscover),
+        "proxy_hints":         c.ProxyHints,
+        "proxy_replicas":      c.ProxyReplicas,
+        "proxy_read_level":    c.ProxyReadLevel,
+        "proxy_write_level":   c.ProxyWriteLevel,
+        "wan_replicate_to":    redactURL(c.WANTarget),
+        "wan_source":          c.WANSource,
+        "wan_interval":        c.WANInterval.String(),
+        "fill_origin":         redactURL(c.FillOrigin),
+        "fill_ttl":            c.FillTTL.String(),
+        "fill_peers":          c.FillPeers,
+        "fill_hot_qps":        c.FillHotQPS,
+        "http3_listen":        c.HTTP3Listen,
+        "nats_url":            redactURL(c.NATSURL),
+        "mqtt_url":            redactURL(c.MQTTURL),
+        "mqtt_topics":         c.MQTTTopics,
+        "config_file":         c.ConfigFile,
+        "capacity":            c.Capacity,
+        "doorkeeper_window":   c.DoorkeeperWindow.String(),
+        "default_ttl":         c.DefaultTTL.String(),
+        "janitor_interval":    c.JanitorInterval.String(),
+        "max_value_bytes":     c.MaxValueBytes,
+        "max_conns":           c.MaxConns,
+        "max_inflight":        c.MaxInflight,
+        "shutdown_timeout":    c.ShutdownTimeout.String(),
+        "read_header_timeout": c.ReadHeaderTimeout.String(),
+        "read_timeout":        c.ReadTimeout.String(),
+        "write_timeout":       c.WriteTimeout.String(),
+        "idle_timeout":        c.IdleTimeout.String(),
+        "rate_limit":          c.RateLimit,
+        "client_rate_limit":   c.ClientRateLimit,
+        "api_keys":            len(c.APIKeys),
+        "auth_reads":          c.AuthReads,
+        "jwt":                 c.JWT.Secret != "" || c.JWT.JWKSURL != "",
+        "tls":                 c.TLSCert != "",
+        "h2c":                 c.H2C,
+        "compress":            c.Compress,
+        "cors_origins":        c.CORS.Origins,
+        "access_log":          c.AccessLog,
+        "statsd_addr":         c.StatsDAddr,
+        "otlp_endpoint":       redactURL(c.OTLPEndpoint),
+        "log_format":          c.LogFormat,
+        "log_level":           c.LogLevel.String(),
+        "slow_threshold":      c.SlowThreshold.String(),
+        "heatmap_depth":       c.HeatmapDepth,
+        "audit_file":          c.AuditFile,
+        "audit_webhook":       redactURL(c.AuditWebhook),
+        "webhooks":            len(c.Webhooks),
+    }
+}
+
+// redactURL drops the user info from a URL so credentials never leak into diagnostics
+func redactURL(s string) string {
+    u, err := url.Parse(s)
+    if err != nil || u.User == nil {
+        return s
+    }
+    u.User = nil
+    return u.String()
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// Fill tuning
+const (
+    fillHotMessage byte = 'H'             // Gossip message kind: a hot key's value pushed by its owner
+    fillHotKeys         = 1024            // Copies of hot keys a member keeps at most
+    fillTimeout         = 5 * time.Second // Limit on one request to the origin or to an owner
+)
+
+// filler is nil unless -fill-origin is set
+var filler *Filler
+
+// Filler loads missing keys from an origin server. With peers, every key is
+// loaded only by its owner on a consistent hash ring of the members, which
+// shares the value instead of each member asking the origin; keys members
+// ask for often are pushed to all of them so the owner is not swamped.
+type Filler struct {
+    cache         *lrucache.LRUCache
+    origin        string
+    ttl           time.Duration
+    token         string
+    maxValueBytes int
+    client        *http.Client
+    members       *Membership // nil loads every miss from the origin here
+    hotQPS        int
+    hotTTL        time.Duration
+    hot           *lrucache.LRUCache // Copies of hot keys other members own
+
+    mutex    sync.Mutex // Guards flights, window, requests and pushed
+    flights  map[string]*fillFlight
+    window   time.Time      // Start of the second requests are being counted in
+    requests map[string]int // Requests from members per key in this second
+    pushed   map[string]time.Time
+
+    originLoads atomic.Uint64
+    peerLoads   atomic.Uint64
+    peerErrors  atomic.Uint64
+    hotHits     atomic.Uint64
+    hotPushes   atomic.Uint64
+}
+
+// fillFlight is a load in progress that callers missing the same key wait for
+type fillFlight struct {
+    done  chan struct{}
+    item  lrucache.Item
+    found bool
+}
+
+// fillHot is the message an owner pushes for a hot key
+type fillHot struct {
+    Key   string `json:"key"`
+    Value string `json:"value"`
+}
+
+// FillStatus is published as expvar "fill"
+type FillStatus struct {
+    OriginLoads uint64 `json:"origin_loads"`
+    PeerLoads   uint64 `json:"peer_loads"`  // Misses the key's owner answered
+    PeerErrors  uint64 `json:"peer_errors"` // Owners that failed, so this member asked the origin itself
+    HotHits     uint64 `json:"hot_hits"`
+    HotPushes   uint64 `json:"hot_pushes"`
+    HotKeys     int    `json:"hot_keys"`
+}
+
+// NewFiller loads from cfg.FillOrigin, through the owners among the members
+// of m unless m is nil
+func NewFiller(cfg *Config, cache *lrucache.LRUCache, m *Membership) *Filler {
+    f := &Filler{
+        cache:         cache,
+        origin:        strings.TrimSuffix(cfg.FillOrigin, "/"),
+        ttl:           cfg.FillTTL,
+        token:         cfg.FillToken,
+        maxValueBytes: cfg.MaxValueBytes,
+        client:        &http.Client{Timeout: fillTimeout},
+        members:       m,
+        hotQPS:        cfg.FillHotQPS,
+        hotTTL:        cfg.FillHotTTL,
+        hot:           lrucache.New(lrucache.WithCapacity(fillHotKeys)),
+        flights:       make(map[string]*fillFlight),
+        requests:      make(map[string]int),
+        pushed:        make(map[string]time.Time),
+    }
+    if m != nil {
+        m.Handle(fillHotMessage, f.receiveHot)
+    }
+    return f
+}
+
+// Status reports the counters
+func (f *Filler) Status() FillStatus {
+    return FillStatus{
+        OriginLoads: f.originLoads.Load(),
+        PeerLoads:   f.peerLoads.Load(),
+        PeerErrors:  f.peerErrors.Load(),
+        HotHits:     f.hotHits.Load(),
+        HotPushes:   f.hotPushes.Load(),
+        HotKeys:     f.hot.Len(),
+    }
+}
+
+// owner returns the member owning key and its API, or empty strings when
+// this node owns it
+func (f *Filler) owner(key string) (name, api string) {
+    if f.members == nil {
+        return "", ""
+    }
+    return f.members.Owner(key)
+}
+
+// do runs load once for concurrent callers missing the same key, whether the
+// miss was here or at a member asking this node as the owner; the cache
+// already shares a load among its own misses. A caller whose ctx is done
+// stops waiting, but the load carries on for the others.
+func (f *Filler) do(ctx context.Context, key string, load func(ctx context.Context) (lrucache.Item, bool)) (lrucache.Item, bool) {
+    f.mutex.Lock()
+    flight, ok := f.flights[key]
+    if !ok {
+        flight = &fillFlight{done: make(chan struct{})}
+        f.flights[key] = flight
+        go func() {
+            flight.item, flight.found = load(context.WithoutCancel(ctx))
+            f.mutex.Lock()
+            delete(f.flights, key)
+            f.mutex.Unlock()
+            close(flight.done)
+        }()
+    }
+    f.mutex.Unlock()
+
+    select {
+    case <-flight.done:
+        return flight.item, flight.found
+    case <-ctx.Done():
+        return lrucache.Item{}, false
+    }
+}
+
+// Load finds a key missing from the cache among the hot copies, at its
+// owner or at the origin; it is the cache's loader
+func (f *Filler) Load(ctx context.Context, key string) (lrucache.Item, bool) {
+    if item, err := f.hot.GetItem(key); err == nil {
+        f.hotHits.Add(1)
+        return item, true
+    }
+    return f.do(ctx, key, func(ctx context.Context) (lrucache.Item, bool) {
+        if name, api := f.owner(key); api != "" {
+            value, found, err := f.get(ctx, api+"/cluster/fill/"+url.PathEscape(key), f.token)
+            if err == nil {
+                if found {
+                    f.peerLoads.Add(1)
+                }
+                return lrucache.Item{Value: value}, found
+            }
+            f.peerErrors.Add(1)
+            slog.Warn("fill: owner failed, loading from the origin", "member", name, "key", key, "err", err)
+        }
+        return f.fillOrigin(ctx, key)
+    })
+}
+
+// loadOwned finds a key another member asked this node, as its owner, for
+func (f *Filler) loadOwned(ctx context.Context, key string) (lrucache.Item, bool) {
+    if item, err := f.cache.LookupCached(key); err == nil {
+        return item, true
+    }
+    return f.do(ctx, key, func(ctx context.Context) (lrucache.Item, bool) { return f.fillOrigin(ctx, key) })
+}
+
+// fillOrigin loads key from the origin and caches it
+func (f *Filler) fillOrigin(ctx context.Context, key string) (lrucache.Item, bool) {
+    value, found, err := f.get(ctx, f.origin+"/"+url.PathEscape(key), "")
+    if err != nil {
+        slog.Warn("fill: origin failed", "key", key, "err", err)
+        return lrucache.Item{}, false
+    }
+    if !found {
+        return lrucache.Item{}, false
+    }
+    f.originLoads.Add(1)
+    cas, err := f.cache.Store(key, value, 0, f.ttl, lrucache.StoreAlways)
+    if err != nil {
+        slog.Debug("fill: origin value not cached", "key", key, "err", err)
+    }
+    return lrucache.Item{Value: value, CAS: cas, Expiration: lrucache.ExpiresAt(f.ttl)}, true
+}
+
+// get fetches target, reporting a 404 as not found
+func (f *Filler) get(ctx context.Context, target, token string) (string, bool, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+    if err != nil {
+        return "", false, err
+    }
+    if token != "" {
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+    resp, err := f.client.Do(req)
+    if err != nil {
+        return "", false, err
+    }
+    defer resp.Body.Close()
+    switch resp.StatusCode {
+    case http.StatusOK:
+    case http.StatusNotFound:
+        return "", false, nil
+    default:
+        return "", false, fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+    }
+    body, err := io.ReadAll(io.LimitReader(resp.Body, int64(f.maxValueBytes)+1))
+    if err != nil {
+        return "", false, err
+    }
+    if len(body) > f.maxValueBytes {
+        return "", false, fmt.Errorf("value exceeds %d bytes", f.maxValueBytes)
+    }
+    return string(body), true, nil
+}
+
+// count notes a member asking for key and pushes the value to every member
+// once it has been asked for hotQPS times within a second
+func (f *Filler) count(key, value string) {
+    if f.hotQPS <= 0 {
+        return
+    }
+    now := time.Now()
+    f.mutex.Lock()
+    if now.Sub(f.window) >= time.Second {
+        f.window = now
+        clear(f.requests)
+        for k, at := range f.pushed {
+            if now.Sub(at) >= f.hotTTL {
+                delete(f.pushed, k)
+            }
+        }
+    }
+    f.requests[key]++
+    // Push again halfway through the copies' lifetime so a key that stays hot stays copied
+    hot := f.requests[key] == f.hotQPS && now.Sub(f.pushed[key]) >= f.hotTTL/2
+    if hot {
+        f.pushed[key] = now
+    }
+    f.mutex.Unlock()
+    if hot {
+        go f.push(key, value)
+    }
+}
+
+// push sends a hot key's value to every other member
+func (f *Filler) push(key, value string) {
+    payload, _ := json.Marshal(fillHot{Key: key, Value: value})
+    msg := append([]byte{fillHotMessage}, payload...)
+    for _, node := range f.members.Peers() {
+        if err := f.members.list.SendReliable(node, msg); err != nil {
+            slog.Debug("fill: hot key push failed", "member", node.Name, "err", err)
+        }
+    }
+    f.hotPushes.Add(1)
+    slog.Debug("fill: pushed hot key", "key", key)
+}
+
+// receiveHot keeps the copy of a hot key its owner pushed
+func (f *Filler) receiveHot(msg []byte) {
+    var hot fillHot
+    if err := json.Unmarshal(msg, &hot); err != nil {
+        slog.Debug("fill: malformed hot key", "err", err)
+        return
+    }
+    if err := f.hot.Set(hot.Key, hot.Value, f.hotTTL); err != nil {
+        slog.Debug("fill: hot key not kept", "key", hot.Key, "err", err)
+    }
+}
+
+// Forget drops the copy of a hot key that changed at its owner
+func (f *Filler) Forget(key string) {
+    f.hot.Delete(key)
+}
+
+// fillHandler handles GET /cluster/fill/{key}, which members send to the
+// owner of a key they miss
+func fillHandler(w http.ResponseWriter, r *http.Request) {
+    if filler == nil || filler.members == nil {
+        writeError(w, http.StatusNotFound, codeNotFound, "Distributed fill is disabled; set -fill-peers")
+        return
+    }
+    key := r.PathValue("key")
+    item, found := filler.loadOwned(r.Context(), key)
+    if !found {
+        writeError(w, http.StatusNotFound, codeKeyNotFound, "Key not found")
+        return
+    }
+    filler.count(key, item.Value)
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte(item.Value))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+    "net/http"
+
+    "lru-cache/lrucache"
+)
+
+// generationRoutes lists the endpoints reading and bumping key generations
+func generationRoutes(h *handlers) []route {
+    prefixParam := param{Name: "prefix", In: "path", Description: "Key prefix, which may contain slashes; empty covers every key", Required: true}
+    return []route{
+        {
+            Method:   http.MethodGet,
+            Path:     "/v1/generations/{prefix...}",
+            Summary:  "Get the number of times a key prefix was bumped",
+            Handler:  h.getGenerationHandler,
+            Params:   []param{prefixParam},
+            Response: []response{{Status: http.StatusOK, Description: "The current generation; 0 if never bumped", ContentType: "application/json", Body: GenerationResponse{}}},
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/v1/generations/{prefix...}",
+            Summary: "Bump the generation of a key prefix, so every key under it written before reads as missing at once",
+            Handler: h.bumpGenerationHandler,
+            Params:  []param{prefixParam},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The new generation", ContentType: "application/json", Body: GenerationResponse{}},
+            },
+        },
+    }
+}
+
+// GenerationResponse is the body of GET and POST /v1/generations/{prefix}
+type GenerationResponse struct {
+    Prefix     string `json:"prefix"`
+    Generation uint64 `json:"generation"`
+}
+
+// getGenerationHandler handles GET /v1/generations/{prefix}
+func (h *handlers) getGenerationHandler(w http.ResponseWriter, r *http.Request) {
+    txns, ok := supported[lrucache.Transactional](w, h.cache)
+    if !ok {
+        return
+    }
+    prefix := r.PathValue("prefix")
+    writeJSON(w, GenerationResponse{Prefix: prefix, Generation: txns.Generation(prefix)})
+}
+
+// bumpGenerationHandler handles POST /v1/generations/{prefix}
+func (h *handlers) bumpGenerationHandler(w http.ResponseWriter, r *http.Request) {
+    txns, ok := supported[lrucache.Transactional](w, h.cache)
+    if !ok {
+        return
+    }
+    prefix := r.PathValue("prefix")
+    span := startSpan(r.Context(), "cache.generation")
+    n, err := txns.BumpGeneration(prefix)
+    span.End()
+    if err != nil {
+        writeCacheError(w, err)
+        return
+    }
+    audit(httpCaller("http", r), "generation", prefix)
+    writeJSON(w, GenerationResponse{Prefix: prefix, Generation: n})
+}
@@ -0,0 +1,846 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// graphQLSchema documents the schema served at /graphql
+const graphQLSchema = `type Query {
+  entry(key: String!): Entry
+  entries(prefix: String = "", limit: Int = 100): [Entry!]!
+  stats: Stats!
+}
+
+type Mutation {
+  set(key: String!, value: String!, ttl: Int = 0): Entry!
+  delete(key: String!): Boolean!
+  flush: Int!
+}
+
+type Entry {
+  key: String!
+  value: String!
+  flags: Int!
+  ttl: Int
+  expiresAt: String
+}
+
+type Stats {
+  size: Int!
+  capacity: Int!
+  memoryBytes: Float!
+  processMemoryBytes: Float!
+  memoryLimitBytes: Float
+}`
+
+// GraphQLRequest is the body of a POST /graphql request
+type GraphQLRequest struct {
+    Query         string                 `json:"query"`
+    OperationName string                 `json:"operationName,omitempty"`
+    Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// GraphQLResponse carries the result data and any field errors
+type GraphQLResponse struct {
+    Data   interface{}    `json:"data,omitempty"`
+    Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+// GraphQLError is a single GraphQL error with the repo's error code as an extension
+type GraphQLError struct {
+    Message    string            `json:"message"`
+    Path       []interface{}     `json:"path,omitempty"`
+    Extensions map[string]string `json:"extensions,omitempty"`
+}
+
+// graphQLHandler executes queries from POST bodies or GET query parameters
+func (h *handlers) graphQLHandler(auth *Authenticator) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req GraphQLRequest
+        if r.Method == http.MethodGet {
+            query := r.URL.Query()
+            req.Query = query.Get("query")
+            req.OperationName = query.Get("operationName")
+            if vars := query.Get("variables"); vars != "" {
+                if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+                    writeError(w, http.StatusBadRequest, codeBadRequest, "Malformed variables parameter")
+                    return
+                }
+            }
+        } else if !h.decodeJSON(w, r, &req) {
+            return
+        }
+
+        doc, err := parseGraphQL(req.Query)
+        if err != nil {
+            writeGraphQL(w, http.StatusBadRequest, GraphQLResponse{Errors: []GraphQLError{{Message: err.Error()}}})
+            return
+        }
+        op, err := doc.operation(req.OperationName)
+        if err != nil {
+            writeGraphQL(w, http.StatusBadRequest, GraphQLResponse{Errors: []GraphQLError{{Message: err.Error()}}})
+            return
+        }
+        if op.Type == "mutation" && r.Method == http.MethodGet {
+            writeGraphQL(w, http.StatusMethodNotAllowed, GraphQLResponse{Errors: []GraphQLError{{Message: "mutations require POST"}}})
+            return
+        }
+
+        ex := &gqlExecutor{
+            cache:         h.cache,
+            maxValueBytes: h.maxValueBytes,
+            auth:          auth,
+            principal:     principalFrom(r.Context()),
+            client:        clientIP(r),
+            doc:           doc,
+            vars:          op.variables(req.Variables),
+        }
+        root := "Query"
+        if op.Type == "mutation" {
+            root = "Mutation"
+        }
+        data := ex.selectionSet(root, nil, op.Selections, nil)
+        writeGraphQL(w, http.StatusOK, GraphQLResponse{Data: data, Errors: ex.errors})
+    }
+}
+
+// graphQLSchemaHandler serves the schema in SDL form for client code generators
+func graphQLSchemaHandler(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    w.Write([]byte(graphQLSchema + "\n"))
+}
+
+func writeGraphQL(w http.ResponseWriter, status int, resp GraphQLResponse) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(resp)
+}
+
+// gqlObject is a result object that keeps fields in selection order
+type gqlObject []gqlPair
+
+type gqlPair struct {
+    Key   string
+    Value interface{}
+}
+
+// MarshalJSON encodes the fields in order
+func (o gqlObject) MarshalJSON() ([]byte, error) {
+    if o == nil {
+        return []byte("null"), nil
+    }
+    var buf bytes.Buffer
+    buf.WriteByte('{')
+    for i, p := range o {
+        if i > 0 {
+            buf.WriteByte(',')
+        }
+        key, _ := json.Marshal(p.Key)
+        buf.Write(key)
+        buf.WriteByte(':')
+        value, err := json.Marshal(p.Value)
+        if err != nil {
+            return nil, err
+        }
+        buf.Write(value)
+    }
+    buf.WriteByte('}')
+    return buf.Bytes(), nil
+}
+
+// gqlExecutor resolves one operation against the cache
+type gqlExecutor struct {
+    cache         lrucache.Cache
+    maxValueBytes int
+    auth          *Authenticator
+    principal     *Principal
+    client        string
+    doc           *gqlDocument
+    vars          map[string]interface{}
+    errors        []GraphQLError
+}
+
+// gqlEntry is the source value for the Entry type
+type gqlEntry struct {
+    Key string
+    lrucache.Item
+}
+
+func (ex *gqlExecutor) fail(path []interface{}, code, format string, args ...interface{}) {
+    ex.errors = append(ex.errors, GraphQLError{
+        Message:    fmt.Sprintf(format, args...),
+        Path:       append([]interface{}(nil), path...),
+        Extensions: map[string]string{"code": code},
+    })
+}
+
+// caller identifies the client for the audit log
+func (ex *gqlExecutor) caller() Caller {
+    return newCaller("graphql", ex.principal, ex.client)
+}
+
+// authorize records a field error and returns false when the caller lacks the role
+func (ex *gqlExecutor) authorize(path []interface{}, required Role) bool {
+    switch ex.auth.Authorize(ex.principal, required) {
+    case nil:
+        return true
+    case errNoCredentials:
+        ex.fail(path, codeUnauthorized, "Missing or invalid credentials")
+    case errReadOnly:
+        ex.fail(path, codeReadOnly, readOnlyMessage)
+    case errNoLeader:
+        ex.fail(path, codeUnavailable, noLeaderMessage)
+    default:
+        ex.fail(path, codeForbidden, "Insufficient role for this operation")
+    }
+    return false
+}
+
+// selectionSet resolves the selected fields of typeName on source
+func (ex *gqlExecutor) selectionSet(typeName string, source interface{}, selections []gqlSelection, path []interface{}) gqlObject {
+    if len(selections) == 0 {
+        ex.fail(path, codeBadRequest, "Field of type %s must have a selection of subfields", typeName)
+        return nil
+    }
+    var out gqlObject
+    seen := make(map[string]int)
+    for _, f := range ex.collectFields(selections, nil) {
+        name := f.responseKey()
+        if _, dup := seen[name]; dup {
+            continue
+        }
+        seen[name] = len(out)
+        fieldPath := append(path, name)
+        out = append(out, gqlPair{name, ex.resolve(typeName, source, f, fieldPath)})
+    }
+    return out
+}
+
+// collectFields flattens fragments and applies @skip/@include
+func (ex *gqlExecutor) collectFields(selections []gqlSelection, visited map[string]bool) []*gqlField {
+    var fields []*gqlField
+    for _, sel := range selections {
+        if !ex.included(sel.Directives) {
+            continue
+        }
+        switch {
+        case sel.Field != nil:
+            fields = append(fields, sel.Field)
+        case sel.Spread != "":
+            if visited[sel.Spread] {
+                continue
+            }
+            if visited == nil {
+                visited = make(map[string]bool)
+            }
+            visited[sel.Spread] = true
+            if frag, ok := ex.doc.Fragments[sel.Spread]; ok {
+                fields = append(fields, ex.collectFields(frag, visited)...)
+            }
+        default:
+            fields = append(fields, ex.collectFields(sel.Inline, visited)...)
+        }
+    }
+    return fields
+}
+
+func (ex *gqlExecutor) included(directives []gqlDirective) bool {
+    for _, d := range directives {
+        cond, _ := ex.value(d.Args["if"]).(bool)
+        if (d.Name == "skip" && cond) || (d.Name == "include" && !cond) {
+            return false
+        }
+    }
+    return true
+}
+
+// resolve computes a single field value
+func (ex *gqlExecutor) resolve(typeName string, source interface{}, f *gqlField, path []interface{}) interface{} {
+    if f.Name == "__typename" {
+        return typeName
+    }
+    switch typeName {
+    case "Query":
+        return ex.resolveQuery(f, path)
+    case "Mutation":
+        return ex.resolveMutation(f, path)
+    case "Entry":
+        if v, ok := resolveEntry(source.(gqlEntry), f.Name); ok {
+            return v
+        }
+    case "Stats":
+        switch f.Name {
+        case "size":
+            return ex.cache.Len()
+        case "capacity":
+            return lrucache.StatsOf(ex.cache).Capacity
+        case "memoryBytes":
+            return lrucache.StatsOf(ex.cache).Bytes
+        case "processMemoryBytes":
+            return processMemory()
+        case "memoryLimitBytes":
+            if limit := memoryLimit(); limit > 0 {
+                return limit
+            }
+            return nil
+        }
+    }
+    ex.fail(path, codeBadRequest, "Cannot query field %q on type %s", f.Name, typeName)
+    return nil
+}
+
+func (ex *gqlExecutor) resolveQuery(f *gqlField, path []interface{}) interface{} {
+    if f.Name != "entry" && f.Name != "entries" && f.Name != "stats" {
+        ex.fail(path, codeBadRequest, "Cannot query field %q on type Query", f.Name)
+        return nil
+    }
+    if !ex.authorize(path, ex.auth.ReadRole()) {
+        return nil
+    }
+    switch f.Name {
+    case "entry":
+        key, ok := ex.stringArg(f, "key", path)
+        if !ok {
+            return nil
+        }
+        item, err := ex.cache.GetItem(key)
+        if err != nil {
+            return nil
+        }
+        return ex.selectionSet("Entry", gqlEntry{key, item}, f.Selections, path)
+    case "entries":
+        prefix, _ := ex.value(f.Args["prefix"]).(string)
+        limit := 100
+        if v, ok := ex.value(f.Args["limit"]).(int); ok {
+            limit = v
+        }
+        replicated, ok := ex.cache.(lrucache.Replicated)
+        if !ok {
+            ex.fail(path, codeNotImplemented, "The cache cannot list its entries")
+            return nil
+        }
+        list := []interface{}{}
+        for _, e := range replicated.Export() {
+            if len(list) >= limit {
+                break
+            }
+            if strings.HasPrefix(e.Key, prefix) {
+                entry := gqlEntry{e.Key, lrucache.Item{Value: e.Value, Flags: e.Flags, Expiration: e.ExpiresAt}}
+                list = append(list, ex.selectionSet("Entry", entry, f.Selections, append(path, len(list))))
+            }
+        }
+        return list
+    default:
+        return ex.selectionSet("Stats", nil, f.Selections, path)
+    }
+}
+
+func (ex *gqlExecutor) resolveMutation(f *gqlField, path []interface{}) interface{} {
+    switch f.Name {
+    case "set":
+        if !ex.authorize(path, RoleWrite) {
+            return nil
+        }
+        key, ok := ex.stringArg(f, "key", path)
+        if !ok {
+            return nil
+        }
+        value, ok := ex.stringArg(f, "value", path)
+        if !ok {
+            return nil
+        }
+        if len(value) > ex.maxValueBytes {
+            ex.fail(path, codePayloadTooLarge, "Value exceeds the maximum size")
+            return nil
+        }
+        ttl, _ := ex.value(f.Args["ttl"]).(int)
+        expiration := time.Duration(ttl) * time.Second
+        if err := ex.cache.Set(key, value, expiration); err != nil {
+            e := apiCacheError(err)
+            ex.fail(path, e.Code, "%s", e.Message)
+            return nil
+        }
+        audit(ex.caller(), "set", key)
+        return ex.selectionSet("Entry", gqlEntry{key, lrucache.Item{Value: value, Expiration: lrucache.ExpiresAt(expiration)}}, f.Selections, path)
+    case "delete":
+        if !ex.authorize(path, RoleWrite) {
+            return nil
+        }
+        key, ok := ex.stringArg(f, "key", path)
+        if !ok {
+            return nil
+        }
+        deleted := ex.cache.Delete(key)
+        if deleted {
+            audit(ex.caller(), "delete", key)
+        }
+        return deleted
+    case "flush":
+        if !ex.authorize(path, RoleAdmin) {
+            return nil
+        }
+        n := ex.cache.Flush()
+        audit(ex.caller(), "flush", "")
+        return n
+    }
+    ex.fail(path, codeBadRequest, "Cannot query field %q on type Mutation", f.Name)
+    return nil
+}
+
+// resolveEntry returns a scalar field of an Entry, reporting whether the field exists
+func resolveEntry(e gqlEntry, field string) (interface{}, bool) {
+    switch field {
+    case "key":
+        return e.Key, true
+    case "value":
+        return e.Value, true
+    case "flags":
+        return e.Flags, true
+    case "ttl":
+        if e.Expiration.IsZero() {
+            return nil, true
+        }
+        return int(time.Until(e.Expiration).Round(time.Second) / time.Second), true
+    case "expiresAt":
+        if e.Expiration.IsZero() {
+            return nil, true
+        }
+        return e.Expiration.UTC().Format(time.RFC3339), true
+    }
+    return nil, false
+}
+
+// stringArg returns a required String argument, recording an error when it is missing
+func (ex *gqlExecutor) stringArg(f *gqlField, name string, path []interface{}) (string, bool) {
+    s, ok := ex.value(f.Args[name]).(string)
+    if !ok {
+        ex.fail(path, codeBadRequest, "Argument %q of field %q must be a String", name, f.Name)
+    }
+    return s, ok
+}
+
+// value substitutes variables into a parsed argument value
+func (ex *gqlExecutor) value(v interface{}) interface{} {
+    switch v := v.(type) {
+    case gqlVariable:
+        return normalizeJSON(ex.vars[string(v)])
+    case []interface{}:
+        out := make([]interface{}, len(v))
+        for i := range v {
+            out[i] = ex.value(v[i])
+        }
+        return out
+    case map[string]interface{}:
+        out := make(map[string]interface{}, len(v))
+        for k := range v {
+            out[k] = ex.value(v[k])
+        }
+        return out
+    }
+    return v
+}
+
+// normalizeJSON turns whole JSON numbers into ints to match literal Int arguments
+func normalizeJSON(v interface{}) interface{} {
+    if f, ok := v.(float64); ok && f == float64(int(f)) {
+        return int(f)
+    }
+    return v
+}
+
+// gqlDocument is a parsed GraphQL document
+type gqlDocument struct {
+    Operations []*gqlOperation
+    Fragments  map[string][]gqlSelection
+}
+
+type gqlOperation struct {
+    Type       string
+    Name       string
+    Defaults   map[string]interface{}
+    Selections []gqlSelection
+}
+
+// gqlSelection is exactly one of a field, a fragment spread or an inline fragment
+type gqlSelection struct {
+    Field      *gqlField
+    Spread     string
+    Inline     []gqlSelection
+    Directives []gqlDirective
+}
+
+type gqlField struct {
+    Alias      string
+    Name       string
+    Args       map[string]interface{}
+    Selections []gqlSelection
+}
+
+type gqlDirective struct {
+    Name string
+    Args map[string]interface{}
+}
+
+// gqlVariable is a $name reference inside an argument value
+type gqlVariable string
+
+func (f *gqlField) responseKey() string {
+    if f.Alias != "" {
+        return f.Alias
+    }
+    return f.Name
+}
+
+// operation picks the operation to run, as named by operationName when there are several
+func (d *gqlDocument) operation(name string) (*gqlOperation, error) {
+    if name == "" {
+        if len(d.Operations) != 1 {
+            return nil, fmt.Errorf("operationName is required when the document has %d operations", len(d.Operations))
+        }
+        return d.Operations[0], nil
+    }
+    for _, op := range d.Operations {
+        if op.Name == name {
+            return op, nil
+        }
+    }
+    return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+// variables merges the supplied values over the declared defaults
+func (op *gqlOperation) variables(supplied map[string]interface{}) map[string]interface{} {
+    vars := make(map[string]interface{}, len(op.Defaults)+len(supplied))
+    for k, v := range op.Defaults {
+        vars[k] = v
+    }
+    for k, v := range supplied {
+        vars[k] = v
+    }
+    return vars
+}
+
+// gqlParser is a recursive-descent parser for executable GraphQL documents
+type gqlParser struct {
+    src string
+    pos int
+    tok string // Current token; punctuators are single characters except "..."
+    str bool   // Whether tok came from a string literal
+}
+
+// parseGraphQL parses a document of operations and fragments
+func parseGraphQL(src string) (doc *gqlDocument, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            e, ok := r.(gqlSyntaxError)
+            if !ok {
+                panic(r)
+            }
+            doc, err = nil, e
+        }
+    }()
+
+    p := &gqlParser{src: src}
+    p.next()
+    doc = &gqlDocument{Fragments: make(map[string][]gqlSelection)}
+    for p.tok != "" || p.str {
+        switch {
+        case p.tok == "{":
+            doc.Operations = append(doc.Operations, &gqlOperation{Type: "query", Selections: p.selectionSet()})
+        case p.tok == "query" || p.tok == "mutation":
+            doc.Operations = append(doc.Operations, p.operation())
+        case p.tok == "fragment":
+            p.next()
+            name := p.name()
+            p.expectName("on")
+            p.name()
+            p.directives()
+            doc.Fragments[name] = p.selectionSet()
+        default:
+            p.fail("unexpected %q", p.tok)
+        }
+    }
+    if len(doc.Operations) == 0 {
+        p.fail("document contains no operations")
+    }
+    return doc, nil
+}
+
+type gqlSyntaxError string
+
+func (e gqlSyntaxError) Error() string { return string(e) }
+
+func (p *gqlParser) fail(format string, args ...interface{}) {
+    panic(gqlSyntaxError(fmt.Sprintf("syntax error at offset %d: ", p.pos) + fmt.Sprintf(format, args...)))
+}
+
+func (p *gqlParser) operation() *gqlOperation {
+    op := &gqlOperation{Type: p.tok, Defaults: make(map[string]interface{})}
+    p.next()
+    if p.isName() {
+        op.Name = p.name()
+    }
+    if p.tok == "(" {
+        p.next()
+        for p.tok != ")" {
+            p.expect("$")
+            name := p.name()
+            p.expect(":")
+            p.typeRef()
+            if p.tok == "=" {
+                p.next()
+                op.Defaults[name] = p.value()
+            }
+            p.directives()
+        }
+        p.next()
+    }
+    p.directives()
+    op.Selections = p.selectionSet()
+    return op
+}
+
+// typeRef skips a variable type such as [String!]!
+func (p *gqlParser) typeRef() {
+    if p.tok == "[" {
+        p.next()
+        p.typeRef()
+        p.expect("]")
+    } else {
+        p.name()
+    }
+    if p.tok == "!" {
+        p.next()
+    }
+}
+
+func (p *gqlParser) selectionSet() []gqlSelection {
+    p.expect("{")
+    var selections []gqlSelection
+    for p.tok != "}" {
+        if p.tok == "" && !p.str {
+            p.fail("unterminated selection set")
+        }
+        if p.tok == "..." {
+            p.next()
+            if p.isName() && p.tok != "on" {
+                name := p.name()
+                selections = append(selections, gqlSelection{Spread: name, Directives: p.directives()})
+                continue
+            }
+            if p.tok == "on" {
+                p.next()
+                p.name()
+            }
+            directives := p.directives()
+            selections = append(selections, gqlSelection{Inline: p.selectionSet(), Directives: directives})
+            continue
+        }
+        f := &gqlField{Name: p.name()}
+        if p.tok == ":" {
+            p.next()
+            f.Alias, f.Name = f.Name, p.name()
+        }
+        f.Args = p.arguments()
+        directives := p.directives()
+        if p.tok == "{" {
+            f.Selections = p.selectionSet()
+        }
+        selections = append(selections, gqlSelection{Field: f, Directives: directives})
+    }
+    p.next()
+    return selections
+}
+
+func (p *gqlParser) arguments() map[string]interface{} {
+    args := make(map[string]interface{})
+    if p.tok != "(" {
+        return args
+    }
+    p.next()
+    for p.tok != ")" {
+        name := p.name()
+        p.expect(":")
+        args[name] = p.value()
+    }
+    p.next()
+    return args
+}
+
+func (p *gqlParser) directives() []gqlDirective {
+    var directives []gqlDirective
+    for p.tok == "@" {
+        p.next()
+        name := p.name()
+        directives = append(directives, gqlDirective{Name: name, Args: p.arguments()})
+    }
+    return directives
+}
+
+// value parses a literal or variable reference
+func (p *gqlParser) value() interface{} {
+    if p.str {
+        s := p.tok
+        p.next()
+        return s
+    }
+    switch tok := p.tok; {
+    case tok == "$":
+        p.next()
+        return gqlVariable(p.name())
+    case tok == "[":
+        p.next()
+        list := []interface{}{}
+        for p.tok != "]" {
+            list = append(list, p.value())
+        }
+        p.next()
+        return list
+    case tok == "{":
+        p.next()
+        obj := make(map[string]interface{})
+        for p.tok != "}" {
+            name := p.name()
+            p.expect(":")
+            obj[name] = p.value()
+        }
+        p.next()
+        return obj
+    case tok == "true" || tok == "false":
+        p.next()
+        return tok == "true"
+    case tok == "null":
+        p.next()
+        return nil
+    case tok != "" && (tok[0] == '-' || tok[0] >= '0' && tok[0] <= '9'):
+        p.next()
+        if n, err := strconv.Atoi(tok); err == nil {
+            return n
+        }
+        f, err := strconv.ParseFloat(tok, 64)
+        if err != nil {
+            p.fail("invalid number %q", tok)
+        }
+        return f
+    case p.isName():
+        p.next()
+        return tok // Enum value
+    }
+    p.fail("unexpected %q", p.tok)
+    return nil
+}
+
+func (p *gqlParser) isName() bool {
+    if p.str || p.tok == "" {
+        return false
+    }
+    c := p.tok[0]
+    return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func (p *gqlParser) name() string {
+    if !p.isName() {
+        p.fail("expected name, found %q", p.tok)
+    }
+    name := p.tok
+    p.next()
+    return name
+}
+
+func (p *gqlParser) expect(tok string) {
+    if p.str || p.tok != tok {
+        p.fail("expected %q, found %q", tok, p.tok)
+    }
+    p.next()
+}
+
+func (p *gqlParser) expectName(name string) {
+    if p.str || p.tok != name {
+        p.fail("expected %q, found %q", name, p.tok)
+    }
+    p.next()
+}
+
+// next advances to the following token, skipping whitespace, commas and comments
+func (p *gqlParser) next() {
+    p.str = false
+    for p.pos < len(p.src) {
+        c := p.src[p.pos]
+        if c == '#' {
+            for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+                p.pos++
+            }
+            continue
+        }
+        if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+            break
+        }
+        p.pos++
+    }
+    if p.pos >= len(p.src) {
+        p.tok = ""
+        return
+    }
+
+    start := p.pos
+    c := p.src[p.pos]
+    switch {
+    case strings.HasPrefix(p.src[p.pos:], "..."):
+        p.pos += 3
+    case strings.IndexByte("!$():=@[]{}|", c) >= 0:
+        p.pos++
+    case c == '"':
+        p.tok, p.str = p.stringLiteral(), true
+        return
+    case c == '-' || c >= '0' && c <= '9' || c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+        p.pos++
+        for p.pos < len(p.src) {
+            c := p.src[p.pos]
+            if c != '_' && c != '.' && c != '+' && c != '-' && !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') {
+                break
+            }
+            p.pos++
+        }
+    default:
+        p.fail("unexpected character %q", c)
+    }
+    p.tok = p.src[start:p.pos]
+}
+
+// stringLiteral reads a quoted or block string starting at the cursor
+func (p *gqlParser) stringLiteral() string {
+    if strings.HasPrefix(p.src[p.pos:], `"""`) {
+        end := strings.Index(p.src[p.pos+3:], `"""`)
+        if end < 0 {
+            p.fail("unterminated block string")
+        }
+        s := p.src[p.pos+3 : p.pos+3+end]
+        p.pos += end + 6
+        return strings.TrimSpace(s)
+    }
+    end := p.pos + 1
+    for ; end < len(p.src) && p.src[end] != '"'; end++ {
+        if p.src[end] == '\\' {
+            end++
+        } else if p.src[end] == '\n' {
+            break
+        }
+    }
+    if end >= len(p.src) || p.src[end] != '"' {
+        p.fail("unterminated string")
+    }
+    // GraphQL string escapes are a subset of JSON's
+    var s string
+    if err := json.Unmarshal([]byte(p.src[p.pos:end+1]), &s); err != nil {
+        p.fail("invalid string escape")
+    }
+    p.pos = end + 1
+    return s
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net"
+    "strings"
+    "time"
+
+    "go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/peer"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/timestamppb"
+
+    "lru-cache/cachepb"
+    "lru-cache/lrucache"
+)
+
+// grpcServer serves cachepb.CacheService and satisfies the server interface
+type grpcServer struct {
+    addr string
+    srv  *grpc.Server
+    done chan struct{}
+}
+
+// newGRPCServer creates the gRPC listener, authenticating calls with auth
+func newGRPCServer(cfg *Config, cache lrucache.Cache, addr string, auth *Authenticator) *grpcServer {
+    g := &grpcServer{addr: addr, done: make(chan struct{})}
+    a := &grpcAuth{auth: auth}
+    opts := []grpc.ServerOption{
+        grpc.UnaryInterceptor(a.unary),
+        grpc.StreamInterceptor(a.stream),
+        grpc.MaxRecvMsgSize(cfg.MaxValueBytes + 4096),
+    }
+    if tracer != nil {
+        opts = append(opts, grpc.StatsHandler(otelgrpc.NewServerHandler()))
+    }
+    g.srv = grpc.NewServer(opts...)
+    cachepb.RegisterCacheServiceServer(g.srv, &cacheService{cache: cache, maxValueBytes: cfg.MaxValueBytes, auth: auth, done: g.done})
+    return g
+}
+
+// ListenAndServe listens on the configured address and serves until stopped
+func (g *grpcServer) ListenAndServe() error {
+    l, err := listen(g.addr)
+    if err != nil {
+        return err
+    }
+    if err := g.srv.Serve(l); err != nil && err != grpc.ErrServerStopped {
+        return err
+    }
+    return errServerClosed
+}
+
+// Shutdown ends Watch streams, then waits for in-flight calls to finish
+func (g *grpcServer) Shutdown(ctx context.Context) error {
+    close(g.done)
+    stopped := make(chan struct{})
+    go func() {
+        g.srv.GracefulStop()
+        close(stopped)
+    }()
+    select {
+    case <-stopped:
+        return nil
+    case <-ctx.Done():
+        g.srv.Stop()
+        return ctx.Err()
+    }
+}
+
+// Close stops the server immediately
+func (g *grpcServer) Close() error {
+    g.srv.Stop()
+    return nil
+}
+
+// grpcAuth authenticates calls from "authorization: Bearer" or "x-api-key" metadata
+type grpcAuth struct {
+    auth *Authenticator
+}
+
+func (a *grpcAuth) principal(ctx context.Context) context.Context {
+    md, _ := metadata.FromIncomingContext(ctx)
+    token := ""
+    if keys := md.Get("x-api-key"); len(keys) > 0 {
+        token = keys[0]
+    } else if auth := md.Get("authorization"); len(auth) > 0 && len(auth[0]) > 7 && strings.EqualFold(auth[0][:7], "Bearer ") {
+        token = strings.TrimSpace(auth[0][7:])
+    }
+    if p, err := a.auth.AuthenticateToken(token); err == nil {
+        return context.WithValue(ctx, principalKey{}, p)
+    }
+    return ctx
+}
+
+func (a *grpcAuth) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+    return handler(a.principal(ctx), req)
+}
+
+func (a *grpcAuth) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+    return handler(srv, &authedStream{ServerStream: ss, ctx: a.principal(ss.Context())})
+}
+
+// authedStream carries the authenticated principal in its context
+type authedStream struct {
+    grpc.ServerStream
+    ctx context.Context
+}
+
+func (s *authedStream) Context() context.Context {
+    return s.ctx
+}
+
+// cacheService implements cachepb.CacheServiceServer on the shared cache
+type cacheService struct {
+    cachepb.UnimplementedCacheServiceServer
+    cache         lrucache.Cache
+    maxValueBytes int
+    auth          *Authenticator
+    done          <-chan struct{}
+}
+
+// authorize maps authentication failures to gRPC status codes
+func (s *cacheService) authorize(ctx context.Context, required Role) error {
+    switch s.auth.Authorize(principalFrom(ctx), required) {
+    case nil:
+        return nil
+    case errNoCredentials:
+        return status.Error(codes.Unauthenticated, "missing or invalid credentials")
+    case errReadOnly:
+        return status.Error(codes.FailedPrecondition, "read-only node; send writes to the primary or Raft leader")
+    case errNoLeader:
+        return status.Error(codes.Unavailable, "no raft leader")
+    default:
+        return status.Error(codes.PermissionDenied, "insufficient role for this operation")
+    }
+}
+
+func (s *cacheService) Get(ctx context.Context, req *cachepb.GetRequest) (*cachepb.GetResponse, error) {
+    if err := s.authorize(ctx, s.auth.ReadRole()); err != nil {
+        return nil, err
+    }
+    return grpcGet(s.cache, req), nil
+}
+
+func (s *cacheService) Set(ctx context.Context, req *cachepb.SetRequest) (*cachepb.SetResponse, error) {
+    if err := s.authorize(ctx, RoleWrite); err != nil {
+        return nil, err
+    }
+    if len(req.GetValue()) > s.maxValueBytes {
+        return nil, status.Error(codes.InvalidArgument, "value exceeds the maximum size")
+    }
+    return grpcSet(s.cache, grpcCaller(ctx), req)
+}
+
+func (s *cacheService) Delete(ctx context.Context, req *cachepb.DeleteRequest) (*cachepb.DeleteResponse, error) {
+    if err := s.authorize(ctx, RoleWrite); err != nil {
+        return nil, err
+    }
+    deleted := s.cache.Delete(req.GetKey())
+    if deleted {
+        audit(grpcCaller(ctx), "delete", req.GetKey())
+    }
+    return &cachepb.DeleteResponse{Deleted: deleted}, nil
+}
+
+func (s *cacheService) Batch(ctx context.Context, req *cachepb.BatchRequest) (*cachepb.BatchResponse, error) {
+    // Check permissions for the whole batch up front so it is never partially applied
+    required := s.auth.ReadRole()
+    for _, op := range req.GetOperations() {
+        switch op.GetOp().(type) {
+        case *cachepb.Operation_Set, *cachepb.Operation_Delete:
+            required = RoleWrite
+        case *cachepb.Operation_Get:
+        default:
+            return nil, status.Error(codes.InvalidArgument, "operation has no request set")
+        }
+        if set := op.GetSet(); set != nil && len(set.GetValue()) > s.maxValueBytes {
+            return nil, status.Error(codes.InvalidArgument, "value exceeds the maximum size")
+        }
+    }
+    if err := s.authorize(ctx, required); err != nil {
+        return nil, err
+    }
+
+    caller := grpcCaller(ctx)
+    resp := &cachepb.BatchResponse{Results: make([]*cachepb.OperationResult, 0, len(req.GetOperations()))}
+    for _, op := range req.GetOperations() {
+        if err := ctx.Err(); err != nil {
+            return nil, status.FromContextError(err).Err()
+        }
+        result := &cachepb.OperationResult{}
+        switch op := op.GetOp().(type) {
+        case *cachepb.Operation_Get:
+            result.Result = &cachepb.OperationResult_Get{Get: grpcGet(s.cache, op.Get)}
+        case *cachepb.Operation_Set:
+            set, err := grpcSet(s.cache, caller, op.Set)
+            if err != nil {
+                return nil, err
+            }
+            result.Result = &cachepb.OperationResult_Set{Set: set}
+        case *cachepb.Operation_Delete:
+            deleted := s.cache.Delete(op.Delete.GetKey())
+            if deleted {
+                audit(caller, "delete", op.Delete.GetKey())
+            }
+            result.Result = &cachepb.OperationResult_Delete{Delete: &cachepb.DeleteResponse{Deleted: deleted}}
+        }
+        resp.Results = append(resp.Results, result)
+    }
+    return resp, nil
+}
+
+func (s *cacheService) Watch(req *cachepb.WatchRequest, stream cachepb.CacheService_WatchServer) error {
+    if err := s.authorize(stream.Context(), s.auth.ReadRole()); err != nil {
+        return err
+    }
+    sub := events.Subscribe(req.GetPrefix(), 256)
+    defer events.Unsubscribe(sub)
+
+    for {
+        select {
+        case e := <-sub.C:
+            err := stream.Send(&cachepb.WatchEvent{
+                Type:  cachepb.WatchEvent_Type(e.Type),
+                Key:   e.Key,
+                Value: e.Value,
+                Time:  timestamppb.New(e.Time),
+            })
+            if err != nil {
+                return err
+            }
+        case <-stream.Context().Done():
+            return status.FromContextError(stream.Context().Err()).Err()
+        case <-s.done:
+            return status.Error(codes.Unavailable, "server shutting down")
+        }
+    }
+}
+
+func grpcGet(cache lrucache.Cache, req *cachepb.GetRequest) *cachepb.GetResponse {
+    item, err := cache.GetItem(req.GetKey())
+    if err != nil {
+        return &cachepb.GetResponse{}
+    }
+    resp := &cachepb.GetResponse{Found: true, Value: item.Value}
+    if !item.Expiration.IsZero() {
+        resp.ExpiresAt = timestamppb.New(item.Expiration)
+    }
+    return resp
+}
+
+func grpcSet(cache lrucache.Cache, c Caller, req *cachepb.SetRequest) (*cachepb.SetResponse, error) {
+    var ttl time.Duration
+    if req.GetTtl() != nil {
+        ttl = req.GetTtl().AsDuration()
+    }
+    if err := cache.Set(req.GetKey(), req.GetValue(), ttl); err != nil {
+        return nil, grpcCacheError(err)
+    }
+    audit(c, "set", req.GetKey())
+    return &cachepb.SetResponse{}, nil
+}
+
+// grpcCacheError converts a write the cache refused into a gRPC status
+func grpcCacheError(err error) error {
+    switch {
+    case errors.Is(err, lrucache.ErrNotAdmitted):
+        return status.Error(codes.FailedPrecondition, "the key is new and was not cached; the doorkeeper caches it on its next write")
+    case errors.Is(err, lrucache.ErrTooLarge):
+        return status.Error(codes.InvalidArgument, "value exceeds the maximum size")
+    case errors.Is(err, lrucache.ErrQuotaExceeded):
+        return status.Error(codes.ResourceExhausted, "the write would exceed the namespace's quota")
+    case errors.Is(err, lrucache.ErrClosed):
+        return status.Error(codes.Unavailable, "the cache is shutting down")
+    case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+        return status.FromContextError(err).Err()
+    default:
+        return status.Error(codes.Unavailable, "the change was not committed: "+err.Error())
+    }
+}
+
+// grpcCaller identifies the client of a call for the audit log
+func grpcCaller(ctx context.Context) Caller {
+    client := "unknown"
+    if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+        client = p.Addr.String()
+        if host, _, err := net.SplitHostPort(client); err == nil {
+            client = host
+        }
+    }
+    return newCaller("grpc", principalFrom(ctx), client)
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// haPollInterval is how often a standby tries the lock and rereads which
+// instance leads
+const haPollInterval = time.Second
+
+// elector is the lock the instances of an active-passive pair compete for;
+// the holder leads and records the replication address standbys follow
+type elector interface {
+    // TryAcquire takes the lock if it is free, recording addr as the
+    // leader's, and reports whether this instance now holds it
+    TryAcquire(ctx context.Context, addr string) (bool, error)
+    // Leader returns the address the holder recorded, or "" if none is known
+    Leader(ctx context.Context) (string, error)
+    // Hold keeps the lock until ctx ends, or returns early once it is lost
+    Hold(ctx context.Context) error
+    // Release gives the lock up so that a standby takes over at once
+    Release() error
+}
+
+// newElector parses -ha-lock: file:///path or etcd://host:port/key, with
+// etcd+https:// for an etcd served over TLS
+func newElector(lock string, lease time.Duration) (elector, error) {
+    u, err := url.Parse(lock)
+    if err != nil {
+        return nil, err
+    }
+    switch u.Scheme {
+    case "file":
+        if u.Path == "" {
+            return nil, errors.New("file locks are named as file:///path/to/lock")
+        }
+        return newFileElector(u.Path)
+    case "etcd", "etcd+http", "etcd+https":
+        if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+            return nil, errors.New("etcd locks are named as etcd://host:2379/key")
+        }
+        scheme := "http"
+        if u.Scheme == "etcd+https" {
+            scheme = "https"
+        }
+        return &etcdElector{url: scheme + "://" + u.Host, key: []byte(u.Path), lease: lease}, nil
+    }
+    return nil, fmt.Errorf("unknown lock scheme %q; want file or etcd", u.Scheme)
+}
+
+// etcdElector holds a key of etcd, through its JSON gateway, under a lease the
+// leader keeps alive; etcd deletes the key once the lease runs out
+type etcdElector struct {
+    url   string
+    key   []byte
+    lease time.Duration
+    id    int64 // Of the lease holding the key
+}
+
+// etcdLease is the body of etcd's lease requests and responses, whose
+// 64-bit integers the gateway writes as strings
+type etcdLease struct {
+    ID  int64 `json:"ID,string,omitempty"`
+    TTL int64 `json:"TTL,string,omitempty"`
+}
+
+// etcdCall posts req to the gateway endpoint path and decodes the answer into resp
+func (e *etcdElector) etcdCall(ctx context.Context, path string, req, resp interface{}) error {
+    body, _ := json.Marshal(req)
+    r, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url+path, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    r.Header.Set("Content-Type", "application/json")
+    res, err := etcdClient.Do(r)
+    if err != nil {
+        return err
+    }
+    defer res.Body.Close()
+    if res.StatusCode != http.StatusOK {
+        return fmt.Errorf("etcd answered %s", res.Status)
+    }
+    return json.NewDecoder(res.Body).Decode(resp)
+}
+
+// etcdClient bounds etcd requests that the caller's context does not
+var etcdClient = &http.Client{Timeout: 5 * time.Second}
+
+// TryAcquire creates the key unless it exists, attached to a new lease
+func (e *etcdElector) TryAcquire(ctx context.Context, addr string) (bool, error) {
+    if leader, err := e.Leader(ctx); err != nil || leader != "" {
+        return false, err
+    }
+    var lease etcdLease
+    if err := e.etcdCall(ctx, "/v3/lease/grant", etcdLease{TTL: int64(max(e.lease/time.Second, 1))}, &lease); err != nil {
+        return false, err
+    }
+    // Put the key only if it was never created, as etcd's own election does
+    txn := map[string]interface{}{
+        "compare": []map[string]interface{}{{"key": e.key, "target": "CREATE", "create_revision": "0"}},
+        "success": []map[string]interface{}{{"request_put": map[string]interface{}{"key": e.key, "value": []byte(addr), "lease": fmt.Sprint(lease.ID)}}},
+    }
+    var result struct {
+        Succeeded bool `json:"succeeded"`
+    }
+    if err := e.etcdCall(ctx, "/v3/kv/txn", txn, &result); err != nil || !result.Succeeded {
+        e.etcdCall(context.WithoutCancel(ctx), "/v3/lease/revoke", etcdLease{ID: lease.ID}, &struct{}{})
+        return false, err
+    }
+    e.id = lease.ID
+    return true, nil
+}
+
+// Leader reads the key
+func (e *etcdElector) Leader(ctx context.Context) (string, error) {
+    var resp struct {
+        KVs []struct {
+            Value []byte `json:"value"`
+        } `json:"kvs"`
+    }
+    if err := e.etcdCall(ctx, "/v3/kv/range", map[string][]byte{"key": e.key}, &resp); err != nil {
+        return "", err
+    }
+    if len(resp.KVs) == 0 {
+        return "", nil
+    }
+    return string(resp.KVs[0].Value), nil
+}
+
+// Hold renews the lease a third of the way through it, giving up once it
+// could not be renewed for as long as it lasts
+func (e *etcdElector) Hold(ctx context.Context) error {
+    ticker := time.NewTicker(max(e.lease/3, 100*time.Millisecond))
+    defer ticker.Stop()
+    renewed := time.Now()
+    for {
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-ticker.C:
+        }
+        var resp struct {
+            Result etcdLease `json:"result"`
+        }
+        err := e.etcdCall(ctx, "/v3/lease/keepalive", etcdLease{ID: e.id}, &resp)
+        switch {
+        case err == nil && resp.Result.TTL > 0:
+            renewed = time.Now()
+        case err == nil:
+            return errors.New("the lease expired")
+        case time.Since(renewed) >= e.lease:
+            return fmt.Errorf("the lease could not be renewed: %w", err)
+        }
+    }
+}
+
+// Release revokes the lease, which deletes the key
+func (e *etcdElector) Release() error {
+    if e.id == 0 {
+        return nil
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    err := e.etcdCall(ctx, "/v3/lease/revoke", etcdLease{ID: e.id}, &struct{}{})
+    e.id = 0
+    return err
+}
+
+// HAStatus is returned by GET /ha
+type HAStatus struct {
+    Role      string `json:"role"`             // leader or standby
+    Leader    string `json:"leader,omitempty"` // Replication address of the leader a standby follows
+    Advertise string `json:"advertise"`        // This instance's replication address
+    Synced    bool   `json:"synced"`           // Whether a standby holds a full copy of the leader's cache
+}
+
+// haNode runs the active-passive mode: whichever instance holds the lock
+// serves writes while the other follows its replication stream, serving
+// reads only, and takes the lock over once the leader lets it go or dies
+type haNode struct {
+    elector   elector
+    cache     *lrucache.LRUCache
+    lock      string // -ha-lock, for log messages
+    advertise string
+    token     string
+
+    leading  atomic.Bool
+    mutex    sync.Mutex // Guards leader and replica
+    leader   string
+    replica  *replicaClient // While following a leader
+    done     chan struct{}
+    stopped  chan struct{}
+    stopOnce sync.Once
+}
+
+// newHANode creates the HA controller of cfg for cache
+func newHANode(cfg *Config, cache *lrucache.LRUCache) (*haNode, error) {
+    e, err := newElector(cfg.HALock, cfg.HALease)
+    if err != nil {
+        return nil, err
+    }
+    advertise, err := haAdvertise(cfg.ReplicationListen, cfg.HAAdvertise)
+    if err != nil {
+        return nil, err
+    }
+    return &haNode{
+        elector:   e,
+        cache:     cache,
+        lock:      redactURL(cfg.HALock),
+        advertise: advertise,
+        token:     cfg.ReplicationToken,
+        done:      make(chan struct{}),
+        stopped:   make(chan struct{}),
+    }, nil
+}
+
+// haAdvertise returns the replication address the standby reaches this
+// instance at: advertise, or listen when it names a host
+func haAdvertise(listen, advertise string) (string, error) {
+    if advertise == "" {
+        advertise = listen
+    }
+    host, _, err := net.SplitHostPort(advertise)
+    if err != nil {
+        return "", fmt.Errorf("-ha-advertise: %w", err)
+    }
+    if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+        return "", errors.New("-ha-advertise must name a host the standby can reach when -replication-listen does not")
+    }
+    return advertise, nil
+}
+
+// gate refuses writes unless this instance leads
+func (n *haNode) gate(write bool) error {
+    if write && !n.leading.Load() {
+        return errReadOnly
+    }
+    return nil
+}
+
+// Status reports the role of this instance
+func (n *haNode) Status() HAStatus {
+    n.mutex.Lock()
+    defer n.mutex.Unlock()
+    s := HAStatus{Role: "standby", Leader: n.leader, Advertise: n.advertise}
+    if n.leading.Load() {
+        s.Role, s.Leader, s.Synced = "leader", n.advertise, true
+    } else if n.replica != nil {
+        s.Synced = n.replica.synced.Load()
+    }
+    return s
+}
+
+// ListenAndServe competes for the lock until shut down, following the
+// leader while standing by
+func (n *haNode) ListenAndServe() error {
+    defer close(n.stopped)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go func() {
+        <-n.done
+        cancel()
+    }()
+    for {
+        if !n.standBy(ctx) {
+            return errServerClosed
+        }
+        n.follow("")
+        n.leading.Store(true)
+        slog.Info("ha: leading", "lock", n.lock, "advertise", n.advertise)
+        err := n.elector.Hold(ctx)
+        n.leading.Store(false)
+        if ctx.Err() != nil {
+            if err := n.elector.Release(); err != nil {
+                slog.Warn("ha: cannot release the lock; the standby takes over when it expires", "lock", n.lock, "err", err)
+            }
+            return errServerClosed
+        }
+        slog.Error("ha: lost the lock, standing by", "lock", n.lock, "err", err)
+    }
+}
+
+// standBy follows whichever instance leads until this one takes the lock,
+// returning false if shut down first
+func (n *haNode) standBy(ctx context.Context) bool {
+    warned := false
+    for {
+        acquired, err := n.elector.TryAcquire(ctx, n.advertise)
+        if ctx.Err() != nil {
+            n.follow("")
+            return false
+        }
+        if acquired {
+            return true
+        }
+        var leader string
+        if err == nil {
+            leader, err = n.elector.Leader(ctx)
+        }
+        switch {
+        case err != nil && !warned:
+            slog.Warn("ha: cannot reach the lock", "lock", n.lock, "err", err)
+            warned = true
+        case err == nil:
+            warned = false
+            if leader != "" { // Empty while the holder is still writing its address
+                n.follow(leader)
+            }
+        }
+        select {
+        case <-ctx.Done():
+            n.follow("")
+            return false
+        case <-time.After(haPollInterval):
+        }
+    }
+}
+
+// follow replicates from the leader at addr, or stops replicating when addr
+// is empty or this instance's own
+func (n *haNode) follow(addr string) {
+    if addr == n.advertise {
+        addr = ""
+    }
+    n.mutex.Lock()
+    defer n.mutex.Unlock()
+    if addr == n.leader {
+        return
+    }
+    if n.replica != nil {
+        n.replica.Close()
+        n.replica = nil
+    }
+    n.leader = addr
+    if addr != "" {
+        slog.Info("ha: standing by, following the leader", "leader", addr)
+        n.replica = newReplicaClient(n.cache, addr, n.token)
+        go n.replica.ListenAndServe()
+    }
+}
+
+// Shutdown stops competing for the lock and releases it if held, so that
+// the standby takes over without waiting for it to expire
+func (n *haNode) Shutdown(ctx context.Context) error {
+    n.stopOnce.Do(func() { close(n.done) })
+    select {
+    case <-n.stopped:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Close stops without waiting for the lock to be released
+func (n *haNode) Close() error {
+    n.stopOnce.Do(func() { close(n.done) })
+    return nil
+}
+
+// haRoute is the endpoint load balancers check to send traffic to the leader
+func haRoute(h *handlers) route {
+    return route{
+        Method:  http.MethodGet,
+        Path:    "/ha",
+        Summary: "Show whether this instance leads its active-passive pair; a standby answers 503 so that health checks route writes to the leader",
+        Handler: h.haHandler,
+        Response: []response{
+            {Status: http.StatusOK, Description: "This instance leads", ContentType: "application/json", Body: HAStatus{}},
+            {Status: http.StatusServiceUnavailable, Description: "This instance stands by", ContentType: "application/json", Body: HAStatus{}},
+            errorResponse(http.StatusNotFound, "HA is disabled (NOT_FOUND)"),
+        },
+    }
+}
+
+// haHandler handles GET /ha
+func (h *handlers) haHandler(w http.ResponseWriter, r *http.Request) {
+    if h.ha == nil {
+        writeError(w, http.StatusNotFound, codeNotFound, "HA is disabled; set -ha-lock")
+        return
+    }
+    status := h.ha.Status()
+    if status.Role != "leader" {
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(http.StatusServiceUnavailable)
+        json.NewEncoder(w).Encode(status)
+        return
+    }
+    writeJSON(w, status)
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func newFileElector(path string) (elector, error) {
+    return nil, errors.New("file locks need a unix system; use an etcd:// -ha-lock")
+}
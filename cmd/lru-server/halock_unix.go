@@ -0,0 +1,79 @@
+//go:build unix
+
+package main
+
+import (
+    "context"
+    "io"
+    "os"
+    "strings"
+    "syscall"
+)
+
+// fileElector holds an flock(2) lock on a file shared by both instances, as
+// on one host or a network filesystem honouring it; the kernel drops the
+// lock when the holder dies
+type fileElector struct {
+    path string
+    file *os.File // While held
+}
+
+func newFileElector(path string) (elector, error) {
+    return &fileElector{path: path}, nil
+}
+
+// TryAcquire locks the file without waiting and writes addr into it
+func (e *fileElector) TryAcquire(ctx context.Context, addr string) (bool, error) {
+    f, err := os.OpenFile(e.path, os.O_RDWR|os.O_CREATE, 0o644)
+    if err != nil {
+        return false, err
+    }
+    if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+        f.Close()
+        if err == syscall.EWOULDBLOCK {
+            return false, nil
+        }
+        return false, err
+    }
+    if err := f.Truncate(0); err != nil {
+        f.Close()
+        return false, err
+    }
+    if _, err := f.WriteAt([]byte(addr+"\n"), 0); err != nil {
+        f.Close()
+        return false, err
+    }
+    f.Sync()
+    e.file = f
+    return true, nil
+}
+
+// Leader reads the address the holder wrote
+func (e *fileElector) Leader(ctx context.Context) (string, error) {
+    f, err := os.Open(e.path)
+    if os.IsNotExist(err) {
+        return "", nil
+    } else if err != nil {
+        return "", err
+    }
+    defer f.Close()
+    data, err := io.ReadAll(io.LimitReader(f, 1024))
+    return strings.TrimSpace(string(data)), err
+}
+
+// Hold keeps the lock until ctx ends; only the process dying loses it
+func (e *fileElector) Hold(ctx context.Context) error {
+    <-ctx.Done()
+    return ctx.Err()
+}
+
+// Release empties the file and unlocks it
+func (e *fileElector) Release() error {
+    if e.file == nil {
+        return nil
+    }
+    f := e.file
+    e.file = nil
+    f.Truncate(0)
+    return f.Close() // Closing the last descriptor releases the lock
+}
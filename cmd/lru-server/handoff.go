@@ -0,0 +1,201 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Hinted handoff tuning
+const (
+    proxyHintReplay = time.Second // How often hints are offered to owners that are back
+    proxyHintMaxAge = time.Hour   // Hints older than this are dropped instead of replayed
+)
+
+// hint is a write another backend accepted while the key's owner was unreachable
+type hint struct {
+    method  string
+    uri     string
+    body    []byte
+    header  http.Header
+    standIn *backend // The backend that accepted the write; nil for a replica that missed it
+    at      time.Time
+}
+
+// hintStore keeps the latest missed write of every key per owner, so a
+// backend that was down briefly gets what it missed when it returns
+type hintStore struct {
+    max      int
+    mutex    sync.Mutex                    // Guards pending and count
+    pending  map[*backend]map[string]*hint // By owner, then key
+    count    int
+    hinted   atomic.Uint64
+    replayed atomic.Uint64
+    expired  atomic.Uint64
+    dropped  atomic.Uint64
+}
+
+// HintStatus is published as expvar "proxy_hints"
+type HintStatus struct {
+    Pending  int    `json:"pending"`
+    Hinted   uint64 `json:"hinted"`   // Writes another backend accepted for an unreachable owner
+    Replayed uint64 `json:"replayed"` // Hints the owner applied once it was back
+    Expired  uint64 `json:"expired"`  // Hints whose owner stayed away longer than an hour
+    Dropped  uint64 `json:"dropped"`  // Hints lost because the store was full or their owner left the ring
+}
+
+func newHintStore(max int) *hintStore {
+    return &hintStore{max: max, pending: make(map[*backend]map[string]*hint)}
+}
+
+// Status reports the counters
+func (s *hintStore) Status() HintStatus {
+    s.mutex.Lock()
+    pending := s.count
+    s.mutex.Unlock()
+    return HintStatus{Pending: pending, Hinted: s.hinted.Load(), Replayed: s.replayed.Load(), Expired: s.expired.Load(), Dropped: s.dropped.Load()}
+}
+
+// wrote notes that b accepted a write of key meant for owner; a nil b means
+// no backend took it in the owner's place
+func (s *hintStore) wrote(owner, b *backend, key, method, uri string, header http.Header, body []byte) {
+    if s == nil || owner == nil {
+        return
+    }
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    if owner == b {
+        // The owner has this write, so an older hint must not overwrite it
+        s.remove(owner, key)
+        return
+    }
+    hints := s.pending[owner]
+    if hints == nil {
+        hints = make(map[string]*hint)
+        s.pending[owner] = hints
+    }
+    if hints[key] == nil {
+        if s.count >= s.max {
+            s.dropped.Add(1)
+            return
+        }
+        s.count++
+    }
+    kept := make(http.Header)
+    for _, h := range proxyHeaders {
+        if v := header.Get(h); v != "" {
+            kept.Set(h, v)
+        }
+    }
+    hints[key] = &hint{method: method, uri: uri, body: body, header: kept, standIn: b, at: time.Now()}
+    s.hinted.Add(1)
+}
+
+// remove drops the hint for key; the caller holds the mutex
+func (s *hintStore) remove(owner *backend, key string) {
+    if _, ok := s.pending[owner][key]; !ok {
+        return
+    }
+    delete(s.pending[owner], key)
+    s.count--
+    if len(s.pending[owner]) == 0 {
+        delete(s.pending, owner)
+    }
+}
+
+// current reports whether h is still the latest hint for key
+func (s *hintStore) current(owner *backend, key string, h *hint) bool {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    return s.pending[owner][key] == h
+}
+
+// done drops h unless a later write of its key replaced it, reporting
+// whether it did
+func (s *hintStore) done(owner *backend, key string, h *hint) bool {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    if s.pending[owner][key] != h {
+        return false
+    }
+    s.remove(owner, key)
+    return true
+}
+
+// due returns a copy of the hints of every owner that is not ejected
+func (s *hintStore) due() map[*backend]map[string]*hint {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    now := time.Now().UnixNano()
+    out := make(map[*backend]map[string]*hint)
+    for owner, hints := range s.pending {
+        if owner.ejectedUntil.Load() > now {
+            continue
+        }
+        out[owner] = make(map[string]*hint, len(hints))
+        for key, h := range hints {
+            out[owner][key] = h
+        }
+    }
+    return out
+}
+
+// forget drops the hints of a backend that left the ring; its keys now
+// belong to others
+func (s *hintStore) forget(owner *backend) {
+    if s == nil {
+        return
+    }
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    if n := len(s.pending[owner]); n > 0 {
+        s.count -= n
+        s.dropped.Add(uint64(n))
+        delete(s.pending, owner)
+        slog.Warn("proxy: dropped hints of removed backend", "url", owner.url, "hints", n)
+    }
+}
+
+// handOff replays the hints of every owner that is not ejected, stopping at
+// an owner's first failure since it is still down, and removes the copies
+// the stand-ins kept
+func (p *Proxy) handOff() {
+    ctx := context.Background()
+    for owner, hints := range p.hints.due() {
+        replayed := 0
+        for key, h := range hints {
+            if time.Since(h.at) > proxyHintMaxAge {
+                if p.hints.done(owner, key, h) {
+                    p.hints.expired.Add(1)
+                }
+                continue
+            }
+            if !p.hints.current(owner, key, h) {
+                continue
+            }
+            resp, err := p.send(ctx, h.header, owner, h.method, h.uri, h.body)
+            if err != nil {
+                break // Retried on a later tick
+            }
+            resp.Body.Close()
+            if !p.hints.done(owner, key, h) {
+                continue // A later write replaced it while it was in flight
+            }
+            p.hints.replayed.Add(1)
+            replayed++
+            // Reads go to the owner again, so the stand-in's copy would only turn stale
+            if h.standIn != nil && h.method != http.MethodDelete && p.owner(key) != h.standIn {
+                if resp, err := p.send(ctx, h.header, h.standIn, http.MethodDelete, "/v1/cache/"+url.PathEscape(key), nil); err == nil {
+                    resp.Body.Close()
+                }
+            }
+        }
+        if replayed > 0 {
+            slog.Info("proxy: handed hints to backend", "url", owner.url, "hints", replayed)
+        }
+    }
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "sort"
+    "strconv"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "lru-cache/lrucache"
+)
+
+var fieldParam = param{Name: "field", In: "path", Description: "Hash field", Required: true}
+
+// hashRoutes lists the endpoints reading and writing hash fields
+func hashRoutes(h *handlers) []route {
+    return []route{
+        {
+            Method:  http.MethodGet,
+            Path:    "/v1/cache/{key}/fields",
+            Summary: "Get every field of a hash",
+            Handler: h.getHashHandler,
+            Params:  []param{keyParam, consistencyParam},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The fields", ContentType: "application/json", Body: HashResponse{}},
+                errorResponse(http.StatusNotFound, "Key not found (KEY_NOT_FOUND) or expired (EXPIRED)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/v1/cache/{key}/fields/{field}",
+            Summary: "Get one field of a hash",
+            Handler: h.getFieldHandler,
+            Params:  []param{keyParam, fieldParam, consistencyParam},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The field's value", ContentType: "text/plain"},
+                errorResponse(http.StatusNotFound, "Key or field not found (KEY_NOT_FOUND) or key expired (EXPIRED)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+        {
+            Method:  http.MethodPut,
+            Path:    "/v1/cache/{key}/fields/{field}",
+            Summary: "Set one field of a hash, creating the hash if needed; the hash keeps its expiration",
+            Handler: h.putFieldHandler,
+            Params:  []param{keyParam, fieldParam},
+            Body:    FieldRequest{},
+            Response: []response{
+                {Status: http.StatusCreated, Description: "New field stored"},
+                {Status: http.StatusNoContent, Description: "Field updated"},
+                errorResponse(http.StatusBadRequest, "Malformed request body (BAD_REQUEST)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+                errorResponse(http.StatusRequestEntityTooLarge, "Hash too large (PAYLOAD_TOO_LARGE)"),
+            },
+        },
+        {
+            Method:  http.MethodDelete,
+            Path:    "/v1/cache/{key}/fields/{field}",
+            Summary: "Delete one field of a hash; deleting the last field deletes the key",
+            Handler: h.deleteFieldHandler,
+            Params:  []param{keyParam, fieldParam},
+            Response: []response{
+                {Status: http.StatusNoContent, Description: "Field deleted"},
+                errorResponse(http.StatusNotFound, "Key or field not found (KEY_NOT_FOUND)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+    }
+}
+
+// HashResponse is the body of GET /v1/cache/{key}/fields
+type HashResponse struct {
+    Fields map[string]string `json:"fields"`
+}
+
+// FieldRequest is the body of PUT /v1/cache/{key}/fields/{field}
+type FieldRequest struct {
+    Value string `json:"value"`
+}
+
+// getHashHandler handles GET /v1/cache/{key}/fields
+func (h *handlers) getHashHandler(w http.ResponseWriter, r *http.Request) {
+    hashes, ok := supported[lrucache.Hashes](w, h.cache)
+    if !ok {
+        return
+    }
+    span := startSpan(r.Context(), "cache.hgetall")
+    fields, err := hashes.HGetAll(r.PathValue("key"))
+    span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+    span.End()
+    if err != nil {
+        writeLookupError(w, err)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(HashResponse{Fields: fields})
+}
+
+// getFieldHandler handles GET /v1/cache/{key}/fields/{field}
+func (h *handlers) getFieldHandler(w http.ResponseWriter, r *http.Request) {
+    hashes, ok := supported[lrucache.Hashes](w, h.cache)
+    if !ok {
+        return
+    }
+    span := startSpan(r.Context(), "cache.hget")
+    value, err := hashes.HGet(r.PathValue("key"), r.PathValue("field"))
+    span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+    span.End()
+    if err != nil {
+        writeLookupError(w, err)
+        return
+    }
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte(value))
+}
+
+// putFieldHandler handles PUT /v1/cache/{key}/fields/{field}
+func (h *handlers) putFieldHandler(w http.ResponseWriter, r *http.Request) {
+    hashes, ok := supported[lrucache.Hashes](w, h.cache)
+    if !ok {
+        return
+    }
+    var req FieldRequest
+    if !h.decodeJSON(w, r, &req) || !h.checkValueSize(w, req.Value) {
+        return
+    }
+
+    span := startSpan(r.Context(), "cache.hset")
+    span.SetAttributes(attribute.Int("cache.value_bytes", len(req.Value)))
+    created, err := hashes.HSet(r.PathValue("key"), r.PathValue("field"), req.Value)
+    span.End()
+    if err != nil {
+        writeCacheError(w, err)
+        return
+    }
+    audit(httpCaller("http", r), "hset", r.PathValue("key"))
+    if created {
+        w.WriteHeader(http.StatusCreated)
+    } else {
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// deleteFieldHandler handles DELETE /v1/cache/{key}/fields/{field}
+func (h *handlers) deleteFieldHandler(w http.ResponseWriter, r *http.Request) {
+    hashes, ok := supported[lrucache.Hashes](w, h.cache)
+    if !ok {
+        return
+    }
+    span := startSpan(r.Context(), "cache.hdel")
+    n, err := hashes.HDel(r.PathValue("key"), r.PathValue("field"))
+    span.SetAttributes(attribute.Bool("cache.hit", n > 0))
+    span.End()
+    switch {
+    case err != nil:
+        writeCacheError(w, err)
+    case n == 0:
+        writeError(w, http.StatusNotFound, codeKeyNotFound, "Field not found")
+    default:
+        audit(httpCaller("http", r), "hdel", r.PathValue("key"))
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// hash implements HSET key field value [field value ...], HGET key field,
+// HDEL key field [field ...] and HGETALL key
+func (s *respSession) hash(cmd string, args []string) {
+    switch {
+    case cmd == "HSET" && (len(args) < 3 || len(args)%2 == 0),
+        cmd == "HGET" && len(args) != 2,
+        cmd == "HDEL" && len(args) < 2,
+        cmd == "HGETALL" && len(args) != 1:
+        s.writeWrongArgs(cmd)
+        return
+    }
+    key := args[0]
+    hashes, ok := respSupported[lrucache.Hashes](s)
+    if !ok {
+        return
+    }
+    switch cmd {
+    case "HSET":
+        if !s.allowed(RoleWrite) {
+            return
+        }
+        var n int64
+        for i := 1; i < len(args); i += 2 {
+            created, err := hashes.HSet(key, args[i], args[i+1])
+            if err != nil {
+                s.writeCacheError(err)
+                return
+            }
+            if created {
+                n++
+            }
+        }
+        audit(s.caller(), "hset", key)
+        s.writeInt(n)
+    case "HDEL":
+        if !s.allowed(RoleWrite) {
+            return
+        }
+        n, err := hashes.HDel(key, args[1:]...)
+        if err != nil {
+            s.writeCacheError(err)
+            return
+        }
+        if n > 0 {
+            audit(s.caller(), "hdel", key)
+        }
+        s.writeInt(int64(n))
+    case "HGET":
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        value, err := hashes.HGet(key, args[1])
+        switch {
+        case err == nil:
+            s.writeBulk(value)
+        case errors.Is(err, lrucache.ErrWrongType):
+            s.writeCacheError(err)
+        default:
+            s.writeNull()
+        }
+    case "HGETALL":
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        fields, err := hashes.HGetAll(key)
+        if errors.Is(err, lrucache.ErrWrongType) {
+            s.writeCacheError(err)
+            return
+        }
+        names := make([]string, 0, len(fields))
+        for name := range fields {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        s.w.WriteString("*" + strconv.Itoa(2*len(names)) + "\r\n")
+        for _, name := range names {
+            s.writeBulk(name)
+            s.writeBulk(fields[name])
+        }
+    }
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strconv"
+
+    "lru-cache/lrucache"
+)
+
+// HeatmapResponse is returned by GET /cache/heatmap
+type HeatmapResponse struct {
+    Depth    int                     `json:"depth"`
+    Prefixes []lrucache.HeatmapEntry `json:"prefixes"`
+}
+
+// heatmapHandler serves the read counts per prefix, optionally only the busiest ones
+func (h *handlers) heatmapHandler(w http.ResponseWriter, r *http.Request) {
+    admin, ok := supported[lrucache.Administered](w, h.cache)
+    if !ok {
+        return
+    }
+    heatmap := admin.Heatmap()
+    if heatmap == nil {
+        writeError(w, http.StatusNotFound, codeNotFound, "The heatmap is disabled; set -heatmap-depth")
+        return
+    }
+    entries := heatmap.Snapshot()
+    if s := r.URL.Query().Get("limit"); s != "" {
+        limit, err := strconv.Atoi(s)
+        if err != nil || limit < 1 {
+            writeError(w, http.StatusBadRequest, codeBadRequest, "limit must be a positive integer")
+            return
+        }
+        entries = entries[:min(limit, len(entries))]
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(HeatmapResponse{Depth: heatmap.Depth(), Prefixes: entries})
+}
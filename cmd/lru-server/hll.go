@@ -0,0 +1,187 @@
+package main
+
+import (
+    "net/http"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "lru-cache/lrucache"
+)
+
+// hllRoutes lists the endpoints adding to, counting and merging HyperLogLogs
+func hllRoutes(h *handlers) []route {
+    return []route{
+        {
+            Method:  http.MethodGet,
+            Path:    "/v1/cache/{key}/distinct",
+            Summary: "Estimate the number of distinct elements added to a HyperLogLog, within 0.81%",
+            Handler: h.countDistinctHandler,
+            Params: []param{
+                keyParam,
+                {Name: "union", In: "query", Description: "Further HyperLogLog keys, repeated, whose elements are counted too, each once"},
+                consistencyParam,
+            },
+            Response: []response{
+                {Status: http.StatusOK, Description: "The estimate; 0 for missing keys", ContentType: "application/json", Body: DistinctResponse{}},
+                errorResponse(http.StatusConflict, "A key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/v1/cache/{key}/distinct",
+            Summary: "Add elements to a HyperLogLog, creating it if needed; it keeps its expiration",
+            Handler: h.addDistinctHandler,
+            Params:  []param{keyParam},
+            Body:    DistinctRequest{},
+            Response: []response{
+                {Status: http.StatusOK, Description: "Whether the estimate may have changed", ContentType: "application/json", Body: DistinctAddResponse{}},
+                errorResponse(http.StatusBadRequest, "Malformed request body (BAD_REQUEST)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/v1/cache/{key}/distinct/merge",
+            Summary: "Merge HyperLogLogs into this one, creating it if needed",
+            Handler: h.mergeDistinctHandler,
+            Params:  []param{keyParam},
+            Body:    MergeRequest{},
+            Response: []response{
+                {Status: http.StatusNoContent, Description: "Merged"},
+                errorResponse(http.StatusBadRequest, "Malformed request body (BAD_REQUEST)"),
+                errorResponse(http.StatusConflict, "A key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+    }
+}
+
+// DistinctRequest is the body of POST /v1/cache/{key}/distinct
+type DistinctRequest struct {
+    Elements []string `json:"elements"`
+}
+
+// DistinctAddResponse answers POST /v1/cache/{key}/distinct
+type DistinctAddResponse struct {
+    Changed bool `json:"changed"`
+}
+
+// DistinctResponse is the body of GET /v1/cache/{key}/distinct
+type DistinctResponse struct {
+    Count int64 `json:"count"`
+}
+
+// MergeRequest is the body of POST /v1/cache/{key}/distinct/merge
+type MergeRequest struct {
+    Sources []string `json:"sources"`
+}
+
+// countDistinctHandler handles GET /v1/cache/{key}/distinct
+func (h *handlers) countDistinctHandler(w http.ResponseWriter, r *http.Request) {
+    hlls, ok := supported[lrucache.HyperLogLogs](w, h.cache)
+    if !ok {
+        return
+    }
+    keys := append([]string{r.PathValue("key")}, r.URL.Query()["union"]...)
+    span := startSpan(r.Context(), "cache.pfcount")
+    span.SetAttributes(attribute.Int("cache.keys", len(keys)))
+    n, err := hlls.PFCount(keys...)
+    span.End()
+    if err != nil {
+        writeCacheError(w, err)
+        return
+    }
+    writeJSON(w, DistinctResponse{Count: n})
+}
+
+// addDistinctHandler handles POST /v1/cache/{key}/distinct
+func (h *handlers) addDistinctHandler(w http.ResponseWriter, r *http.Request) {
+    hlls, ok := supported[lrucache.HyperLogLogs](w, h.cache)
+    if !ok {
+        return
+    }
+    var req DistinctRequest
+    if !h.decodeJSON(w, r, &req) {
+        return
+    }
+    span := startSpan(r.Context(), "cache.pfadd")
+    span.SetAttributes(attribute.Int("cache.values", len(req.Elements)))
+    changed, err := hlls.PFAdd(r.PathValue("key"), req.Elements...)
+    span.End()
+    if err != nil {
+        writeCacheError(w, err)
+        return
+    }
+    audit(httpCaller("http", r), "pfadd", r.PathValue("key"))
+    writeJSON(w, DistinctAddResponse{Changed: changed})
+}
+
+// mergeDistinctHandler handles POST /v1/cache/{key}/distinct/merge
+func (h *handlers) mergeDistinctHandler(w http.ResponseWriter, r *http.Request) {
+    hlls, ok := supported[lrucache.HyperLogLogs](w, h.cache)
+    if !ok {
+        return
+    }
+    var req MergeRequest
+    if !h.decodeJSON(w, r, &req) || !checkTenantKeys(w, r, req.Sources...) {
+        return
+    }
+    span := startSpan(r.Context(), "cache.pfmerge")
+    span.SetAttributes(attribute.Int("cache.keys", len(req.Sources)))
+    err := hlls.PFMerge(r.PathValue("key"), req.Sources...)
+    span.End()
+    if err != nil {
+        writeCacheError(w, err)
+        return
+    }
+    audit(httpCaller("http", r), "pfmerge", r.PathValue("key"))
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// hyperLogLog implements PFADD key [element ...], PFCOUNT key [key ...] and
+// PFMERGE dest [source ...]
+func (s *respSession) hyperLogLog(cmd string, args []string) {
+    if len(args) < 1 {
+        s.writeWrongArgs(cmd)
+        return
+    }
+    hlls, ok := respSupported[lrucache.HyperLogLogs](s)
+    if !ok {
+        return
+    }
+    switch cmd {
+    case "PFADD":
+        if !s.allowed(RoleWrite) {
+            return
+        }
+        changed, err := hlls.PFAdd(args[0], args[1:]...)
+        switch {
+        case err != nil:
+            s.writeCacheError(err)
+        case changed:
+            audit(s.caller(), "pfadd", args[0])
+            s.writeInt(1)
+        default:
+            s.writeInt(0)
+        }
+    case "PFCOUNT":
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        n, err := hlls.PFCount(args...)
+        if err != nil {
+            s.writeCacheError(err)
+            return
+        }
+        s.writeInt(n)
+    case "PFMERGE":
+        if !s.allowed(RoleWrite) {
+            return
+        }
+        if err := hlls.PFMerge(args[0], args[1:]...); err != nil {
+            s.writeCacheError(err)
+            return
+        }
+        audit(s.caller(), "pfmerge", args[0])
+        s.writeSimple("OK")
+    }
+}
@@ -0,0 +1,46 @@
+//go:build http3
+
+package main
+
+import (
+    "net/http"
+
+    "github.com/quic-go/quic-go/http3"
+)
+
+// http3Server serves HTTP/3 over QUIC on a UDP port
+type http3Server struct {
+    *http3.Server
+    certFile string
+    keyFile  string
+}
+
+// newHTTP3Server serves handler over HTTP/3 with the TLS certificate from the config
+func newHTTP3Server(cfg *Config, addr string, handler http.Handler) server {
+    return &http3Server{
+        Server: &http3.Server{
+            Addr:           addr,
+            Handler:        handler,
+            IdleTimeout:    cfg.IdleTimeout,
+            MaxHeaderBytes: cfg.MaxHeaderBytes,
+        },
+        certFile: cfg.TLSCert,
+        keyFile:  cfg.TLSKey,
+    }
+}
+
+// ListenAndServe listens on the UDP address and serves until shut down
+func (s *http3Server) ListenAndServe() error {
+    return s.ListenAndServeTLS(s.certFile, s.keyFile)
+}
+
+// altSvcMiddleware advertises the HTTP/3 endpoint to HTTPS clients so they can switch over
+func altSvcMiddleware(h3 server, next http.Handler) http.Handler {
+    s := h3.(*http3Server)
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.ProtoMajor < 3 {
+            s.SetQUICHeaders(w.Header())
+        }
+        next.ServeHTTP(w, r)
+    })
+}
@@ -0,0 +1,27 @@
+//go:build !http3
+
+package main
+
+import (
+    "context"
+    "errors"
+    "net/http"
+)
+
+// errHTTP3Disabled is returned when -http3-listen is used without the build tag
+var errHTTP3Disabled = errors.New("http3: this binary was built without -tags http3")
+
+// disabledServer fails to start, keeping the quic-go dependency out of default builds
+type disabledServer struct{}
+
+func (disabledServer) ListenAndServe() error              { return errHTTP3Disabled }
+func (disabledServer) Shutdown(ctx context.Context) error { return nil }
+func (disabledServer) Close() error                       { return nil }
+
+func newHTTP3Server(cfg *Config, addr string, handler http.Handler) server {
+    return disabledServer{}
+}
+
+func altSvcMiddleware(h3 server, next http.Handler) http.Handler {
+    return next
+}
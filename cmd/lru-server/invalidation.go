@@ -0,0 +1,147 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "log/slog"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// Invalidation tuning
+const (
+    invalidateMessage byte = 'I'                   // Gossip message kind: a JSON array of keys
+    invalidateDelay        = 10 * time.Millisecond // How long changes are collected into one message
+    invalidateBuffer       = 1 << 14               // Changes queued before they are dropped
+    invalidatePacket       = 1200                  // Payload bytes that fit a UDP packet with memberlist's framing
+)
+
+// invalidator is nil unless -gossip-invalidate is set
+var invalidator *Invalidator
+
+// Invalidator tells the other members to drop keys that were set or deleted
+// here, so they do not keep serving stale copies. Delivery is best effort:
+// batches go over UDP and a lost packet leaves the copy until it expires.
+type Invalidator struct {
+    cache    *lrucache.LRUCache
+    members  *Membership
+    sub      *lrucache.Subscription
+    sent     atomic.Uint64 // Keys broadcast, however many members they went to
+    received atomic.Uint64
+    done     chan struct{}
+    stopped  chan struct{}
+    stopOnce sync.Once
+}
+
+// InvalidationStatus is published as expvar "invalidation"
+type InvalidationStatus struct {
+    Sent     uint64 `json:"sent"`
+    Received uint64 `json:"received"`
+    Dropped  int64  `json:"dropped"` // Changes not broadcast because the queue was full
+}
+
+// NewInvalidator broadcasts changes to the members of m and applies theirs
+func NewInvalidator(cache *lrucache.LRUCache, m *Membership) *Invalidator {
+    inv := &Invalidator{cache: cache, members: m, done: make(chan struct{}), stopped: make(chan struct{})}
+    inv.sub = events.Subscribe("", invalidateBuffer)
+    m.Handle(invalidateMessage, inv.receive)
+    return inv
+}
+
+// Status reports the counters
+func (inv *Invalidator) Status() InvalidationStatus {
+    return InvalidationStatus{Sent: inv.sent.Load(), Received: inv.received.Load(), Dropped: inv.sub.Dropped()}
+}
+
+// receive drops the keys another member changed
+func (inv *Invalidator) receive(msg []byte) {
+    var keys []string
+    if err := json.Unmarshal(msg, &keys); err != nil {
+        slog.Debug("invalidation: malformed message", "err", err)
+        return
+    }
+    for _, key := range keys {
+        inv.cache.Invalidate(key)
+        if filler != nil {
+            filler.Forget(key)
+        }
+    }
+    inv.received.Add(uint64(len(keys)))
+}
+
+// ListenAndServe broadcasts sets and deletes until shut down; evictions and
+// expirations are local decisions and invalidations came from elsewhere
+func (inv *Invalidator) ListenAndServe() error {
+    defer close(inv.stopped)
+    var batch []string
+    size := 2 // The brackets of the JSON array
+    flush := func() {
+        if len(batch) > 0 {
+            inv.broadcast(batch)
+            batch, size = batch[:0], 2
+        }
+    }
+    timer := time.NewTimer(invalidateDelay)
+    timer.Stop()
+    for {
+        select {
+        case e := <-inv.sub.C:
+            if e.Type != lrucache.EventSet && e.Type != lrucache.EventDelete {
+                continue
+            }
+            keySize := len(e.Key) + 3 // Quotes and comma; escaping may add more
+            if len(batch) > 0 && size+keySize > invalidatePacket {
+                flush()
+            }
+            if len(batch) == 0 {
+                timer.Reset(invalidateDelay)
+            }
+            batch = append(batch, e.Key)
+            size += keySize
+        case <-timer.C:
+            flush()
+        case <-inv.done:
+            flush()
+            return errServerClosed
+        }
+    }
+}
+
+// broadcast sends keys to every other member, over TCP when they are too
+// large for a packet
+func (inv *Invalidator) broadcast(keys []string) {
+    payload, _ := json.Marshal(keys)
+    msg := append([]byte{invalidateMessage}, payload...)
+    for _, node := range inv.members.Peers() {
+        var err error
+        if len(msg) > invalidatePacket {
+            err = inv.members.list.SendReliable(node, msg)
+        } else {
+            err = inv.members.list.SendBestEffort(node, msg)
+        }
+        if err != nil {
+            slog.Debug("invalidation: send failed", "member", node.Name, "err", err)
+        }
+    }
+    inv.sent.Add(uint64(len(keys)))
+}
+
+// Shutdown sends the pending batch
+func (inv *Invalidator) Shutdown(ctx context.Context) error {
+    inv.stopOnce.Do(func() { close(inv.done) })
+    select {
+    case <-inv.stopped:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Close stops broadcasting without waiting
+func (inv *Invalidator) Close() error {
+    inv.stopOnce.Do(func() { close(inv.done) })
+    return nil
+}
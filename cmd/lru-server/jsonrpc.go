@@ -0,0 +1,266 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// JSON-RPC 2.0 error codes; application errors carry the API error code in data
+const (
+    rpcParseError     = -32700
+    rpcInvalidRequest = -32600
+    rpcMethodNotFound = -32601
+    rpcInvalidParams  = -32602
+    rpcAppError       = -32000
+)
+
+// rpcMaxBatch bounds the number of calls in one batch request
+const rpcMaxBatch = 1000
+
+// RPCRequest is a single JSON-RPC 2.0 call; calls without an id are notifications
+type RPCRequest struct {
+    JSONRPC string          `json:"jsonrpc"`
+    Method  string          `json:"method"`
+    Params  json.RawMessage `json:"params,omitempty"`
+    ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// RPCResponse answers an RPCRequest with either a result or an error
+type RPCResponse struct {
+    JSONRPC string          `json:"jsonrpc"`
+    Result  interface{}     `json:"result,omitempty"`
+    Error   *RPCError       `json:"error,omitempty"`
+    ID      json.RawMessage `json:"id"`
+}
+
+// RPCError is a JSON-RPC error object
+type RPCError struct {
+    Code    int         `json:"code"`
+    Message string      `json:"message"`
+    Data    interface{} `json:"data,omitempty"`
+}
+
+// RPCParams holds the arguments of every method, given by name or by position
+type RPCParams struct {
+    Key   string `json:"key"`
+    Value string `json:"value"`
+    TTL   int    `json:"ttl"`
+}
+
+// RPCGetResult is the result of cache.get
+type RPCGetResult struct {
+    Found bool   `json:"found"`
+    Value string `json:"value,omitempty"`
+    TTL   int    `json:"ttl,omitempty"`
+}
+
+// rpcMethod describes a method: the role it needs, its positional parameter
+// order and the call itself
+type rpcMethod struct {
+    role   func(a *Authenticator) Role
+    params []string
+    call   func(cache lrucache.Cache, c Caller, p RPCParams) (interface{}, *RPCError)
+}
+
+func readRole(a *Authenticator) Role { return a.ReadRole() }
+func writeRole(*Authenticator) Role  { return RoleWrite }
+func adminRole(*Authenticator) Role  { return RoleAdmin }
+
+// rpcMethods lists the methods served at /rpc
+var rpcMethods = map[string]rpcMethod{
+    "cache.get": {readRole, []string{"key"}, func(cache lrucache.Cache, c Caller, p RPCParams) (interface{}, *RPCError) {
+        item, err := cache.GetItem(p.Key)
+        if err != nil {
+            return RPCGetResult{}, nil
+        }
+        res := RPCGetResult{Found: true, Value: item.Value}
+        if !item.Expiration.IsZero() {
+            res.TTL = int((time.Until(item.Expiration) + time.Second - 1) / time.Second)
+        }
+        return res, nil
+    }},
+    "cache.set": {writeRole, []string{"key", "value", "ttl"}, func(cache lrucache.Cache, c Caller, p RPCParams) (interface{}, *RPCError) {
+        if err := cache.Set(p.Key, p.Value, time.Duration(p.TTL)*time.Second); err != nil {
+            e := apiCacheError(err)
+            return nil, rpcAPIError(e.Code, e.Message)
+        }
+        audit(c, "set", p.Key)
+        return true, nil
+    }},
+    "cache.delete": {writeRole, []string{"key"}, func(cache lrucache.Cache, c Caller, p RPCParams) (interface{}, *RPCError) {
+        deleted := cache.Delete(p.Key)
+        if deleted {
+            audit(c, "delete", p.Key)
+        }
+        return deleted, nil
+    }},
+    "cache.touch": {writeRole, []string{"key", "ttl"}, func(cache lrucache.Cache, c Caller, p RPCParams) (interface{}, *RPCError) {
+        return cache.Touch(p.Key, time.Duration(p.TTL)*time.Second), nil
+    }},
+    "cache.exists": {readRole, []string{"key"}, func(cache lrucache.Cache, c Caller, p RPCParams) (interface{}, *RPCError) {
+        return cache.Contains(p.Key), nil
+    }},
+    "cache.stats": {readRole, nil, func(cache lrucache.Cache, _ Caller, _ RPCParams) (interface{}, *RPCError) {
+        stats := lrucache.StatsOf(cache)
+        return map[string]interface{}{
+            "size":                 cache.Len(),
+            "capacity":             stats.Capacity,
+            "memory_bytes":         stats.Bytes,
+            "process_memory_bytes": processMemory(),
+            "memory_limit_bytes":   memoryLimit(),
+        }, nil
+    }},
+    "cache.flush": {adminRole, nil, func(cache lrucache.Cache, c Caller, _ RPCParams) (interface{}, *RPCError) {
+        n := cache.Flush()
+        audit(c, "flush", "")
+        return n, nil
+    }},
+}
+
+// rpcAPIError wraps one of the API error codes in a JSON-RPC application error
+func rpcAPIError(code, msg string) *RPCError {
+    return &RPCError{Code: rpcAppError, Message: msg, Data: map[string]string{"code": code}}
+}
+
+// jsonRPCHandler serves single and batch JSON-RPC 2.0 calls
+func (h *handlers) jsonRPCHandler(auth *Authenticator) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        // Batches share the request body budget of a single maximum-size value
+        body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(h.maxValueBytes)+4096))
+        if err != nil {
+            var tooLarge *http.MaxBytesError
+            if errors.As(err, &tooLarge) {
+                writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "Request body too large")
+            } else {
+                writeError(w, http.StatusBadRequest, codeBadRequest, "Could not read request body")
+            }
+            return
+        }
+        p := principalFrom(r.Context())
+        c := httpCaller("jsonrpc", r)
+
+        body = bytes.TrimSpace(body)
+        if len(body) == 0 || body[0] != '[' {
+            var req RPCRequest
+            if err := json.Unmarshal(body, &req); err != nil {
+                writeJSON(w, rpcFailure(nil, rpcParseError, "Parse error"))
+                return
+            }
+            if resp, ok := h.callRPC(auth, p, c, req); ok {
+                writeJSON(w, resp)
+            } else {
+                w.WriteHeader(http.StatusNoContent)
+            }
+            return
+        }
+
+        var batch []json.RawMessage
+        if err := json.Unmarshal(body, &batch); err != nil {
+            writeJSON(w, rpcFailure(nil, rpcParseError, "Parse error"))
+            return
+        }
+        if len(batch) == 0 || len(batch) > rpcMaxBatch {
+            writeJSON(w, rpcFailure(nil, rpcInvalidRequest, "Batch must hold between 1 and 1000 calls"))
+            return
+        }
+        responses := make([]RPCResponse, 0, len(batch))
+        for _, raw := range batch {
+            var req RPCRequest
+            if err := json.Unmarshal(raw, &req); err != nil {
+                responses = append(responses, rpcFailure(nil, rpcInvalidRequest, "Invalid Request"))
+                continue
+            }
+            if resp, ok := h.callRPC(auth, p, c, req); ok {
+                responses = append(responses, resp)
+            }
+        }
+        if len(responses) == 0 {
+            w.WriteHeader(http.StatusNoContent) // Only notifications
+            return
+        }
+        writeJSON(w, responses)
+    }
+}
+
+// callRPC executes one call, returning false for notifications that get no response
+func (h *handlers) callRPC(auth *Authenticator, p *Principal, c Caller, req RPCRequest) (RPCResponse, bool) {
+    notification := len(req.ID) == 0
+    if req.JSONRPC != "2.0" || req.Method == "" {
+        return rpcFailure(req.ID, rpcInvalidRequest, "Invalid Request"), true
+    }
+    m, ok := rpcMethods[req.Method]
+    if !ok {
+        return rpcFailure(req.ID, rpcMethodNotFound, "Method not found"), !notification
+    }
+    params, err := decodeRPCParams(req.Params, m.params)
+    if err != nil {
+        return rpcFailure(req.ID, rpcInvalidParams, "Invalid params"), !notification
+    }
+
+    switch auth.Authorize(p, m.role(auth)) {
+    case nil:
+    case errNoCredentials:
+        return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcAPIError(codeUnauthorized, "Missing or invalid credentials")}, !notification
+    case errReadOnly:
+        return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcAPIError(codeReadOnly, readOnlyMessage)}, !notification
+    case errNoLeader:
+        return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcAPIError(codeUnavailable, noLeaderMessage)}, !notification
+    default:
+        return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcAPIError(codeForbidden, "Insufficient role for this operation")}, !notification
+    }
+
+    if len(params.Value) > h.maxValueBytes {
+        return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcAPIError(codePayloadTooLarge, "Value exceeds the maximum size")}, !notification
+    }
+    result, rpcErr := m.call(h.cache, c, params)
+    if notification {
+        return RPCResponse{}, false
+    }
+    if rpcErr != nil {
+        return RPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr}, true
+    }
+    return RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result}, true
+}
+
+// decodeRPCParams accepts params as an object or as an array in the method's positional order
+func decodeRPCParams(raw json.RawMessage, names []string) (RPCParams, error) {
+    var p RPCParams
+    raw = bytes.TrimSpace(raw)
+    if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+        return p, nil
+    }
+    if raw[0] == '[' {
+        var list []json.RawMessage
+        if err := json.Unmarshal(raw, &list); err != nil {
+            return p, err
+        }
+        if len(list) > len(names) {
+            return p, errors.New("too many positional params")
+        }
+        named := make(map[string]json.RawMessage, len(list))
+        for i, v := range list {
+            named[names[i]] = v
+        }
+        var err error
+        if raw, err = json.Marshal(named); err != nil {
+            return p, err
+        }
+    }
+    dec := json.NewDecoder(bytes.NewReader(raw))
+    dec.DisallowUnknownFields()
+    err := dec.Decode(&p)
+    return p, err
+}
+
+func rpcFailure(id json.RawMessage, code int, msg string) RPCResponse {
+    if len(id) == 0 {
+        id = json.RawMessage("null")
+    }
+    return RPCResponse{JSONRPC: "2.0", ID: id, Error: &RPCError{Code: code, Message: msg}}
+}
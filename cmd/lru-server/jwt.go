@@ -0,0 +1,148 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "strings"
+    "time"
+
+    "github.com/MicahParks/keyfunc/v3"
+    "github.com/golang-jwt/jwt/v5"
+    "golang.org/x/time/rate"
+)
+
+var (
+    errTokenMalformed = errors.New("malformed token")
+    errTokenSignature = errors.New("invalid token signature")
+    errTokenExpired   = errors.New("token expired")
+    errTokenClaims    = errors.New("token claims rejected")
+)
+
+// JWTConfig describes how bearer tokens are validated and mapped to roles
+type JWTConfig struct {
+    Secret    string
+    JWKSURL   string
+    Issuer    string
+    Audience  string
+    RoleClaim string
+    RoleMap   map[string]Role
+    Leeway    time.Duration
+}
+
+// JWTVerifier validates JWTs signed with a shared secret or a key from a JWKS
+type JWTVerifier struct {
+    cfg    JWTConfig
+    secret []byte
+    jwks   keyfunc.Keyfunc
+    parser *jwt.Parser
+}
+
+// NewJWTVerifier creates a verifier; it returns nil when no signing key is
+// configured. Only the algorithms of the keys configured are accepted, so
+// that neither "none" nor an HMAC token keyed with a public key from the
+// JWKS passes.
+func NewJWTVerifier(cfg JWTConfig) (*JWTVerifier, error) {
+    if cfg.Secret == "" && cfg.JWKSURL == "" {
+        return nil, nil
+    }
+    if cfg.RoleClaim == "" {
+        cfg.RoleClaim = "roles"
+    }
+    v := &JWTVerifier{cfg: cfg}
+    var methods []string
+    if cfg.Secret != "" {
+        v.secret = []byte(cfg.Secret)
+        methods = append(methods, "HS256", "HS384", "HS512")
+    }
+    if cfg.JWKSURL != "" {
+        // The set is refreshed hourly, and at most every 30s for a token
+        // naming a key it does not hold, as when the issuer rotates keys;
+        // such a token arriving sooner fails rather than wait for the next
+        jwks, err := keyfunc.NewDefaultOverrideCtx(context.Background(), []string{cfg.JWKSURL}, keyfunc.Override{
+            HTTPTimeout:       10 * time.Second,
+            RateLimitWaitMax:  time.Second,
+            RefreshInterval:   time.Hour,
+            RefreshUnknownKID: rate.NewLimiter(rate.Every(30*time.Second), 1),
+            RefreshErrorHandlerFunc: func(url string) func(context.Context, error) {
+                return func(_ context.Context, err error) {
+                    slog.Warn("jwks refresh failed", "url", url, "err", err)
+                }
+            },
+        })
+        if err != nil {
+            return nil, err
+        }
+        v.jwks = jwks
+        methods = append(methods, "RS256", "RS384", "RS512", "ES256", "ES384", "ES512")
+    }
+    // A token without exp would grant access for good once issued
+    opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithLeeway(cfg.Leeway), jwt.WithExpirationRequired()}
+    if cfg.Issuer != "" {
+        opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+    }
+    if cfg.Audience != "" {
+        opts = append(opts, jwt.WithAudience(cfg.Audience))
+    }
+    v.parser = jwt.NewParser(opts...)
+    return v, nil
+}
+
+// Verify checks the token signature and standard claims and returns its principal
+func (v *JWTVerifier) Verify(token string) (*Principal, error) {
+    claims := jwt.MapClaims{}
+    if _, err := v.parser.ParseWithClaims(token, claims, v.key); err != nil {
+        switch {
+        case errors.Is(err, jwt.ErrTokenMalformed):
+            return nil, errTokenMalformed
+        case errors.Is(err, jwt.ErrTokenExpired):
+            return nil, errTokenExpired
+        case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+            return nil, fmt.Errorf("%w: %v", errTokenSignature, err)
+        }
+        return nil, fmt.Errorf("%w: %v", errTokenClaims, err)
+    }
+    subject, _ := claims["sub"].(string)
+    return &Principal{Subject: subject, Roles: v.roles(claims), Method: "jwt"}, nil
+}
+
+// key returns the key checking the signature of token: the secret for HMAC,
+// whose algorithms the parser only accepts with a secret configured, and
+// otherwise the JWKS key its kid names
+func (v *JWTVerifier) key(token *jwt.Token) (interface{}, error) {
+    if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+        return v.secret, nil
+    }
+    return v.jwks.Keyfunc(token)
+}
+
+// roles maps the configured role claim onto cache roles
+func (v *JWTVerifier) roles(claims jwt.MapClaims) Role {
+    var roles Role
+    for _, name := range claimStrings(claims[v.cfg.RoleClaim]) {
+        if role, ok := v.cfg.RoleMap[name]; ok {
+            roles |= role
+        } else if role, ok := parseRole(name); ok {
+            roles |= role
+        }
+    }
+    return roles
+}
+
+// claimStrings accepts a string array, a single string or a space-separated scope string
+func claimStrings(v interface{}) []string {
+    switch v := v.(type) {
+    case string:
+        return strings.Fields(v)
+    case []interface{}:
+        var out []string
+        for _, item := range v {
+            if s, ok := item.(string); ok {
+                out = append(out, s)
+            }
+        }
+        return out
+    }
+    return nil
+}
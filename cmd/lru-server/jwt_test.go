@@ -0,0 +1,224 @@
+package main
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "math/big"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "test-secret"
+
+// signHS signs claims with the test secret
+func signHS(t *testing.T, claims jwt.MapClaims) string {
+    t.Helper()
+    token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return token
+}
+
+// unsigned is a token with alg none, which no verifier may accept
+func unsigned(claims jwt.MapClaims) string {
+    header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+    body, _ := json.Marshal(claims)
+    return header + "." + base64.RawURLEncoding.EncodeToString(body) + "."
+}
+
+func TestJWTVerifierSecret(t *testing.T) {
+    v, err := NewJWTVerifier(JWTConfig{Secret: testSecret, Issuer: "issuer", Audience: "cache", Leeway: 10 * time.Second})
+    if err != nil {
+        t.Fatal(err)
+    }
+    now := time.Now()
+    valid := func(extra jwt.MapClaims) jwt.MapClaims {
+        claims := jwt.MapClaims{"sub": "alice", "iss": "issuer", "aud": "cache", "exp": now.Add(time.Hour).Unix(), "roles": []string{"read", "write"}}
+        for k, v := range extra {
+            claims[k] = v
+        }
+        return claims
+    }
+    otherKey, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, valid(nil)).SignedString([]byte("other-secret"))
+    tampered := signHS(t, valid(nil))
+    tampered = tampered[:len(tampered)-2] + "AA"
+
+    tests := []struct {
+        name  string
+        token string
+        want  error // Nil for a token that verifies
+    }{
+        {"valid", signHS(t, valid(nil)), nil},
+        {"within leeway", signHS(t, valid(jwt.MapClaims{"exp": now.Add(-5 * time.Second).Unix()})), nil},
+        {"expired", signHS(t, valid(jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()})), errTokenExpired},
+        {"no expiration", signHS(t, jwt.MapClaims{"sub": "alice", "iss": "issuer", "aud": "cache", "roles": "read"}), errTokenClaims},
+        {"not yet valid", signHS(t, valid(jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()})), errTokenClaims},
+        {"wrong issuer", signHS(t, valid(jwt.MapClaims{"iss": "someone"})), errTokenClaims},
+        {"wrong audience", signHS(t, valid(jwt.MapClaims{"aud": []string{"other"}})), errTokenClaims},
+        {"alg none", unsigned(valid(nil)), errTokenSignature},
+        {"other secret", otherKey, errTokenSignature},
+        {"tampered signature", tampered, errTokenSignature},
+        {"malformed", "not.a.token", errTokenMalformed},
+        {"two segments", "abc.def", errTokenMalformed},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            p, err := v.Verify(tt.token)
+            switch {
+            case tt.want == nil && err != nil:
+                t.Fatalf("Verify: %v", err)
+            case tt.want != nil && !errors.Is(err, tt.want):
+                t.Fatalf("Verify error = %v, want %v", err, tt.want)
+            case tt.want == nil && (p.Subject != "alice" || p.Roles != RoleRead|RoleWrite):
+                t.Fatalf("principal = %+v", p)
+            }
+        })
+    }
+}
+
+// jwksServer publishes a JWKS document that a test can replace, as an
+// issuer rotating its keys does
+type jwksServer struct {
+    *httptest.Server
+    mutex sync.Mutex
+    doc   []byte
+}
+
+func newJWKSServer(t *testing.T, keys ...map[string]string) *jwksServer {
+    s := &jwksServer{}
+    s.publish(keys...)
+    s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        s.mutex.Lock()
+        defer s.mutex.Unlock()
+        w.Header().Set("Content-Type", "application/json")
+        w.Write(s.doc)
+    }))
+    t.Cleanup(s.Close)
+    return s
+}
+
+func (s *jwksServer) publish(keys ...map[string]string) {
+    doc, _ := json.Marshal(map[string]interface{}{"keys": keys})
+    s.mutex.Lock()
+    s.doc = doc
+    s.mutex.Unlock()
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+    return map[string]string{
+        "kty": "RSA", "kid": kid, "use": "sig", "alg": "RS256",
+        "n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+        "e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+    }
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+    return map[string]string{
+        "kty": "EC", "kid": kid, "use": "sig", "alg": "ES256", "crv": "P-256",
+        "x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+        "y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+    }
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+    t.Helper()
+    token := jwt.NewWithClaims(method, claims)
+    token.Header["kid"] = kid
+    signed, err := token.SignedString(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return signed
+}
+
+func TestJWTVerifierJWKS(t *testing.T) {
+    rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+    ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    strangerKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+    server := newJWKSServer(t, rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", &ecKey.PublicKey))
+    v, err := NewJWTVerifier(JWTConfig{JWKSURL: server.URL})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    claims := jwt.MapClaims{"sub": "svc", "exp": time.Now().Add(time.Hour).Unix(), "roles": "admin"}
+    // Alg confusion: an HMAC token keyed with the published RSA key
+    der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+    publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+    tests := []struct {
+        name  string
+        token string
+        want  error
+    }{
+        {"rs256", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims), nil},
+        {"es256", sign(t, jwt.SigningMethodES256, "ec-1", ecKey, claims), nil},
+        {"hs256 keyed with the public key", sign(t, jwt.SigningMethodHS256, "rsa-1", publicPEM, claims), errTokenSignature},
+        {"hs256 keyed with the modulus", sign(t, jwt.SigningMethodHS256, "rsa-1", rsaKey.PublicKey.N.Bytes(), claims), errTokenSignature},
+        {"alg none", unsigned(claims), errTokenSignature},
+        {"unpublished key", sign(t, jwt.SigningMethodRS256, "rsa-1", strangerKey, claims), errTokenSignature},
+        {"kid of another key type", sign(t, jwt.SigningMethodRS256, "ec-1", rsaKey, claims), errTokenSignature},
+        {"unknown kid", sign(t, jwt.SigningMethodRS256, "rsa-9", strangerKey, claims), errTokenSignature},
+        {"expired", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}), errTokenExpired},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            p, err := v.Verify(tt.token)
+            switch {
+            case tt.want == nil && err != nil:
+                t.Fatalf("Verify: %v", err)
+            case tt.want != nil && !errors.Is(err, tt.want):
+                t.Fatalf("Verify error = %v, want %v", err, tt.want)
+            case tt.want == nil && (p.Subject != "svc" || p.Roles != RoleAdmin):
+                t.Fatalf("principal = %+v", p)
+            }
+        })
+    }
+}
+
+func TestJWTVerifierKeyRotation(t *testing.T) {
+    oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+    newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+    server := newJWKSServer(t, rsaJWK("2025", &oldKey.PublicKey))
+    v, err := NewJWTVerifier(JWTConfig{JWKSURL: server.URL})
+    if err != nil {
+        t.Fatal(err)
+    }
+    claims := jwt.MapClaims{"sub": "svc", "exp": time.Now().Add(time.Hour).Unix()}
+    if _, err := v.Verify(sign(t, jwt.SigningMethodRS256, "2025", oldKey, claims)); err != nil {
+        t.Fatalf("token of the published key: %v", err)
+    }
+
+    // The issuer rotates: the first token naming the new kid refetches the set
+    server.publish(rsaJWK("2026", &newKey.PublicKey))
+    if _, err := v.Verify(sign(t, jwt.SigningMethodRS256, "2026", newKey, claims)); err != nil {
+        t.Fatalf("token of the rotated key: %v", err)
+    }
+    if _, err := v.Verify(sign(t, jwt.SigningMethodRS256, "2025", oldKey, claims)); !errors.Is(err, errTokenSignature) {
+        t.Fatalf("token of the retired key: error = %v, want %v", err, errTokenSignature)
+    }
+}
+
+func TestNewJWTVerifierDisabled(t *testing.T) {
+    v, err := NewJWTVerifier(JWTConfig{})
+    if v != nil || err != nil {
+        t.Fatalf("NewJWTVerifier with no key = %v, %v; want nil, nil", v, err)
+    }
+    if _, err := NewJWTVerifier(JWTConfig{JWKSURL: "://bad"}); err == nil || !strings.Contains(err.Error(), "bad") {
+        t.Fatalf("NewJWTVerifier with a malformed URL: error = %v", err)
+    }
+}
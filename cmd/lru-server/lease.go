@@ -0,0 +1,49 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "lru-cache/lrucache"
+)
+
+// maxLease bounds the lease parameter of GET /cache
+const maxLease = time.Minute
+
+var leaseParam = param{Name: "lease", In: "query", Description: "On a miss, hand out a lease for this long, such as 10s, so that only this caller computes and sets the value"}
+
+// writeLeasedValue answers GET /cache?key=...&lease=...: the value on a hit,
+// or on a miss 404 with a lease token in X-Lease-Token, or 409 while
+// another caller holds the lease
+func (h *handlers) writeLeasedValue(w http.ResponseWriter, r *http.Request, key string) {
+    refresher, ok := supported[lrucache.Refresher](w, h.cache)
+    if !ok {
+        return
+    }
+    ttl, err := time.ParseDuration(r.URL.Query().Get("lease"))
+    if err != nil || ttl <= 0 || ttl > maxLease {
+        writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("lease must be a duration up to %s, such as 10s", maxLease))
+        return
+    }
+    span := startSpan(r.Context(), "cache.get_lease")
+    value, token, err := refresher.GetLease(key, ttl)
+    span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+    span.End()
+    switch {
+    case err == nil:
+        writeItem(w, r, lrucache.Item{Value: value})
+    case token != 0:
+        w.Header().Set("X-Lease-Token", strconv.FormatUint(token, 10))
+        writeError(w, http.StatusNotFound, codeKeyNotFound, "Key not found; set it with the lease_token from X-Lease-Token")
+    case errors.Is(err, lrucache.ErrLeaseHeld):
+        w.Header().Set("Retry-After", "1")
+        writeError(w, http.StatusConflict, codeLeaseHeld, "Another caller is filling this key; read it again shortly")
+    default:
+        writeLookupError(w, err)
+    }
+}
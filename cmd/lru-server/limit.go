@@ -0,0 +1,62 @@
+package main
+
+import (
+    "net"
+    "net/http"
+    "sync"
+)
+
+// limitListener caps the number of simultaneously open connections; Accept
+// blocks until a slot frees up, leaving excess connections in the kernel backlog
+type limitListener struct {
+    net.Listener
+    sem chan struct{}
+}
+
+func newLimitListener(l net.Listener, n int) net.Listener {
+    if n <= 0 {
+        return l
+    }
+    return &limitListener{Listener: l, sem: make(chan struct{}, n)}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+    l.sem <- struct{}{}
+    conn, err := l.Listener.Accept()
+    if err != nil {
+        <-l.sem
+        return nil, err
+    }
+    return &limitConn{Conn: conn, release: func() { <-l.sem }}, nil
+}
+
+// limitConn gives back its listener slot when closed
+type limitConn struct {
+    net.Conn
+    once    sync.Once
+    release func()
+}
+
+func (c *limitConn) Close() error {
+    err := c.Conn.Close()
+    c.once.Do(c.release)
+    return err
+}
+
+// inflightMiddleware rejects requests with 503 once max requests are being served
+func inflightMiddleware(max int, next http.Handler) http.Handler {
+    if max <= 0 {
+        return next
+    }
+    sem := make(chan struct{}, max)
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case sem <- struct{}{}:
+            defer func() { <-sem }()
+            next.ServeHTTP(w, r)
+        default:
+            w.Header().Set("Retry-After", "1")
+            writeError(w, http.StatusServiceUnavailable, codeOverloaded, "Server is at its in-flight request limit")
+        }
+    })
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "lru-cache/lrucache"
+)
+
+// listRoutes lists the endpoints pushing, popping and reading list elements
+func listRoutes(h *handlers) []route {
+    return []route{
+        {
+            Method:  http.MethodGet,
+            Path:    "/v1/cache/{key}/items",
+            Summary: "Get a range of a list's elements",
+            Handler: h.getListHandler,
+            Params: []param{
+                keyParam,
+                {Name: "start", In: "query", Description: "Index of the first element; negative counts back from the tail (default 0)"},
+                {Name: "stop", In: "query", Description: "Index of the last element, inclusive; negative counts back from the tail (default -1)"},
+                consistencyParam,
+            },
+            Response: []response{
+                {Status: http.StatusOK, Description: "The elements", ContentType: "application/json", Body: ListResponse{}},
+                errorResponse(http.StatusBadRequest, "Invalid start or stop (BAD_REQUEST)"),
+                errorResponse(http.StatusNotFound, "Key not found (KEY_NOT_FOUND) or expired (EXPIRED)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/v1/cache/{key}/items/{end}",
+            Summary: "Push elements onto the head or tail of a list, creating the list if needed; the list keeps its expiration",
+            Handler: h.pushHandler,
+            Params:  []param{keyParam, endParam},
+            Body:    PushRequest{},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The list's new length", ContentType: "application/json", Body: PushResponse{}},
+                errorResponse(http.StatusBadRequest, "Malformed request body or end (BAD_REQUEST)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+                errorResponse(http.StatusRequestEntityTooLarge, "List too large (PAYLOAD_TOO_LARGE)"),
+            },
+        },
+        {
+            Method:  http.MethodDelete,
+            Path:    "/v1/cache/{key}/items/head",
+            Summary: "Remove and return the head of a list; popping the last element deletes the key",
+            Handler: h.popHandler,
+            Params:  []param{keyParam},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The removed element", ContentType: "text/plain"},
+                errorResponse(http.StatusNotFound, "Key not found (KEY_NOT_FOUND)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+    }
+}
+
+var endParam = param{Name: "end", In: "path", Description: "head or tail", Required: true}
+
+// PushRequest is the body of POST /v1/cache/{key}/items/{end}
+type PushRequest struct {
+    Values []string `json:"values"`
+}
+
+// ListResponse is the body of GET /v1/cache/{key}/items
+type ListResponse struct {
+    Values []string `json:"values"`
+}
+
+// PushResponse is the body of POST /v1/cache/{key}/items/{end}
+type PushResponse struct {
+    Length int `json:"length"`
+}
+
+// getListHandler handles GET /v1/cache/{key}/items
+func (h *handlers) getListHandler(w http.ResponseWriter, r *http.Request) {
+    lists, ok := supported[lrucache.Lists](w, h.cache)
+    if !ok {
+        return
+    }
+    start, ok := queryInt(w, r, "start", 0)
+    if !ok {
+        return
+    }
+    stop, ok := queryInt(w, r, "stop", -1)
+    if !ok {
+        return
+    }
+
+    span := startSpan(r.Context(), "cache.lrange")
+    values, err := lists.LRange(r.PathValue("key"), start, stop)
+    span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+    span.End()
+    if err != nil {
+        writeLookupError(w, err)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(ListResponse{Values: values})
+}
+
+// queryInt parses the integer query parameter name, which defaults to def,
+// writing an error response if it is malformed
+func queryInt(w http.ResponseWriter, r *http.Request, name string, def int) (int, bool) {
+    s := r.URL.Query().Get(name)
+    if s == "" {
+        return def, true
+    }
+    n, err := strconv.Atoi(s)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, codeBadRequest, name+" must be an integer")
+        return 0, false
+    }
+    return n, true
+}
+
+// pushHandler handles POST /v1/cache/{key}/items/{end}
+func (h *handlers) pushHandler(w http.ResponseWriter, r *http.Request) {
+    lists, ok := supported[lrucache.Lists](w, h.cache)
+    if !ok {
+        return
+    }
+    var push func(key string, values ...string) (int, error)
+    switch r.PathValue("end") {
+    case "head":
+        push = lists.LPush
+    case "tail":
+        push = lists.RPush
+    default:
+        writeError(w, http.StatusBadRequest, codeBadRequest, "end must be head or tail")
+        return
+    }
+    var req PushRequest
+    if !h.decodeJSON(w, r, &req) {
+        return
+    }
+    if len(req.Values) == 0 {
+        writeError(w, http.StatusBadRequest, codeBadRequest, "values must not be empty")
+        return
+    }
+
+    span := startSpan(r.Context(), "cache.push")
+    span.SetAttributes(attribute.Int("cache.values", len(req.Values)))
+    n, err := push(r.PathValue("key"), req.Values...)
+    span.End()
+    if err != nil {
+        writeCacheError(w, err)
+        return
+    }
+    audit(httpCaller("http", r), "push", r.PathValue("key"))
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(PushResponse{Length: n})
+}
+
+// popHandler handles DELETE /v1/cache/{key}/items/head
+func (h *handlers) popHandler(w http.ResponseWriter, r *http.Request) {
+    lists, ok := supported[lrucache.Lists](w, h.cache)
+    if !ok {
+        return
+    }
+    span := startSpan(r.Context(), "cache.lpop")
+    value, err := lists.LPop(r.PathValue("key"))
+    span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+    span.End()
+    if err != nil {
+        writeLookupError(w, err)
+        return
+    }
+    audit(httpCaller("http", r), "lpop", r.PathValue("key"))
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte(value))
+}
+
+// list implements LPUSH and RPUSH key element [element ...], LPOP key and
+// LRANGE key start stop
+func (s *respSession) list(cmd string, args []string) {
+    switch {
+    case (cmd == "LPUSH" || cmd == "RPUSH") && len(args) < 2,
+        cmd == "LPOP" && len(args) != 1,
+        cmd == "LRANGE" && len(args) != 3:
+        s.writeWrongArgs(cmd)
+        return
+    }
+    key := args[0]
+    lists, ok := respSupported[lrucache.Lists](s)
+    if !ok {
+        return
+    }
+    switch cmd {
+    case "LPUSH", "RPUSH":
+        if !s.allowed(RoleWrite) {
+            return
+        }
+        push := lists.RPush
+        if cmd == "LPUSH" {
+            push = lists.LPush
+        }
+        n, err := push(key, args[1:]...)
+        if err != nil {
+            s.writeCacheError(err)
+            return
+        }
+        audit(s.caller(), "push", key)
+        s.writeInt(int64(n))
+    case "LPOP":
+        if !s.allowed(RoleWrite) {
+            return
+        }
+        value, err := lists.LPop(key)
+        switch {
+        case err == nil:
+            audit(s.caller(), "lpop", key)
+            s.writeBulk(value)
+        case errors.Is(err, lrucache.ErrNotFound):
+            s.writeNull()
+        default:
+            s.writeCacheError(err)
+        }
+    case "LRANGE":
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        start, err1 := strconv.Atoi(args[1])
+        stop, err2 := strconv.Atoi(args[2])
+        if err1 != nil || err2 != nil {
+            s.writeError("ERR value is not an integer or out of range")
+            return
+        }
+        values, err := lists.LRange(key, start, stop)
+        if errors.Is(err, lrucache.ErrWrongType) {
+            s.writeCacheError(err)
+            return
+        }
+        s.writeArray(values)
+    }
+}
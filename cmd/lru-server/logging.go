@@ -0,0 +1,69 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "strings"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// logLevel is the minimum level logged; it can be changed while running
+var logLevel = new(slog.LevelVar)
+
+// setupLogging installs the default slog logger, which the log package and
+// net/http error logs also write through
+func setupLogging(w io.Writer, format string, level slog.Level) {
+    logLevel.Set(level)
+    opts := &slog.HandlerOptions{Level: logLevel}
+    var h slog.Handler
+    if format == "json" {
+        h = slog.NewJSONHandler(w, opts)
+    } else {
+        h = slog.NewTextHandler(w, opts)
+    }
+    slog.SetDefault(slog.New(h))
+}
+
+// parseLogLevel accepts debug, info, warn or error, optionally with an offset such as debug-4
+func parseLogLevel(s string) (slog.Level, error) {
+    var level slog.Level
+    if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+        return 0, fmt.Errorf("invalid log level %q: want debug, info, warn or error", s)
+    }
+    return level, nil
+}
+
+// logRemovals logs evictions and expirations at debug level until sub is closed
+func logRemovals(sub *lrucache.Subscription) {
+    for e := range sub.C {
+        if e.Type != lrucache.EventEvict && e.Type != lrucache.EventExpire {
+            continue
+        }
+        if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+            slog.Debug("cache entry removed", "reason", e.Type.String(), "key", e.Key)
+        }
+    }
+}
+
+// slowRequestMiddleware logs requests that take at least threshold to serve
+func slowRequestMiddleware(threshold time.Duration, next http.Handler) http.Handler {
+    if threshold <= 0 {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        rec := &statusRecorder{ResponseWriter: w}
+        next.ServeHTTP(rec, r)
+        if d := time.Since(start); d >= threshold {
+            if rec.status == 0 {
+                rec.status = http.StatusOK
+            }
+            slog.Warn("slow http request", "method", r.Method, "path", r.URL.Path, "key", r.URL.Query().Get("key"), "status", rec.status, "duration", d, "client_ip", clientIP(r))
+        }
+    })
+}
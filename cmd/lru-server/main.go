@@ -0,0 +1,462 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "net/http"
+    "os"
+    "os/signal"
+    "slices"
+    "strings"
+    "syscall"
+    "time"
+    "unicode/utf8"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "lru-cache/lrucache"
+)
+
+// CacheRequest represents the expected structure of a cache set request;
+// an expiration of zero never expires
+type CacheRequest struct {
+    Key        string `json:"key"`
+    Value      string `json:"value"`
+    Expiration int    `json:"expiration"`
+    SoftTTL    int    `json:"soft_ttl,omitempty"`    // Seconds after which reads flag the value stale, until the expiration makes it a miss
+    LeaseToken uint64 `json:"lease_token,omitempty"` // From a GET with ?lease=; the value is then stored only while the lease holds
+}
+
+// MultiGetResponse is returned when several keys are requested at once
+type MultiGetResponse struct {
+    Values  map[string]string `json:"values"`
+    Missing []string          `json:"missing"`
+}
+
+// handlers serves the HTTP API from one cache
+type handlers struct {
+    cache         lrucache.Cache
+    namespaces    *Namespaces
+    reloader      *Reloader
+    ha            *haNode     // nil unless -ha-lock is set
+    mqtt          *mqttBridge // nil unless -mqtt-url is set
+    maxValueBytes int     // Largest value accepted by the HTTP API
+    cors          *CORSPolicy // Cross-origin pages that may open WebSockets; nil allows none
+}
+
+// getCacheHandler handles GET requests for retrieving cache data
+func (h *handlers) getCacheHandler(w http.ResponseWriter, r *http.Request) {
+    keys := requestedKeys(r)
+    if len(keys) == 1 && !r.URL.Query().Has("keys") {
+        if r.URL.Query().Has("lease") {
+            h.writeLeasedValue(w, r, keys[0])
+            return
+        }
+        h.writeValue(w, r, keys[0])
+        return
+    }
+
+    span := startSpan(r.Context(), "cache.get_multi")
+    span.SetAttributes(attribute.Int("cache.keys", len(keys)))
+    resp := MultiGetResponse{Values: make(map[string]string), Missing: []string{}}
+    for _, key := range keys {
+        value, err := lrucache.GetCtx(r.Context(), h.cache, key)
+        switch {
+        case err == nil:
+            resp.Values[key] = value
+        case errors.Is(err, lrucache.ErrNotFound), errors.Is(err, lrucache.ErrExpired), errors.Is(err, lrucache.ErrWrongType), errors.Is(err, lrucache.ErrCorrupt):
+            resp.Missing = append(resp.Missing, key)
+        default:
+            span.End()
+            writeCacheError(w, err)
+            return
+        }
+    }
+    span.SetAttributes(attribute.Int("cache.misses", len(resp.Missing)))
+    span.End()
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(resp)
+}
+
+// requestedKeys collects keys from repeated key parameters and comma-separated keys parameters
+func requestedKeys(r *http.Request) []string {
+    query := r.URL.Query()
+    seen := make(map[string]bool)
+    var keys []string
+    add := func(key string) {
+        if !seen[key] {
+            seen[key] = true
+            keys = append(keys, key)
+        }
+    }
+    for _, key := range query["key"] {
+        add(key)
+    }
+    for _, list := range query["keys"] {
+        for _, key := range strings.Split(list, ",") {
+            if key != "" {
+                add(key)
+            }
+        }
+    }
+    if len(keys) == 0 {
+        keys = []string{""}
+    }
+    return keys
+}
+
+// writeValue writes the value of key, or a KEY_NOT_FOUND or EXPIRED error
+func (h *handlers) writeValue(w http.ResponseWriter, r *http.Request, key string) {
+    span := startSpan(r.Context(), "cache.get")
+    item, err := lrucache.GetItemCtx(r.Context(), h.cache, key)
+    span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+    span.End()
+    switch {
+    case err == nil:
+        writeItem(w, r, item)
+    default:
+        writeLookupError(w, err)
+    }
+}
+
+// writeItem answers a read that found item
+func writeItem(w http.ResponseWriter, r *http.Request, item lrucache.Item) {
+    if item.Stale {
+        // Past the soft TTL: still served, but the writer should refresh it
+        w.Header().Set("X-Stale", "true")
+    }
+    if !item.Modified.IsZero() {
+        // Lets a proxy reading several replicas pick the latest write
+        w.Header().Set("X-Modified", item.Modified.UTC().Format(time.RFC3339Nano))
+    }
+    if !utf8.ValidString(item.Value) || strings.Contains(r.Header.Get("Accept"), "application/octet-stream") {
+        w.Header().Set("Content-Type", "application/octet-stream")
+    }
+    // Answers Range and HEAD requests, and sets Last-Modified and
+    // Content-Type unless set above
+    http.ServeContent(w, r, "", item.Modified, strings.NewReader(item.Value))
+}
+
+// setCacheHandler handles POST requests for setting cache data
+func (h *handlers) setCacheHandler(w http.ResponseWriter, r *http.Request) {
+    var req CacheRequest
+    if !h.decodeJSON(w, r, &req) || !h.checkValueSize(w, req.Value) || !checkTenantKeys(w, r, req.Key) {
+        return
+    }
+
+    if req.SoftTTL < 0 || (req.SoftTTL > 0 && req.LeaseToken != 0) {
+        writeError(w, http.StatusBadRequest, codeBadRequest, "soft_ttl must be positive and cannot be combined with lease_token")
+        return
+    }
+    // Leases and soft TTLs need a cache that refreshes
+    var refresher lrucache.Refresher
+    if req.LeaseToken != 0 || req.SoftTTL > 0 {
+        var ok bool
+        if refresher, ok = supported[lrucache.Refresher](w, h.cache); !ok {
+            return
+        }
+    }
+
+    expiration := time.Duration(req.Expiration) * time.Second
+    span := startSpan(r.Context(), "cache.set")
+    span.SetAttributes(attribute.Int("cache.value_bytes", len(req.Value)))
+    var err error
+    switch {
+    case req.LeaseToken != 0:
+        err = refresher.SetLease(req.Key, req.Value, expiration, req.LeaseToken)
+    case req.SoftTTL > 0:
+        err = refresher.SetSoft(req.Key, req.Value, time.Duration(req.SoftTTL)*time.Second, expiration)
+    default:
+        err = lrucache.SetCtx(r.Context(), h.cache, req.Key, req.Value, expiration)
+    }
+    span.End()
+    if err != nil {
+        writeCacheError(w, err)
+        return
+    }
+    audit(httpCaller("http", r), "set", req.Key)
+    w.WriteHeader(http.StatusOK)
+}
+
+// decodeJSON reads a JSON request body into v, writing an error response on failure
+func (h *handlers) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+    // Leave room for the JSON framing around a maximum-size value
+    r.Body = http.MaxBytesReader(w, r.Body, int64(h.maxValueBytes)+4096)
+    if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+        var tooLarge *http.MaxBytesError
+        if errors.As(err, &tooLarge) {
+            writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "Request body too large")
+        } else {
+            writeError(w, http.StatusBadRequest, codeBadRequest, "Malformed JSON body")
+        }
+        return false
+    }
+    return true
+}
+
+// checkValueSize rejects values larger than -max-value-bytes with PAYLOAD_TOO_LARGE
+func (h *handlers) checkValueSize(w http.ResponseWriter, value string) bool {
+    if len(value) > h.maxValueBytes {
+        writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "Value exceeds the maximum size")
+        return false
+    }
+    return true
+}
+
+func main() {
+    if runAsCLI(os.Args[0]) {
+        os.Exit(runCommand("client", os.Args[1:]))
+    }
+    if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+        os.Exit(runCommand(os.Args[1], os.Args[2:]))
+    }
+    serve(os.Args[1:])
+}
+
+// serve runs the server configured by the flags in args until SIGINT or SIGTERM
+func serve(args []string) {
+    cfg := parseFlags(args)
+    setupLogging(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+    socketMode = cfg.SocketMode
+    shared := []lrucache.Option{
+        lrucache.WithMaxValueBytes(cfg.MaxValueBytes),
+        lrucache.WithCompression(cfg.ValueCompression, cfg.ValueCompressMin),
+        lrucache.WithEncryption(newAEAD(cfg.EncryptionKey)),
+        lrucache.WithSlowThreshold(cfg.SlowThreshold),
+        lrucache.WithDoorkeeper(cfg.DoorkeeperWindow),
+    }
+    opts := append(slices.Clone(shared),
+        lrucache.WithCapacity(cfg.Capacity),
+        lrucache.WithJanitorInterval(cfg.JanitorInterval),
+        lrucache.WithDefaultTTL(cfg.DefaultTTL),
+        lrucache.WithOnEvent(events.Publish),
+    )
+    if cfg.HeatmapDepth > 0 {
+        opts = append(opts, lrucache.WithHeatmap(lrucache.NewHeatmap(cfg.HeatmapDepth, cfg.HeatmapPrefixes)))
+    }
+    cache := lrucache.New(opts...)
+    namespaces := NewNamespaces(cache, cfg, shared)
+    go logRemovals(events.Subscribe("", 1024))
+    if cfg.AuditFile != "" || cfg.AuditWebhook != "" {
+        var err error
+        if auditor, err = NewAuditor(cfg.AuditFile, int64(cfg.AuditMaxSizeMB)<<20, cfg.AuditMaxBackups, cfg.AuditWebhook); err != nil {
+            fatal("cannot open audit log", err)
+        }
+    }
+
+    limiter := NewRateLimiter(cfg.RateLimit, cfg.RateBurst, cfg.ClientRateLimit, cfg.ClientRateBurst)
+    verifier, err := NewJWTVerifier(cfg.JWT)
+    if err != nil {
+        fatal("invalid -jwks-url", err)
+    }
+    auth := NewAuthenticator(cfg.APIKeys, cfg.Tenants, verifier, cfg.AuthReads)
+    reloader := NewReloader(cfg, cache, namespaces, limiter, auth)
+    if cfg.ReplicateFrom != "" {
+        auth.SetGate(replicaGate)
+        replication = newReplicaClient(cache, cfg.ReplicateFrom, cfg.ReplicationToken)
+    }
+    var ha *haNode
+    if cfg.HALock != "" {
+        var err error
+        if ha, err = newHANode(cfg, cache); err != nil {
+            fatal("invalid -ha-lock", err)
+        }
+        auth.SetGate(ha.gate)
+    }
+    if cfg.RaftListen != "" {
+        var err error
+        if consensus, err = newRaftNode(cfg, cache); err != nil {
+            fatal("cannot start raft", err)
+        }
+        cache.SetProposer(consensus)
+        auth.SetGate(consensus.gate)
+    }
+    h := &handlers{cache: cache, namespaces: namespaces, reloader: reloader, ha: ha, maxValueBytes: cfg.MaxValueBytes, cors: &cfg.CORS}
+    publishExpvars(h)
+    routes := apiRoutes(h, auth)
+    if len(cfg.ProxyBackends) > 0 || cfg.ProxyDiscover != "" {
+        var err error
+        if proxy, err = NewProxy(cfg); err != nil {
+            fatal("invalid proxy configuration", err)
+        }
+        routes = proxyRoutes()
+    }
+    mux := newRouter(routes)
+
+    var handler http.Handler = mux
+    if cfg.ReplicateFrom != "" {
+        handler = consistencyMiddleware(cfg.ReplicaPrimaryURL, handler)
+    }
+    if cfg.Compress {
+        handler = compressMiddleware(cfg.CompressLevel, cfg.CompressMinSize, handler)
+    }
+    handler = cfg.CORS.Middleware(limiter.Middleware(auth.Middleware(handler)))
+    handler = inflightMiddleware(cfg.MaxInflight, handler)
+    if cfg.OTLPEndpoint != "" {
+        if tracer, err = NewTracer(cfg.OTLPEndpoint, cfg.ServiceName, cfg.TraceSampleRatio); err != nil {
+            fatal("invalid -otlp-endpoint", err)
+        }
+    }
+    handler = traceMiddleware(mux, handler)
+    metrics := NewHTTPMetrics()
+    handler = metrics.Middleware(mux, handler)
+    handler = slowRequestMiddleware(cfg.SlowThreshold, handler)
+    if cfg.AccessLog {
+        handler = accessLogMiddleware(os.Stdout, handler)
+    }
+
+    var servers []namedServer
+    if cfg.HTTP3Listen != "" {
+        h3 := newHTTP3Server(cfg, cfg.HTTP3Listen, handler)
+        handler = altSvcMiddleware(h3, handler)
+        servers = append(servers, namedServer{"http3", cfg.HTTP3Listen, h3})
+    }
+    public := newHTTPServer(cfg, cfg.Listen, handler)
+    public.RegisterOnShutdown(closeWebSockets)
+    public.RegisterOnShutdown(closeStreams)
+    servers = append(servers, namedServer{"http", cfg.Listen, public})
+    if cfg.AdminListen != "" {
+        var admin http.Handler = withDashboard(auth.RequireRole(RoleAdmin, newAdminRouter(h, metrics)))
+        if cfg.AccessLog {
+            admin = accessLogMiddleware(os.Stdout, admin)
+        }
+        servers = append(servers, namedServer{"admin", cfg.AdminListen, newHTTPServer(cfg, cfg.AdminListen, admin)})
+    }
+
+    if cfg.RESPListen != "" {
+        servers = append(servers, namedServer{"resp", cfg.RESPListen, newRESPServer(cfg, cache, cfg.RESPListen, auth)})
+    }
+
+    if cfg.MemcacheListen != "" {
+        servers = append(servers, namedServer{"memcache", cfg.MemcacheListen, newMemcacheServer(cfg, cache, cfg.MemcacheListen, auth)})
+    }
+
+    if cfg.GRPCListen != "" {
+        servers = append(servers, namedServer{"grpc", cfg.GRPCListen, newGRPCServer(cfg, cache, cfg.GRPCListen, auth)})
+    }
+
+    if cfg.BinaryListen != "" {
+        servers = append(servers, namedServer{"binary", cfg.BinaryListen, newBinaryServer(cfg, cache, cfg.BinaryListen, auth)})
+    }
+
+    if cfg.ReplicationListen != "" {
+        servers = append(servers, namedServer{"replication", cfg.ReplicationListen, newReplicationServer(cfg, cache, cfg.ReplicationListen, auth)})
+    }
+
+    if replication != nil {
+        servers = append(servers, namedServer{"replica", cfg.ReplicateFrom, replication})
+    }
+
+    if ha != nil {
+        servers = append(servers, namedServer{"ha", ha.lock, ha})
+    }
+
+    if consensus != nil {
+        servers = append(servers, namedServer{"raft", consensus.addr, consensus})
+    }
+
+    if cfg.GossipListen != "" {
+        var err error
+        if membership, err = newMembership(cfg); err != nil {
+            fatal("cannot start gossip", err)
+        }
+        servers = append(servers, namedServer{"gossip", cfg.GossipListen, membership})
+        if cfg.GossipInvalidate {
+            invalidator = NewInvalidator(cache, membership)
+            servers = append(servers, namedServer{"invalidation", cfg.GossipListen, invalidator})
+        }
+        if cfg.GossipRebalance {
+            rebalancer = NewRebalancer(cache, membership, cfg.RebalanceRate)
+            servers = append(servers, namedServer{"rebalance", cfg.GossipListen, rebalancer})
+        }
+    }
+
+    if proxy != nil && (proxy.resolver != nil || proxy.hints != nil) {
+        servers = append(servers, namedServer{"proxy", cfg.ProxyDiscover, proxy})
+    }
+
+    if cfg.WANTarget != "" {
+        wan = NewWANShipper(cfg)
+        servers = append(servers, namedServer{"wan", cfg.WANTarget, wan})
+    }
+
+    if cfg.FillOrigin != "" {
+        var peers *Membership
+        if cfg.FillPeers {
+            peers = membership
+        }
+        filler = NewFiller(cfg, cache, peers)
+        cache.SetLoader(filler.Load)
+    }
+
+    if cfg.NATSURL != "" {
+        bridge, err := newNATSBridge(cfg, cache, auth)
+        if err != nil {
+            fatal("invalid NATS configuration", err)
+        }
+        servers = append(servers, namedServer{"nats", bridge.addr(), bridge})
+    }
+
+    if cfg.MQTTURL != "" {
+        bridge, err := newMQTTBridge(cfg, cache)
+        if err != nil {
+            fatal("invalid MQTT configuration", err)
+        }
+        h.mqtt = bridge
+        servers = append(servers, namedServer{"mqtt", bridge.addr(), bridge})
+    }
+
+    if cfg.StatsDAddr != "" {
+        statsd := NewStatsD(cfg.StatsDAddr, cfg.StatsDPrefix, cfg.StatsDTags, cfg.StatsDInterval, namespaces, metrics)
+        servers = append(servers, namedServer{"statsd", cfg.StatsDAddr, statsd})
+    }
+
+    if tracer != nil {
+        servers = append(servers, namedServer{"otlp", tracer.endpoint, tracer})
+    }
+
+    if auditor != nil {
+        servers = append(servers, namedServer{"audit", auditor.target(), auditor})
+    }
+
+    if len(cfg.Webhooks) > 0 {
+        sender := newWebhookSender(cfg.Webhooks, cfg.WebhookRetries)
+        servers = append(servers, namedServer{"webhook", sender.target(), sender})
+    }
+
+    slog.Info("starting",
+        "capacity", cfg.Capacity,
+        "max_value_bytes", cfg.MaxValueBytes,
+        "auth", auth.Enabled(),
+        "auth_reads", cfg.AuthReads,
+        "tls", cfg.TLSCert != "",
+        "rate_limit", cfg.RateLimit,
+        "client_rate_limit", cfg.ClientRateLimit,
+        "max_conns", cfg.MaxConns,
+        "max_inflight", cfg.MaxInflight,
+        "compress", cfg.Compress,
+        "tracing", tracer != nil,
+        "audit", auditor != nil,
+        "replica_of", cfg.ReplicateFrom,
+        "log_level", cfg.LogLevel.String(),
+        "memory_limit_bytes", memoryLimit(),
+    )
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    hangup := make(chan os.Signal, 1)
+    signal.Notify(hangup, syscall.SIGHUP)
+    go reloadOnHangup(reloader, hangup)
+    if err := runServers(ctx, stop, servers, cfg.ShutdownTimeout); err != nil {
+        fatal("server error", err)
+    }
+    slog.Info("shutdown complete")
+}
+
+// fatal logs err and exits with a non-zero status
+func fatal(msg string, err error) {
+    slog.Error(msg, "err", err)
+    os.Exit(1)
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "log"
+    "log/slog"
+    "net"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/hashicorp/memberlist"
+
+    "lru-cache/client"
+)
+
+// gossipJoinRetry is how often a node that has not reached any seed tries again
+const gossipJoinRetry = 5 * time.Second
+
+// NodeMeta is what every member gossips about itself
+type NodeMeta struct {
+    API      string `json:"api,omitempty"`      // Base URL of the HTTP API; empty when it listens on a unix socket
+    Draining bool   `json:"draining,omitempty"` // Handing its keys to the others before it is removed
+}
+
+// Member is one live node of the cluster
+type Member struct {
+    Name     string     `json:"name"`
+    Address  string     `json:"address"` // Gossip address
+    API      string     `json:"api,omitempty"`
+    Self     bool       `json:"self,omitempty"`
+    Draining bool       `json:"draining,omitempty"` // Owns no keys on the ring
+    State    string     `json:"state"`              // alive, or suspect while it misses probes
+    RTTMs    float64    `json:"rtt_ms,omitempty"`   // Round trip of this node's last probe of the member
+    LastAck  *time.Time `json:"last_ack,omitempty"`
+}
+
+// MembersResponse is returned by GET /cluster/members
+type MembersResponse struct {
+    Self    string   `json:"self"`
+    Health  int      `json:"health"` // Zero when this node answers probes on time; higher when it is slow
+    Members []Member `json:"members"`
+}
+
+// probe is the outcome of the last successful probe of a member
+type probe struct {
+    rtt time.Duration
+    at  time.Time
+}
+
+// membership is nil unless -gossip-listen is set
+var membership *Membership
+
+// Membership discovers the other nodes of the cluster and detects their
+// failures by gossip, following SWIM as implemented by memberlist
+type Membership struct {
+    list     *memberlist.Memberlist
+    seeds    []string
+    resolver client.Resolver // nil unless -gossip-discover is set
+    refresh  time.Duration
+
+    mutex     sync.Mutex // Guards meta, probes, listeners and handlers
+    meta      []byte
+    probes    map[string]probe
+    listeners []func()
+    handlers  map[byte]func([]byte)
+    done      chan struct{}
+    closeOnce sync.Once
+
+    ringMutex sync.Mutex // Guards ring and apis
+    ring      *client.Ring
+    apis      map[string]string // Member name to the base URL of its HTTP API
+    ringStale atomic.Bool       // Membership changed since the ring was built
+}
+
+// newMembership starts gossiping on cfg.GossipListen; the node joins the
+// seeds in cfg.GossipJoin once ListenAndServe runs
+func newMembership(cfg *Config) (*Membership, error) {
+    config := memberlist.DefaultLANConfig()
+    if cfg.GossipName != "" {
+        config.Name = cfg.GossipName
+    }
+    host, port, err := splitHostPort(cfg.GossipListen)
+    if err != nil {
+        return nil, fmt.Errorf("-gossip-listen: %w", err)
+    }
+    if host != "" {
+        config.BindAddr = host
+    }
+    config.BindPort, config.AdvertisePort = port, port
+    if cfg.GossipAdvertise != "" {
+        if config.AdvertiseAddr, config.AdvertisePort, err = splitHostPort(cfg.GossipAdvertise); err != nil {
+            return nil, fmt.Errorf("-gossip-advertise: %w", err)
+        }
+    }
+    if cfg.GossipKey != "" {
+        key, err := base64.StdEncoding.DecodeString(cfg.GossipKey)
+        if err != nil || (len(key) != 16 && len(key) != 24 && len(key) != 32) {
+            return nil, fmt.Errorf("-gossip-key must be 16, 24 or 32 bytes encoded in base64")
+        }
+        config.SecretKey = key
+    }
+    config.Logger = log.New(memberlistLog{}, "", 0)
+
+    m := &Membership{seeds: cfg.GossipJoin, refresh: cfg.GossipRefresh, probes: make(map[string]probe), handlers: make(map[byte]func([]byte)), done: make(chan struct{})}
+    if cfg.GossipDiscover != "" {
+        if m.resolver, err = client.ParseResolver(cfg.GossipDiscover); err != nil {
+            return nil, fmt.Errorf("-gossip-discover: %w", err)
+        }
+    }
+    m.ringStale.Store(true)
+    config.Delegate = m
+    config.Events = m
+    config.Ping = m
+    advertise := config.AdvertiseAddr
+    if advertise == "" {
+        advertise = config.BindAddr
+    }
+    m.meta, _ = json.Marshal(NodeMeta{API: apiURL(cfg, advertise)})
+    if m.list, err = memberlist.Create(config); err != nil {
+        return nil, err
+    }
+    // The bind address may be unspecified; memberlist has resolved what others reach
+    if api := apiURL(cfg, m.list.LocalNode().Addr.String()); api != "" {
+        m.mutex.Lock()
+        m.meta, _ = json.Marshal(NodeMeta{API: api})
+        m.mutex.Unlock()
+        m.list.UpdateNode(time.Second)
+    }
+    return m, nil
+}
+
+// splitHostPort is net.SplitHostPort with the port parsed
+func splitHostPort(addr string) (string, int, error) {
+    host, portStr, err := net.SplitHostPort(addr)
+    if err != nil {
+        return "", 0, err
+    }
+    port, err := strconv.Atoi(portStr)
+    if err != nil {
+        return "", 0, fmt.Errorf("invalid port %q", portStr)
+    }
+    return host, port, nil
+}
+
+// apiURL is the base URL peers reach this node's HTTP API at: the -listen
+// address, with advertise as the host when -listen does not name one
+func apiURL(cfg *Config, advertise string) string {
+    if strings.HasPrefix(cfg.Listen, "unix://") || strings.HasPrefix(cfg.Listen, systemdPrefix) {
+        return ""
+    }
+    host, port, err := net.SplitHostPort(cfg.Listen)
+    if err != nil {
+        return ""
+    }
+    if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+        host = advertise
+        if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+            return ""
+        }
+    }
+    scheme := "http"
+    if cfg.TLSCert != "" {
+        scheme = "https"
+    }
+    return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// memberlistLog forwards memberlist's "[LEVEL] memberlist: message" lines to slog
+type memberlistLog struct{}
+
+func (memberlistLog) Write(p []byte) (int, error) {
+    line := strings.TrimSpace(string(p))
+    level := slog.LevelInfo
+    for prefix, l := range map[string]slog.Level{"[DEBUG]": slog.LevelDebug, "[INFO]": slog.LevelInfo, "[WARN]": slog.LevelWarn, "[ERR]": slog.LevelError} {
+        if rest, ok := strings.CutPrefix(line, prefix); ok {
+            line, level = strings.TrimSpace(rest), l
+            break
+        }
+    }
+    slog.Log(context.Background(), level, "gossip: "+strings.TrimPrefix(line, "memberlist: "))
+    return len(p), nil
+}
+
+// OnChange registers fn to run whenever a member joins, leaves, fails or
+// changes its metadata
+func (m *Membership) OnChange(fn func()) {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    m.listeners = append(m.listeners, fn)
+}
+
+func (m *Membership) changed() {
+    // Listeners run with memberlist's locks held, so the ring is rebuilt when next used
+    m.ringStale.Store(true)
+    m.mutex.Lock()
+    listeners := m.listeners
+    m.mutex.Unlock()
+    for _, fn := range listeners {
+        fn()
+    }
+}
+
+// Members returns the live members, this node included, sorted by name
+func (m *Membership) Members() []Member {
+    self := m.list.LocalNode().Name
+    var members []Member
+    m.mutex.Lock()
+    for _, node := range m.list.Members() {
+        var meta NodeMeta
+        json.Unmarshal(node.Meta, &meta)
+        member := Member{Name: node.Name, Address: node.Address(), API: meta.API, Self: node.Name == self, Draining: meta.Draining, State: "alive"}
+        if node.State == memberlist.StateSuspect {
+            member.State = "suspect"
+        }
+        if p, ok := m.probes[node.Name]; ok {
+            member.RTTMs = float64(p.rtt.Microseconds()) / 1000
+            member.LastAck = &p.at
+        }
+        members = append(members, member)
+    }
+    m.mutex.Unlock()
+    sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+    return members
+}
+
+// Peers returns the other live members
+func (m *Membership) Peers() []*memberlist.Node {
+    self := m.list.LocalNode().Name
+    var peers []*memberlist.Node
+    for _, node := range m.list.Members() {
+        if node.Name != self {
+            peers = append(peers, node)
+        }
+    }
+    return peers
+}
+
+// Owner returns the member owning key on a consistent hash ring of the
+// members, leaving out those draining or without a reachable API, and its
+// API; the name is empty when this node owns the key
+func (m *Membership) Owner(key string) (name, api string) {
+    m.ringMutex.Lock()
+    defer m.ringMutex.Unlock()
+    m.rebuildRing()
+    name = m.ring.Node(key)
+    if name == m.Name() {
+        return "", ""
+    }
+    return name, m.apis[name]
+}
+
+// rebuildRing builds the ring again if the membership changed since; the
+// caller holds ringMutex
+func (m *Membership) rebuildRing() {
+    if !m.ringStale.Swap(false) {
+        return
+    }
+    m.ring, m.apis = client.NewRing(client.DefaultReplicas), make(map[string]string)
+    for _, member := range m.Members() {
+        if (member.Self || member.API != "") && !member.Draining {
+            m.ring.Add(member.Name)
+            m.apis[member.Name] = member.API
+        }
+    }
+}
+
+// Ownership returns the spans of the hash space each member owns and its
+// share of the keys
+func (m *Membership) Ownership() (map[string][]client.Arc, map[string]float64) {
+    m.ringMutex.Lock()
+    defer m.ringMutex.Unlock()
+    m.rebuildRing()
+    return m.ring.Arcs(), m.ring.Share()
+}
+
+// Drain gossips that this node is leaving, so no member counts it as the
+// owner of any key from then on
+func (m *Membership) Drain() {
+    m.mutex.Lock()
+    var meta NodeMeta
+    json.Unmarshal(m.meta, &meta)
+    meta.Draining = true
+    m.meta, _ = json.Marshal(meta)
+    m.mutex.Unlock()
+    if err := m.list.UpdateNode(time.Second); err != nil {
+        slog.Warn("gossip: drain not yet acknowledged by every member", "err", err)
+    }
+    m.ringStale.Store(true)
+}
+
+// Handle routes messages from other members whose first byte is kind to fn,
+// which receives the rest; it runs on memberlist's goroutine and must be quick
+func (m *Membership) Handle(kind byte, fn func([]byte)) {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    m.handlers[kind] = fn
+}
+
+// Name is this node's name in the cluster
+func (m *Membership) Name() string {
+    return m.list.LocalNode().Name
+}
+
+// NodeMeta gossips this node's NodeMeta
+func (m *Membership) NodeMeta(limit int) []byte {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    if len(m.meta) > limit {
+        return nil
+    }
+    return m.meta
+}
+
+// NotifyMsg passes a message from another member to its handler
+func (m *Membership) NotifyMsg(msg []byte) {
+    if len(msg) == 0 {
+        return
+    }
+    m.mutex.Lock()
+    fn := m.handlers[msg[0]]
+    m.mutex.Unlock()
+    if fn != nil {
+        fn(msg[1:])
+    } else {
+        slog.Debug("gossip: unknown message", "kind", msg[0])
+    }
+}
+
+// GetBroadcasts has nothing to piggyback on gossip yet
+func (m *Membership) GetBroadcasts(overhead, limit int) [][]byte { return nil }
+
+// LocalState has no state to exchange on push/pull syncs
+func (m *Membership) LocalState(join bool) []byte { return nil }
+
+// MergeRemoteState ignores the empty state of other members
+func (m *Membership) MergeRemoteState(buf []byte, join bool) {}
+
+// NotifyJoin logs a member joining
+func (m *Membership) NotifyJoin(node *memberlist.Node) {
+    slog.Info("gossip: member joined", "name", node.Name, "address", node.Address())
+    m.changed()
+}
+
+// NotifyLeave logs a member leaving or being declared dead after it stopped
+// answering probes; memberlist does not say which
+func (m *Membership) NotifyLeave(node *memberlist.Node) {
+    slog.Info("gossip: member left or failed", "name", node.Name, "address", node.Address())
+    m.mutex.Lock()
+    delete(m.probes, node.Name)
+    m.mutex.Unlock()
+    m.changed()
+}
+
+// NotifyUpdate notes a member's changed metadata
+func (m *Membership) NotifyUpdate(node *memberlist.Node) {
+    slog.Debug("gossip: member updated", "name", node.Name, "address", node.Address())
+    m.changed()
+}
+
+// AckPayload sends nothing extra with probe acknowledgements
+func (m *Membership) AckPayload() []byte { return nil }
+
+// NotifyPingComplete records the round trip of a successful probe
+func (m *Membership) NotifyPingComplete(other *memberlist.Node, rtt time.Duration, payload []byte) {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    m.probes[other.Name] = probe{rtt: rtt, at: time.Now().UTC()}
+}
+
+// ListenAndServe joins the seeds, retrying until one answers, then gossips
+// until shut down, joining the nodes discovery finds as they appear
+func (m *Membership) ListenAndServe() error {
+    for len(m.seeds) > 0 {
+        n, err := m.list.Join(m.seeds)
+        if err == nil {
+            slog.Info("gossip: joined cluster", "contacted", n, "members", m.list.NumMembers())
+            break
+        }
+        slog.Warn("gossip: no seed reachable, retrying", "seeds", strings.Join(m.seeds, ","), "err", err, "retry", gossipJoinRetry)
+        select {
+        case <-m.done:
+            return errServerClosed
+        case <-time.After(gossipJoinRetry):
+        }
+    }
+    if m.resolver == nil {
+        <-m.done
+        return errServerClosed
+    }
+    ticker := time.NewTicker(m.refresh)
+    defer ticker.Stop()
+    for {
+        m.discover()
+        select {
+        case <-m.done:
+            return errServerClosed
+        case <-ticker.C:
+        }
+    }
+}
+
+// discover joins the nodes the registry lists that are not members; that
+// also merges clusters that formed apart while the registry was down.
+// Members missing from the registry are left to failure detection.
+func (m *Membership) discover() {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    addrs, err := m.resolver.Resolve(ctx)
+    cancel()
+    if err != nil {
+        slog.Warn("gossip: discovery failed", "err", err)
+        return
+    }
+    known := make(map[string]bool)
+    for _, node := range m.list.Members() {
+        known[node.Address()] = true
+    }
+    var fresh []string
+    for _, addr := range addrs {
+        if !known[addr] {
+            fresh = append(fresh, addr)
+        }
+    }
+    if len(fresh) == 0 {
+        return
+    }
+    n, err := m.list.Join(fresh)
+    switch {
+    case n == 0:
+        slog.Warn("gossip: no discovered node reachable", "addresses", strings.Join(fresh, ","), "err", err)
+    default:
+        slog.Debug("gossip: joined discovered nodes", "contacted", n, "members", m.list.NumMembers())
+    }
+}
+
+// Shutdown tells the other members this node is leaving, then stops gossiping
+func (m *Membership) Shutdown(ctx context.Context) error {
+    timeout := 5 * time.Second
+    if deadline, ok := ctx.Deadline(); ok {
+        timeout = time.Until(deadline)
+    }
+    if err := m.list.Leave(timeout); err != nil {
+        slog.Warn("gossip: leave not acknowledged", "err", err)
+    }
+    return m.Close()
+}
+
+// Close stops gossiping without announcing it; the others detect the failure
+func (m *Membership) Close() error {
+    var err error
+    m.closeOnce.Do(func() {
+        close(m.done)
+        err = m.list.Shutdown()
+    })
+    return err
+}
+
+// membersHandler handles GET /cluster/members
+func membersHandler(w http.ResponseWriter, r *http.Request) {
+    if membership == nil {
+        writeError(w, http.StatusNotFound, codeNotFound, "Cluster membership is disabled; set -gossip-listen")
+        return
+    }
+    writeJSON(w, MembersResponse{Self: membership.Name(), Health: membership.list.GetHealthScore(), Members: membership.Members()})
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "strconv"
+    "strings"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+const (
+    memcacheMaxKeyLength = 250
+    memcacheMaxLine      = 8 << 10
+    // memcacheRelativeLimit is the largest exptime treated as relative seconds;
+    // larger values are absolute Unix timestamps
+    memcacheRelativeLimit = 60 * 60 * 24 * 30
+    memcacheVersion       = "1.6.0-lru-cache"
+)
+
+// newMemcacheServer serves the memcached ASCII protocol backed by the shared cache
+func newMemcacheServer(cfg *Config, cache lrucache.Cache, addr string, auth *Authenticator) *tcpServer {
+    return &tcpServer{
+        Addr:     addr,
+        MaxConns: cfg.MaxConns,
+        handle: func(conn net.Conn, closing func() bool) {
+            s := &memcacheSession{
+                cache:         cache,
+                maxValueBytes: cfg.MaxValueBytes,
+                r:             bufio.NewReader(conn),
+                w:             bufio.NewWriter(conn),
+                auth:          auth,
+                client:        connClient(conn),
+            }
+            var err error
+            if magic, peekErr := s.r.Peek(1); peekErr == nil && magic[0] == memcacheRequestMagic {
+                err = s.serveBinary(closing)
+            } else {
+                err = s.serveText(closing)
+            }
+            if err != nil {
+                logConnError("memcache", conn, err, closing)
+            }
+        },
+    }
+}
+
+// memcacheSession is the state of one memcached client connection
+type memcacheSession struct {
+    cache         lrucache.Cache
+    maxValueBytes int
+    r             *bufio.Reader
+    w             *bufio.Writer
+    auth          *Authenticator
+    principal     *Principal
+    client        string
+    quit          bool
+}
+
+// caller identifies the client for the audit log
+func (s *memcacheSession) caller() Caller {
+    return newCaller("memcache", s.principal, s.client)
+}
+
+// storeModes maps storage commands to the condition they write under
+var storeModes = map[string]lrucache.StoreMode{
+    "set":     lrucache.StoreAlways,
+    "add":     lrucache.StoreIfAbsent,
+    "replace": lrucache.StoreIfPresent,
+}
+
+// memcacheExpiration converts a memcached exptime to a cache expiration;
+// expired reports a time in the past, which memcached treats as immediately expired
+func memcacheExpiration(exptime int64) (expiration time.Duration, expired bool) {
+    switch {
+    case exptime == 0:
+        return 0, false
+    case exptime < 0:
+        return 0, true
+    case exptime <= memcacheRelativeLimit:
+        return time.Duration(exptime) * time.Second, false
+    default:
+        d := time.Until(time.Unix(exptime, 0))
+        return d, d <= 0
+    }
+}
+
+// validMemcacheKey reports whether key is at most 250 bytes without spaces or control characters
+func validMemcacheKey(key string) bool {
+    if key == "" || len(key) > memcacheMaxKeyLength {
+        return false
+    }
+    for i := 0; i < len(key); i++ {
+        if key[i] <= ' ' || key[i] == 0x7f {
+            return false
+        }
+    }
+    return true
+}
+
+// serveText reads and answers ASCII commands until the client disconnects or quits
+func (s *memcacheSession) serveText(closing func() bool) error {
+    for !s.quit && !closing() {
+        line, err := readLimitedLine(s.r, memcacheMaxLine)
+        if err != nil {
+            if errors.Is(err, io.EOF) {
+                return nil
+            }
+            if errors.Is(err, errLineTooLong) {
+                s.w.WriteString("CLIENT_ERROR line too long\r\n")
+                s.w.Flush()
+            }
+            return err
+        }
+        fields := strings.Fields(line)
+        if len(fields) == 0 {
+            s.w.WriteString("ERROR\r\n")
+        } else if err := s.dispatchText(fields); err != nil {
+            return err
+        }
+        if err := flushDrained(s.r, s.w, s.quit || closing()); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// textAllowed checks the session's principal for the role, replying with an error if denied
+func (s *memcacheSession) textAllowed(required Role) bool {
+    switch s.auth.Authorize(s.principal, required) {
+    case nil:
+        return true
+    case errNoCredentials:
+        s.w.WriteString("CLIENT_ERROR unauthenticated\r\n")
+    case errReadOnly:
+        s.w.WriteString("SERVER_ERROR read-only node\r\n")
+    case errNoLeader:
+        s.w.WriteString("SERVER_ERROR no raft leader\r\n")
+    default:
+        s.w.WriteString("CLIENT_ERROR permission denied\r\n")
+    }
+    return false
+}
+
+// writeTextCacheError answers a command the cache refused for a reason
+// other than its CAS value or store mode
+func (s *memcacheSession) writeTextCacheError(err error) {
+    switch {
+    case errors.Is(err, lrucache.ErrTooLarge):
+        s.w.WriteString("SERVER_ERROR object too large for cache\r\n")
+    case errors.Is(err, lrucache.ErrQuotaExceeded):
+        s.w.WriteString("SERVER_ERROR out of memory storing object\r\n")
+    default:
+        s.w.WriteString("SERVER_ERROR " + err.Error() + "\r\n")
+    }
+}
+
+// dispatchText executes one ASCII command; only I/O errors are returned
+func (s *memcacheSession) dispatchText(fields []string) error {
+    cmd := fields[0]
+    noreply := len(fields) > 1 && fields[len(fields)-1] == "noreply"
+    if noreply {
+        fields = fields[:len(fields)-1]
+    }
+    reply := func(msg string) {
+        if !noreply {
+            s.w.WriteString(msg + "\r\n")
+        }
+    }
+
+    switch cmd {
+    case "get", "gets":
+        if len(fields) < 2 {
+            s.w.WriteString("ERROR\r\n")
+            return nil
+        }
+        if !s.textAllowed(s.auth.ReadRole()) {
+            return nil
+        }
+        for _, key := range fields[1:] {
+            if item, err := s.cache.GetItem(key); err == nil {
+                if cmd == "gets" {
+                    fmt.Fprintf(s.w, "VALUE %s %d %d %d\r\n", key, item.Flags, len(item.Value), item.CAS)
+                } else {
+                    fmt.Fprintf(s.w, "VALUE %s %d %d\r\n", key, item.Flags, len(item.Value))
+                }
+                s.w.WriteString(item.Value)
+                s.w.WriteString("\r\n")
+            }
+        }
+        s.w.WriteString("END\r\n")
+    case "set", "add", "replace", "cas":
+        return s.storeText(cmd, fields, noreply, reply)
+    case "delete":
+        if len(fields) != 2 {
+            s.w.WriteString("ERROR\r\n")
+            return nil
+        }
+        if !s.textAllowed(RoleWrite) {
+            return nil
+        }
+        if s.cache.Delete(fields[1]) {
+            audit(s.caller(), "delete", fields[1])
+            reply("DELETED")
+        } else {
+            reply("NOT_FOUND")
+        }
+    case "touch":
+        if len(fields) != 3 {
+            s.w.WriteString("ERROR\r\n")
+            return nil
+        }
+        exptime, err := strconv.ParseInt(fields[2], 10, 64)
+        if err != nil {
+            s.w.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+            return nil
+        }
+        if !s.textAllowed(RoleWrite) {
+            return nil
+        }
+        expiration, expired := memcacheExpiration(exptime)
+        var touched bool
+        if expired {
+            if touched = s.cache.Delete(fields[1]); touched {
+                audit(s.caller(), "delete", fields[1])
+            }
+        } else {
+            touched = s.cache.Touch(fields[1], expiration)
+        }
+        if touched {
+            reply("TOUCHED")
+        } else {
+            reply("NOT_FOUND")
+        }
+    case "flush_all":
+        if !s.textAllowed(RoleAdmin) {
+            return nil
+        }
+        delay := int64(0)
+        if len(fields) > 1 {
+            var err error
+            if delay, err = strconv.ParseInt(fields[1], 10, 64); err != nil || delay < 0 {
+                s.w.WriteString("CLIENT_ERROR invalid delay argument\r\n")
+                return nil
+            }
+        }
+        if delay > 0 {
+            time.AfterFunc(time.Duration(delay)*time.Second, func() { s.cache.Flush() })
+        } else {
+            s.cache.Flush()
+        }
+        audit(s.caller(), "flush", "")
+        reply("OK")
+    case "version":
+        s.w.WriteString("VERSION " + memcacheVersion + "\r\n")
+    case "verbosity":
+        reply("OK")
+    case "quit":
+        s.quit = true
+    default:
+        s.w.WriteString("ERROR\r\n")
+    }
+    return nil
+}
+
+// storeText implements set/add/replace <key> <flags> <exptime> <bytes> [noreply]
+// and cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+func (s *memcacheSession) storeText(cmd string, fields []string, noreply bool, reply func(string)) error {
+    var unique uint64
+    if cmd == "cas" {
+        if len(fields) != 6 {
+            s.w.WriteString("ERROR\r\n")
+            return nil
+        }
+        var err error
+        if unique, err = strconv.ParseUint(fields[5], 10, 64); err != nil {
+            s.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+            return nil
+        }
+        fields = fields[:5]
+    }
+    if len(fields) != 5 {
+        s.w.WriteString("ERROR\r\n")
+        return nil
+    }
+    key := fields[1]
+    flags, errFlags := strconv.ParseUint(fields[2], 10, 32)
+    exptime, errExp := strconv.ParseInt(fields[3], 10, 64)
+    size, errSize := strconv.Atoi(fields[4])
+    if errFlags != nil || errExp != nil || errSize != nil || size < 0 {
+        s.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+        return nil
+    }
+    if size > s.maxValueBytes {
+        // Swallow the data block so the connection stays in sync
+        if _, err := io.CopyN(io.Discard, s.r, int64(size)+2); err != nil {
+            return err
+        }
+        s.w.WriteString("SERVER_ERROR object too large for cache\r\n")
+        return nil
+    }
+    data := make([]byte, size+2)
+    if _, err := io.ReadFull(s.r, data); err != nil {
+        return err
+    }
+    if data[size] != '\r' || data[size+1] != '\n' {
+        s.w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+        return nil
+    }
+    value := string(data[:size])
+
+    // Without a principal, the first set carries "<user> <token>" credentials
+    // following the memcached ASCII authentication convention
+    if s.auth.Enabled() && s.principal == nil {
+        parts := strings.Fields(value)
+        if cmd != "set" || len(parts) != 2 {
+            s.w.WriteString("CLIENT_ERROR unauthenticated\r\n")
+            return nil
+        }
+        p, err := s.auth.AuthenticateToken(parts[1])
+        if err != nil {
+            s.w.WriteString("CLIENT_ERROR authentication failure\r\n")
+            return nil
+        }
+        s.principal = p
+        s.w.WriteString("STORED\r\n")
+        return nil
+    }
+
+    if !validMemcacheKey(key) {
+        s.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+        return nil
+    }
+    if !s.textAllowed(RoleWrite) {
+        return nil
+    }
+
+    expiration, expired := memcacheExpiration(exptime)
+    var err error
+    if cmd == "cas" {
+        _, err = s.cache.CompareAndSwap(key, value, uint32(flags), expiration, unique)
+    } else {
+        _, err = s.cache.Store(key, value, uint32(flags), expiration, storeModes[cmd])
+    }
+    switch {
+    case errors.Is(err, lrucache.ErrCASNotFound):
+        reply("NOT_FOUND")
+        return nil
+    case errors.Is(err, lrucache.ErrCASMismatch):
+        reply("EXISTS")
+        return nil
+    case errors.Is(err, lrucache.ErrNotStored), errors.Is(err, lrucache.ErrNotAdmitted):
+        reply("NOT_STORED")
+        return nil
+    case err != nil:
+        s.writeTextCacheError(err)
+        return nil
+    }
+    if expired {
+        s.cache.Delete(key)
+    }
+    audit(s.caller(), "set", key)
+    reply("STORED")
+    return nil
+}
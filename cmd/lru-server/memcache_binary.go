@@ -0,0 +1,376 @@
+package main
+
+import (
+    "encoding/binary"
+    "errors"
+    "io"
+    "strings"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// Binary protocol magic bytes
+const (
+    memcacheRequestMagic  = 0x80
+    memcacheResponseMagic = 0x81
+    memcacheHeaderSize    = 24
+)
+
+// Binary protocol opcodes
+const (
+    opGet      = 0x00
+    opSet      = 0x01
+    opAdd      = 0x02
+    opReplace  = 0x03
+    opDelete   = 0x04
+    opQuit     = 0x07
+    opFlush    = 0x08
+    opGetQ     = 0x09
+    opNoop     = 0x0a
+    opVersion  = 0x0b
+    opGetK     = 0x0c
+    opGetKQ    = 0x0d
+    opSetQ     = 0x11
+    opAddQ     = 0x12
+    opReplaceQ = 0x13
+    opDeleteQ  = 0x14
+    opQuitQ    = 0x17
+    opFlushQ   = 0x18
+    opTouch    = 0x1c
+    opGAT      = 0x1d
+    opGATQ     = 0x1e
+    opSASLList = 0x20
+    opSASLAuth = 0x21
+)
+
+// Binary protocol response status codes
+const (
+    statusOK             = 0x0000
+    statusKeyNotFound    = 0x0001
+    statusKeyExists      = 0x0002
+    statusValueTooLarge  = 0x0003
+    statusInvalidArgs    = 0x0004
+    statusNotStored      = 0x0005
+    statusAuthError      = 0x0020
+    statusUnknownCommand = 0x0081
+    statusOutOfMemory    = 0x0082
+    statusTempFailure    = 0x0086
+)
+
+// binaryHeader is the fixed 24-byte header of binary requests and responses
+type binaryHeader struct {
+    magic     byte
+    opcode    byte
+    keyLen    uint16
+    extrasLen uint8
+    dataType  uint8
+    status    uint16 // vbucket id in requests
+    bodyLen   uint32
+    opaque    uint32
+    cas       uint64
+}
+
+func readBinaryHeader(r io.Reader) (binaryHeader, error) {
+    var buf [memcacheHeaderSize]byte
+    if _, err := io.ReadFull(r, buf[:]); err != nil {
+        return binaryHeader{}, err
+    }
+    return binaryHeader{
+        magic:     buf[0],
+        opcode:    buf[1],
+        keyLen:    binary.BigEndian.Uint16(buf[2:4]),
+        extrasLen: buf[4],
+        dataType:  buf[5],
+        status:    binary.BigEndian.Uint16(buf[6:8]),
+        bodyLen:   binary.BigEndian.Uint32(buf[8:12]),
+        opaque:    binary.BigEndian.Uint32(buf[12:16]),
+        cas:       binary.BigEndian.Uint64(buf[16:24]),
+    }, nil
+}
+
+// binaryRequest is a decoded binary request
+type binaryRequest struct {
+    binaryHeader
+    extras []byte
+    key    string
+    value  []byte
+}
+
+// isQuiet reports whether the opcode suppresses its successful response
+func isQuiet(opcode byte) bool {
+    switch opcode {
+    case opGetQ, opGetKQ, opSetQ, opAddQ, opReplaceQ, opDeleteQ, opQuitQ, opFlushQ, opGATQ:
+        return true
+    }
+    return false
+}
+
+// writeBinary writes a response echoing the request's opcode and opaque value
+func (s *memcacheSession) writeBinary(req *binaryRequest, status uint16, cas uint64, extras []byte, key string, value []byte) {
+    var buf [memcacheHeaderSize]byte
+    buf[0] = memcacheResponseMagic
+    buf[1] = req.opcode
+    binary.BigEndian.PutUint16(buf[2:4], uint16(len(key)))
+    buf[4] = uint8(len(extras))
+    binary.BigEndian.PutUint16(buf[6:8], status)
+    binary.BigEndian.PutUint32(buf[8:12], uint32(len(extras)+len(key)+len(value)))
+    binary.BigEndian.PutUint32(buf[12:16], req.opaque)
+    binary.BigEndian.PutUint64(buf[16:24], cas)
+    s.w.Write(buf[:])
+    s.w.Write(extras)
+    s.w.WriteString(key)
+    s.w.Write(value)
+}
+
+// writeBinaryStatus writes an error response with the status message as body
+func (s *memcacheSession) writeBinaryStatus(req *binaryRequest, status uint16, msg string) {
+    s.writeBinary(req, status, 0, nil, "", []byte(msg))
+}
+
+// writeBinaryCacheError answers a request the cache refused for a reason
+// other than its CAS value or store mode
+func (s *memcacheSession) writeBinaryCacheError(req *binaryRequest, err error) {
+    switch {
+    case errors.Is(err, lrucache.ErrNotAdmitted):
+        s.writeBinaryStatus(req, statusNotStored, "Not stored.")
+    case errors.Is(err, lrucache.ErrTooLarge):
+        s.writeBinaryStatus(req, statusValueTooLarge, "Too large.")
+    case errors.Is(err, lrucache.ErrQuotaExceeded):
+        s.writeBinaryStatus(req, statusOutOfMemory, "Out of memory")
+    default:
+        s.writeBinaryStatus(req, statusTempFailure, "Temporary failure: "+err.Error())
+    }
+}
+
+// serveBinary reads and answers binary requests until the client disconnects or quits
+func (s *memcacheSession) serveBinary(closing func() bool) error {
+    for !s.quit && !closing() {
+        hdr, err := readBinaryHeader(s.r)
+        if err != nil {
+            if errors.Is(err, io.EOF) {
+                return nil
+            }
+            return err
+        }
+        if hdr.magic != memcacheRequestMagic || int(hdr.keyLen)+int(hdr.extrasLen) > int(hdr.bodyLen) {
+            return errors.New("invalid binary request header")
+        }
+        req := &binaryRequest{binaryHeader: hdr}
+        if int64(hdr.bodyLen) > int64(s.maxValueBytes)+memcacheMaxKeyLength+64 {
+            if _, err := io.CopyN(io.Discard, s.r, int64(hdr.bodyLen)); err != nil {
+                return err
+            }
+            s.writeBinaryStatus(req, statusValueTooLarge, "Too large.")
+        } else {
+            body := make([]byte, hdr.bodyLen)
+            if _, err := io.ReadFull(s.r, body); err != nil {
+                return err
+            }
+            req.extras = body[:hdr.extrasLen]
+            req.key = string(body[hdr.extrasLen : int(hdr.extrasLen)+int(hdr.keyLen)])
+            req.value = body[int(hdr.extrasLen)+int(hdr.keyLen):]
+            s.dispatchBinary(req)
+        }
+        if err := flushDrained(s.r, s.w, s.quit || closing()); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// binaryAllowed checks the session's principal for the role, replying with an error if denied
+func (s *memcacheSession) binaryAllowed(req *binaryRequest, required Role) bool {
+    switch s.auth.Authorize(s.principal, required) {
+    case nil:
+        return true
+    case errReadOnly:
+        s.writeBinaryStatus(req, statusNotStored, "Read-only node.")
+    case errNoLeader:
+        s.writeBinaryStatus(req, statusTempFailure, "Temporary failure.")
+    default:
+        s.writeBinaryStatus(req, statusAuthError, "Auth failure.")
+    }
+    return false
+}
+
+// dispatchBinary executes one binary request and writes its response
+func (s *memcacheSession) dispatchBinary(req *binaryRequest) {
+    quiet := isQuiet(req.opcode)
+    switch req.opcode {
+    case opGet, opGetQ, opGetK, opGetKQ:
+        if !s.binaryAllowed(req, s.auth.ReadRole()) {
+            return
+        }
+        item, err := s.cache.GetItem(req.key)
+        s.writeGetResponse(req, item, err == nil, req.opcode == opGetK || req.opcode == opGetKQ, quiet)
+    case opGAT, opGATQ, opTouch:
+        if len(req.extras) != 4 {
+            s.writeBinaryStatus(req, statusInvalidArgs, "Invalid arguments")
+            return
+        }
+        if !s.binaryAllowed(req, RoleWrite) {
+            return
+        }
+        expiration, expired := memcacheExpiration(int64(int32(binary.BigEndian.Uint32(req.extras))))
+        item, err := s.cache.GetItem(req.key)
+        found := err == nil
+        if found && expired {
+            s.cache.Delete(req.key)
+            audit(s.caller(), "delete", req.key)
+        } else if found {
+            s.cache.Touch(req.key, expiration)
+        }
+        if req.opcode == opTouch {
+            if !found {
+                s.writeBinaryStatus(req, statusKeyNotFound, "Not found")
+                return
+            }
+            s.writeBinary(req, statusOK, item.CAS, nil, "", nil)
+            return
+        }
+        s.writeGetResponse(req, item, found, false, quiet)
+    case opSet, opSetQ, opAdd, opAddQ, opReplace, opReplaceQ:
+        s.storeBinary(req, quiet)
+    case opDelete, opDeleteQ:
+        if !s.binaryAllowed(req, RoleWrite) {
+            return
+        }
+        switch err := s.cache.DeleteCAS(req.key, req.cas); {
+        case errors.Is(err, lrucache.ErrCASNotFound):
+            s.writeBinaryStatus(req, statusKeyNotFound, "Not found")
+        case errors.Is(err, lrucache.ErrCASMismatch):
+            s.writeBinaryStatus(req, statusKeyExists, "Data exists for key.")
+        case err != nil:
+            s.writeBinaryCacheError(req, err)
+        default:
+            audit(s.caller(), "delete", req.key)
+            if !quiet {
+                s.writeBinary(req, statusOK, 0, nil, "", nil)
+            }
+        }
+    case opFlush, opFlushQ:
+        if !s.binaryAllowed(req, RoleAdmin) {
+            return
+        }
+        if len(req.extras) == 4 && binary.BigEndian.Uint32(req.extras) > 0 {
+            delay := time.Duration(binary.BigEndian.Uint32(req.extras)) * time.Second
+            time.AfterFunc(delay, func() { s.cache.Flush() })
+        } else {
+            s.cache.Flush()
+        }
+        audit(s.caller(), "flush", "")
+        if !quiet {
+            s.writeBinary(req, statusOK, 0, nil, "", nil)
+        }
+    case opNoop:
+        s.writeBinary(req, statusOK, 0, nil, "", nil)
+    case opVersion:
+        s.writeBinary(req, statusOK, 0, nil, "", []byte(memcacheVersion))
+    case opQuit, opQuitQ:
+        if !quiet {
+            s.writeBinary(req, statusOK, 0, nil, "", nil)
+        }
+        s.quit = true
+    case opSASLList:
+        s.writeBinary(req, statusOK, 0, nil, "", []byte("PLAIN"))
+    case opSASLAuth:
+        s.saslAuth(req)
+    default:
+        s.writeBinaryStatus(req, statusUnknownCommand, "Unknown command")
+    }
+}
+
+// writeGetResponse answers the get family; quiet variants stay silent on a miss
+func (s *memcacheSession) writeGetResponse(req *binaryRequest, item lrucache.Item, found, withKey, quiet bool) {
+    if !found {
+        if !quiet {
+            if withKey {
+                s.writeBinary(req, statusKeyNotFound, 0, nil, req.key, nil)
+            } else {
+                s.writeBinaryStatus(req, statusKeyNotFound, "Not found")
+            }
+        }
+        return
+    }
+    extras := make([]byte, 4)
+    binary.BigEndian.PutUint32(extras, item.Flags)
+    key := ""
+    if withKey {
+        key = req.key
+    }
+    s.writeBinary(req, statusOK, item.CAS, extras, key, []byte(item.Value))
+}
+
+// storeBinary implements set, add and replace, honouring a non-zero request CAS
+func (s *memcacheSession) storeBinary(req *binaryRequest, quiet bool) {
+    if len(req.extras) != 8 || !validMemcacheKey(req.key) {
+        s.writeBinaryStatus(req, statusInvalidArgs, "Invalid arguments")
+        return
+    }
+    if len(req.value) > s.maxValueBytes {
+        s.writeBinaryStatus(req, statusValueTooLarge, "Too large.")
+        return
+    }
+    if !s.binaryAllowed(req, RoleWrite) {
+        return
+    }
+    flags := binary.BigEndian.Uint32(req.extras[0:4])
+    expiration, expired := memcacheExpiration(int64(int32(binary.BigEndian.Uint32(req.extras[4:8]))))
+    value := string(req.value)
+
+    var cas uint64
+    var err error
+    mode := lrucache.StoreAlways
+    switch req.opcode {
+    case opAdd, opAddQ:
+        mode = lrucache.StoreIfAbsent
+    case opReplace, opReplaceQ:
+        mode = lrucache.StoreIfPresent
+    }
+    if req.cas != 0 && mode != lrucache.StoreIfAbsent {
+        cas, err = s.cache.CompareAndSwap(req.key, value, flags, expiration, req.cas)
+    } else {
+        cas, err = s.cache.Store(req.key, value, flags, expiration, mode)
+    }
+    switch {
+    case errors.Is(err, lrucache.ErrCASNotFound):
+        s.writeBinaryStatus(req, statusKeyNotFound, "Not found")
+        return
+    case errors.Is(err, lrucache.ErrCASMismatch):
+        s.writeBinaryStatus(req, statusKeyExists, "Data exists for key.")
+        return
+    case errors.Is(err, lrucache.ErrNotStored) && mode == lrucache.StoreIfAbsent:
+        s.writeBinaryStatus(req, statusKeyExists, "Data exists for key.")
+        return
+    case errors.Is(err, lrucache.ErrNotStored):
+        s.writeBinaryStatus(req, statusKeyNotFound, "Not found")
+        return
+    case err != nil:
+        s.writeBinaryCacheError(req, err)
+        return
+    }
+    if expired {
+        s.cache.Delete(req.key)
+    }
+    audit(s.caller(), "set", req.key)
+    if !quiet {
+        s.writeBinary(req, statusOK, cas, nil, "", nil)
+    }
+}
+
+// saslAuth handles SASL PLAIN authentication ("authzid\x00user\x00token")
+func (s *memcacheSession) saslAuth(req *binaryRequest) {
+    if req.key != "PLAIN" {
+        s.writeBinaryStatus(req, statusAuthError, "Auth failure.")
+        return
+    }
+    parts := strings.Split(string(req.value), "\x00")
+    p, err := s.auth.AuthenticateToken(parts[len(parts)-1])
+    if err != nil {
+        s.writeBinaryStatus(req, statusAuthError, "Auth failure.")
+        return
+    }
+    s.principal = p
+    s.writeBinary(req, statusOK, 0, nil, "", []byte("Authenticated"))
+}
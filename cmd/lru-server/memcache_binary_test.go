@@ -0,0 +1,189 @@
+package main
+
+import (
+    "bufio"
+    "encoding/binary"
+    "io"
+    "net"
+    "testing"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// memcachePipe serves one memcached session backed by cache over an
+// in-memory connection and returns the client's end
+func memcachePipe(t *testing.T, cache *lrucache.LRUCache) net.Conn {
+    t.Helper()
+    srv := newMemcacheServer(&Config{MaxValueBytes: 64}, cache, "", NewAuthenticator(nil, nil, nil, false))
+    client, server := net.Pipe()
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        srv.handle(server, func() bool { return false })
+        server.Close()
+    }()
+    t.Cleanup(func() {
+        client.Close()
+        <-done
+    })
+    return client
+}
+
+// binaryResponse is the part of a binary response the tests check
+type binaryResponse struct {
+    status uint16
+    cas    uint64
+    value  string
+}
+
+// binaryCall sends one binary request and reads its response
+func binaryCall(t *testing.T, conn net.Conn, opcode byte, key string, extras []byte, value string, cas uint64) binaryResponse {
+    t.Helper()
+    req := make([]byte, memcacheHeaderSize, memcacheHeaderSize+len(extras)+len(key)+len(value))
+    req[0] = memcacheRequestMagic
+    req[1] = opcode
+    binary.BigEndian.PutUint16(req[2:4], uint16(len(key)))
+    req[4] = uint8(len(extras))
+    binary.BigEndian.PutUint32(req[8:12], uint32(len(extras)+len(key)+len(value)))
+    binary.BigEndian.PutUint64(req[16:24], cas)
+    req = append(append(append(req, extras...), key...), value...)
+    if _, err := conn.Write(req); err != nil {
+        t.Fatalf("write: %v", err)
+    }
+    r := bufio.NewReader(conn)
+    hdr, err := readBinaryHeader(r)
+    if err != nil {
+        t.Fatalf("read: %v", err)
+    }
+    if hdr.magic != memcacheResponseMagic || hdr.opcode != opcode {
+        t.Fatalf("response header = %#x %#x, want %#x %#x", hdr.magic, hdr.opcode, memcacheResponseMagic, opcode)
+    }
+    body := make([]byte, hdr.bodyLen)
+    if _, err := io.ReadFull(r, body); err != nil {
+        t.Fatalf("read body: %v", err)
+    }
+    return binaryResponse{status: hdr.status, cas: hdr.cas, value: string(body[int(hdr.extrasLen)+int(hdr.keyLen):])}
+}
+
+// storeExtras encodes the flags and exptime extras of set, add and replace
+func storeExtras(flags, exptime uint32) []byte {
+    extras := make([]byte, 8)
+    binary.BigEndian.PutUint32(extras[0:4], flags)
+    binary.BigEndian.PutUint32(extras[4:8], exptime)
+    return extras
+}
+
+func TestMemcacheBinaryStore(t *testing.T) {
+    cache := lrucache.New(lrucache.WithCapacity(10))
+    conn := memcachePipe(t, cache)
+    set := binaryCall(t, conn, opSet, "k", storeExtras(3, 0), "v1", 0)
+    if set.status != statusOK || set.cas == 0 {
+        t.Fatalf("set = %+v, want OK with a CAS value", set)
+    }
+
+    tests := []struct {
+        name       string
+        opcode     byte
+        key        string
+        cas        uint64
+        wantStatus uint16
+        wantValue  string // Of k afterwards
+    }{
+        {"add present", opAdd, "k", 0, statusKeyExists, "v1"},
+        {"replace missing", opReplace, "missing", 0, statusKeyNotFound, "v1"},
+        {"cas mismatch", opSet, "k", set.cas + 1, statusKeyExists, "v1"},
+        {"cas missing", opSet, "missing", set.cas, statusKeyNotFound, "v1"},
+        {"cas match", opSet, "k", set.cas, statusOK, "v2"},
+        {"replace present", opReplace, "k", 0, statusOK, "v2"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            res := binaryCall(t, conn, tt.opcode, tt.key, storeExtras(0, 0), "v2", tt.cas)
+            if res.status != tt.wantStatus {
+                t.Fatalf("status = %#x, want %#x", res.status, tt.wantStatus)
+            }
+            if value, _ := cache.Get("k"); value != tt.wantValue {
+                t.Fatalf("k = %q, want %q", value, tt.wantValue)
+            }
+        })
+    }
+}
+
+func TestMemcacheBinaryRefusedWrites(t *testing.T) {
+    tests := []struct {
+        name       string
+        cache      *lrucache.LRUCache
+        opcode     byte
+        wantStatus uint16
+    }{
+        {"quota", lrucache.New(lrucache.WithCapacity(10), lrucache.WithQuota(1, 0)), opSet, statusOutOfMemory},
+        {"too large", lrucache.New(lrucache.WithCapacity(10), lrucache.WithMaxValueBytes(4)), opSet, statusValueTooLarge},
+        {"closed set", lrucache.New(lrucache.WithCapacity(10)), opSet, statusTempFailure},
+        {"closed delete", lrucache.New(lrucache.WithCapacity(10)), opDelete, statusTempFailure},
+        {"not admitted", lrucache.New(lrucache.WithCapacity(10), lrucache.WithDoorkeeper(time.Minute)), opSet, statusNotStored},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            tt.cache.Set("existing", "x", 0)
+            if tt.wantStatus == statusTempFailure {
+                tt.cache.Close()
+            }
+            conn := memcachePipe(t, tt.cache)
+            var extras []byte
+            if tt.opcode == opSet {
+                extras = storeExtras(0, 0)
+            }
+            key := "new"
+            if tt.opcode == opDelete {
+                key = "existing"
+            }
+            if res := binaryCall(t, conn, tt.opcode, key, extras, "value", 0); res.status != tt.wantStatus {
+                t.Fatalf("status = %#x %q, want %#x", res.status, res.value, tt.wantStatus)
+            }
+        })
+    }
+}
+
+func TestMemcacheBinaryDelete(t *testing.T) {
+    cache := lrucache.New(lrucache.WithCapacity(10))
+    cache.Set("k", "v", 0)
+    item, _ := cache.GetItem("k")
+    conn := memcachePipe(t, cache)
+
+    tests := []struct {
+        name       string
+        cas        uint64
+        wantStatus uint16
+    }{
+        {"cas mismatch", item.CAS + 1, statusKeyExists},
+        {"cas match", item.CAS, statusOK},
+        {"missing", 0, statusKeyNotFound},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if res := binaryCall(t, conn, opDelete, "k", nil, "", tt.cas); res.status != tt.wantStatus {
+                t.Fatalf("status = %#x, want %#x", res.status, tt.wantStatus)
+            }
+        })
+    }
+}
+
+func TestMemcacheBinaryGetAndMalformed(t *testing.T) {
+    cache := lrucache.New(lrucache.WithCapacity(10))
+    cache.Store("k", "v", 9, 0, lrucache.StoreAlways)
+    conn := memcachePipe(t, cache)
+
+    if res := binaryCall(t, conn, opGetK, "k", nil, "", 0); res.status != statusOK || res.value != "v" {
+        t.Fatalf("getk = %+v, want OK with v", res)
+    }
+    if res := binaryCall(t, conn, opGet, "missing", nil, "", 0); res.status != statusKeyNotFound {
+        t.Fatalf("get missing = %#x, want not found", res.status)
+    }
+    if res := binaryCall(t, conn, opSet, "k", []byte{1}, "v", 0); res.status != statusInvalidArgs {
+        t.Fatalf("set with short extras = %#x, want invalid arguments", res.status)
+    }
+    if res := binaryCall(t, conn, 0x7f, "", nil, "", 0); res.status != statusUnknownCommand {
+        t.Fatalf("unknown opcode = %#x, want unknown command", res.status)
+    }
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+    "bufio"
+    "net"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// textCall sends one ASCII command and reads the lines of its reply, up to
+// and including the first line that is not VALUE or a value
+func textCall(t *testing.T, conn net.Conn, r *bufio.Reader, command string) string {
+    t.Helper()
+    if _, err := conn.Write([]byte(command + "\r\n")); err != nil {
+        t.Fatalf("write: %v", err)
+    }
+    var lines []string
+    for {
+        line, err := r.ReadString('\n')
+        if err != nil {
+            t.Fatalf("read: %v", err)
+        }
+        line = strings.TrimSuffix(line, "\r\n")
+        lines = append(lines, line)
+        if strings.HasPrefix(line, "VALUE ") {
+            value, err := r.ReadString('\n')
+            if err != nil {
+                t.Fatalf("read value: %v", err)
+            }
+            lines = append(lines, strings.TrimSuffix(value, "\r\n"))
+            continue
+        }
+        return strings.Join(lines, "|")
+    }
+}
+
+func TestMemcacheText(t *testing.T) {
+    cache := lrucache.New(lrucache.WithCapacity(10))
+    conn := memcachePipe(t, cache)
+    r := bufio.NewReader(conn)
+    textCall(t, conn, r, "set k 5 0 2\r\nv1")
+    item, _ := cache.GetItem("k")
+
+    tests := []struct {
+        command string
+        want    string
+    }{
+        {"get k", "VALUE k 5 2|v1|END"},
+        {"get missing k", "VALUE k 5 2|v1|END"},
+        {"add k 0 0 1\r\nx", "NOT_STORED"},
+        {"replace missing 0 0 1\r\nx", "NOT_STORED"},
+        {"cas k 0 0 1 " + strconv.FormatUint(item.CAS+1, 10) + "\r\nx", "EXISTS"},
+        {"cas missing 0 0 1 1\r\nx", "NOT_FOUND"},
+        {"cas k 0 0 2 " + strconv.FormatUint(item.CAS, 10) + "\r\nv2", "STORED"},
+        {"get k", "VALUE k 0 2|v2|END"},
+        {"set k x 0 1", "CLIENT_ERROR bad command line format"},
+        {"set k 0 0 100\r\n" + strings.Repeat("x", 100), "SERVER_ERROR object too large for cache"},
+        {"touch k 100", "TOUCHED"},
+        {"delete k", "DELETED"},
+        {"delete k", "NOT_FOUND"},
+        {"bogus", "ERROR"},
+    }
+    for _, tt := range tests {
+        t.Run(strings.Fields(tt.command)[0], func(t *testing.T) {
+            if got := textCall(t, conn, r, tt.command); got != tt.want {
+                t.Fatalf("%q = %q, want %q", tt.command, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestMemcacheTextRefusedWrites(t *testing.T) {
+    tests := []struct {
+        name    string
+        cache   *lrucache.LRUCache
+        command string
+        want    string
+    }{
+        {"quota", lrucache.New(lrucache.WithCapacity(10), lrucache.WithQuota(1, 0)), "set new 0 0 1\r\nx", "SERVER_ERROR out of memory storing object"},
+        {"too large", lrucache.New(lrucache.WithCapacity(10), lrucache.WithMaxValueBytes(4)), "set new 0 0 5\r\nvalue", "SERVER_ERROR object too large for cache"},
+        {"closed set", lrucache.New(lrucache.WithCapacity(10)), "set new 0 0 1\r\nx", "SERVER_ERROR " + lrucache.ErrClosed.Error()},
+        {"closed cas", lrucache.New(lrucache.WithCapacity(10)), "cas existing 0 0 1 1\r\nx", "SERVER_ERROR " + lrucache.ErrClosed.Error()},
+        {"not admitted", lrucache.New(lrucache.WithCapacity(10), lrucache.WithDoorkeeper(time.Minute)), "set new 0 0 1\r\nx", "NOT_STORED"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            tt.cache.Set("existing", "x", 0)
+            if strings.HasPrefix(tt.name, "closed") {
+                tt.cache.Close()
+            }
+            conn := memcachePipe(t, tt.cache)
+            if got := textCall(t, conn, bufio.NewReader(conn), tt.command); got != tt.want {
+                t.Fatalf("%q = %q, want %q", tt.command, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestMemcacheExpiration(t *testing.T) {
+    tests := []struct {
+        exptime     int64
+        wantMin     time.Duration
+        wantMax     time.Duration
+        wantExpired bool
+    }{
+        {0, 0, 0, false},
+        {-1, 0, 0, true},
+        {60, time.Minute, time.Minute, false},
+        {time.Now().Add(time.Hour).Unix(), 59 * time.Minute, time.Hour, false},
+        {time.Now().Add(-time.Hour).Unix(), -2 * time.Hour, 0, true},
+    }
+    for _, tt := range tests {
+        got, expired := memcacheExpiration(tt.exptime)
+        if got < tt.wantMin || got > tt.wantMax || expired != tt.wantExpired {
+            t.Errorf("memcacheExpiration(%d) = %v, %v; want %v to %v, %v", tt.exptime, got, expired, tt.wantMin, tt.wantMax, tt.wantExpired)
+        }
+    }
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+    "math"
+    "os"
+    "runtime/debug"
+    "runtime/metrics"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// MemoryStats compares the cache's own estimate with what the process uses
+type MemoryStats struct {
+    CacheBytes   int64 `json:"cache_bytes"`   // Keys, values and per-entry overhead of every namespace
+    ProcessBytes int64 `json:"process_bytes"` // Memory mapped by the Go runtime
+    LimitBytes   int64 `json:"limit_bytes"`   // Container or GOMEMLIMIT limit; zero when unlimited
+}
+
+// memoryStats reports the current memory estimates
+func memoryStats(namespaces *Namespaces) MemoryStats {
+    var cacheBytes int64
+    for _, ns := range namespaceStats(namespaces) {
+        cacheBytes += ns.Bytes
+    }
+    return MemoryStats{
+        CacheBytes:   cacheBytes,
+        ProcessBytes: processMemory(),
+        LimitBytes:   memoryLimit(),
+    }
+}
+
+// processMemory is the memory mapped by the Go runtime
+func processMemory() int64 {
+    sample := []metrics.Sample{{Name: "/memory/classes/total:bytes"}}
+    metrics.Read(sample)
+    if sample[0].Value.Kind() != metrics.KindUint64 {
+        return 0
+    }
+    return int64(sample[0].Value.Uint64())
+}
+
+// memoryLimit is the lower of GOMEMLIMIT and the cgroup memory limit, or zero
+func memoryLimit() int64 {
+    limit := cgroupMemoryLimit()
+    if soft := debug.SetMemoryLimit(-1); soft != math.MaxInt64 && (limit == 0 || soft < limit) {
+        limit = soft
+    }
+    return limit
+}
+
+// cgroupMemoryLimit reads the cgroup v2 or v1 memory limit once
+var cgroupMemoryLimit = sync.OnceValue(func() int64 {
+    for _, path := range []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+        data, err := os.ReadFile(path)
+        if err != nil {
+            continue
+        }
+        // cgroup v2 writes "max" and v1 a huge page-aligned number when unlimited
+        n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+        if err != nil || n >= 1<<62 {
+            return 0
+        }
+        return n
+    }
+    return 0
+})
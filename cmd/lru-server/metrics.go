@@ -0,0 +1,224 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency histogram
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// requestKey labels a request counter
+type requestKey struct {
+    method, route, code string
+}
+
+// routeKey labels a latency histogram
+type routeKey struct {
+    method, route string
+}
+
+// histogram is a cumulative Prometheus histogram
+type histogram struct {
+    counts []uint64 // Per bucket, not cumulative; the last slot is +Inf
+    sum    float64
+    count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+    i := sort.SearchFloat64s(latencyBuckets, v)
+    h.counts[i]++
+    h.sum += v
+    h.count++
+}
+
+// HTTPMetrics counts requests and their latency per route
+type HTTPMetrics struct {
+    mutex     sync.Mutex
+    requests  map[requestKey]uint64
+    durations map[routeKey]*histogram
+}
+
+// NewHTTPMetrics creates an empty set of HTTP metrics
+func NewHTTPMetrics() *HTTPMetrics {
+    return &HTTPMetrics{
+        requests:  make(map[requestKey]uint64),
+        durations: make(map[routeKey]*histogram),
+    }
+}
+
+// Middleware records every request under the route pattern mux matched, so
+// that labels stay bounded no matter which keys are requested
+func (m *HTTPMetrics) Middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        rec := &statusRecorder{ResponseWriter: w}
+        next.ServeHTTP(rec, r)
+        if rec.status == 0 {
+            rec.status = http.StatusOK
+        }
+
+        route := "unmatched"
+        if _, pattern := mux.Handler(r); pattern != "" && pattern != "/" {
+            _, route, _ = strings.Cut(pattern, " ")
+        }
+        m.observe(metricMethod(r.Method), route, rec.status, time.Since(start))
+    })
+}
+
+// metricMethod is method if it is one the API serves, and otherwise "other",
+// since clients may send any token as a method
+func metricMethod(method string) string {
+    switch method {
+    case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+        return method
+    }
+    return "other"
+}
+
+func (m *HTTPMetrics) observe(method, route string, status int, d time.Duration) {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+
+    m.requests[requestKey{method, route, strconv.Itoa(status)}]++
+    key := routeKey{method, route}
+    h, ok := m.durations[key]
+    if !ok {
+        h = &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+        m.durations[key] = h
+    }
+    h.observe(d.Seconds())
+}
+
+// snapshot copies the request counters and latency histograms
+func (m *HTTPMetrics) snapshot() (map[requestKey]uint64, map[routeKey]histogram) {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+
+    requests := make(map[requestKey]uint64, len(m.requests))
+    for k, v := range m.requests {
+        requests[k] = v
+    }
+    durations := make(map[routeKey]histogram, len(m.durations))
+    for k, h := range m.durations {
+        durations[k] = histogram{sum: h.sum, count: h.count}
+    }
+    return requests, durations
+}
+
+// metricsHandler serves cache and HTTP metrics in the Prometheus text format
+func metricsHandler(namespaces *Namespaces, m *HTTPMetrics) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+        writeCacheMetrics(w, namespaceStats(namespaces))
+        writeMemoryMetrics(w, memoryStats(namespaces))
+        m.write(w)
+    }
+}
+
+// defaultNamespace is the namespace of the process-wide cache
+const defaultNamespace = "default"
+
+// NamespaceStats are the counters and gauges of one namespace
+type NamespaceStats struct {
+    Name string
+    lrucache.CacheStats
+}
+
+// namespaceStats returns the stats of every namespace, ordered by name
+func namespaceStats(namespaces *Namespaces) []NamespaceStats {
+    stats := append([]NamespaceStats{{defaultNamespace, namespaces.def.Stats()}}, namespaces.Stats()...)
+    sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+    return stats
+}
+
+// writeCacheMetrics labels each cache metric by namespace
+func writeCacheMetrics(w io.Writer, stats []NamespaceStats) {
+    metric := func(name, typ, help string, value func(lrucache.CacheStats) interface{}) {
+        fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+        for _, ns := range stats {
+            fmt.Fprintf(w, "%s{namespace=%q} %v\n", name, ns.Name, value(ns.CacheStats))
+        }
+    }
+    metric("lru_cache_hits_total", "counter", "Reads that found a live entry.", func(s lrucache.CacheStats) interface{} { return s.Hits })
+    metric("lru_cache_misses_total", "counter", "Reads of missing or expired keys.", func(s lrucache.CacheStats) interface{} { return s.Misses })
+    metric("lru_cache_evictions_total", "counter", "Entries evicted to make room.", func(s lrucache.CacheStats) interface{} { return s.Evictions })
+    metric("lru_cache_expirations_total", "counter", "Entries removed after their expiration passed.", func(s lrucache.CacheStats) interface{} { return s.Expirations })
+    metric("lru_cache_stale_hits_total", "counter", "Hits served past the entry's soft TTL.", func(s lrucache.CacheStats) interface{} { return s.StaleHits })
+    metric("lru_cache_refreshes_total", "counter", "Stale entries reloaded in the background.", func(s lrucache.CacheStats) interface{} { return s.Refreshes })
+    metric("lru_cache_rejections_total", "counter", "First writes of new keys the doorkeeper kept out of the cache.", func(s lrucache.CacheStats) interface{} { return s.Rejections })
+    metric("lru_cache_coalesced_total", "counter", "Misses that waited for a load of the same key already in progress.", func(s lrucache.CacheStats) interface{} { return s.Coalesced })
+    metric("lru_cache_corruptions_total", "counter", "Values that failed their checksum on a read or snapshot load.", func(s lrucache.CacheStats) interface{} { return s.Corruptions })
+    metric("lru_cache_quota_rejections_total", "counter", "Writes refused for exceeding the namespace's quota.", func(s lrucache.CacheStats) interface{} { return s.QuotaRejections })
+    metric("lru_cache_entries", "gauge", "Entries currently stored, including expired ones not yet removed.", func(s lrucache.CacheStats) interface{} { return s.Entries })
+    metric("lru_cache_capacity", "gauge", "Maximum number of entries.", func(s lrucache.CacheStats) interface{} { return s.Capacity })
+    metric("lru_cache_memory_bytes", "gauge", "Estimated memory used by keys, values and per-entry overhead.", func(s lrucache.CacheStats) interface{} { return s.Bytes })
+    metric("lru_cache_compressed_entries", "gauge", "Entries whose value is stored compressed.", func(s lrucache.CacheStats) interface{} { return s.Compressed })
+    metric("lru_cache_compressed_bytes", "gauge", "Stored size of the compressed values.", func(s lrucache.CacheStats) interface{} { return s.CompressedBytes })
+    metric("lru_cache_uncompressed_bytes", "gauge", "Size of the compressed values before compression.", func(s lrucache.CacheStats) interface{} { return s.UncompressedBytes })
+    metric("lru_cache_compression_ratio", "gauge", "Size of the compressed values before compression divided by their stored size; 1 with none.", func(s lrucache.CacheStats) interface{} { return s.CompressionRatio() })
+}
+
+func writeMemoryMetrics(w io.Writer, m MemoryStats) {
+    fmt.Fprintf(w, "# HELP lru_process_memory_bytes Memory mapped by the Go runtime.\n# TYPE lru_process_memory_bytes gauge\nlru_process_memory_bytes %d\n", m.ProcessBytes)
+    if m.LimitBytes > 0 {
+        fmt.Fprintf(w, "# HELP lru_memory_limit_bytes Container memory limit or GOMEMLIMIT, whichever is lower.\n# TYPE lru_memory_limit_bytes gauge\nlru_memory_limit_bytes %d\n", m.LimitBytes)
+    }
+}
+
+// write emits the request counters and latency histograms in a stable order
+func (m *HTTPMetrics) write(w io.Writer) {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+
+    fmt.Fprint(w, "# HELP lru_http_requests_total HTTP requests by method, route and status code.\n# TYPE lru_http_requests_total counter\n")
+    keys := make([]requestKey, 0, len(m.requests))
+    for k := range m.requests {
+        keys = append(keys, k)
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        a, b := keys[i], keys[j]
+        if a.route != b.route {
+            return a.route < b.route
+        }
+        if a.method != b.method {
+            return a.method < b.method
+        }
+        return a.code < b.code
+    })
+    for _, k := range keys {
+        fmt.Fprintf(w, "lru_http_requests_total{method=%q,route=%q,code=%q} %d\n", k.method, k.route, k.code, m.requests[k])
+    }
+
+    fmt.Fprint(w, "# HELP lru_http_request_duration_seconds HTTP request latency by method and route.\n# TYPE lru_http_request_duration_seconds histogram\n")
+    routes := make([]routeKey, 0, len(m.durations))
+    for k := range m.durations {
+        routes = append(routes, k)
+    }
+    sort.Slice(routes, func(i, j int) bool {
+        if routes[i].route != routes[j].route {
+            return routes[i].route < routes[j].route
+        }
+        return routes[i].method < routes[j].method
+    })
+    for _, k := range routes {
+        h := m.durations[k]
+        labels := fmt.Sprintf("method=%q,route=%q", k.method, k.route)
+        var cumulative uint64
+        for i, bound := range latencyBuckets {
+            cumulative += h.counts[i]
+            fmt.Fprintf(w, "lru_http_request_duration_seconds_bucket{%s,le=%q} %d\n", labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+        }
+        fmt.Fprintf(w, "lru_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+        fmt.Fprintf(w, "lru_http_request_duration_seconds_sum{%s} %g\n", labels, h.sum)
+        fmt.Fprintf(w, "lru_http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+    }
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestMetricsBoundMethods(t *testing.T) {
+    m := NewHTTPMetrics()
+    mux := http.NewServeMux()
+    handler := m.Middleware(mux, mux)
+    for _, method := range []string{http.MethodGet, "FOO", "BAR", "get"} {
+        handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/", nil))
+    }
+
+    requests, _ := m.snapshot()
+    methods := make(map[string]uint64)
+    for key, n := range requests {
+        methods[key.method] += n
+    }
+    if len(methods) != 2 || methods[http.MethodGet] != 1 || methods["other"] != 3 {
+        t.Fatalf("requests by method = %v, want GET 1 and other 3", methods)
+    }
+}
@@ -0,0 +1,684 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "log/slog"
+    "net/http"
+    "os"
+    "regexp"
+    "slices"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "lru-cache/lrucache"
+)
+
+// NamespaceConfig describes a namespace: a cache of its own beside the
+// default one, so that one application filling it evicts none of another's
+// keys, with the expirations and value sizes that suit its data
+type NamespaceConfig struct {
+    Name          string
+    Capacity      int
+    DefaultTTL    time.Duration
+    MaxTTL        time.Duration // Zero leaves expirations uncapped
+    Policy        lrucache.EvictionPolicy
+    MaxValueBytes int   // Zero takes -max-value-bytes
+    QuotaEntries  int   // Keys stored at most before new ones are refused; zero is unlimited
+    QuotaBytes    int64 // Memory values may use before growing writes are refused; zero is unlimited
+
+    pinned bool // Given with -namespace, so not written to -namespaces-file
+}
+
+var namespaceName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// reservedNamespaces are taken by the fixed routes under /cache/
+var reservedNamespaces = []string{defaultNamespace, "events", "heatmap", "purge", "txn", "watch", "ws"}
+
+// newNamespaceConfig returns the settings of a namespace name before any are
+// given: -capacity entries, no TTL and lru
+func newNamespaceConfig(name string, cfg *Config) (NamespaceConfig, error) {
+    ns := NamespaceConfig{Name: name, Capacity: cfg.Capacity}
+    if !namespaceName.MatchString(name) || slices.Contains(reservedNamespaces, name) {
+        return ns, fmt.Errorf("namespace %q: names are up to 64 lowercase letters, digits, - and _, other than %s", name, strings.Join(reservedNamespaces, ", "))
+    }
+    return ns, nil
+}
+
+// set changes the setting key to value, which is written as in a -namespace
+// flag
+func (ns *NamespaceConfig) set(key, value string) error {
+    var err error
+    switch key {
+    case "capacity":
+        if ns.Capacity, err = strconv.Atoi(value); err == nil && ns.Capacity <= 0 {
+            err = errors.New("must be positive")
+        }
+    case "ttl", "default_ttl":
+        if ns.DefaultTTL, err = time.ParseDuration(value); err == nil && ns.DefaultTTL < 0 {
+            err = errors.New("must not be negative")
+        }
+    case "max_ttl":
+        if ns.MaxTTL, err = time.ParseDuration(value); err == nil && ns.MaxTTL < 0 {
+            err = errors.New("must not be negative")
+        }
+    case "policy":
+        ns.Policy, err = lrucache.ParseEvictionPolicy(value)
+    case "max_value_bytes":
+        if ns.MaxValueBytes, err = strconv.Atoi(value); err == nil && ns.MaxValueBytes < 0 {
+            err = errors.New("must not be negative")
+        }
+    case "quota_entries":
+        if ns.QuotaEntries, err = strconv.Atoi(value); err == nil && ns.QuotaEntries < 0 {
+            err = errors.New("must not be negative")
+        }
+    case "quota_bytes":
+        if ns.QuotaBytes, err = strconv.ParseInt(value, 10, 64); err == nil && ns.QuotaBytes < 0 {
+            err = errors.New("must not be negative")
+        }
+    default:
+        err = errors.New("unknown setting; use capacity, default_ttl, max_ttl, policy, max_value_bytes, quota_entries or quota_bytes")
+    }
+    if err != nil {
+        return fmt.Errorf("namespace %s: %s: %w", ns.Name, key, err)
+    }
+    return nil
+}
+
+// check reports settings that contradict each other or cfg
+func (ns *NamespaceConfig) check(cfg *Config) error {
+    if ns.MaxTTL > 0 && ns.DefaultTTL > ns.MaxTTL {
+        return fmt.Errorf("namespace %s: default_ttl %s exceeds max_ttl %s", ns.Name, ns.DefaultTTL, ns.MaxTTL)
+    }
+    if ns.MaxValueBytes > cfg.MaxValueBytes {
+        // Requests are read no further than -max-value-bytes, whatever the namespace
+        return fmt.Errorf("namespace %s: max_value_bytes %d exceeds -max-value-bytes %d", ns.Name, ns.MaxValueBytes, cfg.MaxValueBytes)
+    }
+    return nil
+}
+
+// parseNamespace parses a -namespace flag, name[:setting=value,...] with the
+// settings of set
+func parseNamespace(s string, cfg *Config) (NamespaceConfig, error) {
+    name, settings, _ := strings.Cut(s, ":")
+    ns, err := newNamespaceConfig(name, cfg)
+    if err != nil {
+        return ns, err
+    }
+    for _, setting := range splitList(settings) {
+        key, value, _ := strings.Cut(setting, "=")
+        if err := ns.set(key, value); err != nil {
+            return ns, err
+        }
+    }
+    return ns, ns.check(cfg)
+}
+
+// NamespaceSettings are the settings of a namespace as -namespaces-file and
+// the admin API write them; zero values take the defaults
+type NamespaceSettings struct {
+    Capacity      int    `json:"capacity,omitempty"`
+    DefaultTTL    string `json:"default_ttl,omitempty"`
+    MaxTTL        string `json:"max_ttl,omitempty"`
+    Policy        string `json:"policy,omitempty"`
+    MaxValueBytes int    `json:"max_value_bytes,omitempty"`
+    QuotaEntries  int    `json:"quota_entries,omitempty"`
+    QuotaBytes    int64  `json:"quota_bytes,omitempty"`
+}
+
+// config returns the configuration of a namespace name with settings s
+func (s NamespaceSettings) config(name string, cfg *Config) (NamespaceConfig, error) {
+    ns, err := newNamespaceConfig(name, cfg)
+    set := func(key, value string) {
+        if err == nil && value != "" && value != "0" {
+            err = ns.set(key, value)
+        }
+    }
+    set("capacity", strconv.Itoa(s.Capacity))
+    set("default_ttl", s.DefaultTTL)
+    set("max_ttl", s.MaxTTL)
+    set("policy", s.Policy)
+    set("max_value_bytes", strconv.Itoa(s.MaxValueBytes))
+    set("quota_entries", strconv.Itoa(s.QuotaEntries))
+    set("quota_bytes", strconv.FormatInt(s.QuotaBytes, 10))
+    if err != nil {
+        return ns, err
+    }
+    return ns, ns.check(cfg)
+}
+
+// settings returns the settings ns was configured with
+func (ns NamespaceConfig) settings() NamespaceSettings {
+    s := NamespaceSettings{
+        Capacity:      ns.Capacity,
+        Policy:        ns.Policy.String(),
+        MaxValueBytes: ns.MaxValueBytes,
+        QuotaEntries:  ns.QuotaEntries,
+        QuotaBytes:    ns.QuotaBytes,
+    }
+    if ns.DefaultTTL > 0 {
+        s.DefaultTTL = ns.DefaultTTL.String()
+    }
+    if ns.MaxTTL > 0 {
+        s.MaxTTL = ns.MaxTTL.String()
+    }
+    return s
+}
+
+// loadNamespaces reads a -namespaces-file: a JSON object from each name to
+// its NamespaceSettings, as in
+//
+//	{"sessions": {"capacity": 100000, "default_ttl": "30m", "max_ttl": "24h"},
+//	 "fragments": {"policy": "fifo", "max_value_bytes": 65536, "quota_bytes": 268435456}}
+//
+// A missing file holds no namespaces, since the admin API creates it.
+func loadNamespaces(path string, cfg *Config) ([]NamespaceConfig, error) {
+    data, err := os.ReadFile(path)
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var file map[string]NamespaceSettings
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&file); err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    configs := make([]NamespaceConfig, 0, len(file))
+    for name, settings := range file {
+        ns, err := settings.config(name, cfg)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", path, err)
+        }
+        configs = append(configs, ns)
+    }
+    slices.SortFunc(configs, func(a, b NamespaceConfig) int { return strings.Compare(a.Name, b.Name) })
+    return configs, nil
+}
+
+var (
+    errNamespaceExists  = errors.New("namespace exists")
+    errNoNamespace      = errors.New("no such namespace")
+    errDefaultNamespace = errors.New("the default namespace cannot be deleted")
+)
+
+// Namespaces holds the named caches; the default namespace is the cache the
+// rest of the API serves. Those created by the admin API or read from
+// -namespaces-file are written back to it whenever they change.
+type Namespaces struct {
+    def  *lrucache.LRUCache
+    cfg  *Config
+    opts []lrucache.Option
+
+    mutex           sync.RWMutex
+    caches          map[string]*lrucache.LRUCache
+    configs         map[string]NamespaceConfig
+    janitorInterval time.Duration
+}
+
+// NewNamespaces creates a cache for every namespace of cfg, each built with
+// opts, which settings it leaves to every namespace, plus its own
+func NewNamespaces(def *lrucache.LRUCache, cfg *Config, opts []lrucache.Option) *Namespaces {
+    n := &Namespaces{def: def, cfg: cfg, opts: opts, caches: make(map[string]*lrucache.LRUCache), configs: make(map[string]NamespaceConfig), janitorInterval: cfg.JanitorInterval}
+    for _, ns := range cfg.Namespaces {
+        n.caches[ns.Name], n.configs[ns.Name] = n.newCache(ns), ns
+    }
+    return n
+}
+
+// newCache creates the cache of ns
+func (n *Namespaces) newCache(ns NamespaceConfig) *lrucache.LRUCache {
+    opts := append(slices.Clone(n.opts),
+        lrucache.WithCapacity(ns.Capacity),
+        lrucache.WithDefaultTTL(ns.DefaultTTL),
+        lrucache.WithMaxTTL(ns.MaxTTL),
+        lrucache.WithEvictionPolicy(ns.Policy),
+        lrucache.WithQuota(ns.QuotaEntries, ns.QuotaBytes),
+        lrucache.WithJanitorInterval(n.janitorInterval),
+        lrucache.WithOnEvent(func(e lrucache.Event) {
+            e.Namespace = ns.Name
+            events.Publish(e)
+        }),
+    )
+    if ns.MaxValueBytes > 0 {
+        opts = append(opts, lrucache.WithMaxValueBytes(ns.MaxValueBytes))
+    }
+    return lrucache.New(opts...)
+}
+
+// Create adds the namespace ns, failing with errNamespaceExists if one has
+// its name
+func (n *Namespaces) Create(ns NamespaceConfig) error {
+    n.mutex.Lock()
+    defer n.mutex.Unlock()
+    if _, found := n.caches[ns.Name]; found {
+        return errNamespaceExists
+    }
+    n.caches[ns.Name], n.configs[ns.Name] = n.newCache(ns), ns
+    return n.save()
+}
+
+// Resize changes the capacity of the namespace name, returning how many
+// entries were evicted
+func (n *Namespaces) Resize(name string, capacity int) (int, error) {
+    if name == defaultNamespace {
+        return n.def.Resize(capacity)
+    }
+    n.mutex.Lock()
+    defer n.mutex.Unlock()
+    cache, found := n.caches[name]
+    if !found {
+        return 0, errNoNamespace
+    }
+    evicted, err := cache.Resize(capacity)
+    if err != nil {
+        return 0, err
+    }
+    ns := n.configs[name]
+    ns.Capacity = capacity
+    n.configs[name] = ns
+    return evicted, n.save()
+}
+
+// Delete removes the namespace name with its entries
+func (n *Namespaces) Delete(name string) error {
+    if name == defaultNamespace {
+        return errDefaultNamespace
+    }
+    n.mutex.Lock()
+    defer n.mutex.Unlock()
+    cache, found := n.caches[name]
+    if !found {
+        return errNoNamespace
+    }
+    delete(n.caches, name)
+    delete(n.configs, name)
+    // Requests still holding the cache fail with ErrClosed
+    cache.Close()
+    return n.save()
+}
+
+// Sync makes the namespaces those of configs, as a reload read them: it
+// creates the new ones, resizes those whose capacity changed and deletes
+// those no longer defined, except ones the admin API created without a
+// -namespaces-file to write them to. The other settings of a namespace only
+// change when it is deleted and created again. It returns what it changed.
+func (n *Namespaces) Sync(configs []NamespaceConfig) []string {
+    n.mutex.Lock()
+    defer n.mutex.Unlock()
+    var changes []string
+    defined := make(map[string]bool, len(configs))
+    for _, ns := range configs {
+        defined[ns.Name] = true
+        cache, found := n.caches[ns.Name]
+        if !found {
+            n.caches[ns.Name], n.configs[ns.Name] = n.newCache(ns), ns
+            changes = append(changes, "namespace "+ns.Name+" created")
+            continue
+        }
+        running := n.configs[ns.Name]
+        running.pinned = ns.pinned
+        if ns.Capacity != running.Capacity {
+            if _, err := cache.Resize(ns.Capacity); err != nil {
+                slog.Warn("reload: cannot resize namespace", "namespace", ns.Name, "err", err)
+            } else {
+                running.Capacity = ns.Capacity
+                changes = append(changes, "namespace "+ns.Name+" resized")
+            }
+        }
+        if ns.settings() != running.settings() {
+            slog.Warn("reload: only the capacity of an existing namespace changes; delete and create it again to apply its other settings", "namespace", ns.Name)
+        }
+        n.configs[ns.Name] = running
+    }
+    for name, ns := range n.configs {
+        if !defined[name] && (ns.pinned || n.cfg.NamespacesFile != "") {
+            n.caches[name].Close()
+            delete(n.caches, name)
+            delete(n.configs, name)
+            changes = append(changes, "namespace "+name+" deleted")
+        }
+    }
+    slices.Sort(changes)
+    return changes
+}
+
+// SetJanitorInterval changes how often every namespace, and those created
+// later, remove expired entries
+func (n *Namespaces) SetJanitorInterval(interval time.Duration) {
+    n.mutex.Lock()
+    defer n.mutex.Unlock()
+    n.janitorInterval = interval
+    for _, cache := range n.caches {
+        cache.SetJanitorInterval(interval)
+    }
+}
+
+// save writes the namespaces not given with -namespace to -namespaces-file,
+// if set; the caller holds the mutex
+func (n *Namespaces) save() error {
+    if n.cfg.NamespacesFile == "" {
+        return nil
+    }
+    file := make(map[string]NamespaceSettings)
+    for name, ns := range n.configs {
+        if !ns.pinned {
+            file[name] = ns.settings()
+        }
+    }
+    data, err := json.MarshalIndent(file, "", "  ")
+    if err != nil {
+        return err
+    }
+    // Write a copy and rename it over the file so that a crash leaves one whole
+    tmp := n.cfg.NamespacesFile + ".tmp"
+    if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, n.cfg.NamespacesFile)
+}
+
+// List describes every namespace, ordered by name
+func (n *Namespaces) List() []NamespaceInfo {
+    stats := n.def.Stats()
+    list := []NamespaceInfo{{
+        Name:     defaultNamespace,
+        Settings: NamespaceSettings{Capacity: stats.Capacity, Policy: lrucache.EvictLRU.String()},
+        Entries:  stats.Entries,
+        Bytes:    stats.Bytes,
+    }}
+    n.mutex.RLock()
+    for name, cache := range n.caches {
+        ns, stats := n.configs[name], cache.Stats()
+        ns.Capacity = stats.Capacity
+        list = append(list, NamespaceInfo{Name: name, Settings: ns.settings(), Entries: stats.Entries, Bytes: stats.Bytes, Persisted: !ns.pinned && n.cfg.NamespacesFile != ""})
+    }
+    n.mutex.RUnlock()
+    slices.SortFunc(list, func(a, b NamespaceInfo) int { return strings.Compare(a.Name, b.Name) })
+    return list
+}
+
+// Get returns the cache of the namespace name, or nil if there is none
+func (n *Namespaces) Get(name string) *lrucache.LRUCache {
+    if name == defaultNamespace {
+        return n.def
+    }
+    n.mutex.RLock()
+    defer n.mutex.RUnlock()
+    return n.caches[name]
+}
+
+// Stats returns the stats of every namespace but the default one
+func (n *Namespaces) Stats() []NamespaceStats {
+    n.mutex.RLock()
+    defer n.mutex.RUnlock()
+    stats := make([]NamespaceStats, 0, len(n.caches))
+    for name, cache := range n.caches {
+        stats = append(stats, NamespaceStats{name, cache.Stats()})
+    }
+    return stats
+}
+
+var namespaceParam = param{Name: "namespace", In: "path", Description: "Namespace configured with -namespace, or default", Required: true}
+
+// namespaceRoutes lists the endpoints reading and writing the keys of a
+// namespace, which mirror the legacy /cache API
+func namespaceRoutes(h *handlers) []route {
+    return []route{
+        {
+            Method:  http.MethodGet,
+            Path:    "/cache/{namespace}",
+            Summary: "Get one or several values from a namespace",
+            Handler: h.inNamespace((*handlers).getCacheHandler),
+            Params: []param{
+                namespaceParam,
+                {Name: "key", In: "query", Description: "Cache key; repeat to fetch several keys"},
+                {Name: "keys", In: "query", Description: "Comma-separated list of keys to fetch at once"},
+            },
+            Response: []response{
+                {Status: http.StatusOK, Description: "The cached value", ContentType: "text/plain"},
+                {Status: http.StatusOK, Description: "Found values and missing keys for multi-key requests", ContentType: "application/json", Body: MultiGetResponse{}},
+                errorResponse(http.StatusNotFound, "Key not found (KEY_NOT_FOUND), expired (EXPIRED) or no such namespace (NOT_FOUND)"),
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/cache/{namespace}",
+            Summary: "Set a value in a namespace; an expiration of zero takes the namespace's default TTL",
+            Handler: h.inNamespace((*handlers).setCacheHandler),
+            Params:  []param{namespaceParam},
+            Body:    CacheRequest{},
+            Response: []response{
+                {Status: http.StatusOK, Description: "Value stored"},
+                errorResponse(http.StatusBadRequest, "Malformed request body (BAD_REQUEST)"),
+                errorResponse(http.StatusNotFound, "No such namespace (NOT_FOUND)"),
+                errorResponse(http.StatusConflict, "With -doorkeeper-window, the key is new and was not cached until its next write (NOT_ADMITTED)"),
+                errorResponse(http.StatusRequestEntityTooLarge, "Value too large (PAYLOAD_TOO_LARGE)"),
+                errorResponse(http.StatusTooManyRequests, "The namespace's quota_entries or quota_bytes is used up (QUOTA_EXCEEDED)"),
+            },
+        },
+        {
+            Method:  http.MethodDelete,
+            Path:    "/cache/{namespace}",
+            Summary: "Delete a key from a namespace",
+            Handler: h.inNamespace((*handlers).deleteCacheHandler),
+            Params:  []param{namespaceParam, {Name: "key", In: "query", Description: "Cache key", Required: true}},
+            Response: []response{
+                {Status: http.StatusNoContent, Description: "Key deleted"},
+                errorResponse(http.StatusBadRequest, "Missing key (BAD_REQUEST)"),
+                errorResponse(http.StatusNotFound, "Key not found (KEY_NOT_FOUND) or no such namespace (NOT_FOUND)"),
+            },
+        },
+    }
+}
+
+// inNamespace serves a request with a copy of h for the namespace its path
+// names
+func (h *handlers) inNamespace(serve func(*handlers, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        cache := h.namespaces.Get(r.PathValue("namespace"))
+        if cache == nil {
+            writeError(w, http.StatusNotFound, codeNotFound, "No such namespace")
+            return
+        }
+        nh := *h
+        nh.cache = cache
+        serve(&nh, w, r)
+    }
+}
+
+// deleteCacheHandler handles DELETE /cache/{namespace}
+func (h *handlers) deleteCacheHandler(w http.ResponseWriter, r *http.Request) {
+    key := r.URL.Query().Get("key")
+    span := startSpan(r.Context(), "cache.delete")
+    deleted := h.cache.Delete(key)
+    span.SetAttributes(attribute.Bool("cache.hit", deleted))
+    span.End()
+    if !deleted {
+        writeError(w, http.StatusNotFound, codeKeyNotFound, "Key not found")
+        return
+    }
+    audit(httpCaller("http", r), "delete", key)
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// NamespaceInfo describes a namespace in GET /admin/namespaces
+type NamespaceInfo struct {
+    Name      string            `json:"name"`
+    Settings  NamespaceSettings `json:"settings"`
+    Entries   int               `json:"entries"`
+    Bytes     int64             `json:"bytes"`
+    Persisted bool              `json:"persisted"` // Written to -namespaces-file, so it survives a restart
+}
+
+// NamespaceRequest is the body of POST /admin/namespaces
+type NamespaceRequest struct {
+    Name     string            `json:"name"`
+    Settings NamespaceSettings `json:"settings"`
+}
+
+// namespaceAdminRoutes lists the admin endpoints managing namespaces
+func namespaceAdminRoutes(h *handlers) []route {
+    return []route{
+        {
+            Method:  http.MethodGet,
+            Path:    "/admin/namespaces",
+            Summary: "List the namespaces with their settings and sizes",
+            Handler: h.listNamespacesHandler,
+            Response: []response{
+                {Status: http.StatusOK, Description: "Every namespace, the default one included, ordered by name", ContentType: "application/json", Body: []NamespaceInfo{}},
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/admin/namespaces",
+            Summary: "Create a namespace, writing it to -namespaces-file if set",
+            Handler: h.createNamespaceHandler,
+            Body:    NamespaceRequest{},
+            Response: []response{
+                {Status: http.StatusCreated, Description: "Namespace created", ContentType: "application/json", Body: NamespaceInfo{}},
+                errorResponse(http.StatusBadRequest, "Malformed body, name or settings (BAD_REQUEST)"),
+                errorResponse(http.StatusConflict, "The namespace exists, or this node replicates its cache (CONFLICT)"),
+            },
+        },
+        {
+            Method:  http.MethodPut,
+            Path:    "/admin/namespaces/{namespace}/capacity",
+            Summary: "Change the maximum number of entries of a namespace",
+            Handler: h.resizeNamespaceHandler,
+            Params:  []param{namespaceParam},
+            Body:    CapacityRequest{},
+            Response: []response{
+                {Status: http.StatusOK, Description: "New capacity and evicted entries", ContentType: "application/json", Body: CapacityResponse{}},
+                errorResponse(http.StatusBadRequest, "Malformed request body or non-positive capacity (BAD_REQUEST)"),
+                errorResponse(http.StatusNotFound, "No such namespace (NOT_FOUND)"),
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/admin/namespaces/{namespace}/flush",
+            Summary: "Remove every entry of a namespace",
+            Handler: h.flushNamespaceHandler,
+            Params:  []param{namespaceParam},
+            Response: []response{
+                {Status: http.StatusOK, Description: "Number of entries removed", ContentType: "application/json", Body: CountResponse{}},
+                errorResponse(http.StatusNotFound, "No such namespace (NOT_FOUND)"),
+            },
+        },
+        {
+            Method:  http.MethodDelete,
+            Path:    "/admin/namespaces/{namespace}",
+            Summary: "Delete a namespace and its entries",
+            Handler: h.deleteNamespaceHandler,
+            Params:  []param{namespaceParam},
+            Response: []response{
+                {Status: http.StatusNoContent, Description: "Namespace deleted"},
+                errorResponse(http.StatusBadRequest, "The default namespace cannot be deleted (BAD_REQUEST)"),
+                errorResponse(http.StatusNotFound, "No such namespace (NOT_FOUND)"),
+            },
+        },
+    }
+}
+
+// listNamespacesHandler handles GET /admin/namespaces
+func (h *handlers) listNamespacesHandler(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, h.namespaces.List())
+}
+
+// createNamespaceHandler handles POST /admin/namespaces
+func (h *handlers) createNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+    var req NamespaceRequest
+    if !h.decodeJSON(w, r, &req) {
+        return
+    }
+    if consensus != nil || replication != nil || h.ha != nil {
+        writeError(w, http.StatusConflict, codeConflict, "Namespaces are local to each node and cannot be used with -replicate-from, -raft-listen or -ha-lock")
+        return
+    }
+    ns, err := req.Settings.config(req.Name, h.namespaces.cfg)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+        return
+    }
+    err = h.namespaces.Create(ns)
+    switch {
+    case errors.Is(err, errNamespaceExists):
+        writeError(w, http.StatusConflict, codeConflict, "Namespace "+ns.Name+" exists")
+        return
+    case err != nil:
+        // The namespace serves, but only until a restart
+        slog.Error("cannot write -namespaces-file", "err", err)
+    }
+    audit(httpCaller("admin", r), "create_namespace", ns.Name)
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(NamespaceInfo{Name: ns.Name, Settings: ns.settings(), Persisted: h.namespaces.cfg.NamespacesFile != "" && err == nil})
+}
+
+// resizeNamespaceHandler handles PUT /admin/namespaces/{namespace}/capacity
+func (h *handlers) resizeNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+    if r.PathValue("namespace") == defaultNamespace {
+        h.capacityHandler(w, r)
+        return
+    }
+    var req CapacityRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Capacity <= 0 {
+        writeError(w, http.StatusBadRequest, codeBadRequest, "Capacity must be a positive integer")
+        return
+    }
+    evicted, err := h.namespaces.Resize(r.PathValue("namespace"), req.Capacity)
+    switch {
+    case errors.Is(err, errNoNamespace):
+        writeError(w, http.StatusNotFound, codeNotFound, "No such namespace")
+        return
+    case errors.Is(err, lrucache.ErrInvalidCapacity):
+        writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+        return
+    case err != nil:
+        slog.Error("cannot write -namespaces-file", "err", err)
+    }
+    writeJSON(w, CapacityResponse{Capacity: req.Capacity, Evicted: evicted})
+}
+
+// flushNamespaceHandler handles POST /admin/namespaces/{namespace}/flush
+func (h *handlers) flushNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+    name := r.PathValue("namespace")
+    if name == defaultNamespace {
+        h.flushHandler(w, r)
+        return
+    }
+    cache := h.namespaces.Get(name)
+    if cache == nil {
+        writeError(w, http.StatusNotFound, codeNotFound, "No such namespace")
+        return
+    }
+    n := cache.Flush()
+    audit(httpCaller("admin", r), "flush", name+"/")
+    writeJSON(w, CountResponse{Count: n})
+}
+
+// deleteNamespaceHandler handles DELETE /admin/namespaces/{namespace}
+func (h *handlers) deleteNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+    name := r.PathValue("namespace")
+    switch err := h.namespaces.Delete(name); {
+    case errors.Is(err, errDefaultNamespace):
+        writeError(w, http.StatusBadRequest, codeBadRequest, "The default namespace cannot be deleted")
+        return
+    case errors.Is(err, errNoNamespace):
+        writeError(w, http.StatusNotFound, codeNotFound, "No such namespace")
+        return
+    case err != nil:
+        slog.Error("cannot write -namespaces-file", "err", err)
+    }
+    audit(httpCaller("admin", r), "delete_namespace", name)
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net"
+    "strings"
+    "testing"
+    "time"
+
+    natsserver "github.com/nats-io/nats-server/v2/server"
+    "github.com/nats-io/nats.go"
+
+    "lru-cache/lrucache"
+)
+
+// startNATS runs a NATS server on port, a free one if -1
+func startNATS(t *testing.T, port int) *natsserver.Server {
+    t.Helper()
+    s, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1", Port: port, NoLog: true, NoSigs: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    go s.Start()
+    if !s.ReadyForConnections(5 * time.Second) {
+        t.Fatal("NATS server did not start")
+    }
+    t.Cleanup(s.Shutdown)
+    return s
+}
+
+// startNATSBridge connects a bridge serving a fresh cache to s
+func startNATSBridge(t *testing.T, s *natsserver.Server, auth *Authenticator) *lrucache.LRUCache {
+    t.Helper()
+    cache := lrucache.New(lrucache.WithCapacity(100))
+    cfg := &Config{NATSURL: s.ClientURL(), NATSSubject: "cache", NATSQueue: "lru-cache", MaxValueBytes: 64}
+    b, err := newNATSBridge(cfg, cache, auth)
+    if err != nil {
+        t.Fatal(err)
+    }
+    served := make(chan error, 1)
+    go func() { served <- b.ListenAndServe() }()
+    t.Cleanup(func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        if err := b.Shutdown(ctx); err != nil {
+            t.Errorf("Shutdown: %v", err)
+        }
+        if err := <-served; err != errServerClosed {
+            t.Errorf("ListenAndServe = %v, want errServerClosed", err)
+        }
+    })
+    return cache
+}
+
+// natsClient connects to s and waits until the bridge answers
+func natsClient(t *testing.T, s *natsserver.Server) *nats.Conn {
+    t.Helper()
+    nc, err := nats.Connect(s.ClientURL())
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(nc.Close)
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        if _, err := nc.Request("cache.get", []byte(`{"key":"ready"}`), 100*time.Millisecond); err == nil {
+            return nc
+        } else if time.Now().After(deadline) {
+            t.Fatalf("bridge does not answer: %v", err)
+        }
+    }
+}
+
+func natsRequest(t *testing.T, nc *nats.Conn, subject, payload string) NATSReply {
+    t.Helper()
+    msg, err := nc.Request(subject, []byte(payload), 2*time.Second)
+    if err != nil {
+        t.Fatalf("request on %s: %v", subject, err)
+    }
+    var reply NATSReply
+    if err := json.Unmarshal(msg.Data, &reply); err != nil {
+        t.Fatalf("reply %q: %v", msg.Data, err)
+    }
+    return reply
+}
+
+func TestNATSBridge(t *testing.T) {
+    s := startNATS(t, -1)
+    cache := startNATSBridge(t, s, NewAuthenticator(nil, nil, nil, false))
+    nc := natsClient(t, s)
+
+    tests := []struct {
+        name      string
+        subject   string
+        payload   string
+        wantFound string // "true", "false" or "" for no found field
+        wantValue string
+        wantCode  string
+    }{
+        {"get missing", "cache.get", `{"key":"k"}`, "false", "", ""},
+        {"set", "cache.set", `{"key":"k","value":"v","ttl":60}`, "true", "", ""},
+        {"get", "cache.get", `{"key":"k"}`, "true", "v", ""},
+        {"del", "cache.del", `{"key":"k"}`, "true", "", ""},
+        {"del missing", "cache.del", `{"key":"k"}`, "false", "", ""},
+        {"malformed", "cache.set", `{`, "", "", codeBadRequest},
+        {"value too large", "cache.set", `{"key":"k","value":"` + strings.Repeat("x", 65) + `"}`, "", "", codePayloadTooLarge},
+        {"request too large", "cache.set", `{"key":"k","value":"` + strings.Repeat("x", 8192) + `"}`, "", "", codePayloadTooLarge},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            reply := natsRequest(t, nc, tt.subject, tt.payload)
+            found := ""
+            if reply.Found != nil {
+                found = map[bool]string{true: "true", false: "false"}[*reply.Found]
+            }
+            code := ""
+            if reply.Error != nil {
+                code = reply.Error.Code
+            }
+            if found != tt.wantFound || reply.Value != tt.wantValue || code != tt.wantCode {
+                t.Fatalf("reply = %+v, want found %q, value %q, error %q", reply, tt.wantFound, tt.wantValue, tt.wantCode)
+            }
+        })
+    }
+
+    // The TTL of a get is what is left of the set's
+    cache.Set("ttl", "v", time.Minute)
+    if reply := natsRequest(t, nc, "cache.get", `{"key":"ttl"}`); reply.TTL < 59 || reply.TTL > 60 {
+        t.Fatalf("ttl = %d, want about 60", reply.TTL)
+    }
+}
+
+func TestNATSBridgeAuth(t *testing.T) {
+    s := startNATS(t, -1)
+    startNATSBridge(t, s, NewAuthenticator([]string{"secret"}, nil, nil, true))
+    nc := natsClient(t, s) // Unauthorized requests are answered too
+
+    tests := []struct {
+        name, subject, payload, wantCode string
+    }{
+        {"no token", "cache.get", `{"key":"k"}`, codeUnauthorized},
+        {"wrong token", "cache.set", `{"key":"k","value":"v","token":"guess"}`, codeUnauthorized},
+        {"api key", "cache.set", `{"key":"k","value":"v","token":"secret"}`, ""},
+        {"read with the key", "cache.get", `{"key":"k","token":"secret"}`, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            reply := natsRequest(t, nc, tt.subject, tt.payload)
+            code := ""
+            if reply.Error != nil {
+                code = reply.Error.Code
+            }
+            if code != tt.wantCode {
+                t.Fatalf("reply = %+v, want error %q", reply, tt.wantCode)
+            }
+        })
+    }
+}
+
+func TestNATSBridgeReconnects(t *testing.T) {
+    s := startNATS(t, -1)
+    port := s.Addr().(*net.TCPAddr).Port
+    startNATSBridge(t, s, NewAuthenticator(nil, nil, nil, false))
+    natsClient(t, s)
+
+    // The server restarts on the same port; the bridge subscribes again
+    s.Shutdown()
+    s.WaitForShutdown()
+    restarted := startNATS(t, port)
+    nc := natsClient(t, restarted)
+    if reply := natsRequest(t, nc, "cache.set", `{"key":"k","value":"v"}`); reply.Error != nil {
+        t.Fatalf("set after the restart: %+v", reply.Error)
+    }
+}
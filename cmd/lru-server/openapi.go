@@ -0,0 +1,164 @@
+package main
+
+import (
+    "reflect"
+    "strconv"
+    "strings"
+)
+
+// openAPIDocument builds an OpenAPI 3 description of the given routes
+func openAPIDocument(routes []route) map[string]interface{} {
+    schemas := make(map[string]interface{})
+    paths := make(map[string]map[string]interface{})
+
+    for _, rt := range routes {
+        op := map[string]interface{}{
+            "summary":     rt.Summary,
+            "operationId": operationID(rt),
+        }
+        if len(rt.Params) > 0 {
+            var params []interface{}
+            for _, p := range rt.Params {
+                params = append(params, map[string]interface{}{
+                    "name":        p.Name,
+                    "in":          p.In,
+                    "description": p.Description,
+                    "required":    p.Required,
+                    "schema":      map[string]interface{}{"type": "string"},
+                })
+            }
+            op["parameters"] = params
+        }
+        if rt.Body != nil {
+            content := map[string]interface{}{
+                "application/json": map[string]interface{}{
+                    "schema": schemaFor(reflect.TypeOf(rt.Body), schemas),
+                },
+            }
+            if rt.Binary {
+                content["application/octet-stream"] = map[string]interface{}{
+                    "schema": map[string]interface{}{"type": "string", "format": "binary"},
+                }
+            }
+            op["requestBody"] = map[string]interface{}{"required": true, "content": content}
+        }
+        responses := make(map[string]interface{})
+        for _, resp := range rt.Response {
+            // Several entries with the same status describe alternative content types
+            status := strconv.Itoa(resp.Status)
+            r, seen := responses[status].(map[string]interface{})
+            if !seen {
+                r = map[string]interface{}{"description": resp.Description}
+                responses[status] = r
+            } else {
+                r["description"] = r["description"].(string) + "; " + resp.Description
+            }
+            if resp.ContentType != "" {
+                media := map[string]interface{}{}
+                if resp.Body != nil {
+                    media["schema"] = schemaFor(reflect.TypeOf(resp.Body), schemas)
+                } else {
+                    media["schema"] = map[string]interface{}{"type": "string"}
+                }
+                content, _ := r["content"].(map[string]interface{})
+                if content == nil {
+                    content = make(map[string]interface{})
+                    r["content"] = content
+                }
+                content[resp.ContentType] = media
+            }
+        }
+        op["responses"] = responses
+
+        if paths[rt.Path] == nil {
+            paths[rt.Path] = make(map[string]interface{})
+        }
+        paths[rt.Path][strings.ToLower(rt.Method)] = op
+    }
+
+    doc := map[string]interface{}{
+        "openapi": "3.0.3",
+        "info": map[string]interface{}{
+            "title":   "LRU Cache",
+            "version": "1.0.0",
+        },
+        "paths": paths,
+    }
+    if len(schemas) > 0 {
+        doc["components"] = map[string]interface{}{"schemas": schemas}
+    }
+    return doc
+}
+
+// operationID derives a stable identifier such as getV1CacheKey from a route
+func operationID(rt route) string {
+    var b strings.Builder
+    b.WriteString(strings.ToLower(rt.Method))
+    for _, part := range strings.FieldsFunc(rt.Path, func(r rune) bool {
+        return r == '/' || r == '{' || r == '}' || r == '.' || r == '-'
+    }) {
+        b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+    }
+    return b.String()
+}
+
+// schemaFor returns a JSON schema for t, registering named structs as components
+func schemaFor(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+    switch t.Kind() {
+    case reflect.Pointer:
+        return schemaFor(t.Elem(), schemas)
+    case reflect.String:
+        return map[string]interface{}{"type": "string"}
+    case reflect.Bool:
+        return map[string]interface{}{"type": "boolean"}
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return map[string]interface{}{"type": "integer"}
+    case reflect.Float32, reflect.Float64:
+        return map[string]interface{}{"type": "number"}
+    case reflect.Slice, reflect.Array:
+        return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+    case reflect.Map:
+        return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+    case reflect.Struct:
+        if t.Name() == "" {
+            return structSchema(t, schemas)
+        }
+        ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+        if _, done := schemas[t.Name()]; !done {
+            schemas[t.Name()] = map[string]interface{}{"type": "object"} // placeholder for recursive types
+            schemas[t.Name()] = structSchema(t, schemas)
+        }
+        return ref
+    default:
+        return map[string]interface{}{}
+    }
+}
+
+// structSchema describes the exported, JSON-visible fields of a struct
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+    props := make(map[string]interface{})
+    var required []string
+    for i := 0; i < t.NumField(); i++ {
+        f := t.Field(i)
+        if !f.IsExported() {
+            continue
+        }
+        name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+        if name == "-" {
+            continue
+        }
+        if name == "" {
+            name = f.Name
+        }
+        props[name] = schemaFor(f.Type, schemas)
+        if !strings.Contains(opts, "omitempty") {
+            required = append(required, name)
+        }
+    }
+    s := map[string]interface{}{"type": "object", "properties": props}
+    if len(required) > 0 {
+        s["required"] = required
+    }
+    return s
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "sort"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+
+    "lru-cache/client"
+)
+
+// Proxy tuning
+const (
+    proxyEjectAfter = 3                // Consecutive failures that take a backend out of the ring
+    proxyEjectFor   = 30 * time.Second // How long an ejected backend is skipped before it is tried again
+    proxyRefresh    = 30 * time.Second // How often -proxy-discover is queried
+)
+
+// proxy is nil unless -proxy or -proxy-discover is set
+var proxy *Proxy
+
+// Proxy stores nothing itself: it sends every key to a backend cache node
+// chosen by consistent hash, fails over to the next nodes on the ring and
+// splits multi-key reads by backend, so clients need not know the topology
+type Proxy struct {
+    client   *http.Client
+    retries  int
+    replicas int    // Backends holding each key
+    read     string // Default quorum levels
+    write    string
+    resolver client.Resolver // nil with a fixed list of backends
+    hints    *hintStore      // nil unless -proxy-hints is set
+    maxBody  int64           // -max-value-bytes plus framing
+
+    mutex    sync.RWMutex // Guards ring and backends
+    ring     *client.Ring
+    backends map[string]*backend // By base URL
+    done     chan struct{}
+    stopOnce sync.Once
+}
+
+// backend is one cache node behind the proxy
+type backend struct {
+    url          string
+    failures     atomic.Int32 // Consecutive
+    ejectedUntil atomic.Int64 // Unix nanoseconds
+    requests     atomic.Uint64
+    errors       atomic.Uint64
+}
+
+// ProxyBackend is the state of a backend, published in expvar "proxy"
+type ProxyBackend struct {
+    URL          string     `json:"url"`
+    Requests     uint64     `json:"requests"`
+    Errors       uint64     `json:"errors"`
+    EjectedUntil *time.Time `json:"ejected_until,omitempty"`
+}
+
+// NewProxy routes to cfg.ProxyBackends, or to the nodes -proxy-discover finds
+func NewProxy(cfg *Config) (*Proxy, error) {
+    p := &Proxy{
+        client:   &http.Client{Timeout: cfg.ProxyTimeout, Transport: otelhttp.NewTransport(http.DefaultTransport)},
+        retries:  cfg.ProxyRetries,
+        replicas: cfg.ProxyReplicas,
+        read:     cfg.ProxyReadLevel,
+        write:    cfg.ProxyWriteLevel,
+        maxBody:  int64(cfg.MaxValueBytes) + 4096,
+        ring:     client.NewRing(client.DefaultReplicas),
+        backends: make(map[string]*backend),
+        done:     make(chan struct{}),
+    }
+    if cfg.ProxyHints > 0 {
+        p.hints = newHintStore(cfg.ProxyHints)
+    }
+    if cfg.ProxyDiscover == "" {
+        p.setBackends(cfg.ProxyBackends)
+        return p, nil
+    }
+    var err error
+    if p.resolver, err = client.ParseResolver(cfg.ProxyDiscover); err != nil {
+        return nil, err
+    }
+    p.discover()
+    return p, nil
+}
+
+// setBackends replaces the backends with urls, keeping the counters of those
+// that stay
+func (p *Proxy) setBackends(urls []string) {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    keep := make(map[string]bool, len(urls))
+    for _, u := range urls {
+        u = strings.TrimSuffix(u, "/")
+        keep[u] = true
+        if p.backends[u] == nil {
+            p.backends[u] = &backend{url: u}
+            p.ring.Add(u)
+            slog.Info("proxy: backend added", "url", u)
+        }
+    }
+    for u := range p.backends {
+        if !keep[u] {
+            p.hints.forget(p.backends[u])
+            delete(p.backends, u)
+            p.ring.Remove(u)
+            slog.Info("proxy: backend removed", "url", u)
+        }
+    }
+}
+
+// discover replaces the backends with the nodes the registry lists; a failed
+// or empty lookup keeps the current ones
+func (p *Proxy) discover() {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    addrs, err := p.resolver.Resolve(ctx)
+    cancel()
+    if err != nil || len(addrs) == 0 {
+        slog.Warn("proxy: discovery found no backends, keeping the current ones", "err", err)
+        return
+    }
+    urls := make([]string, len(addrs))
+    for i, addr := range addrs {
+        urls[i] = "http://" + addr
+    }
+    p.setBackends(urls)
+}
+
+// ListenAndServe refreshes the backends from the registry and replays hints
+// to backends that are back until shut down
+func (p *Proxy) ListenAndServe() error {
+    var refresh, replay <-chan time.Time
+    if p.resolver != nil {
+        ticker := time.NewTicker(proxyRefresh)
+        defer ticker.Stop()
+        refresh = ticker.C
+    }
+    if p.hints != nil {
+        ticker := time.NewTicker(proxyHintReplay)
+        defer ticker.Stop()
+        replay = ticker.C
+    }
+    for {
+        select {
+        case <-p.done:
+            return errServerClosed
+        case <-refresh:
+            p.discover()
+        case <-replay:
+            p.handOff()
+        }
+    }
+}
+
+// Shutdown stops refreshing the backends and replaying hints
+func (p *Proxy) Shutdown(ctx context.Context) error {
+    return p.Close()
+}
+
+// Close stops refreshing the backends and replaying hints; hints not yet
+// replayed are lost
+func (p *Proxy) Close() error {
+    p.stopOnce.Do(func() { close(p.done) })
+    return nil
+}
+
+// Backends reports every backend, sorted by URL
+func (p *Proxy) Backends() []ProxyBackend {
+    p.mutex.RLock()
+    defer p.mutex.RUnlock()
+    out := make([]ProxyBackend, 0, len(p.backends))
+    now := time.Now()
+    for _, b := range p.backends {
+        pb := ProxyBackend{URL: b.url, Requests: b.requests.Load(), Errors: b.errors.Load()}
+        if until := time.Unix(0, b.ejectedUntil.Load()); until.After(now) {
+            pb.EjectedUntil = &until
+        }
+        out = append(out, pb)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].URL < out[j].URL })
+    return out
+}
+
+// owner returns the first backend on the ring for key, up or not
+func (p *Proxy) owner(key string) *backend {
+    p.mutex.RLock()
+    defer p.mutex.RUnlock()
+    if owners := p.ring.Successors(key, 1); len(owners) > 0 {
+        return p.backends[owners[0]]
+    }
+    return nil
+}
+
+// pick returns the first backend on the ring for key that has not failed
+// during this request, preferring those not ejected
+func (p *Proxy) pick(key string, failed map[*backend]bool) *backend {
+    p.mutex.RLock()
+    defer p.mutex.RUnlock()
+    now := time.Now().UnixNano()
+    var fallback *backend
+    for _, u := range p.ring.Successors(key, len(p.backends)) {
+        b := p.backends[u]
+        if failed[b] {
+            continue
+        }
+        if b.ejectedUntil.Load() <= now {
+            return b
+        }
+        if fallback == nil {
+            fallback = b
+        }
+    }
+    return fallback
+}
+
+// result records the outcome of a request to b, ejecting it after repeated failures
+func (b *backend) result(ok bool) {
+    b.requests.Add(1)
+    if ok {
+        b.failures.Store(0)
+        return
+    }
+    b.errors.Add(1)
+    if b.failures.Add(1) >= proxyEjectAfter {
+        if b.ejectedUntil.Swap(time.Now().Add(proxyEjectFor).UnixNano()) < time.Now().UnixNano() {
+            slog.Warn("proxy: backend ejected", "url", b.url, "for", proxyEjectFor)
+        }
+    }
+}
+
+// proxyHeaders are the client request headers passed on to backends
+var proxyHeaders = []string{"Authorization", "X-API-Key", "Content-Type", "Accept", "Range"}
+
+// proxyResponseHeaders are the backend response headers passed on to clients
+var proxyResponseHeaders = []string{"Content-Type", "Content-Range", "Accept-Ranges", "Last-Modified"}
+
+// send makes one request to b with the headers of a client request; a
+// transport error or a 5xx response counts as a failure and is returned as
+// an error
+func (p *Proxy) send(ctx context.Context, header http.Header, b *backend, method, uri string, body []byte) (*http.Response, error) {
+    span := startSpan(ctx, "proxy.request")
+    span.SetAttributes(attribute.String("proxy.backend", b.url))
+    defer span.End()
+    req, err := http.NewRequestWithContext(ctx, method, b.url+uri, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    for _, h := range proxyHeaders {
+        if v := header.Get(h); v != "" {
+            req.Header.Set(h, v)
+        }
+    }
+    resp, err := p.client.Do(req)
+    if err == nil && resp.StatusCode >= 500 {
+        resp.Body.Close()
+        err = errors.New(resp.Status)
+    }
+    b.result(err == nil)
+    if err != nil {
+        span.SetStatus(codes.Error, err.Error())
+        slog.Debug("proxy: backend failed", "url", b.url, "err", err)
+        return nil, err
+    }
+    return resp, nil
+}
+
+// forward sends r, with body, to the backend owning key, trying up to
+// retries more backends when it fails, and copies the response; a write
+// another backend accepted for the owner is kept as a hint. With replicas,
+// reads above quorum one and all writes go to every replica.
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request, key string, body []byte) {
+    level := p.write
+    if r.Method == http.MethodGet {
+        level = p.read
+    }
+    level, ok := quorumLevel(w, r, level)
+    if !ok {
+        return
+    }
+    if p.replicas > 1 && (r.Method != http.MethodGet || level != levelOne) {
+        p.replicate(w, r, key, body, level)
+        return
+    }
+
+    uri := proxyURI(r)
+    failed := make(map[*backend]bool)
+    for attempt := 0; attempt <= p.retries; attempt++ {
+        b := p.pick(key, failed)
+        if b == nil {
+            break
+        }
+        resp, err := p.send(r.Context(), r.Header, b, r.Method, uri, body)
+        if err != nil {
+            failed[b] = true
+            continue
+        }
+        defer resp.Body.Close()
+        if r.Method != http.MethodGet && resp.StatusCode < http.StatusBadRequest {
+            p.hints.wrote(p.owner(key), b, key, r.Method, uri, r.Header, body)
+        }
+        for _, h := range proxyResponseHeaders {
+            if v := resp.Header.Get(h); v != "" {
+                w.Header().Set(h, v)
+            }
+        }
+        w.WriteHeader(resp.StatusCode)
+        io.Copy(w, resp.Body)
+        return
+    }
+    writeError(w, http.StatusBadGateway, codeUnavailable, "No backend answered; try again shortly")
+}
+
+var levelParam = param{Name: "quorum", In: "query", Description: "With -proxy-replicas: one, quorum or all replicas must answer (multi-key reads are always one)"}
+
+// proxyConsistencyParam is consistencyParam as the proxy passes it on to the
+// backends, which act on it when they are replicas
+var proxyConsistencyParam = param{Name: "consistency", In: "query", Description: "Passed on to the backends; on a replica, primary (default) reads through the primary and stale reads the local copy"}
+
+// proxyRoutes lists the endpoints served on the public listener in proxy mode
+func proxyRoutes() []route {
+    return []route{
+        {
+            Method:  http.MethodGet,
+            Path:    "/cache",
+            Summary: "Get one or several values (legacy API) from the backends owning them",
+            Handler: proxyGetCacheHandler,
+            Params: []param{
+                {Name: "key", In: "query", Description: "Cache key; repeat to fetch several keys"},
+                {Name: "keys", In: "query", Description: "Comma-separated list of keys to fetch at once"},
+                levelParam,
+                proxyConsistencyParam,
+            },
+            Response: []response{
+                {Status: http.StatusOK, Description: "The cached value", ContentType: "text/plain"},
+                {Status: http.StatusOK, Description: "Found values and missing keys for multi-key requests", ContentType: "application/json", Body: MultiGetResponse{}},
+                errorResponse(http.StatusNotFound, "Key not found (KEY_NOT_FOUND) or expired (EXPIRED)"),
+                errorResponse(http.StatusBadGateway, "No backend answered (UNAVAILABLE)"),
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/cache",
+            Summary: "Set a value (legacy API) on the backend owning it",
+            Handler: proxySetCacheHandler,
+            Body:    CacheRequest{},
+            Response: []response{
+                {Status: http.StatusOK, Description: "Value stored"},
+                errorResponse(http.StatusBadRequest, "Malformed request body (BAD_REQUEST)"),
+                errorResponse(http.StatusBadGateway, "No backend answered (UNAVAILABLE)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/v1/cache/{key}",
+            Summary: "Get a value from the backend owning it",
+            Handler: proxyKeyHandler,
+            Params:  []param{keyParam, levelParam, proxyConsistencyParam},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The cached value", ContentType: "text/plain"},
+                errorResponse(http.StatusNotFound, "Key not found (KEY_NOT_FOUND) or expired (EXPIRED)"),
+                errorResponse(http.StatusBadGateway, "No backend answered (UNAVAILABLE)"),
+            },
+        },
+        {
+            Method:  http.MethodPut,
+            Path:    "/v1/cache/{key}",
+            Summary: "Set a value on the backend owning it",
+            Handler: proxyKeyHandler,
+            Params:  []param{keyParam, levelParam},
+            Body:    EntryRequest{},
+            Response: []response{
+                {Status: http.StatusNoContent, Description: "Value stored"},
+                errorResponse(http.StatusBadRequest, "Malformed request body (BAD_REQUEST)"),
+                errorResponse(http.StatusBadGateway, "No backend answered (UNAVAILABLE)"),
+            },
+        },
+        {
+            Method:  http.MethodDelete,
+            Path:    "/v1/cache/{key}",
+            Summary: "Delete a value on the backend owning it",
+            Handler: proxyKeyHandler,
+            Params:  []param{keyParam, levelParam},
+            Response: []response{
+                {Status: http.StatusNoContent, Description: "Key deleted"},
+                errorResponse(http.StatusNotFound, "Key not found (KEY_NOT_FOUND) or expired (EXPIRED)"),
+                errorResponse(http.StatusBadGateway, "No backend answered (UNAVAILABLE)"),
+            },
+        },
+    }
+}
+
+// readBody reads a request body of at most a maximum-size value plus framing
+func (p *Proxy) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+    body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, p.maxBody))
+    if err != nil {
+        writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "Request body too large")
+        return nil, false
+    }
+    return body, true
+}
+
+// proxyKeyHandler forwards GET, PUT and DELETE /v1/cache/{key}
+func proxyKeyHandler(w http.ResponseWriter, r *http.Request) {
+    body, ok := proxy.readBody(w, r)
+    if !ok {
+        return
+    }
+    proxy.forward(w, r, r.PathValue("key"), body)
+}
+
+// proxySetCacheHandler forwards POST /cache to the owner of the key in the body
+func proxySetCacheHandler(w http.ResponseWriter, r *http.Request) {
+    body, ok := proxy.readBody(w, r)
+    if !ok {
+        return
+    }
+    var req CacheRequest
+    if err := json.Unmarshal(body, &req); err != nil {
+        writeError(w, http.StatusBadRequest, codeBadRequest, "Malformed JSON body")
+        return
+    }
+    proxy.forward(w, r, req.Key, body)
+}
+
+// proxyGetCacheHandler forwards single-key reads and splits multi-key reads
+// into one request per backend, merging the answers
+func proxyGetCacheHandler(w http.ResponseWriter, r *http.Request) {
+    keys := requestedKeys(r)
+    if len(keys) == 1 && !r.URL.Query().Has("keys") {
+        proxy.forward(w, r, keys[0], nil)
+        return
+    }
+    // Multi-key reads are answered by each key's first live replica
+    if _, ok := quorumLevel(w, r, levelOne); !ok {
+        return
+    }
+
+    values := make(map[string]string)
+    failed := make(map[*backend]bool)
+    pending := keys
+    var unrouted []string // Keys no live backend is left to answer
+    for attempt := 0; attempt <= proxy.retries && len(pending) > 0; attempt++ {
+        groups := make(map[*backend][]string)
+        for _, key := range pending {
+            if b := proxy.pick(key, failed); b != nil {
+                groups[b] = append(groups[b], key)
+            } else {
+                unrouted = append(unrouted, key)
+            }
+        }
+        pending = nil
+        var mutex sync.Mutex
+        var wg sync.WaitGroup
+        for b, group := range groups {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                resp, err := proxy.multiGet(r, b, group)
+                mutex.Lock()
+                defer mutex.Unlock()
+                if err != nil {
+                    failed[b] = true
+                    pending = append(pending, group...)
+                    return
+                }
+                for k, v := range resp.Values {
+                    values[k] = v
+                }
+            }()
+        }
+        wg.Wait()
+    }
+    if len(pending) > 0 || len(unrouted) > 0 {
+        writeError(w, http.StatusBadGateway, codeUnavailable, "No backend answered for some keys; try again shortly")
+        return
+    }
+
+    resp := MultiGetResponse{Values: values, Missing: []string{}}
+    for _, key := range keys {
+        if _, found := values[key]; !found {
+            resp.Missing = append(resp.Missing, key)
+        }
+    }
+    writeJSON(w, resp)
+}
+
+// multiGet asks b for several keys at once
+func (p *Proxy) multiGet(r *http.Request, b *backend, keys []string) (MultiGetResponse, error) {
+    var out MultiGetResponse
+    // Repeated key parameters, since keys may contain commas
+    resp, err := p.send(r.Context(), r.Header, b, http.MethodGet, "/cache?"+url.Values{"key": keys}.Encode()+"&keys=", nil)
+    if err != nil {
+        return out, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        // Rejections such as failed authentication count as failures, since answers are merged
+        return out, errors.New(resp.Status)
+    }
+    return out, json.NewDecoder(resp.Body).Decode(&out)
+}
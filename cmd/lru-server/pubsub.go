@@ -0,0 +1,180 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+
+    "lru-cache/lrucache"
+)
+
+// Channels announcing key events to RESP subscribers, as Redis keyspace
+// notifications do: __keyspace@0__:<key> carries the event name and
+// __keyevent@0__:<event> the key
+const (
+    keyspaceChannel = "__keyspace@0__:"
+    keyeventChannel = "__keyevent@0__:"
+)
+
+// keyEventNames are the Redis names of the events key notifications announce
+var keyEventNames = map[lrucache.EventType]string{
+    lrucache.EventSet:        "set",
+    lrucache.EventDelete:     "del",
+    lrucache.EventInvalidate: "del",
+    lrucache.EventExpire:     "expired",
+    lrucache.EventEvict:      "evicted",
+    lrucache.EventFlush:      "flushall",
+}
+
+// keyspaceFilter returns the events the channel, or the channel pattern if
+// pattern is set, names
+func keyspaceFilter(channel string, pattern bool) (lrucache.EventFilter, error) {
+    var f lrucache.EventFilter
+    switch {
+    case strings.HasPrefix(channel, keyspaceChannel):
+        f.Pattern = strings.TrimPrefix(channel, keyspaceChannel)
+        if !pattern {
+            f.Pattern = lrucache.QuoteGlob(f.Pattern)
+        }
+        // Flushes concern no key in particular, so only __keyevent@0__:flushall has them
+        for t := range keyEventNames {
+            if t != lrucache.EventFlush {
+                f.Types = append(f.Types, t)
+            }
+        }
+    case strings.HasPrefix(channel, keyeventChannel):
+        event := strings.TrimPrefix(channel, keyeventChannel)
+        for t, name := range keyEventNames {
+            if name == event || (pattern && lrucache.MatchGlob(event, name)) {
+                f.Types = append(f.Types, t)
+            }
+        }
+        if len(f.Types) == 0 {
+            return f, fmt.Errorf("ERR no key event matches %q", event)
+        }
+    default:
+        return f, fmt.Errorf("ERR only %s<key> and %s<event> channels are supported", keyspaceChannel, keyeventChannel)
+    }
+    return f, nil
+}
+
+// pubsub implements SUBSCRIBE, PSUBSCRIBE, UNSUBSCRIBE and PUNSUBSCRIBE
+func (s *respSession) pubsub(cmd string, args []string) {
+    pattern := strings.HasPrefix(cmd, "P")
+    subs := s.channels
+    if pattern {
+        subs = s.patterns
+    }
+    kind := strings.ToLower(cmd)
+    switch cmd {
+    case "SUBSCRIBE", "PSUBSCRIBE":
+        if len(args) == 0 {
+            s.writeWrongArgs(cmd)
+            return
+        }
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        filters := make([]lrucache.EventFilter, len(args))
+        for i, channel := range args {
+            f, err := keyspaceFilter(channel, pattern)
+            if err != nil {
+                s.writeError(err.Error())
+                return
+            }
+            filters[i] = f
+        }
+        for i, channel := range args {
+            if _, ok := subs[channel]; !ok {
+                sub := events.SubscribeFilter(filters[i], 256)
+                subs[channel] = sub
+                go s.push(sub, channel, pattern)
+            }
+            s.writeSubscription(kind, channel, true)
+        }
+    case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+        if len(args) == 0 {
+            for channel := range subs {
+                args = append(args, channel)
+            }
+        }
+        if len(args) == 0 {
+            s.writeSubscription(kind, "", false)
+        }
+        for _, channel := range args {
+            if sub, ok := subs[channel]; ok {
+                delete(subs, channel)
+                events.Unsubscribe(sub)
+            }
+            s.writeSubscription(kind, channel, true)
+        }
+    }
+}
+
+// subscribed reports whether the session has any subscriptions, which
+// restrict it to the pub/sub commands
+func (s *respSession) subscribed() bool {
+    return len(s.channels)+len(s.patterns) > 0
+}
+
+// unsubscribeAll drops every subscription when the connection closes
+func (s *respSession) unsubscribeAll() {
+    for _, sub := range s.channels {
+        events.Unsubscribe(sub)
+    }
+    for _, sub := range s.patterns {
+        events.Unsubscribe(sub)
+    }
+}
+
+// writeSubscription confirms a change to the subscriptions with the number
+// left; channel is null when hasChannel is false
+func (s *respSession) writeSubscription(kind, channel string, hasChannel bool) {
+    s.w.WriteString("*3\r\n")
+    s.writeBulk(kind)
+    if hasChannel {
+        s.writeBulk(channel)
+    } else {
+        s.writeNull()
+    }
+    s.writeInt(int64(len(s.channels) + len(s.patterns)))
+}
+
+// push sends the events of sub as messages on channel, or as pmessages of
+// the channel pattern if pattern is set, until sub is unsubscribed
+func (s *respSession) push(sub *lrucache.Subscription, channel string, pattern bool) {
+    for e := range sub.C {
+        name, ok := keyEventNames[e.Type]
+        if !ok {
+            continue
+        }
+        // Each subscription is to one kind of channel, as keyspaceFilter built it
+        to, payload := keyspaceChannel+e.Key, name
+        if strings.HasPrefix(channel, keyeventChannel) {
+            to, payload = keyeventChannel+name, e.Key
+        }
+        s.wmutex.Lock()
+        if pattern {
+            s.w.WriteString("*4\r\n")
+            s.writeBulk("pmessage")
+            s.writeBulk(channel)
+        } else {
+            s.w.WriteString("*3\r\n")
+            s.writeBulk("message")
+        }
+        s.writeBulk(to)
+        s.writeBulk(payload)
+        err := s.w.Flush()
+        s.wmutex.Unlock()
+        if err != nil {
+            return
+        }
+    }
+}
+
+// subscribedCommands may run while the session has subscriptions
+var subscribedCommands = []string{"SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "PING", "QUIT"}
+
+// writeSubscribedOnly refuses cmd while the session has subscriptions
+func (s *respSession) writeSubscribedOnly(cmd string) {
+    s.writeError("ERR Can't execute '" + strings.ToLower(cmd) + "': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context")
+}
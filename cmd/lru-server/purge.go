@@ -0,0 +1,63 @@
+package main
+
+import (
+    "net/http"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "lru-cache/lrucache"
+)
+
+// PurgeRequest is the body of POST /cache/purge
+type PurgeRequest struct {
+    Patterns []string `json:"patterns"` // * matches any run of characters, ? one, and \ escapes
+}
+
+// PurgeResponse answers POST /cache/purge
+type PurgeResponse struct {
+    Purged int `json:"purged"`
+}
+
+// purgeRoute is the endpoint deleting keys by pattern
+func purgeRoute(h *handlers) route {
+    return route{
+        Method:  http.MethodPost,
+        Path:    "/cache/purge",
+        Summary: "Delete every key matching one of the glob patterns, such as user:*:profile",
+        Handler: h.purgeHandler,
+        Body:    PurgeRequest{},
+        Response: []response{
+            {Status: http.StatusOK, Description: "Number of keys deleted", ContentType: "application/json", Body: PurgeResponse{}},
+            errorResponse(http.StatusBadRequest, "Malformed body or no patterns (BAD_REQUEST)"),
+        },
+    }
+}
+
+// purgeHandler handles POST /cache/purge
+func (h *handlers) purgeHandler(w http.ResponseWriter, r *http.Request) {
+    txns, ok := supported[lrucache.Transactional](w, h.cache)
+    if !ok {
+        return
+    }
+    var req PurgeRequest
+    if !h.decodeJSON(w, r, &req) {
+        return
+    }
+    if len(req.Patterns) == 0 {
+        writeError(w, http.StatusBadRequest, codeBadRequest, "patterns must list at least one pattern")
+        return
+    }
+    span := startSpan(r.Context(), "cache.purge")
+    span.SetAttributes(attribute.Int("cache.patterns", len(req.Patterns)))
+    n, err := txns.Purge(req.Patterns...)
+    span.SetAttributes(attribute.Int("cache.keys", n))
+    span.End()
+    if err != nil {
+        writeCacheError(w, err)
+        return
+    }
+    for _, pattern := range req.Patterns {
+        audit(httpCaller("http", r), "purge", pattern)
+    }
+    writeJSON(w, PurgeResponse{Purged: n})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// Levels a proxied request asks for with ?quorum=; ?consistency= is left
+// for the backends, where replicas read it as primary or stale
+const (
+    levelOne    = "one"    // The first replica to answer
+    levelQuorum = "quorum" // A majority of the replicas
+    levelAll    = "all"    // Every replica
+)
+
+var errEjected = errors.New("backend ejected")
+
+// replicaReply is one replica's answer, read in full so the request can be
+// answered before slower replicas are
+type replicaReply struct {
+    status int
+    header http.Header
+    body   []byte
+    err    error
+}
+
+// quorumLevel returns the level r asks for, or def; it answers 400 and
+// returns false for an unknown one
+func quorumLevel(w http.ResponseWriter, r *http.Request, def string) (string, bool) {
+    switch level := strings.ToLower(r.URL.Query().Get("quorum")); level {
+    case "":
+        return def, true
+    case levelOne, levelQuorum, levelAll:
+        return level, true
+    }
+    writeError(w, http.StatusBadRequest, codeBadRequest, "quorum must be one, quorum or all")
+    return "", false
+}
+
+// needed is how many of n replicas must answer at level; always at least
+// one, so that a request reaching no replica fails
+func needed(level string, n int) int {
+    switch level {
+    case levelOne:
+        return 1
+    case levelAll:
+        return max(n, 1)
+    }
+    return n/2 + 1
+}
+
+// proxyURI is the request URI of r without the quorum parameter, which only
+// the proxy acts on
+func proxyURI(r *http.Request) string {
+    query := r.URL.Query()
+    if !query.Has("quorum") {
+        return r.URL.RequestURI()
+    }
+    query.Del("quorum")
+    u := *r.URL
+    u.RawQuery = query.Encode()
+    return u.RequestURI()
+}
+
+// replicasOf returns the backends holding key: the first on the ring for it
+func (p *Proxy) replicasOf(key string) []*backend {
+    p.mutex.RLock()
+    defer p.mutex.RUnlock()
+    var out []*backend
+    for _, u := range p.ring.Successors(key, p.replicas) {
+        out = append(out, p.backends[u])
+    }
+    return out
+}
+
+// replicate sends r to every replica of key and answers as soon as the
+// level's share of them has: with the latest value for a read, with the
+// first success for a write. Replicas that miss a write get it as a hint.
+func (p *Proxy) replicate(w http.ResponseWriter, r *http.Request, key string, body []byte, level string) {
+    replicas := p.replicasOf(key)
+    need := needed(level, len(replicas))
+    method, uri, header := r.Method, proxyURI(r), r.Header.Clone()
+    // Replicas still answering after the response is sent finish their requests
+    ctx := context.WithoutCancel(r.Context())
+    replies := make(chan replicaReply, len(replicas))
+    now := time.Now().UnixNano()
+    for _, b := range replicas {
+        go func() {
+            reply := replicaReply{err: errEjected}
+            if b.ejectedUntil.Load() <= now {
+                reply = p.ask(ctx, header, b, method, uri, body)
+            }
+            if method != http.MethodGet {
+                if reply.err != nil {
+                    p.hints.wrote(b, nil, key, method, uri, header, body)
+                } else if reply.status < http.StatusBadRequest {
+                    p.hints.wrote(b, b, key, method, uri, header, body)
+                }
+            }
+            replies <- reply
+        }()
+    }
+
+    var answered []replicaReply
+    failed := 0
+    for len(answered) < need && len(replicas)-failed >= need {
+        if reply := <-replies; reply.err != nil {
+            failed++
+        } else {
+            answered = append(answered, reply)
+        }
+    }
+    if len(answered) < need {
+        writeError(w, http.StatusBadGateway, codeUnavailable, fmt.Sprintf("%d of %d replicas answered but %s needs %d; try again shortly", len(replicas)-failed, len(replicas), level, need))
+        return
+    }
+    reply := answered[0]
+    for _, other := range answered[1:] {
+        if method == http.MethodGet {
+            // The latest write wins; a replica that has the key beats one that does not
+            if replicaFound(other.status) && (!replicaFound(reply.status) || other.modified().After(reply.modified())) {
+                reply = other
+            }
+        } else if other.status < http.StatusBadRequest && reply.status >= http.StatusBadRequest {
+            reply = other
+        }
+    }
+    for _, h := range proxyResponseHeaders {
+        if v := reply.header.Get(h); v != "" {
+            w.Header().Set(h, v)
+        }
+    }
+    w.WriteHeader(reply.status)
+    w.Write(reply.body)
+}
+
+// replicaFound reports whether a replica's status means it had the key, answering
+// with all of the value or the range asked for
+func replicaFound(status int) bool {
+    return status == http.StatusOK || status == http.StatusPartialContent
+}
+
+// modified is when the replica's value was written, from its X-Modified header
+func (r replicaReply) modified() time.Time {
+    t, _ := time.Parse(time.RFC3339Nano, r.header.Get("X-Modified"))
+    return t
+}
+
+// ask sends one request to a replica and reads the whole answer
+func (p *Proxy) ask(ctx context.Context, header http.Header, b *backend, method, uri string, body []byte) replicaReply {
+    resp, err := p.send(ctx, header, b, method, uri, body)
+    if err != nil {
+        return replicaReply{err: err}
+    }
+    defer resp.Body.Close()
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return replicaReply{err: err}
+    }
+    return replicaReply{status: resp.StatusCode, header: resp.Header, body: data}
+}
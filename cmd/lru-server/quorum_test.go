@@ -0,0 +1,147 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// testBackend is a cache node behind the proxy that answers every read with
+// its value, written at modified, and records the queries it receives
+type testBackend struct {
+    *httptest.Server
+    mutex   sync.Mutex
+    queries []string
+}
+
+func startBackend(t *testing.T, value string, modified time.Time, status int) *testBackend {
+    t.Helper()
+    b := &testBackend{}
+    b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        b.mutex.Lock()
+        b.queries = append(b.queries, r.URL.RawQuery)
+        b.mutex.Unlock()
+        w.Header().Set("X-Modified", modified.Format(time.RFC3339Nano))
+        w.WriteHeader(status)
+        w.Write([]byte(value))
+    }))
+    t.Cleanup(b.Close)
+    return b
+}
+
+func (b *testBackend) lastQuery() string {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    if len(b.queries) == 0 {
+        return ""
+    }
+    return b.queries[len(b.queries)-1]
+}
+
+// testProxy routes to backends, each key held by all of them
+func testProxy(t *testing.T, backends ...*testBackend) *Proxy {
+    t.Helper()
+    var urls []string
+    for _, b := range backends {
+        urls = append(urls, b.URL)
+    }
+    p, err := NewProxy(&Config{
+        ProxyBackends:   urls,
+        ProxyReplicas:   3,
+        ProxyTimeout:    time.Second,
+        ProxyReadLevel:  levelQuorum,
+        ProxyWriteLevel: levelQuorum,
+        MaxValueBytes:   64,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    return p
+}
+
+func TestNeeded(t *testing.T) {
+    tests := []struct {
+        level string
+        n     int
+        want  int
+    }{
+        {levelOne, 3, 1},
+        {levelQuorum, 3, 2},
+        {levelQuorum, 4, 3},
+        {levelAll, 3, 3},
+        {levelOne, 0, 1},
+        {levelQuorum, 0, 1},
+        {levelAll, 0, 1},
+    }
+    for _, tt := range tests {
+        if got := needed(tt.level, tt.n); got != tt.want {
+            t.Errorf("needed(%s, %d) = %d, want %d", tt.level, tt.n, got, tt.want)
+        }
+    }
+}
+
+func TestProxyQuorumReads(t *testing.T) {
+    now := time.Now()
+    older := startBackend(t, "old", now.Add(-time.Minute), http.StatusOK)
+    newer := startBackend(t, "new", now, http.StatusOK)
+    down := startBackend(t, "", now, http.StatusServiceUnavailable)
+    p := testProxy(t, older, newer, down)
+
+    tests := []struct {
+        query      string
+        wantStatus int
+        wantBody   string
+    }{
+        {"", http.StatusOK, "new"},
+        {"quorum=quorum", http.StatusOK, "new"},
+        {"quorum=all", http.StatusBadGateway, ""},
+        {"quorum=bogus", http.StatusBadRequest, ""},
+        {"quorum=quorum&consistency=stale", http.StatusOK, "new"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            r := httptest.NewRequest(http.MethodGet, "/v1/cache/k?"+tt.query, nil)
+            w := httptest.NewRecorder()
+            p.forward(w, r, "k", nil)
+            if w.Code != tt.wantStatus {
+                t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.wantStatus)
+            }
+            if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+                t.Fatalf("body = %q, want %q", w.Body, tt.wantBody)
+            }
+        })
+    }
+
+    // ?consistency= belongs to the replicas, so it reaches them; ?quorum= does not
+    if q := newer.lastQuery(); q != "consistency=stale" {
+        t.Fatalf("backend query = %q, want consistency=stale passed on without quorum", q)
+    }
+}
+
+func TestProxyQuorumWithoutBackends(t *testing.T) {
+    p := testProxy(t)
+    for _, level := range []string{levelOne, levelQuorum, levelAll} {
+        r := httptest.NewRequest(http.MethodGet, "/v1/cache/k?quorum="+level, nil)
+        w := httptest.NewRecorder()
+        p.forward(w, r, "k", nil)
+        if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), codeUnavailable) {
+            t.Fatalf("quorum=%s without backends = %d %s, want 502", level, w.Code, w.Body)
+        }
+    }
+}
+
+func TestProxyMultiGetWithoutBackends(t *testing.T) {
+    old := proxy
+    proxy = testProxy(t)
+    t.Cleanup(func() { proxy = old })
+
+    r := httptest.NewRequest(http.MethodGet, "/cache?key=a&key=b&keys=", nil)
+    w := httptest.NewRecorder()
+    proxyGetCacheHandler(w, r)
+    if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), codeUnavailable) {
+        t.Fatalf("multi-key read without backends = %d %s, want 502", w.Code, w.Body)
+    }
+}
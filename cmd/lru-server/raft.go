@@ -0,0 +1,689 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "crypto/cipher"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/hashicorp/go-hclog"
+    "github.com/hashicorp/raft"
+
+    "lru-cache/lrucache"
+)
+
+// Raft tuning
+const (
+    raftTimeout   = 10 * time.Second // How long a change or a linearizable read waits for the cluster
+    raftSnapshots = 2                // Snapshots kept in -raft-dir
+    raftIdleConns = 8                // Idle read-index connections kept to the leader
+    // raftReadIndex starts a read-index request on the Raft port; Raft's own
+    // connections start with a small RPC type instead
+    raftReadIndex byte = 'R'
+)
+
+// consensus is nil unless -raft-listen is set
+var consensus *raftNode
+
+// raftNode commits every change to the cache through a Raft log before
+// applying it, and makes reads on any member linearizable
+type raftNode struct {
+    id         string
+    addr       string // Advertised Raft address
+    staleReads bool
+    raft       *raft.Raft
+    fsm        *raftFSM
+    layer      *raftLayer
+    store      *raftStore
+    transport  *raft.NetworkTransport
+    idle       chan leaderConn // Read-index connections to the leader
+
+    mutex      sync.Mutex // Guards ready and generation
+    ready      bool       // This node leads and has applied every entry of earlier terms
+    generation int
+    done       chan struct{}
+    closeOnce  sync.Once
+}
+
+// newRaftNode opens the log in cfg.RaftDir, bootstrapping the cluster from
+// cfg.RaftPeers if the directory is new, and joins the group
+func newRaftNode(cfg *Config, cache *lrucache.LRUCache) (*raftNode, error) {
+    aead := newAEAD(cfg.EncryptionKey)
+    advertise, err := raftAdvertise(cfg.RaftListen, cfg.RaftAdvertise)
+    if err != nil {
+        return nil, err
+    }
+    id := cfg.RaftID
+    if id == "" {
+        id = advertise.String()
+    }
+    if err := os.MkdirAll(cfg.RaftDir, 0700); err != nil {
+        return nil, err
+    }
+    logger := hclog.New(&hclog.LoggerOptions{
+        Name:       "raft",
+        Level:      hclog.LevelFromString(cfg.LogLevel.String()),
+        JSONFormat: cfg.LogFormat == "json",
+        Output:     os.Stderr,
+    })
+
+    n := &raftNode{
+        id:         id,
+        addr:       advertise.String(),
+        staleReads: cfg.RaftStaleReads,
+        fsm:        newRaftFSM(cache, aead),
+        idle:       make(chan leaderConn, raftIdleConns),
+        done:       make(chan struct{}),
+    }
+    if n.store, err = openRaftStore(filepath.Join(cfg.RaftDir, "raft.db"), aead); err != nil {
+        return nil, err
+    }
+    snapshots, err := raft.NewFileSnapshotStoreWithLogger(cfg.RaftDir, raftSnapshots, logger)
+    if err != nil {
+        n.store.Close()
+        return nil, err
+    }
+    listener, err := net.Listen("tcp", cfg.RaftListen)
+    if err != nil {
+        n.store.Close()
+        return nil, err
+    }
+    n.layer = newRaftLayer(listener, advertise, n.serveReadIndex)
+    n.transport = raft.NewNetworkTransportWithLogger(n.layer, 3, raftTimeout, logger)
+
+    config := raft.DefaultConfig()
+    config.LocalID = raft.ServerID(id)
+    config.Logger = logger
+    notify := make(chan bool, 1)
+    config.NotifyCh = notify
+
+    existing, err := raft.HasExistingState(n.store, n.store, snapshots)
+    if err == nil && !existing && len(cfg.RaftPeers) > 0 {
+        var servers []raft.Server
+        servers, err = raftServers(cfg.RaftPeers)
+        if err == nil {
+            err = raft.BootstrapCluster(config, n.store, n.store, snapshots, n.transport, raft.Configuration{Servers: servers})
+        }
+    }
+    if err == nil {
+        n.raft, err = raft.NewRaft(config, n.fsm, n.store, n.store, snapshots, n.transport)
+    }
+    if err != nil {
+        n.transport.Close()
+        n.store.Close()
+        return nil, err
+    }
+    go n.watchLeadership(notify)
+    return n, nil
+}
+
+// raftAdvertise resolves the address other members reach this node at,
+// which defaults to the listen address when that names a host
+func raftAdvertise(listen, advertise string) (*net.TCPAddr, error) {
+    if advertise == "" {
+        advertise = listen
+    }
+    addr, err := net.ResolveTCPAddr("tcp", advertise)
+    if err != nil {
+        return nil, fmt.Errorf("-raft-advertise: %w", err)
+    }
+    if addr.IP == nil || addr.IP.IsUnspecified() {
+        return nil, errors.New("-raft-advertise must name a host other members can reach when -raft-listen does not")
+    }
+    return addr, nil
+}
+
+// raftServers parses -raft-peers entries of the form id=host:port
+func raftServers(peers []string) ([]raft.Server, error) {
+    var servers []raft.Server
+    for _, peer := range peers {
+        id, addr, ok := strings.Cut(peer, "=")
+        if !ok || id == "" || addr == "" {
+            return nil, fmt.Errorf("-raft-peers entry %q is not id=host:port", peer)
+        }
+        servers = append(servers, raft.Server{Suffrage: raft.Voter, ID: raft.ServerID(id), Address: raft.ServerAddress(addr)})
+    }
+    return servers, nil
+}
+
+// watchLeadership tracks whether this node may answer reads as the leader: a
+// new leader first commits a barrier so that it has applied what earlier
+// leaders committed
+func (n *raftNode) watchLeadership(notify <-chan bool) {
+    for {
+        select {
+        case leader := <-notify:
+            n.mutex.Lock()
+            n.ready = false
+            n.generation++
+            generation := n.generation
+            n.mutex.Unlock()
+            if leader {
+                slog.Info("raft: elected leader", "id", n.id)
+                go n.awaitReady(generation)
+            }
+        case <-n.done:
+            return
+        }
+    }
+}
+
+func (n *raftNode) awaitReady(generation int) {
+    if err := n.raft.Barrier(raftTimeout).Error(); err != nil {
+        slog.Warn("raft: leader barrier failed", "err", err)
+        return
+    }
+    n.mutex.Lock()
+    defer n.mutex.Unlock()
+    if n.generation == generation {
+        n.ready = true
+    }
+}
+
+func (n *raftNode) isReady() bool {
+    n.mutex.Lock()
+    defer n.mutex.Unlock()
+    return n.ready
+}
+
+// Propose commits m through the log; it fails on followers
+func (n *raftNode) Propose(ctx context.Context, m lrucache.Mutation) (lrucache.MutationResult, error) {
+    data, err := json.Marshal(m)
+    if err != nil {
+        return lrucache.MutationResult{}, err
+    }
+    f := n.raft.Apply(data, raftTimeout)
+    committed := make(chan error, 1)
+    go func() { committed <- f.Error() }()
+    select {
+    case err := <-committed:
+        if err != nil {
+            return lrucache.MutationResult{}, err
+        }
+    case <-ctx.Done():
+        return lrucache.MutationResult{}, ctx.Err()
+    }
+    res, _ := f.Response().(lrucache.MutationResult)
+    return res, nil
+}
+
+// gate refuses writes on followers and, unless -raft-stale-reads is set,
+// holds every read back until this node has applied all that was committed
+// when the read arrived
+func (n *raftNode) gate(write bool) error {
+    if write {
+        if n.raft.State() != raft.Leader {
+            return errReadOnly
+        }
+        return nil
+    }
+    if n.staleReads {
+        return nil
+    }
+    index, err := n.readIndex()
+    if err == nil {
+        err = n.fsm.waitApplied(index, raftTimeout)
+    }
+    if err != nil {
+        slog.Debug("raft: linearizable read failed", "err", err)
+        return errNoLeader
+    }
+    return nil
+}
+
+// readIndex returns the index this node must have applied before reading: the
+// leader's applied index, taken once it has confirmed it still leads
+func (n *raftNode) readIndex() (uint64, error) {
+    if n.raft.State() == raft.Leader {
+        return n.leaderReadIndex()
+    }
+    addr, _ := n.raft.LeaderWithID()
+    if addr == "" {
+        return 0, errNoLeader
+    }
+    conn, err := n.dialLeader(string(addr))
+    if err != nil {
+        return 0, err
+    }
+    conn.SetDeadline(time.Now().Add(raftTimeout))
+    var reply [9]byte
+    if _, err := conn.Write([]byte{raftReadIndex}); err != nil {
+        conn.Close()
+        return 0, err
+    }
+    if _, err := io.ReadFull(conn, reply[:]); err != nil {
+        conn.Close()
+        return 0, err
+    }
+    n.releaseConn(conn)
+    if reply[0] != 0 {
+        return 0, errNoLeader
+    }
+    return binary.BigEndian.Uint64(reply[1:]), nil
+}
+
+func (n *raftNode) leaderReadIndex() (uint64, error) {
+    if !n.isReady() {
+        return 0, errNoLeader
+    }
+    if err := n.raft.VerifyLeader().Error(); err != nil {
+        return 0, err
+    }
+    return n.fsm.applied(), nil
+}
+
+// leaderConn is a read-index connection to the leader at addr
+type leaderConn struct {
+    net.Conn
+    addr string
+}
+
+// dialLeader reuses an idle read-index connection if it still goes to addr
+func (n *raftNode) dialLeader(addr string) (leaderConn, error) {
+    for {
+        select {
+        case conn := <-n.idle:
+            if conn.addr == addr {
+                return conn, nil
+            }
+            conn.Close() // Leadership moved
+        default:
+            conn, err := net.DialTimeout("tcp", addr, raftTimeout)
+            return leaderConn{conn, addr}, err
+        }
+    }
+}
+
+func (n *raftNode) releaseConn(conn leaderConn) {
+    select {
+    case n.idle <- conn:
+    default:
+        conn.Close()
+    }
+}
+
+// serveReadIndex answers read-index requests from followers: a status byte,
+// zero when this node is the leader, then the index as eight bytes
+func (n *raftNode) serveReadIndex(conn net.Conn, r *bufio.Reader) {
+    defer conn.Close()
+    for {
+        conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
+        if b, err := r.ReadByte(); err != nil || b != raftReadIndex {
+            return
+        }
+        var reply [9]byte
+        if n.raft.State() != raft.Leader {
+            reply[0] = 1
+        } else if index, err := n.leaderReadIndex(); err != nil {
+            reply[0] = 1
+        } else {
+            binary.BigEndian.PutUint64(reply[1:], index)
+        }
+        conn.SetWriteDeadline(time.Now().Add(raftTimeout))
+        if _, err := conn.Write(reply[:]); err != nil {
+            return
+        }
+    }
+}
+
+// ListenAndServe runs until shut down; Raft itself runs in the background
+func (n *raftNode) ListenAndServe() error {
+    <-n.done
+    return errServerClosed
+}
+
+// Shutdown leaves the group's log intact, so the node rejoins on restart
+func (n *raftNode) Shutdown(ctx context.Context) error {
+    return n.Close()
+}
+
+// Close stops Raft and closes the transport and the log
+func (n *raftNode) Close() error {
+    var err error
+    n.closeOnce.Do(func() {
+        close(n.done)
+        err = n.raft.Shutdown().Error()
+        n.transport.Close()
+        for len(n.idle) > 0 {
+            (<-n.idle).Close()
+        }
+        if cerr := n.store.Close(); err == nil {
+            err = cerr
+        }
+    })
+    return err
+}
+
+// raftLayer is the Raft transport's listener; connections starting with
+// raftReadIndex are handed to serve and never reach Raft
+type raftLayer struct {
+    net.Listener
+    advertise net.Addr
+    serve     func(net.Conn, *bufio.Reader)
+    conns     chan net.Conn
+    done      chan struct{}
+    closeOnce sync.Once
+}
+
+func newRaftLayer(l net.Listener, advertise net.Addr, serve func(net.Conn, *bufio.Reader)) *raftLayer {
+    layer := &raftLayer{Listener: l, advertise: advertise, serve: serve, conns: make(chan net.Conn), done: make(chan struct{})}
+    go layer.acceptLoop()
+    return layer
+}
+
+func (l *raftLayer) acceptLoop() {
+    for {
+        conn, err := l.Listener.Accept()
+        if err != nil {
+            select {
+            case <-l.done:
+                return
+            default:
+            }
+            if errors.Is(err, net.ErrClosed) {
+                return
+            }
+            time.Sleep(50 * time.Millisecond) // Out of file descriptors, most likely
+            continue
+        }
+        go l.route(conn)
+    }
+}
+
+// route peeks at the first byte to tell read-index requests from Raft RPCs
+func (l *raftLayer) route(conn net.Conn) {
+    r := bufio.NewReader(conn)
+    conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+    first, err := r.Peek(1)
+    if err != nil {
+        conn.Close()
+        return
+    }
+    if first[0] == raftReadIndex {
+        l.serve(conn, r)
+        return
+    }
+    conn.SetReadDeadline(time.Time{})
+    select {
+    case l.conns <- &peekedConn{Conn: conn, r: r}:
+    case <-l.done:
+        conn.Close()
+    }
+}
+
+// Accept returns the next Raft connection
+func (l *raftLayer) Accept() (net.Conn, error) {
+    select {
+    case conn := <-l.conns:
+        return conn, nil
+    case <-l.done:
+        return nil, net.ErrClosed
+    }
+}
+
+// Close stops accepting connections
+func (l *raftLayer) Close() error {
+    l.closeOnce.Do(func() { close(l.done) })
+    return l.Listener.Close()
+}
+
+// Addr is the address advertised to other members
+func (l *raftLayer) Addr() net.Addr {
+    return l.advertise
+}
+
+// Dial connects to another member
+func (l *raftLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+    return net.DialTimeout("tcp", string(address), timeout)
+}
+
+// peekedConn reads through the buffer that routing peeked into
+type peekedConn struct {
+    net.Conn
+    r *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+    return c.r.Read(p)
+}
+
+// raftFSM applies committed mutations to the cache and records the index of
+// the last one, which is what linearizable reads wait for; with an AEAD,
+// snapshots are encrypted
+type raftFSM struct {
+    cache   *lrucache.LRUCache
+    aead    cipher.AEAD
+    mutex   sync.Mutex
+    index   uint64
+    advance chan struct{} // Closed and replaced whenever index grows
+}
+
+func newRaftFSM(cache *lrucache.LRUCache, aead cipher.AEAD) *raftFSM {
+    return &raftFSM{cache: cache, aead: aead, advance: make(chan struct{})}
+}
+
+// raftSnapshot is the content of a snapshot file
+type raftSnapshot struct {
+    Index uint64              `json:"index"`
+    State lrucache.CacheState `json:"state"`
+
+    aead cipher.AEAD
+}
+
+// Apply applies one committed mutation
+func (f *raftFSM) Apply(log *raft.Log) interface{} {
+    var m lrucache.Mutation
+    res := lrucache.MutationResult{}
+    if err := json.Unmarshal(log.Data, &m); err != nil {
+        res.Err = fmt.Errorf("malformed raft entry %d: %w", log.Index, err)
+    } else {
+        res = f.cache.Apply(m)
+    }
+    f.setIndex(log.Index)
+    return res
+}
+
+// Snapshot copies the cache; Raft writes it out in the background
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+    return &raftSnapshot{Index: f.applied(), State: f.cache.State(), aead: f.aead}, nil
+}
+
+// Restore replaces the cache with a snapshot from disk or from the leader
+func (f *raftFSM) Restore(rc io.ReadCloser) error {
+    defer rc.Close()
+    var s raftSnapshot
+    if f.aead != nil {
+        data, err := io.ReadAll(rc)
+        if err != nil {
+            return err
+        }
+        if data, err = unseal(f.aead, data); err != nil {
+            return fmt.Errorf("raft snapshot: %w", err)
+        }
+        if err := json.Unmarshal(data, &s); err != nil {
+            return err
+        }
+    } else if err := json.NewDecoder(bufio.NewReader(rc)).Decode(&s); err != nil {
+        return err
+    }
+    f.cache.Restore(s.State)
+    f.setIndex(s.Index)
+    return nil
+}
+
+func (f *raftFSM) setIndex(index uint64) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.index = index
+    close(f.advance)
+    f.advance = make(chan struct{})
+}
+
+func (f *raftFSM) applied() uint64 {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    return f.index
+}
+
+// waitApplied blocks until the mutation at index has been applied
+func (f *raftFSM) waitApplied(index uint64, timeout time.Duration) error {
+    timer := time.NewTimer(timeout)
+    defer timer.Stop()
+    for {
+        f.mutex.Lock()
+        reached, advance := f.index >= index, f.advance
+        f.mutex.Unlock()
+        if reached {
+            return nil
+        }
+        select {
+        case <-advance:
+        case <-timer.C:
+            return fmt.Errorf("timed out waiting for raft index %d", index)
+        }
+    }
+}
+
+// Persist writes the snapshot as JSON, encrypted as a whole with an AEAD
+func (s *raftSnapshot) Persist(sink raft.SnapshotSink) error {
+    if s.aead != nil {
+        data, err := json.Marshal(s)
+        if err == nil {
+            _, err = sink.Write(seal(s.aead, data))
+        }
+        if err != nil {
+            sink.Cancel()
+            return err
+        }
+        return sink.Close()
+    }
+    w := bufio.NewWriter(sink)
+    if err := json.NewEncoder(w).Encode(s); err != nil {
+        sink.Cancel()
+        return err
+    }
+    if err := w.Flush(); err != nil {
+        sink.Cancel()
+        return err
+    }
+    return sink.Close()
+}
+
+// Release is a no-op; the snapshot holds a copy of the cache
+func (s *raftSnapshot) Release() {}
+
+// RaftPeer is a member of the Raft group
+type RaftPeer struct {
+    ID      string `json:"id"`
+    Address string `json:"address"`
+    Voter   bool   `json:"voter"`
+}
+
+// RaftStatus is returned by GET /admin/raft and published as expvar "raft"
+type RaftStatus struct {
+    ID           string     `json:"id"`
+    Address      string     `json:"address"`
+    State        string     `json:"state"`
+    LeaderID     string     `json:"leader_id,omitempty"`
+    LeaderAddr   string     `json:"leader_address,omitempty"`
+    AppliedIndex uint64     `json:"applied_index"`
+    CommitIndex  uint64     `json:"commit_index"`
+    Peers        []RaftPeer `json:"peers"`
+}
+
+// RaftPeerRequest is the body of POST /admin/raft/peers
+type RaftPeerRequest struct {
+    ID      string `json:"id"`
+    Address string `json:"address"`
+}
+
+// status describes this node and the group as it knows it
+func (n *raftNode) status() RaftStatus {
+    addr, id := n.raft.LeaderWithID()
+    s := RaftStatus{
+        ID:           n.id,
+        Address:      n.addr,
+        State:        n.raft.State().String(),
+        LeaderID:     string(id),
+        LeaderAddr:   string(addr),
+        AppliedIndex: n.fsm.applied(),
+        CommitIndex:  n.raft.CommitIndex(),
+        Peers:        []RaftPeer{},
+    }
+    if f := n.raft.GetConfiguration(); f.Error() == nil {
+        for _, server := range f.Configuration().Servers {
+            s.Peers = append(s.Peers, RaftPeer{ID: string(server.ID), Address: string(server.Address), Voter: server.Suffrage == raft.Voter})
+        }
+    }
+    return s
+}
+
+// raftFollower refuses an admin change on a Raft follower, which cannot commit
+// it, and reports whether it did
+func raftFollower(w http.ResponseWriter) bool {
+    if consensus == nil || consensus.raft.State() == raft.Leader {
+        return false
+    }
+    writeError(w, http.StatusForbidden, codeReadOnly, readOnlyMessage)
+    return true
+}
+
+// raftEnabled writes a 404 when Raft is disabled and reports whether it is enabled
+func raftEnabled(w http.ResponseWriter) bool {
+    if consensus == nil {
+        writeError(w, http.StatusNotFound, codeNotFound, "Raft is disabled; set -raft-listen")
+        return false
+    }
+    return true
+}
+
+// raftStatusHandler handles GET /admin/raft
+func raftStatusHandler(w http.ResponseWriter, r *http.Request) {
+    if raftEnabled(w) {
+        writeJSON(w, consensus.status())
+    }
+}
+
+// raftAddPeerHandler handles POST /admin/raft/peers, adding a voter
+func raftAddPeerHandler(w http.ResponseWriter, r *http.Request) {
+    if !raftEnabled(w) || raftFollower(w) {
+        return
+    }
+    var req RaftPeerRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" || req.Address == "" {
+        writeError(w, http.StatusBadRequest, codeBadRequest, "id and address are required")
+        return
+    }
+    if err := consensus.raft.AddVoter(raft.ServerID(req.ID), raft.ServerAddress(req.Address), 0, raftTimeout).Error(); err != nil {
+        writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Adding the peer failed: "+err.Error())
+        return
+    }
+    slog.Info("raft: peer added", "id", req.ID, "address", req.Address)
+    writeJSON(w, consensus.status())
+}
+
+// raftRemovePeerHandler handles DELETE /admin/raft/peers/{id}
+func raftRemovePeerHandler(w http.ResponseWriter, r *http.Request) {
+    if !raftEnabled(w) || raftFollower(w) {
+        return
+    }
+    id := r.PathValue("id")
+    if err := consensus.raft.RemoveServer(raft.ServerID(id), 0, raftTimeout).Error(); err != nil {
+        writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Removing the peer failed: "+err.Error())
+        return
+    }
+    slog.Info("raft: peer removed", "id", id)
+    writeJSON(w, consensus.status())
+}
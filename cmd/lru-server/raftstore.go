@@ -0,0 +1,159 @@
+package main
+
+import (
+    "crypto/cipher"
+    "encoding/binary"
+    "encoding/json"
+    "fmt"
+
+    "github.com/hashicorp/raft"
+    bolt "go.etcd.io/bbolt"
+)
+
+var (
+    raftLogsBucket   = []byte("logs")
+    raftStableBucket = []byte("stable")
+)
+
+// raftStore keeps the Raft log and the node's term and vote in a bbolt file,
+// so a restarted node neither loses committed entries nor votes twice; with
+// an AEAD, log entries are encrypted
+type raftStore struct {
+    db   *bolt.DB
+    aead cipher.AEAD
+}
+
+// openRaftStore opens or creates the store at path, encrypting log entries
+// with aead unless it is nil
+func openRaftStore(path string, aead cipher.AEAD) (*raftStore, error) {
+    db, err := bolt.Open(path, 0600, nil)
+    if err != nil {
+        return nil, err
+    }
+    err = db.Update(func(tx *bolt.Tx) error {
+        if _, err := tx.CreateBucketIfNotExists(raftLogsBucket); err != nil {
+            return err
+        }
+        _, err := tx.CreateBucketIfNotExists(raftStableBucket)
+        return err
+    })
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+    return &raftStore{db: db, aead: aead}, nil
+}
+
+// Close closes the file
+func (s *raftStore) Close() error {
+    return s.db.Close()
+}
+
+func indexKey(index uint64) []byte {
+    return binary.BigEndian.AppendUint64(nil, index)
+}
+
+// FirstIndex returns the index of the oldest entry, or zero if there are none
+func (s *raftStore) FirstIndex() (uint64, error) {
+    var index uint64
+    err := s.db.View(func(tx *bolt.Tx) error {
+        if k, _ := tx.Bucket(raftLogsBucket).Cursor().First(); k != nil {
+            index = binary.BigEndian.Uint64(k)
+        }
+        return nil
+    })
+    return index, err
+}
+
+// LastIndex returns the index of the newest entry, or zero if there are none
+func (s *raftStore) LastIndex() (uint64, error) {
+    var index uint64
+    err := s.db.View(func(tx *bolt.Tx) error {
+        if k, _ := tx.Bucket(raftLogsBucket).Cursor().Last(); k != nil {
+            index = binary.BigEndian.Uint64(k)
+        }
+        return nil
+    })
+    return index, err
+}
+
+// GetLog reads the entry at index into log
+func (s *raftStore) GetLog(index uint64, log *raft.Log) error {
+    return s.db.View(func(tx *bolt.Tx) error {
+        v := tx.Bucket(raftLogsBucket).Get(indexKey(index))
+        if v == nil {
+            return raft.ErrLogNotFound
+        }
+        v, err := unseal(s.aead, v)
+        if err != nil {
+            return fmt.Errorf("raft log entry %d: %w", index, err)
+        }
+        return json.Unmarshal(v, log)
+    })
+}
+
+// StoreLog appends one entry
+func (s *raftStore) StoreLog(log *raft.Log) error {
+    return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs appends entries in a single transaction
+func (s *raftStore) StoreLogs(logs []*raft.Log) error {
+    return s.db.Update(func(tx *bolt.Tx) error {
+        b := tx.Bucket(raftLogsBucket)
+        for _, log := range logs {
+            v, err := json.Marshal(log)
+            if err != nil {
+                return err
+            }
+            if err := b.Put(indexKey(log.Index), seal(s.aead, v)); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// DeleteRange removes the entries from min to max inclusive
+func (s *raftStore) DeleteRange(min, max uint64) error {
+    return s.db.Update(func(tx *bolt.Tx) error {
+        c := tx.Bucket(raftLogsBucket).Cursor()
+        for k, _ := c.Seek(indexKey(min)); k != nil && binary.BigEndian.Uint64(k) <= max; k, _ = c.Next() {
+            if err := c.Delete(); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// Set stores a value under key
+func (s *raftStore) Set(key []byte, val []byte) error {
+    return s.db.Update(func(tx *bolt.Tx) error {
+        return tx.Bucket(raftStableBucket).Put(key, val)
+    })
+}
+
+// Get returns the value of key, or an empty slice if it is not set
+func (s *raftStore) Get(key []byte) ([]byte, error) {
+    var val []byte
+    err := s.db.View(func(tx *bolt.Tx) error {
+        val = append([]byte(nil), tx.Bucket(raftStableBucket).Get(key)...)
+        return nil
+    })
+    return val, err
+}
+
+// SetUint64 stores an integer under key
+func (s *raftStore) SetUint64(key []byte, val uint64) error {
+    return s.Set(key, indexKey(val))
+}
+
+// GetUint64 returns the integer under key, or zero if it is not set
+func (s *raftStore) GetUint64(key []byte) (uint64, error) {
+    val, err := s.Get(key)
+    if err != nil || len(val) != 8 {
+        return 0, err
+    }
+    return binary.BigEndian.Uint64(val), nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+    "math"
+    "net"
+    "net/http"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// tokenBucket is a classic token bucket refilled continuously at rate tokens per second
+type tokenBucket struct {
+    rate   float64
+    burst  float64
+    tokens float64
+    last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+    if burst < 1 {
+        burst = 1
+    }
+    return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// take consumes one token, returning how long to wait when none is available
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+    if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+        b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+        b.last = now
+    }
+    if b.tokens >= 1 {
+        b.tokens--
+        return true, 0
+    }
+    wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+    return false, wait
+}
+
+// refund gives back a token taken for a request that was refused anyway
+func (b *tokenBucket) refund() {
+    b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+// clientBucket tracks a per-client bucket and when it was last used
+type clientBucket struct {
+    bucket   *tokenBucket
+    lastSeen time.Time
+}
+
+// RateLimiter enforces a global and a per-client-IP request rate
+type RateLimiter struct {
+    mutex       sync.Mutex
+    global      *tokenBucket
+    clientRate  float64
+    clientBurst int
+    clients     map[string]*clientBucket
+    cleaning    bool        // Whether cleanup runs
+    enabled     atomic.Bool // Whether either limit is set, read without the mutex
+}
+
+// NewRateLimiter creates a RateLimiter; a rate of zero disables that limit
+func NewRateLimiter(rate float64, burst int, clientRate float64, clientBurst int) *RateLimiter {
+    rl := &RateLimiter{clients: make(map[string]*clientBucket)}
+    rl.SetLimits(rate, burst, clientRate, clientBurst)
+    return rl
+}
+
+// SetLimits changes the limits as NewRateLimiter sets them, starting every
+// bucket full again
+func (rl *RateLimiter) SetLimits(rate float64, burst int, clientRate float64, clientBurst int) {
+    rl.mutex.Lock()
+    defer rl.mutex.Unlock()
+
+    rl.global = nil
+    if rate > 0 {
+        rl.global = newTokenBucket(rate, burst, time.Now())
+    }
+    rl.clientRate, rl.clientBurst = clientRate, clientBurst
+    clear(rl.clients)
+    rl.enabled.Store(rate > 0 || clientRate > 0)
+    if clientRate > 0 && !rl.cleaning {
+        rl.cleaning = true
+        go rl.cleanup(time.Minute)
+    }
+}
+
+// Allow reports whether a request from the given client may proceed. The
+// client's bucket is checked first, so that a client over its own limit does
+// not use up the global one; a request the global limit refuses gets the
+// client's token back.
+func (rl *RateLimiter) Allow(client string) (bool, time.Duration) {
+    rl.mutex.Lock()
+    defer rl.mutex.Unlock()
+
+    now := time.Now()
+    var cb *clientBucket
+    if rl.clientRate > 0 {
+        var found bool
+        if cb, found = rl.clients[client]; !found {
+            cb = &clientBucket{bucket: newTokenBucket(rl.clientRate, rl.clientBurst, now)}
+            rl.clients[client] = cb
+        }
+        cb.lastSeen = now
+        if ok, wait := cb.bucket.take(now); !ok {
+            return false, wait
+        }
+    }
+    if rl.global != nil {
+        if ok, wait := rl.global.take(now); !ok {
+            if cb != nil {
+                cb.bucket.refund()
+            }
+            return false, wait
+        }
+    }
+    return true, 0
+}
+
+// cleanup periodically forgets clients that have been idle for a while
+func (rl *RateLimiter) cleanup(interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for now := range ticker.C {
+        rl.mutex.Lock()
+        for client, cb := range rl.clients {
+            if now.Sub(cb.lastSeen) > interval {
+                delete(rl.clients, client)
+            }
+        }
+        rl.mutex.Unlock()
+    }
+}
+
+// Middleware rejects requests over the limit with 429 Too Many Requests
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !rl.enabled.Load() {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if ok, wait := rl.Allow(clientIP(r)); !ok {
+            seconds := int(math.Ceil(wait.Seconds()))
+            w.Header().Set("Retry-After", strconv.Itoa(seconds))
+            writeError(w, http.StatusTooManyRequests, codeRateLimited, "Too many requests")
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// clientIP returns the IP address of the remote end of the request
+func clientIP(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
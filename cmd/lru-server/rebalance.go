@@ -0,0 +1,302 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/hashicorp/memberlist"
+
+    "lru-cache/lrucache"
+)
+
+// Rebalancing tuning
+const (
+    rebalanceMessage byte = 'M'             // Gossip message kind: a JSON array of entries handed to their owner
+    rebalanceSettle       = 2 * time.Second // Quiet time after a change before keys move, so joins in quick succession move them once
+    rebalanceBatch        = 256 << 10       // Payload bytes sent to one member at a time
+)
+
+// rebalancer is nil unless -gossip-rebalance is set
+var rebalancer *Rebalancer
+
+// Rebalancer hands the entries this node holds for keys another member now
+// owns to that member when the ring changes, so a node joining starts warm
+// instead of missing every key it took over
+type Rebalancer struct {
+    cache    *lrucache.LRUCache
+    members  *Membership
+    rate     int // Entries sent per second
+    changed  chan struct{}
+    passes   atomic.Uint64
+    moved    atomic.Uint64
+    received atomic.Uint64
+    failed   atomic.Uint64
+    drain    chan struct{} // Signalled once by POST /admin/drain
+    done     chan struct{}
+    stopped  chan struct{}
+    stopOnce sync.Once
+
+    drainMutex  sync.Mutex // Guards drainStatus
+    drainStatus DrainStatus
+}
+
+// RebalanceStatus is published as expvar "rebalance"
+type RebalanceStatus struct {
+    Passes   uint64 `json:"passes"`
+    Moved    uint64 `json:"moved"`    // Entries handed to their owner and dropped here
+    Received uint64 `json:"received"` // Entries other members handed to this node
+    Failed   uint64 `json:"failed"`   // Entries kept because their owner could not be reached
+}
+
+// DrainStatus is returned by POST and GET /admin/drain
+type DrainStatus struct {
+    State     string     `json:"state"` // serving, draining or drained
+    Started   *time.Time `json:"started,omitempty"`
+    Finished  *time.Time `json:"finished,omitempty"`
+    Total     int        `json:"total"`     // Entries held when the drain started
+    Moved     uint64     `json:"moved"`     // Entries handed to their new owners so far
+    Remaining int        `json:"remaining"` // Entries still held; non-zero once drained if owners could not be reached
+}
+
+// NewRebalancer moves entries between the members of m at up to rate per second
+func NewRebalancer(cache *lrucache.LRUCache, m *Membership, rate int) *Rebalancer {
+    r := &Rebalancer{
+        cache:       cache,
+        members:     m,
+        rate:        rate,
+        changed:     make(chan struct{}, 1),
+        drain:       make(chan struct{}, 1),
+        done:        make(chan struct{}),
+        stopped:     make(chan struct{}),
+        drainStatus: DrainStatus{State: "serving"},
+    }
+    m.OnChange(func() {
+        select {
+        case r.changed <- struct{}{}:
+        default: // A pass is already due
+        }
+    })
+    m.Handle(rebalanceMessage, r.receive)
+    return r
+}
+
+// Status reports the counters
+func (r *Rebalancer) Status() RebalanceStatus {
+    return RebalanceStatus{Passes: r.passes.Load(), Moved: r.moved.Load(), Received: r.received.Load(), Failed: r.failed.Load()}
+}
+
+// receive stores the entries another member handed over, keeping any this
+// node already has since those were written here since
+func (r *Rebalancer) receive(msg []byte) {
+    var entries []lrucache.Entry
+    if err := json.Unmarshal(msg, &entries); err != nil {
+        slog.Debug("rebalance: malformed message", "err", err)
+        return
+    }
+    now := time.Now()
+    for _, e := range entries {
+        if !e.ExpiresAt.IsZero() && !e.ExpiresAt.After(now) {
+            continue
+        }
+        r.cache.StoreEntry(e, lrucache.StoreIfAbsent)
+    }
+    r.received.Add(uint64(len(entries)))
+}
+
+// ListenAndServe moves entries after every membership change, once the
+// membership has been quiet for rebalanceSettle, until shut down
+func (r *Rebalancer) ListenAndServe() error {
+    defer close(r.stopped)
+    for {
+        select {
+        case <-r.changed:
+        case <-r.drain:
+            r.drainAll()
+            continue
+        case <-r.done:
+            return errServerClosed
+        }
+        settle := time.NewTimer(rebalanceSettle)
+    settling:
+        for {
+            select {
+            case <-r.changed:
+                settle.Reset(rebalanceSettle)
+            case <-settle.C:
+                break settling
+            case <-r.done:
+                settle.Stop()
+                return errServerClosed
+            }
+        }
+        r.pass()
+    }
+}
+
+// Drain takes this node off the ring and starts handing all its entries to
+// their new owners, unless that has begun already
+func (r *Rebalancer) Drain() DrainStatus {
+    r.drainMutex.Lock()
+    if r.drainStatus.State == "serving" {
+        now := time.Now().UTC()
+        r.drainStatus = DrainStatus{State: "draining", Started: &now, Total: r.cache.Len(), Remaining: r.cache.Len()}
+        r.drain <- struct{}{}
+    }
+    r.drainMutex.Unlock()
+    return r.DrainStatus()
+}
+
+// DrainStatus reports the progress of the drain
+func (r *Rebalancer) DrainStatus() DrainStatus {
+    r.drainMutex.Lock()
+    defer r.drainMutex.Unlock()
+    s := r.drainStatus
+    if s.State == "draining" {
+        s.Remaining = r.cache.Len()
+    }
+    return s
+}
+
+// drainAll gossips that this node owns nothing, waits for the others to
+// hear it, then moves entries until none are left or a pass moves none;
+// entries written here meanwhile are moved too
+func (r *Rebalancer) drainAll() {
+    r.members.Drain()
+    slog.Info("rebalance: draining", "entries", r.cache.Len())
+    select {
+    case <-time.After(rebalanceSettle):
+    case <-r.done:
+        return
+    }
+    for r.cache.Len() > 0 {
+        moved, _ := r.pass()
+        r.drainMutex.Lock()
+        r.drainStatus.Moved += moved
+        r.drainMutex.Unlock()
+        if moved == 0 {
+            break
+        }
+        select {
+        case <-r.done:
+            return
+        default:
+        }
+    }
+    now := time.Now().UTC()
+    r.drainMutex.Lock()
+    r.drainStatus.State, r.drainStatus.Finished, r.drainStatus.Remaining = "drained", &now, r.cache.Len()
+    s := r.drainStatus
+    r.drainMutex.Unlock()
+    slog.Info("rebalance: drained", "moved", s.Moved, "remaining", s.Remaining)
+}
+
+// pass sends every entry whose key another member owns to that member and
+// drops it here once sent, returning how many were moved and kept
+func (r *Rebalancer) pass() (moved, failed uint64) {
+    r.passes.Add(1)
+    peers := make(map[string]*memberlist.Node)
+    for _, node := range r.members.Peers() {
+        peers[node.Name] = node
+    }
+    batches := make(map[string][]lrucache.Entry)
+    sizes := make(map[string]int)
+    for _, e := range r.cache.Export() {
+        owner, _ := r.members.Owner(e.Key)
+        if owner == "" || peers[owner] == nil {
+            continue
+        }
+        batches[owner] = append(batches[owner], e)
+        sizes[owner] += len(e.Key) + len(e.Value) + 64 // Room for the JSON framing
+        if sizes[owner] < rebalanceBatch {
+            continue
+        }
+        if !r.send(peers[owner], batches[owner]) {
+            failed += uint64(len(batches[owner]))
+        } else {
+            moved += uint64(len(batches[owner]))
+        }
+        delete(batches, owner)
+        delete(sizes, owner)
+        select {
+        case <-r.done:
+            return moved, failed
+        default:
+        }
+    }
+    for owner, batch := range batches {
+        if !r.send(peers[owner], batch) {
+            failed += uint64(len(batch))
+        } else {
+            moved += uint64(len(batch))
+        }
+    }
+    r.moved.Add(moved)
+    r.failed.Add(failed)
+    if moved > 0 || failed > 0 {
+        slog.Info("rebalance: handed entries to their owners", "moved", moved, "failed", failed)
+    }
+    return moved, failed
+}
+
+// send hands batch to node and, if it arrived, drops the entries here;
+// afterwards it waits long enough to keep to the rate
+func (r *Rebalancer) send(node *memberlist.Node, batch []lrucache.Entry) bool {
+    started := time.Now()
+    payload, _ := json.Marshal(batch)
+    msg := append([]byte{rebalanceMessage}, payload...)
+    if err := r.members.list.SendReliable(node, msg); err != nil {
+        slog.Warn("rebalance: cannot reach owner", "member", node.Name, "entries", len(batch), "err", err)
+        return false
+    }
+    for _, e := range batch {
+        // Invalidate rather than Delete, so -gossip-invalidate does not have the owner drop them again
+        r.cache.Invalidate(e.Key)
+    }
+    if wait := time.Duration(len(batch))*time.Second/time.Duration(r.rate) - time.Since(started); wait > 0 {
+        select {
+        case <-time.After(wait):
+        case <-r.done:
+        }
+    }
+    return true
+}
+
+// Shutdown stops moving entries, waiting for the batch in flight
+func (r *Rebalancer) Shutdown(ctx context.Context) error {
+    r.stopOnce.Do(func() { close(r.done) })
+    select {
+    case <-r.stopped:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Close stops moving entries without waiting
+func (r *Rebalancer) Close() error {
+    r.stopOnce.Do(func() { close(r.done) })
+    return nil
+}
+
+// drainHandler handles POST /admin/drain
+func drainHandler(w http.ResponseWriter, r *http.Request) {
+    if rebalancer == nil {
+        writeError(w, http.StatusNotFound, codeNotFound, "Draining moves keys with the rebalancer; set -gossip-rebalance")
+        return
+    }
+    writeJSON(w, rebalancer.Drain())
+}
+
+// drainStatusHandler handles GET /admin/drain
+func drainStatusHandler(w http.ResponseWriter, r *http.Request) {
+    if rebalancer == nil {
+        writeError(w, http.StatusNotFound, codeNotFound, "Draining moves keys with the rebalancer; set -gossip-rebalance")
+        return
+    }
+    writeJSON(w, rebalancer.DrainStatus())
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "os"
+    "reflect"
+    "slices"
+    "strconv"
+    "sync"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// reloadableSettings are the flags a reload applies, from the environment,
+// the -config file and the command line as at startup; every other setting
+// keeps the value the process started with
+var reloadableSettings = []string{"log-level", "rate-limit", "rate-burst", "client-rate-limit", "client-rate-burst", "api-keys", "tenants-file", "namespace", "janitor-interval", "default-ttl"}
+
+// ReloadResponse answers POST /admin/reload
+type ReloadResponse struct {
+    Changed []string `json:"changed"` // Such as rate-limit or namespace sessions created
+}
+
+// Reloader applies the reloadable settings to the running server, leaving
+// the cache contents and open connections alone
+type Reloader struct {
+    mutex      sync.Mutex
+    cfg        *Config // As last applied
+    cache      *lrucache.LRUCache
+    namespaces *Namespaces
+    limiter    *RateLimiter
+    auth       *Authenticator
+}
+
+// NewReloader creates a Reloader of the server started with cfg
+func NewReloader(cfg *Config, cache *lrucache.LRUCache, namespaces *Namespaces, limiter *RateLimiter, auth *Authenticator) *Reloader {
+    return &Reloader{cfg: cfg, cache: cache, namespaces: namespaces, limiter: limiter, auth: auth}
+}
+
+// Config returns the configuration as last reloaded
+func (rl *Reloader) Config() *Config {
+    rl.mutex.Lock()
+    defer rl.mutex.Unlock()
+    return rl.cfg
+}
+
+// Reload reads the settings again and applies those that changed; on an
+// error nothing is applied
+func (rl *Reloader) Reload() (ReloadResponse, error) {
+    fs, err := rereadFlags(rl.cfg.args)
+    if err != nil {
+        return ReloadResponse{}, err
+    }
+    rl.mutex.Lock()
+    defer rl.mutex.Unlock()
+    next, err := reloadedConfig(rl.cfg, fs)
+    if err != nil {
+        return ReloadResponse{}, err
+    }
+
+    resp := ReloadResponse{Changed: []string{}}
+    if next.LogLevel != logLevel.Level() {
+        logLevel.Set(next.LogLevel)
+        resp.Changed = append(resp.Changed, "log-level")
+    }
+    prev := rl.cfg
+    resp.Changed = append(resp.Changed, rl.applyLimits(prev, next)...)
+    keysChanged, tenantsChanged := !slices.Equal(next.APIKeys, prev.APIKeys), !reflect.DeepEqual(next.Tenants, prev.Tenants)
+    if keysChanged || tenantsChanged {
+        rl.auth.SetKeys(next.APIKeys, next.Tenants)
+        if keysChanged {
+            resp.Changed = append(resp.Changed, "api-keys")
+        }
+        if tenantsChanged {
+            resp.Changed = append(resp.Changed, "tenants-file")
+        }
+    }
+    resp.Changed = append(resp.Changed, rl.namespaces.Sync(next.Namespaces)...)
+    resp.Changed = append(resp.Changed, rl.applyTimers(prev, next)...)
+    rl.cfg = next
+    slog.Info("configuration reloaded", "changed", resp.Changed)
+    return resp, nil
+}
+
+// applyLimits applies the rate limits of next that differ from prev's,
+// returning the names of those that changed
+func (rl *Reloader) applyLimits(prev, next *Config) []string {
+    limits := map[string]bool{
+        "rate-limit":        next.RateLimit != prev.RateLimit,
+        "rate-burst":        next.RateBurst != prev.RateBurst,
+        "client-rate-limit": next.ClientRateLimit != prev.ClientRateLimit,
+        "client-rate-burst": next.ClientRateBurst != prev.ClientRateBurst,
+    }
+    var changed []string
+    if limits["rate-limit"] || limits["rate-burst"] || limits["client-rate-limit"] || limits["client-rate-burst"] {
+        rl.limiter.SetLimits(next.RateLimit, next.RateBurst, next.ClientRateLimit, next.ClientRateBurst)
+        for _, name := range reloadableSettings {
+            if limits[name] {
+                changed = append(changed, name)
+            }
+        }
+    }
+    return changed
+}
+
+// applyTimers applies the janitor interval and default TTL of next that
+// differ from prev's, returning the names of those that changed
+func (rl *Reloader) applyTimers(prev, next *Config) []string {
+    var changed []string
+    if next.JanitorInterval != prev.JanitorInterval {
+        rl.cache.SetJanitorInterval(next.JanitorInterval)
+        rl.namespaces.SetJanitorInterval(next.JanitorInterval)
+        changed = append(changed, "janitor-interval")
+    }
+    if next.DefaultTTL != prev.DefaultTTL {
+        rl.cache.SetDefaultTTL(next.DefaultTTL)
+        changed = append(changed, "default-ttl")
+    }
+    return changed
+}
+
+// reloadedConfig returns a copy of cfg with the reloadable settings of fs
+func reloadedConfig(cfg *Config, fs *flag.FlagSet) (*Config, error) {
+    value := func(name string) string { return fs.Lookup(name).Value.String() }
+    next := *cfg
+    var err error
+    if next.LogLevel, err = parseLogLevel(value("log-level")); err != nil {
+        return nil, err
+    }
+    for name, p := range map[string]*float64{"rate-limit": &next.RateLimit, "client-rate-limit": &next.ClientRateLimit} {
+        if *p, err = strconv.ParseFloat(value(name), 64); err != nil {
+            return nil, fmt.Errorf("-%s: invalid value %q", name, value(name))
+        }
+    }
+    for name, p := range map[string]*int{"rate-burst": &next.RateBurst, "client-rate-burst": &next.ClientRateBurst} {
+        if *p, err = strconv.Atoi(value(name)); err != nil {
+            return nil, fmt.Errorf("-%s: invalid value %q", name, value(name))
+        }
+    }
+    for name, p := range map[string]*time.Duration{"janitor-interval": &next.JanitorInterval, "default-ttl": &next.DefaultTTL} {
+        if *p, err = time.ParseDuration(value(name)); err != nil || *p < 0 {
+            return nil, fmt.Errorf("-%s: invalid value %q", name, value(name))
+        }
+    }
+    next.APIKeys = splitList(value("api-keys"))
+    if next.Namespaces, err = namespaceConfigs(&next, fs.Lookup("namespace").Value.(*rawFlag).values); err != nil {
+        return nil, err
+    }
+    if next.Tenants, err = tenantsConfig(&next, value("tenants-file")); err != nil {
+        return nil, err
+    }
+    return &next, nil
+}
+
+// rawFlag holds a flag's value as text, so that the settings can be read
+// again without touching the ones in use
+type rawFlag struct {
+    values     []string
+    repeatable bool
+    isBool     bool
+}
+
+func (f *rawFlag) String() string {
+    if len(f.values) == 0 {
+        return ""
+    }
+    return f.values[len(f.values)-1]
+}
+
+func (f *rawFlag) Set(s string) error {
+    if f.repeatable {
+        f.values = append(f.values, s)
+    } else {
+        f.values = []string{s}
+    }
+    return nil
+}
+
+func (f *rawFlag) IsBoolFlag() bool { return f.isBool }
+
+// rereadFlags reads the flags in args, the environment and the -config file
+// again, into a flag set with a rawFlag for every flag
+func rereadFlags(args []string) (*flag.FlagSet, error) {
+    fs := flag.NewFlagSet(flag.CommandLine.Name(), flag.ContinueOnError)
+    fs.SetOutput(io.Discard)
+    flag.VisitAll(func(f *flag.Flag) {
+        raw := &rawFlag{repeatable: slices.Contains(repeatableFlags, f.Name)}
+        if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok {
+            raw.isBool = b.IsBoolFlag()
+        }
+        if !raw.repeatable {
+            raw.values = []string{f.DefValue}
+        }
+        fs.Var(raw, f.Name, f.Usage)
+    })
+    if err := fs.Parse(args); err != nil {
+        return nil, err
+    }
+    explicit := explicitFlags(fs)
+    if err := applyEnv(fs, explicit); err != nil {
+        return nil, err
+    }
+    if path := fs.Lookup("config").Value.String(); path != "" {
+        if err := applyConfigFile(fs, path, explicit); err != nil {
+            return nil, fmt.Errorf("-config: %w", err)
+        }
+    }
+    return fs, nil
+}
+
+// reloadOnHangup reloads the settings with rl whenever a signal arrives on c
+func reloadOnHangup(rl *Reloader, c <-chan os.Signal) {
+    for range c {
+        if _, err := rl.Reload(); err != nil {
+            slog.Error("reload failed, keeping the running configuration", "err", err)
+        }
+    }
+}
+
+// reloadRoute is the admin endpoint doing what SIGHUP does
+func reloadRoute(h *handlers) route {
+    return route{
+        Method:  http.MethodPost,
+        Path:    "/admin/reload",
+        Summary: "Read the settings again, as on SIGHUP, and apply the log level, rate limits, API keys, tenants, namespaces, janitor interval and default TTL; other settings need a restart",
+        Handler: h.reloadHandler,
+        Response: []response{
+            {Status: http.StatusOK, Description: "The settings that changed", ContentType: "application/json", Body: ReloadResponse{}},
+            errorResponse(http.StatusBadRequest, "The settings are invalid and none were applied (BAD_REQUEST)"),
+        },
+    }
+}
+
+// reloadHandler handles POST /admin/reload
+func (h *handlers) reloadHandler(w http.ResponseWriter, r *http.Request) {
+    resp, err := h.reloader.Reload()
+    if err != nil {
+        writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+        return
+    }
+    audit(httpCaller("admin", r), "reload", "")
+    writeJSON(w, resp)
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net"
+    "net/http"
+    "net/http/httputil"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// Replication stream tuning
+const (
+    replicationBuffer   = 1 << 16 // Events a replica may lag behind before it must resync
+    replicationPing     = 10 * time.Second
+    replicationDeadline = 3 * replicationPing
+)
+
+// ReplHello is the first line a replica sends
+type ReplHello struct {
+    Token string `json:"token,omitempty"`
+}
+
+// ReplOp is one line of the replication stream: a snapshot header, a set,
+// delete, flush or generation bump, the end of the snapshot ("synced"), a ping or an error
+type ReplOp struct {
+    Op        string    `json:"op"`
+    Key       string    `json:"key,omitempty"`
+    Value     string    `json:"value,omitempty"`
+    Kind      string    `json:"kind,omitempty"`
+    Flags     uint32    `json:"flags,omitempty"`
+    ExpiresAt time.Time `json:"expires_at,omitzero"`
+    StaleAt   time.Time `json:"stale_at,omitzero"`
+    Count     int       `json:"count,omitempty"`
+    Error     string    `json:"error,omitempty"`
+    Time      time.Time `json:"time,omitzero"` // When the primary made the change or sent the ping
+}
+
+// connectedReplicas counts replicas currently streaming from this process
+var connectedReplicas atomic.Int64
+
+// newReplicationServer streams the cache to replicas: a snapshot first, then
+// every change as it happens
+func newReplicationServer(cfg *Config, cache *lrucache.LRUCache, addr string, auth *Authenticator) *tcpServer {
+    return &tcpServer{
+        Addr:     addr,
+        MaxConns: cfg.MaxConns,
+        handle: func(conn net.Conn, closing func() bool) {
+            if err := serveReplica(cache, conn, auth, closing); err != nil {
+                logConnError("replication", conn, err, closing)
+            }
+        },
+    }
+}
+
+// serveReplica authenticates a replica and streams to it until it disconnects
+func serveReplica(cache *lrucache.LRUCache, conn net.Conn, auth *Authenticator, closing func() bool) error {
+    r := bufio.NewReader(conn)
+    w := bufio.NewWriter(conn)
+    enc := json.NewEncoder(w)
+
+    conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+    line, err := readLimitedLine(r, 64<<10)
+    if err != nil {
+        return err
+    }
+    var hello ReplHello
+    if err := json.Unmarshal([]byte(line), &hello); err != nil {
+        return errors.New("malformed hello")
+    }
+    // Replicas receive every entry, so they need the admin role; Authorize is
+    // not used because a read-only replica may itself serve replicas
+    if auth.Enabled() {
+        p, err := auth.AuthenticateToken(hello.Token)
+        if err != nil || !p.Roles.Allows(RoleAdmin) {
+            enc.Encode(ReplOp{Op: "error", Error: "replication requires a token with the admin role"})
+            return w.Flush()
+        }
+    }
+    conn.SetReadDeadline(time.Time{})
+
+    // Subscribe before taking the snapshot so no change falls in between;
+    // replaying changes the snapshot already contains is harmless
+    sub := events.Subscribe("", replicationBuffer)
+    defer events.Unsubscribe(sub)
+    connectedReplicas.Add(1)
+    defer connectedReplicas.Add(-1)
+    slog.Info("replication: replica connected", "remote", conn.RemoteAddr().String())
+
+    // The replica sends nothing more; a failed read means it went away or
+    // that shutdown interrupted it
+    gone := make(chan struct{})
+    go func() {
+        r.ReadByte()
+        close(gone)
+    }()
+
+    send := func(op ReplOp) error {
+        conn.SetWriteDeadline(time.Now().Add(replicationDeadline))
+        return enc.Encode(op)
+    }
+    snapshot := cache.Export()
+    if err := send(ReplOp{Op: "snapshot", Count: len(snapshot)}); err != nil {
+        return err
+    }
+    // Send the least recently used first so the replica ends with the same order
+    for i := len(snapshot) - 1; i >= 0; i-- {
+        e := snapshot[i]
+        if err := send(ReplOp{Op: "set", Key: e.Key, Value: e.Value, Kind: string(e.Kind), Flags: e.Flags, ExpiresAt: e.ExpiresAt, StaleAt: e.StaleAt}); err != nil {
+            return err
+        }
+    }
+    if err := send(ReplOp{Op: "synced"}); err != nil {
+        return err
+    }
+
+    ticker := time.NewTicker(replicationPing)
+    defer ticker.Stop()
+    for {
+        if err := w.Flush(); err != nil {
+            return err
+        }
+        select {
+        case e := <-sub.C:
+            if sub.Dropped() > 0 {
+                return errors.New("replica fell behind; it will resync on reconnect")
+            }
+            if err := send(replOp(e)); err != nil {
+                return err
+            }
+            // Batch whatever else is queued into the same flush
+            for len(sub.C) > 0 {
+                if err := send(replOp(<-sub.C)); err != nil {
+                    return err
+                }
+            }
+        case <-ticker.C:
+            if err := send(ReplOp{Op: "ping", Time: time.Now()}); err != nil {
+                return err
+            }
+        case <-gone:
+            if !closing() {
+                slog.Info("replication: replica disconnected", "remote", conn.RemoteAddr().String())
+            }
+            return nil
+        }
+    }
+}
+
+// replOp converts a cache event; expirations and evictions replicate as
+// deletes so that replicas hold exactly what the primary holds
+func replOp(e lrucache.Event) ReplOp {
+    switch e.Type {
+    case lrucache.EventSet:
+        return ReplOp{Op: "set", Key: e.Key, Value: e.Value, Kind: string(e.Kind), Flags: e.Flags, ExpiresAt: e.ExpiresAt, StaleAt: e.StaleAt, Time: e.Time}
+    case lrucache.EventFlush:
+        return ReplOp{Op: "flush", Time: e.Time}
+    case lrucache.EventGeneration:
+        return ReplOp{Op: "generation", Key: e.Key, Time: e.Time}
+    }
+    return ReplOp{Op: "delete", Key: e.Key, Time: e.Time}
+}
+
+// replicaGate refuses writes on a replica; they would be lost at the next resync
+func replicaGate(write bool) error {
+    if write {
+        return errReadOnly
+    }
+    return nil
+}
+
+// replication is nil unless -replicate-from is set
+var replication *replicaClient
+
+// replicaClient keeps the local cache a copy of a primary's
+type replicaClient struct {
+    cache   *lrucache.LRUCache
+    primary string
+    token   string
+
+    mutex     sync.Mutex // Guards conn
+    conn      net.Conn
+    connected atomic.Bool
+    synced    atomic.Bool
+    lastOp    atomic.Int64 // Unix nanoseconds of the last change applied
+    delay     atomic.Int64 // Nanoseconds between the primary sending the last timed op and its arrival
+    closing   atomic.Bool
+    done      chan struct{}
+}
+
+// newReplicaClient replicates from the replication listener at primary
+func newReplicaClient(cache *lrucache.LRUCache, primary, token string) *replicaClient {
+    return &replicaClient{cache: cache, primary: primary, token: token, done: make(chan struct{})}
+}
+
+// ReplicationStatus describes this process's place in replication
+type ReplicationStatus struct {
+    Replicas  int64      `json:"replicas"` // Replicas streaming from this process
+    Primary   string     `json:"primary,omitempty"`
+    Connected bool       `json:"connected"`
+    Synced    bool       `json:"synced"`
+    LastOp    *time.Time `json:"last_op,omitempty"`
+    Lag       *float64   `json:"lag_seconds,omitempty"`
+}
+
+// replicationStatus reports the replica connection, if any, and connected replicas
+func replicationStatus() ReplicationStatus {
+    s := ReplicationStatus{Replicas: connectedReplicas.Load()}
+    if r := replication; r != nil {
+        s.Primary, s.Connected, s.Synced = r.primary, r.connected.Load(), r.synced.Load()
+        if n := r.lastOp.Load(); n > 0 {
+            t := time.Unix(0, n).UTC()
+            s.LastOp = &t
+        }
+        if lag, ok := r.lag(); ok {
+            seconds := lag.Seconds()
+            s.Lag = &seconds
+        }
+    }
+    return s
+}
+
+// lag estimates how far the local copy trails the primary: the delay of the
+// last change or ping while in sync, or the time since the last one while
+// not. It is unknown until the first op arrives.
+func (r *replicaClient) lag() (time.Duration, bool) {
+    if r.connected.Load() && r.synced.Load() {
+        return time.Duration(r.delay.Load()), true
+    }
+    if n := r.lastOp.Load(); n > 0 {
+        return time.Since(time.Unix(0, n)), true
+    }
+    return 0, false
+}
+
+// ListenAndServe stays connected to the primary until shut down
+func (r *replicaClient) ListenAndServe() error {
+    return reconnectLoop("replication", r.done, r.session)
+}
+
+// session runs one connection: it applies the snapshot, removes local keys
+// the primary no longer has, then applies changes as they arrive
+func (r *replicaClient) session() (bool, error) {
+    network, addr := "tcp", r.primary
+    if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+        network, addr = "unix", path
+    }
+    conn, err := net.DialTimeout(network, addr, 5*time.Second)
+    if err != nil {
+        return false, err
+    }
+    r.mutex.Lock()
+    r.conn = conn
+    closing := r.closing.Load()
+    r.mutex.Unlock()
+    defer func() {
+        r.mutex.Lock()
+        r.conn = nil
+        r.mutex.Unlock()
+        conn.Close()
+        r.connected.Store(false)
+        r.synced.Store(false)
+    }()
+    if closing {
+        return false, errServerClosed
+    }
+
+    conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+    if err := json.NewEncoder(conn).Encode(ReplHello{Token: r.token}); err != nil {
+        return false, err
+    }
+    dec := json.NewDecoder(bufio.NewReader(conn))
+    var snapshot map[string]bool // Keys in the snapshot, until it is complete
+    for {
+        conn.SetReadDeadline(time.Now().Add(replicationDeadline))
+        var op ReplOp
+        if err := dec.Decode(&op); err != nil {
+            return r.connected.Load(), err
+        }
+        switch op.Op {
+        case "error":
+            return false, fmt.Errorf("primary refused replication: %s", op.Error)
+        case "snapshot":
+            r.connected.Store(true)
+            snapshot = make(map[string]bool, op.Count)
+            slog.Info("replication: receiving snapshot", "primary", r.primary, "entries", op.Count)
+        case "set":
+            if snapshot != nil {
+                snapshot[op.Key] = true
+            }
+            r.cache.Import([]lrucache.Entry{{Key: op.Key, Value: op.Value, Kind: lrucache.Kind(op.Kind), Flags: op.Flags, ExpiresAt: op.ExpiresAt, StaleAt: op.StaleAt}})
+        case "delete":
+            r.cache.Delete(op.Key)
+        case "flush":
+            r.cache.Flush()
+        case "generation":
+            r.cache.BumpGeneration(op.Key)
+        case "synced":
+            removed := 0
+            for _, e := range r.cache.Export() {
+                if !snapshot[e.Key] && r.cache.Delete(e.Key) {
+                    removed++
+                }
+            }
+            snapshot = nil
+            r.synced.Store(true)
+            slog.Info("replication: synced", "primary", r.primary, "entries", r.cache.Len(), "removed_stale", removed)
+        case "ping": // Carries only the primary's clock
+        }
+        now := time.Now()
+        if !op.Time.IsZero() {
+            // Clocks differ between hosts, so a skew shows up as lag; never report less than none
+            r.delay.Store(max(int64(now.Sub(op.Time)), 0))
+        }
+        if op.Op != "ping" {
+            r.lastOp.Store(now.UnixNano())
+        }
+    }
+}
+
+// consistencyMiddleware routes reads on a replica: by default they are
+// forwarded to the primary's HTTP API at primaryURL, and ?consistency=stale
+// serves them from the local copy with the replication lag in
+// X-Replication-Lag. Without primaryURL every read is stale.
+func consistencyMiddleware(primaryURL string, next http.Handler) http.Handler {
+    var primary *httputil.ReverseProxy
+    if primaryURL != "" {
+        target, _ := url.Parse(primaryURL) // Validated by parseFlags
+        primary = httputil.NewSingleHostReverseProxy(target)
+        primary.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+            slog.Warn("replication: cannot forward read to the primary", "primary", target.Host, "err", err)
+            writeError(w, http.StatusBadGateway, codeUnavailable, "Primary unreachable; retry with consistency=stale to read this replica")
+        }
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet || (r.URL.Path != "/cache" && !strings.HasPrefix(r.URL.Path, "/v1/cache/")) {
+            next.ServeHTTP(w, r)
+            return
+        }
+        consistency := r.URL.Query().Get("consistency")
+        switch consistency {
+        case "", "primary":
+            if primary != nil {
+                w.Header().Set("X-Consistency", "primary")
+                primary.ServeHTTP(w, r)
+                return
+            }
+        case "stale":
+        default:
+            writeError(w, http.StatusBadRequest, codeBadRequest, "consistency must be primary or stale")
+            return
+        }
+        w.Header().Set("X-Consistency", "stale")
+        if lag, ok := replication.lag(); ok {
+            w.Header().Set("X-Replication-Lag", strconv.FormatFloat(lag.Seconds(), 'f', 3, 64))
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// Shutdown stops replicating; the local cache keeps its contents
+func (r *replicaClient) Shutdown(ctx context.Context) error {
+    return r.Close()
+}
+
+// Close drops the connection to the primary and stops reconnecting
+func (r *replicaClient) Close() error {
+    if r.closing.Swap(true) {
+        return nil
+    }
+    close(r.done)
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    if r.conn != nil {
+        r.conn.Close()
+    }
+    return nil
+}
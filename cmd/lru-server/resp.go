@@ -0,0 +1,481 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "slices"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// Limits protecting the RESP parser from oversized input
+const (
+    respMaxArgs       = 1 << 16
+    respMaxInlineSize = 64 << 10
+)
+
+var errRESPProtocol = errors.New("protocol error")
+
+// newRESPServer serves a subset of the Redis protocol backed by the shared cache
+func newRESPServer(cfg *Config, cache lrucache.Cache, addr string, auth *Authenticator) *tcpServer {
+    return &tcpServer{
+        Addr:     addr,
+        MaxConns: cfg.MaxConns,
+        handle: func(conn net.Conn, closing func() bool) {
+            s := &respSession{
+                cache:         cache,
+                maxValueBytes: cfg.MaxValueBytes,
+                r:             bufio.NewReader(conn),
+                w:             bufio.NewWriter(conn),
+                auth:          auth,
+                client:        connClient(conn),
+                channels:      make(map[string]*lrucache.Subscription),
+                patterns:      make(map[string]*lrucache.Subscription),
+            }
+            if err := s.serve(closing); err != nil {
+                logConnError("resp", conn, err, closing)
+            }
+        },
+    }
+}
+
+// respSession is the state of one RESP client connection
+type respSession struct {
+    cache         lrucache.Cache
+    maxValueBytes int
+    r             *bufio.Reader
+    wmutex        sync.Mutex // Held writing w, which subscriptions push to as well
+    w             *bufio.Writer
+    auth          *Authenticator
+    principal     *Principal
+    client        string
+    quit          bool
+
+    // Between MULTI and EXEC or DISCARD
+    multi     bool
+    queued    []queuedCommand
+    txnFailed bool             // A command failed to queue, so EXEC discards the transaction
+    watched   []lrucache.TxnOp // Checks WATCH added, which abort the transaction if a key changed
+
+    // By channel or channel pattern, as SUBSCRIBE and PSUBSCRIBE named them
+    channels map[string]*lrucache.Subscription
+    patterns map[string]*lrucache.Subscription
+}
+
+// caller identifies the client for the audit log
+func (s *respSession) caller() Caller {
+    return newCaller("resp", s.principal, s.client)
+}
+
+// serve reads and answers commands until the client disconnects or quits
+func (s *respSession) serve(closing func() bool) error {
+    defer s.unsubscribeAll()
+    for !s.quit && !closing() {
+        args, err := readRESPCommand(s.r, s.maxValueBytes)
+        if err != nil {
+            if errors.Is(err, io.EOF) {
+                return nil
+            }
+            if errors.Is(err, errRESPProtocol) || errors.Is(err, errLineTooLong) {
+                s.writeError("ERR Protocol error: " + err.Error())
+                s.w.Flush()
+            }
+            return err
+        }
+        if len(args) == 0 {
+            continue
+        }
+        s.wmutex.Lock()
+        s.dispatch(args)
+        err = flushDrained(s.r, s.w, s.quit || closing())
+        s.wmutex.Unlock()
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// readRESPCommand reads a multibulk request or an inline command whose
+// arguments are at most maxValueBytes long
+func readRESPCommand(r *bufio.Reader, maxValueBytes int) ([]string, error) {
+    b, err := r.ReadByte()
+    if err != nil {
+        return nil, err
+    }
+    if b != '*' {
+        r.UnreadByte()
+        line, err := readLimitedLine(r, respMaxInlineSize)
+        if err != nil {
+            return nil, err
+        }
+        return strings.Fields(line), nil
+    }
+
+    n, err := readRESPLength(r, respMaxArgs)
+    if err != nil {
+        return nil, err
+    }
+    args := make([]string, 0, n)
+    for i := 0; i < n; i++ {
+        if b, err := r.ReadByte(); err != nil {
+            return nil, err
+        } else if b != '$' {
+            return nil, fmt.Errorf("%w: expected '$', got %q", errRESPProtocol, b)
+        }
+        size, err := readRESPLength(r, maxValueBytes)
+        if err != nil {
+            return nil, err
+        }
+        buf := make([]byte, size+2)
+        if _, err := io.ReadFull(r, buf); err != nil {
+            return nil, err
+        }
+        if buf[size] != '\r' || buf[size+1] != '\n' {
+            return nil, fmt.Errorf("%w: bulk string not terminated by CRLF", errRESPProtocol)
+        }
+        args = append(args, string(buf[:size]))
+    }
+    return args, nil
+}
+
+// readRESPLength reads the integer following a '*' or '$' marker
+func readRESPLength(r *bufio.Reader, max int) (int, error) {
+    line, err := readLimitedLine(r, 32)
+    if err != nil {
+        return 0, err
+    }
+    n, err := strconv.Atoi(line)
+    if err != nil || n < 0 || n > max {
+        return 0, fmt.Errorf("%w: invalid length %q", errRESPProtocol, line)
+    }
+    return n, nil
+}
+
+func (s *respSession) writeSimple(msg string) {
+    s.w.WriteString("+" + msg + "\r\n")
+}
+
+func (s *respSession) writeError(msg string) {
+    s.w.WriteString("-" + msg + "\r\n")
+}
+
+func (s *respSession) writeInt(n int64) {
+    s.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (s *respSession) writeBulk(value string) {
+    s.w.WriteString("$" + strconv.Itoa(len(value)) + "\r\n")
+    s.w.WriteString(value)
+    s.w.WriteString("\r\n")
+}
+
+func (s *respSession) writeNull() {
+    s.w.WriteString("$-1\r\n")
+}
+
+func (s *respSession) writeArray(values []string) {
+    s.w.WriteString("*" + strconv.Itoa(len(values)) + "\r\n")
+    for _, value := range values {
+        s.writeBulk(value)
+    }
+}
+
+func (s *respSession) writeWrongArgs(cmd string) {
+    s.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+// respSupported returns the session's cache as a T, or replies with an error
+// if the cache does not implement it
+func respSupported[T any](s *respSession) (T, bool) {
+    c, ok := s.cache.(T)
+    if !ok {
+        s.writeError("ERR the cache does not support this command")
+    }
+    return c, ok
+}
+
+// writeCacheError replies to a command the cache refused
+func (s *respSession) writeCacheError(err error) {
+    switch {
+    case errors.Is(err, lrucache.ErrWrongType):
+        s.writeError("WRONGTYPE Operation against a key holding the wrong kind of value")
+    case errors.Is(err, lrucache.ErrInvalidScore):
+        s.writeError("ERR resulting score is not a number (NaN)")
+    case errors.Is(err, lrucache.ErrCorrupt):
+        s.writeError("CORRUPT the stored value failed its checksum and was dropped")
+    default:
+        s.writeError("ERR " + err.Error())
+    }
+}
+
+// allowed checks the session's principal for the role, replying with an error if denied
+func (s *respSession) allowed(required Role) bool {
+    switch s.auth.Authorize(s.principal, required) {
+    case nil:
+        return true
+    case errNoCredentials:
+        s.writeError("NOAUTH Authentication required.")
+    case errReadOnly:
+        s.writeError("READONLY You can't write against a read only replica.")
+    case errNoLeader:
+        s.writeError("CLUSTERDOWN The cluster is down")
+    default:
+        s.writeError("NOPERM this user has no permissions to run this command")
+    }
+    return false
+}
+
+// dispatch executes one command and writes its reply
+func (s *respSession) dispatch(args []string) {
+    cmd := strings.ToUpper(args[0])
+    argc := len(args) - 1
+    wrongArgs := func() {
+        s.writeWrongArgs(cmd)
+    }
+
+    if s.subscribed() && !slices.Contains(subscribedCommands, cmd) {
+        s.writeSubscribedOnly(cmd)
+        return
+    }
+    if s.multi {
+        switch cmd {
+        case "MULTI", "EXEC", "DISCARD", "WATCH", "QUIT":
+        default:
+            s.queue(cmd, args[1:])
+            return
+        }
+    }
+
+    switch cmd {
+    case "PING":
+        switch argc {
+        case 0:
+            s.writeSimple("PONG")
+        case 1:
+            s.writeBulk(args[1])
+        default:
+            wrongArgs()
+        }
+    case "ECHO":
+        if argc != 1 {
+            wrongArgs()
+            return
+        }
+        s.writeBulk(args[1])
+    case "QUIT":
+        s.writeSimple("OK")
+        s.quit = true
+    case "AUTH":
+        if argc < 1 || argc > 2 {
+            wrongArgs()
+            return
+        }
+        p, err := s.auth.AuthenticateToken(args[argc]) // AUTH [username] password
+        if err != nil {
+            s.writeError("WRONGPASS invalid username-password pair or user is disabled.")
+            return
+        }
+        s.principal = p
+        s.writeSimple("OK")
+    case "SELECT":
+        if argc != 1 {
+            wrongArgs()
+        } else if args[1] != "0" {
+            s.writeError("ERR DB index is out of range")
+        } else {
+            s.writeSimple("OK")
+        }
+    case "COMMAND":
+        s.w.WriteString("*0\r\n") // Lets redis-cli start without command docs
+    case "GET":
+        if argc != 1 {
+            wrongArgs()
+            return
+        }
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        value, err := s.cache.Get(args[1])
+        switch {
+        case err == nil:
+            s.writeBulk(value)
+        case errors.Is(err, lrucache.ErrNotFound), errors.Is(err, lrucache.ErrExpired):
+            s.writeNull()
+        default:
+            s.writeCacheError(err)
+        }
+    case "SET":
+        if argc < 2 {
+            wrongArgs()
+            return
+        }
+        if !s.allowed(RoleWrite) {
+            return
+        }
+        s.set(args[1], args[2], args[3:])
+    case "DEL":
+        if argc < 1 {
+            wrongArgs()
+            return
+        }
+        if !s.allowed(RoleWrite) {
+            return
+        }
+        var n int64
+        for _, key := range args[1:] {
+            if s.cache.Delete(key) {
+                audit(s.caller(), "delete", key)
+                n++
+            }
+        }
+        s.writeInt(n)
+    case "EXISTS":
+        if argc < 1 {
+            wrongArgs()
+            return
+        }
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        var n int64
+        for _, key := range args[1:] {
+            if s.cache.Contains(key) {
+                n++
+            }
+        }
+        s.writeInt(n)
+    case "TTL", "PTTL":
+        if argc != 1 {
+            wrongArgs()
+            return
+        }
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        ttl, found := s.cache.TTL(args[1])
+        switch {
+        case !found:
+            s.writeInt(-2)
+        case ttl == 0:
+            s.writeInt(-1)
+        case cmd == "PTTL":
+            s.writeInt(ttl.Milliseconds())
+        default:
+            s.writeInt((ttl.Milliseconds() + 500) / 1000)
+        }
+    case "INCR":
+        if argc != 1 {
+            wrongArgs()
+            return
+        }
+        if !s.allowed(RoleWrite) {
+            return
+        }
+        n, err := s.cache.Incr(args[1], 1)
+        if err != nil {
+            s.writeCacheError(err)
+            return
+        }
+        audit(s.caller(), "incr", args[1])
+        s.writeInt(n)
+    case "FLUSHALL":
+        if argc > 1 {
+            wrongArgs()
+            return
+        }
+        if !s.allowed(RoleAdmin) {
+            return
+        }
+        s.cache.Flush()
+        audit(s.caller(), "flush", "")
+        s.writeSimple("OK")
+    case "HSET", "HGET", "HDEL", "HGETALL":
+        s.hash(cmd, args[1:])
+    case "LPUSH", "RPUSH", "LPOP", "LRANGE":
+        s.list(cmd, args[1:])
+    case "SADD", "SREM", "SISMEMBER", "SMEMBERS", "SCARD":
+        s.members(cmd, args[1:])
+    case "ZADD", "ZRANGE", "ZRANK", "ZINCRBY":
+        s.sortedSet(cmd, args[1:])
+    case "SETBIT", "GETBIT", "BITCOUNT":
+        s.bitmap(cmd, args[1:])
+    case "PFADD", "PFCOUNT", "PFMERGE":
+        s.hyperLogLog(cmd, args[1:])
+    case "MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH":
+        s.transaction(cmd, args[1:])
+    case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+        s.pubsub(cmd, args[1:])
+    default:
+        s.writeError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+    }
+}
+
+// set implements SET key value [EX seconds|PX milliseconds] [NX|XX]
+func (s *respSession) set(key, value string, opts []string) {
+    expiration, nx, xx, err := parseSetOptions(opts)
+    if err != nil {
+        s.writeError(err.Error())
+        return
+    }
+
+    mode := lrucache.StoreAlways
+    switch {
+    case nx:
+        mode = lrucache.StoreIfAbsent
+    case xx:
+        mode = lrucache.StoreIfPresent
+    }
+    // A write the doorkeeper refused is answered like one NX or XX refused
+    _, err = s.cache.Store(key, value, 0, expiration, mode)
+    switch {
+    case errors.Is(err, lrucache.ErrNotStored), errors.Is(err, lrucache.ErrNotAdmitted):
+        s.writeNull()
+        return
+    case err != nil:
+        s.writeCacheError(err)
+        return
+    }
+    audit(s.caller(), "set", key)
+    s.writeSimple("OK")
+}
+
+// parseSetOptions reads the options following the value of a SET
+func parseSetOptions(opts []string) (expiration time.Duration, nx, xx bool, err error) {
+    errSyntax := errors.New("ERR syntax error")
+    for i := 0; i < len(opts); i++ {
+        switch opt := strings.ToUpper(opts[i]); opt {
+        case "NX":
+            nx = true
+        case "XX":
+            xx = true
+        case "EX", "PX":
+            if i+1 >= len(opts) {
+                return 0, false, false, errSyntax
+            }
+            n, err := strconv.ParseInt(opts[i+1], 10, 64)
+            if err != nil || n <= 0 {
+                return 0, false, false, errors.New("ERR invalid expire time in 'set' command")
+            }
+            if opt == "EX" {
+                expiration = time.Duration(n) * time.Second
+            } else {
+                expiration = time.Duration(n) * time.Millisecond
+            }
+            i++
+        default:
+            return 0, false, false, errSyntax
+        }
+    }
+    if nx && xx {
+        return 0, false, false, errSyntax
+    }
+    return expiration, nx, xx, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+    "bufio"
+    "net"
+    "strings"
+    "testing"
+    "time"
+
+    "lru-cache/lrucache"
+    "lru-cache/lrucache/cachetest"
+)
+
+// respPipe serves one RESP session backed by cache over an in-memory
+// connection and returns the client's end with a reader of its replies
+func respPipe(t *testing.T, cache lrucache.Cache) (net.Conn, *bufio.Reader) {
+    t.Helper()
+    srv := newRESPServer(&Config{MaxValueBytes: 64}, cache, "", NewAuthenticator(nil, nil, nil, false))
+    client, server := net.Pipe()
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        srv.handle(server, func() bool { return false })
+        server.Close()
+    }()
+    t.Cleanup(func() {
+        client.Close()
+        <-done
+    })
+    return client, bufio.NewReader(client)
+}
+
+// respCall sends one inline command and reads its reply, joining a bulk
+// string's header and value with "|"
+func respCall(t *testing.T, conn net.Conn, r *bufio.Reader, command string) string {
+    t.Helper()
+    if _, err := conn.Write([]byte(command + "\r\n")); err != nil {
+        t.Fatalf("write: %v", err)
+    }
+    line, err := r.ReadString('\n')
+    if err != nil {
+        t.Fatalf("read: %v", err)
+    }
+    line = strings.TrimSuffix(line, "\r\n")
+    if !strings.HasPrefix(line, "$") || line == "$-1" {
+        return line
+    }
+    value, err := r.ReadString('\n')
+    if err != nil {
+        t.Fatalf("read value: %v", err)
+    }
+    return line + "|" + strings.TrimSuffix(value, "\r\n")
+}
+
+func TestRESPStrings(t *testing.T) {
+    conn, r := respPipe(t, lrucache.New(lrucache.WithCapacity(10)))
+    tests := []struct {
+        command string
+        want    string
+    }{
+        {"SET k v1", "+OK"},
+        {"SET k v2 NX", "$-1"},
+        {"SET missing v XX", "$-1"},
+        {"SET k v2 XX", "+OK"},
+        {"GET k", "$2|v2"},
+        {"GET missing", "$-1"},
+        {"TTL k", ":-1"},
+        {"TTL missing", ":-2"},
+        {"INCR n", ":1"},
+        {"DEL k", ":1"},
+    }
+    for _, tt := range tests {
+        if got := respCall(t, conn, r, tt.command); got != tt.want {
+            t.Fatalf("%q = %q, want %q", tt.command, got, tt.want)
+        }
+    }
+}
+
+func TestRESPErrors(t *testing.T) {
+    conn, r := respPipe(t, lrucache.New(lrucache.WithCapacity(10)))
+    respCall(t, conn, r, "RPUSH l v")
+    respCall(t, conn, r, "SET s abc")
+    tests := []struct {
+        command string
+        want    string
+    }{
+        {"GET l", "-WRONGTYPE Operation against a key holding the wrong kind of value"},
+        {"INCR l", "-WRONGTYPE Operation against a key holding the wrong kind of value"},
+        {"INCR s", "-ERR value is not an integer or out of range"},
+        {"SETBIT b 512 1", "-ERR bit offset is not an integer or out of range"},
+        {"SETBIT b 4294967295 1", "-ERR bit offset is not an integer or out of range"},
+        {"SETBIT b 511 1", ":0"},
+        {"GET missing", "$-1"},
+    }
+    for _, tt := range tests {
+        if got := respCall(t, conn, r, tt.command); got != tt.want {
+            t.Errorf("%q = %q, want %q", tt.command, got, tt.want)
+        }
+    }
+}
+
+func TestRESPClosedCache(t *testing.T) {
+    cache := lrucache.New(lrucache.WithCapacity(10))
+    cache.Set("k", "v", 0)
+    cache.Close()
+    conn, r := respPipe(t, cache)
+    for _, command := range []string{"GET k", "INCR n"} {
+        if got := respCall(t, conn, r, command); got != "-ERR "+lrucache.ErrClosed.Error() {
+            t.Errorf("%q on a closed cache = %q", command, got)
+        }
+    }
+}
+
+func TestRESPNotAdmitted(t *testing.T) {
+    conn, r := respPipe(t, lrucache.New(lrucache.WithCapacity(10), lrucache.WithDoorkeeper(time.Minute)))
+    for _, tt := range []struct{ command, want string }{
+        {"SET k v", "$-1"},
+        {"SET k v", "+OK"},
+        {"SET other v NX", "$-1"},
+    } {
+        if got := respCall(t, conn, r, tt.command); got != tt.want {
+            t.Fatalf("%q = %q, want %q", tt.command, got, tt.want)
+        }
+    }
+}
+
+func TestRESPUnsupportedCommand(t *testing.T) {
+    conn, r := respPipe(t, coreCache{cachetest.New(10)})
+    for _, command := range []string{"HSET h f v", "LPUSH l v", "SADD s m", "ZADD z 1 m", "SETBIT b 1 1", "PFADD p e", "WATCH k"} {
+        if got := respCall(t, conn, r, command); got != "-ERR the cache does not support this command" {
+            t.Errorf("%q on a core-only cache = %q", command, got)
+        }
+    }
+    if got := respCall(t, conn, r, "SET k v"); got != "+OK" {
+        t.Fatalf("SET on a core-only cache = %q", got)
+    }
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "mime"
+    "net/http"
+    "time"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "lru-cache/lrucache"
+)
+
+// param documents a path or query parameter of a route
+type param struct {
+    Name        string
+    In          string
+    Description string
+    Required    bool
+}
+
+// response documents one possible response of a route
+type response struct {
+    Status      int
+    Description string
+    ContentType string
+    Body        interface{}
+}
+
+// route describes an API endpoint; both the router and /openapi.json are built from it
+type route struct {
+    Method   string
+    Path     string
+    Summary  string
+    Handler  http.HandlerFunc
+    Params   []param
+    Body     interface{}
+    Binary   bool // The value may instead be sent as a raw application/octet-stream body
+    Response []response
+}
+
+var keyParam = param{Name: "key", In: "path", Description: "Cache key", Required: true}
+
+var consistencyParam = param{Name: "consistency", In: "query", Description: "On a replica: primary (default) reads through the primary, stale reads the local copy and reports X-Replication-Lag"}
+
+// apiRoutes lists every endpoint served on the public listener
+func apiRoutes(h *handlers, auth *Authenticator) []route {
+    routes := []route{
+        {
+            Method:  http.MethodGet,
+            Path:    "/cache",
+            Summary: "Get one or several values (legacy API)",
+            Handler: h.getCacheHandler,
+            Params: []param{
+                {Name: "key", In: "query", Description: "Cache key; repeat to fetch several keys"},
+                {Name: "keys", In: "query", Description: "Comma-separated list of keys to fetch at once"},
+                leaseParam,
+                consistencyParam,
+            },
+            Response: []response{
+                {Status: http.StatusOK, Description: "The cached value, with X-Stale: true once past its soft_ttl", ContentType: "text/plain"},
+                {Status: http.StatusOK, Description: "Found values and missing keys for multi-key requests", ContentType: "application/json", Body: MultiGetResponse{}},
+                errorResponse(http.StatusNotFound, "Key not found (KEY_NOT_FOUND) or expired (EXPIRED); with lease, the X-Lease-Token header carries the token to set the key with"),
+                errorResponse(http.StatusConflict, "With lease, another caller holds the lease (LEASE_HELD); retry after Retry-After seconds"),
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/cache",
+            Summary: "Set a value (legacy API)",
+            Handler: h.setCacheHandler,
+            Body:    CacheRequest{},
+            Response: []response{
+                {Status: http.StatusOK, Description: "Value stored"},
+                errorResponse(http.StatusBadRequest, "Malformed request body (BAD_REQUEST)"),
+                errorResponse(http.StatusConflict, "The lease_token expired or was revoked (LEASE_INVALID)"),
+                errorResponse(http.StatusConflict, "With -doorkeeper-window, the key is new and was not cached until its next write (NOT_ADMITTED)"),
+                errorResponse(http.StatusRequestEntityTooLarge, "Value too large (PAYLOAD_TOO_LARGE)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/v1/cache/{key}",
+            Summary: "Get a value",
+            Handler: h.getKeyHandler,
+            Params:  []param{keyParam, consistencyParam},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The cached value, with X-Stale: true once past its soft_ttl; Range requests are answered with 206", ContentType: "text/plain"},
+                {Status: http.StatusOK, Description: "Sent as raw bytes for values that are not valid UTF-8, or if Accept asks for it", ContentType: "application/octet-stream"},
+                {Status: http.StatusPartialContent, Description: "The bytes a Range header asked for"},
+                errorResponse(http.StatusRequestedRangeNotSatisfiable, "The range lies past the end of the value"),
+                errorResponse(http.StatusNotFound, "Key not found (KEY_NOT_FOUND) or expired (EXPIRED)"),
+                errorResponse(http.StatusInternalServerError, "The value failed its checksum and was dropped (CORRUPT_VALUE)"),
+            },
+        },
+        {
+            Method:  http.MethodPut,
+            Path:    "/v1/cache/{key}",
+            Summary: "Set a value, given in a JSON body or as the raw body of an application/octet-stream request",
+            Handler: h.putKeyHandler,
+            Params: []param{
+                keyParam,
+                {Name: "expiration", In: "query", Description: "Seconds until a raw application/octet-stream value expires"},
+            },
+            Body:   EntryRequest{},
+            Binary: true,
+            Response: []response{
+                {Status: http.StatusNoContent, Description: "Value stored"},
+                errorResponse(http.StatusBadRequest, "Malformed request body (BAD_REQUEST)"),
+                errorResponse(http.StatusConflict, "With -doorkeeper-window, the key is new and was not cached until its next write (NOT_ADMITTED)"),
+                errorResponse(http.StatusRequestEntityTooLarge, "Value too large (PAYLOAD_TOO_LARGE)"),
+            },
+        },
+        {
+            Method:  http.MethodDelete,
+            Path:    "/v1/cache/{key}",
+            Summary: "Delete a value",
+            Handler: h.deleteKeyHandler,
+            Params:  []param{keyParam},
+            Response: []response{
+                {Status: http.StatusNoContent, Description: "Key deleted"},
+                errorResponse(http.StatusNotFound, "Key not found (KEY_NOT_FOUND) or expired (EXPIRED)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/cache/ws",
+            Summary: "WebSocket carrying JSON get/set/delete/subscribe frames",
+            Handler: h.websocketHandler(auth),
+            Response: []response{
+                {Status: http.StatusSwitchingProtocols, Description: "Upgraded; frames follow WSRequest, WSResponse and EventMessage", ContentType: "application/json", Body: WSRequest{}},
+                errorResponse(http.StatusUpgradeRequired, "Not a WebSocket upgrade request (BAD_REQUEST)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/cache/heatmap",
+            Summary: "Read counts and hit ratios per key prefix, busiest first",
+            Handler: h.heatmapHandler,
+            Params:  []param{{Name: "limit", In: "query", Description: "Only return this many of the busiest prefixes"}},
+            Response: []response{
+                {Status: http.StatusOK, Description: "Reads per prefix", ContentType: "application/json", Body: HeatmapResponse{Prefixes: []lrucache.HeatmapEntry{}}},
+                errorResponse(http.StatusBadRequest, "Invalid limit (BAD_REQUEST)"),
+                errorResponse(http.StatusNotFound, "The heatmap is disabled (NOT_FOUND)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/cluster/members",
+            Summary: "List the live members of the cluster found by gossip",
+            Handler: membersHandler,
+            Response: []response{
+                {Status: http.StatusOK, Description: "This node's name and the live members", ContentType: "application/json", Body: MembersResponse{Members: []Member{}}},
+                errorResponse(http.StatusNotFound, "Cluster membership is disabled (NOT_FOUND)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/cluster/status",
+            Summary: "Show every member with its health, share of the ring, entry count and replication lag",
+            Handler: h.clusterStatusHandler,
+            Params: []param{
+                {Name: "ranges", In: "query", Description: "true lists the spans of the hash space each member owns"},
+            },
+            Response: []response{
+                {Status: http.StatusOK, Description: "Cluster status; members that did not answer carry an error", ContentType: "application/json", Body: ClusterStatus{Members: []MemberStatus{}}},
+                errorResponse(http.StatusNotFound, "Cluster membership is disabled (NOT_FOUND)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/cluster/node",
+            Summary: "Show this member's entry count and replication state; GET /cluster/status collects it from every member",
+            Handler: h.nodeHandler,
+            Response: []response{
+                {Status: http.StatusOK, Description: "This member's status", ContentType: "application/json", Body: NodeStatus{}},
+                errorResponse(http.StatusNotFound, "Cluster membership is disabled (NOT_FOUND)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/cluster/fill/{key}",
+            Summary: "Get a value from the member owning the key, which loads it from the origin on a miss; members call this for keys they miss",
+            Handler: fillHandler,
+            Params:  []param{keyParam},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The value", ContentType: "text/plain"},
+                errorResponse(http.StatusNotFound, "Not at the origin either (KEY_NOT_FOUND), or distributed fill is disabled (NOT_FOUND)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/cache/events",
+            Summary: "Stream set, delete, expire, evict and flush events as Server-Sent Events",
+            Handler: h.eventsHandler,
+            Params: []param{
+                {Name: "prefix", In: "query", Description: "Only stream events for keys with this prefix"},
+                {Name: "pattern", In: "query", Description: "Only stream events for keys matching this glob pattern, such as user:*:profile"},
+                {Name: "types", In: "query", Description: "Only stream these comma-separated events: set, delete, expire, evict, flush, invalidate or generation"},
+                {Name: "namespace", In: "query", Description: "Stream the events of this namespace instead of the default one"},
+            },
+            Response: []response{
+                {Status: http.StatusOK, Description: "An event stream whose data lines are EventMessage objects", ContentType: "text/event-stream", Body: EventMessage{}},
+                errorResponse(http.StatusBadRequest, "Unknown event type (BAD_REQUEST)"),
+                errorResponse(http.StatusNotFound, "No such namespace (NOT_FOUND)"),
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/graphql",
+            Summary: "Run a GraphQL query or mutation",
+            Handler: h.graphQLHandler(auth),
+            Body:    GraphQLRequest{},
+            Response: []response{
+                {Status: http.StatusOK, Description: "Result data and field errors", ContentType: "application/json", Body: GraphQLResponse{}},
+                {Status: http.StatusBadRequest, Description: "The document could not be parsed", ContentType: "application/json", Body: GraphQLResponse{}},
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/graphql",
+            Summary: "Run a GraphQL query passed in the query, operationName and variables parameters",
+            Handler: h.graphQLHandler(auth),
+            Params: []param{
+                {Name: "query", In: "query", Description: "GraphQL document", Required: true},
+                {Name: "operationName", In: "query", Description: "Operation to run when the document has several"},
+                {Name: "variables", In: "query", Description: "JSON object of variable values"},
+            },
+            Response: []response{
+                {Status: http.StatusOK, Description: "Result data and field errors", ContentType: "application/json", Body: GraphQLResponse{}},
+                {Status: http.StatusBadRequest, Description: "The document could not be parsed", ContentType: "application/json", Body: GraphQLResponse{}},
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/rpc",
+            Summary: "JSON-RPC 2.0 calls to cache.get, cache.set, cache.delete, cache.touch, cache.exists, cache.stats and cache.flush, singly or in batches",
+            Handler: h.jsonRPCHandler(auth),
+            Body:    RPCRequest{},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The response, or an array of responses for a batch", ContentType: "application/json", Body: RPCResponse{}},
+                {Status: http.StatusNoContent, Description: "Only notifications were sent"},
+                errorResponse(http.StatusRequestEntityTooLarge, "Request body too large (PAYLOAD_TOO_LARGE)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/graphql/schema",
+            Summary: "GraphQL schema in SDL form",
+            Handler: graphQLSchemaHandler,
+            Response: []response{
+                {Status: http.StatusOK, Description: "The schema", ContentType: "text/plain"},
+            },
+        },
+    }
+    routes = append(routes, hashRoutes(h)...)
+    routes = append(routes, listRoutes(h)...)
+    routes = append(routes, setRoutes(h)...)
+    routes = append(routes, sortedSetRoutes(h)...)
+    routes = append(routes, bitmapRoutes(h)...)
+    routes = append(routes, hllRoutes(h)...)
+    routes = append(routes, generationRoutes(h)...)
+    routes = append(routes, purgeRoute(h), txnRoute(h), watchRoute(h), haRoute(h))
+    return append(routes, namespaceRoutes(h)...)
+}
+
+// newRouter registers the given routes plus the OpenAPI document describing them
+func newRouter(routes []route) *http.ServeMux {
+    routes = append(routes, route{
+        Method:  http.MethodGet,
+        Path:    "/openapi.json",
+        Summary: "OpenAPI description of this API",
+        Response: []response{
+            {Status: http.StatusOK, Description: "OpenAPI 3 document", ContentType: "application/json"},
+        },
+    })
+    spec, err := json.MarshalIndent(openAPIDocument(routes), "", "  ")
+    if err != nil {
+        panic(err)
+    }
+    routes[len(routes)-1].Handler = func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        w.Write(spec)
+    }
+
+    mux := http.NewServeMux()
+    for _, rt := range routes {
+        mux.HandleFunc(rt.Method+" "+rt.Path, requireKey(rt.Params, tenantGuard(rt.Path, rt.Handler)))
+    }
+    mux.Handle("/", notFoundHandler(mux))
+    return mux
+}
+
+// EntryRequest is the body of PUT /v1/cache/{key}
+type EntryRequest struct {
+    Value      string `json:"value"`
+    Expiration int    `json:"expiration"`
+    SoftTTL    int    `json:"soft_ttl,omitempty"` // Seconds after which reads flag the value stale, until the expiration makes it a miss
+}
+
+// getKeyHandler handles GET /v1/cache/{key}
+func (h *handlers) getKeyHandler(w http.ResponseWriter, r *http.Request) {
+    h.writeValue(w, r, r.PathValue("key"))
+}
+
+// putKeyHandler handles PUT /v1/cache/{key}
+func (h *handlers) putKeyHandler(w http.ResponseWriter, r *http.Request) {
+    if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/octet-stream" {
+        h.putRawValue(w, r)
+        return
+    }
+    var req EntryRequest
+    if !h.decodeJSON(w, r, &req) || !h.checkValueSize(w, req.Value) {
+        return
+    }
+    if req.SoftTTL < 0 {
+        writeError(w, http.StatusBadRequest, codeBadRequest, "soft_ttl must be positive")
+        return
+    }
+
+    var refresher lrucache.Refresher
+    if req.SoftTTL > 0 {
+        var ok bool
+        if refresher, ok = supported[lrucache.Refresher](w, h.cache); !ok {
+            return
+        }
+    }
+
+    span := startSpan(r.Context(), "cache.set")
+    span.SetAttributes(attribute.Int("cache.value_bytes", len(req.Value)))
+    expiration := time.Duration(req.Expiration) * time.Second
+    var err error
+    if req.SoftTTL > 0 {
+        err = refresher.SetSoft(r.PathValue("key"), req.Value, time.Duration(req.SoftTTL)*time.Second, expiration)
+    } else {
+        err = lrucache.SetCtx(r.Context(), h.cache, r.PathValue("key"), req.Value, expiration)
+    }
+    span.End()
+    if err != nil {
+        writeCacheError(w, err)
+        return
+    }
+    audit(httpCaller("http", r), "set", r.PathValue("key"))
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// putRawValue stores the raw body of an application/octet-stream PUT
+// /v1/cache/{key} as it arrives, with the expiration query parameter
+func (h *handlers) putRawValue(w http.ResponseWriter, r *http.Request) {
+    expiration, ok := queryInt(w, r, "expiration", 0)
+    if !ok {
+        return
+    }
+
+    // A body that says it is too long is refused before any of it is read; one
+    // that does not say stops being read once it passes the limit
+    if r.ContentLength > int64(h.maxValueBytes) {
+        writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "Value exceeds the maximum size")
+        return
+    }
+
+    span := startSpan(r.Context(), "cache.set")
+    n, err := lrucache.SetFrom(r.Context(), h.cache, r.PathValue("key"), http.MaxBytesReader(w, r.Body, int64(h.maxValueBytes)), time.Duration(expiration)*time.Second)
+    span.SetAttributes(attribute.Int64("cache.value_bytes", n))
+    span.End()
+    var tooLarge *http.MaxBytesError
+    switch {
+    case errors.As(err, &tooLarge):
+        writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "Value exceeds the maximum size")
+    case err != nil:
+        writeCacheError(w, err)
+    default:
+        audit(httpCaller("http", r), "set", r.PathValue("key"))
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// deleteKeyHandler handles DELETE /v1/cache/{key}
+func (h *handlers) deleteKeyHandler(w http.ResponseWriter, r *http.Request) {
+    span := startSpan(r.Context(), "cache.delete")
+    deleted := h.cache.Delete(r.PathValue("key"))
+    span.SetAttributes(attribute.Bool("cache.hit", deleted))
+    span.End()
+    if deleted {
+        audit(httpCaller("http", r), "delete", r.PathValue("key"))
+    }
+    if !deleted {
+        writeError(w, http.StatusNotFound, codeKeyNotFound, "Key not found")
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "time"
+)
+
+// RuntimeConfig is the effective value of the settings PUT /admin/config changes
+type RuntimeConfig struct {
+    Capacity        int     `json:"capacity"`
+    DefaultTTL      string  `json:"default_ttl"`      // Such as 1h; 0s keeps entries until evicted
+    JanitorInterval string  `json:"janitor_interval"` // 0s when disabled
+    RateLimit       float64 `json:"rate_limit"`
+    RateBurst       int     `json:"rate_burst"`
+    ClientRateLimit float64 `json:"client_rate_limit"`
+    ClientRateBurst int     `json:"client_rate_burst"`
+}
+
+// RuntimeConfigRequest is the body of PUT /admin/config; settings left out
+// keep their value
+type RuntimeConfigRequest struct {
+    Capacity        *int     `json:"capacity,omitempty"`
+    DefaultTTL      *string  `json:"default_ttl,omitempty"`
+    JanitorInterval *string  `json:"janitor_interval,omitempty"`
+    RateLimit       *float64 `json:"rate_limit,omitempty"`
+    RateBurst       *int     `json:"rate_burst,omitempty"`
+    ClientRateLimit *float64 `json:"client_rate_limit,omitempty"`
+    ClientRateBurst *int     `json:"client_rate_burst,omitempty"`
+}
+
+// RuntimeConfigResponse answers PUT /admin/config
+type RuntimeConfigResponse struct {
+    Config  RuntimeConfig `json:"config"`
+    Changed []string      `json:"changed"` // Flag names of the settings that changed
+    Evicted int           `json:"evicted"` // Entries evicted by shrinking the capacity
+}
+
+// RuntimeConfig returns the effective value of the settings PUT /admin/config changes
+func (rl *Reloader) RuntimeConfig() RuntimeConfig {
+    rl.mutex.Lock()
+    defer rl.mutex.Unlock()
+    return rl.runtimeConfig()
+}
+
+// runtimeConfig is RuntimeConfig with the mutex held
+func (rl *Reloader) runtimeConfig() RuntimeConfig {
+    return RuntimeConfig{
+        Capacity:        rl.cache.Capacity(),
+        DefaultTTL:      rl.cache.DefaultTTL().String(),
+        JanitorInterval: rl.cfg.JanitorInterval.String(),
+        RateLimit:       rl.cfg.RateLimit,
+        RateBurst:       rl.cfg.RateBurst,
+        ClientRateLimit: rl.cfg.ClientRateLimit,
+        ClientRateBurst: rl.cfg.ClientRateBurst,
+    }
+}
+
+// Update applies the settings req gives and reports the effective ones; on
+// an error nothing is applied. A later reload sets them back to what the
+// flags, environment and -config file say.
+func (rl *Reloader) Update(req RuntimeConfigRequest) (RuntimeConfigResponse, error) {
+    rl.mutex.Lock()
+    defer rl.mutex.Unlock()
+    next, err := updatedConfig(rl.cfg, req)
+    if err != nil {
+        return RuntimeConfigResponse{}, err
+    }
+
+    resp := RuntimeConfigResponse{Changed: []string{}}
+    if req.Capacity != nil && *req.Capacity != rl.cache.Capacity() {
+        if resp.Evicted, err = rl.cache.Resize(*req.Capacity); err != nil {
+            return RuntimeConfigResponse{}, err
+        }
+        resp.Changed = append(resp.Changed, "capacity")
+    }
+    resp.Changed = append(resp.Changed, rl.applyLimits(rl.cfg, next)...)
+    resp.Changed = append(resp.Changed, rl.applyTimers(rl.cfg, next)...)
+    rl.cfg = next
+    resp.Config = rl.runtimeConfig()
+    slog.Info("configuration updated", "changed", resp.Changed, "evicted", resp.Evicted)
+    return resp, nil
+}
+
+// updatedConfig returns a copy of cfg with the settings of req
+func updatedConfig(cfg *Config, req RuntimeConfigRequest) (*Config, error) {
+    next := *cfg
+    if req.Capacity != nil {
+        if *req.Capacity <= 0 {
+            return nil, errors.New("capacity must be positive")
+        }
+        next.Capacity = *req.Capacity
+    }
+    durations := []struct {
+        name  string
+        value *string
+        p     *time.Duration
+    }{
+        {"default_ttl", req.DefaultTTL, &next.DefaultTTL},
+        {"janitor_interval", req.JanitorInterval, &next.JanitorInterval},
+    }
+    for _, d := range durations {
+        if d.value == nil {
+            continue
+        }
+        v, err := time.ParseDuration(*d.value)
+        if err != nil || v < 0 {
+            return nil, fmt.Errorf("%s must be a duration such as 30s or 1h, or 0s", d.name)
+        }
+        *d.p = v
+    }
+    for name, p := range map[string]*float64{"rate_limit": req.RateLimit, "client_rate_limit": req.ClientRateLimit} {
+        if p != nil && *p < 0 {
+            return nil, fmt.Errorf("%s cannot be negative", name)
+        }
+    }
+    for name, p := range map[string]*int{"rate_burst": req.RateBurst, "client_rate_burst": req.ClientRateBurst} {
+        if p != nil && *p <= 0 {
+            return nil, fmt.Errorf("%s must be positive", name)
+        }
+    }
+    if req.RateLimit != nil {
+        next.RateLimit = *req.RateLimit
+    }
+    if req.RateBurst != nil {
+        next.RateBurst = *req.RateBurst
+    }
+    if req.ClientRateLimit != nil {
+        next.ClientRateLimit = *req.ClientRateLimit
+    }
+    if req.ClientRateBurst != nil {
+        next.ClientRateBurst = *req.ClientRateBurst
+    }
+    return &next, nil
+}
+
+// runtimeConfigRoutes are the admin endpoints tuning a running server
+func runtimeConfigRoutes(h *handlers) []route {
+    return []route{
+        {
+            Method:  http.MethodGet,
+            Path:    "/admin/config",
+            Summary: "Show the effective capacity, default TTL, janitor interval and rate limits",
+            Handler: h.getRuntimeConfigHandler,
+            Response: []response{
+                {Status: http.StatusOK, Description: "The effective settings", ContentType: "application/json", Body: RuntimeConfig{}},
+            },
+        },
+        {
+            Method:  http.MethodPut,
+            Path:    "/admin/config",
+            Summary: "Change the capacity, default TTL, janitor interval or rate limits without a restart; shrinking the capacity evicts the least recently used entries over it",
+            Handler: h.putRuntimeConfigHandler,
+            Body:    RuntimeConfigRequest{},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The effective settings, those that changed and the entries evicted", ContentType: "application/json", Body: RuntimeConfigResponse{}},
+                errorResponse(http.StatusBadRequest, "Malformed request body or invalid setting; none were applied (BAD_REQUEST)"),
+                errorResponse(http.StatusForbidden, "A capacity change sent to a Raft follower (READ_ONLY)"),
+            },
+        },
+    }
+}
+
+// getRuntimeConfigHandler handles GET /admin/config
+func (h *handlers) getRuntimeConfigHandler(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, h.reloader.RuntimeConfig())
+}
+
+// putRuntimeConfigHandler handles PUT /admin/config
+func (h *handlers) putRuntimeConfigHandler(w http.ResponseWriter, r *http.Request) {
+    var req RuntimeConfigRequest
+    if !h.decodeJSON(w, r, &req) {
+        return
+    }
+    // Resizing goes through the Raft log, which only the leader appends to
+    if req.Capacity != nil && raftFollower(w) {
+        return
+    }
+    resp, err := h.reloader.Update(req)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+        return
+    }
+    audit(httpCaller("admin", r), "config", "")
+    writeJSON(w, resp)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// server is a listener started at boot and drained on shutdown
+type server interface {
+    ListenAndServe() error
+    Shutdown(ctx context.Context) error
+    Close() error
+}
+
+// namedServer labels a server and its address in log messages
+type namedServer struct {
+    name string
+    addr string
+    srv  server
+}
+
+// httpServer is an http.Server whose listener caps concurrent connections
+type httpServer struct {
+    *http.Server
+    maxConns int
+    certFile string
+    keyFile  string
+}
+
+// ListenAndServe listens on the server address and serves until shut down
+func (s *httpServer) ListenAndServe() error {
+    l, err := listen(s.Addr)
+    if err != nil {
+        return err
+    }
+    l = newLimitListener(l, s.maxConns)
+    if s.certFile != "" {
+        return s.ServeTLS(l, s.certFile, s.keyFile)
+    }
+    return s.Serve(l)
+}
+
+// socketMode is applied to unix socket listeners after they are created
+var socketMode os.FileMode = 0660
+
+// listen opens a TCP listener, or a unix socket for addresses of the form
+// unix:///path, or takes the socket systemd passed for systemd:name
+func listen(addr string) (net.Listener, error) {
+    if name, ok := strings.CutPrefix(addr, systemdPrefix); ok {
+        return systemdListener(name)
+    }
+    path, ok := strings.CutPrefix(addr, "unix://")
+    if !ok {
+        return net.Listen("tcp", addr)
+    }
+    // A socket left behind by a crashed process blocks the bind; remove it
+    // unless another server is still accepting on it
+    if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+        if conn, err := net.Dial("unix", path); err == nil {
+            conn.Close()
+            return nil, fmt.Errorf("listen unix %s: socket is in use", path)
+        }
+        os.Remove(path)
+    }
+    l, err := net.Listen("unix", path)
+    if err != nil {
+        return nil, err
+    }
+    if err := os.Chmod(path, socketMode); err != nil {
+        l.Close()
+        return nil, err
+    }
+    return l, nil
+}
+
+// newHTTPServer applies the configured timeouts and limits to an HTTP server for addr
+func newHTTPServer(cfg *Config, addr string, handler http.Handler) *httpServer {
+    // HTTP/2 is negotiated over TLS; h2c serves it on plaintext connections
+    // that open with the HTTP/2 preface
+    protocols := new(http.Protocols)
+    protocols.SetHTTP1(true)
+    protocols.SetHTTP2(cfg.TLSCert != "")
+    protocols.SetUnencryptedHTTP2(cfg.H2C && cfg.TLSCert == "")
+    return &httpServer{
+        Server: &http.Server{
+            Addr:              addr,
+            Handler:           handler,
+            ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+            ReadTimeout:       cfg.ReadTimeout,
+            WriteTimeout:      cfg.WriteTimeout,
+            IdleTimeout:       cfg.IdleTimeout,
+            MaxHeaderBytes:    cfg.MaxHeaderBytes,
+            Protocols:         protocols,
+            HTTP2:             &http.HTTP2Config{MaxConcurrentStreams: cfg.HTTP2MaxStreams},
+        },
+        maxConns: cfg.MaxConns,
+        certFile: cfg.TLSCert,
+        keyFile:  cfg.TLSKey,
+    }
+}
+
+// streamsDone is closed when the public listener begins shutting down, since
+// long-lived streams never become idle for Shutdown to reap
+var streamsDone = make(chan struct{})
+
+var closeStreamsOnce sync.Once
+
+// closeStreams ends every open event stream
+func closeStreams() {
+    closeStreamsOnce.Do(func() { close(streamsDone) })
+}
+
+// runServers starts every server and blocks until ctx is cancelled or one of
+// them fails, then drains them all within the grace period
+func runServers(ctx context.Context, stop context.CancelFunc, servers []namedServer, grace time.Duration) error {
+    errCh := make(chan error, len(servers))
+    for _, s := range servers {
+        s := s
+        go func() {
+            slog.Info("listening", "server", s.name, "addr", s.addr)
+            if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, errServerClosed) {
+                errCh <- err
+            }
+        }()
+    }
+
+    var runErr error
+    select {
+    case runErr = <-errCh:
+    case <-ctx.Done():
+    }
+    stop() // A second signal terminates immediately
+
+    // Stop accepting new connections and wait for in-flight requests to drain
+    slog.Info("shutting down, draining connections", "grace", grace)
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+    defer cancel()
+
+    var wg sync.WaitGroup
+    for _, s := range servers {
+        s := s
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if err := s.srv.Shutdown(shutdownCtx); err != nil {
+                slog.Warn("graceful shutdown incomplete", "server", s.name, "err", err)
+                s.srv.Close()
+            }
+        }()
+    }
+    wg.Wait()
+    return runErr
+}
+
+// maxReconnectBackoff caps the delay between reconnect attempts of outbound bridges
+const maxReconnectBackoff = 30 * time.Second
+
+// reconnectLoop runs session until done is closed, retrying with exponential
+// backoff that resets whenever a session got past its handshake
+func reconnectLoop(name string, done <-chan struct{}, session func() (connected bool, err error)) error {
+    backoff := 500 * time.Millisecond
+    for {
+        connected, err := session()
+        select {
+        case <-done:
+            return errServerClosed
+        default:
+        }
+        if connected {
+            backoff = 500 * time.Millisecond
+        }
+        slog.Warn("connection lost, reconnecting", "bridge", name, "err", err, "backoff", backoff)
+        select {
+        case <-done:
+            return errServerClosed
+        case <-time.After(backoff):
+        }
+        backoff = min(backoff*2, maxReconnectBackoff)
+    }
+}
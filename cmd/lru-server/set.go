@@ -0,0 +1,223 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "lru-cache/lrucache"
+)
+
+var memberParam = param{Name: "member", In: "path", Description: "Set member", Required: true}
+
+// setRoutes lists the endpoints adding, removing and checking set members
+func setRoutes(h *handlers) []route {
+    return []route{
+        {
+            Method:  http.MethodGet,
+            Path:    "/v1/cache/{key}/members",
+            Summary: "Get every member of a set, in sorted order",
+            Handler: h.getSetHandler,
+            Params:  []param{keyParam, consistencyParam},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The members and their number", ContentType: "application/json", Body: SetResponse{}},
+                errorResponse(http.StatusNotFound, "Key not found (KEY_NOT_FOUND) or expired (EXPIRED)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/v1/cache/{key}/members/{member}",
+            Summary: "Check whether a value is a member of a set",
+            Handler: h.getMemberHandler,
+            Params:  []param{keyParam, memberParam, consistencyParam},
+            Response: []response{
+                {Status: http.StatusNoContent, Description: "The value is a member"},
+                errorResponse(http.StatusNotFound, "Not a member, or the key is missing (KEY_NOT_FOUND)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+        {
+            Method:  http.MethodPut,
+            Path:    "/v1/cache/{key}/members/{member}",
+            Summary: "Add a member to a set, creating the set if needed; the set keeps its expiration",
+            Handler: h.putMemberHandler,
+            Params:  []param{keyParam, memberParam},
+            Response: []response{
+                {Status: http.StatusCreated, Description: "Member added"},
+                {Status: http.StatusNoContent, Description: "Already a member"},
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+                errorResponse(http.StatusRequestEntityTooLarge, "Set too large (PAYLOAD_TOO_LARGE)"),
+            },
+        },
+        {
+            Method:  http.MethodDelete,
+            Path:    "/v1/cache/{key}/members/{member}",
+            Summary: "Remove a member from a set; removing the last member deletes the key",
+            Handler: h.deleteMemberHandler,
+            Params:  []param{keyParam, memberParam},
+            Response: []response{
+                {Status: http.StatusNoContent, Description: "Member removed"},
+                errorResponse(http.StatusNotFound, "Not a member (KEY_NOT_FOUND)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+    }
+}
+
+// SetResponse is the body of GET /v1/cache/{key}/members
+type SetResponse struct {
+    Members []string `json:"members"`
+    Count   int      `json:"count"`
+}
+
+// getSetHandler handles GET /v1/cache/{key}/members
+func (h *handlers) getSetHandler(w http.ResponseWriter, r *http.Request) {
+    sets, ok := supported[lrucache.Sets](w, h.cache)
+    if !ok {
+        return
+    }
+    span := startSpan(r.Context(), "cache.smembers")
+    members, err := sets.SMembers(r.PathValue("key"))
+    span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+    span.End()
+    if err != nil {
+        writeLookupError(w, err)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(SetResponse{Members: members, Count: len(members)})
+}
+
+// getMemberHandler handles GET /v1/cache/{key}/members/{member}
+func (h *handlers) getMemberHandler(w http.ResponseWriter, r *http.Request) {
+    sets, ok := supported[lrucache.Sets](w, h.cache)
+    if !ok {
+        return
+    }
+    span := startSpan(r.Context(), "cache.sismember")
+    member, err := sets.SIsMember(r.PathValue("key"), r.PathValue("member"))
+    span.SetAttributes(attribute.Bool("cache.hit", member))
+    span.End()
+    switch {
+    case err != nil:
+        writeCacheError(w, err)
+    case !member:
+        writeError(w, http.StatusNotFound, codeKeyNotFound, "Not a member")
+    default:
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// putMemberHandler handles PUT /v1/cache/{key}/members/{member}
+func (h *handlers) putMemberHandler(w http.ResponseWriter, r *http.Request) {
+    sets, ok := supported[lrucache.Sets](w, h.cache)
+    if !ok {
+        return
+    }
+    span := startSpan(r.Context(), "cache.sadd")
+    n, err := sets.SAdd(r.PathValue("key"), r.PathValue("member"))
+    span.End()
+    if err != nil {
+        writeCacheError(w, err)
+        return
+    }
+    audit(httpCaller("http", r), "sadd", r.PathValue("key"))
+    if n > 0 {
+        w.WriteHeader(http.StatusCreated)
+    } else {
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// deleteMemberHandler handles DELETE /v1/cache/{key}/members/{member}
+func (h *handlers) deleteMemberHandler(w http.ResponseWriter, r *http.Request) {
+    sets, ok := supported[lrucache.Sets](w, h.cache)
+    if !ok {
+        return
+    }
+    span := startSpan(r.Context(), "cache.srem")
+    n, err := sets.SRem(r.PathValue("key"), r.PathValue("member"))
+    span.SetAttributes(attribute.Bool("cache.hit", n > 0))
+    span.End()
+    switch {
+    case err != nil:
+        writeCacheError(w, err)
+    case n == 0:
+        writeError(w, http.StatusNotFound, codeKeyNotFound, "Not a member")
+    default:
+        audit(httpCaller("http", r), "srem", r.PathValue("key"))
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// members implements SADD and SREM key member [member ...], SISMEMBER key
+// member, SMEMBERS key and SCARD key
+func (s *respSession) members(cmd string, args []string) {
+    switch {
+    case (cmd == "SADD" || cmd == "SREM") && len(args) < 2,
+        cmd == "SISMEMBER" && len(args) != 2,
+        (cmd == "SMEMBERS" || cmd == "SCARD") && len(args) != 1:
+        s.writeWrongArgs(cmd)
+        return
+    }
+    key := args[0]
+    sets, ok := respSupported[lrucache.Sets](s)
+    if !ok {
+        return
+    }
+    switch cmd {
+    case "SADD", "SREM":
+        if !s.allowed(RoleWrite) {
+            return
+        }
+        change, op := sets.SAdd, "sadd"
+        if cmd == "SREM" {
+            change, op = sets.SRem, "srem"
+        }
+        n, err := change(key, args[1:]...)
+        if err != nil {
+            s.writeCacheError(err)
+            return
+        }
+        if n > 0 {
+            audit(s.caller(), op, key)
+        }
+        s.writeInt(int64(n))
+    case "SISMEMBER":
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        member, err := sets.SIsMember(key, args[1])
+        switch {
+        case err != nil:
+            s.writeCacheError(err)
+        case member:
+            s.writeInt(1)
+        default:
+            s.writeInt(0)
+        }
+    case "SMEMBERS":
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        members, err := sets.SMembers(key)
+        if errors.Is(err, lrucache.ErrWrongType) {
+            s.writeCacheError(err)
+            return
+        }
+        s.writeArray(members)
+    case "SCARD":
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        n, err := sets.SCard(key)
+        if err != nil {
+            s.writeCacheError(err)
+            return
+        }
+        s.writeInt(int64(n))
+    }
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// sseHeartbeat keeps idle streams alive through proxies that time out quiet connections
+const sseHeartbeat = 15 * time.Second
+
+// eventsHandler streams cache events of the namespace parameter, the default
+// one if absent, for keys matching the prefix and pattern parameters as
+// Server-Sent Events; a "dropped" event tells the client it fell behind and
+// should resync
+func (h *handlers) eventsHandler(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    var types []string
+    if query.Get("types") != "" {
+        types = strings.Split(query.Get("types"), ",")
+    }
+    filter, err := eventFilter(query.Get("prefix"), query.Get("pattern"), types)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+        return
+    }
+    if ns := query.Get("namespace"); ns != "" && ns != defaultNamespace {
+        if h.namespaces.Get(ns) == nil {
+            writeError(w, http.StatusNotFound, codeNotFound, "No such namespace")
+            return
+        }
+        filter.Namespace = ns
+    }
+
+    rc := http.NewResponseController(w)
+    // The server's read and write timeouts would otherwise cut the stream off
+    rc.SetReadDeadline(time.Time{})
+    rc.SetWriteDeadline(time.Time{})
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+
+    sub := events.SubscribeFilter(filter, 1024)
+    defer events.Unsubscribe(sub)
+
+    fmt.Fprint(w, "retry: 3000\n\n")
+    if rc.Flush() != nil {
+        return
+    }
+
+    heartbeat := time.NewTicker(sseHeartbeat)
+    defer heartbeat.Stop()
+    var dropped int64
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case <-streamsDone:
+            return
+        case <-heartbeat.C:
+            fmt.Fprint(w, ": ping\n\n")
+        case e := <-sub.C:
+            if n := sub.Dropped(); n > dropped {
+                fmt.Fprintf(w, "event: dropped\ndata: {\"count\":%d}\n\n", n-dropped)
+                dropped = n
+            }
+            data, _ := json.Marshal(newEventMessage(e))
+            fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+        }
+        if rc.Flush() != nil {
+            return
+        }
+    }
+}
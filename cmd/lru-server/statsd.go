@@ -0,0 +1,183 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "log/slog"
+    "net"
+    "strings"
+    "sync"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// statsdMaxPacket keeps datagrams under a typical MTU
+const statsdMaxPacket = 1400
+
+// StatsD pushes cache and HTTP metrics to a StatsD or DogStatsD agent over
+// UDP every interval; counters are sent as deltas since the previous flush
+type StatsD struct {
+    addr       string
+    prefix     string
+    tags       []string
+    interval   time.Duration
+    namespaces *Namespaces
+    metrics    *HTTPMetrics
+
+    conn     net.Conn
+    last     map[string]lrucache.CacheStats
+    requests map[requestKey]uint64
+    latency  map[routeKey]histogram
+    buf      bytes.Buffer
+
+    done     chan struct{}
+    stopped  chan struct{}
+    stopOnce sync.Once
+}
+
+// NewStatsD creates an emitter; tags use the DogStatsD "|#k:v" extension
+func NewStatsD(addr, prefix string, tags []string, interval time.Duration, namespaces *Namespaces, metrics *HTTPMetrics) *StatsD {
+    return &StatsD{
+        addr:       addr,
+        prefix:     prefix,
+        tags:       tags,
+        interval:   interval,
+        namespaces: namespaces,
+        metrics:    metrics,
+        last:       make(map[string]lrucache.CacheStats),
+        requests:   make(map[requestKey]uint64),
+        latency:    make(map[routeKey]histogram),
+        done:       make(chan struct{}),
+        stopped:    make(chan struct{}),
+    }
+}
+
+// ListenAndServe flushes metrics every interval until shut down
+func (s *StatsD) ListenAndServe() error {
+    defer close(s.stopped)
+    conn, err := net.Dial("udp", s.addr)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    s.conn = conn
+
+    ticker := time.NewTicker(s.interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            s.flush()
+        case <-s.done:
+            s.flush()
+            return errServerClosed
+        }
+    }
+}
+
+// flush sends one round of metrics
+func (s *StatsD) flush() {
+    seen := make(map[string]bool)
+    for _, ns := range namespaceStats(s.namespaces) {
+        seen[ns.Name] = true
+        tags := []string{"namespace:" + ns.Name}
+        last := s.last[ns.Name]
+        s.emit("entries", ns.Entries, "g", tags)
+        s.emit("capacity", ns.Capacity, "g", tags)
+        s.emit("memory_bytes", ns.Bytes, "g", tags)
+        s.emit("compression_ratio", fmt.Sprintf("%.3f", ns.CompressionRatio()), "g", tags)
+        s.emit("hits", counterDelta(ns.Hits, last.Hits), "c", tags)
+        s.emit("misses", counterDelta(ns.Misses, last.Misses), "c", tags)
+        s.emit("evictions", counterDelta(ns.Evictions, last.Evictions), "c", tags)
+        s.emit("expirations", counterDelta(ns.Expirations, last.Expirations), "c", tags)
+        s.emit("stale_hits", counterDelta(ns.StaleHits, last.StaleHits), "c", tags)
+        s.emit("refreshes", counterDelta(ns.Refreshes, last.Refreshes), "c", tags)
+        s.emit("rejections", counterDelta(ns.Rejections, last.Rejections), "c", tags)
+        s.emit("coalesced", counterDelta(ns.Coalesced, last.Coalesced), "c", tags)
+        s.emit("corruptions", counterDelta(ns.Corruptions, last.Corruptions), "c", tags)
+        s.emit("quota_rejections", counterDelta(ns.QuotaRejections, last.QuotaRejections), "c", tags)
+        s.last[ns.Name] = ns.CacheStats
+    }
+    // A namespace deleted and created again starts from zero
+    for name := range s.last {
+        if !seen[name] {
+            delete(s.last, name)
+        }
+    }
+    mem := memoryStats(s.namespaces)
+    s.emit("process_memory_bytes", mem.ProcessBytes, "g", nil)
+    if mem.LimitBytes > 0 {
+        s.emit("memory_limit_bytes", mem.LimitBytes, "g", nil)
+    }
+
+    requests, latency := s.metrics.snapshot()
+    for k, n := range requests {
+        if delta := n - s.requests[k]; delta > 0 {
+            s.emit("http.requests", delta, "c", []string{"method:" + k.method, "route:" + k.route, "code:" + k.code})
+        }
+    }
+    for k, h := range latency {
+        prev := s.latency[k]
+        if count := h.count - prev.count; count > 0 {
+            // Histograms only keep sums, so the interval's mean is what can be reported
+            mean := (h.sum - prev.sum) / float64(count) * 1000
+            s.emit("http.request.duration_ms", fmt.Sprintf("%.3f", mean), "g", []string{"method:" + k.method, "route:" + k.route})
+        }
+    }
+    s.requests, s.latency = requests, latency
+    s.send()
+}
+
+// counterDelta is how much a counter grew from last to cur; a counter below
+// its last value was reset, as by a namespace recreated between two flushes,
+// and all of cur is new
+func counterDelta(cur, last uint64) uint64 {
+    if cur < last {
+        return cur
+    }
+    return cur - last
+}
+
+// emit appends a metric line, sending the packet first if the line would overflow it
+func (s *StatsD) emit(name string, value interface{}, typ string, tags []string) {
+    line := fmt.Sprintf("%s%s:%v|%s", s.prefix, name, value, typ)
+    if all := append(append([]string(nil), s.tags...), tags...); len(all) > 0 {
+        line += "|#" + strings.Join(all, ",")
+    }
+    if s.buf.Len() > 0 && s.buf.Len()+1+len(line) > statsdMaxPacket {
+        s.send()
+    }
+    if s.buf.Len() > 0 {
+        s.buf.WriteByte('\n')
+    }
+    s.buf.WriteString(line)
+}
+
+func (s *StatsD) send() {
+    if s.buf.Len() == 0 {
+        return
+    }
+    if _, err := s.conn.Write(s.buf.Bytes()); err != nil {
+        slog.Debug("statsd: send failed", "addr", s.addr, "err", err)
+    }
+    s.buf.Reset()
+}
+
+// Shutdown sends a final flush
+func (s *StatsD) Shutdown(ctx context.Context) error {
+    s.stopOnce.Do(func() { close(s.done) })
+    select {
+    case <-s.stopped:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Close stops the emitter without waiting for the final flush
+func (s *StatsD) Close() error {
+    s.stopOnce.Do(func() { close(s.done) })
+    return nil
+}
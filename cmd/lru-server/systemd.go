@@ -0,0 +1,78 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// systemdPrefix starts listen addresses naming a socket passed by systemd
+// socket activation, as systemd:http for the socket of a .socket unit with
+// FileDescriptorName=http, or systemd:0 for the first one passed
+const systemdPrefix = "systemd:"
+
+// listenFDsStart is the first file descriptor systemd passes sockets as
+const listenFDsStart = 3
+
+var (
+    systemdOnce    sync.Once
+    systemdMutex   sync.Mutex
+    systemdSockets map[string]*os.File // By name and by position; taken once each
+    systemdErr     error
+)
+
+// systemdListener returns the listener of the socket systemd passed as name
+func systemdListener(name string) (net.Listener, error) {
+    systemdOnce.Do(func() { systemdSockets, systemdErr = inheritSockets() })
+    if systemdErr != nil {
+        return nil, fmt.Errorf("listen %s%s: %w", systemdPrefix, name, systemdErr)
+    }
+    systemdMutex.Lock()
+    f, found := systemdSockets[name]
+    for key, other := range systemdSockets {
+        if other == f {
+            delete(systemdSockets, key)
+        }
+    }
+    systemdMutex.Unlock()
+    if !found {
+        return nil, fmt.Errorf("listen %s%s: systemd passed no socket of that name, or it is already in use", systemdPrefix, name)
+    }
+    // FileListener duplicates the descriptor, close-on-exec, so the original goes
+    defer f.Close()
+    return net.FileListener(f)
+}
+
+// inheritSockets takes the sockets systemd passed, as sd_listen_fds(3)
+// describes, and unsets the variables listing them so that child processes
+// do not take them too
+func inheritSockets() (map[string]*os.File, error) {
+    defer os.Unsetenv("LISTEN_PID")
+    defer os.Unsetenv("LISTEN_FDS")
+    defer os.Unsetenv("LISTEN_FDNAMES")
+    if pid := os.Getenv("LISTEN_PID"); pid != strconv.Itoa(os.Getpid()) {
+        return nil, errors.New("no sockets passed; start the service through its .socket unit")
+    }
+    n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+    if err != nil || n <= 0 {
+        return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+    }
+    names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+    sockets := make(map[string]*os.File, 2*n)
+    for i := range n {
+        name := strconv.Itoa(i)
+        f := os.NewFile(uintptr(listenFDsStart+i), name)
+        sockets[name] = f
+        if i < len(names) && names[i] != "" {
+            // Several sockets of a unit share its name; the first one wins
+            if _, taken := sockets[names[i]]; !taken {
+                sockets[names[i]] = f
+            }
+        }
+    }
+    return sockets, nil
+}
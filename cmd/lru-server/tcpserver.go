@@ -0,0 +1,163 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "log/slog"
+    "net"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// tcpServer accepts raw TCP connections and hands each one to handle,
+// tracking them so shutdown can drain in-flight commands
+type tcpServer struct {
+    Addr     string
+    MaxConns int
+    handle   func(conn net.Conn, closing func() bool)
+
+    mutex    sync.Mutex
+    listener net.Listener
+    conns    map[net.Conn]struct{}
+    wg       sync.WaitGroup
+    closing  atomic.Bool
+}
+
+// errServerClosed is returned by ListenAndServe after Shutdown or Close
+var errServerClosed = errors.New("tcp: server closed")
+
+// ListenAndServe accepts connections until the server is shut down
+func (s *tcpServer) ListenAndServe() error {
+    l, err := listen(s.Addr)
+    if err != nil {
+        return err
+    }
+    return s.Serve(l)
+}
+
+// Serve accepts connections on l until the server is shut down
+func (s *tcpServer) Serve(l net.Listener) error {
+    l = newLimitListener(l, s.MaxConns)
+    s.mutex.Lock()
+    s.listener = l
+    s.conns = make(map[net.Conn]struct{})
+    s.mutex.Unlock()
+
+    for {
+        conn, err := l.Accept()
+        if err != nil {
+            if s.closing.Load() {
+                return errServerClosed
+            }
+            var ne net.Error
+            if errors.As(err, &ne) && ne.Timeout() {
+                time.Sleep(10 * time.Millisecond)
+                continue
+            }
+            return err
+        }
+        s.track(conn, true)
+        s.wg.Add(1)
+        go func() {
+            defer s.wg.Done()
+            defer s.track(conn, false)
+            defer conn.Close()
+            s.handle(conn, s.closing.Load)
+        }()
+    }
+}
+
+func (s *tcpServer) track(conn net.Conn, add bool) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    if add {
+        s.conns[conn] = struct{}{}
+    } else {
+        delete(s.conns, conn)
+    }
+}
+
+// Shutdown stops accepting connections, interrupts idle reads and waits for
+// handlers to finish their current command
+func (s *tcpServer) Shutdown(ctx context.Context) error {
+    s.closing.Store(true)
+    s.mutex.Lock()
+    if s.listener != nil {
+        s.listener.Close()
+    }
+    for conn := range s.conns {
+        conn.SetReadDeadline(time.Now())
+    }
+    s.mutex.Unlock()
+
+    done := make(chan struct{})
+    go func() {
+        s.wg.Wait()
+        close(done)
+    }()
+    select {
+    case <-done:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Close immediately closes the listener and all connections
+func (s *tcpServer) Close() error {
+    s.closing.Store(true)
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    if s.listener != nil {
+        s.listener.Close()
+    }
+    for conn := range s.conns {
+        conn.Close()
+    }
+    return nil
+}
+
+// errLineTooLong is returned by readLimitedLine for lines over its limit
+var errLineTooLong = errors.New("line too long")
+
+// readLimitedLine reads a CRLF (or bare LF) terminated line of at most max bytes
+func readLimitedLine(r *bufio.Reader, max int) (string, error) {
+    var line []byte
+    for {
+        chunk, isPrefix, err := r.ReadLine()
+        if err != nil {
+            return "", err
+        }
+        line = append(line, chunk...)
+        if len(line) > max {
+            return "", errLineTooLong
+        }
+        if !isPrefix {
+            return string(line), nil
+        }
+    }
+}
+
+// flushDrained flushes buffered responses once every pipelined request already
+// received has been answered, so a burst of commands costs one write; last
+// forces the flush when the session is about to end
+func flushDrained(r *bufio.Reader, w *bufio.Writer, last bool) error {
+    if r.Buffered() > 0 && !last {
+        return nil
+    }
+    return w.Flush()
+}
+
+// logConnError logs unexpected connection errors, ignoring those caused by shutdown
+func logConnError(proto string, conn net.Conn, err error, closing func() bool) {
+    var ne net.Error
+    if closing() && errors.As(err, &ne) && ne.Timeout() {
+        return
+    }
+    if errors.Is(err, net.ErrClosed) {
+        return
+    }
+    slog.Warn("connection error", "proto", proto, "remote", conn.RemoteAddr().String(), "err", err)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "os"
+    "slices"
+    "strings"
+)
+
+// Tenant confines an API key to the keys starting with Prefix in one
+// namespace, so that services sharing a server cannot read or overwrite each
+// other's entries
+type Tenant struct {
+    Namespace string `json:"namespace"` // default unless set
+    Prefix    string `json:"prefix"`
+    Role      string `json:"role"` // read or write, the default
+    roles     Role
+}
+
+// loadTenants reads a -tenants-file: a JSON object from each API key to its
+// Tenant, as in
+//
+//	{"k3y-for-billing": {"namespace": "sessions", "prefix": "billing:"},
+//	 "k3y-for-reports": {"prefix": "reports:", "role": "read"}}
+func loadTenants(path string, namespaces []NamespaceConfig) (map[string]*Tenant, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var tenants map[string]*Tenant
+    if err := json.Unmarshal(data, &tenants); err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    for key, t := range tenants {
+        // Never print the key itself
+        name := apiKeySubject(key)
+        if strings.TrimSpace(key) != key || key == "" {
+            return nil, fmt.Errorf("%s: API key %s is empty or has surrounding spaces", path, name)
+        }
+        if t.Namespace == "" {
+            t.Namespace = defaultNamespace
+        }
+        if t.Namespace != defaultNamespace && !slices.ContainsFunc(namespaces, func(ns NamespaceConfig) bool { return ns.Name == t.Namespace }) {
+            return nil, fmt.Errorf("%s: API key %s: no namespace %s is defined", path, name, t.Namespace)
+        }
+        switch t.Role {
+        case "read":
+            t.roles = RoleRead
+        case "write", "":
+            t.roles = RoleRead | RoleWrite
+        default:
+            return nil, fmt.Errorf("%s: API key %s: role must be read or write", path, name)
+        }
+    }
+    return tenants, nil
+}
+
+// tenantRoute reports whether tenants may call the route at path: those
+// naming the keys, namespace or prefix they touch, which are then checked
+func tenantRoute(path string) bool {
+    return path == "/cache" || path == "/cache/txn" || path == "/cache/watch" || strings.Contains(path, "{key}") || strings.Contains(path, "{namespace}") || strings.Contains(path, "{prefix...}")
+}
+
+// requireKey answers 400 when a route whose params require the key query
+// parameter is called without it. It runs before tenantGuard, which checks
+// only the keys a request names, so a missing key cannot slip past it.
+func requireKey(params []param, next http.HandlerFunc) http.HandlerFunc {
+    required := false
+    for _, p := range params {
+        if p.Name == "key" && p.In == "query" && p.Required {
+            required = true
+        }
+    }
+    if !required {
+        return next
+    }
+    return func(w http.ResponseWriter, r *http.Request) {
+        if !r.URL.Query().Has("key") {
+            writeError(w, http.StatusBadRequest, codeBadRequest, "key is required")
+            return
+        }
+        next(w, r)
+    }
+}
+
+// tenantGuard answers 403 when a tenant calls the route at path with keys,
+// a namespace or a prefix outside its own, or calls a route that could reach
+// them unchecked
+func tenantGuard(path string, next http.HandlerFunc) http.HandlerFunc {
+    allowed := tenantRoute(path)
+    return func(w http.ResponseWriter, r *http.Request) {
+        p := principalFrom(r.Context())
+        if p == nil || p.Tenant == nil || path == "/openapi.json" {
+            next(w, r)
+            return
+        }
+        if !allowed {
+            writeError(w, http.StatusForbidden, codeForbidden, "This API key may only use the cache endpoints")
+            return
+        }
+        query := r.URL.Query()
+        keys := query["union"]
+        if query.Has("key") || query.Has("keys") {
+            keys = append(keys, requestedKeys(r)...)
+        }
+        if strings.Contains(path, "{key}") {
+            keys = append(keys, r.PathValue("key"))
+        }
+        if strings.Contains(path, "{prefix...}") {
+            keys = append(keys, r.PathValue("prefix"))
+        }
+        namespace := r.PathValue("namespace")
+        if namespace == "" {
+            namespace = defaultNamespace
+        }
+        if namespace != p.Tenant.Namespace {
+            writeError(w, http.StatusForbidden, codeForbidden, "This API key may only use namespace "+p.Tenant.Namespace)
+            return
+        }
+        if checkTenantKeys(w, r, keys...) {
+            next(w, r)
+        }
+    }
+}
+
+// checkTenantKeys answers 403 and returns false if the caller is a tenant and
+// any of keys falls outside its prefix
+func checkTenantKeys(w http.ResponseWriter, r *http.Request, keys ...string) bool {
+    p := principalFrom(r.Context())
+    if p == nil || p.Tenant == nil {
+        return true
+    }
+    for _, key := range keys {
+        if !strings.HasPrefix(key, p.Tenant.Prefix) {
+            writeError(w, http.StatusForbidden, codeForbidden, fmt.Sprintf("This API key may only use keys starting with %q", p.Tenant.Prefix))
+            return false
+        }
+    }
+    return true
+}
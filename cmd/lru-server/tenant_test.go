@@ -0,0 +1,47 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestRequireKeyBeforeTenantGuard(t *testing.T) {
+    tenant := &Principal{Subject: "t", Tenant: &Tenant{Namespace: "ns", Prefix: "t:"}}
+    tests := []struct {
+        name       string
+        principal  *Principal
+        query      string
+        wantStatus int
+        wantServed bool
+    }{
+        {"missing key", nil, "", http.StatusBadRequest, false},
+        {"tenant missing key", tenant, "", http.StatusBadRequest, false},
+        {"tenant empty key", tenant, "?key=", http.StatusForbidden, false},
+        {"tenant other key", tenant, "?key=other", http.StatusForbidden, false},
+        {"tenant own key", tenant, "?key=t:k", http.StatusNoContent, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            served := false
+            path := "/cache/{namespace}"
+            params := []param{namespaceParam, {Name: "key", In: "query", Description: "Cache key", Required: true}}
+            mux := http.NewServeMux()
+            mux.HandleFunc(http.MethodDelete+" "+path, requireKey(params, tenantGuard(path, func(w http.ResponseWriter, r *http.Request) {
+                served = true
+                w.WriteHeader(http.StatusNoContent)
+            })))
+
+            r := httptest.NewRequest(http.MethodDelete, "/cache/ns"+tt.query, nil)
+            if tt.principal != nil {
+                r = r.WithContext(context.WithValue(r.Context(), principalKey{}, tt.principal))
+            }
+            w := httptest.NewRecorder()
+            mux.ServeHTTP(w, r)
+            if w.Code != tt.wantStatus || served != tt.wantServed {
+                t.Fatalf("status = %d %s, served = %v; want %d, %v", w.Code, w.Body, served, tt.wantStatus, tt.wantServed)
+            }
+        })
+    }
+}
@@ -0,0 +1,30 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// isTerminal reports whether fd is a terminal
+func isTerminal(fd int) bool {
+    _, err := unix.IoctlGetTermios(fd, unix.TIOCGETA)
+    return err == nil
+}
+
+// makeRaw puts the terminal fd into raw mode, reading keys one at a time
+// without echo or signals, and returns the function restoring it
+func makeRaw(fd int) (func(), error) {
+    old, err := unix.IoctlGetTermios(fd, unix.TIOCGETA)
+    if err != nil {
+        return nil, err
+    }
+    raw := *old
+    raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+    raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+    raw.Cflag &^= unix.CSIZE | unix.PARENB
+    raw.Cflag |= unix.CS8
+    raw.Cc[unix.VMIN], raw.Cc[unix.VTIME] = 1, 0
+    if err := unix.IoctlSetTermios(fd, unix.TIOCSETA, &raw); err != nil {
+        return nil, err
+    }
+    return func() { unix.IoctlSetTermios(fd, unix.TIOCSETA, old) }, nil
+}
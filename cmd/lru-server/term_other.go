@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package main
+
+import "errors"
+
+// isTerminal reports false, leaving the REPL to read plain lines
+func isTerminal(fd int) bool { return false }
+
+func makeRaw(fd int) (func(), error) {
+    return nil, errors.New("line editing is not supported on this system")
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    "net/http"
+    "strings"
+    "sync"
+
+    "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/sdk/resource"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans the server starts
+const tracerName = "lru-cache"
+
+// Tracer exports the spans of requests and cache operations to an OTLP/HTTP
+// collector; it installs itself as the global OpenTelemetry tracer provider
+type Tracer struct {
+    endpoint string
+    provider *sdktrace.TracerProvider
+    done     chan struct{}
+    stopOnce sync.Once
+}
+
+// tracer is nil unless -otlp-endpoint is set, which disables every span
+var tracer *Tracer
+
+// NewTracer exports to endpoint + /v1/traces, sampling new traces at ratio
+// and following the decision of an incoming W3C traceparent
+func NewTracer(endpoint, service string, ratio float64) (*Tracer, error) {
+    endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+    exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+    if err != nil {
+        return nil, err
+    }
+    provider := sdktrace.NewTracerProvider(
+        sdktrace.WithBatcher(exporter),
+        sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+        sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+    )
+    otel.SetTracerProvider(provider)
+    otel.SetTextMapPropagator(propagation.TraceContext{})
+    otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+        slog.Warn("otlp: export failed", "err", err)
+    }))
+    return &Tracer{endpoint: endpoint, provider: provider, done: make(chan struct{})}, nil
+}
+
+// startSpan starts an internal child span of the span in ctx; without a
+// parent it returns a no-op span so that background work does not create
+// orphan traces
+func startSpan(ctx context.Context, name string) trace.Span {
+    if !trace.SpanContextFromContext(ctx).IsValid() {
+        return trace.SpanFromContext(ctx)
+    }
+    _, span := otel.Tracer(tracerName).Start(ctx, name)
+    return span
+}
+
+// traceparent formats a span context as a W3C traceparent header
+func traceparent(sc trace.SpanContext) string {
+    return "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-" + sc.TraceFlags().String()
+}
+
+// traceMiddleware wraps each request in a server span named after its route
+func traceMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+    if tracer == nil {
+        return next
+    }
+    route := func(r *http.Request) string {
+        if _, pattern := mux.Handler(r); pattern != "" && pattern != "/" {
+            _, route, _ := strings.Cut(pattern, " ")
+            return route
+        }
+        return ""
+    }
+    inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        span := trace.SpanFromContext(r.Context())
+        span.SetAttributes(attribute.String("client.address", clientIP(r)))
+        if route := route(r); route != "" {
+            span.SetAttributes(attribute.String("http.route", route))
+        }
+        w.Header().Set("traceresponse", traceparent(span.SpanContext()))
+        next.ServeHTTP(w, r)
+    })
+    return otelhttp.NewHandler(inner, "http", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+        if route := route(r); route != "" {
+            return r.Method + " " + route
+        }
+        return r.Method
+    }))
+}
+
+// ListenAndServe waits until shut down; the provider exports in the background
+func (t *Tracer) ListenAndServe() error {
+    <-t.done
+    return errServerClosed
+}
+
+// Shutdown exports the remaining spans
+func (t *Tracer) Shutdown(ctx context.Context) error {
+    t.stopOnce.Do(func() { close(t.done) })
+    return t.provider.Shutdown(ctx)
+}
+
+// Close stops exporting without waiting
+func (t *Tracer) Close() error {
+    t.stopOnce.Do(func() { close(t.done) })
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    t.provider.Shutdown(ctx)
+    return nil
+}
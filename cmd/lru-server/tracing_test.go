@@ -0,0 +1,188 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+
+    coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+    commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+    tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+    "google.golang.org/protobuf/proto"
+)
+
+// collector is an OTLP/HTTP endpoint keeping the spans exported to it
+type collector struct {
+    *httptest.Server
+    mutex    sync.Mutex
+    spans    []*tracepb.Span
+    services []string
+}
+
+func newCollector(t *testing.T) *collector {
+    c := &collector{}
+    c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+            http.Error(w, "unexpected request", http.StatusBadRequest)
+            return
+        }
+        body, _ := io.ReadAll(r.Body)
+        var req coltracepb.ExportTraceServiceRequest
+        if err := proto.Unmarshal(body, &req); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        c.mutex.Lock()
+        defer c.mutex.Unlock()
+        for _, rs := range req.ResourceSpans {
+            if name := attr(rs.Resource.GetAttributes(), "service.name"); name != "" {
+                c.services = append(c.services, name)
+            }
+            for _, ss := range rs.ScopeSpans {
+                c.spans = append(c.spans, ss.Spans...)
+            }
+        }
+        w.Header().Set("Content-Type", "application/x-protobuf")
+    }))
+    t.Cleanup(c.Close)
+    return c
+}
+
+// span returns the exported span named name, or nil
+func (c *collector) span(name string) *tracepb.Span {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    for _, s := range c.spans {
+        if s.Name == name {
+            return s
+        }
+    }
+    return nil
+}
+
+// attr returns the value of the attribute key as a string
+func attr(attrs []*commonpb.KeyValue, key string) string {
+    for _, a := range attrs {
+        if a.Key != key {
+            continue
+        }
+        if v, ok := a.Value.Value.(*commonpb.AnyValue_IntValue); ok {
+            return strconv.FormatInt(v.IntValue, 10)
+        }
+        return a.Value.GetStringValue()
+    }
+    return ""
+}
+
+// tracedServer serves GET /v1/cache/{key} behind traceMiddleware, exporting
+// to c with the sampling ratio
+func tracedServer(t *testing.T, c *collector, ratio float64) (*httptest.Server, *Tracer) {
+    tr, err := NewTracer(c.URL, "cache-test", ratio)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tracer = tr
+    t.Cleanup(func() { tracer = nil })
+    mux := http.NewServeMux()
+    mux.HandleFunc("GET /v1/cache/{key}", func(w http.ResponseWriter, r *http.Request) {
+        span := startSpan(r.Context(), "cache.get")
+        span.End()
+        w.Write([]byte("value"))
+    })
+    server := httptest.NewServer(traceMiddleware(mux, mux))
+    t.Cleanup(server.Close)
+    return server, tr
+}
+
+func TestTraceMiddlewareContinuesIncomingTrace(t *testing.T) {
+    c := newCollector(t)
+    server, tr := tracedServer(t, c, 0) // Only the caller's decision samples
+    const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+
+    req, _ := http.NewRequest(http.MethodGet, server.URL+"/v1/cache/k", nil)
+    req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if got := resp.Header.Get("traceresponse"); !strings.HasPrefix(got, "00-"+traceID+"-") || !strings.HasSuffix(got, "-01") {
+        t.Fatalf("traceresponse = %q, want the incoming trace, sampled", got)
+    }
+    if err := tr.Shutdown(context.Background()); err != nil {
+        t.Fatalf("Shutdown: %v", err)
+    }
+
+    root := c.span("GET /v1/cache/{key}")
+    if root == nil {
+        t.Fatalf("no server span among %d exported", len(c.spans))
+    }
+    tests := []struct {
+        name, got, want string
+    }{
+        {"trace id", hex.EncodeToString(root.TraceId), traceID},
+        {"parent span id", hex.EncodeToString(root.ParentSpanId), parentID},
+        {"kind", root.Kind.String(), tracepb.Span_SPAN_KIND_SERVER.String()},
+        {"http.route", attr(root.Attributes, "http.route"), "/v1/cache/{key}"},
+        {"http.status_code", attr(root.Attributes, "http.status_code"), "200"},
+        {"service.name", strings.Join(c.services, ","), "cache-test"},
+    }
+    for _, tt := range tests {
+        if tt.got != tt.want {
+            t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+        }
+    }
+    child := c.span("cache.get")
+    if child == nil || hex.EncodeToString(child.ParentSpanId) != hex.EncodeToString(root.SpanId) {
+        t.Fatalf("cache.get span = %v, want a child of the server span", child)
+    }
+}
+
+func TestTraceMiddlewareSampling(t *testing.T) {
+    tests := []struct {
+        name        string
+        ratio       float64
+        traceparent string
+        exported    bool
+    }{
+        {"new trace sampled", 1, "", true},
+        {"new trace dropped", 0, "", false},
+        {"caller dropped", 1, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false},
+        {"malformed traceparent starts a trace", 1, "00-zz-00f067aa0ba902b7-01", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c := newCollector(t)
+            server, tr := tracedServer(t, c, tt.ratio)
+            req, _ := http.NewRequest(http.MethodGet, server.URL+"/v1/cache/k", nil)
+            if tt.traceparent != "" {
+                req.Header.Set("traceparent", tt.traceparent)
+            }
+            resp, err := http.DefaultClient.Do(req)
+            if err != nil {
+                t.Fatal(err)
+            }
+            resp.Body.Close()
+            tr.Shutdown(context.Background())
+            if exported := c.span("GET /v1/cache/{key}") != nil; exported != tt.exported {
+                t.Fatalf("exported = %v, want %v", exported, tt.exported)
+            }
+        })
+    }
+}
+
+func TestStartSpanWithoutParent(t *testing.T) {
+    c := newCollector(t)
+    _, tr := tracedServer(t, c, 1)
+    startSpan(context.Background(), "background").End()
+    tr.Shutdown(context.Background())
+    if c.span("background") != nil {
+        t.Fatal("a span without a parent was exported")
+    }
+}
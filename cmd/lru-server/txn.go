@@ -0,0 +1,320 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "lru-cache/lrucache"
+)
+
+// TxnRequest is the body of POST /cache/txn
+type TxnRequest struct {
+    Ops []TxnOperation `json:"ops"`
+}
+
+// TxnOperation is one operation of a TxnRequest; the conditions are checked
+// against the key as the earlier operations leave it
+type TxnOperation struct {
+    Op         string  `json:"op"` // set, delete, incr, get or check, which only tests the conditions
+    Key        string  `json:"key"`
+    Value      string  `json:"value,omitempty"`      // Written by set
+    Expiration int     `json:"expiration,omitempty"` // Of a set, in seconds
+    Delta      int64   `json:"delta,omitempty"`      // Added by incr
+    Exists     *bool   `json:"exists,omitempty"`     // Requires the key to be live, or missing if false
+    Equals     *string `json:"equals,omitempty"`     // Requires the key to hold this value
+    CAS        uint64  `json:"cas,omitempty"`        // Requires the key to hold this CAS value, as a get returned it
+}
+
+// TxnResponse answers POST /cache/txn with a result per operation
+type TxnResponse struct {
+    Results []TxnOperationResult `json:"results"`
+}
+
+// TxnOperationResult is what an operation of a transaction did
+type TxnOperationResult struct {
+    Found bool    `json:"found"`           // Whether the key was live before the operation
+    CAS   uint64  `json:"cas,omitempty"`   // After a set or incr, or as a get read it
+    Value *string `json:"value,omitempty"` // Read by a get, or left by an incr
+}
+
+// txnRoute is the endpoint applying several operations at once
+func txnRoute(h *handlers) route {
+    return route{
+        Method:  http.MethodPost,
+        Path:    "/cache/txn",
+        Summary: "Apply a list of conditional get, set, delete and incr operations atomically: all of them or, if any condition fails, none. Read with get, then write with the cas it returned, to change keys safely against concurrent clients.",
+        Handler: h.txnHandler,
+        Body:    TxnRequest{},
+        Response: []response{
+            {Status: http.StatusOK, Description: "Every operation applied", ContentType: "application/json", Body: TxnResponse{}},
+            errorResponse(http.StatusBadRequest, "Malformed body, unknown op or a value that is not an integer (BAD_REQUEST)"),
+            errorResponse(http.StatusConflict, "A condition failed and nothing was applied (CONFLICT), or an incr key holds another kind of value (WRONG_TYPE)"),
+            errorResponse(http.StatusTooManyRequests, "The writes would exceed the namespace's quota (QUOTA_EXCEEDED)"),
+        },
+    }
+}
+
+// txnHandler handles POST /cache/txn
+func (h *handlers) txnHandler(w http.ResponseWriter, r *http.Request) {
+    txns, ok := supported[lrucache.Transactional](w, h.cache)
+    if !ok {
+        return
+    }
+    var req TxnRequest
+    if !h.decodeJSON(w, r, &req) {
+        return
+    }
+    if len(req.Ops) == 0 {
+        writeError(w, http.StatusBadRequest, codeBadRequest, "ops must list at least one operation")
+        return
+    }
+    ops := make([]lrucache.TxnOp, len(req.Ops))
+    keys := make([]string, len(req.Ops))
+    for i, op := range req.Ops {
+        switch op.Op {
+        case "set", "delete", "incr", "get", "check":
+        default:
+            writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Operation %d: op must be set, delete, incr, get or check", i))
+            return
+        }
+        if !h.checkValueSize(w, op.Value) {
+            return
+        }
+        ops[i] = lrucache.TxnOp{Op: op.Op, Key: op.Key, Value: op.Value, Expiration: time.Duration(op.Expiration) * time.Second, Delta: op.Delta, CAS: op.CAS, Equals: op.Equals}
+        if op.Exists != nil {
+            ops[i].Mode = lrucache.StoreIfAbsent
+            if *op.Exists {
+                ops[i].Mode = lrucache.StoreIfPresent
+            }
+        }
+        keys[i] = op.Key
+    }
+    if !checkTenantKeys(w, r, keys...) {
+        return
+    }
+
+    span := startSpan(r.Context(), "cache.txn")
+    span.SetAttributes(attribute.Int("cache.ops", len(ops)))
+    results, err := txns.Txn(ops...)
+    span.End()
+    if err != nil {
+        var txnErr *lrucache.TxnError
+        switch {
+        case !errors.As(err, &txnErr):
+            writeCacheError(w, err)
+        case errors.Is(err, lrucache.ErrConditionFailed), errors.Is(err, lrucache.ErrCASMismatch), errors.Is(err, lrucache.ErrCASNotFound):
+            writeError(w, http.StatusConflict, codeConflict, fmt.Sprintf("Operation %d: %v; nothing was applied", txnErr.Index, txnErr.Err))
+        case errors.Is(err, lrucache.ErrWrongType), errors.Is(err, lrucache.ErrQuotaExceeded), errors.Is(err, lrucache.ErrTooLarge), errors.Is(err, lrucache.ErrCorrupt):
+            writeCacheError(w, err)
+        default:
+            // Such as incr of a value that is not an integer
+            writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("Operation %d: %v; nothing was applied", txnErr.Index, txnErr.Err))
+        }
+        return
+    }
+
+    resp := TxnResponse{Results: make([]TxnOperationResult, len(results))}
+    for i, res := range results {
+        resp.Results[i] = TxnOperationResult{Found: res.Found, CAS: res.CAS}
+        switch req.Ops[i].Op {
+        case "get":
+            if res.Found {
+                resp.Results[i].Value = &res.Value
+            }
+        case "incr":
+            n := strconv.FormatInt(res.N, 10)
+            resp.Results[i].Value = &n
+            fallthrough
+        case "set", "delete":
+            audit(httpCaller("http", r), req.Ops[i].Op, req.Ops[i].Key)
+        }
+    }
+    writeJSON(w, resp)
+}
+
+// queuedCommand is a command sent between MULTI and EXEC, as the operations
+// of the transaction it runs as
+type queuedCommand struct {
+    cmd string
+    ops []lrucache.TxnOp
+}
+
+// transaction implements MULTI, EXEC, DISCARD, WATCH key [key ...] and UNWATCH
+func (s *respSession) transaction(cmd string, args []string) {
+    switch cmd {
+    case "MULTI":
+        if len(args) != 0 {
+            s.writeWrongArgs(cmd)
+        } else if s.multi {
+            s.writeError("ERR MULTI calls can not be nested")
+        } else {
+            s.multi = true
+            s.writeSimple("OK")
+        }
+    case "EXEC":
+        if !s.multi {
+            s.writeError("ERR EXEC without MULTI")
+            return
+        }
+        s.exec()
+    case "DISCARD":
+        if !s.multi {
+            s.writeError("ERR DISCARD without MULTI")
+            return
+        }
+        s.resetTxn()
+        s.writeSimple("OK")
+    case "WATCH":
+        if len(args) == 0 {
+            s.writeWrongArgs(cmd)
+            return
+        }
+        if s.multi {
+            s.writeError("ERR WATCH inside MULTI is not allowed")
+            return
+        }
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        txn, ok := respSupported[lrucache.Transactional](s)
+        if !ok {
+            return
+        }
+        s.watched = append(s.watched, txn.Watch(args...)...)
+        s.writeSimple("OK")
+    case "UNWATCH":
+        s.watched = nil
+        s.writeSimple("OK")
+    }
+}
+
+// queue adds a command sent after MULTI to the transaction, or replies with
+// an error and makes EXEC discard the transaction if it cannot run in one
+func (s *respSession) queue(cmd string, args []string) {
+    fail := func(msg string) {
+        s.writeError(msg)
+        s.txnFailed = true
+    }
+    q := queuedCommand{cmd: cmd}
+    role := RoleWrite
+    switch cmd {
+    case "GET", "INCR":
+        if len(args) != 1 {
+            fail(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+            return
+        }
+        q.ops = []lrucache.TxnOp{{Op: "get", Key: args[0]}}
+        role = s.auth.ReadRole()
+        if cmd == "INCR" {
+            q.ops[0] = lrucache.TxnOp{Op: "incr", Key: args[0], Delta: 1}
+            role = RoleWrite
+        }
+    case "SET":
+        if len(args) < 2 {
+            fail("ERR wrong number of arguments for 'set' command")
+            return
+        }
+        expiration, nx, xx, err := parseSetOptions(args[2:])
+        if err != nil {
+            fail(err.Error())
+            return
+        }
+        if nx || xx {
+            fail("ERR SET NX and XX are not supported inside MULTI; WATCH the key instead")
+            return
+        }
+        if len(args[1]) > s.maxValueBytes {
+            fail("ERR value exceeds the maximum size")
+            return
+        }
+        q.ops = []lrucache.TxnOp{{Op: "set", Key: args[0], Value: args[1], Expiration: expiration}}
+    case "DEL":
+        if len(args) == 0 {
+            fail("ERR wrong number of arguments for 'del' command")
+            return
+        }
+        for _, key := range args {
+            q.ops = append(q.ops, lrucache.TxnOp{Op: "delete", Key: key})
+        }
+    default:
+        fail(fmt.Sprintf("ERR command '%s' is not supported inside MULTI", strings.ToLower(cmd)))
+        return
+    }
+    if !s.allowed(role) {
+        s.txnFailed = true
+        return
+    }
+    s.queued = append(s.queued, q)
+    s.writeSimple("QUEUED")
+}
+
+// exec applies the queued commands and the watched keys' checks as one
+// transaction, replying with a reply per command, or a null array if a
+// watched key changed
+func (s *respSession) exec() {
+    defer s.resetTxn()
+    if s.txnFailed {
+        s.writeError("EXECABORT Transaction discarded because of previous errors.")
+        return
+    }
+    txn, ok := respSupported[lrucache.Transactional](s)
+    if !ok {
+        return
+    }
+    ops := slices.Clone(s.watched)
+    for _, q := range s.queued {
+        ops = append(ops, q.ops...)
+    }
+    results, err := txn.Txn(ops...)
+    var txnErr *lrucache.TxnError
+    if errors.As(err, &txnErr) && txnErr.Index < len(s.watched) {
+        s.w.WriteString("*-1\r\n")
+        return
+    }
+    if err != nil {
+        s.writeError("EXECABORT Transaction discarded because of: " + err.Error())
+        return
+    }
+
+    results = results[len(s.watched):]
+    s.w.WriteString("*" + strconv.Itoa(len(s.queued)) + "\r\n")
+    for _, q := range s.queued {
+        res := results[:len(q.ops)]
+        results = results[len(q.ops):]
+        switch q.cmd {
+        case "GET":
+            if res[0].Found {
+                s.writeBulk(res[0].Value)
+            } else {
+                s.writeNull()
+            }
+        case "INCR":
+            audit(s.caller(), "incr", q.ops[0].Key)
+            s.writeInt(res[0].N)
+        case "SET":
+            audit(s.caller(), "set", q.ops[0].Key)
+            s.writeSimple("OK")
+        case "DEL":
+            var n int64
+            for i, r := range res {
+                if r.Found {
+                    audit(s.caller(), "delete", q.ops[i].Key)
+                    n++
+                }
+            }
+            s.writeInt(n)
+        }
+    }
+}
+
+// resetTxn ends MULTI and forgets the watched keys
+func (s *respSession) resetTxn() {
+    s.multi, s.queued, s.txnFailed, s.watched = false, nil, false, nil
+}
@@ -0,0 +1,195 @@
+// Dashboard of the admin listener: polls /debug/vars for stats, lists keys
+// from /debug/lru and drives /admin/flush, /admin/config and /admin/keys.
+"use strict";
+
+const pollInterval = 2000;
+const historyLength = 60;
+const keyLimit = 200;
+
+const $ = (id) => document.getElementById(id);
+let token = sessionStorage.getItem("lru-token") || "";
+let previous = null; // Last stats sample, for rates
+const history = { hits: [], ops: [] };
+
+// api calls the admin endpoint path, returning the decoded JSON body or null
+async function api(method, path, body) {
+  const headers = {};
+  if (token) headers["Authorization"] = "Bearer " + token;
+  if (body !== undefined) headers["Content-Type"] = "application/json";
+  const resp = await fetch(path, { method, headers, body: body === undefined ? undefined : JSON.stringify(body) });
+  if (resp.status === 401 || resp.status === 403) {
+    $("token-form").hidden = false;
+  }
+  if (!resp.ok) {
+    let message = resp.statusText;
+    try {
+      message = (await resp.json()).error.message;
+    } catch (e) {}
+    throw new Error(message);
+  }
+  return resp.status === 204 ? null : resp.json();
+}
+
+function status(text, error) {
+  $("status").textContent = text;
+  $("status").className = error ? "error" : "";
+}
+
+function formatBytes(n) {
+  const units = ["B", "KiB", "MiB", "GiB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+}
+
+function formatDuration(seconds) {
+  const d = Math.floor(seconds / 86400), h = Math.floor(seconds / 3600) % 24, m = Math.floor(seconds / 60) % 60;
+  if (d > 0) return d + "d " + h + "h";
+  if (h > 0) return h + "h " + m + "m";
+  return m + "m " + (seconds % 60) + "s";
+}
+
+// plot draws values, scaled to their maximum, as the polyline of the svg id
+function plot(id, values, max) {
+  max = max || Math.max(...values, 1);
+  const offset = historyLength - values.length;
+  const points = values.map((v, i) => (offset + i) + "," + (20 - (v / max) * 19).toFixed(2));
+  $(id).querySelector("polyline").setAttribute("points", points.join(" "));
+}
+
+function push(series, value) {
+  series.push(value);
+  if (series.length > historyLength) series.shift();
+}
+
+async function refreshStats() {
+  let vars;
+  try {
+    vars = await api("GET", "/debug/vars");
+  } catch (e) {
+    status("Stats unavailable: " + e.message, true);
+    return;
+  }
+  const s = vars.cache, now = Date.now();
+  const lookups = s.Hits + s.Misses;
+  $("hit-ratio").textContent = lookups ? (100 * s.Hits / lookups).toFixed(1) + "%" : "–";
+  $("entries").textContent = s.Entries.toLocaleString() + " / " + s.Capacity.toLocaleString();
+  $("fill").style.width = Math.min(100, 100 * s.Entries / s.Capacity) + "%";
+  $("bytes").textContent = formatBytes(s.Bytes);
+  $("evictions").textContent = s.Evictions.toLocaleString();
+  $("expirations").textContent = s.Expirations.toLocaleString();
+  $("uptime").textContent = "up " + formatDuration(vars.uptime_seconds);
+  if (document.activeElement !== $("capacity")) $("capacity").value = s.Capacity;
+
+  if (previous) {
+    const elapsed = (now - previous.at) / 1000;
+    const dHits = s.Hits - previous.Hits, dLookups = lookups - previous.Hits - previous.Misses;
+    const ops = Math.max(0, dLookups) / elapsed;
+    $("ops").textContent = ops.toFixed(ops < 10 ? 1 : 0);
+    $("eviction-rate").textContent = (Math.max(0, s.Evictions - previous.Evictions) / elapsed).toFixed(1) + "/s";
+    push(history.ops, ops);
+    push(history.hits, dLookups > 0 ? dHits / dLookups : (history.hits.at(-1) || 0));
+    plot("ops-chart", history.ops);
+    plot("hit-chart", history.hits, 1);
+  }
+  previous = Object.assign({ at: now }, s);
+}
+
+// pattern turns the search box into a /debug/lru match: a pattern as typed
+// when it has wildcards, or the keys containing the text otherwise
+function pattern() {
+  const q = $("search").value;
+  if (q === "" || /[*?]/.test(q)) return q;
+  return "*" + q.replace(/[\\*?]/g, "\\$&") + "*";
+}
+
+async function refreshKeys() {
+  let resp;
+  try {
+    resp = await api("GET", "/debug/lru?limit=" + keyLimit + "&truncate=80&match=" + encodeURIComponent(pattern()));
+  } catch (e) {
+    status("Keys unavailable: " + e.message, true);
+    return;
+  }
+  const rows = resp.order.map((e) => {
+    const tr = document.createElement("tr");
+    const ttl = e.ttl < 0 ? "never" : e.ttl === 0 ? "expired" : formatDuration(e.ttl);
+    const cells = [[e.key, ""], [e.value + (e.truncated ? "…" : ""), "value"], [e.kind || "string", ""], [ttl, "num"], [formatBytes(e.bytes), "num"]];
+    for (const [text, cls] of cells) {
+      const td = document.createElement("td");
+      td.textContent = text;
+      td.className = cls;
+      tr.appendChild(td);
+    }
+    const del = document.createElement("button");
+    del.textContent = "Delete";
+    del.className = "danger";
+    del.addEventListener("click", () => deleteKey(e.key));
+    const td = document.createElement("td");
+    td.appendChild(del);
+    tr.appendChild(td);
+    return tr;
+  });
+  $("keys").replaceChildren(...rows);
+  $("key-count").textContent = rows.length === keyLimit ? "first " + keyLimit + " matches" : rows.length + " matching";
+}
+
+async function deleteKey(key) {
+  try {
+    await api("DELETE", "/admin/keys/" + encodeURIComponent(key));
+    status("Deleted " + key);
+  } catch (e) {
+    status("Delete failed: " + e.message, true);
+  }
+  refreshKeys();
+}
+
+$("flush").addEventListener("click", async () => {
+  if (!confirm("Remove every entry from the cache?")) return;
+  try {
+    const resp = await api("POST", "/admin/flush");
+    status("Flushed " + resp.count + " entries");
+  } catch (e) {
+    status("Flush failed: " + e.message, true);
+  }
+  refreshStats();
+  refreshKeys();
+});
+
+$("resize-form").addEventListener("submit", async (ev) => {
+  ev.preventDefault();
+  const capacity = parseInt($("capacity").value, 10);
+  try {
+    const resp = await api("PUT", "/admin/config", { capacity });
+    status("Capacity " + resp.config.capacity.toLocaleString() + (resp.evicted ? ", " + resp.evicted + " entries evicted" : ""));
+  } catch (e) {
+    status("Resize failed: " + e.message, true);
+  }
+  $("capacity").blur();
+  refreshStats();
+  refreshKeys();
+});
+
+$("token-form").addEventListener("submit", (ev) => {
+  ev.preventDefault();
+  token = $("token").value;
+  sessionStorage.setItem("lru-token", token);
+  $("token-form").hidden = true;
+  status("");
+  refreshStats();
+  refreshKeys();
+});
+
+let searchTimer;
+$("search").addEventListener("input", () => {
+  clearTimeout(searchTimer);
+  searchTimer = setTimeout(refreshKeys, 250);
+});
+$("refresh").addEventListener("click", refreshKeys);
+
+refreshStats();
+refreshKeys();
+setInterval(refreshStats, pollInterval);
@@ -0,0 +1,344 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// WAN replication tuning
+const (
+    wanBuffer     = 1 << 16  // Changes queued before they are dropped
+    wanMaxPending = 1 << 20  // Changes kept while the remote cluster is unreachable
+    wanMaxBody    = 64 << 20 // Largest compressed batch the receiving side accepts
+)
+
+// wan is nil unless -wan-replicate-to is set
+var wan *WANShipper
+
+// WANChange is one change in a batch shipped to another cluster; Time is
+// when it was made, which decides conflicts there
+type WANChange struct {
+    Op        string    `json:"op"` // set, delete or flush
+    Key       string    `json:"key,omitempty"`
+    Value     string    `json:"value,omitempty"`
+    Kind      string    `json:"kind,omitempty"`
+    Flags     uint32    `json:"flags,omitempty"`
+    ExpiresAt time.Time `json:"expires_at,omitzero"`
+    StaleAt   time.Time `json:"stale_at,omitzero"`
+    Time      time.Time `json:"time"`
+}
+
+// WANBatch is the gzip-compressed body of POST /admin/wan
+type WANBatch struct {
+    Source  string      `json:"source"` // Name of the sending cluster
+    Changes []WANChange `json:"changes"`
+}
+
+// WANApplyResponse reports what the receiving cluster did with a batch
+type WANApplyResponse struct {
+    Applied   int `json:"applied"`
+    Conflicts int `json:"conflicts"` // Changes older than the entry they would have replaced
+}
+
+// WANStatus is published as expvar "wan"
+type WANStatus struct {
+    Target     string      `json:"target,omitempty"`
+    Shipped    uint64      `json:"shipped"`
+    Batches    uint64      `json:"batches"`
+    Conflicts  uint64      `json:"conflicts"` // Shipped changes the remote cluster resolved in favor of its own
+    Pending    int         `json:"pending"`
+    Dropped    int64       `json:"dropped"`     // Changes lost because a queue was full
+    LagSeconds float64     `json:"lag_seconds"` // Age of the oldest change not yet acknowledged
+    LastError  string      `json:"last_error,omitempty"`
+    LastSent   *time.Time  `json:"last_sent,omitempty"`
+    Received   WANReceived `json:"received"`
+}
+
+// WANReceived counts batches other clusters shipped to this one
+type WANReceived struct {
+    Batches    uint64  `json:"batches"`
+    Applied    uint64  `json:"applied"`
+    Conflicts  uint64  `json:"conflicts"`
+    LagSeconds float64 `json:"lag_seconds"` // Age of the newest change in the last batch, by this node's clock
+}
+
+// wanReceived is updated by wanHandler whether or not this node ships
+var wanReceived struct {
+    batches, applied, conflicts atomic.Uint64
+    newest                      atomic.Int64 // Unix nanoseconds of the newest change received
+}
+
+// WANShipper ships the changes made here, batched and compressed, to the
+// admin listener of a cluster in another datacenter, which applies each
+// one unless it holds a later write of the key. It suits active/standby
+// setups; clusters shipping to each other send every change back once.
+type WANShipper struct {
+    target   string
+    token    string
+    source   string
+    interval time.Duration
+    batch    int
+    client   *http.Client
+    sub      *lrucache.Subscription
+
+    mutex     sync.Mutex // Guards pending, lastError and lastSent
+    pending   []WANChange
+    lastError string
+    lastSent  time.Time
+    shipped   atomic.Uint64
+    batches   atomic.Uint64
+    conflicts atomic.Uint64
+    dropped   atomic.Int64 // Changes the pending queue had no room for
+    done      chan struct{}
+    stopped   chan struct{}
+    stopOnce  sync.Once
+}
+
+// NewWANShipper ships to the admin listener at cfg.WANTarget
+func NewWANShipper(cfg *Config) *WANShipper {
+    source := cfg.WANSource
+    if source == "" {
+        source, _ = os.Hostname()
+    }
+    return &WANShipper{
+        target:   strings.TrimSuffix(cfg.WANTarget, "/") + "/admin/wan",
+        token:    cfg.WANToken,
+        source:   source,
+        interval: cfg.WANInterval,
+        batch:    cfg.WANBatch,
+        client:   &http.Client{Timeout: 30 * time.Second},
+        sub:      events.Subscribe("", wanBuffer),
+        done:     make(chan struct{}),
+        stopped:  make(chan struct{}),
+    }
+}
+
+// wanChange converts the events worth shipping; evictions and expirations
+// are each cluster's own business and invalidations came from elsewhere
+func wanChange(e lrucache.Event) (WANChange, bool) {
+    switch e.Type {
+    case lrucache.EventSet:
+        return WANChange{Op: "set", Key: e.Key, Value: e.Value, Kind: string(e.Kind), Flags: e.Flags, ExpiresAt: e.ExpiresAt, StaleAt: e.StaleAt, Time: e.Time}, true
+    case lrucache.EventDelete:
+        return WANChange{Op: "delete", Key: e.Key, Time: e.Time}, true
+    case lrucache.EventFlush:
+        return WANChange{Op: "flush", Time: e.Time}, true
+    }
+    return WANChange{}, false
+}
+
+// Status reports the counters and the replication lag
+func (s *WANShipper) Status() WANStatus {
+    st := wanReceivedStatus()
+    if s == nil {
+        return st
+    }
+    s.mutex.Lock()
+    st.Target, st.Pending, st.LastError = s.target, len(s.pending), s.lastError
+    if len(s.pending) > 0 {
+        st.LagSeconds = time.Since(s.pending[0].Time).Seconds()
+    }
+    if !s.lastSent.IsZero() {
+        sent := s.lastSent
+        st.LastSent = &sent
+    }
+    s.mutex.Unlock()
+    st.Shipped, st.Batches, st.Conflicts, st.Dropped = s.shipped.Load(), s.batches.Load(), s.conflicts.Load(), s.sub.Dropped()+s.dropped.Load()
+    return st
+}
+
+func wanReceivedStatus() WANStatus {
+    st := WANStatus{Received: WANReceived{
+        Batches:   wanReceived.batches.Load(),
+        Applied:   wanReceived.applied.Load(),
+        Conflicts: wanReceived.conflicts.Load(),
+    }}
+    if newest := wanReceived.newest.Load(); newest != 0 {
+        st.Received.LagSeconds = time.Since(time.Unix(0, newest)).Seconds()
+    }
+    return st
+}
+
+// ListenAndServe collects changes and ships them every interval, or as soon
+// as a batch fills, until shut down
+func (s *WANShipper) ListenAndServe() error {
+    defer close(s.stopped)
+    ticker := time.NewTicker(s.interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case e := <-s.sub.C:
+            change, ok := wanChange(e)
+            if !ok {
+                continue
+            }
+            s.mutex.Lock()
+            if len(s.pending) < wanMaxPending {
+                s.pending = append(s.pending, change)
+            } else {
+                s.dropped.Add(1)
+            }
+            // While the remote cluster is unreachable only the ticker retries
+            full := len(s.pending) >= s.batch && s.lastError == ""
+            s.mutex.Unlock()
+            if full {
+                s.ship()
+            }
+        case <-ticker.C:
+            s.ship()
+        case <-s.done:
+            s.ship()
+            return errServerClosed
+        }
+    }
+}
+
+// ship sends the pending changes in batches, stopping at the first failure
+// so the rest are retried in order on the next tick
+func (s *WANShipper) ship() {
+    for {
+        s.mutex.Lock()
+        n := min(len(s.pending), s.batch)
+        changes := s.pending[:n:n]
+        s.mutex.Unlock()
+        if n == 0 {
+            return
+        }
+        resp, err := s.send(changes)
+        s.mutex.Lock()
+        if err != nil {
+            if s.lastError == "" {
+                slog.Warn("wan: cannot ship changes, retrying", "target", s.target, "pending", len(s.pending), "err", err)
+            }
+            s.lastError = err.Error()
+            s.mutex.Unlock()
+            return
+        }
+        if s.lastError != "" {
+            slog.Info("wan: shipping again", "target", s.target)
+        }
+        s.pending, s.lastError, s.lastSent = s.pending[n:], "", time.Now().UTC()
+        s.mutex.Unlock()
+        s.shipped.Add(uint64(n))
+        s.batches.Add(1)
+        s.conflicts.Add(uint64(resp.Conflicts))
+    }
+}
+
+// send posts one compressed batch
+func (s *WANShipper) send(changes []WANChange) (WANApplyResponse, error) {
+    var body bytes.Buffer
+    gz := gzip.NewWriter(&body)
+    json.NewEncoder(gz).Encode(WANBatch{Source: s.source, Changes: changes})
+    gz.Close()
+
+    var out WANApplyResponse
+    req, err := http.NewRequest(http.MethodPost, s.target, &body)
+    if err != nil {
+        return out, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Content-Encoding", "gzip")
+    if s.token != "" {
+        req.Header.Set("Authorization", "Bearer "+s.token)
+    }
+    resp, err := s.client.Do(req)
+    if err != nil {
+        return out, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        io.Copy(io.Discard, resp.Body)
+        return out, fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+    }
+    return out, json.NewDecoder(resp.Body).Decode(&out)
+}
+
+// Shutdown ships what is pending, once
+func (s *WANShipper) Shutdown(ctx context.Context) error {
+    s.stopOnce.Do(func() { close(s.done) })
+    select {
+    case <-s.stopped:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Close stops shipping; pending changes are lost
+func (s *WANShipper) Close() error {
+    s.stopOnce.Do(func() { close(s.done) })
+    return nil
+}
+
+// wanHandler handles POST /admin/wan, applying a batch from another cluster
+// with last write wins
+func (h *handlers) wanHandler(w http.ResponseWriter, r *http.Request) {
+    replicated, ok := supported[lrucache.Replicated](w, h.cache)
+    if !ok {
+        return
+    }
+    if raftFollower(w) {
+        return
+    }
+    var body io.Reader = http.MaxBytesReader(w, r.Body, wanMaxBody)
+    if r.Header.Get("Content-Encoding") == "gzip" {
+        gz, err := gzip.NewReader(body)
+        if err != nil {
+            writeError(w, http.StatusBadRequest, codeBadRequest, "Malformed gzip body")
+            return
+        }
+        body = gz
+    }
+    var batch WANBatch
+    if err := json.NewDecoder(body).Decode(&batch); err != nil {
+        writeError(w, http.StatusBadRequest, codeBadRequest, "Malformed JSON body")
+        return
+    }
+
+    var resp WANApplyResponse
+    var newest time.Time
+    for _, c := range batch.Changes {
+        applied := true
+        switch c.Op {
+        case "set":
+            applied = replicated.StoreIfNewer(lrucache.Entry{Key: c.Key, Value: c.Value, Kind: lrucache.Kind(c.Kind), Flags: c.Flags, ExpiresAt: c.ExpiresAt, StaleAt: c.StaleAt}, c.Time)
+        case "delete":
+            // A key already gone is no conflict
+            applied = replicated.DeleteIfNewer(c.Key, c.Time) || !h.cache.Contains(c.Key)
+        case "flush":
+            replicated.FlushOlder(c.Time)
+        default:
+            continue
+        }
+        if applied {
+            resp.Applied++
+        } else {
+            resp.Conflicts++
+        }
+        if c.Time.After(newest) {
+            newest = c.Time
+        }
+    }
+    wanReceived.batches.Add(1)
+    wanReceived.applied.Add(uint64(resp.Applied))
+    wanReceived.conflicts.Add(uint64(resp.Conflicts))
+    if !newest.IsZero() {
+        wanReceived.newest.Store(newest.UnixNano())
+    }
+    slog.Debug("wan: applied batch", "source", batch.Source, "applied", resp.Applied, "conflicts", resp.Conflicts)
+    writeJSON(w, resp)
+}
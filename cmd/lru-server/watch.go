@@ -0,0 +1,72 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// Bounds of the timeout parameter of GET /cache/watch
+const (
+    defaultWatchTimeout = 30 * time.Second
+    maxWatchTimeout     = 5 * time.Minute
+)
+
+// watchRoute is the long-poll endpoint waiting for a key to change
+func watchRoute(h *handlers) route {
+    return route{
+        Method:  http.MethodGet,
+        Path:    "/cache/watch",
+        Summary: "Wait until a key is written, deleted, expired or evicted, then answer as a GET of it would",
+        Handler: h.watchHandler,
+        Params: []param{
+            {Name: "key", In: "query", Description: "Cache key", Required: true},
+            {Name: "timeout", In: "query", Description: "How long to wait, such as 30s (the default); at most 5m"},
+        },
+        Response: []response{
+            {Status: http.StatusOK, Description: "The new value", ContentType: "text/plain"},
+            {Status: http.StatusNotModified, Description: "The key did not change before the timeout"},
+            errorResponse(http.StatusNotFound, "The key was deleted, expired or evicted (KEY_NOT_FOUND)"),
+            errorResponse(http.StatusBadRequest, "Missing key or malformed timeout (BAD_REQUEST)"),
+        },
+    }
+}
+
+// watchHandler handles GET /cache/watch
+func (h *handlers) watchHandler(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    key := query.Get("key")
+    if !query.Has("key") {
+        writeError(w, http.StatusBadRequest, codeBadRequest, "key is required")
+        return
+    }
+    timeout := defaultWatchTimeout
+    if s := query.Get("timeout"); s != "" {
+        d, err := time.ParseDuration(s)
+        if err != nil || d <= 0 || d > maxWatchTimeout {
+            writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("timeout must be a duration up to %s, such as 30s", maxWatchTimeout))
+            return
+        }
+        timeout = d
+    }
+    // The server's write timeout would otherwise cut the wait short
+    rc := http.NewResponseController(w)
+    rc.SetWriteDeadline(time.Now().Add(timeout + 10*time.Second))
+
+    sub := events.SubscribeFilter(lrucache.EventFilter{Pattern: lrucache.QuoteGlob(key)}, 16)
+    defer events.Unsubscribe(sub)
+    timer := time.NewTimer(timeout)
+    defer timer.Stop()
+    select {
+    case <-r.Context().Done():
+    case <-streamsDone:
+        w.WriteHeader(http.StatusNotModified)
+    case <-timer.C:
+        w.WriteHeader(http.StatusNotModified)
+    case <-sub.C:
+        // Answer with the latest value, which a later write may already have replaced
+        h.writeValue(w, r, key)
+    }
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "os"
+    "sync"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// Batching and retrying of webhook deliveries
+const (
+    webhookBatchSize  = 100
+    webhookFlushDelay = 200 * time.Millisecond
+    webhookMaxBackoff = 30 * time.Second
+)
+
+// defaultWebhookTypes are the events a webhook receives unless it lists others
+var defaultWebhookTypes = []string{"set", "delete", "expire", "evict"}
+
+// Webhook is a URL cache events are POSTed to as JSON arrays of EventMessage
+type Webhook struct {
+    URL     string   `json:"url"`
+    Prefix  string   `json:"prefix,omitempty"`  // Of the keys whose events are sent
+    Pattern string   `json:"pattern,omitempty"` // Unless empty, keys must also match it, as for POST /cache/purge
+    Types   []string `json:"types,omitempty"`   // set, delete, expire and evict unless set
+    filter  lrucache.EventFilter
+}
+
+// check validates the URL and event types and builds the filter
+func (wh *Webhook) check() error {
+    u, err := url.Parse(wh.URL)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fmt.Errorf("webhook %q: want an http:// or https:// URL", redactURL(wh.URL))
+    }
+    if len(wh.Types) == 0 {
+        wh.Types = defaultWebhookTypes
+    }
+    if wh.filter, err = eventFilter(wh.Prefix, wh.Pattern, wh.Types); err != nil {
+        return fmt.Errorf("webhook %s: %w", redactURL(wh.URL), err)
+    }
+    return nil
+}
+
+// loadWebhooks reads a -webhooks-file: a JSON array of Webhook, as in
+//
+//	[{"url": "https://hooks.example.com/cache", "prefix": "user:", "types": ["set", "delete"]},
+//	 {"url": "http://invalidator:8000/", "types": ["evict", "expire"]}]
+func loadWebhooks(path string) ([]Webhook, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var webhooks []Webhook
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&webhooks); err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    for i := range webhooks {
+        if err := webhooks[i].check(); err != nil {
+            return nil, fmt.Errorf("%s: %w", path, err)
+        }
+    }
+    return webhooks, nil
+}
+
+// webhookSender delivers events to every configured webhook, each from its
+// own subscription so that a slow target only holds up itself
+type webhookSender struct {
+    webhooks []Webhook
+    retries  int
+    client   *http.Client
+    done     chan struct{}
+    stopped  chan struct{}
+    stopOnce sync.Once
+}
+
+func newWebhookSender(webhooks []Webhook, retries int) *webhookSender {
+    return &webhookSender{
+        webhooks: webhooks,
+        retries:  retries,
+        client:   &http.Client{Timeout: 10 * time.Second},
+        done:     make(chan struct{}),
+        stopped:  make(chan struct{}),
+    }
+}
+
+// target describes the webhooks, for log messages
+func (s *webhookSender) target() string {
+    if len(s.webhooks) == 1 {
+        return redactURL(s.webhooks[0].URL)
+    }
+    return fmt.Sprintf("%d webhooks", len(s.webhooks))
+}
+
+// ListenAndServe delivers events until shut down
+func (s *webhookSender) ListenAndServe() error {
+    defer close(s.stopped)
+    var wg sync.WaitGroup
+    for _, wh := range s.webhooks {
+        sub := events.SubscribeFilter(wh.filter, 4096)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            defer events.Unsubscribe(sub)
+            s.deliver(wh, sub)
+        }()
+    }
+    wg.Wait()
+    return errServerClosed
+}
+
+// deliver batches the events of sub and posts them to wh until shut down,
+// then posts what is left once
+func (s *webhookSender) deliver(wh Webhook, sub *lrucache.Subscription) {
+    batch := make([]EventMessage, 0, webhookBatchSize)
+    flush := time.NewTimer(webhookFlushDelay)
+    flush.Stop()
+    var dropped int64
+    for {
+        select {
+        case e := <-sub.C:
+            if len(batch) == 0 {
+                flush.Reset(webhookFlushDelay)
+            }
+            if batch = append(batch, newEventMessage(e)); len(batch) < webhookBatchSize {
+                continue
+            }
+        case <-flush.C:
+        case <-s.done:
+            for len(sub.C) > 0 {
+                batch = append(batch, newEventMessage(<-sub.C))
+            }
+            if len(batch) > 0 {
+                s.post(wh, batch, 0)
+            }
+            return
+        }
+        flush.Stop()
+        if n := sub.Dropped(); n > dropped {
+            slog.Warn("webhook: fell behind, events dropped", "url", redactURL(wh.URL), "dropped", n-dropped)
+            dropped = n
+        }
+        if len(batch) > 0 {
+            s.post(wh, batch, s.retries)
+            batch = batch[:0]
+        }
+    }
+}
+
+// post sends batch to wh, retrying with exponential backoff up to retries
+// times while the target is unreachable, answers 429 or fails with a 5xx
+func (s *webhookSender) post(wh Webhook, batch []EventMessage, retries int) {
+    body, _ := json.Marshal(batch)
+    backoff := 500 * time.Millisecond
+    for attempt := 0; ; attempt++ {
+        resp, err := s.client.Post(wh.URL, "application/json", bytes.NewReader(body))
+        retry := err != nil
+        if err == nil {
+            resp.Body.Close()
+            switch {
+            case resp.StatusCode < 300:
+                return
+            case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+                retry = true
+                err = fmt.Errorf("status %s", resp.Status)
+            default:
+                slog.Warn("webhook: target rejected events", "url", redactURL(wh.URL), "events", len(batch), "status", resp.Status)
+                return
+            }
+        }
+        if !retry || attempt >= retries {
+            slog.Warn("webhook: delivery failed, events dropped", "url", redactURL(wh.URL), "events", len(batch), "attempts", attempt+1, "err", err)
+            return
+        }
+        select {
+        case <-s.done:
+            retries = attempt + 1 // One last try before shutting down
+        case <-time.After(backoff):
+        }
+        backoff = min(backoff*2, webhookMaxBackoff)
+    }
+}
+
+// Shutdown posts the events already received
+func (s *webhookSender) Shutdown(ctx context.Context) error {
+    s.stopOnce.Do(func() { close(s.done) })
+    select {
+    case <-s.stopped:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Close stops delivering without waiting
+func (s *webhookSender) Close() error {
+    s.stopOnce.Do(func() { close(s.done) })
+    return nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "net"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/gorilla/websocket"
+
+    "lru-cache/lrucache"
+)
+
+// wsWriteTimeout bounds each frame written to a client
+const wsWriteTimeout = 10 * time.Second
+
+// newWSUpgrader accepts upgrades with no Origin, from the server's own
+// origin, or from an origin cors allows. With auth off, any page a browser
+// visits could otherwise drive the cache from another site.
+func newWSUpgrader(cors *CORSPolicy) *websocket.Upgrader {
+    return &websocket.Upgrader{
+        CheckOrigin: func(r *http.Request) bool {
+            origin := r.Header.Get("Origin")
+            if origin == "" {
+                return true
+            }
+            if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+                return true
+            }
+            return cors != nil && cors.allowOrigin(origin) != ""
+        },
+        Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+            code := codeBadRequest
+            if status == http.StatusForbidden {
+                code = codeForbidden
+            }
+            writeError(w, status, code, reason.Error())
+        },
+    }
+}
+
+// WSRequest is a client frame on /cache/ws
+type WSRequest struct {
+    ID         string   `json:"id,omitempty"`
+    Op         string   `json:"op"`
+    Key        string   `json:"key,omitempty"`
+    Value      string   `json:"value,omitempty"`
+    Expiration int      `json:"expiration,omitempty"`
+    Prefix     string   `json:"prefix,omitempty"`
+    Pattern    string   `json:"pattern,omitempty"` // Of the keys a subscription covers, as for POST /cache/purge
+    Types      []string `json:"types,omitempty"`   // The events a subscription covers; every type if empty
+    Token      string   `json:"token,omitempty"`
+}
+
+// WSResponse answers a WSRequest with the same id
+type WSResponse struct {
+    ID    string    `json:"id,omitempty"`
+    OK    bool      `json:"ok"`
+    Found *bool     `json:"found,omitempty"`
+    Value string    `json:"value,omitempty"`
+    Error *APIError `json:"error,omitempty"`
+}
+
+// wsConn is a server-side WebSocket connection
+type wsConn struct {
+    cache         lrucache.Cache
+    maxValueBytes int
+    conn          *websocket.Conn
+    wmutex        sync.Mutex // The connection allows one writer at a time
+    auth          *Authenticator
+    principal     *Principal
+    client        string
+    subs          map[string]*lrucache.Subscription // By prefix, pattern and types
+    closeOnce     sync.Once
+}
+
+// wsConns tracks open WebSocket connections so shutdown can close them
+var wsConns = struct {
+    sync.Mutex
+    m map[*wsConn]struct{}
+}{m: make(map[*wsConn]struct{})}
+
+// closeWebSockets sends a going-away close frame to every open connection
+func closeWebSockets() {
+    wsConns.Lock()
+    defer wsConns.Unlock()
+    for c := range wsConns.m {
+        c.close(websocket.CloseGoingAway, "server shutting down")
+    }
+}
+
+// websocketHandler upgrades GET /cache/ws and serves JSON get/set/delete frames
+func (h *handlers) websocketHandler(auth *Authenticator) http.HandlerFunc {
+    upgrader := newWSUpgrader(h.cors)
+    return func(w http.ResponseWriter, r *http.Request) {
+        if !websocket.IsWebSocketUpgrade(r) || r.Header.Get("Sec-WebSocket-Version") != "13" {
+            w.Header().Set("Sec-WebSocket-Version", "13")
+            writeError(w, http.StatusUpgradeRequired, codeBadRequest, "Expected a WebSocket upgrade request")
+            return
+        }
+        conn, err := upgrader.Upgrade(w, r, nil)
+        if err != nil {
+            return // The upgrader has answered
+        }
+        // A message holds at most a maximum-size value and its JSON framing
+        conn.SetReadLimit(int64(h.maxValueBytes) + 4096)
+
+        c := &wsConn{
+            cache:         h.cache,
+            maxValueBytes: h.maxValueBytes,
+            conn:          conn,
+            auth:          auth,
+            principal:     principalFrom(r.Context()),
+            client:        clientIP(r),
+            subs:          make(map[string]*lrucache.Subscription),
+        }
+        wsConns.Lock()
+        wsConns.m[c] = struct{}{}
+        wsConns.Unlock()
+        go c.serve()
+    }
+}
+
+// serve reads requests until the connection closes; control frames are
+// answered by the connection as they arrive
+func (c *wsConn) serve() {
+    defer func() {
+        for _, sub := range c.subs {
+            events.Unsubscribe(sub)
+        }
+        wsConns.Lock()
+        delete(wsConns.m, c)
+        wsConns.Unlock()
+        c.conn.Close()
+    }()
+
+    for {
+        typ, payload, err := c.conn.ReadMessage()
+        if err != nil {
+            var closed *websocket.CloseError
+            // Past the read limit the connection has already sent 1009
+            if !errors.As(err, &closed) && !errors.Is(err, net.ErrClosed) && !errors.Is(err, websocket.ErrReadLimit) {
+                slog.Warn("websocket: connection error", "remote", c.conn.RemoteAddr().String(), "err", err)
+            }
+            return
+        }
+        if typ != websocket.TextMessage {
+            c.close(websocket.CloseUnsupportedData, "only text frames are supported")
+            return
+        }
+        var req WSRequest
+        if err := json.Unmarshal(payload, &req); err != nil {
+            c.writeJSON(WSResponse{Error: &APIError{Code: codeBadRequest, Message: "Malformed JSON frame"}})
+            continue
+        }
+        c.writeJSON(c.handle(req))
+    }
+}
+
+// handle executes one request frame
+func (c *wsConn) handle(req WSRequest) WSResponse {
+    resp := WSResponse{ID: req.ID}
+    fail := func(code, msg string) WSResponse {
+        resp.Error = &APIError{Code: code, Message: msg}
+        return resp
+    }
+    authorize := func(required Role) bool {
+        switch c.auth.Authorize(c.principal, required) {
+        case nil:
+            return true
+        case errNoCredentials:
+            fail(codeUnauthorized, "Missing or invalid credentials")
+        case errReadOnly:
+            fail(codeReadOnly, readOnlyMessage)
+        case errNoLeader:
+            fail(codeUnavailable, noLeaderMessage)
+        default:
+            fail(codeForbidden, "Insufficient role for this operation")
+        }
+        return false
+    }
+
+    switch req.Op {
+    case "auth":
+        p, err := c.auth.AuthenticateToken(req.Token)
+        if err != nil {
+            return fail(codeUnauthorized, "Missing or invalid credentials")
+        }
+        c.principal = p
+    case "get":
+        if !authorize(c.auth.ReadRole()) {
+            return resp
+        }
+        value, err := c.cache.Get(req.Key)
+        found := err == nil
+        resp.Found = &found
+        resp.Value = value
+        if errors.Is(err, lrucache.ErrExpired) {
+            return fail(codeExpired, "Key has expired")
+        }
+    case "set":
+        if !authorize(RoleWrite) {
+            return resp
+        }
+        if len(req.Value) > c.maxValueBytes {
+            return fail(codePayloadTooLarge, "Value exceeds the maximum size")
+        }
+        if err := c.cache.Set(req.Key, req.Value, time.Duration(req.Expiration)*time.Second); err != nil {
+            e := apiCacheError(err)
+            return fail(e.Code, e.Message)
+        }
+        audit(newCaller("websocket", c.principal, c.client), "set", req.Key)
+    case "delete":
+        if !authorize(RoleWrite) {
+            return resp
+        }
+        found := c.cache.Delete(req.Key)
+        if found {
+            audit(newCaller("websocket", c.principal, c.client), "delete", req.Key)
+        }
+        resp.Found = &found
+    case "subscribe":
+        if !authorize(c.auth.ReadRole()) {
+            return resp
+        }
+        filter, err := eventFilter(req.Prefix, req.Pattern, req.Types)
+        if err != nil {
+            return fail(codeBadRequest, err.Error())
+        }
+        if _, ok := c.subs[req.subscription()]; !ok {
+            sub := events.SubscribeFilter(filter, 256)
+            c.subs[req.subscription()] = sub
+            go c.push(sub)
+        }
+    case "unsubscribe":
+        if sub, ok := c.subs[req.subscription()]; ok {
+            delete(c.subs, req.subscription())
+            events.Unsubscribe(sub)
+        }
+    default:
+        return fail(codeBadRequest, "Unknown op "+req.Op)
+    }
+    resp.OK = true
+    return resp
+}
+
+// subscription identifies the subscription a subscribe or unsubscribe frame names
+func (req *WSRequest) subscription() string {
+    return req.Prefix + "\x00" + req.Pattern + "\x00" + strings.Join(req.Types, ",")
+}
+
+// push forwards subscription events until the subscription is closed
+func (c *wsConn) push(sub *lrucache.Subscription) {
+    for e := range sub.C {
+        if err := c.writeJSON(newEventMessage(e)); err != nil {
+            return
+        }
+    }
+}
+
+func (c *wsConn) writeJSON(v interface{}) error {
+    c.wmutex.Lock()
+    defer c.wmutex.Unlock()
+    c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+    return c.conn.WriteJSON(v)
+}
+
+// close sends a close frame with the given status code and closes the connection
+func (c *wsConn) close(code int, reason string) {
+    c.closeOnce.Do(func() {
+        c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteTimeout))
+        c.conn.Close()
+    })
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+
+    "lru-cache/lrucache"
+)
+
+// wsServer serves /cache/ws from a fresh cache publishing to events,
+// accepting apiKeys if any are given
+func wsServer(t *testing.T, apiKeys ...string) (string, *lrucache.LRUCache) {
+    cache := lrucache.New(lrucache.WithCapacity(100), lrucache.WithOnEvent(events.Publish))
+    t.Cleanup(func() { cache.Close() })
+    h := &handlers{cache: cache, maxValueBytes: 64}
+    auth := NewAuthenticator(apiKeys, nil, nil, false)
+    server := httptest.NewServer(auth.Middleware(h.websocketHandler(auth)))
+    t.Cleanup(server.Close)
+    return "ws" + strings.TrimPrefix(server.URL, "http"), cache
+}
+
+func dialWS(t *testing.T, url string, header http.Header) *websocket.Conn {
+    t.Helper()
+    conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+    if err != nil {
+        t.Fatalf("dial: %v", err)
+    }
+    resp.Body.Close()
+    t.Cleanup(func() { conn.Close() })
+    conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+    return conn
+}
+
+func TestWebSocketRequiresUpgrade(t *testing.T) {
+    url, _ := wsServer(t)
+    resp, err := http.Get("http" + strings.TrimPrefix(url, "ws"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+        t.Fatalf("plain GET = %d, Sec-WebSocket-Version %q; want 426 naming version 13", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Version"))
+    }
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+    cache := lrucache.New(lrucache.WithCapacity(10))
+    t.Cleanup(func() { cache.Close() })
+    h := &handlers{cache: cache, maxValueBytes: 64, cors: &CORSPolicy{Origins: []string{"https://app.example"}}}
+    auth := NewAuthenticator(nil, nil, nil, false)
+    server := httptest.NewServer(auth.Middleware(h.websocketHandler(auth)))
+    t.Cleanup(server.Close)
+    url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+    tests := []struct {
+        origin     string
+        wantStatus int
+    }{
+        {"", http.StatusSwitchingProtocols},
+        {server.URL, http.StatusSwitchingProtocols},
+        {"https://app.example", http.StatusSwitchingProtocols},
+        {"https://evil.example", http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        t.Run(tt.origin, func(t *testing.T) {
+            header := http.Header{}
+            if tt.origin != "" {
+                header.Set("Origin", tt.origin)
+            }
+            conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+            if conn != nil {
+                conn.Close()
+            }
+            if resp == nil {
+                t.Fatalf("dial: %v", err)
+            }
+            resp.Body.Close()
+            if resp.StatusCode != tt.wantStatus {
+                t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+            }
+        })
+    }
+}
+
+func TestWebSocketFrames(t *testing.T) {
+    url, _ := wsServer(t)
+    conn := dialWS(t, url, nil)
+    yes, no := true, false
+    tests := []struct {
+        name string
+        req  WSRequest
+        want WSResponse
+    }{
+        {"get missing", WSRequest{ID: "1", Op: "get", Key: "k"}, WSResponse{ID: "1", OK: true, Found: &no}},
+        {"set", WSRequest{ID: "2", Op: "set", Key: "k", Value: "v"}, WSResponse{ID: "2", OK: true}},
+        {"get", WSRequest{ID: "3", Op: "get", Key: "k"}, WSResponse{ID: "3", OK: true, Found: &yes, Value: "v"}},
+        {"delete", WSRequest{ID: "4", Op: "delete", Key: "k"}, WSResponse{ID: "4", OK: true, Found: &yes}},
+        {"delete missing", WSRequest{ID: "5", Op: "delete", Key: "k"}, WSResponse{ID: "5", OK: true, Found: &no}},
+        {"value too large", WSRequest{ID: "6", Op: "set", Key: "k", Value: strings.Repeat("x", 65)}, WSResponse{ID: "6", Error: &APIError{Code: codePayloadTooLarge}}},
+        {"unknown op", WSRequest{ID: "7", Op: "incr"}, WSResponse{ID: "7", Error: &APIError{Code: codeBadRequest}}},
+        {"unknown event type", WSRequest{ID: "8", Op: "subscribe", Types: []string{"renamed"}}, WSResponse{ID: "8", Error: &APIError{Code: codeBadRequest}}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if err := conn.WriteJSON(tt.req); err != nil {
+                t.Fatal(err)
+            }
+            var got WSResponse
+            if err := conn.ReadJSON(&got); err != nil {
+                t.Fatal(err)
+            }
+            if got.ID != tt.want.ID || got.OK != tt.want.OK || got.Value != tt.want.Value ||
+                (got.Found == nil) != (tt.want.Found == nil) || (got.Found != nil && *got.Found != *tt.want.Found) ||
+                (got.Error == nil) != (tt.want.Error == nil) || (got.Error != nil && got.Error.Code != tt.want.Error.Code) {
+                t.Fatalf("response = %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+
+    // A frame that is not JSON is answered, and the connection stays open
+    conn.WriteMessage(websocket.TextMessage, []byte("{"))
+    var got WSResponse
+    if err := conn.ReadJSON(&got); err != nil || got.Error == nil || got.Error.Code != codeBadRequest {
+        t.Fatalf("malformed frame: %+v, %v", got, err)
+    }
+}
+
+func TestWebSocketAuth(t *testing.T) {
+    url, _ := wsServer(t, "secret")
+    tests := []struct {
+        name   string
+        header http.Header
+        frames []WSRequest
+        want   string // Error code of the last response, empty for success
+    }{
+        {"anonymous read", nil, []WSRequest{{Op: "get", Key: "k"}}, ""},
+        {"anonymous write", nil, []WSRequest{{Op: "set", Key: "k", Value: "v"}}, codeUnauthorized},
+        {"wrong token", nil, []WSRequest{{Op: "auth", Token: "guess"}}, codeUnauthorized},
+        {"auth frame", nil, []WSRequest{{Op: "auth", Token: "secret"}, {Op: "set", Key: "k", Value: "v"}}, ""},
+        {"key header", http.Header{"X-API-Key": {"secret"}}, []WSRequest{{Op: "delete", Key: "k"}}, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            conn := dialWS(t, url, tt.header)
+            var got WSResponse
+            for _, f := range tt.frames {
+                conn.WriteJSON(f)
+                if err := conn.ReadJSON(&got); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            code := ""
+            if got.Error != nil {
+                code = got.Error.Code
+            }
+            if code != tt.want {
+                t.Fatalf("last response = %+v, want error code %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestWebSocketSubscribe(t *testing.T) {
+    url, cache := wsServer(t)
+    conn := dialWS(t, url, nil)
+    conn.WriteJSON(WSRequest{ID: "s", Op: "subscribe", Prefix: "user:", Types: []string{"set"}})
+    var ack WSResponse
+    if err := conn.ReadJSON(&ack); err != nil || !ack.OK {
+        t.Fatalf("subscribe: %+v, %v", ack, err)
+    }
+
+    cache.Set("order:1", "skipped", 0)
+    cache.Set("user:1", "alice", 0)
+    var e EventMessage
+    if err := conn.ReadJSON(&e); err != nil {
+        t.Fatal(err)
+    }
+    if e.Event != "set" || e.Key != "user:1" || e.Value != "alice" {
+        t.Fatalf("event = %+v, want the set of user:1", e)
+    }
+
+    conn.WriteJSON(WSRequest{ID: "u", Op: "unsubscribe", Prefix: "user:", Types: []string{"set"}})
+    if err := conn.ReadJSON(&ack); err != nil || !ack.OK || ack.ID != "u" {
+        t.Fatalf("unsubscribe: %+v, %v", ack, err)
+    }
+}
+
+func TestWebSocketClose(t *testing.T) {
+    url, _ := wsServer(t)
+    tests := []struct {
+        name     string
+        typ      int
+        payload  []byte
+        wantCode int
+    }{
+        {"binary frame", websocket.BinaryMessage, []byte("{}"), websocket.CloseUnsupportedData},
+        {"message over the limit", websocket.TextMessage, []byte(`{"op":"set","value":"` + strings.Repeat("x", 8192) + `"}`), websocket.CloseMessageTooBig},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            conn := dialWS(t, url, nil)
+            conn.WriteMessage(tt.typ, tt.payload)
+            _, _, err := conn.ReadMessage()
+            var closed *websocket.CloseError
+            if !errors.As(err, &closed) || closed.Code != tt.wantCode {
+                t.Fatalf("read after the frame: %v, want close code %d", err, tt.wantCode)
+            }
+        })
+    }
+
+    // Pings are answered while the server waits for requests
+    conn := dialWS(t, url, nil)
+    pong := make(chan struct{}, 1)
+    conn.SetPongHandler(func(string) error { pong <- struct{}{}; return nil })
+    conn.WriteControl(websocket.PingMessage, []byte("hi"), time.Now().Add(time.Second))
+    go conn.ReadMessage()
+    select {
+    case <-pong:
+    case <-time.After(5 * time.Second):
+        t.Fatal("no pong")
+    }
+}
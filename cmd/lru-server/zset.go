@@ -0,0 +1,290 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "lru-cache/lrucache"
+)
+
+// sortedSetRoutes lists the endpoints scoring, ranking and reading sorted
+// set members
+func sortedSetRoutes(h *handlers) []route {
+    return []route{
+        {
+            Method:  http.MethodGet,
+            Path:    "/v1/cache/{key}/scores",
+            Summary: "Get a range of a sorted set's members, lowest score first",
+            Handler: h.getSortedSetHandler,
+            Params: []param{
+                keyParam,
+                {Name: "start", In: "query", Description: "Rank of the first member; negative counts back from the highest score (default 0)"},
+                {Name: "stop", In: "query", Description: "Rank of the last member, inclusive; negative counts back from the highest score (default -1)"},
+                consistencyParam,
+            },
+            Response: []response{
+                {Status: http.StatusOK, Description: "The members with their scores", ContentType: "application/json", Body: SortedSetResponse{}},
+                errorResponse(http.StatusBadRequest, "Invalid start or stop (BAD_REQUEST)"),
+                errorResponse(http.StatusNotFound, "Key not found (KEY_NOT_FOUND) or expired (EXPIRED)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+        {
+            Method:  http.MethodGet,
+            Path:    "/v1/cache/{key}/scores/{member}",
+            Summary: "Get the rank of a sorted set member, counting from zero at the lowest score",
+            Handler: h.getRankHandler,
+            Params:  []param{keyParam, memberParam, consistencyParam},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The member's rank", ContentType: "application/json", Body: RankResponse{}},
+                errorResponse(http.StatusNotFound, "Not a member or key not found (KEY_NOT_FOUND), or key expired (EXPIRED)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+        {
+            Method:  http.MethodPut,
+            Path:    "/v1/cache/{key}/scores/{member}",
+            Summary: "Add a member to a sorted set or set its score, creating the sorted set if needed; the sorted set keeps its expiration",
+            Handler: h.putScoreHandler,
+            Params:  []param{keyParam, memberParam},
+            Body:    ScoreRequest{},
+            Response: []response{
+                {Status: http.StatusCreated, Description: "Member added"},
+                {Status: http.StatusNoContent, Description: "Score updated"},
+                errorResponse(http.StatusBadRequest, "Malformed request body (BAD_REQUEST)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+                errorResponse(http.StatusRequestEntityTooLarge, "Sorted set too large (PAYLOAD_TOO_LARGE)"),
+            },
+        },
+        {
+            Method:  http.MethodPost,
+            Path:    "/v1/cache/{key}/scores/{member}/incr",
+            Summary: "Add to the score of a sorted set member, adding the member if needed",
+            Handler: h.incrScoreHandler,
+            Params:  []param{keyParam, memberParam},
+            Body:    IncrScoreRequest{},
+            Response: []response{
+                {Status: http.StatusOK, Description: "The new score", ContentType: "application/json", Body: ScoreRequest{}},
+                errorResponse(http.StatusBadRequest, "Malformed request body, or the score would not be finite (BAD_REQUEST)"),
+                errorResponse(http.StatusConflict, "The key holds another kind of value (WRONG_TYPE)"),
+            },
+        },
+    }
+}
+
+// SortedSetResponse is the body of GET /v1/cache/{key}/scores
+type SortedSetResponse struct {
+    Members []lrucache.ZMember `json:"members"`
+}
+
+// RankResponse is the body of GET /v1/cache/{key}/scores/{member}
+type RankResponse struct {
+    Rank int `json:"rank"`
+}
+
+// ScoreRequest is the body of PUT /v1/cache/{key}/scores/{member}, and of
+// the response to an increment
+type ScoreRequest struct {
+    Score float64 `json:"score"`
+}
+
+// IncrScoreRequest is the body of POST /v1/cache/{key}/scores/{member}/incr
+type IncrScoreRequest struct {
+    Delta float64 `json:"delta"`
+}
+
+// getSortedSetHandler handles GET /v1/cache/{key}/scores
+func (h *handlers) getSortedSetHandler(w http.ResponseWriter, r *http.Request) {
+    zsets, ok := supported[lrucache.SortedSets](w, h.cache)
+    if !ok {
+        return
+    }
+    start, ok := queryInt(w, r, "start", 0)
+    if !ok {
+        return
+    }
+    stop, ok := queryInt(w, r, "stop", -1)
+    if !ok {
+        return
+    }
+
+    span := startSpan(r.Context(), "cache.zrange")
+    members, err := zsets.ZRange(r.PathValue("key"), start, stop)
+    span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+    span.End()
+    if err != nil {
+        writeLookupError(w, err)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(SortedSetResponse{Members: members})
+}
+
+// getRankHandler handles GET /v1/cache/{key}/scores/{member}
+func (h *handlers) getRankHandler(w http.ResponseWriter, r *http.Request) {
+    zsets, ok := supported[lrucache.SortedSets](w, h.cache)
+    if !ok {
+        return
+    }
+    span := startSpan(r.Context(), "cache.zrank")
+    rank, err := zsets.ZRank(r.PathValue("key"), r.PathValue("member"))
+    span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+    span.End()
+    if err != nil {
+        writeLookupError(w, err)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(RankResponse{Rank: rank})
+}
+
+// putScoreHandler handles PUT /v1/cache/{key}/scores/{member}
+func (h *handlers) putScoreHandler(w http.ResponseWriter, r *http.Request) {
+    zsets, ok := supported[lrucache.SortedSets](w, h.cache)
+    if !ok {
+        return
+    }
+    var req ScoreRequest
+    if !h.decodeJSON(w, r, &req) {
+        return
+    }
+
+    span := startSpan(r.Context(), "cache.zadd")
+    n, err := zsets.ZAdd(r.PathValue("key"), lrucache.ZMember{Member: r.PathValue("member"), Score: req.Score})
+    span.End()
+    if err != nil {
+        writeCacheError(w, err)
+        return
+    }
+    audit(httpCaller("http", r), "zadd", r.PathValue("key"))
+    if n > 0 {
+        w.WriteHeader(http.StatusCreated)
+    } else {
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// incrScoreHandler handles POST /v1/cache/{key}/scores/{member}/incr
+func (h *handlers) incrScoreHandler(w http.ResponseWriter, r *http.Request) {
+    zsets, ok := supported[lrucache.SortedSets](w, h.cache)
+    if !ok {
+        return
+    }
+    var req IncrScoreRequest
+    if !h.decodeJSON(w, r, &req) {
+        return
+    }
+
+    span := startSpan(r.Context(), "cache.zincrby")
+    score, err := zsets.ZIncrBy(r.PathValue("key"), r.PathValue("member"), req.Delta)
+    span.End()
+    if err != nil {
+        writeCacheError(w, err)
+        return
+    }
+    audit(httpCaller("http", r), "zincrby", r.PathValue("key"))
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(ScoreRequest{Score: score})
+}
+
+// sortedSet implements ZADD key score member [score member ...], ZRANGE key
+// start stop [WITHSCORES], ZRANK key member and ZINCRBY key increment member
+func (s *respSession) sortedSet(cmd string, args []string) {
+    switch {
+    case cmd == "ZADD" && (len(args) < 3 || len(args)%2 == 0),
+        cmd == "ZRANGE" && len(args) != 3 && len(args) != 4,
+        cmd == "ZRANK" && len(args) != 2,
+        cmd == "ZINCRBY" && len(args) != 3:
+        s.writeWrongArgs(cmd)
+        return
+    }
+    key := args[0]
+    sortedSets, ok := respSupported[lrucache.SortedSets](s)
+    if !ok {
+        return
+    }
+    switch cmd {
+    case "ZADD":
+        if !s.allowed(RoleWrite) {
+            return
+        }
+        var members []lrucache.ZMember
+        for i := 1; i < len(args); i += 2 {
+            score, err := strconv.ParseFloat(args[i], 64)
+            if err != nil {
+                s.writeError("ERR value is not a valid float")
+                return
+            }
+            members = append(members, lrucache.ZMember{Member: args[i+1], Score: score})
+        }
+        n, err := sortedSets.ZAdd(key, members...)
+        if err != nil {
+            s.writeCacheError(err)
+            return
+        }
+        audit(s.caller(), "zadd", key)
+        s.writeInt(int64(n))
+    case "ZINCRBY":
+        if !s.allowed(RoleWrite) {
+            return
+        }
+        delta, err := strconv.ParseFloat(args[1], 64)
+        if err != nil {
+            s.writeError("ERR value is not a valid float")
+            return
+        }
+        score, err := sortedSets.ZIncrBy(key, args[2], delta)
+        if err != nil {
+            s.writeCacheError(err)
+            return
+        }
+        audit(s.caller(), "zincrby", key)
+        s.writeBulk(strconv.FormatFloat(score, 'g', -1, 64))
+    case "ZRANGE":
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        start, err1 := strconv.Atoi(args[1])
+        stop, err2 := strconv.Atoi(args[2])
+        if err1 != nil || err2 != nil {
+            s.writeError("ERR value is not an integer or out of range")
+            return
+        }
+        withScores := len(args) == 4
+        if withScores && !strings.EqualFold(args[3], "WITHSCORES") {
+            s.writeError("ERR syntax error")
+            return
+        }
+        members, err := sortedSets.ZRange(key, start, stop)
+        if errors.Is(err, lrucache.ErrWrongType) {
+            s.writeCacheError(err)
+            return
+        }
+        values := make([]string, 0, 2*len(members))
+        for _, m := range members {
+            values = append(values, m.Member)
+            if withScores {
+                values = append(values, strconv.FormatFloat(m.Score, 'g', -1, 64))
+            }
+        }
+        s.writeArray(values)
+    case "ZRANK":
+        if !s.allowed(s.auth.ReadRole()) {
+            return
+        }
+        rank, err := sortedSets.ZRank(key, args[1])
+        switch {
+        case err == nil:
+            s.writeInt(int64(rank))
+        case errors.Is(err, lrucache.ErrWrongType):
+            s.writeCacheError(err)
+        default:
+            s.writeNull()
+        }
+    }
+}
@@ -0,0 +1,101 @@
+package lrucache
+
+import (
+    "container/list"
+    "errors"
+    "math/bits"
+    "strconv"
+)
+
+// MaxBitOffset is the highest bit offset SetBit accepts, making for values
+// of up to 512MB
+const MaxBitOffset = 1<<32 - 1
+
+// ErrInvalidBit is returned for a bit other than 0 or 1, or an offset below
+// zero or above MaxBitOffset
+var ErrInvalidBit = errors.New("bit is not 0 or 1, or offset is out of range")
+
+// SetBit sets the bit at offset of the value at key to bit, counting from the
+// most significant bit of the first byte and growing the value with zero
+// bytes as needed; a missing key starts empty and never expires. It returns
+// the bit's previous value, or ErrTooLarge, before growing anything, if the
+// value would pass WithMaxValueBytes.
+func (c *LRUCache) SetBit(key string, offset int64, bit int) (int, error) {
+    if (bit != 0 && bit != 1) || offset < 0 || offset > MaxBitOffset {
+        return 0, ErrInvalidBit
+    }
+    if c.maxValue > 0 && offset/8 >= int64(c.maxValue) {
+        return 0, ErrTooLarge
+    }
+    res := c.mutate(Mutation{Op: "setbit", Key: key, Delta: offset, Value: strconv.Itoa(bit)})
+    return int(res.N), res.Err
+}
+
+// GetBit returns the bit at offset of the value at key; bits past the end of
+// the value, or of a missing key, are zero
+func (c *LRUCache) GetBit(key string, offset int64) (int, error) {
+    if offset < 0 || offset > MaxBitOffset {
+        return 0, ErrInvalidBit
+    }
+    value, err := c.bitmap("getbit", key)
+    if offset/8 >= int64(len(value)) {
+        return 0, err
+    }
+    return int(value[offset/8]>>(7-offset%8)) & 1, err
+}
+
+// BitCount returns the number of bits set in the value at key
+func (c *LRUCache) BitCount(key string) (int, error) {
+    value, err := c.bitmap("bitcount", key)
+    n := 0
+    for i := 0; i < len(value); i++ {
+        n += bits.OnesCount8(value[i])
+    }
+    return n, err
+}
+
+// bitmap returns the live value at key, or "" if there is none
+func (c *LRUCache) bitmap(op, key string) (string, error) {
+    if c.closed() {
+        return "", ErrClosed
+    }
+    defer c.lock(op, key)()
+
+    value, err := c.lookupValue(key, KindString)
+    switch err {
+    case nil:
+        return value, nil
+    case ErrNotFound, ErrExpired:
+        return "", nil
+    }
+    return "", err
+}
+
+// applySetBit makes a setbit change; the caller holds the mutex
+func (c *LRUCache) applySetBit(elem *list.Element, live bool, m Mutation) MutationResult {
+    prev, bits, err := c.current(elem, live, KindString)
+    if err != nil {
+        return MutationResult{Err: err}
+    }
+    var value []byte
+    if prev != nil {
+        value = []byte(bits)
+    }
+    i, mask := m.Delta/8, byte(1)<<(7-m.Delta%8)
+    if i >= int64(len(value)) {
+        value = append(value, make([]byte, i+1-int64(len(value)))...)
+    }
+    var old int64
+    if value[i]&mask != 0 {
+        old = 1
+    }
+    if m.Value == "1" {
+        value[i] |= mask
+    } else {
+        value[i] &^= mask
+    }
+    if err := c.update(m, prev, KindString, string(value), false); err != nil {
+        return MutationResult{Err: err}
+    }
+    return MutationResult{N: old, OK: true}
+}
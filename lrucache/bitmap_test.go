@@ -0,0 +1,108 @@
+package lrucache
+
+import "testing"
+
+func TestSetBit(t *testing.T) {
+    c := New()
+    tests := []struct {
+        name      string
+        offset    int64
+        bit       int
+        wantPrev  int
+        wantValue string
+    }{
+        {"first bit of a missing key", 0, 1, 0, "\x80"},
+        {"grows by a zero byte", 15, 1, 0, "\x80\x01"},
+        {"set again", 15, 1, 1, "\x80\x01"},
+        {"clear", 0, 0, 1, "\x00\x01"},
+        {"grows over several bytes", 39, 1, 0, "\x00\x01\x00\x00\x01"},
+        {"clearing past the end still grows", 47, 0, 0, "\x00\x01\x00\x00\x01\x00"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            prev, err := c.SetBit("bits", tt.offset, tt.bit)
+            if err != nil || prev != tt.wantPrev {
+                t.Fatalf("SetBit(%d, %d) = %d, %v; want %d", tt.offset, tt.bit, prev, err, tt.wantPrev)
+            }
+            if v, _ := c.Get("bits"); v != tt.wantValue {
+                t.Fatalf("value = %q, want %q", v, tt.wantValue)
+            }
+        })
+    }
+}
+
+func TestGetBitAndBitCount(t *testing.T) {
+    c := New()
+    c.Set("bits", "\xf0\x01", 0)
+    c.LPush("list", "a")
+    tests := []struct {
+        key     string
+        offset  int64
+        want    int
+        wantErr error
+    }{
+        {"bits", 0, 1, nil},
+        {"bits", 4, 0, nil},
+        {"bits", 15, 1, nil},
+        {"bits", 16, 0, nil},
+        {"missing", 3, 0, nil},
+        {"bits", -1, 0, ErrInvalidBit},
+        {"bits", MaxBitOffset + 1, 0, ErrInvalidBit},
+        {"list", 0, 0, ErrWrongType},
+    }
+    for _, tt := range tests {
+        if got, err := c.GetBit(tt.key, tt.offset); got != tt.want || err != tt.wantErr {
+            t.Errorf("GetBit(%q, %d) = %d, %v; want %d, %v", tt.key, tt.offset, got, err, tt.want, tt.wantErr)
+        }
+    }
+
+    counts := map[string]int{"bits": 5, "missing": 0}
+    for key, want := range counts {
+        if got, err := c.BitCount(key); got != want || err != nil {
+            t.Errorf("BitCount(%q) = %d, %v; want %d", key, got, err, want)
+        }
+    }
+    if _, err := c.BitCount("list"); err != ErrWrongType {
+        t.Errorf("BitCount of a list = %v, want ErrWrongType", err)
+    }
+}
+
+func TestSetBitRejects(t *testing.T) {
+    c := New(WithMaxValueBytes(2))
+    tests := []struct {
+        name    string
+        offset  int64
+        bit     int
+        wantErr error
+    }{
+        {"bit of 2", 0, 2, ErrInvalidBit},
+        {"negative offset", -1, 1, ErrInvalidBit},
+        {"offset past the maximum", MaxBitOffset + 1, 1, ErrInvalidBit},
+        {"value past -max-value-bytes", 16, 1, ErrTooLarge},
+    }
+    for _, tt := range tests {
+        if _, err := c.SetBit("bits", tt.offset, tt.bit); err != tt.wantErr {
+            t.Errorf("%s: SetBit = %v, want %v", tt.name, err, tt.wantErr)
+        }
+    }
+    if c.Contains("bits") {
+        t.Fatal("a refused SetBit created the key")
+    }
+}
+
+func TestSetBitPastMaxValueKeepsValue(t *testing.T) {
+    c := New(WithMaxValueBytes(1 << 20))
+    c.Set("bits", "a", 0)
+    if _, err := c.SetBit("bits", MaxBitOffset, 1); err != ErrTooLarge {
+        t.Fatalf("SetBit at MaxBitOffset = %v, want ErrTooLarge", err)
+    }
+    if _, err := c.SetBit("bits", 8<<20, 1); err != ErrTooLarge {
+        t.Fatalf("SetBit at the first byte past the limit = %v, want ErrTooLarge", err)
+    }
+    if value, _ := c.Get("bits"); value != "a" {
+        t.Fatalf("value after refused SetBits = %q, want it unchanged", value)
+    }
+    if _, err := c.SetBit("bits", 8<<20-1, 1); err != nil {
+        t.Fatalf("SetBit at the last bit within the limit = %v", err)
+    }
+}
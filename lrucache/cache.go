@@ -0,0 +1,1232 @@
+// Package lrucache is a thread-safe in-memory cache that evicts the least
+// recently used entries, with expirations, CAS, counters and change events.
+// Values are byte strings, so binary values are stored as they are.
+package lrucache
+
+import (
+    "container/list"
+    "context"
+    "crypto/cipher"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "maps"
+    "math"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// CacheItem represents a single cache entry
+type CacheItem struct {
+    key         string
+    value       string // As stored, which compression and encryption may differ from as written
+    size        int    // Length of the value as written
+    compression Compression
+    checksum    uint32 // CRC-32C of the value as stored
+    kind        Kind
+    flags       uint32
+    cas         uint64
+    expiration  time.Time
+    staleAt     time.Time // When the value turns stale, though still served until expiration; zero never
+    modified    time.Time // When the entry was last written
+}
+
+// LRUCache represents a thread-safe LRU cache
+type LRUCache struct {
+    capacity    int
+    cache       map[string]*list.Element
+    list        *list.List
+    mutex       sync.Mutex
+    casSeq      uint64
+    generations map[string]Generation  // Bumped key prefixes
+    leases      map[string]Lease       // Outstanding leases of missing keys
+    leaseSweep  int                    // Number of leases at which expired ones are swept
+    refreshing  map[string]bool        // Keys whose stale value the loader is refreshing
+    loads       map[string]*loadFlight // Loader calls in progress, which misses of the same key wait for
+    onEvent     func(Event)
+    onEvict     func(key, value string)
+    stats       CacheStats
+    heatmap     *Heatmap
+    slow        atomic.Int64 // Slow-operation threshold in nanoseconds; zero disables
+    proposer    Proposer
+    loader      func(ctx context.Context, key string) (Item, bool)
+    defaultTTL  atomic.Int64 // Nanoseconds
+    maxTTL      time.Duration
+    policy      EvictionPolicy
+    maxValue    int
+
+    doorkeeper       *doorkeeper // Admits new keys on their second write; nil admits every write
+    doorkeeperWindow time.Duration
+
+    quotaEntries int
+    quotaBytes   int64
+
+    compression       Compression
+    compressThreshold int
+    aead              cipher.AEAD
+
+    janitorMutex    sync.Mutex
+    janitorInterval time.Duration
+    janitorStop     chan struct{} // Closed to stop the running janitor, if any
+    done            chan struct{} // Closed by Close to stop the janitor
+    closeOnce       sync.Once
+}
+
+// entryOverhead approximates the memory an entry uses besides its key and
+// value: the list element, the CacheItem and the map slot
+const entryOverhead = 184
+
+// CacheStats are counters and gauges describing the cache
+type CacheStats struct {
+    Hits        uint64
+    Misses      uint64
+    Evictions   uint64
+    Expirations uint64
+    Entries     int
+    Capacity    int
+    Bytes       int64 // Estimated memory held by entries
+
+    Compressed        int   // Entries whose value is stored compressed
+    CompressedBytes   int64 // Stored length of the compressed values
+    UncompressedBytes int64 // Length the compressed values were written with
+
+    Corruptions     uint64 // Values that failed their checksum on a read or snapshot load
+    QuotaRejections uint64 // Writes refused for exceeding WithQuota
+    StaleHits       uint64 // Hits served past the entry's soft TTL
+    Refreshes       uint64 // Stale entries the loader refreshed in the background
+    Rejections      uint64 // First writes of new keys the doorkeeper refused
+    Coalesced       uint64 // Misses that shared a loader call already in progress for the key
+}
+
+// SetHeatmap counts every read in h; nil stops counting
+func (c *LRUCache) SetHeatmap(h *Heatmap) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    c.heatmap = h
+}
+
+// Heatmap returns the heatmap reads are counted in, or nil
+func (c *LRUCache) Heatmap() *Heatmap {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    return c.heatmap
+}
+
+// Stats returns a snapshot of the cache counters
+func (c *LRUCache) Stats() CacheStats {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    stats := c.stats
+    stats.Entries = c.list.Len()
+    stats.Capacity = c.capacity
+    return stats
+}
+
+// SetSlowThreshold logs operations whose lock wait plus hold time exceeds d; zero disables
+func (c *LRUCache) SetSlowThreshold(d time.Duration) {
+    c.slow.Store(int64(d))
+}
+
+// lock acquires the mutex and returns the function that releases it; with a
+// slow threshold set, the release logs operations that took too long
+func (c *LRUCache) lock(op, key string) func() {
+    threshold := time.Duration(c.slow.Load())
+    if threshold <= 0 {
+        c.mutex.Lock()
+        return c.mutex.Unlock
+    }
+    start := time.Now()
+    c.mutex.Lock()
+    acquired := time.Now()
+    return func() {
+        c.mutex.Unlock()
+        if total := time.Since(start); total >= threshold {
+            slog.Warn("slow cache operation", "op", op, "key", key, "duration", total, "lock_wait", acquired.Sub(start))
+        }
+    }
+}
+
+// record counts a read of key as a hit or a miss; the caller holds the mutex
+func (c *LRUCache) record(key string, hit bool) {
+    if hit {
+        c.stats.Hits++
+    } else {
+        c.stats.Misses++
+    }
+    if c.heatmap != nil {
+        c.heatmap.Record(key, hit)
+    }
+}
+
+func entrySize(key, value string) int64 {
+    return int64(len(key) + len(value) + entryOverhead)
+}
+
+// Close stops the janitor; reads and writes then fail with ErrClosed
+func (c *LRUCache) Close() {
+    c.closeOnce.Do(func() { close(c.done) })
+}
+
+// closed reports whether Close was called
+func (c *LRUCache) closed() bool {
+    select {
+    case <-c.done:
+        return true
+    default:
+        return false
+    }
+}
+
+// SetDefaultTTL changes the expiration of later writes that pass zero, as
+// WithDefaultTTL sets it; entries already written keep theirs
+func (c *LRUCache) SetDefaultTTL(ttl time.Duration) {
+    c.defaultTTL.Store(int64(ttl))
+}
+
+// DefaultTTL returns the expiration of writes that pass zero
+func (c *LRUCache) DefaultTTL() time.Duration {
+    return time.Duration(c.defaultTTL.Load())
+}
+
+// SetJanitorInterval changes how often expired entries are removed, as
+// WithJanitorInterval sets it; zero stops the janitor
+func (c *LRUCache) SetJanitorInterval(interval time.Duration) {
+    c.janitorMutex.Lock()
+    defer c.janitorMutex.Unlock()
+    if interval == c.janitorInterval {
+        return
+    }
+    if c.janitorStop != nil {
+        close(c.janitorStop)
+        c.janitorStop = nil
+    }
+    c.janitorInterval = interval
+    if interval > 0 && !c.closed() {
+        c.janitorStop = make(chan struct{})
+        go c.janitor(interval, c.janitorStop)
+    }
+}
+
+// janitor removes expired entries every interval until stop is closed or Close
+func (c *LRUCache) janitor(interval time.Duration, stop chan struct{}) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ticker.C:
+            c.removeExpired()
+        case <-stop:
+            return
+        case <-c.done:
+            return
+        }
+    }
+}
+
+// removeExpired removes every expired entry, unless a proposer owns the
+// contents, in which case expired entries stay until overwritten or evicted
+func (c *LRUCache) removeExpired() {
+    defer c.lock("expire", "")()
+
+    if c.proposer != nil {
+        return
+    }
+    now := time.Now()
+    for elem := c.list.Back(); elem != nil; {
+        prev := elem.Prev()
+        if c.gone(elem.Value.(*CacheItem), now) {
+            c.remove(elem, EventExpire)
+        }
+        elem = prev
+    }
+}
+
+// promote marks elem as just used; the caller holds the mutex
+func (c *LRUCache) promote(elem *list.Element) {
+    if c.policy == EvictLRU {
+        c.list.MoveToFront(elem)
+    }
+}
+
+// expiresAt is ExpiresAt with zero standing for the default TTL, capped at
+// the maximum TTL
+func (c *LRUCache) expiresAt(expiration time.Duration) time.Time {
+    if expiration == 0 {
+        expiration = time.Duration(c.defaultTTL.Load())
+    }
+    if c.maxTTL > 0 && (expiration <= 0 || expiration > c.maxTTL) {
+        expiration = c.maxTTL
+    }
+    return ExpiresAt(expiration)
+}
+
+// OnEvent registers fn to be called for every change; it runs with the cache
+// locked, so it must be fast and must not call back into the cache
+func (c *LRUCache) OnEvent(fn func(Event)) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    c.onEvent = fn
+}
+
+// emit reports an event to the registered handler; the caller holds the mutex
+func (c *LRUCache) emit(t EventType, key, value string) {
+    if c.onEvent != nil {
+        c.onEvent(Event{Type: t, Key: key, Value: value, Time: time.Now()})
+    }
+}
+
+// emitSet reports a written entry, whose value as written is value, with its
+// flags and expiration; the caller holds the mutex
+func (c *LRUCache) emitSet(item *CacheItem, value string) {
+    if c.onEvent != nil {
+        c.onEvent(Event{Type: EventSet, Key: item.key, Value: value, Kind: item.kind, Flags: item.flags, ExpiresAt: item.expiration, StaleAt: item.staleAt, Time: item.modified})
+    }
+}
+
+// remove unlinks an entry and reports why; the caller holds the mutex
+func (c *LRUCache) remove(elem *list.Element, reason EventType) {
+    item := elem.Value.(*CacheItem)
+    c.list.Remove(elem)
+    delete(c.cache, item.key)
+    c.unstore(item)
+    switch reason {
+    case EventEvict:
+        c.stats.Evictions++
+        if c.onEvict != nil {
+            if value, err := c.valueOf(item); err == nil {
+                c.onEvict(item.key, value)
+            }
+        }
+    case EventExpire:
+        c.stats.Expirations++
+    }
+    c.emit(reason, item.key, "")
+}
+
+// Errors returned by reads and writes
+var (
+    // ErrNotFound is returned for a key the cache does not hold
+    ErrNotFound = errors.New("key not found")
+    // ErrExpired is returned for a key whose entry has expired
+    ErrExpired = errors.New("key has expired")
+    // ErrTooLarge is returned for a value longer than WithMaxValueBytes allows
+    ErrTooLarge = errors.New("value exceeds the maximum size")
+    // ErrClosed is returned once the cache has been closed
+    ErrClosed = errors.New("cache is closed")
+    // ErrWrongType is returned for an operation on a key holding another kind of value
+    ErrWrongType = errors.New("key holds the wrong kind of value")
+)
+
+// Get retrieves a value from the cache, or fails with ErrNotFound or ErrExpired
+func (c *LRUCache) Get(key string) (string, error) {
+    item, err := c.GetItemCtx(context.Background(), key)
+    return item.Value, err
+}
+
+// GetCtx is like Get but gives up with ctx's error once ctx is done, which
+// matters when a miss falls back to the loader
+func (c *LRUCache) GetCtx(ctx context.Context, key string) (string, error) {
+    item, err := c.GetItemCtx(ctx, key)
+    return item.Value, err
+}
+
+// GetItem is like Get but returns the entry's metadata too
+func (c *LRUCache) GetItem(key string) (Item, error) {
+    return c.GetItemCtx(context.Background(), key)
+}
+
+// GetItemCtx is like GetItem but passes ctx to the loader, which a miss falls
+// back to, and returns ctx's error once ctx is done
+func (c *LRUCache) GetItemCtx(ctx context.Context, key string) (Item, error) {
+    if err := ctx.Err(); err != nil {
+        return Item{}, err
+    }
+    item, err := c.LookupCached(key)
+    if err == ErrNotFound || err == ErrExpired {
+        loaded, found := c.load(ctx, key)
+        if err := ctx.Err(); err != nil {
+            return Item{}, err
+        }
+        if found {
+            return loaded, nil
+        }
+    }
+    if item.Stale {
+        c.refresh(key, item)
+    }
+    return item, err
+}
+
+// LookupCached is like GetItem but never falls back to the loader
+func (c *LRUCache) LookupCached(key string) (Item, error) {
+    if c.closed() {
+        return Item{}, ErrClosed
+    }
+    defer c.lock("get", key)()
+
+    entry, err := c.lookupKind(key, KindString)
+    if err != nil {
+        return Item{}, err
+    }
+    value, err := c.readValue(entry)
+    if err != nil {
+        return Item{}, err
+    }
+    stale := !entry.staleAt.IsZero() && time.Now().After(entry.staleAt)
+    if stale {
+        c.stats.StaleHits++
+    }
+    return Item{Value: value, Flags: entry.flags, CAS: entry.cas, Expiration: entry.expiration, StaleAt: entry.staleAt, Stale: stale, Modified: entry.modified}, nil
+}
+
+// lookup returns the live entry of key, counting the read and, unless a
+// proposer owns the contents, removing an expired entry and promoting a live
+// one; the caller holds the mutex
+func (c *LRUCache) lookup(key string) (*CacheItem, error) {
+    elem, found := c.cache[key]
+    if !found {
+        c.record(key, false)
+        return nil, ErrNotFound
+    }
+    entry := elem.Value.(*CacheItem)
+    if c.gone(entry, time.Now()) {
+        if c.proposer == nil {
+            c.remove(elem, EventExpire)
+        }
+        c.record(key, false)
+        return nil, ErrExpired
+    }
+    if c.proposer == nil {
+        c.promote(elem)
+    }
+    c.record(key, true)
+    return entry, nil
+}
+
+// SetLoader calls load, without holding the lock, whenever Get or GetItem
+// miss; a value it finds is returned as if it had been cached. Concurrent
+// misses of the same key share one call, so a burst of them reaches the
+// backend once. load gets the first caller's context, without its
+// cancellation, or context.Background() for the methods without one. nil
+// stops loading.
+func (c *LRUCache) SetLoader(load func(ctx context.Context, key string) (Item, bool)) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    c.loader = load
+}
+
+// loadFlight is a loader call that concurrent misses of the same key share
+type loadFlight struct {
+    done  chan struct{}
+    item  Item
+    found bool
+}
+
+// load calls the loader for key, or waits for the call already in progress
+// for it. A caller whose ctx is done stops waiting, but the call carries on
+// for the others.
+func (c *LRUCache) load(ctx context.Context, key string) (Item, bool) {
+    c.mutex.Lock()
+    load := c.loader
+    if load == nil {
+        c.mutex.Unlock()
+        return Item{}, false
+    }
+    flight, ok := c.loads[key]
+    if ok {
+        c.stats.Coalesced++
+    } else {
+        if c.loads == nil {
+            c.loads = make(map[string]*loadFlight)
+        }
+        flight = &loadFlight{done: make(chan struct{})}
+        c.loads[key] = flight
+        go func() {
+            flight.item, flight.found = load(context.WithoutCancel(ctx), key)
+            c.mutex.Lock()
+            delete(c.loads, key)
+            c.mutex.Unlock()
+            close(flight.done)
+        }()
+    }
+    c.mutex.Unlock()
+
+    select {
+    case <-flight.done:
+        return flight.item, flight.found
+    case <-ctx.Done():
+        return Item{}, false
+    }
+}
+
+// refresh reloads a stale item in the background, one load per key at a
+// time, and stores what the loader finds with the same soft TTL unless the
+// entry was written meanwhile
+func (c *LRUCache) refresh(key string, item Item) {
+    c.mutex.Lock()
+    load := c.loader
+    if load == nil || c.refreshing[key] {
+        c.mutex.Unlock()
+        return
+    }
+    if c.refreshing == nil {
+        c.refreshing = make(map[string]bool)
+    }
+    c.refreshing[key] = true
+    c.mutex.Unlock()
+
+    go func() {
+        defer func() {
+            c.mutex.Lock()
+            delete(c.refreshing, key)
+            c.mutex.Unlock()
+        }()
+        loaded, found := load(context.Background(), key)
+        if !found {
+            return
+        }
+        m := Mutation{Op: "cas", Key: key, Value: loaded.Value, Flags: loaded.Flags, ExpiresAt: loaded.Expiration, CAS: item.CAS}
+        if !item.Modified.IsZero() && item.StaleAt.After(item.Modified) {
+            m.StaleAt = time.Now().Add(item.StaleAt.Sub(item.Modified))
+        }
+        if c.mutate(m).OK {
+            c.mutex.Lock()
+            c.stats.Refreshes++
+            c.mutex.Unlock()
+        }
+    }()
+}
+
+// Set adds a value to the cache; an expiration of zero uses the default TTL,
+// which never expires unless set with WithDefaultTTL, and a negative one never
+// expires. It fails with ErrTooLarge, ErrClosed or the proposer's error.
+func (c *LRUCache) Set(key string, value string, expiration time.Duration) error {
+    return c.mutate(Mutation{Op: "store", Key: key, Value: value, ExpiresAt: c.expiresAt(expiration)}).Err
+}
+
+// SetSoft is Set with a soft TTL as well: once softTTL passes, reads still
+// return the value but GetItem reports it Stale and, with a loader set, the
+// cache reloads it in the background; once expiration passes it is a miss.
+// A softTTL of zero or more than the expiration has no effect.
+func (c *LRUCache) SetSoft(key string, value string, softTTL, expiration time.Duration) error {
+    m := Mutation{Op: "store", Key: key, Value: value, ExpiresAt: c.expiresAt(expiration)}
+    if softTTL > 0 {
+        m.StaleAt = time.Now().Add(softTTL)
+    }
+    return c.mutate(m).Err
+}
+
+// SetBytes is Set for a binary value; the cache keeps its own copy
+func (c *LRUCache) SetBytes(key string, value []byte, expiration time.Duration) error {
+    return c.Set(key, string(value), expiration)
+}
+
+// GetBytes is Get for a binary value, returning a copy the caller may modify
+func (c *LRUCache) GetBytes(key string) ([]byte, error) {
+    value, err := c.Get(key)
+    if err != nil {
+        return nil, err
+    }
+    return []byte(value), nil
+}
+
+// SetFrom is SetCtx for a value read from r until EOF, returning the number
+// of bytes read; with WithMaxValueBytes it stops reading and fails with
+// ErrTooLarge as soon as the value grows past the limit
+func (c *LRUCache) SetFrom(ctx context.Context, key string, r io.Reader, expiration time.Duration) (int64, error) {
+    if c.maxValue > 0 {
+        r = io.LimitReader(r, int64(c.maxValue)+1)
+    }
+    var value strings.Builder
+    n, err := io.Copy(&value, r)
+    switch {
+    case err != nil:
+        return n, err
+    case c.maxValue > 0 && n > int64(c.maxValue):
+        return n, ErrTooLarge
+    }
+    return n, c.SetCtx(ctx, key, value.String(), expiration)
+}
+
+// GetTo is GetCtx writing the value to w instead of returning it, and
+// returns the number of bytes written
+func (c *LRUCache) GetTo(ctx context.Context, key string, w io.Writer) (int64, error) {
+    value, err := c.GetCtx(ctx, key)
+    if err != nil {
+        return 0, err
+    }
+    n, err := io.WriteString(w, value)
+    return int64(n), err
+}
+
+// SetCtx is like Set but also returns ctx's error if ctx is done before the
+// change is made. Once proposed, a change may still be committed after ctx is
+// done.
+func (c *LRUCache) SetCtx(ctx context.Context, key string, value string, expiration time.Duration) error {
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    return c.mutateCtx(ctx, Mutation{Op: "store", Key: key, Value: value, ExpiresAt: c.expiresAt(expiration)}).Err
+}
+
+// StoreMode selects the condition under which Store writes an entry
+type StoreMode int
+
+const (
+    // StoreAlways writes unconditionally
+    StoreAlways StoreMode = iota
+    // StoreIfAbsent writes only if the key has no live entry
+    StoreIfAbsent
+    // StoreIfPresent writes only if the key has a live entry
+    StoreIfPresent
+)
+
+// Store writes a value with opaque client flags under the given mode,
+// returning the new CAS value. It fails with ErrNotStored when the mode's
+// condition does not hold, and otherwise as Set does.
+func (c *LRUCache) Store(key string, value string, flags uint32, expiration time.Duration, mode StoreMode) (uint64, error) {
+    res := c.mutate(Mutation{Op: "store", Key: key, Value: value, Flags: flags, ExpiresAt: c.expiresAt(expiration), Mode: mode})
+    return res.CAS, res.Err
+}
+
+var (
+    // ErrNotStored is returned by Store when the key is present under
+    // StoreIfAbsent or missing under StoreIfPresent
+    ErrNotStored = errors.New("not stored")
+    // ErrNotAdmitted is returned by writes of a new key that WithDoorkeeper
+    // refused; writing the key again within the window stores it
+    ErrNotAdmitted = errors.New("not admitted: a new key is cached on its second write")
+    // ErrCASNotFound is returned by CAS operations on a missing key; it
+    // matches ErrNotFound under errors.Is
+    ErrCASNotFound = fmt.Errorf("cas: %w", ErrNotFound)
+    // ErrCASMismatch is returned when the entry changed since its CAS value was read
+    ErrCASMismatch = errors.New("cas mismatch")
+)
+
+// CompareAndSwap writes the value only if the entry's CAS value still equals cas,
+// returning the new CAS value
+func (c *LRUCache) CompareAndSwap(key string, value string, flags uint32, expiration time.Duration, cas uint64) (uint64, error) {
+    res := c.mutate(Mutation{Op: "cas", Key: key, Value: value, Flags: flags, ExpiresAt: c.expiresAt(expiration), CAS: cas})
+    return res.CAS, res.Err
+}
+
+// DeleteCAS removes a key only if its CAS value equals cas; zero matches any entry
+func (c *LRUCache) DeleteCAS(key string, cas uint64) error {
+    return c.mutate(Mutation{Op: "delete_cas", Key: key, CAS: cas}).Err
+}
+
+// StoreEntry writes e, keeping its kind and absolute expiration, under the
+// given mode, returning the new CAS value and whether it was written
+func (c *LRUCache) StoreEntry(e Entry, mode StoreMode) (uint64, bool) {
+    res := c.mutate(Mutation{Op: "store", Key: e.Key, Value: e.Value, Kind: e.Kind, Flags: e.Flags, ExpiresAt: e.ExpiresAt, StaleAt: e.StaleAt, Mode: mode})
+    return res.CAS, res.OK
+}
+
+// Add stores a value only if the key is absent, reporting whether it did
+func (c *LRUCache) Add(key string, value string, expiration time.Duration) bool {
+    _, err := c.Store(key, value, 0, expiration, StoreIfAbsent)
+    return err == nil
+}
+
+// Replace stores a value only if the key is present, reporting whether it did
+func (c *LRUCache) Replace(key string, value string, expiration time.Duration) bool {
+    _, err := c.Store(key, value, 0, expiration, StoreIfPresent)
+    return err == nil
+}
+
+// Item is a copy of an entry's value and metadata; CAS changes on every write
+type Item struct {
+    Value      string
+    Flags      uint32
+    CAS        uint64
+    Expiration time.Time
+    StaleAt    time.Time // When the soft TTL SetSoft gave passes; zero never
+    Stale      bool      // Whether StaleAt had passed when the entry was read
+    Modified   time.Time // When the entry was last written; zero for values the loader found
+}
+
+// Touch updates the expiration of a live entry and gives it a new CAS value,
+// reporting whether it exists
+func (c *LRUCache) Touch(key string, expiration time.Duration) bool {
+    return c.mutate(Mutation{Op: "touch", Key: key, ExpiresAt: c.expiresAt(expiration)}).OK
+}
+
+// ExpiresAt converts a relative expiration to an absolute one; zero means never
+func ExpiresAt(expiration time.Duration) time.Time {
+    if expiration <= 0 {
+        return time.Time{}
+    }
+    return time.Now().Add(expiration)
+}
+
+// expired reports whether the item's expiration has passed
+func (item *CacheItem) expired(now time.Time) bool {
+    return !item.expiration.IsZero() && now.After(item.expiration)
+}
+
+// set stores a value of the given kind with an absolute expiration, written
+// at the time at, and returns its new CAS value; the caller holds the mutex
+func (c *LRUCache) set(key string, value string, kind Kind, flags uint32, expiration, at time.Time) uint64 {
+    return c.setStale(key, value, kind, flags, time.Time{}, expiration, at)
+}
+
+// setStale is set for a value that turns stale at staleAt
+func (c *LRUCache) setStale(key string, value string, kind Kind, flags uint32, staleAt, expiration, at time.Time) uint64 {
+    c.casSeq++
+    delete(c.leases, key)
+    if elem, found := c.cache[key]; found {
+        c.promote(elem)
+        c.unstore(elem.Value.(*CacheItem))
+        c.store(elem.Value.(*CacheItem), value)
+        elem.Value.(*CacheItem).kind = kind
+        elem.Value.(*CacheItem).flags = flags
+        elem.Value.(*CacheItem).cas = c.casSeq
+        elem.Value.(*CacheItem).expiration = expiration
+        elem.Value.(*CacheItem).staleAt = staleAt
+        elem.Value.(*CacheItem).modified = at
+        c.emitSet(elem.Value.(*CacheItem), value)
+        return c.casSeq
+    }
+
+    if c.list.Len() >= c.capacity {
+        oldest := c.list.Back()
+        if oldest != nil {
+            c.remove(oldest, EventEvict)
+        }
+    }
+
+    item := &CacheItem{
+        key:        key,
+        kind:       kind,
+        flags:      flags,
+        cas:        c.casSeq,
+        expiration: expiration,
+        staleAt:    staleAt,
+        modified:   at,
+    }
+    c.store(item, value)
+    elem := c.list.PushFront(item)
+    c.cache[key] = elem
+    c.emitSet(item, value)
+    return c.casSeq
+}
+
+// Delete removes a key from the cache, reporting whether it was present
+func (c *LRUCache) Delete(key string) bool {
+    return c.mutate(Mutation{Op: "delete", Key: key}).OK
+}
+
+// Invalidate is Delete on behalf of another node whose copy changed, reported
+// as EventInvalidate so that it is not broadcast back
+func (c *LRUCache) Invalidate(key string) bool {
+    return c.mutate(Mutation{Op: "invalidate", Key: key}).OK
+}
+
+// StoreIfNewer writes e unless the key was written at or after at, as last
+// write wins resolves changes replicated from another cluster; it reports
+// whether it wrote
+func (c *LRUCache) StoreIfNewer(e Entry, at time.Time) bool {
+    return c.mutate(Mutation{Op: "lww_store", Key: e.Key, Value: e.Value, Kind: e.Kind, Flags: e.Flags, ExpiresAt: e.ExpiresAt, StaleAt: e.StaleAt, Time: at}).OK
+}
+
+// DeleteIfNewer removes a key unless it was written at or after at. Deleted
+// keys leave no tombstone, so an older write arriving later recreates them.
+func (c *LRUCache) DeleteIfNewer(key string, at time.Time) bool {
+    return c.mutate(Mutation{Op: "lww_delete", Key: key, Time: at}).OK
+}
+
+// FlushOlder removes the entries last written before at, returning how many
+func (c *LRUCache) FlushOlder(at time.Time) int {
+    return int(c.mutate(Mutation{Op: "lww_flush", Time: at}).N)
+}
+
+// Len returns the number of entries, including expired ones not yet removed
+func (c *LRUCache) Len() int {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    return c.list.Len()
+}
+
+// Capacity returns the maximum number of entries
+func (c *LRUCache) Capacity() int {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    return c.capacity
+}
+
+// Flush removes every entry, returning how many were removed
+func (c *LRUCache) Flush() int {
+    return int(c.mutate(Mutation{Op: "flush"}).N)
+}
+
+// ErrInvalidCapacity is returned by Resize for a capacity that is not positive
+var ErrInvalidCapacity = errors.New("capacity must be positive")
+
+// Resize changes the capacity, returning how many least recently used
+// entries it evicted if it shrank
+func (c *LRUCache) Resize(capacity int) (int, error) {
+    if capacity <= 0 {
+        return 0, ErrInvalidCapacity
+    }
+    res := c.mutate(Mutation{Op: "resize", Capacity: capacity})
+    return int(res.N), res.Err
+}
+
+// Entry is an exported copy of a cache item; a zero ExpiresAt never expires
+// and a zero StaleAt never turns stale
+type Entry struct {
+    Key       string    `json:"key"`
+    Value     string    `json:"value"`
+    Kind      Kind      `json:"kind,omitempty"`
+    Flags     uint32    `json:"flags,omitempty"`
+    ExpiresAt time.Time `json:"expires_at"`
+    StaleAt   time.Time `json:"stale_at,omitzero"`
+}
+
+// Export returns all live entries from most to least recently used
+func (c *LRUCache) Export() []Entry {
+    defer c.lock("export", "")()
+
+    now := time.Now()
+    entries := make([]Entry, 0, c.list.Len())
+    for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+        item := elem.Value.(*CacheItem)
+        if c.gone(item, now) {
+            continue
+        }
+        value, err := c.valueOf(item)
+        if err != nil {
+            continue
+        }
+        entries = append(entries, Entry{Key: item.key, Value: value, Kind: item.kind, Flags: item.flags, ExpiresAt: item.expiration, StaleAt: item.staleAt})
+    }
+    return entries
+}
+
+// DebugEntry describes an entry's place in the LRU order
+type DebugEntry struct {
+    Key       string `json:"key"`
+    Value     string `json:"value"`
+    Kind      Kind   `json:"kind,omitempty"`
+    Truncated bool   `json:"truncated,omitempty"`
+    Bytes     int64  `json:"bytes"` // Memory used, with the value as stored
+
+    Compression Compression `json:"compression,omitempty"`
+    TTL         int         `json:"ttl"` // Seconds left; -1 never expires, 0 has expired but not been removed
+    ExpiresAt   time.Time   `json:"expires_at"`
+}
+
+// DebugEntries returns up to limit entries from most to least recently used,
+// including expired ones not yet removed, with values cut to maxValue bytes;
+// a non-positive limit or maxValue means no limit
+func (c *LRUCache) DebugEntries(limit, maxValue int) []DebugEntry {
+    return c.DebugEntriesMatching("", limit, maxValue)
+}
+
+// DebugEntriesMatching is DebugEntries listing only the keys matching
+// pattern, written as for Purge; an empty pattern matches every key
+func (c *LRUCache) DebugEntriesMatching(pattern string, limit, maxValue int) []DebugEntry {
+    defer c.lock("debug", "")()
+
+    now := time.Now()
+    n := c.list.Len()
+    if limit > 0 {
+        n = min(n, limit)
+    }
+    prefix := globPrefix(pattern)
+    entries := make([]DebugEntry, 0, min(n, 1024))
+    for elem := c.list.Front(); elem != nil && len(entries) < n; elem = elem.Next() {
+        item := elem.Value.(*CacheItem)
+        if pattern != "" && (!strings.HasPrefix(item.key, prefix) || !MatchGlob(pattern, item.key)) {
+            continue
+        }
+        value, err := c.valueOf(item)
+        if err != nil {
+            continue
+        }
+        e := DebugEntry{Key: item.key, Value: value, Kind: item.kind, Bytes: entrySize(item.key, item.value), TTL: -1, ExpiresAt: item.expiration, Compression: item.compression}
+        if maxValue > 0 && len(e.Value) > maxValue {
+            e.Value, e.Truncated = strings.ToValidUTF8(e.Value[:maxValue], ""), true
+        }
+        if !item.expiration.IsZero() {
+            e.TTL = max(0, int((item.expiration.Sub(now)+time.Second-1)/time.Second))
+        }
+        entries = append(entries, e)
+    }
+    return entries
+}
+
+// Import stores entries as returned by Export, preserving their recency order
+// and skipping any that have expired since
+func (c *LRUCache) Import(entries []Entry) int {
+    return int(c.mutate(Mutation{Op: "import", Entries: entries}).N)
+}
+
+// Contains reports whether a live entry exists without updating its recency
+func (c *LRUCache) Contains(key string) bool {
+    defer c.lock("contains", key)()
+
+    elem, found := c.cache[key]
+    return found && !c.gone(elem.Value.(*CacheItem), time.Now())
+}
+
+// TTL returns the remaining lifetime of a key; zero means it never expires
+func (c *LRUCache) TTL(key string) (time.Duration, bool) {
+    defer c.lock("ttl", key)()
+
+    elem, found := c.cache[key]
+    if !found {
+        return 0, false
+    }
+    item := elem.Value.(*CacheItem)
+    now := time.Now()
+    if c.gone(item, now) {
+        if c.proposer == nil {
+            c.remove(elem, EventExpire)
+        }
+        return 0, false
+    }
+    if item.expiration.IsZero() {
+        return 0, true
+    }
+    return item.expiration.Sub(now), true
+}
+
+var (
+    // errNotInteger is returned by Incr when the stored value is not a base-10 integer
+    errNotInteger = errors.New("value is not an integer or out of range")
+    // errOverflow is returned by Incr when the result does not fit in an int64
+    errOverflow = errors.New("increment or decrement would overflow")
+)
+
+// Incr atomically adds delta to an integer value, keeping its expiration;
+// a missing key starts from zero and never expires
+func (c *LRUCache) Incr(key string, delta int64) (int64, error) {
+    res := c.mutate(Mutation{Op: "incr", Key: key, Delta: delta})
+    return res.N, res.Err
+}
+
+// Mutation describes one change to the cache, with absolute expirations and
+// the time it was made, so that applying it has the same effect everywhere
+type Mutation struct {
+    Op        string             `json:"op"`            // store, cas, delete, delete_cas, invalidate, touch, incr, flush, resize, import, lww_store, lww_delete, lww_flush, hset, hdel, lpush, rpush, lpop, sadd, srem, zadd, zincrby, setbit, pfadd, pfmerge, generation, purge, txn, lease or lease_store
+    Key       string             `json:"key,omitempty"` // The prefix, for generation
+    Value     string             `json:"value,omitempty"`
+    Kind      Kind               `json:"kind,omitempty"`
+    Flags     uint32             `json:"flags,omitempty"`
+    ExpiresAt time.Time          `json:"expires_at,omitzero"`
+    StaleAt   time.Time          `json:"stale_at,omitzero"` // When the value a store, cas, lww_store or import writes turns stale
+    Mode      StoreMode          `json:"mode,omitempty"`
+    CAS       uint64             `json:"cas,omitempty"`   // Or the lease token of a lease_store
+    Delta     int64              `json:"delta,omitempty"` // Added by incr, or the offset of the bit setbit sets to Value
+    Capacity  int                `json:"capacity,omitempty"`
+    Entries   []Entry            `json:"entries,omitempty"`
+    Fields    map[string]string  `json:"fields,omitempty"` // Fields to set, by hset
+    Args      []string           `json:"args,omitempty"`   // Fields removed by hdel, elements pushed by lpush and rpush or added by pfadd, members added by sadd or removed by srem, the keys pfmerge merges or the patterns purge deletes
+    Scores    map[string]float64 `json:"scores,omitempty"` // Scores to set by zadd, or to add by zincrby, per member
+    Ops       []Mutation         `json:"ops,omitempty"`    // The operations of a txn: set, delete, incr, get or check
+    Equals    *string            `json:"equals,omitempty"` // The value an operation of a txn requires the key to hold
+    Time      time.Time          `json:"time"`
+}
+
+// MutationResult is what applying a Mutation returned
+type MutationResult struct {
+    CAS     uint64
+    OK      bool
+    N       int64            // Entries affected, the new value of an incr, the length of a list or the previous bit of a setbit
+    Value   string           // The element an lpop removed, or a get in a txn read
+    Score   float64          // The new score of a zincrby
+    Results []MutationResult // Of each operation of a txn
+    Err     error
+}
+
+// Proposer commits mutations, for example through a Raft log, and applies
+// them to the cache with Apply once they are committed; Propose stops waiting
+// for the commit once ctx is done
+type Proposer interface {
+    Propose(ctx context.Context, m Mutation) (MutationResult, error)
+}
+
+// SetProposer routes every change through p instead of applying it directly;
+// call it before serving. Reads then leave recency and expired entries alone,
+// so caches applying the same mutations hold the same entries.
+func (c *LRUCache) SetProposer(p Proposer) {
+    c.proposer = p
+}
+
+// mutate applies m directly or through the proposer; a change that was not
+// committed is logged and, where the method returns one, reported as an error.
+// m.Time defaults to now.
+func (c *LRUCache) mutate(m Mutation) MutationResult {
+    return c.mutateCtx(context.Background(), m)
+}
+
+// mutateCtx is like mutate but bounds waiting for the proposer with ctx
+func (c *LRUCache) mutateCtx(ctx context.Context, m Mutation) MutationResult {
+    if c.closed() {
+        return MutationResult{Err: ErrClosed}
+    }
+    if c.maxValue > 0 && len(m.Value) > c.maxValue {
+        return MutationResult{Err: ErrTooLarge}
+    }
+    if m.Time.IsZero() {
+        m.Time = time.Now()
+    }
+    if c.proposer == nil {
+        return c.Apply(m)
+    }
+    res, err := c.proposer.Propose(ctx, m)
+    if err != nil {
+        slog.Warn("cache change not committed", "op", m.Op, "key", m.Key, "err", err)
+        return MutationResult{Err: err}
+    }
+    return res
+}
+
+// Apply makes the change m describes
+func (c *LRUCache) Apply(m Mutation) MutationResult {
+    defer c.lock(m.Op, m.Key)()
+
+    elem, found := c.cache[m.Key]
+    live := found && !c.gone(elem.Value.(*CacheItem), m.Time)
+    switch m.Op {
+    case "store":
+        if (m.Mode == StoreIfAbsent && live) || (m.Mode == StoreIfPresent && !live) {
+            return MutationResult{Err: ErrNotStored}
+        }
+        if err := c.checkQuota(m.Key, m.Value); err != nil {
+            return MutationResult{Err: err}
+        }
+        if !live && c.doorkeeper != nil && !c.doorkeeper.admit(m.Key, m.Time) {
+            c.stats.Rejections++
+            return MutationResult{Err: ErrNotAdmitted}
+        }
+        return MutationResult{CAS: c.setStale(m.Key, m.Value, m.Kind, m.Flags, m.StaleAt, m.ExpiresAt, m.Time), OK: true}
+    case "cas":
+        if !live {
+            return MutationResult{Err: ErrCASNotFound}
+        }
+        if elem.Value.(*CacheItem).cas != m.CAS {
+            return MutationResult{Err: ErrCASMismatch}
+        }
+        if err := c.checkQuota(m.Key, m.Value); err != nil {
+            return MutationResult{Err: err}
+        }
+        return MutationResult{CAS: c.setStale(m.Key, m.Value, KindString, m.Flags, m.StaleAt, m.ExpiresAt, m.Time), OK: true}
+    case "delete":
+        // Revokes a lease even on a miss, since the value it was to fill changed
+        delete(c.leases, m.Key)
+        if !found {
+            return MutationResult{}
+        }
+        c.remove(elem, EventDelete)
+        return MutationResult{OK: true}
+    case "invalidate":
+        if !found {
+            return MutationResult{}
+        }
+        c.remove(elem, EventInvalidate)
+        return MutationResult{OK: true}
+    case "delete_cas":
+        if !live {
+            return MutationResult{Err: ErrCASNotFound}
+        }
+        if m.CAS != 0 && elem.Value.(*CacheItem).cas != m.CAS {
+            return MutationResult{Err: ErrCASMismatch}
+        }
+        c.remove(elem, EventDelete)
+        return MutationResult{OK: true}
+    case "touch":
+        if !live {
+            return MutationResult{}
+        }
+        // A new CAS value, so that transactions watching the key see the change
+        c.casSeq++
+        elem.Value.(*CacheItem).cas = c.casSeq
+        elem.Value.(*CacheItem).expiration = m.ExpiresAt
+        elem.Value.(*CacheItem).modified = m.Time
+        c.promote(elem)
+        // Report the new expiration as a set of the unchanged value so that
+        // subscribers such as replicas learn about it
+        if value, err := c.valueOf(elem.Value.(*CacheItem)); err == nil {
+            c.emitSet(elem.Value.(*CacheItem), value)
+        }
+        return MutationResult{OK: true}
+    case "incr":
+        var n int64
+        var flags uint32
+        expiration := time.Time{}
+        if live {
+            item := elem.Value.(*CacheItem)
+            if item.kind != KindString {
+                return MutationResult{Err: ErrWrongType}
+            }
+            value, err := c.valueOf(item)
+            if err != nil {
+                return MutationResult{Err: err}
+            }
+            parsed, err := strconv.ParseInt(value, 10, 64)
+            if err != nil {
+                return MutationResult{Err: errNotInteger}
+            }
+            n, flags, expiration = parsed, item.flags, item.expiration
+        }
+        if (m.Delta > 0 && n > math.MaxInt64-m.Delta) || (m.Delta < 0 && n < math.MinInt64-m.Delta) {
+            return MutationResult{Err: errOverflow}
+        }
+        n += m.Delta
+        c.set(m.Key, strconv.FormatInt(n, 10), KindString, flags, expiration, m.Time)
+        return MutationResult{N: n, OK: true}
+    case "flush":
+        n := c.list.Len()
+        c.leases = nil
+        c.cache = make(map[string]*list.Element)
+        c.list.Init()
+        c.clearStored()
+        c.emit(EventFlush, "", "")
+        return MutationResult{N: int64(n), OK: true}
+    case "resize":
+        // Resize checks this, but a log written by another version may not have
+        if m.Capacity <= 0 {
+            return MutationResult{Err: ErrInvalidCapacity}
+        }
+        c.capacity = m.Capacity
+        if c.doorkeeper != nil {
+            c.doorkeeper.resize(c.capacity)
+        }
+        evicted := 0
+        for c.list.Len() > c.capacity {
+            c.remove(c.list.Back(), EventEvict)
+            evicted++
+        }
+        return MutationResult{N: int64(evicted), OK: true}
+    case "import":
+        imported := 0
+        for i := len(m.Entries) - 1; i >= 0; i-- {
+            e := m.Entries[i]
+            if !e.ExpiresAt.IsZero() && m.Time.After(e.ExpiresAt) {
+                continue
+            }
+            c.setStale(e.Key, e.Value, e.Kind, e.Flags, e.StaleAt, e.ExpiresAt, m.Time)
+            imported++
+        }
+        return MutationResult{N: int64(imported), OK: true}
+    case "lww_store":
+        if found && !elem.Value.(*CacheItem).modified.Before(m.Time) {
+            return MutationResult{}
+        }
+        return MutationResult{CAS: c.setStale(m.Key, m.Value, m.Kind, m.Flags, m.StaleAt, m.ExpiresAt, m.Time), OK: true}
+    case "lww_delete":
+        if !found || !elem.Value.(*CacheItem).modified.Before(m.Time) {
+            return MutationResult{}
+        }
+        c.remove(elem, EventDelete)
+        return MutationResult{OK: true}
+    case "lww_flush":
+        n := 0
+        for elem := c.list.Front(); elem != nil; {
+            next := elem.Next()
+            if elem.Value.(*CacheItem).modified.Before(m.Time) {
+                c.remove(elem, EventDelete)
+                n++
+            }
+            elem = next
+        }
+        return MutationResult{N: int64(n), OK: true}
+    case "hset", "hdel":
+        return c.applyHash(elem, live, m)
+    case "lpush", "rpush", "lpop":
+        return c.applyList(elem, live, m)
+    case "sadd", "srem":
+        return c.applySet(elem, live, m)
+    case "zadd", "zincrby":
+        return c.applySortedSet(elem, live, m)
+    case "setbit":
+        return c.applySetBit(elem, live, m)
+    case "generation":
+        return c.applyGeneration(m)
+    case "purge":
+        return c.applyPurge(m)
+    case "txn":
+        return c.applyTxn(m)
+    case "lease", "lease_store":
+        return c.applyLease(live, m)
+    case "pfadd", "pfmerge":
+        return c.applyHLL(elem, live, m)
+    }
+    return MutationResult{Err: fmt.Errorf("unknown cache mutation %q", m.Op)}
+}
+
+// CacheState is a complete copy of the cache, including expired entries and
+// CAS values, as kept in Raft snapshots
+type CacheState struct {
+    Capacity    int                   `json:"capacity"`
+    CASSeq      uint64                `json:"cas_seq"`
+    Generations map[string]Generation `json:"generations,omitempty"`
+    Leases      map[string]Lease      `json:"leases,omitempty"`
+    Entries     []StateEntry          `json:"entries"` // Most to least recently used
+}
+
+// StateEntry is an entry of a CacheState
+type StateEntry struct {
+    Entry
+    CAS      uint64 `json:"cas"`
+    Checksum uint32 `json:"crc32c,omitempty"` // Of the value; Restore drops an entry that does not match
+}
+
+// State returns a complete copy of the cache
+func (c *LRUCache) State() CacheState {
+    defer c.lock("state", "")()
+
+    s := CacheState{Capacity: c.capacity, CASSeq: c.casSeq, Generations: maps.Clone(c.generations), Leases: maps.Clone(c.leases), Entries: make([]StateEntry, 0, c.list.Len())}
+    for elem := c.list.Front(); elem != nil; elem = elem.Next() {
+        item := elem.Value.(*CacheItem)
+        if c.outdated(item) {
+            continue
+        }
+        value, err := c.valueOf(item)
+        if err != nil {
+            continue
+        }
+        s.Entries = append(s.Entries, StateEntry{Entry{item.key, value, item.kind, item.flags, item.expiration, item.staleAt}, item.cas, checksum(value)})
+    }
+    return s
+}
+
+// Restore replaces the cache contents with s, reporting the change to
+// subscribers as a flush followed by a set of every entry; entries that fail
+// their checksum are dropped
+func (c *LRUCache) Restore(s CacheState) {
+    defer c.lock("restore", "")()
+
+    c.capacity, c.casSeq, c.generations, c.leases = s.Capacity, s.CASSeq, maps.Clone(s.Generations), maps.Clone(s.Leases)
+    if c.doorkeeper != nil {
+        c.doorkeeper.resize(c.capacity)
+    }
+    c.cache = make(map[string]*list.Element, len(s.Entries))
+    c.list.Init()
+    c.clearStored()
+    c.emit(EventFlush, "", "")
+    for _, e := range s.Entries {
+        if e.Checksum != 0 && checksum(e.Value) != e.Checksum {
+            c.stats.Corruptions++
+            slog.Warn("dropping corrupt entry from snapshot", "key", e.Key)
+            continue
+        }
+        item := &CacheItem{key: e.Key, kind: e.Kind, flags: e.Flags, cas: e.CAS, expiration: e.ExpiresAt, staleAt: e.StaleAt}
+        c.store(item, e.Value)
+        c.cache[e.Key] = c.list.PushBack(item)
+        c.emitSet(item, e.Value)
+    }
+}
@@ -0,0 +1,470 @@
+package lrucache
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestGetSetDelete(t *testing.T) {
+    c := New(WithCapacity(10))
+    tests := []struct {
+        name    string
+        do      func() error
+        key     string
+        want    string
+        wantErr error
+    }{
+        {"miss", nil, "k", "", ErrNotFound},
+        {"set", func() error { return c.Set("k", "v", 0) }, "k", "v", nil},
+        {"overwrite", func() error { return c.Set("k", "w", 0) }, "k", "w", nil},
+        {"binary value", func() error { return c.SetBytes("bin", []byte{0, 0xff, 0}, 0) }, "bin", "\x00\xff\x00", nil},
+        {"delete", func() error { c.Delete("k"); return nil }, "k", "", ErrNotFound},
+        {"expired", func() error { return c.Set("short", "v", time.Nanosecond) }, "short", "", ErrExpired},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if tt.do != nil {
+                if err := tt.do(); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            time.Sleep(time.Millisecond)
+            got, err := c.Get(tt.key)
+            if got != tt.want || err != tt.wantErr {
+                t.Fatalf("Get(%q) = %q, %v; want %q, %v", tt.key, got, err, tt.want, tt.wantErr)
+            }
+        })
+    }
+    if c.Delete("missing") {
+        t.Fatal("Delete of a missing key reported it present")
+    }
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+    var evicted []string
+    c := New(WithCapacity(3), WithOnEvict(func(key, _ string) { evicted = append(evicted, key) }))
+    for _, key := range []string{"a", "b", "c"} {
+        c.Set(key, key, 0)
+    }
+    c.Get("a") // b is now the least recently used
+    c.Set("d", "d", 0)
+    c.Contains("b") // Contains leaves recency alone
+    c.Set("e", "e", 0)
+
+    for key, want := range map[string]bool{"a": true, "b": false, "c": false, "d": true, "e": true} {
+        if got := c.Contains(key); got != want {
+            t.Errorf("Contains(%q) = %v, want %v", key, got, want)
+        }
+    }
+    if strings.Join(evicted, ",") != "b,c" {
+        t.Errorf("evicted %v, want b then c", evicted)
+    }
+    if s := c.Stats(); s.Evictions != 2 || s.Entries != 3 || s.Capacity != 3 {
+        t.Errorf("stats = %+v, want 2 evictions of 3 entries at capacity 3", s)
+    }
+}
+
+func TestStoreModes(t *testing.T) {
+    c := New()
+    tests := []struct {
+        name      string
+        mode      StoreMode
+        value     string
+        wantOK    bool
+        wantValue string
+    }{
+        {"present only, missing", StoreIfPresent, "1", false, ""},
+        {"absent only, missing", StoreIfAbsent, "2", true, "2"},
+        {"absent only, present", StoreIfAbsent, "3", false, "2"},
+        {"present only, present", StoreIfPresent, "4", true, "4"},
+        {"always", StoreAlways, "5", true, "5"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            cas, err := c.Store("k", tt.value, 7, 0, tt.mode)
+            if ok := err == nil; ok != tt.wantOK || (ok && cas == 0) || (!ok && err != ErrNotStored) {
+                t.Fatalf("Store = %d, %v; want stored %v with a CAS value", cas, err, tt.wantOK)
+            }
+            if item, _ := c.GetItem("k"); item.Value != tt.wantValue {
+                t.Fatalf("value = %q, want %q", item.Value, tt.wantValue)
+            }
+        })
+    }
+    if c.Add("k", "x", 0) || !c.Replace("k", "x", 0) || c.Replace("missing", "x", 0) || !c.Add("new", "x", 0) {
+        t.Fatal("Add and Replace disagree with StoreIfAbsent and StoreIfPresent")
+    }
+}
+
+func TestCompareAndSwap(t *testing.T) {
+    c := New()
+    c.Set("k", "v", 0)
+    item, _ := c.GetItem("k")
+    tests := []struct {
+        name    string
+        key     string
+        cas     uint64
+        wantErr error
+    }{
+        {"missing key", "missing", 1, ErrCASNotFound},
+        {"stale CAS", "k", item.CAS + 100, ErrCASMismatch},
+        {"current CAS", "k", item.CAS, nil},
+        {"CAS the swap replaced", "k", item.CAS, ErrCASMismatch},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            cas, err := c.CompareAndSwap(tt.key, "swapped", 0, 0, tt.cas)
+            if err != tt.wantErr || (err == nil && cas <= tt.cas) {
+                t.Fatalf("CompareAndSwap = %d, %v; want a newer CAS value or %v", cas, err, tt.wantErr)
+            }
+        })
+    }
+    if !errors.Is(ErrCASNotFound, ErrNotFound) {
+        t.Fatal("ErrCASNotFound does not match ErrNotFound")
+    }
+
+    item, _ = c.GetItem("k")
+    if err := c.DeleteCAS("k", item.CAS+1); err != ErrCASMismatch {
+        t.Fatalf("DeleteCAS with another CAS = %v, want ErrCASMismatch", err)
+    }
+    if err := c.DeleteCAS("k", 0); err != nil || c.Contains("k") {
+        t.Fatalf("DeleteCAS with zero = %v, want the key deleted", err)
+    }
+}
+
+func TestIncr(t *testing.T) {
+    c := New()
+    c.Set("text", "abc", 0)
+    c.Set("max", "9223372036854775807", 0)
+    c.HSet("hash", "f", "1")
+    tests := []struct {
+        key     string
+        delta   int64
+        want    int64
+        wantErr error
+    }{
+        {"n", 5, 5, nil},
+        {"n", -7, -2, nil},
+        {"text", 1, 0, errNotInteger},
+        {"max", 1, 0, errOverflow},
+        {"max", -1, 9223372036854775806, nil},
+        {"hash", 1, 0, ErrWrongType},
+    }
+    for _, tt := range tests {
+        got, err := c.Incr(tt.key, tt.delta)
+        if got != tt.want || err != tt.wantErr {
+            t.Errorf("Incr(%q, %d) = %d, %v; want %d, %v", tt.key, tt.delta, got, err, tt.want, tt.wantErr)
+        }
+    }
+
+    // Incrementing keeps the expiration
+    c.Set("ttl", "1", time.Hour)
+    c.Incr("ttl", 1)
+    if ttl, _ := c.TTL("ttl"); ttl <= 59*time.Minute {
+        t.Fatalf("TTL after Incr = %v, want about an hour", ttl)
+    }
+}
+
+func TestTouchAndTTL(t *testing.T) {
+    c := New()
+    c.Set("k", "v", time.Minute)
+    tests := []struct {
+        name       string
+        key        string
+        expiration time.Duration
+        wantOK     bool
+        min, max   time.Duration
+    }{
+        {"extend", "k", time.Hour, true, 59 * time.Minute, time.Hour},
+        {"never expire", "k", -1, true, 0, 0},
+        {"missing", "missing", time.Hour, false, 0, 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if ok := c.Touch(tt.key, tt.expiration); ok != tt.wantOK {
+                t.Fatalf("Touch = %v, want %v", ok, tt.wantOK)
+            }
+            ttl, ok := c.TTL(tt.key)
+            if ok != tt.wantOK || ttl < tt.min || ttl > tt.max {
+                t.Fatalf("TTL = %v, %v; want between %v and %v", ttl, ok, tt.min, tt.max)
+            }
+        })
+    }
+}
+
+func TestResize(t *testing.T) {
+    c := New(WithCapacity(4))
+    for _, key := range []string{"a", "b", "c", "d"} {
+        c.Set(key, key, 0)
+    }
+    tests := []struct {
+        capacity    int
+        wantEvicted int
+        wantErr     error
+        wantLen     int
+    }{
+        {0, 0, ErrInvalidCapacity, 4},
+        {8, 0, nil, 4},
+        {2, 2, nil, 2},
+    }
+    for _, tt := range tests {
+        evicted, err := c.Resize(tt.capacity)
+        if evicted != tt.wantEvicted || err != tt.wantErr || c.Len() != tt.wantLen {
+            t.Errorf("Resize(%d) = %d, %v with %d entries; want %d, %v with %d", tt.capacity, evicted, err, c.Len(), tt.wantEvicted, tt.wantErr, tt.wantLen)
+        }
+    }
+    if !c.Contains("d") || !c.Contains("c") || c.Contains("a") {
+        t.Fatal("shrinking did not evict the least recently used entries")
+    }
+}
+
+func TestExportImport(t *testing.T) {
+    src := New()
+    src.Set("old", "1", 0)
+    src.Set("new", "2", time.Hour)
+    src.HSet("hash", "f", "v")
+    src.Set("gone", "3", time.Nanosecond)
+    time.Sleep(time.Millisecond)
+
+    entries := src.Export()
+    keys := make([]string, len(entries))
+    for i, e := range entries {
+        keys[i] = e.Key
+    }
+    if strings.Join(keys, ",") != "hash,new,old" {
+        t.Fatalf("exported %v, want live entries most recently used first", keys)
+    }
+
+    entries = append(entries, Entry{Key: "expired", Value: "x", ExpiresAt: time.Now().Add(-time.Second)})
+    dst := New()
+    if n := dst.Import(entries); n != 3 {
+        t.Fatalf("imported %d entries, want 3", n)
+    }
+    for i, e := range dst.Export() {
+        if e.Key != entries[i].Key || e.Value != entries[i].Value || e.Kind != entries[i].Kind || !e.ExpiresAt.Equal(entries[i].ExpiresAt) {
+            t.Errorf("entry %d = %+v, want %+v", i, e, entries[i])
+        }
+    }
+}
+
+func TestLastWriteWins(t *testing.T) {
+    c := New()
+    base := time.Now().Add(time.Hour)
+    tests := []struct {
+        name   string
+        do     func() bool
+        want   bool
+        stored string
+    }{
+        {"newer store", func() bool { return c.StoreIfNewer(Entry{Key: "k", Value: "a"}, base) }, true, "a"},
+        {"older store", func() bool { return c.StoreIfNewer(Entry{Key: "k", Value: "b"}, base.Add(-time.Second)) }, false, "a"},
+        {"same time", func() bool { return c.StoreIfNewer(Entry{Key: "k", Value: "c"}, base) }, false, "a"},
+        {"older delete", func() bool { return c.DeleteIfNewer("k", base.Add(-time.Second)) }, false, "a"},
+        {"newer delete", func() bool { return c.DeleteIfNewer("k", base.Add(time.Second)) }, true, ""},
+        {"store after delete", func() bool { return c.StoreIfNewer(Entry{Key: "k", Value: "d"}, base.Add(-time.Minute)) }, true, "d"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := tt.do(); got != tt.want {
+                t.Fatalf("got %v, want %v", got, tt.want)
+            }
+            if v, _ := c.Get("k"); v != tt.stored {
+                t.Fatalf("value = %q, want %q", v, tt.stored)
+            }
+        })
+    }
+
+    // k was last written at base minus a minute, later than the cut
+    c.Set("fresh", "v", 0)
+    if n := c.FlushOlder(time.Now().Add(time.Minute)); n != 1 || c.Contains("fresh") || !c.Contains("k") {
+        t.Fatalf("FlushOlder removed %d entries, want only the one written before the cut", n)
+    }
+}
+
+func TestStreaming(t *testing.T) {
+    c := New(WithMaxValueBytes(8))
+    ctx := context.Background()
+    tests := []struct {
+        name    string
+        value   string
+        wantErr error
+    }{
+        {"fits", "12345678", nil},
+        {"too large", "123456789", ErrTooLarge},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if _, err := c.SetFrom(ctx, tt.name, strings.NewReader(tt.value), 0); err != tt.wantErr {
+                t.Fatalf("SetFrom = %v, want %v", err, tt.wantErr)
+            }
+        })
+    }
+    var out strings.Builder
+    if n, err := c.GetTo(ctx, "fits", &out); n != 8 || err != nil || out.String() != "12345678" {
+        t.Fatalf("GetTo = %d, %v, %q", n, err, out.String())
+    }
+    if _, err := c.GetTo(ctx, "too large", &out); err != ErrNotFound {
+        t.Fatalf("GetTo of the refused value = %v, want ErrNotFound", err)
+    }
+}
+
+func TestContext(t *testing.T) {
+    c := New()
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if err := c.SetCtx(ctx, "k", "v", 0); err != context.Canceled {
+        t.Fatalf("SetCtx with a canceled context = %v", err)
+    }
+    if c.Contains("k") {
+        t.Fatal("SetCtx with a canceled context stored the value")
+    }
+    c.Set("k", "v", 0)
+    if _, err := c.GetCtx(ctx, "k"); err != context.Canceled {
+        t.Fatalf("GetCtx with a canceled context = %v", err)
+    }
+}
+
+func TestClose(t *testing.T) {
+    c := New()
+    c.Set("k", "v", 0)
+    c.Close()
+    c.Close()
+    if _, err := c.Get("k"); err != ErrClosed {
+        t.Fatalf("Get after Close = %v, want ErrClosed", err)
+    }
+    if err := c.Set("k", "v", 0); err != ErrClosed {
+        t.Fatalf("Set after Close = %v, want ErrClosed", err)
+    }
+}
+
+func TestLoaderCoalescesMisses(t *testing.T) {
+    c := New()
+    var mutex sync.Mutex
+    loads := 0
+    release := make(chan struct{})
+    c.SetLoader(func(_ context.Context, key string) (Item, bool) {
+        mutex.Lock()
+        loads++
+        mutex.Unlock()
+        <-release
+        return Item{Value: "loaded " + key}, key != "absent"
+    })
+
+    var wg sync.WaitGroup
+    values := make([]string, 3)
+    for i := range values {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            values[i], _ = c.Get("k")
+        }()
+    }
+    for c.Stats().Coalesced < 2 {
+        time.Sleep(time.Millisecond)
+    }
+    close(release)
+    wg.Wait()
+    if loads != 1 || values[0] != "loaded k" || values[2] != "loaded k" {
+        t.Fatalf("%d loads returned %q, want one shared load", loads, values)
+    }
+    if _, err := c.Get("absent"); err != ErrNotFound {
+        t.Fatalf("Get of a key the loader lacks = %v, want ErrNotFound", err)
+    }
+}
+
+func TestSoftTTL(t *testing.T) {
+    c := New()
+    c.SetLoader(func(_ context.Context, key string) (Item, bool) {
+        return Item{Value: "loaded " + key}, true
+    })
+
+    // A stale hit is served and refreshed in the background
+    c.SetSoft("soft", "old", time.Nanosecond, time.Hour)
+    time.Sleep(time.Millisecond)
+    item, err := c.GetItem("soft")
+    if err != nil || item.Value != "old" || !item.Stale {
+        t.Fatalf("stale read = %+v, %v; want the old value reported stale", item, err)
+    }
+    for c.Stats().Refreshes == 0 {
+        time.Sleep(time.Millisecond)
+    }
+    if v, _ := c.Get("soft"); v != "loaded soft" {
+        t.Fatalf("refreshed value = %q", v)
+    }
+    if item, _ := c.GetItem("soft"); item.Stale {
+        t.Fatal("a refreshed value is still reported stale")
+    }
+}
+
+func TestDebugEntriesMatching(t *testing.T) {
+    c := New()
+    c.Set("user:1", "alice", 0)
+    c.Set("user:2", strings.Repeat("é", 4), time.Hour)
+    c.Set("order:1", "x", 0)
+    tests := []struct {
+        pattern       string
+        limit, max    int
+        wantKeys      string
+        wantTruncated bool
+    }{
+        {"", 0, 0, "order:1,user:2,user:1", false},
+        {"user:*", 0, 0, "user:2,user:1", false},
+        {"user:*", 1, 3, "user:2", true},
+        {"nobody*", 0, 0, "", false},
+    }
+    for _, tt := range tests {
+        entries := c.DebugEntriesMatching(tt.pattern, tt.limit, tt.max)
+        keys := make([]string, len(entries))
+        truncated := false
+        for i, e := range entries {
+            keys[i] = e.Key
+            truncated = truncated || e.Truncated
+        }
+        if strings.Join(keys, ",") != tt.wantKeys || truncated != tt.wantTruncated {
+            t.Errorf("DebugEntriesMatching(%q, %d, %d) = %v, truncated %v", tt.pattern, tt.limit, tt.max, keys, truncated)
+        }
+    }
+    if e := c.DebugEntriesMatching("user:2", 0, 3)[0]; e.Value != "é" || e.TTL != 3600 {
+        t.Fatalf("truncated entry = %+v, want a whole rune and a TTL of an hour", e)
+    }
+}
+
+// recordingProposer applies what it is given, keeping every mutation
+type recordingProposer struct {
+    c     *LRUCache
+    mutex sync.Mutex
+    ops   []string
+}
+
+func (p *recordingProposer) Propose(_ context.Context, m Mutation) (MutationResult, error) {
+    p.mutex.Lock()
+    p.ops = append(p.ops, m.Op)
+    p.mutex.Unlock()
+    return p.c.Apply(m), nil
+}
+
+func TestProposerAndState(t *testing.T) {
+    c := New()
+    p := &recordingProposer{c: c}
+    c.SetProposer(p)
+    c.Set("k", "v", 0)
+    c.Incr("n", 1)
+    c.Delete("k")
+    c.Set("a", "1", 0)
+    if strings.Join(p.ops, ",") != "store,incr,delete,store" {
+        t.Fatalf("proposed %v", p.ops)
+    }
+    if res := c.Apply(Mutation{Op: "rename"}); res.Err == nil {
+        t.Fatal("an unknown mutation applied")
+    }
+
+    state := c.State()
+    replica := New()
+    replica.Restore(state)
+    if got, want := replica.Export(), c.Export(); len(got) != len(want) || got[0] != want[0] || replica.State().CASSeq != state.CASSeq {
+        t.Fatalf("restored %+v, want %+v", got, want)
+    }
+}
@@ -0,0 +1,1449 @@
+// Package cachetest provides a fake lrucache.Cache for testing code that
+// uses a cache: it runs on a manual clock, fails calls on request and records
+// every call it gets.
+package cachetest
+
+import (
+    "cmp"
+    "container/list"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "math/bits"
+    "slices"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+// Clock is a manual clock; it only moves when told to
+type Clock struct {
+    mutex sync.Mutex
+    now   time.Time
+}
+
+// NewClock returns a clock stopped at now
+func NewClock(now time.Time) *Clock {
+    return &Clock{now: now}
+}
+
+// Now returns the clock's time
+func (c *Clock) Now() time.Time {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    c.now = c.now.Add(d)
+}
+
+// Set moves the clock to t
+func (c *Clock) Set(t time.Time) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    c.now = t
+}
+
+// Call is one method call the fake received
+type Call struct {
+    Method     string // Such as "Get" or "SetCtx"
+    Key        string
+    Field      string   // Of hash calls; HDel joins its fields with spaces
+    Values     []string // Of list, set and HyperLogLog calls; the keys of PFCount, sources of PFMerge, patterns of Purge and DebugEntriesMatching and keys of Txn and Watch
+    Score      float64  // Of ZIncrBy calls
+    Offset     int64    // Of bit calls
+    Delta      int64    // Of Incr calls
+    CAS        uint64   // Of CompareAndSwap and DeleteCAS calls
+    Value      string
+    Expiration time.Duration
+}
+
+// entry is a stored value
+type entry struct {
+    key        string
+    value      string
+    kind       lrucache.Kind
+    flags      uint32
+    cas        uint64
+    expiration time.Time
+    modified   time.Time
+    staleAt    time.Time
+}
+
+// Fake is an in-memory lrucache.Cache evicting the least recently used entry
+// when full, like lrucache.LRUCache; it is safe for concurrent use
+type Fake struct {
+    Clock *Clock
+
+    mutex    sync.Mutex
+    capacity int
+    entries  map[string]*list.Element
+    order    *list.List // Most recently used first
+    casSeq   uint64
+    gens     map[string]uint64
+    leases   map[string]lrucache.Lease
+    stats    lrucache.CacheStats
+    calls    []Call
+    failures map[string][]error
+}
+
+var (
+    _ lrucache.Cache         = (*Fake)(nil)
+    _ lrucache.ContextCache  = (*Fake)(nil)
+    _ lrucache.Refresher     = (*Fake)(nil)
+    _ lrucache.Hashes        = (*Fake)(nil)
+    _ lrucache.Lists         = (*Fake)(nil)
+    _ lrucache.Sets          = (*Fake)(nil)
+    _ lrucache.SortedSets    = (*Fake)(nil)
+    _ lrucache.Bitmaps       = (*Fake)(nil)
+    _ lrucache.HyperLogLogs  = (*Fake)(nil)
+    _ lrucache.Transactional = (*Fake)(nil)
+    _ lrucache.Replicated    = (*Fake)(nil)
+    _ lrucache.Administered  = (*Fake)(nil)
+)
+
+// New returns an empty fake holding up to capacity entries, its clock
+// stopped at 2000-01-01 UTC
+func New(capacity int) *Fake {
+    return &Fake{
+        Clock:    NewClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)),
+        capacity: capacity,
+        entries:  make(map[string]*list.Element),
+        order:    list.New(),
+        gens:     make(map[string]uint64),
+        leases:   make(map[string]lrucache.Lease),
+        failures: make(map[string][]error),
+    }
+}
+
+// FailNext makes the next calls of method, one per error, fail with errs
+// instead of running; only the methods that return an error can fail
+func (f *Fake) FailNext(method string, errs ...error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.failures[method] = append(f.failures[method], errs...)
+}
+
+// Calls returns the calls received so far, oldest first
+func (f *Fake) Calls() []Call {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    return append([]Call(nil), f.calls...)
+}
+
+// CallsTo returns the calls of method received so far, oldest first
+func (f *Fake) CallsTo(method string) []Call {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    var calls []Call
+    for _, c := range f.calls {
+        if c.Method == method {
+            calls = append(calls, c)
+        }
+    }
+    return calls
+}
+
+// ResetCalls forgets the calls received so far
+func (f *Fake) ResetCalls() {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.calls = nil
+}
+
+// record notes a call and returns its scripted failure, if any; the caller
+// holds the mutex
+func (f *Fake) record(c Call) error {
+    f.calls = append(f.calls, c)
+    errs := f.failures[c.Method]
+    if len(errs) == 0 {
+        return nil
+    }
+    f.failures[c.Method] = errs[1:]
+    return errs[0]
+}
+
+// live returns key's unexpired entry, promoting it unless peek is set; the
+// caller holds the mutex
+func (f *Fake) live(key string, peek bool) (*entry, error) {
+    elem, ok := f.entries[key]
+    if !ok {
+        return nil, lrucache.ErrNotFound
+    }
+    e := elem.Value.(*entry)
+    if !e.expiration.IsZero() && !f.Clock.Now().Before(e.expiration) {
+        f.order.Remove(elem)
+        delete(f.entries, key)
+        f.stats.Expirations++
+        return nil, lrucache.ErrExpired
+    }
+    if !peek {
+        f.order.MoveToFront(elem)
+    }
+    return e, nil
+}
+
+// get records a read called method and runs it
+func (f *Fake) get(ctx context.Context, method, key string) (lrucache.Item, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: method, Key: key}); err != nil {
+        return lrucache.Item{}, err
+    }
+    if err := ctx.Err(); err != nil {
+        return lrucache.Item{}, err
+    }
+    e, err := f.read(key, lrucache.KindString)
+    if err != nil {
+        return lrucache.Item{}, err
+    }
+    stale := !e.staleAt.IsZero() && !f.Clock.Now().Before(e.staleAt)
+    if stale {
+        f.stats.StaleHits++
+    }
+    return lrucache.Item{Value: e.value, Flags: e.flags, CAS: e.cas, Expiration: e.expiration, StaleAt: e.staleAt, Stale: stale, Modified: e.modified}, nil
+}
+
+// read returns key's live entry, which must hold a value of kind k,
+// counting a hit or a miss; the caller holds the mutex
+func (f *Fake) read(key string, k lrucache.Kind) (*entry, error) {
+    e, err := f.live(key, false)
+    if err != nil {
+        f.stats.Misses++
+        return nil, err
+    }
+    f.stats.Hits++
+    if e.kind != k {
+        return nil, lrucache.ErrWrongType
+    }
+    return e, nil
+}
+
+// set stores an entry; the caller holds the mutex
+func (f *Fake) set(key, value string, kind lrucache.Kind, flags uint32, expiration, modified time.Time) {
+    f.casSeq++
+    delete(f.leases, key)
+    if elem, ok := f.entries[key]; ok {
+        *elem.Value.(*entry) = entry{key, value, kind, flags, f.casSeq, expiration, modified, time.Time{}}
+        f.order.MoveToFront(elem)
+        return
+    }
+    if f.order.Len() >= f.capacity {
+        if oldest := f.order.Back(); oldest != nil {
+            f.order.Remove(oldest)
+            delete(f.entries, oldest.Value.(*entry).key)
+            f.stats.Evictions++
+        }
+    }
+    f.entries[key] = f.order.PushFront(&entry{key, value, kind, flags, f.casSeq, expiration, modified, time.Time{}})
+}
+
+// setStale is set for a value that turns stale at staleAt
+func (f *Fake) setStale(key, value string, kind lrucache.Kind, flags uint32, staleAt, expiration, modified time.Time) {
+    f.set(key, value, kind, flags, expiration, modified)
+    f.entries[key].Value.(*entry).staleAt = staleAt
+}
+
+// expiresAt converts a relative expiration on the fake's clock
+func (f *Fake) expiresAt(expiration time.Duration) time.Time {
+    if expiration <= 0 {
+        return time.Time{}
+    }
+    return f.Clock.Now().Add(expiration)
+}
+
+// Get implements lrucache.Cache
+func (f *Fake) Get(key string) (string, error) {
+    item, err := f.get(context.Background(), "Get", key)
+    return item.Value, err
+}
+
+// GetCtx implements lrucache.ContextCache
+func (f *Fake) GetCtx(ctx context.Context, key string) (string, error) {
+    item, err := f.get(ctx, "GetCtx", key)
+    return item.Value, err
+}
+
+// GetItem implements lrucache.Cache
+func (f *Fake) GetItem(key string) (lrucache.Item, error) {
+    return f.get(context.Background(), "GetItem", key)
+}
+
+// GetItemCtx implements lrucache.ContextCache
+func (f *Fake) GetItemCtx(ctx context.Context, key string) (lrucache.Item, error) {
+    return f.get(ctx, "GetItemCtx", key)
+}
+
+// Contains implements lrucache.Cache
+func (f *Fake) Contains(key string) bool {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "Contains", Key: key})
+    _, err := f.live(key, true)
+    return err == nil
+}
+
+// Set implements lrucache.Cache
+func (f *Fake) Set(key string, value string, expiration time.Duration) error {
+    return f.store(context.Background(), "Set", key, value, expiration)
+}
+
+// SetCtx implements lrucache.ContextCache
+func (f *Fake) SetCtx(ctx context.Context, key string, value string, expiration time.Duration) error {
+    return f.store(ctx, "SetCtx", key, value, expiration)
+}
+
+// store records a write called method and runs it
+func (f *Fake) store(ctx context.Context, method, key, value string, expiration time.Duration) error {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: method, Key: key, Value: value, Expiration: expiration}); err != nil {
+        return err
+    }
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    f.set(key, value, lrucache.KindString, 0, f.expiresAt(expiration), f.Clock.Now())
+    return nil
+}
+
+// Store implements lrucache.Cache
+func (f *Fake) Store(key string, value string, flags uint32, expiration time.Duration, mode lrucache.StoreMode) (uint64, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "Store", Key: key, Value: value, Expiration: expiration}); err != nil {
+        return 0, err
+    }
+    _, err := f.live(key, true)
+    if (mode == lrucache.StoreIfAbsent && err == nil) || (mode == lrucache.StoreIfPresent && err != nil) {
+        return 0, lrucache.ErrNotStored
+    }
+    f.set(key, value, lrucache.KindString, flags, f.expiresAt(expiration), f.Clock.Now())
+    return f.casSeq, nil
+}
+
+// CompareAndSwap implements lrucache.Cache
+func (f *Fake) CompareAndSwap(key string, value string, flags uint32, expiration time.Duration, cas uint64) (uint64, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "CompareAndSwap", Key: key, Value: value, Expiration: expiration, CAS: cas}); err != nil {
+        return 0, err
+    }
+    e, err := f.live(key, true)
+    if err != nil {
+        return 0, lrucache.ErrCASNotFound
+    }
+    if e.cas != cas {
+        return 0, lrucache.ErrCASMismatch
+    }
+    f.set(key, value, lrucache.KindString, flags, f.expiresAt(expiration), f.Clock.Now())
+    return f.casSeq, nil
+}
+
+// Incr implements lrucache.Cache
+func (f *Fake) Incr(key string, delta int64) (int64, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "Incr", Key: key, Delta: delta}); err != nil {
+        return 0, err
+    }
+    var n int64
+    var prev entry
+    if e, err := f.live(key, true); err == nil {
+        if e.kind != lrucache.KindString {
+            return 0, lrucache.ErrWrongType
+        }
+        parsed, err := strconv.ParseInt(e.value, 10, 64)
+        if err != nil {
+            return 0, err
+        }
+        n, prev = parsed, *e
+    }
+    if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+        return 0, errors.New("increment or decrement would overflow")
+    }
+    n += delta
+    f.set(key, strconv.FormatInt(n, 10), lrucache.KindString, prev.flags, prev.expiration, f.Clock.Now())
+    return n, nil
+}
+
+// SetSoft implements lrucache.Refresher
+func (f *Fake) SetSoft(key string, value string, softTTL, expiration time.Duration) error {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "SetSoft", Key: key, Value: value, Expiration: expiration}); err != nil {
+        return err
+    }
+    f.setStale(key, value, lrucache.KindString, 0, f.expiresAt(softTTL), f.expiresAt(expiration), f.Clock.Now())
+    return nil
+}
+
+// GetLease implements lrucache.Refresher
+func (f *Fake) GetLease(key string, ttl time.Duration) (string, uint64, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "GetLease", Key: key, Expiration: ttl}); err != nil {
+        return "", 0, err
+    }
+    e, err := f.read(key, lrucache.KindString)
+    if err == nil {
+        return e.value, 0, nil
+    }
+    if !errors.Is(err, lrucache.ErrNotFound) && !errors.Is(err, lrucache.ErrExpired) {
+        return "", 0, err
+    }
+    now := f.Clock.Now()
+    if l, ok := f.leases[key]; ok && now.Before(l.ExpiresAt) {
+        return "", 0, lrucache.ErrLeaseHeld
+    }
+    f.casSeq++
+    f.leases[key] = lrucache.Lease{Token: f.casSeq, ExpiresAt: now.Add(ttl)}
+    return "", f.casSeq, lrucache.ErrNotFound
+}
+
+// SetLease implements lrucache.Refresher
+func (f *Fake) SetLease(key, value string, expiration time.Duration, token uint64) error {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "SetLease", Key: key, Value: value, Expiration: expiration}); err != nil {
+        return err
+    }
+    now := f.Clock.Now()
+    if l, ok := f.leases[key]; !ok || l.Token != token || !now.Before(l.ExpiresAt) {
+        return lrucache.ErrLeaseInvalid
+    }
+    f.set(key, value, lrucache.KindString, 0, f.expiresAt(expiration), now)
+    return nil
+}
+
+// SetFrom implements lrucache.ContextCache
+func (f *Fake) SetFrom(ctx context.Context, key string, r io.Reader, expiration time.Duration) (int64, error) {
+    var value strings.Builder
+    n, err := io.Copy(&value, r)
+    if err != nil {
+        return n, err
+    }
+    return n, f.store(ctx, "SetFrom", key, value.String(), expiration)
+}
+
+// GetTo implements lrucache.ContextCache
+func (f *Fake) GetTo(ctx context.Context, key string, w io.Writer) (int64, error) {
+    item, err := f.get(ctx, "GetTo", key)
+    if err != nil {
+        return 0, err
+    }
+    n, err := io.WriteString(w, item.Value)
+    return int64(n), err
+}
+
+// Touch implements lrucache.Cache
+func (f *Fake) Touch(key string, expiration time.Duration) bool {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "Touch", Key: key, Expiration: expiration})
+    e, err := f.live(key, false)
+    if err != nil {
+        return false
+    }
+    f.casSeq++
+    e.cas, e.expiration, e.modified = f.casSeq, f.expiresAt(expiration), f.Clock.Now()
+    return true
+}
+
+// TTL implements lrucache.Cache
+func (f *Fake) TTL(key string) (time.Duration, bool) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "TTL", Key: key})
+    e, err := f.live(key, true)
+    if err != nil {
+        return 0, false
+    }
+    if e.expiration.IsZero() {
+        return 0, true
+    }
+    return e.expiration.Sub(f.Clock.Now()), true
+}
+
+// Delete implements lrucache.Cache
+func (f *Fake) Delete(key string) bool {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "Delete", Key: key})
+    delete(f.leases, key)
+    if _, err := f.live(key, true); err != nil {
+        return false
+    }
+    f.order.Remove(f.entries[key])
+    delete(f.entries, key)
+    return true
+}
+
+// DeleteCAS implements lrucache.Cache
+func (f *Fake) DeleteCAS(key string, cas uint64) error {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "DeleteCAS", Key: key, CAS: cas}); err != nil {
+        return err
+    }
+    e, err := f.live(key, true)
+    if err != nil {
+        return lrucache.ErrCASNotFound
+    }
+    if cas != 0 && e.cas != cas {
+        return lrucache.ErrCASMismatch
+    }
+    delete(f.leases, key)
+    f.order.Remove(f.entries[key])
+    delete(f.entries, key)
+    return nil
+}
+
+// StoreIfNewer implements lrucache.Replicated
+func (f *Fake) StoreIfNewer(e lrucache.Entry, at time.Time) bool {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "StoreIfNewer", Key: e.Key, Value: e.Value})
+    if elem, ok := f.entries[e.Key]; ok && !elem.Value.(*entry).modified.Before(at) {
+        return false
+    }
+    f.setStale(e.Key, e.Value, e.Kind, e.Flags, e.StaleAt, e.ExpiresAt, at)
+    return true
+}
+
+// DeleteIfNewer implements lrucache.Replicated
+func (f *Fake) DeleteIfNewer(key string, at time.Time) bool {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "DeleteIfNewer", Key: key})
+    elem, ok := f.entries[key]
+    if !ok || !elem.Value.(*entry).modified.Before(at) {
+        return false
+    }
+    f.order.Remove(elem)
+    delete(f.entries, key)
+    return true
+}
+
+// Flush implements lrucache.Cache
+func (f *Fake) Flush() int {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "Flush"})
+    n := f.order.Len()
+    f.entries = make(map[string]*list.Element)
+    f.order.Init()
+    clear(f.leases)
+    return n
+}
+
+// FlushOlder implements lrucache.Replicated
+func (f *Fake) FlushOlder(at time.Time) int {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "FlushOlder"})
+    n := 0
+    for elem := f.order.Front(); elem != nil; {
+        next := elem.Next()
+        if e := elem.Value.(*entry); e.modified.Before(at) {
+            f.order.Remove(elem)
+            delete(f.entries, e.key)
+            n++
+        }
+        elem = next
+    }
+    return n
+}
+
+// Purge implements lrucache.Transactional
+func (f *Fake) Purge(patterns ...string) (int, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "Purge", Values: patterns}); err != nil {
+        return 0, err
+    }
+    n := 0
+    for key, elem := range f.entries {
+        if slices.ContainsFunc(patterns, func(p string) bool { return lrucache.MatchGlob(p, key) }) {
+            f.order.Remove(elem)
+            delete(f.entries, key)
+            n++
+        }
+    }
+    return n, nil
+}
+
+// Txn implements lrucache.Transactional
+func (f *Fake) Txn(ops ...lrucache.TxnOp) ([]lrucache.TxnResult, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    keys := make([]string, len(ops))
+    for i, op := range ops {
+        keys[i] = op.Key
+    }
+    if err := f.record(Call{Method: "Txn", Values: keys}); err != nil {
+        return nil, err
+    }
+
+    // Check every operation against the entries the earlier ones leave, then apply
+    pending := make(map[string]*entry)
+    results := make([]lrucache.TxnResult, len(ops))
+    for i, op := range ops {
+        e, ok := pending[op.Key]
+        if !ok {
+            e, _ = f.live(op.Key, true)
+        }
+        fail := func(err error) ([]lrucache.TxnResult, error) {
+            return nil, &lrucache.TxnError{Index: i, Err: err}
+        }
+        switch {
+        case (op.Mode == lrucache.StoreIfAbsent && e != nil) || (op.Mode == lrucache.StoreIfPresent && e == nil):
+            return fail(lrucache.ErrConditionFailed)
+        case op.CAS != 0 && e == nil:
+            return fail(lrucache.ErrCASNotFound)
+        case op.CAS != 0 && e.cas != op.CAS:
+            return fail(lrucache.ErrCASMismatch)
+        case op.Equals != nil && (e == nil || e.kind != lrucache.KindString || e.value != *op.Equals):
+            return fail(lrucache.ErrConditionFailed)
+        }
+        results[i].Found = e != nil
+        switch op.Op {
+        case "set":
+            pending[op.Key] = &entry{key: op.Key, value: op.Value, expiration: f.expiresAt(op.Expiration)}
+        case "incr":
+            n := int64(0)
+            if e != nil {
+                if e.kind != lrucache.KindString {
+                    return fail(lrucache.ErrWrongType)
+                }
+                parsed, err := strconv.ParseInt(e.value, 10, 64)
+                if err != nil {
+                    return fail(err)
+                }
+                n = parsed
+            }
+            results[i].N = n + op.Delta
+            pending[op.Key] = &entry{key: op.Key, value: strconv.FormatInt(results[i].N, 10)}
+        case "delete":
+            pending[op.Key] = nil
+        case "get":
+            if e != nil && e.kind != lrucache.KindString {
+                return fail(lrucache.ErrWrongType)
+            }
+            if e != nil {
+                results[i].Value, results[i].CAS = e.value, e.cas
+            }
+        case "check":
+        default:
+            return fail(fmt.Errorf("unknown transaction operation %q", op.Op))
+        }
+    }
+    now := f.Clock.Now()
+    for i, op := range ops {
+        switch op.Op {
+        case "set":
+            f.set(op.Key, op.Value, lrucache.KindString, 0, f.expiresAt(op.Expiration), now)
+            results[i].CAS = f.casSeq
+        case "incr":
+            var next entry
+            if e, err := f.live(op.Key, true); err == nil {
+                next = *e
+            }
+            f.set(op.Key, strconv.FormatInt(results[i].N, 10), lrucache.KindString, next.flags, next.expiration, now)
+            results[i].CAS = f.casSeq
+        case "delete":
+            if elem, ok := f.entries[op.Key]; ok {
+                f.order.Remove(elem)
+                delete(f.entries, op.Key)
+            }
+        }
+    }
+    return results, nil
+}
+
+// Watch implements lrucache.Transactional
+func (f *Fake) Watch(keys ...string) []lrucache.TxnOp {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "Watch", Values: keys})
+    ops := make([]lrucache.TxnOp, len(keys))
+    for i, key := range keys {
+        ops[i] = lrucache.TxnOp{Op: "check", Key: key, Mode: lrucache.StoreIfAbsent}
+        if e, err := f.live(key, true); err == nil {
+            ops[i] = lrucache.TxnOp{Op: "check", Key: key, CAS: e.cas}
+        }
+    }
+    return ops
+}
+
+// Export implements lrucache.Replicated
+func (f *Fake) Export() []lrucache.Entry {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "Export"})
+    now := f.Clock.Now()
+    var entries []lrucache.Entry
+    for elem := f.order.Front(); elem != nil; elem = elem.Next() {
+        if e := elem.Value.(*entry); e.expiration.IsZero() || now.Before(e.expiration) {
+            entries = append(entries, lrucache.Entry{Key: e.key, Value: e.value, Kind: e.kind, Flags: e.flags, ExpiresAt: e.expiration, StaleAt: e.staleAt})
+        }
+    }
+    return entries
+}
+
+// Import implements lrucache.Replicated
+func (f *Fake) Import(entries []lrucache.Entry) int {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "Import"})
+    now := f.Clock.Now()
+    n := 0
+    for i := len(entries) - 1; i >= 0; i-- {
+        e := entries[i]
+        if !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt) {
+            continue
+        }
+        f.setStale(e.Key, e.Value, e.Kind, e.Flags, e.StaleAt, e.ExpiresAt, now)
+        n++
+    }
+    return n
+}
+
+// DebugEntries implements lrucache.Administered
+func (f *Fake) DebugEntries(limit, maxValue int) []lrucache.DebugEntry {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "DebugEntries"})
+    return f.debugEntries("", limit, maxValue)
+}
+
+// DebugEntriesMatching implements lrucache.Administered
+func (f *Fake) DebugEntriesMatching(pattern string, limit, maxValue int) []lrucache.DebugEntry {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "DebugEntriesMatching", Values: []string{pattern}})
+    return f.debugEntries(pattern, limit, maxValue)
+}
+
+// debugEntries lists the entries for DebugEntriesMatching; the caller holds the mutex
+func (f *Fake) debugEntries(pattern string, limit, maxValue int) []lrucache.DebugEntry {
+    now := f.Clock.Now()
+    var entries []lrucache.DebugEntry
+    for elem := f.order.Front(); elem != nil && (limit <= 0 || len(entries) < limit); elem = elem.Next() {
+        e := elem.Value.(*entry)
+        if pattern != "" && !lrucache.MatchGlob(pattern, e.key) {
+            continue
+        }
+        d := lrucache.DebugEntry{Key: e.key, Value: e.value, Kind: e.kind, Bytes: int64(len(e.key) + len(e.value)), TTL: -1, ExpiresAt: e.expiration}
+        if maxValue > 0 && len(d.Value) > maxValue {
+            d.Value, d.Truncated = strings.ToValidUTF8(d.Value[:maxValue], ""), true
+        }
+        if !e.expiration.IsZero() {
+            d.TTL = max(0, int((e.expiration.Sub(now)+time.Second-1)/time.Second))
+        }
+        entries = append(entries, d)
+    }
+    return entries
+}
+
+// Len implements lrucache.Cache
+func (f *Fake) Len() int {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "Len"})
+    return f.order.Len()
+}
+
+// Capacity implements lrucache.Administered
+func (f *Fake) Capacity() int {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "Capacity"})
+    return f.capacity
+}
+
+// Resize implements lrucache.Administered
+func (f *Fake) Resize(capacity int) (int, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "Resize"})
+    if capacity <= 0 {
+        return 0, lrucache.ErrInvalidCapacity
+    }
+    f.capacity = capacity
+    evicted := 0
+    for f.order.Len() > capacity {
+        oldest := f.order.Back()
+        f.order.Remove(oldest)
+        delete(f.entries, oldest.Value.(*entry).key)
+        f.stats.Evictions++
+        evicted++
+    }
+    return evicted, nil
+}
+
+// Stats implements lrucache.Administered
+func (f *Fake) Stats() lrucache.CacheStats {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "Stats"})
+    stats := f.stats
+    stats.Entries, stats.Capacity = f.order.Len(), f.capacity
+    for _, elem := range f.entries {
+        e := elem.Value.(*entry)
+        stats.Bytes += int64(len(e.key) + len(e.value))
+    }
+    return stats
+}
+
+// Heatmap returns nil; the fake does not count reads per prefix
+func (f *Fake) Heatmap() *lrucache.Heatmap {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "Heatmap"})
+    return nil
+}
+
+// update writes the new encoding of a value of kind k at key, keeping the
+// flags and expiration of prev, the live entry if any; an empty value deletes
+// the key. The caller holds the mutex.
+func (f *Fake) update(key string, prev *entry, k lrucache.Kind, value string, empty bool) {
+    if empty {
+        if elem, ok := f.entries[key]; ok {
+            f.order.Remove(elem)
+            delete(f.entries, key)
+        }
+        return
+    }
+    var flags uint32
+    var expiration time.Time
+    if prev != nil {
+        flags, expiration = prev.flags, prev.expiration
+    }
+    f.set(key, value, k, flags, expiration, f.Clock.Now())
+}
+
+// hash returns the live hash at key and its entry, or an empty hash and nil
+// if there is none; the caller holds the mutex
+func (f *Fake) hash(key string) (map[string]string, *entry, error) {
+    h := map[string]string{}
+    e, err := f.live(key, false)
+    if err != nil {
+        return h, nil, nil
+    }
+    if e.kind != lrucache.KindHash {
+        return nil, nil, lrucache.ErrWrongType
+    }
+    json.Unmarshal([]byte(e.value), &h)
+    return h, e, nil
+}
+
+// HSet implements lrucache.Hashes
+func (f *Fake) HSet(key, field, value string) (bool, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "HSet", Key: key, Field: field, Value: value}); err != nil {
+        return false, err
+    }
+    h, e, err := f.hash(key)
+    if err != nil {
+        return false, err
+    }
+    _, exists := h[field]
+    h[field] = value
+    data, _ := json.Marshal(h)
+    f.update(key, e, lrucache.KindHash, string(data), false)
+    return !exists, nil
+}
+
+// HGet implements lrucache.Hashes
+func (f *Fake) HGet(key, field string) (string, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "HGet", Key: key, Field: field}); err != nil {
+        return "", err
+    }
+    e, err := f.read(key, lrucache.KindHash)
+    if err != nil {
+        return "", err
+    }
+    h := map[string]string{}
+    json.Unmarshal([]byte(e.value), &h)
+    value, found := h[field]
+    if !found {
+        return "", lrucache.ErrNotFound
+    }
+    return value, nil
+}
+
+// HDel implements lrucache.Hashes
+func (f *Fake) HDel(key string, fields ...string) (int, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "HDel", Key: key, Field: strings.Join(fields, " ")}); err != nil {
+        return 0, err
+    }
+    h, e, err := f.hash(key)
+    if err != nil {
+        return 0, err
+    }
+    n := 0
+    for _, field := range fields {
+        if _, found := h[field]; found {
+            delete(h, field)
+            n++
+        }
+    }
+    if n > 0 {
+        data, _ := json.Marshal(h)
+        f.update(key, e, lrucache.KindHash, string(data), len(h) == 0)
+    }
+    return n, nil
+}
+
+// HGetAll implements lrucache.Hashes
+func (f *Fake) HGetAll(key string) (map[string]string, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "HGetAll", Key: key}); err != nil {
+        return nil, err
+    }
+    e, err := f.read(key, lrucache.KindHash)
+    if err != nil {
+        return nil, err
+    }
+    h := map[string]string{}
+    json.Unmarshal([]byte(e.value), &h)
+    return h, nil
+}
+
+// list returns the live list at key and its entry, or nil if there is none;
+// the caller holds the mutex
+func (f *Fake) list(key string) ([]string, *entry, error) {
+    e, err := f.live(key, false)
+    if err != nil {
+        return nil, nil, nil
+    }
+    if e.kind != lrucache.KindList {
+        return nil, nil, lrucache.ErrWrongType
+    }
+    var values []string
+    json.Unmarshal([]byte(e.value), &values)
+    return values, e, nil
+}
+
+// push records a push called method and runs it
+func (f *Fake) push(method, key string, values []string) (int, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: method, Key: key, Values: values}); err != nil {
+        return 0, err
+    }
+    items, e, err := f.list(key)
+    if err != nil {
+        return 0, err
+    }
+    if method == "LPush" {
+        pushed := slices.Clone(values)
+        slices.Reverse(pushed)
+        items = append(pushed, items...)
+    } else {
+        items = append(items, values...)
+    }
+    data, _ := json.Marshal(items)
+    f.update(key, e, lrucache.KindList, string(data), len(items) == 0)
+    return len(items), nil
+}
+
+// LPush implements lrucache.Lists
+func (f *Fake) LPush(key string, values ...string) (int, error) {
+    return f.push("LPush", key, values)
+}
+
+// RPush implements lrucache.Lists
+func (f *Fake) RPush(key string, values ...string) (int, error) {
+    return f.push("RPush", key, values)
+}
+
+// LPop implements lrucache.Lists
+func (f *Fake) LPop(key string) (string, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "LPop", Key: key}); err != nil {
+        return "", err
+    }
+    items, e, err := f.list(key)
+    if err != nil {
+        return "", err
+    }
+    if len(items) == 0 {
+        return "", lrucache.ErrNotFound
+    }
+    data, _ := json.Marshal(items[1:])
+    f.update(key, e, lrucache.KindList, string(data), len(items) == 1)
+    return items[0], nil
+}
+
+// LRange implements lrucache.Lists
+func (f *Fake) LRange(key string, start, stop int) ([]string, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "LRange", Key: key}); err != nil {
+        return nil, err
+    }
+    e, err := f.read(key, lrucache.KindList)
+    if err != nil {
+        return nil, err
+    }
+    var items []string
+    json.Unmarshal([]byte(e.value), &items)
+    n := len(items)
+    if start < 0 {
+        start += n
+    }
+    if stop < 0 {
+        stop += n
+    }
+    start, stop = max(start, 0), min(stop+1, n)
+    if start >= stop {
+        return items[:0], nil
+    }
+    return items[start:stop], nil
+}
+
+// members returns the live set at key, sorted, and its entry, or nil if
+// there is none; the caller holds the mutex
+func (f *Fake) members(key string) ([]string, *entry, error) {
+    e, err := f.live(key, false)
+    if err != nil {
+        return nil, nil, nil
+    }
+    if e.kind != lrucache.KindSet {
+        return nil, nil, lrucache.ErrWrongType
+    }
+    var members []string
+    json.Unmarshal([]byte(e.value), &members)
+    return members, e, nil
+}
+
+// changeSet records an SAdd or SRem call and runs it
+func (f *Fake) changeSet(method, key string, members []string) (int, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: method, Key: key, Values: members}); err != nil {
+        return 0, err
+    }
+    set, e, err := f.members(key)
+    if err != nil {
+        return 0, err
+    }
+    n := 0
+    for _, member := range members {
+        i, found := slices.BinarySearch(set, member)
+        switch {
+        case method == "SAdd" && !found:
+            set = slices.Insert(set, i, member)
+            n++
+        case method == "SRem" && found:
+            set = slices.Delete(set, i, i+1)
+            n++
+        }
+    }
+    if n > 0 {
+        data, _ := json.Marshal(set)
+        f.update(key, e, lrucache.KindSet, string(data), len(set) == 0)
+    }
+    return n, nil
+}
+
+// SAdd implements lrucache.Sets
+func (f *Fake) SAdd(key string, members ...string) (int, error) {
+    return f.changeSet("SAdd", key, members)
+}
+
+// SRem implements lrucache.Sets
+func (f *Fake) SRem(key string, members ...string) (int, error) {
+    return f.changeSet("SRem", key, members)
+}
+
+// readSet records a set read called method and returns the members
+func (f *Fake) readSet(method, key, member string) ([]string, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: method, Key: key, Value: member}); err != nil {
+        return nil, err
+    }
+    e, err := f.read(key, lrucache.KindSet)
+    if err != nil {
+        return nil, err
+    }
+    var members []string
+    json.Unmarshal([]byte(e.value), &members)
+    return members, nil
+}
+
+// SIsMember implements lrucache.Sets
+func (f *Fake) SIsMember(key, member string) (bool, error) {
+    members, err := f.readSet("SIsMember", key, member)
+    if err == lrucache.ErrNotFound || err == lrucache.ErrExpired {
+        return false, nil
+    }
+    _, found := slices.BinarySearch(members, member)
+    return found, err
+}
+
+// SMembers implements lrucache.Sets
+func (f *Fake) SMembers(key string) ([]string, error) {
+    return f.readSet("SMembers", key, "")
+}
+
+// SCard implements lrucache.Sets
+func (f *Fake) SCard(key string) (int, error) {
+    members, err := f.readSet("SCard", key, "")
+    if err == lrucache.ErrNotFound || err == lrucache.ErrExpired {
+        return 0, nil
+    }
+    return len(members), err
+}
+
+// sortedSet returns the live sorted set at key, in rank order, and its
+// entry, or nil if there is none; the caller holds the mutex
+func (f *Fake) sortedSet(key string) ([]lrucache.ZMember, *entry, error) {
+    e, err := f.live(key, false)
+    if err != nil {
+        return nil, nil, nil
+    }
+    if e.kind != lrucache.KindSortedSet {
+        return nil, nil, lrucache.ErrWrongType
+    }
+    var members []lrucache.ZMember
+    json.Unmarshal([]byte(e.value), &members)
+    return members, e, nil
+}
+
+// score records a ZAdd or ZIncrBy call and runs it, returning how many
+// members are new and the last score written
+func (f *Fake) score(method, key string, members []lrucache.ZMember, delta float64) (int, float64, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    names := make([]string, len(members))
+    for i, m := range members {
+        names[i] = m.Member
+    }
+    if err := f.record(Call{Method: method, Key: key, Values: names, Score: delta}); err != nil {
+        return 0, 0, err
+    }
+    set, e, err := f.sortedSet(key)
+    if err != nil {
+        return 0, 0, err
+    }
+    n := 0
+    var score float64
+    for _, m := range members {
+        i := slices.IndexFunc(set, func(z lrucache.ZMember) bool { return z.Member == m.Member })
+        if i < 0 {
+            set = append(set, lrucache.ZMember{Member: m.Member})
+            i = len(set) - 1
+            n++
+        }
+        score = m.Score
+        if method == "ZIncrBy" {
+            score += set[i].Score
+        }
+        if math.IsNaN(score) || math.IsInf(score, 0) {
+            return 0, 0, lrucache.ErrInvalidScore
+        }
+        set[i].Score = score
+    }
+    slices.SortFunc(set, func(a, b lrucache.ZMember) int {
+        return cmp.Or(cmp.Compare(a.Score, b.Score), cmp.Compare(a.Member, b.Member))
+    })
+    data, _ := json.Marshal(set)
+    f.update(key, e, lrucache.KindSortedSet, string(data), len(set) == 0)
+    return n, score, nil
+}
+
+// ZAdd implements lrucache.SortedSets
+func (f *Fake) ZAdd(key string, members ...lrucache.ZMember) (int, error) {
+    n, _, err := f.score("ZAdd", key, members, 0)
+    return n, err
+}
+
+// ZIncrBy implements lrucache.SortedSets
+func (f *Fake) ZIncrBy(key, member string, delta float64) (float64, error) {
+    _, score, err := f.score("ZIncrBy", key, []lrucache.ZMember{{Member: member, Score: delta}}, delta)
+    return score, err
+}
+
+// readSortedSet records a sorted set read called method and returns the
+// members in rank order
+func (f *Fake) readSortedSet(method, key, member string) ([]lrucache.ZMember, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: method, Key: key, Value: member}); err != nil {
+        return nil, err
+    }
+    e, err := f.read(key, lrucache.KindSortedSet)
+    if err != nil {
+        return nil, err
+    }
+    var members []lrucache.ZMember
+    json.Unmarshal([]byte(e.value), &members)
+    return members, nil
+}
+
+// ZRange implements lrucache.SortedSets
+func (f *Fake) ZRange(key string, start, stop int) ([]lrucache.ZMember, error) {
+    members, err := f.readSortedSet("ZRange", key, "")
+    if err != nil {
+        return nil, err
+    }
+    n := len(members)
+    if start < 0 {
+        start += n
+    }
+    if stop < 0 {
+        stop += n
+    }
+    start, stop = max(start, 0), min(stop+1, n)
+    if start >= stop {
+        return members[:0], nil
+    }
+    return members[start:stop], nil
+}
+
+// ZRank implements lrucache.SortedSets
+func (f *Fake) ZRank(key, member string) (int, error) {
+    members, err := f.readSortedSet("ZRank", key, member)
+    if err != nil {
+        return 0, err
+    }
+    i := slices.IndexFunc(members, func(z lrucache.ZMember) bool { return z.Member == member })
+    if i < 0 {
+        return 0, lrucache.ErrNotFound
+    }
+    return i, nil
+}
+
+// bitmap records a bit call called method and returns the live value at key,
+// "" if there is none, and its entry; the caller holds the mutex
+func (f *Fake) bitmap(method, key string, offset int64) (string, *entry, error) {
+    if err := f.record(Call{Method: method, Key: key, Offset: offset}); err != nil {
+        return "", nil, err
+    }
+    e, err := f.live(key, false)
+    if err != nil {
+        return "", nil, nil
+    }
+    if e.kind != lrucache.KindString {
+        return "", nil, lrucache.ErrWrongType
+    }
+    return e.value, e, nil
+}
+
+// SetBit implements lrucache.Bitmaps
+func (f *Fake) SetBit(key string, offset int64, bit int) (int, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    value, e, err := f.bitmap("SetBit", key, offset)
+    if err != nil {
+        return 0, err
+    }
+    if (bit != 0 && bit != 1) || offset < 0 || offset > lrucache.MaxBitOffset {
+        return 0, lrucache.ErrInvalidBit
+    }
+    data := []byte(value)
+    if i := int(offset / 8); i >= len(data) {
+        data = append(data, make([]byte, i+1-len(data))...)
+    }
+    mask := byte(1) << (7 - offset%8)
+    old := 0
+    if data[offset/8]&mask != 0 {
+        old = 1
+    }
+    if bit == 1 {
+        data[offset/8] |= mask
+    } else {
+        data[offset/8] &^= mask
+    }
+    f.update(key, e, lrucache.KindString, string(data), false)
+    return old, nil
+}
+
+// GetBit implements lrucache.Bitmaps
+func (f *Fake) GetBit(key string, offset int64) (int, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    value, _, err := f.bitmap("GetBit", key, offset)
+    if err != nil {
+        return 0, err
+    }
+    if offset < 0 || offset > lrucache.MaxBitOffset {
+        return 0, lrucache.ErrInvalidBit
+    }
+    if offset/8 >= int64(len(value)) {
+        return 0, nil
+    }
+    return int(value[offset/8]>>(7-offset%8)) & 1, nil
+}
+
+// BitCount implements lrucache.Bitmaps
+func (f *Fake) BitCount(key string) (int, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    value, _, err := f.bitmap("BitCount", key, 0)
+    n := 0
+    for i := 0; i < len(value); i++ {
+        n += bits.OnesCount8(value[i])
+    }
+    return n, err
+}
+
+// elements returns the distinct elements added to the HyperLogLog at key,
+// sorted, and its entry, or nil if there is none; unlike lrucache.LRUCache
+// the fake keeps every element, so its counts are exact. The caller holds
+// the mutex.
+func (f *Fake) elements(key string) ([]string, *entry, error) {
+    e, err := f.live(key, false)
+    if err != nil {
+        return nil, nil, nil
+    }
+    if e.kind != lrucache.KindHLL {
+        return nil, nil, lrucache.ErrWrongType
+    }
+    var elements []string
+    json.Unmarshal([]byte(e.value), &elements)
+    return elements, e, nil
+}
+
+// addElements inserts elements into the sorted slice all, reporting whether
+// any was missing
+func addElements(all []string, elements []string) ([]string, bool) {
+    changed := false
+    for _, element := range elements {
+        if i, found := slices.BinarySearch(all, element); !found {
+            all, changed = slices.Insert(all, i, element), true
+        }
+    }
+    return all, changed
+}
+
+// PFAdd implements lrucache.HyperLogLogs
+func (f *Fake) PFAdd(key string, elements ...string) (bool, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "PFAdd", Key: key, Values: elements}); err != nil {
+        return false, err
+    }
+    all, e, err := f.elements(key)
+    if err != nil {
+        return false, err
+    }
+    all, changed := addElements(all, elements)
+    if !changed && e != nil {
+        return false, nil
+    }
+    data, _ := json.Marshal(all)
+    f.update(key, e, lrucache.KindHLL, string(data), false)
+    return true, nil
+}
+
+// PFCount implements lrucache.HyperLogLogs
+func (f *Fake) PFCount(keys ...string) (int64, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "PFCount", Values: keys}); err != nil {
+        return 0, err
+    }
+    var all []string
+    for _, key := range keys {
+        e, err := f.read(key, lrucache.KindHLL)
+        if err == lrucache.ErrNotFound || err == lrucache.ErrExpired {
+            continue
+        }
+        if err != nil {
+            return 0, err
+        }
+        var elements []string
+        json.Unmarshal([]byte(e.value), &elements)
+        all, _ = addElements(all, elements)
+    }
+    return int64(len(all)), nil
+}
+
+// PFMerge implements lrucache.HyperLogLogs
+func (f *Fake) PFMerge(dest string, sources ...string) error {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "PFMerge", Key: dest, Values: sources}); err != nil {
+        return err
+    }
+    all, e, err := f.elements(dest)
+    if err != nil {
+        return err
+    }
+    changed := e == nil
+    for _, key := range sources {
+        elements, _, err := f.elements(key)
+        if err != nil {
+            return err
+        }
+        var added bool
+        all, added = addElements(all, elements)
+        changed = changed || added
+    }
+    if changed {
+        data, _ := json.Marshal(all)
+        f.update(dest, e, lrucache.KindHLL, string(data), false)
+    }
+    return nil
+}
+
+// BumpGeneration implements lrucache.Transactional; it deletes the keys under prefix
+// at once rather than when they are next read
+func (f *Fake) BumpGeneration(prefix string) (uint64, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if err := f.record(Call{Method: "BumpGeneration", Key: prefix}); err != nil {
+        return 0, err
+    }
+    for key, elem := range f.entries {
+        if strings.HasPrefix(key, prefix) {
+            f.order.Remove(elem)
+            delete(f.entries, key)
+        }
+    }
+    f.gens[prefix]++
+    return f.gens[prefix], nil
+}
+
+// Generation implements lrucache.Transactional
+func (f *Fake) Generation(prefix string) uint64 {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    f.record(Call{Method: "Generation", Key: prefix})
+    return f.gens[prefix]
+}
+
+// Keys returns the keys held, expired or not, in sorted order
+func (f *Fake) Keys() []string {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    keys := make([]string, 0, len(f.entries))
+    for key := range f.entries {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
@@ -0,0 +1,89 @@
+package cachetest
+
+import (
+    "errors"
+    "slices"
+    "testing"
+    "time"
+
+    "lru-cache/lrucache"
+)
+
+func TestClock(t *testing.T) {
+    start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+    f := New(10)
+    f.Set("k", "v", time.Minute)
+    steps := []struct {
+        name    string
+        move    func()
+        wantErr error
+    }{
+        {"stopped", func() { time.Sleep(time.Millisecond) }, nil},
+        {"before the expiration", func() { f.Clock.Advance(time.Minute - time.Nanosecond) }, nil},
+        {"at the expiration", func() { f.Clock.Advance(time.Nanosecond) }, lrucache.ErrExpired},
+    }
+    for _, s := range steps {
+        s.move()
+        if _, err := f.Get("k"); err != s.wantErr {
+            t.Errorf("%s: Get = %v, want %v", s.name, err, s.wantErr)
+        }
+    }
+    f.Clock.Set(start)
+    if !f.Clock.Now().Equal(start) {
+        t.Fatalf("Now = %v after Set, want %v", f.Clock.Now(), start)
+    }
+}
+
+func TestFailNext(t *testing.T) {
+    f := New(10)
+    first, second := errors.New("first"), errors.New("second")
+    f.FailNext("Set", first, second)
+    tests := []struct {
+        value   string
+        wantErr error
+    }{
+        {"1", first},
+        {"2", second},
+        {"3", nil},
+    }
+    for _, tt := range tests {
+        if err := f.Set("k", tt.value, 0); err != tt.wantErr {
+            t.Errorf("Set(%q) = %v, want %v", tt.value, err, tt.wantErr)
+        }
+    }
+    if got, _ := f.Get("k"); got != "3" {
+        t.Fatalf("Get = %q; a failed Set was applied", got)
+    }
+}
+
+func TestCalls(t *testing.T) {
+    f := New(10)
+    f.Set("a", "1", time.Second)
+    f.Get("a")
+    f.RPush("l", "x", "y")
+    f.Get("b")
+
+    want := []Call{
+        {Method: "Set", Key: "a", Value: "1", Expiration: time.Second},
+        {Method: "Get", Key: "a"},
+        {Method: "RPush", Key: "l", Values: []string{"x", "y"}},
+        {Method: "Get", Key: "b"},
+    }
+    calls := f.Calls()
+    if !slices.EqualFunc(calls, want, callsEqual) {
+        t.Fatalf("Calls = %+v\nwant %+v", calls, want)
+    }
+    if gets := f.CallsTo("Get"); len(gets) != 2 || gets[1].Key != "b" {
+        t.Fatalf("CallsTo(Get) = %+v", gets)
+    }
+    f.ResetCalls()
+    if len(f.Calls()) != 0 {
+        t.Fatal("ResetCalls kept calls")
+    }
+}
+
+// callsEqual compares calls field by field, Values included
+func callsEqual(a, b Call) bool {
+    return a.Method == b.Method && a.Key == b.Key && a.Field == b.Field && slices.Equal(a.Values, b.Values) &&
+        a.Score == b.Score && a.Offset == b.Offset && a.Value == b.Value && a.Expiration == b.Expiration
+}
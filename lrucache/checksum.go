@@ -0,0 +1,42 @@
+package lrucache
+
+import (
+    "errors"
+    "fmt"
+    "hash/crc32"
+)
+
+// ErrCorrupt is returned for an entry whose value no longer matches the
+// checksum taken when it was written; a read drops the entry, so the next
+// one misses
+var ErrCorrupt = errors.New("value is corrupt")
+
+// crcTable is CRC-32C, which most CPUs compute in hardware
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum returns the CRC-32C of value
+func checksum(value string) uint32 {
+    return crc32.Checksum([]byte(value), crcTable)
+}
+
+// verify checks the value of item against its checksum, counting a
+// mismatch; the caller holds the mutex
+func (c *LRUCache) verify(item *CacheItem) error {
+    if checksum(item.value) == item.checksum {
+        return nil
+    }
+    c.stats.Corruptions++
+    return fmt.Errorf("%q: %w", item.key, ErrCorrupt)
+}
+
+// readValue is valueOf for a read, which drops an entry found corrupt unless
+// a proposer owns the contents; the caller holds the mutex
+func (c *LRUCache) readValue(item *CacheItem) (string, error) {
+    value, err := c.valueOf(item)
+    if errors.Is(err, ErrCorrupt) && c.proposer == nil {
+        if elem, found := c.cache[item.key]; found {
+            c.remove(elem, EventDelete)
+        }
+    }
+    return value, err
+}
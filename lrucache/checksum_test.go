@@ -0,0 +1,63 @@
+package lrucache
+
+import (
+    "errors"
+    "testing"
+)
+
+func TestChecksum(t *testing.T) {
+    // Check values of CRC-32C, as listed in RFC 3720 and by most references
+    tests := []struct {
+        value string
+        want  uint32
+    }{
+        {"", 0},
+        {"a", 0xc1d04330},
+        {"123456789", 0xe3069283},
+        {string(make([]byte, 32)), 0x8a9136aa},
+    }
+    for _, tt := range tests {
+        if got := checksum(tt.value); got != tt.want {
+            t.Errorf("checksum(%q) = %#08x, want %#08x", tt.value, got, tt.want)
+        }
+    }
+}
+
+func TestCorruptValueIsDropped(t *testing.T) {
+    c := New()
+    c.Set("k", "value", 0)
+    c.Set("other", "value", 0)
+    c.cache["k"].Value.(*CacheItem).value = "valuf" // A flipped bit
+
+    _, err := c.Get("k")
+    if !errors.Is(err, ErrCorrupt) {
+        t.Fatalf("Get of a corrupt value = %v, want ErrCorrupt", err)
+    }
+    if _, err := c.Get("k"); err != ErrNotFound {
+        t.Fatalf("Get after the corrupt read = %v, want the entry dropped", err)
+    }
+    if v, err := c.Get("other"); v != "value" || err != nil {
+        t.Fatalf("Get of an intact value = %q, %v", v, err)
+    }
+    if n := c.Stats().Corruptions; n != 1 {
+        t.Fatalf("Corruptions = %d, want 1", n)
+    }
+}
+
+func TestRestoreDropsCorruptEntries(t *testing.T) {
+    src := New()
+    src.Set("good", "v", 0)
+    src.Set("bad", "v", 0)
+    state := src.State()
+    for i := range state.Entries {
+        if state.Entries[i].Key == "bad" {
+            state.Entries[i].Value = "tampered"
+        }
+    }
+
+    c := New()
+    c.Restore(state)
+    if !c.Contains("good") || c.Contains("bad") || c.Stats().Corruptions != 1 {
+        t.Fatalf("restored %v with %d corruptions, want only the intact entry", c.Export(), c.Stats().Corruptions)
+    }
+}
@@ -0,0 +1,135 @@
+package lrucache
+
+import (
+    "fmt"
+    "sync"
+
+    "github.com/klauspost/compress/s2"
+    "github.com/klauspost/compress/zstd"
+)
+
+// Compression is the codec an entry's value is stored with
+type Compression string
+
+const (
+    // CompressNone stores values as they are
+    CompressNone Compression = ""
+    // CompressSnappy uses snappy, which is fast but saves less
+    CompressSnappy Compression = "snappy"
+    // CompressZstd uses zstd, which saves more at a higher CPU cost
+    CompressZstd Compression = "zstd"
+)
+
+// ParseCompression returns the codec named s: "none", "snappy" or "zstd"
+func ParseCompression(s string) (Compression, error) {
+    switch Compression(s) {
+    case CompressNone, "none":
+        return CompressNone, nil
+    case CompressSnappy, CompressZstd:
+        return Compression(s), nil
+    }
+    return "", fmt.Errorf("unknown compression %q", s)
+}
+
+// CompressionRatio is the size of the compressed values as written divided
+// by their stored size, or 1 if no value is stored compressed
+func (s CacheStats) CompressionRatio() float64 {
+    if s.CompressedBytes == 0 {
+        return 1
+    }
+    return float64(s.UncompressedBytes) / float64(s.CompressedBytes)
+}
+
+// zstd encoders and decoders are safe for concurrent EncodeAll and DecodeAll
+// calls and costly to create, so every cache shares one of each
+var (
+    zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+        enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+        return enc
+    })
+    zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+        dec, _ := zstd.NewReader(nil)
+        return dec
+    })
+)
+
+// compress returns value in the form the cache keeps it in and the codec
+// used; values under the threshold, and those compression would not make
+// shorter, are kept as they are
+func (c *LRUCache) compress(value string) (string, Compression) {
+    if c.compression == CompressNone || len(value) < c.compressThreshold {
+        return value, CompressNone
+    }
+    var out []byte
+    switch c.compression {
+    case CompressSnappy:
+        out = s2.EncodeSnappy(nil, []byte(value))
+    case CompressZstd:
+        out = zstdEncoder().EncodeAll([]byte(value), nil)
+    }
+    if len(out) >= len(value) {
+        return value, CompressNone
+    }
+    return string(out), c.compression
+}
+
+// valueOf returns the value of item as it was written, checking it against
+// its checksum first; the caller holds the mutex
+func (c *LRUCache) valueOf(item *CacheItem) (string, error) {
+    if err := c.verify(item); err != nil {
+        return "", err
+    }
+    stored, err := c.unseal(item)
+    if err != nil {
+        return "", err
+    }
+    var out []byte
+    switch item.compression {
+    case CompressNone:
+        return stored, nil
+    case CompressSnappy:
+        out, err = s2.Decode(nil, []byte(stored))
+    case CompressZstd:
+        out, err = zstdDecoder().DecodeAll([]byte(stored), nil)
+    default:
+        err = fmt.Errorf("unknown compression %q", item.compression)
+    }
+    if err != nil {
+        return "", fmt.Errorf("decompressing %q: %w", item.key, err)
+    }
+    return string(out), nil
+}
+
+// store puts value in a new item, or one unstore was called on, compressed
+// and encrypted if the cache is configured to, and counts it in the memory
+// and compression counters; the caller holds the mutex
+func (c *LRUCache) store(item *CacheItem, value string) {
+    stored, compression := c.compress(value)
+    item.value, item.compression = c.seal(item.key, stored), compression
+    item.checksum = checksum(item.value)
+    item.size = len(value)
+    c.stats.Bytes += entrySize(item.key, item.value)
+    if item.compression != CompressNone {
+        c.stats.Compressed++
+        c.stats.CompressedBytes += int64(len(item.value))
+        c.stats.UncompressedBytes += int64(item.size)
+    }
+}
+
+// unstore takes the value of item out of the memory and compression
+// counters; the caller holds the mutex
+func (c *LRUCache) unstore(item *CacheItem) {
+    c.stats.Bytes -= entrySize(item.key, item.value)
+    if item.compression != CompressNone {
+        c.stats.Compressed--
+        c.stats.CompressedBytes -= int64(len(item.value))
+        c.stats.UncompressedBytes -= int64(item.size)
+    }
+}
+
+// clearStored zeroes the memory and compression counters once every entry
+// is gone; the caller holds the mutex
+func (c *LRUCache) clearStored() {
+    c.stats.Bytes = 0
+    c.stats.Compressed, c.stats.CompressedBytes, c.stats.UncompressedBytes = 0, 0, 0
+}
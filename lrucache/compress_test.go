@@ -0,0 +1,90 @@
+package lrucache
+
+import (
+    "crypto/rand"
+    "strings"
+    "testing"
+)
+
+func TestParseCompression(t *testing.T) {
+    tests := []struct {
+        s       string
+        want    Compression
+        wantErr bool
+    }{
+        {"", CompressNone, false},
+        {"none", CompressNone, false},
+        {"snappy", CompressSnappy, false},
+        {"zstd", CompressZstd, false},
+        {"gzip", "", true},
+    }
+    for _, tt := range tests {
+        got, err := ParseCompression(tt.s)
+        if got != tt.want || (err != nil) != tt.wantErr {
+            t.Errorf("ParseCompression(%q) = %q, %v", tt.s, got, err)
+        }
+    }
+}
+
+func TestCompression(t *testing.T) {
+    random := make([]byte, 512)
+    rand.Read(random)
+    tests := []struct {
+        name      string
+        codec     Compression
+        value     string
+        wantCodec Compression
+    }{
+        {"snappy", CompressSnappy, strings.Repeat("abc", 200), CompressSnappy},
+        {"zstd", CompressZstd, strings.Repeat("abc", 200), CompressZstd},
+        {"under the threshold", CompressZstd, strings.Repeat("a", 63), CompressNone},
+        {"incompressible", CompressZstd, string(random), CompressNone},
+        {"disabled", CompressNone, strings.Repeat("abc", 200), CompressNone},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c := New(WithCompression(tt.codec, 64))
+            if err := c.Set("k", tt.value, 0); err != nil {
+                t.Fatal(err)
+            }
+            if v, err := c.Get("k"); v != tt.value || err != nil {
+                t.Fatalf("Get = %d bytes, %v; want the value as written", len(v), err)
+            }
+            e := c.DebugEntries(0, 0)[0]
+            if e.Compression != tt.wantCodec || e.Value != tt.value {
+                t.Fatalf("stored with %q, want %q", e.Compression, tt.wantCodec)
+            }
+
+            s := c.Stats()
+            if tt.wantCodec == CompressNone {
+                if s.Compressed != 0 || s.CompressionRatio() != 1 {
+                    t.Fatalf("stats = %+v, want nothing compressed", s)
+                }
+                return
+            }
+            if s.Compressed != 1 || s.UncompressedBytes != int64(len(tt.value)) || s.CompressedBytes >= s.UncompressedBytes || s.CompressionRatio() <= 1 {
+                t.Fatalf("stats = %+v, want one shorter compressed value", s)
+            }
+        })
+    }
+}
+
+func TestCompressionCounters(t *testing.T) {
+    c := New(WithCompression(CompressSnappy, 16))
+    long := strings.Repeat("x", 1000)
+    c.Set("a", long, 0)
+    c.Set("b", long, 0)
+    c.Set("a", "short", 0) // No longer compressed
+    if s := c.Stats(); s.Compressed != 1 || s.UncompressedBytes != 1000 {
+        t.Fatalf("after an overwrite: %+v", s)
+    }
+    c.Delete("b")
+    if s := c.Stats(); s.Compressed != 0 || s.CompressedBytes != 0 || s.UncompressedBytes != 0 {
+        t.Fatalf("after a delete: %+v", s)
+    }
+    c.Set("c", long, 0)
+    c.Flush()
+    if s := c.Stats(); s.Compressed != 0 || s.Bytes != 0 {
+        t.Fatalf("after a flush: %+v", s)
+    }
+}
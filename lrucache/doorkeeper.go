@@ -0,0 +1,74 @@
+package lrucache
+
+import (
+    "hash/fnv"
+    "time"
+)
+
+// Sizing of the doorkeeper's bloom filter: about a 1% false positive rate
+// with as many keys noted per window as the cache holds
+const (
+    doorkeeperBitsPerKey = 10
+    doorkeeperHashes     = 7
+)
+
+// doorkeeper is a bloom filter of the keys written once, cleared every
+// window, which admits a new key only on its second write in the window
+type doorkeeper struct {
+    bits   []uint64
+    window time.Duration
+    reset  time.Time // When the filter was last cleared
+}
+
+func newDoorkeeper(capacity int, window time.Duration) *doorkeeper {
+    return &doorkeeper{bits: make([]uint64, doorkeeperWords(capacity)), window: window}
+}
+
+// doorkeeperWords is the length of the filter for a cache of capacity entries
+func doorkeeperWords(capacity int) int {
+    n := max(capacity, 64) * doorkeeperBitsPerKey
+    return (n + 63) / 64
+}
+
+// resize sizes the filter for a cache of capacity entries. A filter of
+// another size forgets the keys noted in the current window, which then need
+// another write; the window itself carries on.
+func (d *doorkeeper) resize(capacity int) {
+    if n := doorkeeperWords(capacity); n != len(d.bits) {
+        d.bits = make([]uint64, n)
+    }
+}
+
+// admit notes key and reports whether it had already been noted in the
+// current window; window boundaries follow at so that every replica applying
+// the same mutations clears the filter alike
+func (d *doorkeeper) admit(key string, at time.Time) bool {
+    if !at.Before(d.reset.Add(d.window)) {
+        clear(d.bits)
+        d.reset = at
+    }
+    // Double hashing derives the k probes from one 64-bit hash
+    h := fnv.New64a()
+    h.Write([]byte(key))
+    sum := h.Sum64()
+    h1, h2 := sum, sum>>32|sum<<32|1
+    m := uint64(len(d.bits) * 64)
+    seen := true
+    for i := range uint64(doorkeeperHashes) {
+        bit := (h1 + i*h2) % m
+        if d.bits[bit/64]&(1<<(bit%64)) == 0 {
+            seen = false
+            d.bits[bit/64] |= 1 << (bit % 64)
+        }
+    }
+    return seen
+}
+
+// WithDoorkeeper caches a key that is not yet present only on its second
+// write within window, noting the first in a bloom filter instead, so that
+// keys written once and never read again do not push out others. A refused
+// write returns ErrNotAdmitted, leaves the key missing and is counted in
+// CacheStats.Rejections.
+func WithDoorkeeper(window time.Duration) Option {
+    return func(c *LRUCache) { c.doorkeeperWindow = window }
+}
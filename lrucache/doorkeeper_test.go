@@ -0,0 +1,86 @@
+package lrucache
+
+import (
+    "errors"
+    "slices"
+    "strconv"
+    "testing"
+    "time"
+)
+
+func TestDoorkeeperAdmit(t *testing.T) {
+    start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+    d := newDoorkeeper(100, time.Minute)
+    tests := []struct {
+        name string
+        key  string
+        at   time.Duration // After start
+        want bool
+    }{
+        {"first write", "a", 0, false},
+        {"second write", "a", time.Second, true},
+        {"another key", "b", 2 * time.Second, false},
+        {"next window forgets", "a", time.Minute, false},
+        {"second write in the new window", "a", time.Minute + time.Second, true},
+    }
+    for _, tt := range tests {
+        if got := d.admit(tt.key, start.Add(tt.at)); got != tt.want {
+            t.Errorf("%s: admit(%q) = %v, want %v", tt.name, tt.key, got, tt.want)
+        }
+    }
+
+    // A filter of another size forgets the keys noted so far
+    d.resize(10000)
+    if d.admit("b", start.Add(time.Minute+2*time.Second)) {
+        t.Fatal("a resized filter still admitted a key noted once")
+    }
+}
+
+func TestDoorkeeperFalsePositives(t *testing.T) {
+    const n = 1000
+    d := newDoorkeeper(n, time.Hour)
+    at := time.Now()
+    for i := range n {
+        d.admit("noted"+strconv.Itoa(i), at)
+    }
+    // Probing notes the key too, so each probe starts from the same filter
+    noted := slices.Clone(d.bits)
+    admitted := 0
+    for i := range n {
+        if d.admit("new"+strconv.Itoa(i), at) {
+            admitted++
+        }
+        copy(d.bits, noted)
+    }
+    if admitted > n/50 {
+        t.Fatalf("%d of %d never written keys were admitted, want about 1%%", admitted, n)
+    }
+}
+
+func TestWithDoorkeeper(t *testing.T) {
+    c := New(WithDoorkeeper(time.Minute))
+    tests := []struct {
+        name      string
+        key       string
+        wantErr   error
+        wantFound bool
+    }{
+        {"first write is refused", "k", ErrNotAdmitted, false},
+        {"second write is admitted", "k", nil, true},
+        {"overwrites need no admission", "k", nil, true},
+    }
+    for _, tt := range tests {
+        if err := c.Set(tt.key, "v", 0); !errors.Is(err, tt.wantErr) {
+            t.Errorf("%s: Set = %v, want %v", tt.name, err, tt.wantErr)
+        }
+        if c.Contains(tt.key) != tt.wantFound {
+            t.Errorf("%s: Contains = %v", tt.name, !tt.wantFound)
+        }
+    }
+    if _, err := c.Store("other", "v", 0, 0, StoreIfAbsent); !errors.Is(err, ErrNotAdmitted) {
+        t.Errorf("Store of a new key = %v, want ErrNotAdmitted", err)
+    }
+    if n := c.Stats().Rejections; n != 2 {
+        t.Fatalf("Rejections = %d, want 2", n)
+    }
+}
@@ -0,0 +1,47 @@
+package lrucache
+
+import (
+    "crypto/cipher"
+    "crypto/rand"
+    "errors"
+    "fmt"
+)
+
+// ErrDecrypt is returned for a value that fails to decrypt, as happens when
+// the memory holding it was altered
+var ErrDecrypt = errors.New("value failed to decrypt")
+
+// WithEncryption keeps values encrypted with aead, such as AES-GCM, so they
+// cannot be read from a heap dump; each value is sealed with a random nonce
+// and bound to its key. Keys and metadata stay in the clear.
+func WithEncryption(aead cipher.AEAD) Option {
+    return func(c *LRUCache) { c.aead = aead }
+}
+
+// seal encrypts the stored form of the value of key, if the cache encrypts
+// values, prefixing the nonce
+func (c *LRUCache) seal(key, stored string) string {
+    if c.aead == nil {
+        return stored
+    }
+    out := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(stored)+c.aead.Overhead())
+    rand.Read(out)
+    return string(c.aead.Seal(out, out, []byte(stored), []byte(key)))
+}
+
+// unseal returns the stored form of the value of item, decrypted if the
+// cache encrypts values
+func (c *LRUCache) unseal(item *CacheItem) (string, error) {
+    if c.aead == nil {
+        return item.value, nil
+    }
+    n := c.aead.NonceSize()
+    if len(item.value) < n {
+        return "", fmt.Errorf("decrypting %q: %w", item.key, ErrDecrypt)
+    }
+    out, err := c.aead.Open(nil, []byte(item.value[:n]), []byte(item.value[n:]), []byte(item.key))
+    if err != nil {
+        return "", fmt.Errorf("decrypting %q: %w", item.key, ErrDecrypt)
+    }
+    return string(out), nil
+}
@@ -0,0 +1,89 @@
+package lrucache
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "errors"
+    "strings"
+    "testing"
+)
+
+func newAESGCM(t *testing.T) cipher.AEAD {
+    t.Helper()
+    block, err := aes.NewCipher(make([]byte, 32))
+    if err != nil {
+        t.Fatal(err)
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return aead
+}
+
+func TestEncryption(t *testing.T) {
+    tests := []struct {
+        name  string
+        opts  []Option
+        value string
+    }{
+        {"short value", nil, "secret"},
+        {"empty value", nil, ""},
+        {"compressed first", []Option{WithCompression(CompressZstd, 16)}, strings.Repeat("secret ", 100)},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c := New(append(tt.opts, WithEncryption(newAESGCM(t)))...)
+            c.Set("k", tt.value, 0)
+            stored := c.cache["k"].Value.(*CacheItem).value
+            if tt.value != "" && strings.Contains(stored, "secret") {
+                t.Fatal("the value is stored in the clear")
+            }
+            if v, err := c.Get("k"); v != tt.value || err != nil {
+                t.Fatalf("Get = %q, %v", v, err)
+            }
+        })
+    }
+}
+
+func TestEncryptionNonces(t *testing.T) {
+    c := New(WithEncryption(newAESGCM(t)))
+    c.Set("a", "same", 0)
+    c.Set("b", "same", 0)
+    if c.cache["a"].Value.(*CacheItem).value == c.cache["b"].Value.(*CacheItem).value {
+        t.Fatal("equal values were sealed alike")
+    }
+}
+
+func TestDecryptFailures(t *testing.T) {
+    tests := []struct {
+        name   string
+        tamper func(c *LRUCache)
+    }{
+        {"flipped byte", func(c *LRUCache) {
+            item := c.cache["a"].Value.(*CacheItem)
+            b := []byte(item.value)
+            b[len(b)-1] ^= 1
+            item.value, item.checksum = string(b), checksum(string(b))
+        }},
+        {"value of another key", func(c *LRUCache) {
+            a, b := c.cache["a"].Value.(*CacheItem), c.cache["b"].Value.(*CacheItem)
+            a.value, a.checksum = b.value, b.checksum
+        }},
+        {"shorter than a nonce", func(c *LRUCache) {
+            item := c.cache["a"].Value.(*CacheItem)
+            item.value, item.checksum = "x", checksum("x")
+        }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c := New(WithEncryption(newAESGCM(t)))
+            c.Set("a", "one", 0)
+            c.Set("b", "two", 0)
+            tt.tamper(c)
+            if _, err := c.Get("a"); !errors.Is(err, ErrDecrypt) {
+                t.Fatalf("Get = %v, want ErrDecrypt", err)
+            }
+        })
+    }
+}
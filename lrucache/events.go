@@ -0,0 +1,157 @@
+package lrucache
+
+import (
+    "fmt"
+    "slices"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// EventType identifies what happened to a cache entry
+type EventType int
+
+const (
+    // EventSet is emitted when a value is written
+    EventSet EventType = iota + 1
+    // EventDelete is emitted when a key is deleted explicitly
+    EventDelete
+    // EventExpire is emitted when an expired entry is removed
+    EventExpire
+    // EventEvict is emitted when an entry is evicted to make room
+    EventEvict
+    // EventFlush is emitted once when the whole cache is flushed
+    EventFlush
+    // EventInvalidate is emitted when another node asked for a key to be dropped
+    EventInvalidate
+    // EventGeneration is emitted when the generation of the prefix in Key is
+    // bumped
+    EventGeneration
+)
+
+// String returns the lower-case name of the event type
+func (t EventType) String() string {
+    switch t {
+    case EventSet:
+        return "set"
+    case EventDelete:
+        return "delete"
+    case EventExpire:
+        return "expire"
+    case EventEvict:
+        return "evict"
+    case EventFlush:
+        return "flush"
+    case EventInvalidate:
+        return "invalidate"
+    case EventGeneration:
+        return "generation"
+    }
+    return "unknown"
+}
+
+// ParseEventType returns the event type String names
+func ParseEventType(s string) (EventType, error) {
+    for t := EventSet; t <= EventGeneration; t++ {
+        if t.String() == s {
+            return t, nil
+        }
+    }
+    return 0, fmt.Errorf("unknown event type %q", s)
+}
+
+// Event describes a change to a cache entry; Value, Kind, Flags and
+// ExpiresAt are only set for EventSet
+type Event struct {
+    Type      EventType
+    Key       string
+    Value     string
+    Kind      Kind
+    Flags     uint32
+    ExpiresAt time.Time // Zero never expires
+    StaleAt   time.Time // Zero never turns stale
+    Time      time.Time
+    Namespace string // Of the cache, as tagged by the OnEvent hook of a server holding several; empty for its default one
+}
+
+// EventFilter selects the events a subscription receives
+type EventFilter struct {
+    Prefix    string      // Of the keys
+    Pattern   string      // Unless empty, keys must also match it as for MatchGlob
+    Types     []EventType // Unless empty, only these; otherwise every type, flush included
+    Namespace string      // Events must carry this Namespace, empty for those of the default cache
+}
+
+// match reports whether e passes the filter; flush events pass any key filter
+func (f *EventFilter) match(e Event) bool {
+    if e.Namespace != f.Namespace || len(f.Types) > 0 && !slices.Contains(f.Types, e.Type) {
+        return false
+    }
+    return e.Type == EventFlush || (strings.HasPrefix(e.Key, f.Prefix) && (f.Pattern == "" || MatchGlob(f.Pattern, e.Key)))
+}
+
+// Subscription receives the events an EventFilter selects
+type Subscription struct {
+    C       <-chan Event
+    ch      chan Event
+    filter  EventFilter
+    dropped atomic.Int64
+}
+
+// Dropped returns how many events were discarded because the subscriber fell behind
+func (s *Subscription) Dropped() int64 {
+    return s.dropped.Load()
+}
+
+// EventBus fans cache events out to subscribers without ever blocking the cache
+type EventBus struct {
+    mutex sync.RWMutex
+    subs  map[*Subscription]struct{}
+}
+
+// NewEventBus creates an EventBus with no subscribers
+func NewEventBus() *EventBus {
+    return &EventBus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe registers interest in keys starting with prefix; flush events are always delivered
+func (b *EventBus) Subscribe(prefix string, buffer int) *Subscription {
+    return b.SubscribeFilter(EventFilter{Prefix: prefix}, buffer)
+}
+
+// SubscribeFilter registers interest in the events f selects
+func (b *EventBus) SubscribeFilter(f EventFilter, buffer int) *Subscription {
+    ch := make(chan Event, buffer)
+    s := &Subscription{C: ch, ch: ch, filter: f}
+    b.mutex.Lock()
+    b.subs[s] = struct{}{}
+    b.mutex.Unlock()
+    return s
+}
+
+// Unsubscribe stops delivery and closes the subscription channel
+func (b *EventBus) Unsubscribe(s *Subscription) {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    if _, ok := b.subs[s]; ok {
+        delete(b.subs, s)
+        close(s.ch)
+    }
+}
+
+// Publish delivers e to matching subscribers, dropping it for those whose buffer is full
+func (b *EventBus) Publish(e Event) {
+    b.mutex.RLock()
+    defer b.mutex.RUnlock()
+    for s := range b.subs {
+        if !s.filter.match(e) {
+            continue
+        }
+        select {
+        case s.ch <- e:
+        default:
+            s.dropped.Add(1)
+        }
+    }
+}
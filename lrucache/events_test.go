@@ -0,0 +1,107 @@
+package lrucache
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestEventTypeNames(t *testing.T) {
+    tests := []struct {
+        t    EventType
+        name string
+    }{
+        {EventSet, "set"},
+        {EventDelete, "delete"},
+        {EventExpire, "expire"},
+        {EventEvict, "evict"},
+        {EventFlush, "flush"},
+        {EventInvalidate, "invalidate"},
+        {EventGeneration, "generation"},
+    }
+    for _, tt := range tests {
+        if got := tt.t.String(); got != tt.name {
+            t.Errorf("%d.String() = %q, want %q", tt.t, got, tt.name)
+        }
+        if got, err := ParseEventType(tt.name); got != tt.t || err != nil {
+            t.Errorf("ParseEventType(%q) = %v, %v", tt.name, got, err)
+        }
+    }
+    if _, err := ParseEventType("unknown"); err == nil {
+        t.Error("ParseEventType accepted unknown")
+    }
+    if got := EventType(0).String(); got != "unknown" {
+        t.Errorf("EventType(0).String() = %q", got)
+    }
+}
+
+func TestEventFilterMatch(t *testing.T) {
+    tests := []struct {
+        name   string
+        filter EventFilter
+        event  Event
+        want   bool
+    }{
+        {"everything", EventFilter{}, Event{Type: EventSet, Key: "k"}, true},
+        {"prefix", EventFilter{Prefix: "user:"}, Event{Type: EventSet, Key: "user:1"}, true},
+        {"other prefix", EventFilter{Prefix: "user:"}, Event{Type: EventSet, Key: "order:1"}, false},
+        {"flush passes a key filter", EventFilter{Prefix: "user:", Pattern: "user:?"}, Event{Type: EventFlush}, true},
+        {"pattern", EventFilter{Pattern: "*:1"}, Event{Type: EventDelete, Key: "user:1"}, true},
+        {"pattern mismatch", EventFilter{Pattern: "*:1"}, Event{Type: EventDelete, Key: "user:2"}, false},
+        {"type", EventFilter{Types: []EventType{EventExpire, EventEvict}}, Event{Type: EventEvict, Key: "k"}, true},
+        {"other type", EventFilter{Types: []EventType{EventExpire}}, Event{Type: EventFlush}, false},
+        {"namespace", EventFilter{Namespace: "a"}, Event{Type: EventSet, Key: "k", Namespace: "a"}, true},
+        {"default namespace only", EventFilter{}, Event{Type: EventSet, Key: "k", Namespace: "a"}, false},
+    }
+    for _, tt := range tests {
+        if got := tt.filter.match(tt.event); got != tt.want {
+            t.Errorf("%s: match = %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestEventBus(t *testing.T) {
+    bus := NewEventBus()
+    users := bus.Subscribe("user:", 1)
+    all := bus.SubscribeFilter(EventFilter{Types: []EventType{EventSet}}, 10)
+
+    bus.Publish(Event{Type: EventSet, Key: "user:1"})
+    bus.Publish(Event{Type: EventSet, Key: "user:2"}) // users is full
+    bus.Publish(Event{Type: EventDelete, Key: "order:1"})
+    if e := <-users.C; e.Key != "user:1" || users.Dropped() != 1 {
+        t.Fatalf("received %+v with %d dropped, want user:1 then a drop", e, users.Dropped())
+    }
+    if len(all.C) != 2 || all.Dropped() != 0 {
+        t.Fatalf("%d set events buffered, want 2", len(all.C))
+    }
+
+    bus.Unsubscribe(users)
+    bus.Unsubscribe(users)
+    bus.Publish(Event{Type: EventSet, Key: "user:3"})
+    if _, open := <-users.C; open {
+        t.Fatal("an unsubscribed channel is still open")
+    }
+}
+
+func TestCacheEvents(t *testing.T) {
+    var events []string
+    c := New(WithCapacity(2), WithOnEvent(func(e Event) {
+        events = append(events, e.Type.String()+" "+e.Key)
+    }))
+    c.Set("a", "1", 0)
+    c.Set("b", "2", time.Nanosecond)
+    time.Sleep(time.Millisecond)
+    c.Get("b")
+    c.Set("c", "3", 0)
+    c.Set("d", "4", 0)
+    c.Touch("c", time.Hour)
+    c.Delete("c")
+    c.Invalidate("d")
+    c.BumpGeneration("user:")
+    c.Flush()
+
+    want := "set a,set b,expire b,set c,evict a,set d,set c,delete c,invalidate d,generation user:,flush "
+    if got := strings.Join(events, ","); got != want {
+        t.Fatalf("events:\n got %s\nwant %s", got, want)
+    }
+}
@@ -0,0 +1,60 @@
+package lrucache
+
+import (
+    "strings"
+    "time"
+)
+
+// Generation is the counter of a key prefix: bumping it retires every key
+// under the prefix at once, without visiting them
+type Generation struct {
+    N   uint64 `json:"n"`   // Times the prefix was bumped
+    CAS uint64 `json:"cas"` // CAS sequence at the last bump; entries with a CAS no higher belong to older generations
+}
+
+// BumpGeneration starts a new generation of prefix and returns its number.
+// Every key starting with prefix that was written before reads as missing
+// from then on and is removed, as if expired, when next found; an empty
+// prefix retires every key.
+func (c *LRUCache) BumpGeneration(prefix string) (uint64, error) {
+    res := c.mutate(Mutation{Op: "generation", Key: prefix})
+    return uint64(res.N), res.Err
+}
+
+// Generation returns the number of times prefix was bumped
+func (c *LRUCache) Generation(prefix string) uint64 {
+    defer c.lock("generation", prefix)()
+
+    return c.generations[prefix].N
+}
+
+// applyGeneration makes a generation change; the caller holds the mutex
+func (c *LRUCache) applyGeneration(m Mutation) MutationResult {
+    if c.generations == nil {
+        c.generations = make(map[string]Generation)
+    }
+    g := c.generations[m.Key]
+    g.N, g.CAS = g.N+1, c.casSeq
+    c.generations[m.Key] = g
+    c.emit(EventGeneration, m.Key, "")
+    return MutationResult{N: int64(g.N), OK: true}
+}
+
+// gone reports whether item expired or is outdated; the caller holds the
+// mutex
+func (c *LRUCache) gone(item *CacheItem, now time.Time) bool {
+    return item.expired(now) || c.outdated(item)
+}
+
+// outdated reports whether item belongs to an older generation of a prefix
+// of its key; the caller holds the mutex
+func (c *LRUCache) outdated(item *CacheItem) bool {
+    // Few prefixes are ever bumped, so walking them beats looking up every
+    // prefix of the key
+    for prefix, g := range c.generations {
+        if item.cas <= g.CAS && strings.HasPrefix(item.key, prefix) {
+            return true
+        }
+    }
+    return false
+}
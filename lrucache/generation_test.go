@@ -0,0 +1,66 @@
+package lrucache
+
+import "testing"
+
+func TestBumpGeneration(t *testing.T) {
+    c := New()
+    for _, key := range []string{"user:1", "user:2", "order:1"} {
+        c.Set(key, "old", 0)
+    }
+    if n, err := c.BumpGeneration("user:"); n != 1 || err != nil {
+        t.Fatalf("BumpGeneration = %d, %v; want 1", n, err)
+    }
+    c.Set("user:2", "new", 0)
+
+    tests := []struct {
+        key     string
+        want    string
+        wantErr error
+    }{
+        {"user:1", "", ErrExpired},
+        {"user:1", "", ErrNotFound}, // Removed when found
+        {"user:2", "new", nil},
+        {"order:1", "old", nil},
+    }
+    for _, tt := range tests {
+        if got, err := c.Get(tt.key); got != tt.want || err != tt.wantErr {
+            t.Errorf("Get(%q) = %q, %v; want %q, %v", tt.key, got, err, tt.want, tt.wantErr)
+        }
+    }
+    if c.Contains("user:1") || c.Stats().Expirations != 1 {
+        t.Fatal("the retired key was not removed as expired")
+    }
+}
+
+func TestGenerationCounts(t *testing.T) {
+    c := New()
+    c.Set("a", "1", 0)
+    c.Set("b", "1", 0)
+    bumps := []struct {
+        prefix string
+        want   uint64
+    }{
+        {"user:", 1},
+        {"user:", 2},
+        {"order:", 1},
+        {"", 1},
+    }
+    for _, b := range bumps {
+        if n, _ := c.BumpGeneration(b.prefix); n != b.want || c.Generation(b.prefix) != b.want {
+            t.Errorf("BumpGeneration(%q) = %d, Generation %d; want %d", b.prefix, n, c.Generation(b.prefix), b.want)
+        }
+    }
+    if c.Generation("never") != 0 {
+        t.Error("a prefix never bumped has a generation")
+    }
+
+    // The empty prefix retires every key, and snapshots leave them out
+    if c.Contains("a") || c.Contains("b") || len(c.State().Entries) != 0 || len(c.Export()) != 0 {
+        t.Fatal("keys written before the empty prefix was bumped are still live")
+    }
+    replica := New()
+    replica.Restore(c.State())
+    if replica.Generation("user:") != 2 {
+        t.Fatal("Restore lost the generations")
+    }
+}
@@ -0,0 +1,103 @@
+package lrucache
+
+import (
+    "container/list"
+    "encoding/json"
+)
+
+// HSet sets field of the hash at key to value, creating a hash that never
+// expires if key is missing, and reports whether the field is new
+func (c *LRUCache) HSet(key, field, value string) (bool, error) {
+    res := c.mutate(Mutation{Op: "hset", Key: key, Fields: map[string]string{field: value}})
+    return res.N > 0, res.Err
+}
+
+// HDel removes fields of the hash at key, returning how many it had; removing
+// the last field deletes the key
+func (c *LRUCache) HDel(key string, fields ...string) (int, error) {
+    res := c.mutate(Mutation{Op: "hdel", Key: key, Args: fields})
+    return int(res.N), res.Err
+}
+
+// HGet returns field of the hash at key, or ErrNotFound if the key or the
+// field is missing
+func (c *LRUCache) HGet(key, field string) (string, error) {
+    h, err := c.hash("hget", key)
+    if err != nil {
+        return "", err
+    }
+    value, found := h[field]
+    if !found {
+        return "", ErrNotFound
+    }
+    return value, nil
+}
+
+// HGetAll returns every field of the hash at key
+func (c *LRUCache) HGetAll(key string) (map[string]string, error) {
+    return c.hash("hgetall", key)
+}
+
+// hash decodes the live hash at key
+func (c *LRUCache) hash(op, key string) (map[string]string, error) {
+    if c.closed() {
+        return nil, ErrClosed
+    }
+    defer c.lock(op, key)()
+
+    value, err := c.lookupValue(key, KindHash)
+    if err != nil {
+        return nil, err
+    }
+    return decodeHash(value), nil
+}
+
+// applyHash makes an hset or hdel change; the caller holds the mutex
+func (c *LRUCache) applyHash(elem *list.Element, live bool, m Mutation) MutationResult {
+    prev, encoded, err := c.current(elem, live, KindHash)
+    if err != nil {
+        return MutationResult{Err: err}
+    }
+    h := map[string]string{}
+    if prev != nil {
+        h = decodeHash(encoded)
+    }
+    n := 0
+    switch m.Op {
+    case "hset":
+        for field, value := range m.Fields {
+            if _, found := h[field]; !found {
+                n++
+            }
+            h[field] = value
+        }
+    case "hdel":
+        for _, field := range m.Args {
+            if _, found := h[field]; found {
+                delete(h, field)
+                n++
+            }
+        }
+        if n == 0 {
+            return MutationResult{}
+        }
+    }
+    if err := c.update(m, prev, KindHash, encodeHash(h), len(h) == 0); err != nil {
+        return MutationResult{Err: err}
+    }
+    return MutationResult{N: int64(n), OK: true}
+}
+
+// encodeHash encodes a hash as a JSON object with its fields sorted, so that
+// equal hashes have equal encodings
+func encodeHash(h map[string]string) string {
+    data, _ := json.Marshal(h)
+    return string(data)
+}
+
+// decodeHash decodes a hash, treating a malformed encoding as empty
+func decodeHash(value string) map[string]string {
+    h := map[string]string{}
+    json.Unmarshal([]byte(value), &h)
+    return h
+}
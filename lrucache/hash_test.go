@@ -0,0 +1,96 @@
+package lrucache
+
+import (
+    "maps"
+    "testing"
+    "time"
+)
+
+func TestHash(t *testing.T) {
+    c := New()
+    tests := []struct {
+        name    string
+        do      func() (int, error)
+        wantN   int
+        wantErr error
+        want    map[string]string // nil for a missing key
+    }{
+        {"new field", func() (int, error) { return boolInt(c.HSet("h", "a", "1")) }, 1, nil, map[string]string{"a": "1"}},
+        {"overwrite", func() (int, error) { return boolInt(c.HSet("h", "a", "2")) }, 0, nil, map[string]string{"a": "2"}},
+        {"second field", func() (int, error) { return boolInt(c.HSet("h", "b", "3")) }, 1, nil, map[string]string{"a": "2", "b": "3"}},
+        {"delete missing field", func() (int, error) { return c.HDel("h", "z") }, 0, nil, map[string]string{"a": "2", "b": "3"}},
+        {"delete fields", func() (int, error) { return c.HDel("h", "a", "z") }, 1, nil, map[string]string{"b": "3"}},
+        {"delete the last field", func() (int, error) { return c.HDel("h", "b") }, 1, nil, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            n, err := tt.do()
+            if n != tt.wantN || err != tt.wantErr {
+                t.Fatalf("got %d, %v; want %d, %v", n, err, tt.wantN, tt.wantErr)
+            }
+            got, err := c.HGetAll("h")
+            if tt.want == nil {
+                if err != ErrNotFound {
+                    t.Fatalf("HGetAll = %v, %v; want ErrNotFound", got, err)
+                }
+                return
+            }
+            if !maps.Equal(got, tt.want) {
+                t.Fatalf("HGetAll = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+// boolInt converts the reply of HSet for a table of int replies
+func boolInt(b bool, err error) (int, error) {
+    if b {
+        return 1, err
+    }
+    return 0, err
+}
+
+func TestHGet(t *testing.T) {
+    c := New()
+    c.HSet("h", "f", "v")
+    c.Set("s", "v", 0)
+    tests := []struct {
+        key, field string
+        want       string
+        wantErr    error
+    }{
+        {"h", "f", "v", nil},
+        {"h", "g", "", ErrNotFound},
+        {"missing", "f", "", ErrNotFound},
+        {"s", "f", "", ErrWrongType},
+    }
+    for _, tt := range tests {
+        if got, err := c.HGet(tt.key, tt.field); got != tt.want || err != tt.wantErr {
+            t.Errorf("HGet(%q, %q) = %q, %v; want %q, %v", tt.key, tt.field, got, err, tt.want, tt.wantErr)
+        }
+    }
+    if _, err := c.HSet("s", "f", "v"); err != ErrWrongType {
+        t.Errorf("HSet on a string = %v, want ErrWrongType", err)
+    }
+}
+
+func TestHashEncoding(t *testing.T) {
+    // Equal hashes have equal encodings however they were built
+    a := encodeHash(map[string]string{"x": "1", "y": "2"})
+    b := encodeHash(map[string]string{"y": "2", "x": "1"})
+    if a != b || a != `{"x":"1","y":"2"}` {
+        t.Fatalf("encodings %s and %s", a, b)
+    }
+    if h := decodeHash("not json"); len(h) != 0 {
+        t.Fatalf("decoded a malformed hash as %v", h)
+    }
+
+    // Changing a field keeps the expiration
+    c := New()
+    c.HSet("h", "f", "1")
+    c.Touch("h", time.Hour)
+    c.HSet("h", "f", "2")
+    if ttl, _ := c.TTL("h"); ttl < 59*time.Minute {
+        t.Fatalf("TTL after HSet = %v, want about an hour", ttl)
+    }
+}
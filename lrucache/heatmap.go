@@ -0,0 +1,106 @@
+package lrucache
+
+import (
+    "sort"
+    "strings"
+    "sync"
+)
+
+// heatmapOther collects reads once the heatmap tracks its maximum number of prefixes
+const heatmapOther = "(other)"
+
+// Heatmap counts reads per key prefix, where a prefix is up to depth leading
+// ":"-separated segments of the key, never including its last segment
+type Heatmap struct {
+    depth       int
+    maxPrefixes int
+    mutex       sync.Mutex
+    prefixes    map[string]*prefixCount
+}
+
+type prefixCount struct {
+    hits, misses uint64
+}
+
+// HeatmapEntry is the read count of one prefix
+type HeatmapEntry struct {
+    Prefix   string  `json:"prefix"`
+    Hits     uint64  `json:"hits"`
+    Misses   uint64  `json:"misses"`
+    HitRatio float64 `json:"hit_ratio"`
+}
+
+// NewHeatmap tracks at most maxPrefixes distinct prefixes of depth segments
+func NewHeatmap(depth, maxPrefixes int) *Heatmap {
+    return &Heatmap{depth: depth, maxPrefixes: maxPrefixes, prefixes: make(map[string]*prefixCount)}
+}
+
+// Depth is the number of key segments a prefix has at most
+func (h *Heatmap) Depth() int {
+    return h.depth
+}
+
+// prefix returns the part of key the heatmap aggregates under; keys without
+// a separator have the empty prefix
+func (h *Heatmap) prefix(key string) string {
+    end := 0
+    for i := 0; i < h.depth; i++ {
+        from := end
+        if i > 0 {
+            from++ // Skip the separator ending the previous segment
+        }
+        next := strings.IndexByte(key[from:], ':')
+        if next < 0 {
+            break
+        }
+        end = from + next
+    }
+    return key[:end]
+}
+
+// Record counts a read of key
+func (h *Heatmap) Record(key string, hit bool) {
+    prefix := h.prefix(key)
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    count, ok := h.prefixes[prefix]
+    if !ok {
+        if len(h.prefixes) >= h.maxPrefixes {
+            prefix = heatmapOther
+        }
+        if count, ok = h.prefixes[prefix]; !ok {
+            count = &prefixCount{}
+            h.prefixes[prefix] = count
+        }
+    }
+    if hit {
+        count.hits++
+    } else {
+        count.misses++
+    }
+}
+
+// Snapshot returns the prefixes ordered by number of reads, busiest first
+func (h *Heatmap) Snapshot() []HeatmapEntry {
+    h.mutex.Lock()
+    entries := make([]HeatmapEntry, 0, len(h.prefixes))
+    for prefix, count := range h.prefixes {
+        entries = append(entries, HeatmapEntry{
+            Prefix:   prefix,
+            Hits:     count.hits,
+            Misses:   count.misses,
+            HitRatio: float64(count.hits) / float64(count.hits+count.misses),
+        })
+    }
+    h.mutex.Unlock()
+
+    sort.Slice(entries, func(i, j int) bool {
+        a, b := entries[i].Hits+entries[i].Misses, entries[j].Hits+entries[j].Misses
+        if a != b {
+            return a > b
+        }
+        return entries[i].Prefix < entries[j].Prefix
+    })
+    return entries
+}
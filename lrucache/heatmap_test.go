@@ -0,0 +1,71 @@
+package lrucache
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestHeatmapPrefix(t *testing.T) {
+    tests := []struct {
+        depth int
+        key   string
+        want  string
+    }{
+        {1, "user:1", "user"},
+        {1, "user:1:name", "user"},
+        {2, "user:1:name", "user:1"},
+        {2, "user:1", "user"},
+        {3, "a:b:c:d", "a:b:c"},
+        {1, "plain", ""},
+        {1, ":leading", ""},
+        {0, "user:1", ""},
+    }
+    for _, tt := range tests {
+        if got := NewHeatmap(tt.depth, 10).prefix(tt.key); got != tt.want {
+            t.Errorf("prefix(%q) at depth %d = %q, want %q", tt.key, tt.depth, got, tt.want)
+        }
+    }
+}
+
+func TestHeatmapSnapshot(t *testing.T) {
+    h := NewHeatmap(1, 2)
+    reads := []struct {
+        key string
+        hit bool
+    }{
+        {"user:1", true},
+        {"user:2", true},
+        {"user:3", false},
+        {"order:1", false},
+        {"session:1", true}, // Past the maximum prefixes
+        {"cart:1", true},
+    }
+    for _, r := range reads {
+        h.Record(r.key, r.hit)
+    }
+    want := []HeatmapEntry{
+        {Prefix: "user", Hits: 2, Misses: 1, HitRatio: 2.0 / 3},
+        {Prefix: heatmapOther, Hits: 2, HitRatio: 1},
+        {Prefix: "order", Misses: 1, HitRatio: 0},
+    }
+    if got := h.Snapshot(); !reflect.DeepEqual(got, want) {
+        t.Fatalf("Snapshot = %+v\nwant %+v", got, want)
+    }
+}
+
+func TestCacheRecordsReads(t *testing.T) {
+    h := NewHeatmap(1, 10)
+    c := New(WithHeatmap(h))
+    c.Set("user:1", "v", 0)
+    c.Get("user:1")
+    c.Get("user:2")
+    c.Contains("user:1") // Not a read
+    if got := h.Snapshot(); len(got) != 1 || got[0].Hits != 1 || got[0].Misses != 1 {
+        t.Fatalf("Snapshot = %+v, want a hit and a miss of user", got)
+    }
+    c.SetHeatmap(nil)
+    c.Get("user:1")
+    if c.Heatmap() != nil || h.Snapshot()[0].Hits != 1 {
+        t.Fatal("reads are still counted after SetHeatmap(nil)")
+    }
+}
@@ -0,0 +1,151 @@
+package lrucache
+
+import (
+    "container/list"
+    "hash/fnv"
+    "math"
+    "math/bits"
+)
+
+// hllPrecision is the number of hash bits selecting a register; 2^14
+// registers give a standard error of 0.81%
+const hllPrecision = 14
+
+// hllRegisters is the number of registers, and bytes, of a HyperLogLog
+const hllRegisters = 1 << hllPrecision
+
+// PFAdd adds elements to the HyperLogLog at key, creating one that never
+// expires if key is missing, and reports whether its estimate may have
+// changed, which is always so for a new key
+func (c *LRUCache) PFAdd(key string, elements ...string) (bool, error) {
+    res := c.mutate(Mutation{Op: "pfadd", Key: key, Args: elements})
+    return res.OK, res.Err
+}
+
+// PFCount estimates the number of distinct elements added to the
+// HyperLogLogs at keys, counting each element once however many hold it;
+// missing keys are empty
+func (c *LRUCache) PFCount(keys ...string) (int64, error) {
+    if c.closed() {
+        return 0, ErrClosed
+    }
+    defer c.lock("pfcount", "")()
+
+    registers := make([]byte, hllRegisters)
+    for _, key := range keys {
+        value, err := c.lookupValue(key, KindHLL)
+        switch err {
+        case nil:
+            mergeHLL(registers, decodeHLL(value))
+        case ErrNotFound, ErrExpired:
+        default:
+            return 0, err
+        }
+    }
+    return estimateHLL(registers), nil
+}
+
+// PFMerge stores the union of the HyperLogLogs at dest and sources in dest,
+// keeping the expiration of dest or never expiring if it is missing
+func (c *LRUCache) PFMerge(dest string, sources ...string) error {
+    return c.mutate(Mutation{Op: "pfmerge", Key: dest, Args: sources}).Err
+}
+
+// applyHLL makes a pfadd or pfmerge change; the caller holds the mutex
+func (c *LRUCache) applyHLL(elem *list.Element, live bool, m Mutation) MutationResult {
+    prev, encoded, err := c.current(elem, live, KindHLL)
+    if err != nil {
+        return MutationResult{Err: err}
+    }
+    registers := make([]byte, hllRegisters)
+    if prev != nil {
+        registers = decodeHLL(encoded)
+    }
+    changed := prev == nil
+    switch m.Op {
+    case "pfadd":
+        for _, element := range m.Args {
+            i, rank := hllHash(element)
+            if rank > registers[i] {
+                registers[i], changed = rank, true
+            }
+        }
+    case "pfmerge":
+        for _, key := range m.Args {
+            src, found := c.cache[key]
+            if !found || key == m.Key {
+                continue
+            }
+            item, value, err := c.current(src, !src.Value.(*CacheItem).expired(m.Time), KindHLL)
+            if err != nil {
+                return MutationResult{Err: err}
+            }
+            if item != nil {
+                changed = mergeHLL(registers, decodeHLL(value)) || changed
+            }
+        }
+    }
+    if !changed {
+        return MutationResult{}
+    }
+    if err := c.update(m, prev, KindHLL, string(registers), false); err != nil {
+        return MutationResult{Err: err}
+    }
+    return MutationResult{OK: true}
+}
+
+// hllHash returns the register element falls in and the rank to record in
+// it: the position of the first set bit of the rest of its hash
+func hllHash(element string) (int, byte) {
+    h := fnv.New64a()
+    h.Write([]byte(element))
+    // FNV alone leaves the high bits poorly mixed, so finish it like MurmurHash3
+    x := h.Sum64()
+    x ^= x >> 33
+    x *= 0xff51afd7ed558ccd
+    x ^= x >> 33
+    x *= 0xc4ceb9fe1a85ec53
+    x ^= x >> 33
+    i := int(x >> (64 - hllPrecision))
+    rank := bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1
+    return i, byte(rank)
+}
+
+// mergeHLL raises every register of dst to at least that of src, reporting
+// whether any changed
+func mergeHLL(dst, src []byte) bool {
+    changed := false
+    for i, rank := range src {
+        if rank > dst[i] {
+            dst[i], changed = rank, true
+        }
+    }
+    return changed
+}
+
+// estimateHLL returns the cardinality registers describe, using linear
+// counting while many registers are still empty
+func estimateHLL(registers []byte) int64 {
+    m := float64(len(registers))
+    sum, zeros := 0.0, 0
+    for _, rank := range registers {
+        sum += math.Ldexp(1, -int(rank))
+        if rank == 0 {
+            zeros++
+        }
+    }
+    estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+    if estimate <= 2.5*m && zeros > 0 {
+        estimate = m * math.Log(m/float64(zeros))
+    }
+    return int64(estimate + 0.5)
+}
+
+// decodeHLL returns the registers of a HyperLogLog; a value of the wrong
+// length, which only an import could store, reads as empty
+func decodeHLL(value string) []byte {
+    if len(value) != hllRegisters {
+        return make([]byte, hllRegisters)
+    }
+    return []byte(value)
+}
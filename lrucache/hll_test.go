@@ -0,0 +1,98 @@
+package lrucache
+
+import (
+    "math"
+    "strconv"
+    "testing"
+)
+
+func TestPFCountEstimate(t *testing.T) {
+    tests := []struct {
+        name string
+        n    int
+    }{
+        {"linear counting", 1000},
+        {"ten thousand", 10000},
+        {"hundred thousand", 100000},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c := New()
+            elements := make([]string, tt.n)
+            for i := range elements {
+                elements[i] = "e" + strconv.Itoa(i)
+            }
+            c.PFAdd("h", elements...)
+            c.PFAdd("h", elements[:tt.n/2]...) // Duplicates count once
+            got, err := c.PFCount("h")
+            if err != nil {
+                t.Fatal(err)
+            }
+            if e := math.Abs(float64(got)-float64(tt.n)) / float64(tt.n); e > 0.02 {
+                t.Fatalf("PFCount = %d, %.1f%% off %d", got, 100*e, tt.n)
+            }
+        })
+    }
+}
+
+func TestPFAdd(t *testing.T) {
+    c := New()
+    tests := []struct {
+        name     string
+        elements []string
+        want     bool
+    }{
+        {"new key", nil, true},
+        {"new element", []string{"a"}, true},
+        {"same element", []string{"a"}, false},
+        {"nothing", nil, false},
+    }
+    for _, tt := range tests {
+        if got, err := c.PFAdd("h", tt.elements...); got != tt.want || err != nil {
+            t.Errorf("%s: PFAdd = %v, %v; want %v", tt.name, got, err, tt.want)
+        }
+    }
+}
+
+func TestPFMergeAndUnion(t *testing.T) {
+    c := New()
+    c.PFAdd("a", "1", "2", "3")
+    c.PFAdd("b", "3", "4")
+    c.Set("s", "v", 0)
+    tests := []struct {
+        name    string
+        keys    []string
+        want    int64
+        wantErr error
+    }{
+        {"one", []string{"a"}, 3, nil},
+        {"union", []string{"a", "b"}, 4, nil},
+        {"missing is empty", []string{"a", "missing"}, 3, nil},
+        {"wrong type", []string{"a", "s"}, 0, ErrWrongType},
+    }
+    for _, tt := range tests {
+        if got, err := c.PFCount(tt.keys...); got != tt.want || err != tt.wantErr {
+            t.Errorf("%s: PFCount = %d, %v; want %d, %v", tt.name, got, err, tt.want, tt.wantErr)
+        }
+    }
+
+    if err := c.PFMerge("u", "a", "b", "missing"); err != nil {
+        t.Fatal(err)
+    }
+    if got, _ := c.PFCount("u"); got != 4 {
+        t.Fatalf("PFCount of the merge = %d, want 4", got)
+    }
+    if err := c.PFMerge("u", "s"); err != ErrWrongType {
+        t.Fatalf("PFMerge from a string = %v, want ErrWrongType", err)
+    }
+    if _, err := c.PFAdd("s", "x"); err != ErrWrongType {
+        t.Fatalf("PFAdd to a string = %v, want ErrWrongType", err)
+    }
+}
+
+func TestDecodeHLL(t *testing.T) {
+    // Only an import stores a value of another length
+    if r := decodeHLL("short"); len(r) != hllRegisters || estimateHLL(r) != 0 {
+        t.Fatal("a malformed HyperLogLog does not read as empty")
+    }
+}
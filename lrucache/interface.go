@@ -0,0 +1,178 @@
+package lrucache
+
+import (
+    "context"
+    "io"
+    "time"
+)
+
+// Cache is what every cache provides and every front-end needs: reading and
+// writing string values, with the memcached-style flags, store modes and CAS
+// values the protocols expose. A sharded variant or a test fake need only
+// implement Cache; the optional interfaces below add the rest of what an
+// LRUCache does, and callers find them with a type assertion.
+type Cache interface {
+    Get(key string) (string, error)
+    GetItem(key string) (Item, error)
+    Contains(key string) bool
+    Set(key string, value string, expiration time.Duration) error
+    Store(key string, value string, flags uint32, expiration time.Duration, mode StoreMode) (uint64, error)
+    CompareAndSwap(key string, value string, flags uint32, expiration time.Duration, cas uint64) (uint64, error)
+    Touch(key string, expiration time.Duration) bool
+    TTL(key string) (time.Duration, bool)
+    Incr(key string, delta int64) (int64, error)
+    Delete(key string) bool
+    DeleteCAS(key string, cas uint64) error
+    Flush() int
+    Len() int
+}
+
+// ContextCache reads and writes under a context, and streams values
+type ContextCache interface {
+    GetCtx(ctx context.Context, key string) (string, error)
+    GetItemCtx(ctx context.Context, key string) (Item, error)
+    SetCtx(ctx context.Context, key string, value string, expiration time.Duration) error
+    SetFrom(ctx context.Context, key string, r io.Reader, expiration time.Duration) (int64, error)
+    GetTo(ctx context.Context, key string, w io.Writer) (int64, error)
+}
+
+// Refresher handles misses and refreshes with leases and soft TTLs
+type Refresher interface {
+    GetLease(key string, ttl time.Duration) (string, uint64, error)
+    SetLease(key, value string, expiration time.Duration, token uint64) error
+    SetSoft(key string, value string, softTTL, expiration time.Duration) error
+}
+
+// Hashes stores hashes of fields
+type Hashes interface {
+    HSet(key, field, value string) (bool, error)
+    HGet(key, field string) (string, error)
+    HDel(key string, fields ...string) (int, error)
+    HGetAll(key string) (map[string]string, error)
+}
+
+// Lists stores lists
+type Lists interface {
+    LPush(key string, values ...string) (int, error)
+    RPush(key string, values ...string) (int, error)
+    LPop(key string) (string, error)
+    LRange(key string, start, stop int) ([]string, error)
+}
+
+// Sets stores sets of members
+type Sets interface {
+    SAdd(key string, members ...string) (int, error)
+    SRem(key string, members ...string) (int, error)
+    SIsMember(key, member string) (bool, error)
+    SMembers(key string) ([]string, error)
+    SCard(key string) (int, error)
+}
+
+// SortedSets stores members ordered by score
+type SortedSets interface {
+    ZAdd(key string, members ...ZMember) (int, error)
+    ZRange(key string, start, stop int) ([]ZMember, error)
+    ZRank(key, member string) (int, error)
+    ZIncrBy(key, member string, delta float64) (float64, error)
+}
+
+// Bitmaps stores bitmaps
+type Bitmaps interface {
+    SetBit(key string, offset int64, bit int) (int, error)
+    GetBit(key string, offset int64) (int, error)
+    BitCount(key string) (int, error)
+}
+
+// HyperLogLogs counts distinct elements approximately
+type HyperLogLogs interface {
+    PFAdd(key string, elements ...string) (bool, error)
+    PFCount(keys ...string) (int64, error)
+    PFMerge(dest string, sources ...string) error
+}
+
+// Transactional changes several keys at once
+type Transactional interface {
+    Txn(ops ...TxnOp) ([]TxnResult, error)
+    Watch(keys ...string) []TxnOp
+    Purge(patterns ...string) (int, error)
+    BumpGeneration(prefix string) (uint64, error)
+    Generation(prefix string) uint64
+}
+
+// Replicated copies entries between nodes and clusters, resolving
+// conflicting writes by the time they were made
+type Replicated interface {
+    StoreIfNewer(e Entry, at time.Time) bool
+    DeleteIfNewer(key string, at time.Time) bool
+    FlushOlder(at time.Time) int
+    Export() []Entry
+    Import(entries []Entry) int
+}
+
+// Administered reports on the cache and changes its capacity
+type Administered interface {
+    Capacity() int
+    Resize(capacity int) (int, error)
+    Stats() CacheStats
+    Heatmap() *Heatmap
+    DebugEntries(limit, maxValue int) []DebugEntry
+    DebugEntriesMatching(pattern string, limit, maxValue int) []DebugEntry
+}
+
+// GetCtx is c.GetCtx if c is a ContextCache, and otherwise Get once ctx is
+// checked
+func GetCtx(ctx context.Context, c Cache, key string) (string, error) {
+    if cc, ok := c.(ContextCache); ok {
+        return cc.GetCtx(ctx, key)
+    }
+    if err := ctx.Err(); err != nil {
+        return "", err
+    }
+    return c.Get(key)
+}
+
+// GetItemCtx is c.GetItemCtx if c is a ContextCache, and otherwise GetItem
+// once ctx is checked
+func GetItemCtx(ctx context.Context, c Cache, key string) (Item, error) {
+    if cc, ok := c.(ContextCache); ok {
+        return cc.GetItemCtx(ctx, key)
+    }
+    if err := ctx.Err(); err != nil {
+        return Item{}, err
+    }
+    return c.GetItem(key)
+}
+
+// SetCtx is c.SetCtx if c is a ContextCache, and otherwise Set once ctx is
+// checked
+func SetCtx(ctx context.Context, c Cache, key string, value string, expiration time.Duration) error {
+    if cc, ok := c.(ContextCache); ok {
+        return cc.SetCtx(ctx, key, value, expiration)
+    }
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    return c.Set(key, value, expiration)
+}
+
+// SetFrom is c.SetFrom if c is a ContextCache, and otherwise SetCtx of
+// everything read from r
+func SetFrom(ctx context.Context, c Cache, key string, r io.Reader, expiration time.Duration) (int64, error) {
+    if cc, ok := c.(ContextCache); ok {
+        return cc.SetFrom(ctx, key, r, expiration)
+    }
+    value, err := io.ReadAll(r)
+    if err != nil {
+        return int64(len(value)), err
+    }
+    return int64(len(value)), SetCtx(ctx, c, key, string(value), expiration)
+}
+
+// StatsOf is c.Stats if c is Administered, and otherwise only counts its
+// entries
+func StatsOf(c Cache) CacheStats {
+    if a, ok := c.(Administered); ok {
+        return a.Stats()
+    }
+    return CacheStats{Entries: c.Len()}
+}
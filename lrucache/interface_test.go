@@ -0,0 +1,422 @@
+package lrucache_test
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "maps"
+    "slices"
+    "strings"
+    "testing"
+    "time"
+
+    "lru-cache/lrucache"
+    "lru-cache/lrucache/cachetest"
+)
+
+// fullCache is a Cache with every optional interface, as both
+// implementations are
+type fullCache interface {
+    lrucache.Cache
+    lrucache.ContextCache
+    lrucache.Refresher
+    lrucache.Hashes
+    lrucache.Lists
+    lrucache.Sets
+    lrucache.SortedSets
+    lrucache.Bitmaps
+    lrucache.HyperLogLogs
+    lrucache.Transactional
+    lrucache.Replicated
+    lrucache.Administered
+}
+
+// implementations are the caches the conformance tests run against; advance
+// moves the cache's clock forward
+var implementations = []struct {
+    name string
+    new  func(t *testing.T, capacity int) (c fullCache, advance func(time.Duration))
+}{
+    {"LRUCache", func(t *testing.T, capacity int) (fullCache, func(time.Duration)) {
+        c := lrucache.New(lrucache.WithCapacity(capacity))
+        t.Cleanup(c.Close)
+        return c, time.Sleep
+    }},
+    {"Fake", func(t *testing.T, capacity int) (fullCache, func(time.Duration)) {
+        f := cachetest.New(capacity)
+        return f, f.Clock.Advance
+    }},
+}
+
+// Expirations short enough to pass once the clock advances by tick, and long
+// enough not to
+const (
+    short = 50 * time.Millisecond
+    tick  = 100 * time.Millisecond
+    long  = time.Hour
+)
+
+var conformance = []struct {
+    name string
+    run  func(t *testing.T, c fullCache, advance func(time.Duration))
+}{
+    {"get set delete", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        if _, err := c.Get("k"); !errors.Is(err, lrucache.ErrNotFound) {
+            t.Fatalf("Get of a missing key = %v, want ErrNotFound", err)
+        }
+        c.Set("k", "v", 0)
+        if got, err := c.Get("k"); got != "v" || err != nil || !c.Contains("k") {
+            t.Fatalf("Get = %q, %v; want v", got, err)
+        }
+        if !c.Delete("k") || c.Delete("k") || c.Contains("k") {
+            t.Fatal("Delete did not remove the key exactly once")
+        }
+    }},
+    {"store modes and cas", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        if _, err := c.Store("k", "v", 0, 0, lrucache.StoreIfPresent); !errors.Is(err, lrucache.ErrNotStored) {
+            t.Fatalf("Store of a missing key if present = %v, want ErrNotStored", err)
+        }
+        cas, err := c.Store("k", "v", 7, 0, lrucache.StoreIfAbsent)
+        if err != nil || cas == 0 {
+            t.Fatalf("Store if absent = %d, %v", cas, err)
+        }
+        if _, err := c.Store("k", "w", 0, 0, lrucache.StoreIfAbsent); !errors.Is(err, lrucache.ErrNotStored) {
+            t.Fatalf("Store of a present key if absent = %v, want ErrNotStored", err)
+        }
+        if item, _ := c.GetItem("k"); item.Flags != 7 || item.CAS != cas {
+            t.Fatalf("GetItem = %+v, want flags 7 and CAS %d", item, cas)
+        }
+        writes := []struct {
+            name    string
+            call    func() error
+            wantErr error
+        }{
+            {"cas mismatch", func() error { _, err := c.CompareAndSwap("k", "w", 0, 0, cas+1); return err }, lrucache.ErrCASMismatch},
+            {"cas missing", func() error { _, err := c.CompareAndSwap("missing", "w", 0, 0, cas); return err }, lrucache.ErrCASNotFound},
+            {"cas", func() error { _, err := c.CompareAndSwap("k", "w", 0, 0, cas); return err }, nil},
+            {"delete stale cas", func() error { return c.DeleteCAS("k", cas) }, lrucache.ErrCASMismatch},
+            {"delete any cas", func() error { return c.DeleteCAS("k", 0) }, nil},
+            {"delete missing", func() error { return c.DeleteCAS("k", 0) }, lrucache.ErrCASNotFound},
+        }
+        for _, w := range writes {
+            if err := w.call(); !errors.Is(err, w.wantErr) {
+                t.Errorf("%s = %v, want %v", w.name, err, w.wantErr)
+            }
+        }
+    }},
+    {"incr", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        if n, err := c.Incr("n", 2); n != 2 || err != nil {
+            t.Fatalf("Incr of a missing key = %d, %v; want 2", n, err)
+        }
+        if n, err := c.Incr("n", -5); n != -3 || err != nil {
+            t.Fatalf("Incr = %d, %v; want -3", n, err)
+        }
+        c.Set("s", "abc", 0)
+        if _, err := c.Incr("s", 1); err == nil {
+            t.Fatal("Incr of a non-integer succeeded")
+        }
+    }},
+    {"expiration", func(t *testing.T, c fullCache, advance func(time.Duration)) {
+        c.Set("short", "v", short)
+        c.Set("long", "v", long)
+        c.Set("touched", "v", short)
+        before, _ := c.GetItem("touched")
+        if !c.Touch("touched", long) || c.Touch("missing", long) {
+            t.Fatal("Touch did not report which keys it found")
+        }
+        if after, _ := c.GetItem("touched"); after.CAS == before.CAS {
+            t.Fatal("Touch left the CAS value unchanged")
+        }
+        if ttl, found := c.TTL("touched"); !found || ttl <= short || ttl > long {
+            t.Fatalf("TTL = %v, %v; want up to an hour", ttl, found)
+        }
+        advance(tick)
+        reads := []struct {
+            key     string
+            wantErr error
+        }{
+            {"short", lrucache.ErrExpired},
+            {"short", lrucache.ErrNotFound},
+            {"long", nil},
+            {"touched", nil},
+        }
+        for _, r := range reads {
+            if _, err := c.Get(r.key); !errors.Is(err, r.wantErr) {
+                t.Errorf("Get(%q) = %v, want %v", r.key, err, r.wantErr)
+            }
+        }
+        if item, _ := c.GetItem("long"); item.Expiration.IsZero() {
+            t.Error("the item of a key with a TTL has no expiration")
+        }
+    }},
+    {"evicts least recently used", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        c.Resize(2)
+        c.Set("a", "1", 0)
+        c.Set("b", "2", 0)
+        c.Get("a")
+        c.Set("c", "3", 0)
+        if c.Contains("b") || !c.Contains("a") || !c.Contains("c") || c.Stats().Evictions != 1 {
+            t.Fatal("the least recently used key was not the one evicted")
+        }
+        if n, err := c.Resize(1); n != 1 || err != nil || c.Capacity() != 1 || c.Contains("a") {
+            t.Fatalf("Resize(1) = %d, %v; want c kept", n, err)
+        }
+        if _, err := c.Resize(0); !errors.Is(err, lrucache.ErrInvalidCapacity) {
+            t.Fatalf("Resize(0) = %v, want ErrInvalidCapacity", err)
+        }
+    }},
+    {"stats", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        c.Set("a", "1", 0)
+        c.Get("a")
+        c.Get("a")
+        c.Get("missing")
+        if s := c.Stats(); s.Hits != 2 || s.Misses != 1 || s.Entries != 1 || s.Capacity != 4 {
+            t.Fatalf("Stats = %+v", s)
+        }
+    }},
+    {"context", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        ctx, cancel := context.WithCancel(context.Background())
+        cancel()
+        if err := c.SetCtx(ctx, "k", "v", 0); !errors.Is(err, context.Canceled) || c.Contains("k") {
+            t.Fatalf("SetCtx with a canceled context = %v", err)
+        }
+        c.Set("k", "v", 0)
+        if _, err := c.GetCtx(ctx, "k"); !errors.Is(err, context.Canceled) {
+            t.Fatalf("GetCtx with a canceled context = %v", err)
+        }
+    }},
+    {"streaming", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        value := strings.Repeat("stream", 100)
+        if n, err := c.SetFrom(context.Background(), "k", strings.NewReader(value), 0); n != int64(len(value)) || err != nil {
+            t.Fatalf("SetFrom = %d, %v", n, err)
+        }
+        var b bytes.Buffer
+        if n, err := c.GetTo(context.Background(), "k", &b); n != int64(len(value)) || err != nil || b.String() != value {
+            t.Fatalf("GetTo = %d, %v", n, err)
+        }
+    }},
+    {"flush and purge", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        c.Set("user:1", "v", 0)
+        c.Set("order:1", "v", 0)
+        if n, err := c.Purge("user:*"); n != 1 || err != nil || c.Contains("user:1") {
+            t.Fatalf("Purge = %d, %v", n, err)
+        }
+        if n := c.Flush(); n != 1 || c.Len() != 0 {
+            t.Fatalf("Flush = %d leaving %d", n, c.Len())
+        }
+    }},
+    {"last write wins", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        at := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+        writes := []struct {
+            name string
+            do   func() bool
+            want bool
+        }{
+            {"store", func() bool { return c.StoreIfNewer(lrucache.Entry{Key: "k", Value: "1"}, at) }, true},
+            {"older store", func() bool { return c.StoreIfNewer(lrucache.Entry{Key: "k", Value: "0"}, at.Add(-time.Second)) }, false},
+            {"newer store", func() bool { return c.StoreIfNewer(lrucache.Entry{Key: "k", Value: "2"}, at.Add(time.Second)) }, true},
+            {"older delete", func() bool { return c.DeleteIfNewer("k", at) }, false},
+            {"newer delete", func() bool { return c.DeleteIfNewer("k", at.Add(2*time.Second)) }, true},
+        }
+        for _, w := range writes {
+            if got := w.do(); got != w.want {
+                t.Errorf("%s = %v, want %v", w.name, got, w.want)
+            }
+        }
+    }},
+    {"transactions", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        c.Set("n", "1", 0)
+        ops := c.Watch("n")
+        results, err := c.Txn(append(ops, lrucache.TxnOp{Op: "incr", Key: "n", Delta: 2}, lrucache.TxnOp{Op: "get", Key: "n"})...)
+        if err != nil || results[1].N != 3 || results[2].Value != "3" {
+            t.Fatalf("Txn = %+v, %v", results, err)
+        }
+        // The watched key has changed since
+        _, err = c.Txn(append(ops, lrucache.TxnOp{Op: "set", Key: "other", Value: "v"})...)
+        var txnErr *lrucache.TxnError
+        if !errors.As(err, &txnErr) || txnErr.Index != 0 || !errors.Is(err, lrucache.ErrCASMismatch) || c.Contains("other") {
+            t.Fatalf("Txn after a watched write = %v, want ErrCASMismatch from operation 0", err)
+        }
+        c.Set("s", "abc", 0)
+        if _, err := c.Txn(lrucache.TxnOp{Op: "set", Key: "x", Value: "v"}, lrucache.TxnOp{Op: "incr", Key: "s", Delta: 1}); !errors.As(err, &txnErr) || txnErr.Index != 1 || c.Contains("x") {
+            t.Fatalf("Txn of an incr of a non-integer = %v", err)
+        }
+    }},
+    {"leases", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        _, token, err := c.GetLease("k", long)
+        if token == 0 || !errors.Is(err, lrucache.ErrNotFound) {
+            t.Fatalf("GetLease = %d, %v; want a token", token, err)
+        }
+        if _, _, err := c.GetLease("k", long); !errors.Is(err, lrucache.ErrLeaseHeld) {
+            t.Fatalf("second GetLease = %v, want ErrLeaseHeld", err)
+        }
+        if err := c.SetLease("k", "v", 0, token); err != nil {
+            t.Fatal(err)
+        }
+        if err := c.SetLease("k", "v", 0, token); !errors.Is(err, lrucache.ErrLeaseInvalid) {
+            t.Fatalf("SetLease with a used token = %v", err)
+        }
+        _, token, _ = c.GetLease("revoked", long)
+        c.Set("revoked", "other", 0)
+        if err := c.SetLease("revoked", "v", 0, token); !errors.Is(err, lrucache.ErrLeaseInvalid) {
+            t.Fatalf("SetLease after a write = %v", err)
+        }
+    }},
+    {"export and import", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        c.Set("a", "1", 0)
+        c.HSet("h", "f", "v")
+        entries := c.Export()
+        c.Flush()
+        if n := c.Import(entries); n != 2 {
+            t.Fatalf("Import = %d, want 2", n)
+        }
+        if got, _ := c.HGet("h", "f"); got != "v" || !c.Contains("a") {
+            t.Fatal("Import did not restore the exported entries")
+        }
+    }},
+    {"generations", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        c.Set("user:1", "v", 0)
+        c.Set("order:1", "v", 0)
+        if n, err := c.BumpGeneration("user:"); n != 1 || err != nil || c.Generation("user:") != 1 {
+            t.Fatalf("BumpGeneration = %d, %v", n, err)
+        }
+        if c.Contains("user:1") || !c.Contains("order:1") {
+            t.Fatal("BumpGeneration did not retire only its prefix")
+        }
+    }},
+    {"hashes", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        c.HSet("h", "a", "1")
+        c.HSet("h", "b", "2")
+        if n, _ := c.HDel("h", "a", "z"); n != 1 {
+            t.Fatalf("HDel = %d, want 1", n)
+        }
+        if got, _ := c.HGetAll("h"); !maps.Equal(got, map[string]string{"b": "2"}) {
+            t.Fatalf("HGetAll = %v", got)
+        }
+    }},
+    {"lists", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        c.RPush("l", "b", "c")
+        c.LPush("l", "a")
+        if got, _ := c.LRange("l", 0, -1); !slices.Equal(got, []string{"a", "b", "c"}) {
+            t.Fatalf("LRange = %q", got)
+        }
+        if got, _ := c.LPop("l"); got != "a" {
+            t.Fatalf("LPop = %q", got)
+        }
+        if got, _ := c.LRange("l", -1, -1); !slices.Equal(got, []string{"c"}) {
+            t.Fatalf("LRange(-1, -1) = %q", got)
+        }
+    }},
+    {"sets", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        if n, _ := c.SAdd("s", "b", "a", "b"); n != 2 {
+            t.Fatalf("SAdd = %d, want 2", n)
+        }
+        member, _ := c.SIsMember("s", "a")
+        card, _ := c.SCard("s")
+        if got, _ := c.SMembers("s"); !member || card != 2 || !slices.Equal(got, []string{"a", "b"}) {
+            t.Fatalf("SMembers = %q", got)
+        }
+        c.SRem("s", "a", "b")
+        if c.Contains("s") {
+            t.Fatal("an empty set is still stored")
+        }
+    }},
+    {"sorted sets", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        c.ZAdd("z", lrucache.ZMember{Member: "b", Score: 1}, lrucache.ZMember{Member: "a", Score: 1})
+        if score, _ := c.ZIncrBy("z", "c", 0.5); score != 0.5 {
+            t.Fatalf("ZIncrBy = %v", score)
+        }
+        want := []lrucache.ZMember{{Member: "c", Score: 0.5}, {Member: "a", Score: 1}, {Member: "b", Score: 1}}
+        if got, _ := c.ZRange("z", 0, -1); !slices.Equal(got, want) {
+            t.Fatalf("ZRange = %v", got)
+        }
+        if rank, err := c.ZRank("z", "b"); rank != 2 || err != nil {
+            t.Fatalf("ZRank = %d, %v", rank, err)
+        }
+    }},
+    {"bitmaps", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        c.SetBit("b", 3, 1)
+        c.SetBit("b", 20, 1)
+        if old, _ := c.SetBit("b", 3, 0); old != 1 {
+            t.Fatalf("SetBit returned %d, want the old bit 1", old)
+        }
+        bit, _ := c.GetBit("b", 20)
+        if n, _ := c.BitCount("b"); bit != 1 || n != 1 {
+            t.Fatalf("GetBit = %d and BitCount = %d", bit, n)
+        }
+    }},
+    {"hyperloglogs", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        c.PFAdd("a", "1", "2")
+        c.PFAdd("b", "2", "3")
+        if changed, _ := c.PFAdd("a", "1"); changed {
+            t.Fatal("PFAdd of a counted element reported a change")
+        }
+        if n, _ := c.PFCount("a", "b"); n != 3 {
+            t.Fatalf("PFCount = %d, want 3", n)
+        }
+        c.PFMerge("u", "a", "b")
+        if n, _ := c.PFCount("u"); n != 3 {
+            t.Fatalf("PFCount of the merge = %d, want 3", n)
+        }
+    }},
+    {"wrong type", func(t *testing.T, c fullCache, _ func(time.Duration)) {
+        c.Set("s", "v", 0)
+        c.RPush("l", "v")
+        calls := []struct {
+            name string
+            call func() error
+        }{
+            {"HGet", func() error { _, err := c.HGet("s", "f"); return err }},
+            {"LPush", func() error { _, err := c.LPush("s", "v"); return err }},
+            {"SAdd", func() error { _, err := c.SAdd("s", "v"); return err }},
+            {"ZRange", func() error { _, err := c.ZRange("s", 0, -1); return err }},
+            {"PFCount", func() error { _, err := c.PFCount("s"); return err }},
+            {"Get", func() error { _, err := c.Get("l"); return err }},
+        }
+        for _, call := range calls {
+            if err := call.call(); !errors.Is(err, lrucache.ErrWrongType) {
+                t.Errorf("%s = %v, want ErrWrongType", call.name, err)
+            }
+        }
+    }},
+}
+
+func TestConformance(t *testing.T) {
+    for _, impl := range implementations {
+        t.Run(impl.name, func(t *testing.T) {
+            for _, tt := range conformance {
+                t.Run(tt.name, func(t *testing.T) {
+                    t.Parallel()
+                    c, advance := impl.new(t, 4)
+                    tt.run(t, c, advance)
+                })
+            }
+        })
+    }
+}
+
+// coreOnly hides every method of a cache beyond Cache
+type coreOnly struct {
+    lrucache.Cache
+}
+
+func TestHelpersOnCoreOnlyCache(t *testing.T) {
+    c := coreOnly{cachetest.New(4)}
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if err := lrucache.SetCtx(ctx, c, "k", "v", 0); !errors.Is(err, context.Canceled) || c.Contains("k") {
+        t.Fatalf("SetCtx with a canceled context = %v", err)
+    }
+    if n, err := lrucache.SetFrom(context.Background(), c, "k", strings.NewReader("value"), 0); n != 5 || err != nil {
+        t.Fatalf("SetFrom = %d, %v", n, err)
+    }
+    if got, err := lrucache.GetCtx(context.Background(), c, "k"); got != "value" || err != nil {
+        t.Fatalf("GetCtx = %q, %v", got, err)
+    }
+    if _, err := lrucache.GetItemCtx(ctx, c, "k"); !errors.Is(err, context.Canceled) {
+        t.Fatalf("GetItemCtx with a canceled context = %v", err)
+    }
+    if s := lrucache.StatsOf(c); s.Entries != 1 || s.Hits != 0 {
+        t.Fatalf("StatsOf = %+v, want only the entry count", s)
+    }
+}
@@ -0,0 +1,108 @@
+package lrucache
+
+import (
+    "container/list"
+    "time"
+)
+
+// Kind is the kind of value an entry holds; values other than strings are
+// kept in an encoded form, so they are exported, replicated and evicted like
+// any other entry
+type Kind string
+
+const (
+    // KindString is a plain value, as written by Set
+    KindString Kind = ""
+    // KindHash maps fields to values, encoded as a JSON object
+    KindHash Kind = "hash"
+    // KindList is a sequence of values, encoded as a JSON array
+    KindList Kind = "list"
+    // KindSet is a set of distinct values, encoded as a sorted JSON array
+    KindSet Kind = "set"
+    // KindSortedSet is a set of values ordered by score, encoded as a JSON
+    // array of ZMember objects in that order
+    KindSortedSet Kind = "zset"
+    // KindHLL is a HyperLogLog estimating how many distinct elements were
+    // added, encoded as one byte per register
+    KindHLL Kind = "hll"
+)
+
+// lookupKind is lookup for an entry that must hold a value of kind k; the
+// caller holds the mutex
+func (c *LRUCache) lookupKind(key string, k Kind) (*CacheItem, error) {
+    item, err := c.lookup(key)
+    if err == nil && item.kind != k {
+        return nil, ErrWrongType
+    }
+    return item, err
+}
+
+// lookupValue is lookupKind returning the entry's value as written; the
+// caller holds the mutex
+func (c *LRUCache) lookupValue(key string, k Kind) (string, error) {
+    item, err := c.lookupKind(key, k)
+    if err != nil {
+        return "", err
+    }
+    return c.readValue(item)
+}
+
+// current returns the entry elem and its value as written if it is live and
+// holds a value of kind k, or nil if there is no live entry; the caller holds
+// the mutex
+func (c *LRUCache) current(elem *list.Element, live bool, k Kind) (*CacheItem, string, error) {
+    if !live {
+        return nil, "", nil
+    }
+    item := elem.Value.(*CacheItem)
+    if item.kind != k {
+        return nil, "", ErrWrongType
+    }
+    value, err := c.valueOf(item)
+    if err != nil {
+        return nil, "", err
+    }
+    return item, value, nil
+}
+
+// update writes the new encoding of a value of kind k at m.Key, keeping the
+// flags and expiration of prev, the live entry if any, so that changing part
+// of a value leaves its TTL alone; an empty value deletes the key. The caller
+// holds the mutex.
+func (c *LRUCache) update(m Mutation, prev *CacheItem, k Kind, value string, empty bool) error {
+    if empty {
+        if elem, found := c.cache[m.Key]; found {
+            c.remove(elem, EventDelete)
+        }
+        return nil
+    }
+    if c.maxValue > 0 && len(value) > c.maxValue {
+        return ErrTooLarge
+    }
+    if err := c.checkQuota(m.Key, value); err != nil {
+        return err
+    }
+    var flags uint32
+    var expiration time.Time
+    if prev != nil {
+        flags, expiration = prev.flags, prev.expiration
+    }
+    c.set(m.Key, value, k, flags, expiration, m.Time)
+    return nil
+}
+
+// bounds converts an inclusive range of indexes, which count back from the
+// end if negative, into a slice range of a sequence of n values
+func bounds(start, stop, n int) (int, int) {
+    if start < 0 {
+        start += n
+    }
+    if stop < 0 {
+        stop += n
+    }
+    start, stop = max(start, 0), min(stop+1, n)
+    if start >= stop {
+        return 0, 0
+    }
+    return start, stop
+}
@@ -0,0 +1,99 @@
+package lrucache
+
+import (
+    "testing"
+    "time"
+)
+
+func TestBounds(t *testing.T) {
+    tests := []struct {
+        start, stop, n int
+        from, to       int
+    }{
+        {0, -1, 5, 0, 5},
+        {1, 2, 5, 1, 3},
+        {-2, -1, 5, 3, 5},
+        {0, 10, 5, 0, 5},
+        {-10, 1, 5, 0, 2},
+        {3, 1, 5, 0, 0},
+        {5, 6, 5, 0, 0},
+        {0, -1, 0, 0, 0},
+    }
+    for _, tt := range tests {
+        if from, to := bounds(tt.start, tt.stop, tt.n); from != tt.from || to != tt.to {
+            t.Errorf("bounds(%d, %d, %d) = %d, %d; want %d, %d", tt.start, tt.stop, tt.n, from, to, tt.from, tt.to)
+        }
+    }
+}
+
+func TestWrongKind(t *testing.T) {
+    c := New()
+    c.Set("string", "v", 0)
+    c.HSet("hash", "f", "v")
+    c.RPush("list", "v")
+    c.SAdd("set", "v")
+    c.ZAdd("zset", ZMember{"v", 1})
+    c.PFAdd("hll", "v")
+
+    reads := []struct {
+        kind Kind
+        read func(key string) error
+    }{
+        {KindHash, func(key string) error { _, err := c.HGetAll(key); return err }},
+        {KindList, func(key string) error { _, err := c.LRange(key, 0, -1); return err }},
+        {KindSet, func(key string) error { _, err := c.SMembers(key); return err }},
+        {KindSortedSet, func(key string) error { _, err := c.ZRange(key, 0, -1); return err }},
+        {KindHLL, func(key string) error { _, err := c.PFCount(key); return err }},
+    }
+    keys := map[Kind]string{KindString: "string", KindHash: "hash", KindList: "list", KindSet: "set", KindSortedSet: "zset", KindHLL: "hll"}
+    for _, r := range reads {
+        for k, key := range keys {
+            want := ErrWrongType
+            if k == r.kind {
+                want = nil
+            }
+            if err := r.read(key); err != want {
+                t.Errorf("reading %q as %q = %v, want %v", key, r.kind, err, want)
+            }
+        }
+    }
+    if _, err := c.Incr("list", 1); err != ErrWrongType {
+        t.Errorf("Incr of a list = %v, want ErrWrongType", err)
+    }
+}
+
+func TestUpdateKeepsTTL(t *testing.T) {
+    c := New()
+    updates := []struct {
+        key    string
+        create func()
+        change func()
+    }{
+        {"hash", func() { c.HSet("hash", "a", "1") }, func() { c.HSet("hash", "b", "2") }},
+        {"list", func() { c.RPush("list", "a") }, func() { c.LPush("list", "b") }},
+        {"set", func() { c.SAdd("set", "a") }, func() { c.SAdd("set", "b") }},
+        {"zset", func() { c.ZAdd("zset", ZMember{"a", 1}) }, func() { c.ZIncrBy("zset", "a", 1) }},
+        {"hll", func() { c.PFAdd("hll", "a") }, func() { c.PFAdd("hll", "b") }},
+    }
+    for _, u := range updates {
+        u.create()
+        if ttl, found := c.TTL(u.key); !found || ttl != 0 {
+            t.Errorf("%s: created with TTL %v, want none", u.key, ttl)
+        }
+        c.Touch(u.key, time.Hour)
+        u.change()
+        if ttl, _ := c.TTL(u.key); ttl < 59*time.Minute {
+            t.Errorf("%s: TTL after a change = %v, want about an hour", u.key, ttl)
+        }
+    }
+}
+
+func TestMaxValueBytesAppliesToEveryKind(t *testing.T) {
+    c := New(WithMaxValueBytes(8))
+    if _, err := c.RPush("list", "a", "b", "c"); err != ErrTooLarge {
+        t.Fatalf("RPush of an encoding over the limit = %v, want ErrTooLarge", err)
+    }
+    if c.Contains("list") {
+        t.Fatal("a list over the limit was stored")
+    }
+}
@@ -0,0 +1,88 @@
+package lrucache
+
+import (
+    "errors"
+    "maps"
+    "time"
+)
+
+var (
+    // ErrLeaseHeld is returned by GetLease on a miss while another caller
+    // holds the key's lease; wait briefly and read again rather than
+    // recomputing the value
+    ErrLeaseHeld = errors.New("another caller holds the lease")
+    // ErrLeaseInvalid is returned by SetLease with a token that expired, was
+    // used or was revoked by a write or delete of the key
+    ErrLeaseInvalid = errors.New("lease token is not valid")
+)
+
+// Lease is the outstanding right to fill a missing key, as GetLease grants it
+type Lease struct {
+    Token     uint64    `json:"token"`
+    ExpiresAt time.Time `json:"expires_at"`
+}
+
+// minLeaseSweep is the number of leases kept before expired ones are swept
+const minLeaseSweep = 1024
+
+// GetLease is Get that, on a miss, also hands the caller a lease token valid
+// for ttl along with ErrNotFound: only SetLease with the token then fills the
+// key, and until then other callers get ErrLeaseHeld and should retry shortly
+// instead of recomputing the value. A write or delete of the key revokes the
+// lease, so a value computed before it is never stored. A hit returns token 0.
+func (c *LRUCache) GetLease(key string, ttl time.Duration) (string, uint64, error) {
+    value, err := c.Get(key)
+    if !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrExpired) {
+        return value, 0, err
+    }
+    res := c.mutate(Mutation{Op: "lease", Key: key, ExpiresAt: time.Now().Add(ttl)})
+    switch {
+    case res.Err != nil:
+        return "", 0, res.Err
+    case !res.OK:
+        // Written since the miss
+        value, err := c.Get(key)
+        return value, 0, err
+    }
+    return "", res.CAS, ErrNotFound
+}
+
+// SetLease is Set for the holder of the lease token GetLease returned,
+// failing with ErrLeaseInvalid once the lease expired, was used or was
+// revoked
+func (c *LRUCache) SetLease(key, value string, expiration time.Duration, token uint64) error {
+    return c.mutate(Mutation{Op: "lease_store", Key: key, Value: value, ExpiresAt: c.expiresAt(expiration), CAS: token}).Err
+}
+
+// applyLease makes a lease or lease_store change; the caller holds the mutex
+func (c *LRUCache) applyLease(live bool, m Mutation) MutationResult {
+    l, held := c.leases[m.Key]
+    held = held && m.Time.Before(l.ExpiresAt)
+    switch m.Op {
+    case "lease":
+        if live {
+            return MutationResult{}
+        }
+        if held {
+            return MutationResult{Err: ErrLeaseHeld}
+        }
+        if c.leases == nil {
+            c.leases = make(map[string]Lease)
+        }
+        if len(c.leases) >= c.leaseSweep {
+            maps.DeleteFunc(c.leases, func(_ string, l Lease) bool { return !m.Time.Before(l.ExpiresAt) })
+            c.leaseSweep = max(minLeaseSweep, 2*len(c.leases))
+        }
+        c.casSeq++
+        c.leases[m.Key] = Lease{Token: c.casSeq, ExpiresAt: m.ExpiresAt}
+        return MutationResult{CAS: c.casSeq, OK: true}
+    default:
+        if !held || l.Token != m.CAS {
+            return MutationResult{Err: ErrLeaseInvalid}
+        }
+        if err := c.checkQuota(m.Key, m.Value); err != nil {
+            return MutationResult{Err: err}
+        }
+        return MutationResult{CAS: c.set(m.Key, m.Value, KindString, 0, m.ExpiresAt, m.Time), OK: true}
+    }
+}
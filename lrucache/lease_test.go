@@ -0,0 +1,67 @@
+package lrucache
+
+import (
+    "testing"
+    "time"
+)
+
+func TestLease(t *testing.T) {
+    c := New()
+    value, token, err := c.GetLease("k", time.Minute)
+    if value != "" || token == 0 || err != ErrNotFound {
+        t.Fatalf("GetLease of a missing key = %q, %d, %v; want a token", value, token, err)
+    }
+    if _, other, err := c.GetLease("k", time.Minute); other != 0 || err != ErrLeaseHeld {
+        t.Fatalf("second GetLease = %d, %v; want ErrLeaseHeld", other, err)
+    }
+    tests := []struct {
+        name    string
+        token   uint64
+        wantErr error
+    }{
+        {"wrong token", token + 1, ErrLeaseInvalid},
+        {"holder", token, nil},
+        {"token used", token, ErrLeaseInvalid},
+    }
+    for _, tt := range tests {
+        if err := c.SetLease("k", "v", 0, tt.token); err != tt.wantErr {
+            t.Errorf("%s: SetLease = %v, want %v", tt.name, err, tt.wantErr)
+        }
+    }
+    if value, token, err := c.GetLease("k", time.Minute); value != "v" || token != 0 || err != nil {
+        t.Fatalf("GetLease of a hit = %q, %d, %v; want v and token 0", value, token, err)
+    }
+}
+
+func TestLeaseRevoked(t *testing.T) {
+    tests := []struct {
+        name   string
+        revoke func(c *LRUCache)
+    }{
+        {"set", func(c *LRUCache) { c.Set("k", "other", 0) }},
+        {"delete", func(c *LRUCache) { c.Delete("k") }},
+        {"flush", func(c *LRUCache) { c.Flush() }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c := New()
+            _, token, _ := c.GetLease("k", time.Minute)
+            tt.revoke(c)
+            if err := c.SetLease("k", "stale", 0, token); err != ErrLeaseInvalid {
+                t.Fatalf("SetLease after a %s = %v, want ErrLeaseInvalid", tt.name, err)
+            }
+        })
+    }
+}
+
+func TestLeaseExpires(t *testing.T) {
+    c := New()
+    _, token, _ := c.GetLease("k", time.Millisecond)
+    time.Sleep(5 * time.Millisecond)
+    if err := c.SetLease("k", "v", 0, token); err != ErrLeaseInvalid {
+        t.Fatalf("SetLease with an expired token = %v, want ErrLeaseInvalid", err)
+    }
+    if _, next, err := c.GetLease("k", time.Minute); next == 0 || err != ErrNotFound {
+        t.Fatalf("GetLease after the lease expired = %d, %v; want a new token", next, err)
+    }
+}
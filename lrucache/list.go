@@ -0,0 +1,93 @@
+package lrucache
+
+import (
+    "container/list"
+    "encoding/json"
+    "slices"
+)
+
+// LPush inserts values at the head of the list at key, one after another so
+// that the last ends up first, creating a list that never expires if key is
+// missing; it returns the new length
+func (c *LRUCache) LPush(key string, values ...string) (int, error) {
+    res := c.mutate(Mutation{Op: "lpush", Key: key, Args: values})
+    return int(res.N), res.Err
+}
+
+// RPush appends values to the tail of the list at key, creating a list that
+// never expires if key is missing; it returns the new length
+func (c *LRUCache) RPush(key string, values ...string) (int, error) {
+    res := c.mutate(Mutation{Op: "rpush", Key: key, Args: values})
+    return int(res.N), res.Err
+}
+
+// LPop removes and returns the head of the list at key, or fails with
+// ErrNotFound if there is none; popping the last element deletes the key
+func (c *LRUCache) LPop(key string) (string, error) {
+    res := c.mutate(Mutation{Op: "lpop", Key: key})
+    if res.Err == nil && !res.OK {
+        return "", ErrNotFound
+    }
+    return res.Value, res.Err
+}
+
+// LRange returns the elements of the list at key from start to stop,
+// inclusive; negative indexes count back from the tail, so 0, -1 is the
+// whole list
+func (c *LRUCache) LRange(key string, start, stop int) ([]string, error) {
+    if c.closed() {
+        return nil, ErrClosed
+    }
+    defer c.lock("lrange", key)()
+
+    value, err := c.lookupValue(key, KindList)
+    if err != nil {
+        return nil, err
+    }
+    values := decodeList(value)
+    from, to := bounds(start, stop, len(values))
+    return values[from:to], nil
+}
+
+// applyList makes an lpush, rpush or lpop change; the caller holds the mutex
+func (c *LRUCache) applyList(elem *list.Element, live bool, m Mutation) MutationResult {
+    prev, encoded, err := c.current(elem, live, KindList)
+    if err != nil {
+        return MutationResult{Err: err}
+    }
+    var values []string
+    if prev != nil {
+        values = decodeList(encoded)
+    }
+    var popped string
+    switch m.Op {
+    case "lpush":
+        pushed := slices.Clone(m.Args)
+        slices.Reverse(pushed)
+        values = append(pushed, values...)
+    case "rpush":
+        values = append(values, m.Args...)
+    case "lpop":
+        if len(values) == 0 {
+            return MutationResult{}
+        }
+        popped, values = values[0], values[1:]
+    }
+    if err := c.update(m, prev, KindList, encodeList(values), len(values) == 0); err != nil {
+        return MutationResult{Err: err}
+    }
+    return MutationResult{N: int64(len(values)), Value: popped, OK: true}
+}
+
+// encodeList encodes a list as a JSON array
+func encodeList(values []string) string {
+    data, _ := json.Marshal(values)
+    return string(data)
+}
+
+// decodeList decodes a list, treating a malformed encoding as empty
+func decodeList(value string) []string {
+    var values []string
+    json.Unmarshal([]byte(value), &values)
+    return values
+}
@@ -0,0 +1,70 @@
+package lrucache
+
+import (
+    "slices"
+    "testing"
+)
+
+func TestListPush(t *testing.T) {
+    c := New()
+    tests := []struct {
+        name string
+        push func() (int, error)
+        want []string
+    }{
+        {"rpush creates", func() (int, error) { return c.RPush("l", "a", "b") }, []string{"a", "b"}},
+        {"lpush reverses", func() (int, error) { return c.LPush("l", "c", "d") }, []string{"d", "c", "a", "b"}},
+        {"rpush appends", func() (int, error) { return c.RPush("l", "e") }, []string{"d", "c", "a", "b", "e"}},
+    }
+    for _, tt := range tests {
+        n, err := tt.push()
+        got, _ := c.LRange("l", 0, -1)
+        if n != len(tt.want) || err != nil || !slices.Equal(got, tt.want) {
+            t.Errorf("%s: length %d, %v and %q; want %q", tt.name, n, err, got, tt.want)
+        }
+    }
+}
+
+func TestLRange(t *testing.T) {
+    c := New()
+    c.RPush("l", "a", "b", "c", "d")
+    tests := []struct {
+        start, stop int
+        want        []string
+    }{
+        {0, -1, []string{"a", "b", "c", "d"}},
+        {1, 2, []string{"b", "c"}},
+        {-2, -1, []string{"c", "d"}},
+        {2, 100, []string{"c", "d"}},
+        {3, 1, []string{}},
+    }
+    for _, tt := range tests {
+        if got, err := c.LRange("l", tt.start, tt.stop); !slices.Equal(got, tt.want) || err != nil {
+            t.Errorf("LRange(%d, %d) = %q, %v; want %q", tt.start, tt.stop, got, err, tt.want)
+        }
+    }
+    if _, err := c.LRange("missing", 0, -1); err != ErrNotFound {
+        t.Errorf("LRange of a missing key = %v, want ErrNotFound", err)
+    }
+}
+
+func TestLPop(t *testing.T) {
+    c := New()
+    c.RPush("l", "a", "b")
+    pops := []struct {
+        want    string
+        wantErr error
+    }{
+        {"a", nil},
+        {"b", nil}, // Deletes the key
+        {"", ErrNotFound},
+    }
+    for i, p := range pops {
+        if got, err := c.LPop("l"); got != p.want || err != p.wantErr {
+            t.Errorf("pop %d = %q, %v; want %q, %v", i, got, err, p.want, p.wantErr)
+        }
+    }
+    if c.Contains("l") {
+        t.Fatal("an empty list is still stored")
+    }
+}
@@ -0,0 +1,124 @@
+package lrucache
+
+import (
+    "container/list"
+    "fmt"
+    "time"
+)
+
+// defaultCapacity is the capacity of a cache created without WithCapacity
+const defaultCapacity = 1024
+
+// EvictionPolicy decides which entry makes room for a new one when the cache is full
+type EvictionPolicy int
+
+const (
+    // EvictLRU evicts the least recently read or written entry
+    EvictLRU EvictionPolicy = iota
+    // EvictFIFO evicts the oldest entry; reads and overwrites keep its place
+    EvictFIFO
+)
+
+// ParseEvictionPolicy returns the policy named s: "lru" or "fifo"
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+    switch s {
+    case "lru":
+        return EvictLRU, nil
+    case "fifo":
+        return EvictFIFO, nil
+    }
+    return 0, fmt.Errorf("unknown eviction policy %q", s)
+}
+
+// String returns the name ParseEvictionPolicy takes
+func (p EvictionPolicy) String() string {
+    if p == EvictFIFO {
+        return "fifo"
+    }
+    return "lru"
+}
+
+// Option configures a cache created with New
+type Option func(*LRUCache)
+
+// WithCapacity sets the maximum number of entries
+func WithCapacity(capacity int) Option {
+    return func(c *LRUCache) { c.capacity = capacity }
+}
+
+// WithDefaultTTL sets the expiration of writes that pass zero
+func WithDefaultTTL(ttl time.Duration) Option {
+    return func(c *LRUCache) { c.defaultTTL.Store(int64(ttl)) }
+}
+
+// WithMaxTTL caps the expiration of writes at ttl, so that even those asking
+// never to expire do after it
+func WithMaxTTL(ttl time.Duration) Option {
+    return func(c *LRUCache) { c.maxTTL = ttl }
+}
+
+// WithJanitorInterval removes expired entries every interval, rather than
+// only once they are read or evicted; Close stops it
+func WithJanitorInterval(interval time.Duration) Option {
+    return func(c *LRUCache) { c.janitorInterval = interval }
+}
+
+// WithMaxValueBytes makes writes of longer values fail with ErrTooLarge;
+// zero allows any length
+func WithMaxValueBytes(n int) Option {
+    return func(c *LRUCache) { c.maxValue = n }
+}
+
+// WithCompression stores values of at least threshold bytes compressed with
+// codec, decompressing them on every read; values compression does not make
+// shorter are stored as they are
+func WithCompression(codec Compression, threshold int) Option {
+    return func(c *LRUCache) { c.compression, c.compressThreshold = codec, threshold }
+}
+
+// WithEvictionPolicy selects which entry is evicted when the cache is full
+func WithEvictionPolicy(p EvictionPolicy) Option {
+    return func(c *LRUCache) { c.policy = p }
+}
+
+// WithOnEvict calls fn for every entry evicted to make room; like OnEvent it
+// runs with the cache locked
+func WithOnEvict(fn func(key, value string)) Option {
+    return func(c *LRUCache) { c.onEvict = fn }
+}
+
+// WithOnEvent is like calling OnEvent before the cache is used
+func WithOnEvent(fn func(Event)) Option {
+    return func(c *LRUCache) { c.onEvent = fn }
+}
+
+// WithHeatmap is like calling SetHeatmap before the cache is used
+func WithHeatmap(h *Heatmap) Option {
+    return func(c *LRUCache) { c.heatmap = h }
+}
+
+// WithSlowThreshold is like calling SetSlowThreshold before the cache is used
+func WithSlowThreshold(d time.Duration) Option {
+    return func(c *LRUCache) { c.slow.Store(int64(d)) }
+}
+
+// New creates a cache holding up to 1024 entries unless opts say otherwise
+func New(opts ...Option) *LRUCache {
+    c := &LRUCache{
+        capacity: defaultCapacity,
+        cache:    make(map[string]*list.Element),
+        list:     list.New(),
+        done:     make(chan struct{}),
+    }
+    for _, opt := range opts {
+        opt(c)
+    }
+    if c.doorkeeperWindow > 0 {
+        c.doorkeeper = newDoorkeeper(c.capacity, c.doorkeeperWindow)
+    }
+    if c.janitorInterval > 0 {
+        c.janitorStop = make(chan struct{})
+        go c.janitor(c.janitorInterval, c.janitorStop)
+    }
+    return c
+}
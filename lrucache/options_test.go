@@ -0,0 +1,111 @@
+package lrucache
+
+import (
+    "testing"
+    "time"
+)
+
+func TestParseEvictionPolicy(t *testing.T) {
+    tests := []struct {
+        s       string
+        want    EvictionPolicy
+        wantErr bool
+    }{
+        {"lru", EvictLRU, false},
+        {"fifo", EvictFIFO, false},
+        {"lfu", 0, true},
+        {"", 0, true},
+    }
+    for _, tt := range tests {
+        got, err := ParseEvictionPolicy(tt.s)
+        if got != tt.want || (err != nil) != tt.wantErr {
+            t.Errorf("ParseEvictionPolicy(%q) = %v, %v", tt.s, got, err)
+        }
+        if err == nil && got.String() != tt.s {
+            t.Errorf("%v.String() = %q, want %q", got, got.String(), tt.s)
+        }
+    }
+}
+
+func TestEvictionPolicy(t *testing.T) {
+    tests := []struct {
+        policy  EvictionPolicy
+        evicted string
+    }{
+        {EvictLRU, "b"},
+        {EvictFIFO, "a"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.policy.String(), func(t *testing.T) {
+            var evicted []string
+            c := New(WithCapacity(2), WithEvictionPolicy(tt.policy), WithOnEvict(func(key, value string) {
+                evicted = append(evicted, key+"="+value)
+            }))
+            c.Set("a", "1", 0)
+            c.Set("b", "2", 0)
+            c.Get("a")
+            c.Set("a", "3", 0) // FIFO keeps the place of an overwritten key
+            c.Set("c", "4", 0)
+            if len(evicted) != 1 || evicted[0][:1] != tt.evicted || c.Contains(tt.evicted) {
+                t.Fatalf("evicted %q, want %q", evicted, tt.evicted)
+            }
+        })
+    }
+}
+
+func TestExpirationOptions(t *testing.T) {
+    tests := []struct {
+        name string
+        opts []Option
+        ttl  time.Duration // Passed to Set
+        want time.Duration // Zero for never
+    }{
+        {"none", nil, 0, 0},
+        {"default applies to zero", []Option{WithDefaultTTL(time.Hour)}, 0, time.Hour},
+        {"default leaves others alone", []Option{WithDefaultTTL(time.Hour)}, time.Minute, time.Minute},
+        {"max caps never", []Option{WithMaxTTL(time.Minute)}, 0, time.Minute},
+        {"max caps longer", []Option{WithMaxTTL(time.Minute)}, time.Hour, time.Minute},
+        {"max leaves shorter alone", []Option{WithMaxTTL(time.Hour)}, time.Minute, time.Minute},
+        {"max caps the default", []Option{WithDefaultTTL(time.Hour), WithMaxTTL(time.Minute)}, 0, time.Minute},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c := New(tt.opts...)
+            c.Set("k", "v", tt.ttl)
+            got, found := c.TTL("k")
+            if !found || got > tt.want || got < tt.want-time.Second {
+                t.Fatalf("TTL = %v, %v; want %v", got, found, tt.want)
+            }
+        })
+    }
+}
+
+func TestNewOptions(t *testing.T) {
+    if c := New(); c.capacity != defaultCapacity || c.policy != EvictLRU || c.maxValue != 0 {
+        t.Fatalf("New() has capacity %d, policy %v and value limit %d", c.capacity, c.policy, c.maxValue)
+    }
+    c := New(WithMaxValueBytes(3))
+    tests := []struct {
+        value   string
+        wantErr error
+    }{
+        {"abc", nil},
+        {"abcd", ErrTooLarge},
+    }
+    for _, tt := range tests {
+        if err := c.Set("k", tt.value, 0); err != tt.wantErr {
+            t.Errorf("Set(%q) = %v, want %v", tt.value, err, tt.wantErr)
+        }
+    }
+
+    j := New(WithJanitorInterval(time.Millisecond))
+    defer j.Close()
+    j.Set("k", "v", time.Millisecond)
+    deadline := time.Now().Add(time.Second)
+    for j.Len() > 0 && time.Now().Before(deadline) {
+        time.Sleep(time.Millisecond)
+    }
+    if j.Len() != 0 {
+        t.Fatal("the janitor did not remove an expired entry")
+    }
+}
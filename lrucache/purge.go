@@ -0,0 +1,103 @@
+package lrucache
+
+import (
+    "strings"
+    "unicode/utf8"
+)
+
+// Purge deletes every key matching one of patterns and returns how many it
+// deleted. In a pattern * matches any run of characters, ":" and "/"
+// included, ? matches one character and \ makes the next one literal.
+func (c *LRUCache) Purge(patterns ...string) (int, error) {
+    res := c.mutate(Mutation{Op: "purge", Args: patterns})
+    return int(res.N), res.Err
+}
+
+// applyPurge makes a purge change; the caller holds the mutex
+func (c *LRUCache) applyPurge(m Mutation) MutationResult {
+    // Comparing the literal start of a pattern first skips most keys cheaply
+    prefixes := make([]string, len(m.Args))
+    for i, pattern := range m.Args {
+        prefixes[i] = globPrefix(pattern)
+    }
+    n := 0
+    for elem := c.list.Front(); elem != nil; {
+        next := elem.Next()
+        key := elem.Value.(*CacheItem).key
+        for i, pattern := range m.Args {
+            if strings.HasPrefix(key, prefixes[i]) && MatchGlob(pattern, key) {
+                c.remove(elem, EventDelete)
+                n++
+                break
+            }
+        }
+        elem = next
+    }
+    return MutationResult{N: int64(n), OK: true}
+}
+
+// globPrefix returns the characters every key pattern matches starts with
+func globPrefix(pattern string) string {
+    var b strings.Builder
+    for i := 0; i < len(pattern); i++ {
+        switch pattern[i] {
+        case '*', '?':
+            return b.String()
+        case '\\':
+            if i+1 < len(pattern) {
+                i++
+            }
+        }
+        b.WriteByte(pattern[i])
+    }
+    return b.String()
+}
+
+// QuoteGlob returns the pattern matching only s
+func QuoteGlob(s string) string {
+    return globQuoter.Replace(s)
+}
+
+var globQuoter = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`)
+
+// MatchGlob reports whether key matches pattern, written as for Purge
+func MatchGlob(pattern, key string) bool {
+    // Backtrack to just after the last * on a mismatch, which keeps matching
+    // linear in practice
+    px, kx := 0, 0
+    starP, starK := -1, 0
+    for kx < len(key) {
+        if px < len(pattern) {
+            switch c := pattern[px]; c {
+            case '*':
+                starP, starK = px, kx
+                px++
+                continue
+            case '?':
+                _, size := utf8.DecodeRuneInString(key[kx:])
+                px, kx = px+1, kx+size
+                continue
+            case '\\':
+                if px+1 < len(pattern) {
+                    c = pattern[px+1]
+                    px++
+                }
+                fallthrough
+            default:
+                if c == key[kx] {
+                    px, kx = px+1, kx+1
+                    continue
+                }
+            }
+        }
+        if starP < 0 {
+            return false
+        }
+        starK++
+        px, kx = starP+1, starK
+    }
+    for px < len(pattern) && pattern[px] == '*' {
+        px++
+    }
+    return px == len(pattern)
+}
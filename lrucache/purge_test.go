@@ -0,0 +1,94 @@
+package lrucache
+
+import (
+    "slices"
+    "testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+    tests := []struct {
+        pattern, key string
+        want         bool
+    }{
+        {"user:*", "user:1", true},
+        {"user:*", "user:1:name", true},
+        {"user:*", "order:1", false},
+        {"*", "", true},
+        {"", "", true},
+        {"", "a", false},
+        {"user:?", "user:1", true},
+        {"user:?", "user:10", false},
+        {"?", "é", true},
+        {"*:name", "user:1:name", true},
+        {"a*b*c", "aXbYbZc", true},
+        {"a*b*c", "aXbYbZ", false},
+        {`a\*`, "a*", true},
+        {`a\*`, "ab", false},
+        {`a\?`, "ab", false},
+        {`a\\`, `a\`, true},
+        {`trailing\`, `trailing\`, true},
+    }
+    for _, tt := range tests {
+        if got := MatchGlob(tt.pattern, tt.key); got != tt.want {
+            t.Errorf("MatchGlob(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+        }
+    }
+}
+
+func TestGlobPrefix(t *testing.T) {
+    tests := []struct {
+        pattern, want string
+    }{
+        {"user:*", "user:"},
+        {"user:?:name", "user:"},
+        {"*", ""},
+        {"plain", "plain"},
+        {`a\*b*`, "a*b"},
+        {`a\`, `a\`},
+    }
+    for _, tt := range tests {
+        if got := globPrefix(tt.pattern); got != tt.want {
+            t.Errorf("globPrefix(%q) = %q, want %q", tt.pattern, got, tt.want)
+        }
+    }
+}
+
+func TestQuoteGlob(t *testing.T) {
+    for _, s := range []string{"plain", "a*b", "what?", `back\slash`, `*?\`} {
+        q := QuoteGlob(s)
+        if !MatchGlob(q, s) || MatchGlob(q, s+"x") || (s != "plain" && MatchGlob(q, "plain")) {
+            t.Errorf("QuoteGlob(%q) = %q matches more than %q", s, q, s)
+        }
+    }
+}
+
+func TestPurge(t *testing.T) {
+    tests := []struct {
+        name     string
+        patterns []string
+        wantN    int
+        left     []string
+    }{
+        {"prefix", []string{"user:*"}, 2, []string{"order:1", "uses"}},
+        {"several", []string{"user:1", "order:?"}, 2, []string{"user:22", "uses"}},
+        {"none", []string{"cart:*"}, 0, []string{"order:1", "user:1", "user:22", "uses"}},
+        {"no patterns", nil, 0, []string{"order:1", "user:1", "user:22", "uses"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c := New()
+            for _, key := range []string{"user:1", "user:22", "order:1", "uses"} {
+                c.Set(key, "v", 0)
+            }
+            n, err := c.Purge(tt.patterns...)
+            var left []string
+            for _, e := range c.Export() {
+                left = append(left, e.Key)
+            }
+            slices.Sort(left)
+            if n != tt.wantN || err != nil || !slices.Equal(left, tt.left) {
+                t.Fatalf("Purge = %d, %v leaving %q; want %d leaving %q", n, err, left, tt.wantN, tt.left)
+            }
+        })
+    }
+}
@@ -0,0 +1,44 @@
+package lrucache
+
+import "errors"
+
+// ErrQuotaExceeded is returned for a write that would take the cache past the
+// quota WithQuota sets
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// WithQuota makes writes that would add an entry past entries, or grow the
+// memory values use past bytes, fail with ErrQuotaExceeded instead of
+// evicting; zero leaves either unlimited. Unlike the capacity, a quota keeps
+// a tenant sharing a cache from pushing out what it already stored.
+func WithQuota(entries int, bytes int64) Option {
+    return func(c *LRUCache) { c.quotaEntries, c.quotaBytes = entries, bytes }
+}
+
+// checkQuota fails with ErrQuotaExceeded if writing value to key would
+// exceed the quota; the caller holds the mutex. Memory is counted as store
+// counts it, in the compressed and encrypted form the cache keeps.
+func (c *LRUCache) checkQuota(key, value string) error {
+    elem, found := c.cache[key]
+    exceeded := !found && c.quotaEntries > 0 && c.list.Len() >= c.quotaEntries
+    if !exceeded && c.quotaBytes > 0 {
+        grown := entrySize(key, "") + int64(c.storedSize(value))
+        if found {
+            grown -= entrySize(key, elem.Value.(*CacheItem).value)
+        }
+        exceeded = grown > 0 && c.stats.Bytes+grown > c.quotaBytes
+    }
+    if exceeded {
+        c.stats.QuotaRejections++
+        return ErrQuotaExceeded
+    }
+    return nil
+}
+
+// storedSize is the length store keeps value in once compressed and sealed
+func (c *LRUCache) storedSize(value string) int {
+    stored, _ := c.compress(value)
+    if c.aead == nil {
+        return len(stored)
+    }
+    return c.aead.NonceSize() + len(stored) + c.aead.Overhead()
+}
@@ -0,0 +1,87 @@
+package lrucache
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestEntriesQuota(t *testing.T) {
+    c := New(WithQuota(2, 0))
+    writes := []struct {
+        name    string
+        write   func() error
+        wantErr error
+    }{
+        {"first", func() error { return c.Set("a", "1", 0) }, nil},
+        {"second", func() error { return c.Set("b", "1", 0) }, nil},
+        {"past the quota", func() error { return c.Set("c", "1", 0) }, ErrQuotaExceeded},
+        {"overwrite", func() error { return c.Set("a", "2", 0) }, nil},
+        {"another kind", func() error { _, err := c.SAdd("c", "1"); return err }, ErrQuotaExceeded},
+        {"after a delete", func() error { c.Delete("b"); return c.Set("c", "1", 0) }, nil},
+    }
+    for _, w := range writes {
+        if err := w.write(); err != w.wantErr {
+            t.Errorf("%s: %v, want %v", w.name, err, w.wantErr)
+        }
+    }
+    if n := c.Stats().QuotaRejections; n != 2 {
+        t.Fatalf("QuotaRejections = %d, want 2", n)
+    }
+    if c.Stats().Evictions != 0 {
+        t.Fatal("a quota evicted instead of refusing")
+    }
+}
+
+func TestBytesQuota(t *testing.T) {
+    ten := strings.Repeat("x", 10)
+    c := New(WithQuota(0, entrySize("a", ten)))
+    writes := []struct {
+        name    string
+        key     string
+        value   string
+        wantErr error
+    }{
+        {"fits exactly", "a", ten, nil},
+        {"another key", "b", "", ErrQuotaExceeded},
+        {"grow", "a", ten + "x", ErrQuotaExceeded},
+        {"shrink", "a", "x", nil},
+    }
+    for _, w := range writes {
+        if err := c.Set(w.key, w.value, 0); err != w.wantErr {
+            t.Errorf("%s: Set = %v, want %v", w.name, err, w.wantErr)
+        }
+    }
+}
+
+func TestQuotaCountsStoredBytes(t *testing.T) {
+    value := strings.Repeat("abc", 1000)
+    tests := []struct {
+        name   string
+        opts   []Option
+        stored func(c *LRUCache) int
+    }{
+        {"plain", nil, func(*LRUCache) int { return len(value) }},
+        {"compressed", []Option{WithCompression(CompressSnappy, 0)}, func(c *LRUCache) int {
+            stored, _ := c.compress(value)
+            return len(stored)
+        }},
+        {"encrypted", []Option{WithEncryption(newAESGCM(t))}, func(c *LRUCache) int {
+            return c.aead.NonceSize() + len(value) + c.aead.Overhead()
+        }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            probe := New(tt.opts...)
+            size := entrySize("k", "") + int64(tt.stored(probe))
+
+            fits := New(append(tt.opts, WithQuota(0, size))...)
+            if err := fits.Set("k", value, 0); err != nil {
+                t.Fatalf("Set within a quota of the stored size = %v", err)
+            }
+            short := New(append(tt.opts, WithQuota(0, size-1))...)
+            if err := short.Set("k", value, 0); err != ErrQuotaExceeded {
+                t.Fatalf("Set past a quota of the stored size = %v, want ErrQuotaExceeded", err)
+            }
+        })
+    }
+}
@@ -0,0 +1,91 @@
+package lrucache
+
+import (
+    "container/list"
+    "slices"
+)
+
+// SAdd adds members to the set at key, creating a set that never expires if
+// key is missing, and returns how many were not members yet
+func (c *LRUCache) SAdd(key string, members ...string) (int, error) {
+    res := c.mutate(Mutation{Op: "sadd", Key: key, Args: members})
+    return int(res.N), res.Err
+}
+
+// SRem removes members from the set at key, returning how many were members;
+// removing the last member deletes the key
+func (c *LRUCache) SRem(key string, members ...string) (int, error) {
+    res := c.mutate(Mutation{Op: "srem", Key: key, Args: members})
+    return int(res.N), res.Err
+}
+
+// SIsMember reports whether member is in the set at key; a missing key is an
+// empty set
+func (c *LRUCache) SIsMember(key, member string) (bool, error) {
+    members, err := c.members("sismember", key)
+    if err == ErrNotFound || err == ErrExpired {
+        return false, nil
+    }
+    _, found := slices.BinarySearch(members, member)
+    return found, err
+}
+
+// SMembers returns the members of the set at key in sorted order
+func (c *LRUCache) SMembers(key string) ([]string, error) {
+    return c.members("smembers", key)
+}
+
+// SCard returns the number of members of the set at key; a missing key is an
+// empty set
+func (c *LRUCache) SCard(key string) (int, error) {
+    members, err := c.members("scard", key)
+    if err == ErrNotFound || err == ErrExpired {
+        return 0, nil
+    }
+    return len(members), err
+}
+
+// members decodes the live set at key
+func (c *LRUCache) members(op, key string) ([]string, error) {
+    if c.closed() {
+        return nil, ErrClosed
+    }
+    defer c.lock(op, key)()
+
+    value, err := c.lookupValue(key, KindSet)
+    if err != nil {
+        return nil, err
+    }
+    return decodeList(value), nil
+}
+
+// applySet makes an sadd or srem change; the caller holds the mutex
+func (c *LRUCache) applySet(elem *list.Element, live bool, m Mutation) MutationResult {
+    prev, encoded, err := c.current(elem, live, KindSet)
+    if err != nil {
+        return MutationResult{Err: err}
+    }
+    var members []string
+    if prev != nil {
+        members = decodeList(encoded)
+    }
+    n := 0
+    for _, member := range m.Args {
+        i, found := slices.BinarySearch(members, member)
+        switch {
+        case m.Op == "sadd" && !found:
+            members = slices.Insert(members, i, member)
+            n++
+        case m.Op == "srem" && found:
+            members = slices.Delete(members, i, i+1)
+            n++
+        }
+    }
+    if n == 0 {
+        return MutationResult{OK: true}
+    }
+    if err := c.update(m, prev, KindSet, encodeList(members), len(members) == 0); err != nil {
+        return MutationResult{Err: err}
+    }
+    return MutationResult{N: int64(n), OK: true}
+}
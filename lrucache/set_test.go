@@ -0,0 +1,58 @@
+package lrucache
+
+import (
+    "slices"
+    "testing"
+)
+
+func TestSetMembers(t *testing.T) {
+    c := New()
+    tests := []struct {
+        name   string
+        change func() (int, error)
+        wantN  int
+        want   []string
+    }{
+        {"add", func() (int, error) { return c.SAdd("s", "b", "a", "b") }, 2, []string{"a", "b"}},
+        {"add again", func() (int, error) { return c.SAdd("s", "a", "c") }, 1, []string{"a", "b", "c"}},
+        {"remove", func() (int, error) { return c.SRem("s", "b", "z") }, 1, []string{"a", "c"}},
+        {"remove nothing", func() (int, error) { return c.SRem("s", "z") }, 0, []string{"a", "c"}},
+    }
+    for _, tt := range tests {
+        n, err := tt.change()
+        got, _ := c.SMembers("s")
+        if n != tt.wantN || err != nil || !slices.Equal(got, tt.want) {
+            t.Errorf("%s: %d, %v and %q; want %d and %q", tt.name, n, err, got, tt.wantN, tt.want)
+        }
+    }
+
+    c.SRem("s", "a", "c")
+    if c.Contains("s") {
+        t.Fatal("an empty set is still stored")
+    }
+}
+
+func TestSetQueries(t *testing.T) {
+    c := New()
+    c.SAdd("s", "a", "b")
+    c.Set("str", "v", 0)
+    tests := []struct {
+        key, member string
+        wantMember  bool
+        wantCard    int
+        wantErr     error
+    }{
+        {"s", "a", true, 2, nil},
+        {"s", "z", false, 2, nil},
+        {"missing", "a", false, 0, nil},
+        {"str", "a", false, 0, ErrWrongType},
+    }
+    for _, tt := range tests {
+        if got, err := c.SIsMember(tt.key, tt.member); got != tt.wantMember || err != tt.wantErr {
+            t.Errorf("SIsMember(%q, %q) = %v, %v", tt.key, tt.member, got, err)
+        }
+        if got, err := c.SCard(tt.key); got != tt.wantCard || err != tt.wantErr {
+            t.Errorf("SCard(%q) = %d, %v", tt.key, got, err)
+        }
+    }
+}
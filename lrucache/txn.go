@@ -0,0 +1,227 @@
+package lrucache
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "strconv"
+    "time"
+)
+
+// ErrConditionFailed aborts a transaction when a key is live, missing or holds
+// another value than an operation requires
+var ErrConditionFailed = errors.New("transaction condition failed")
+
+// TxnOp is one operation of a transaction; its conditions are checked against
+// the key as the earlier operations leave it
+type TxnOp struct {
+    Op         string // set, delete, incr, get or check, which only tests the conditions
+    Key        string
+    Value      string        // Written by set
+    Expiration time.Duration // Of a set, as for Set
+    Delta      int64         // Added by incr
+    Mode       StoreMode     // StoreIfAbsent or StoreIfPresent require the key to be missing or live
+    CAS        uint64        // Unless zero, the CAS value the key must hold
+    Equals     *string       // Unless nil, the value the key must hold
+}
+
+// TxnResult is what an operation of a transaction did
+type TxnResult struct {
+    Found bool   // Whether the key was live before the operation
+    CAS   uint64 // The new CAS value after a set or incr, or the one a get read
+    N     int64  // The new value after an incr
+    Value string // The value a get read
+}
+
+// TxnError reports which operation aborted a transaction
+type TxnError struct {
+    Index int // Of the operation, from zero
+    Err   error
+}
+
+func (e *TxnError) Error() string {
+    return fmt.Sprintf("transaction operation %d: %v", e.Index, e.Err)
+}
+
+func (e *TxnError) Unwrap() error {
+    return e.Err
+}
+
+// Txn applies ops in order as one change, like MULTI and EXEC: if any
+// condition fails or any operation would fail, none applies and the error is
+// a *TxnError. Other reads and writes see the cache before or after the whole
+// transaction.
+func (c *LRUCache) Txn(ops ...TxnOp) ([]TxnResult, error) {
+    m := Mutation{Op: "txn", Ops: make([]Mutation, len(ops))}
+    for i, op := range ops {
+        if c.maxValue > 0 && len(op.Value) > c.maxValue {
+            return nil, &TxnError{Index: i, Err: ErrTooLarge}
+        }
+        m.Ops[i] = Mutation{Op: op.Op, Key: op.Key, Value: op.Value, Delta: op.Delta, Mode: op.Mode, CAS: op.CAS, Equals: op.Equals}
+        if op.Op == "set" {
+            m.Ops[i].ExpiresAt = c.expiresAt(op.Expiration)
+        }
+    }
+    res := c.mutate(m)
+    if res.Err != nil {
+        return nil, res.Err
+    }
+    results := make([]TxnResult, len(res.Results))
+    for i, r := range res.Results {
+        results[i] = TxnResult{Found: r.OK, CAS: r.CAS, N: r.N, Value: r.Value}
+    }
+    return results, nil
+}
+
+// Watch returns check operations that, put first in a transaction, abort it
+// with ErrCASMismatch, ErrCASNotFound or ErrConditionFailed if any of keys was
+// written, deleted, expired or created since, like WATCH before MULTI. Reading
+// the keys after Watch and writing them with the transaction then needs no
+// lock in between.
+func (c *LRUCache) Watch(keys ...string) []TxnOp {
+    defer c.lock("watch", "")()
+
+    now := time.Now()
+    ops := make([]TxnOp, len(keys))
+    for i, key := range keys {
+        ops[i] = TxnOp{Op: "check", Key: key, Mode: StoreIfAbsent}
+        if elem, found := c.cache[key]; found && !c.gone(elem.Value.(*CacheItem), now) {
+            ops[i] = TxnOp{Op: "check", Key: key, CAS: elem.Value.(*CacheItem).cas}
+        }
+    }
+    return ops
+}
+
+// txnKey is a key as the operations of a transaction checked so far leave it
+type txnKey struct {
+    stored bool // Whether the key has an entry, live or not
+    live   bool
+    kind   Kind
+    value  string
+    cas    uint64 // Zero once the transaction wrote the key
+    size   int    // Of the value as stored, as checkQuota counts it
+    err    error  // Reading the value failed
+}
+
+// applyTxn makes a txn change; the caller holds the mutex. Every operation is
+// checked before any applies, so that the transaction applies whole or not
+// at all.
+func (c *LRUCache) applyTxn(m Mutation) MutationResult {
+    keys := make(map[string]*txnKey)
+    entries, bytes := c.list.Len(), c.stats.Bytes
+    results := make([]MutationResult, len(m.Ops))
+    for i, op := range m.Ops {
+        k, ok := keys[op.Key]
+        if !ok {
+            k = &txnKey{}
+            if elem, found := c.cache[op.Key]; found {
+                item := elem.Value.(*CacheItem)
+                k.stored, k.live, k.kind, k.cas, k.size = true, !c.gone(item, m.Time), item.kind, item.cas, len(item.value)
+                if k.live && item.kind == KindString {
+                    k.value, k.err = c.valueOf(item)
+                }
+            }
+            keys[op.Key] = k
+        }
+        fail := func(err error) MutationResult {
+            return MutationResult{Err: &TxnError{Index: i, Err: err}}
+        }
+
+        switch {
+        case (op.Mode == StoreIfAbsent && k.live) || (op.Mode == StoreIfPresent && !k.live):
+            return fail(ErrConditionFailed)
+        case op.CAS != 0 && !k.live:
+            return fail(ErrCASNotFound)
+        case op.CAS != 0 && k.cas != op.CAS:
+            return fail(ErrCASMismatch)
+        case op.Equals != nil && (!k.live || k.kind != KindString || k.value != *op.Equals):
+            if k.err != nil {
+                return fail(k.err)
+            }
+            return fail(ErrConditionFailed)
+        }
+        results[i].OK = k.live
+
+        // The value a set or incr leaves, with the quota checked as checkQuota does
+        var value string
+        switch op.Op {
+        case "set":
+            value = op.Value
+        case "incr":
+            var n int64
+            if k.live {
+                if k.kind != KindString {
+                    return fail(ErrWrongType)
+                }
+                if k.err != nil {
+                    return fail(k.err)
+                }
+                parsed, err := strconv.ParseInt(k.value, 10, 64)
+                if err != nil {
+                    return fail(errNotInteger)
+                }
+                n = parsed
+            }
+            if (op.Delta > 0 && n > math.MaxInt64-op.Delta) || (op.Delta < 0 && n < math.MinInt64-op.Delta) {
+                return fail(errOverflow)
+            }
+            results[i].N = n + op.Delta
+            value = strconv.FormatInt(results[i].N, 10)
+        case "delete":
+            if k.stored {
+                entries--
+                bytes -= entrySize(op.Key, "") + int64(k.size)
+            }
+            *k = txnKey{}
+            continue
+        case "get":
+            if k.live && k.kind != KindString {
+                return fail(ErrWrongType)
+            }
+            if k.err != nil {
+                return fail(k.err)
+            }
+            results[i].Value, results[i].CAS = k.value, k.cas
+            continue
+        case "check":
+            continue
+        default:
+            return fail(fmt.Errorf("unknown transaction operation %q", op.Op))
+        }
+        size := c.storedSize(value)
+        grown := entrySize(op.Key, "") + int64(size)
+        if k.stored {
+            grown = int64(size - k.size)
+        }
+        if (!k.stored && c.quotaEntries > 0 && entries >= c.quotaEntries) ||
+            (c.quotaBytes > 0 && grown > 0 && bytes+grown > c.quotaBytes) {
+            c.stats.QuotaRejections++
+            return fail(ErrQuotaExceeded)
+        }
+        if !k.stored {
+            entries++
+        }
+        bytes += grown
+        *k = txnKey{stored: true, live: true, kind: KindString, value: value, size: size}
+    }
+
+    for i, op := range m.Ops {
+        elem, found := c.cache[op.Key]
+        switch op.Op {
+        case "set":
+            results[i].CAS = c.set(op.Key, op.Value, KindString, 0, op.ExpiresAt, m.Time)
+        case "incr":
+            var flags uint32
+            var expiration time.Time
+            if found && !c.gone(elem.Value.(*CacheItem), m.Time) {
+                flags, expiration = elem.Value.(*CacheItem).flags, elem.Value.(*CacheItem).expiration
+            }
+            results[i].CAS = c.set(op.Key, strconv.FormatInt(results[i].N, 10), KindString, flags, expiration, m.Time)
+        case "delete":
+            if found {
+                c.remove(elem, EventDelete)
+            }
+        }
+    }
+    return MutationResult{OK: true, N: int64(len(m.Ops)), Results: results}
+}
@@ -0,0 +1,138 @@
+package lrucache
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+func TestTxnConditions(t *testing.T) {
+    one, two := "1", "2"
+    tests := []struct {
+        name    string
+        op      TxnOp
+        fails   bool
+        wantErr error // Unless nil, what the failure wraps
+    }{
+        {"absent on a live key", TxnOp{Op: "check", Key: "a", Mode: StoreIfAbsent}, true, ErrConditionFailed},
+        {"present on a missing key", TxnOp{Op: "check", Key: "missing", Mode: StoreIfPresent}, true, ErrConditionFailed},
+        {"CAS of a missing key", TxnOp{Op: "check", Key: "missing", CAS: 1}, true, ErrCASNotFound},
+        {"wrong CAS", TxnOp{Op: "check", Key: "a", CAS: 1000}, true, ErrCASMismatch},
+        {"other value", TxnOp{Op: "check", Key: "a", Equals: &two}, true, ErrConditionFailed},
+        {"value", TxnOp{Op: "check", Key: "a", Equals: &one}, false, nil},
+        {"unknown operation", TxnOp{Op: "rename", Key: "a"}, true, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c := New()
+            c.Set("a", "1", 0)
+            _, err := c.Txn(TxnOp{Op: "set", Key: "b", Value: "new"}, tt.op)
+            if !tt.fails {
+                if err != nil {
+                    t.Fatalf("Txn = %v", err)
+                }
+                return
+            }
+            var txnErr *TxnError
+            if !errors.As(err, &txnErr) || txnErr.Index != 1 {
+                t.Fatalf("Txn = %v, want a TxnError of operation 1", err)
+            }
+            if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+                t.Fatalf("Txn = %v, want %v", err, tt.wantErr)
+            }
+            if c.Contains("b") {
+                t.Fatal("an aborted transaction applied its first operation")
+            }
+        })
+    }
+}
+
+func TestTxnResults(t *testing.T) {
+    c := New()
+    c.Set("n", "41", 0)
+    results, err := c.Txn(
+        TxnOp{Op: "incr", Key: "n", Delta: 1},
+        TxnOp{Op: "get", Key: "n"},
+        TxnOp{Op: "set", Key: "s", Value: "v", Mode: StoreIfAbsent},
+        TxnOp{Op: "delete", Key: "n"},
+        TxnOp{Op: "get", Key: "n"},
+        TxnOp{Op: "incr", Key: "n", Delta: -1},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []TxnResult{
+        {Found: true, N: 42},
+        {Found: true, Value: "42"},
+        {},
+        {Found: true},
+        {},
+        {N: -1},
+    }
+    for i, r := range results {
+        r.CAS = 0
+        if r != want[i] {
+            t.Errorf("result %d = %+v, want %+v", i, r, want[i])
+        }
+    }
+    if got, _ := c.Get("n"); got != "-1" {
+        t.Fatalf("n = %q after the transaction, want -1", got)
+    }
+}
+
+func TestTxnFailures(t *testing.T) {
+    tests := []struct {
+        name    string
+        opts    []Option
+        op      TxnOp
+        wantErr error
+    }{
+        {"incr of a non-integer", nil, TxnOp{Op: "incr", Key: "s", Delta: 1}, errNotInteger},
+        {"incr of a list", nil, TxnOp{Op: "incr", Key: "l", Delta: 1}, ErrWrongType},
+        {"get of a list", nil, TxnOp{Op: "get", Key: "l"}, ErrWrongType},
+        {"too large", []Option{WithMaxValueBytes(4)}, TxnOp{Op: "set", Key: "k", Value: "12345"}, ErrTooLarge},
+        {"past the quota", []Option{WithQuota(2, 0)}, TxnOp{Op: "set", Key: "k", Value: "v"}, ErrQuotaExceeded},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c := New(tt.opts...)
+            c.Set("s", "abc", 0)
+            c.RPush("l", "a")
+            if _, err := c.Txn(tt.op); !errors.Is(err, tt.wantErr) {
+                t.Fatalf("Txn = %v, want %v", err, tt.wantErr)
+            }
+        })
+    }
+}
+
+func TestWatch(t *testing.T) {
+    tests := []struct {
+        name    string
+        change  func(c *LRUCache)
+        wantErr error
+    }{
+        {"unchanged", func(*LRUCache) {}, nil},
+        {"written", func(c *LRUCache) { c.Set("a", "2", 0) }, ErrCASMismatch},
+        {"touched", func(c *LRUCache) { c.Touch("a", time.Hour) }, ErrCASMismatch},
+        {"deleted", func(c *LRUCache) { c.Delete("a") }, ErrCASNotFound},
+        {"created", func(c *LRUCache) { c.Set("b", "1", 0) }, ErrConditionFailed},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c := New()
+            c.Set("a", "1", 0)
+            ops := c.Watch("a", "b")
+            tt.change(c)
+            _, err := c.Txn(append(ops, TxnOp{Op: "set", Key: "a", Value: "txn"})...)
+            if tt.wantErr == nil {
+                if got, _ := c.Get("a"); err != nil || got != "txn" {
+                    t.Fatalf("Txn = %v leaving %q", err, got)
+                }
+                return
+            }
+            if !errors.Is(err, tt.wantErr) {
+                t.Fatalf("Txn = %v, want %v", err, tt.wantErr)
+            }
+        })
+    }
+}
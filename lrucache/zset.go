@@ -0,0 +1,136 @@
+package lrucache
+
+import (
+    "cmp"
+    "container/list"
+    "encoding/json"
+    "errors"
+    "math"
+    "slices"
+)
+
+// ZMember is a member of a sorted set with its score
+type ZMember struct {
+    Member string  `json:"member"`
+    Score  float64 `json:"score"`
+}
+
+// ErrInvalidScore is returned for a score, given or computed, that is not a
+// finite number
+var ErrInvalidScore = errors.New("score is not a finite number")
+
+// ZAdd adds members to the sorted set at key, or updates their scores,
+// creating a sorted set that never expires if key is missing; it returns how
+// many members are new
+func (c *LRUCache) ZAdd(key string, members ...ZMember) (int, error) {
+    scores := make(map[string]float64, len(members))
+    for _, m := range members {
+        scores[m.Member] = m.Score
+    }
+    res := c.mutate(Mutation{Op: "zadd", Key: key, Scores: scores})
+    return int(res.N), res.Err
+}
+
+// ZIncrBy adds delta to the score of member in the sorted set at key, adding
+// the member with score delta if it is missing, and returns the new score
+func (c *LRUCache) ZIncrBy(key, member string, delta float64) (float64, error) {
+    res := c.mutate(Mutation{Op: "zincrby", Key: key, Scores: map[string]float64{member: delta}})
+    return res.Score, res.Err
+}
+
+// ZRange returns the members of the sorted set at key ranked start to stop,
+// inclusive, from the lowest score; negative ranks count back from the
+// highest, so 0, -1 is the whole set. Equal scores are ordered by member.
+func (c *LRUCache) ZRange(key string, start, stop int) ([]ZMember, error) {
+    members, err := c.sortedSet("zrange", key)
+    if err != nil {
+        return nil, err
+    }
+    from, to := bounds(start, stop, len(members))
+    return members[from:to], nil
+}
+
+// ZRank returns the rank of member in the sorted set at key, counting from
+// zero at the lowest score, or fails with ErrNotFound if it is not a member
+func (c *LRUCache) ZRank(key, member string) (int, error) {
+    members, err := c.sortedSet("zrank", key)
+    if err != nil {
+        return 0, err
+    }
+    for i, m := range members {
+        if m.Member == member {
+            return i, nil
+        }
+    }
+    return 0, ErrNotFound
+}
+
+// sortedSet decodes the live sorted set at key
+func (c *LRUCache) sortedSet(op, key string) ([]ZMember, error) {
+    if c.closed() {
+        return nil, ErrClosed
+    }
+    defer c.lock(op, key)()
+
+    value, err := c.lookupValue(key, KindSortedSet)
+    if err != nil {
+        return nil, err
+    }
+    return decodeSortedSet(value), nil
+}
+
+// applySortedSet makes a zadd or zincrby change; the caller holds the mutex
+func (c *LRUCache) applySortedSet(elem *list.Element, live bool, m Mutation) MutationResult {
+    prev, encoded, err := c.current(elem, live, KindSortedSet)
+    if err != nil {
+        return MutationResult{Err: err}
+    }
+    scores := make(map[string]float64)
+    if prev != nil {
+        for _, member := range decodeSortedSet(encoded) {
+            scores[member.Member] = member.Score
+        }
+    }
+    n := 0
+    var score float64
+    for member, s := range m.Scores {
+        old, found := scores[member]
+        if !found {
+            n++
+        }
+        if m.Op == "zincrby" {
+            s += old
+        }
+        if math.IsNaN(s) || math.IsInf(s, 0) {
+            return MutationResult{Err: ErrInvalidScore}
+        }
+        scores[member], score = s, s
+    }
+    members := make([]ZMember, 0, len(scores))
+    for member, s := range scores {
+        members = append(members, ZMember{member, s})
+    }
+    slices.SortFunc(members, compareZMembers)
+    if err := c.update(m, prev, KindSortedSet, encodeSortedSet(members), len(members) == 0); err != nil {
+        return MutationResult{Err: err}
+    }
+    return MutationResult{N: int64(n), Score: score, OK: true}
+}
+
+// compareZMembers orders members by score, then by member
+func compareZMembers(a, b ZMember) int {
+    return cmp.Or(cmp.Compare(a.Score, b.Score), cmp.Compare(a.Member, b.Member))
+}
+
+// encodeSortedSet encodes a sorted set as a JSON array in rank order
+func encodeSortedSet(members []ZMember) string {
+    data, _ := json.Marshal(members)
+    return string(data)
+}
+
+// decodeSortedSet decodes a sorted set, treating a malformed encoding as empty
+func decodeSortedSet(value string) []ZMember {
+    var members []ZMember
+    json.Unmarshal([]byte(value), &members)
+    return members
+}
@@ -0,0 +1,78 @@
+package lrucache
+
+import (
+    "math"
+    "slices"
+    "testing"
+)
+
+func TestZRange(t *testing.T) {
+    c := New()
+    if n, err := c.ZAdd("z", ZMember{"b", 2}, ZMember{"a", 2}, ZMember{"c", 1}); n != 3 || err != nil {
+        t.Fatalf("ZAdd = %d, %v; want 3", n, err)
+    }
+    tests := []struct {
+        start, stop int
+        want        []ZMember
+    }{
+        {0, -1, []ZMember{{"c", 1}, {"a", 2}, {"b", 2}}}, // Equal scores by member
+        {0, 0, []ZMember{{"c", 1}}},
+        {-2, -1, []ZMember{{"a", 2}, {"b", 2}}},
+        {2, 1, []ZMember{}},
+    }
+    for _, tt := range tests {
+        if got, err := c.ZRange("z", tt.start, tt.stop); !slices.Equal(got, tt.want) || err != nil {
+            t.Errorf("ZRange(%d, %d) = %v, %v; want %v", tt.start, tt.stop, got, err, tt.want)
+        }
+    }
+}
+
+func TestZRankAndIncrBy(t *testing.T) {
+    c := New()
+    c.ZAdd("z", ZMember{"a", 1}, ZMember{"b", 2})
+    steps := []struct {
+        name      string
+        member    string
+        delta     float64
+        wantScore float64
+        wantRanks []string
+    }{
+        {"raise past another", "a", 2, 3, []string{"b", "a"}},
+        {"add a member", "c", -1, -1, []string{"c", "b", "a"}},
+        {"lower", "a", -2.5, 0.5, []string{"c", "a", "b"}},
+    }
+    for _, s := range steps {
+        if score, err := c.ZIncrBy("z", s.member, s.delta); score != s.wantScore || err != nil {
+            t.Errorf("%s: ZIncrBy = %v, %v; want %v", s.name, score, err, s.wantScore)
+        }
+        for rank, member := range s.wantRanks {
+            if got, err := c.ZRank("z", member); got != rank || err != nil {
+                t.Errorf("%s: ZRank(%q) = %d, %v; want %d", s.name, member, got, err, rank)
+            }
+        }
+    }
+    if _, err := c.ZRank("z", "missing"); err != ErrNotFound {
+        t.Errorf("ZRank of a non-member = %v, want ErrNotFound", err)
+    }
+}
+
+func TestInvalidScores(t *testing.T) {
+    c := New()
+    c.ZAdd("z", ZMember{"big", math.MaxFloat64})
+    tests := []struct {
+        name   string
+        change func() error
+    }{
+        {"NaN", func() error { _, err := c.ZAdd("z", ZMember{"a", math.NaN()}); return err }},
+        {"infinite", func() error { _, err := c.ZAdd("z", ZMember{"a", math.Inf(-1)}); return err }},
+        {"overflowing increment", func() error { _, err := c.ZIncrBy("z", "big", math.MaxFloat64); return err }},
+    }
+    for _, tt := range tests {
+        if err := tt.change(); err != ErrInvalidScore {
+            t.Errorf("%s: %v, want ErrInvalidScore", tt.name, err)
+        }
+    }
+    if got, _ := c.ZRange("z", 0, -1); len(got) != 1 || got[0].Score != math.MaxFloat64 {
+        t.Fatalf("an invalid score changed the set to %v", got)
+    }
+}